@@ -0,0 +1,119 @@
+package alerting
+
+import (
+	"icapeg/logging"
+	"sync"
+	"time"
+)
+
+// Detection represents a blocked ICAP transaction which the alerters notify the security team about
+type Detection struct {
+	Time          time.Time
+	XICAPMetadata string
+	ServiceName   string
+	Tenant        string
+	Vendor        string
+	Method        string
+	ClientIP      string
+	Username      string
+	RequestedURL  string
+	FileName      string
+	FileHash      string
+	FileDigests   map[string]string // the digests which were configured in [app.digests], by algorithm
+	FileSize      string
+	Threat        string
+	Quarantine    string // the path of the quarantined copy of the file, empty if it isn't quarantined
+	Delivered     bool   // the file was delivered to the user before the verdict (deferred scanning)
+}
+
+// Alerter is the interface which every alert channel (email, chat, etc) implements
+type Alerter interface {
+	Alert(detection *Detection)
+}
+
+var (
+	alertersMu sync.RWMutex
+	alerters   []Alerter
+)
+
+// InitAlerting reads the alerting sections of config.toml file and registers the enabled alerters
+func InitAlerting() {
+	logging.Logger.Info("loading the alerting configuration")
+	if alerter := initEmailAlerter(); alerter != nil {
+		Register(alerter)
+	}
+	for _, channel := range initChatAlerters() {
+		Register(channel)
+	}
+	if hook := initCommandHook(); hook != nil {
+		Register(hook)
+	}
+	if trapper := initSNMPTrapper(); trapper != nil {
+		Register(trapper)
+	}
+	alertersMu.RLock()
+	defer alertersMu.RUnlock()
+	if len(alerters) > 0 {
+		subscribeToEvents()
+	}
+}
+
+// Register adds an alerter to the list of alerters which get notified on detections
+func Register(alerter Alerter) {
+	alertersMu.Lock()
+	defer alertersMu.Unlock()
+	alerters = append(alerters, alerter)
+}
+
+// Notify sends the detection to all registered alerters
+func Notify(detection *Detection) {
+	if detection.Time.IsZero() {
+		detection.Time = time.Now()
+	}
+	alertersMu.RLock()
+	defer alertersMu.RUnlock()
+	for _, alerter := range alerters {
+		alerter.Alert(detection)
+	}
+}
+
+// thresholds is used for counting the detections of every service in a time window
+// so the alerter fires only when a service reached its threshold
+type thresholds struct {
+	mu       sync.Mutex
+	limits   map[string]int
+	window   time.Duration
+	counters map[string][]time.Time
+}
+
+func newThresholds(limits map[string]int, window time.Duration) *thresholds {
+	return &thresholds{
+		limits:   limits,
+		window:   window,
+		counters: make(map[string][]time.Time),
+	}
+}
+
+// reached records a detection of the service and returns true if the number of detections
+// in the window reached the threshold of the service, services without a threshold alert on every detection
+func (t *thresholds) reached(serviceName string, now time.Time) bool {
+	limit, exists := t.limits[serviceName]
+	if !exists || limit <= 1 {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var recent []time.Time
+	for _, detectionTime := range t.counters[serviceName] {
+		if t.window == 0 || now.Sub(detectionTime) < t.window {
+			recent = append(recent, detectionTime)
+		}
+	}
+	recent = append(recent, now)
+	if len(recent) >= limit {
+		delete(t.counters, serviceName)
+		return true
+	}
+	t.counters[serviceName] = recent
+	return false
+}
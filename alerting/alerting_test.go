@@ -0,0 +1,178 @@
+package alerting
+
+import (
+	"icapeg/logging"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	// the alerters log in their own goroutines, the logger is set once before them
+	logging.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+func TestThresholds(t *testing.T) {
+	th := newThresholds(map[string]int{"clamav": 3}, time.Minute)
+	now := time.Now()
+	if !th.reached("echo", now) {
+		t.Fatalf("service without a threshold should alert on every detection")
+	}
+	if th.reached("clamav", now) || th.reached("clamav", now.Add(time.Second)) {
+		t.Fatalf("threshold reached before 3 detections")
+	}
+	if !th.reached("clamav", now.Add(2*time.Second)) {
+		t.Fatalf("threshold wasn't reached after 3 detections")
+	}
+	if th.reached("clamav", now.Add(3*time.Second)) {
+		t.Fatalf("counter wasn't reset after reaching the threshold")
+	}
+	if th.reached("clamav", now.Add(2*time.Minute)) {
+		t.Fatalf("old detections outside the window were counted")
+	}
+}
+
+func TestEmailAlerterRateLimit(t *testing.T) {
+	var mu sync.Mutex
+	var sent []string
+	e := &EmailAlerter{
+		from:             "icapeg@localhost",
+		to:               []string{"soc@localhost"},
+		subjectTmpl:      template.Must(template.New("subject").Parse(defaultEmailSubject)),
+		bodyTmpl:         template.Must(template.New("body").Parse(defaultEmailBody)),
+		digestSubject:    template.Must(template.New("digest").Parse(digestEmailSubject)),
+		digestInterval:   time.Hour,
+		maxEmailsPerHour: 1,
+		thresholds:       newThresholds(map[string]int{}, 0),
+		sendMail: func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			sent = append(sent, string(msg))
+			return nil
+		},
+	}
+	now := time.Now()
+	e.Alert(&Detection{Time: now, ServiceName: "clamav", FileName: "first.exe\r\nBcc: attacker@example.com"})
+	e.Alert(&Detection{Time: now, ServiceName: "clamav", FileName: "second.exe"})
+	e.sends.Wait()
+
+	mu.Lock()
+	if len(sent) != 1 || !strings.Contains(sent[0], "Subject: [ICAPeg] clamav blocked first.exe  Bcc:") {
+		t.Fatalf("expected one immediate email without line breaks in its subject, got %v", sent)
+	}
+	mu.Unlock()
+	e.mu.Lock()
+	pending := len(e.pending)
+	e.mu.Unlock()
+	if pending != 1 {
+		t.Fatalf("the detection above the rate limit should wait for the digest")
+	}
+
+	e.flush()
+	mu.Lock()
+	if len(sent) != 2 || !strings.Contains(sent[1], "digest of 1 blocked files") ||
+		!strings.Contains(sent[1], "second.exe") {
+		t.Fatalf("expected a digest email, got %v", sent)
+	}
+	mu.Unlock()
+
+	for i := 0; i < maxPendingDetections+10; i++ {
+		e.Alert(&Detection{Time: now, ServiceName: "clamav"})
+	}
+	e.mu.Lock()
+	pending, dropped := len(e.pending), e.dropped
+	e.mu.Unlock()
+	if pending != maxPendingDetections || dropped != 10 {
+		t.Fatalf("the digest should be capped, got %d pending and %d dropped detections", pending, dropped)
+	}
+	e.digestInterval = 0
+	e.Alert(&Detection{Time: now, ServiceName: "clamav"})
+	if e.dropped != 11 {
+		t.Fatalf("the detections above the rate limit should be dropped without a digest loop")
+	}
+}
+
+func TestCommandHook(t *testing.T) {
+	out := t.TempDir() + "/hook.out"
+	hook, err := NewCommandHook("sh", []string{"-c", `echo "$1 $2" > ` + out, "sh", "{{.FileHash}}", "{{.RequestedURL}}"},
+		[]string{"clamav"}, time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, err := hook.render(&Detection{FileHash: "abc", RequestedURL: "http://example.com/a b; rm -rf /"})
+	if err != nil || len(args) != 5 || args[4] != "http://example.com/a b; rm -rf /" {
+		t.Fatalf("unexpected arguments %q %v", args, err)
+	}
+	if err = hook.run(args); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := os.ReadFile(out); string(raw) != "abc http://example.com/a b; rm -rf /\n" {
+		t.Fatalf("the values of the detection should be passed as arguments, got %q", raw)
+	}
+
+	slow, _ := NewCommandHook("sleep", []string{"5"}, []string{"*"}, 50*time.Millisecond, 1)
+	if err = slow.run([]string{"5"}); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected the command to time out, got %v", err)
+	}
+	if _, err = NewCommandHook("true", []string{"{{.Unknown"}, nil, 0, 1); err == nil {
+		t.Fatalf("expected an error for a malformed template")
+	}
+}
+
+func TestSNMPTrapper(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	addr := listener.LocalAddr().(*net.UDPAddr)
+	s := newSNMPTrapper(gosnmp.GoSNMP{Target: "127.0.0.1", Port: uint16(addr.Port), Transport: "udp",
+		Version: gosnmp.Version2c, Community: "noc", Timeout: time.Second}, "1.3.6.1.4.1.99999.1", time.Minute, 2,
+		time.Minute)
+
+	now := time.Now()
+	s.VendorDown(&VendorDownEvent{Time: now, ServiceName: "clamav", Vendor: "clamav", Error: "connection refused"})
+	listener.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 4096)
+	n, _, err := listener.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := gosnmp.Default.SnmpDecodePacket(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.Community != "noc" || packet.PDUType != gosnmp.SNMPv2Trap || len(packet.Variables) != 6 ||
+		packet.Variables[1].Value != ".1.3.6.1.4.1.99999.1.0.1" {
+		t.Fatalf("unexpected vendor down trap %+v", packet)
+	}
+
+	s = newSNMPTrapper(s.params, "1.3.6.1.4.1.99999.1", time.Minute, 2, time.Minute)
+	var mu sync.Mutex
+	var sent []string
+	s.send = func(trap gosnmp.SnmpTrap) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, trap.Variables[1].Value.(string))
+		return nil
+	}
+	s.VendorDown(&VendorDownEvent{Time: now, ServiceName: "clamav"})
+	s.VendorDown(&VendorDownEvent{Time: now.Add(time.Second), ServiceName: "clamav"})
+	s.Alert(&Detection{Time: now, ServiceName: "clamav"})
+	s.Alert(&Detection{Time: now.Add(time.Second), ServiceName: "echo"})
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) != 2 || sent[0] == sent[1] {
+		t.Fatalf("expected one vendor down trap and one detection spike trap in the interval, got %v", sent)
+	}
+}
@@ -0,0 +1,189 @@
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"net/http"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// the chat channel types
+const (
+	ChatTypeSlack = "slack"
+	ChatTypeTeams = "teams"
+)
+
+const (
+	defaultChatDetectionTemplate = ":no_entry: *{{.ServiceName}}* {{if .Delivered}}found the delivered file{{else}}blocked{{end}} `{{.FileName}}` " +
+		"({{.Threat}}) requested from {{.RequestedURL}} by {{.ClientIP}}, SHA-256: {{.FileHash}}"
+	defaultChatVendorDownTemplate = ":warning: the vendor *{{.Vendor}}* of *{{.ServiceName}}* service " +
+		"is unreachable: {{.Error}}"
+)
+
+// VendorDownEvent represents a failure of a service to reach its vendor
+type VendorDownEvent struct {
+	Time          time.Time
+	XICAPMetadata string
+	ServiceName   string
+	Tenant        string
+	Vendor        string
+	Error         string
+}
+
+// VendorDownAlerter is implemented by the alerters which notify about vendor failures as well as detections
+type VendorDownAlerter interface {
+	VendorDown(event *VendorDownEvent)
+}
+
+// NotifyVendorDown sends the vendor failure to all registered alerters which support it
+func NotifyVendorDown(event *VendorDownEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	alertersMu.RLock()
+	defer alertersMu.RUnlock()
+	for _, alerter := range alerters {
+		if vendorDownAlerter, ok := alerter.(VendorDownAlerter); ok {
+			vendorDownAlerter.VendorDown(event)
+		}
+	}
+}
+
+// chatChannel is a Slack or Microsoft Teams incoming webhook
+type chatChannel struct {
+	name               string
+	chatType           string
+	webhookURL         string
+	services           []string
+	detectionTmpl      *template.Template
+	vendorDownTmpl     *template.Template
+	vendorDownInterval time.Duration
+	mu                 sync.Mutex
+	lastVendorDown     map[string]time.Time
+	client             *http.Client
+}
+
+// initChatAlerters reads [app.chat_alerts.channels] sub sections, every sub section is a channel
+func initChatAlerters() []*chatChannel {
+	if !readValues.IsSecExists("app.chat_alerts") || !readValues.ReadValuesBool("app.chat_alerts.enabled") {
+		return nil
+	}
+	logging.Logger.Debug("loading chat alerts configuration")
+	vendorDownInterval := readValues.ReadValuesDuration("app.chat_alerts.vendor_down_interval") * time.Second
+	var channels []*chatChannel
+	for _, name := range readValues.ReadSubSections("app.chat_alerts.channels") {
+		section := "app.chat_alerts.channels." + name
+		c := &chatChannel{
+			name:               name,
+			chatType:           readValues.ReadValuesString(section + ".type"),
+			webhookURL:         readValues.ReadValuesString(section + ".webhook_url"),
+			services:           readValues.ReadValuesSlice(section + ".services"),
+			vendorDownInterval: vendorDownInterval,
+			lastVendorDown:     make(map[string]time.Time),
+			client:             &http.Client{Timeout: 10 * time.Second},
+		}
+		if c.chatType != ChatTypeSlack && c.chatType != ChatTypeTeams {
+			logging.Logger.Error("chat alerts channel " + name + " has an unknown type: " + c.chatType)
+			continue
+		}
+		c.detectionTmpl = parseChatTemplate(section+".detection_template", defaultChatDetectionTemplate)
+		c.vendorDownTmpl = parseChatTemplate(section+".vendor_down_template", defaultChatVendorDownTemplate)
+		channels = append(channels, c)
+	}
+	return channels
+}
+
+// parseChatTemplate parses the template of the key if it exists, otherwise it parses the default template
+func parseChatTemplate(key, defaultTemplate string) *template.Template {
+	text := defaultTemplate
+	if readValues.IsSecExists(key) {
+		text = readValues.ReadValuesString(key)
+	}
+	tmpl, err := template.New(key).Parse(text)
+	if err != nil {
+		logging.Logger.Error(key + " is not valid, the default template is used: " + err.Error())
+		tmpl = template.Must(template.New(key).Parse(defaultTemplate))
+	}
+	return tmpl
+}
+
+// routes checks if the channel is interested in the service
+func (c *chatChannel) routes(serviceName string) bool {
+	for _, service := range c.services {
+		if service == "*" || service == serviceName {
+			return true
+		}
+	}
+	return false
+}
+
+// Alert posts the detection message to the channel
+func (c *chatChannel) Alert(detection *Detection) {
+	if !c.routes(detection.ServiceName) {
+		return
+	}
+	go c.post(c.detectionTmpl, detection)
+}
+
+// VendorDown posts the vendor failure to the channel, once every vendor_down_interval per service
+func (c *chatChannel) VendorDown(event *VendorDownEvent) {
+	if !c.routes(event.ServiceName) {
+		return
+	}
+	c.mu.Lock()
+	if last, exists := c.lastVendorDown[event.ServiceName]; exists && event.Time.Sub(last) < c.vendorDownInterval {
+		c.mu.Unlock()
+		return
+	}
+	c.lastVendorDown[event.ServiceName] = event.Time
+	c.mu.Unlock()
+	go c.post(c.vendorDownTmpl, event)
+}
+
+func (c *chatChannel) post(tmpl *template.Template, data interface{}) {
+	text := &bytes.Buffer{}
+	if err := tmpl.Execute(text, data); err != nil {
+		logging.Logger.Error("couldn't render the message of " + c.name + " chat channel: " + err.Error())
+		return
+	}
+	payload, err := json.Marshal(c.payload(text.String()))
+	if err != nil {
+		logging.Logger.Error("couldn't prepare the message of " + c.name + " chat channel: " + err.Error())
+		return
+	}
+	if err = c.send(payload); err != nil {
+		logging.Logger.Error("couldn't send the message to " + c.name + " chat channel: " + err.Error())
+	}
+}
+
+// payload returns the webhook body in the format of the chat type
+func (c *chatChannel) payload(text string) interface{} {
+	if c.chatType == ChatTypeTeams {
+		return map[string]interface{}{
+			"@type":    "MessageCard",
+			"@context": "http://schema.org/extensions",
+			"summary":  "ICAPeg alert",
+			"title":    "ICAPeg alert",
+			"text":     text,
+		}
+	}
+	return map[string]interface{}{"text": text}
+}
+
+func (c *chatChannel) send(payload []byte) error {
+	resp, err := c.client.Post(c.webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("webhook returned status code " + strconv.Itoa(resp.StatusCode))
+	}
+	return nil
+}
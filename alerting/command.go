@@ -0,0 +1,126 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// CommandHook runs a command when a service blocks a file, ex: to isolate the client or open a ticket.
+// The arguments are text/template templates of the detection and the command is run without a shell,
+// so the values of the detection can't inject other commands
+type CommandHook struct {
+	command  string
+	args     []*template.Template
+	services []string
+	timeout  time.Duration
+	slots    chan struct{}
+}
+
+// initCommandHook reads [app.command_hook] section and returns nil if it doesn't exist or it's disabled
+func initCommandHook() *CommandHook {
+	if !readValues.IsSecExists("app.command_hook") || !readValues.ReadValuesBool("app.command_hook.enabled") {
+		return nil
+	}
+	logging.Logger.Debug("loading command hook configuration")
+	hook, err := NewCommandHook(readValues.ReadValuesString("app.command_hook.command"),
+		readValues.ReadValuesSlice("app.command_hook.args"), readValues.ReadValuesSlice("app.command_hook.services"),
+		readValues.ReadValuesDuration("app.command_hook.timeout")*time.Second,
+		readValues.ReadValuesInt("app.command_hook.max_concurrent"))
+	if err != nil {
+		logging.Logger.Error("the command hook is disabled: " + err.Error())
+		return nil
+	}
+	return hook
+}
+
+// NewCommandHook creates the hook of the command, the hook runs up to maxConcurrent commands at once
+func NewCommandHook(command string, args, services []string, timeout time.Duration, maxConcurrent int) (*CommandHook,
+	error) {
+	if command == "" {
+		return nil, errors.New("command is required")
+	}
+	if maxConcurrent <= 0 {
+		return nil, errors.New("max_concurrent must be positive")
+	}
+	hook := &CommandHook{command: command, services: services, timeout: timeout,
+		slots: make(chan struct{}, maxConcurrent)}
+	for _, arg := range args {
+		tmpl, err := template.New("arg").Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return nil, errors.New("the argument " + arg + " isn't a valid template: " + err.Error())
+		}
+		hook.args = append(hook.args, tmpl)
+	}
+	return hook, nil
+}
+
+func (h *CommandHook) routes(serviceName string) bool {
+	for _, service := range h.services {
+		if service == "*" || service == serviceName {
+			return true
+		}
+	}
+	return false
+}
+
+// Alert runs the command in the background, the detection is dropped if max_concurrent commands are running
+func (h *CommandHook) Alert(detection *Detection) {
+	if !h.routes(detection.ServiceName) {
+		return
+	}
+	args, err := h.render(detection)
+	if err != nil {
+		logging.Logger.Error("couldn't render the arguments of the command hook: " + err.Error())
+		return
+	}
+	select {
+	case h.slots <- struct{}{}:
+	default:
+		logging.Logger.Warn("the command hook is busy, it isn't run for " + detection.FileHash + " blocked by " +
+			detection.ServiceName)
+		return
+	}
+	go func() {
+		defer func() { <-h.slots }()
+		h.run(args)
+	}()
+}
+
+func (h *CommandHook) render(detection *Detection) ([]string, error) {
+	args := make([]string, 0, len(h.args))
+	for _, tmpl := range h.args {
+		arg := &bytes.Buffer{}
+		if err := tmpl.Execute(arg, detection); err != nil {
+			return nil, err
+		}
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+func (h *CommandHook) run(args []string) error {
+	ctx := context.Background()
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	output, err := exec.CommandContext(ctx, h.command, args...).CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = errors.New("the command timed out after " + h.timeout.String())
+	}
+	if err != nil {
+		logging.Logger.Error("the command hook failed: " + err.Error() + ", output: " +
+			strings.TrimSpace(string(output)))
+		return err
+	}
+	logging.Logger.Debug("the command hook was run: " + h.command + " " + strings.Join(args, " "))
+	return nil
+}
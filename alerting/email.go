@@ -0,0 +1,236 @@
+package alerting
+
+import (
+	"bytes"
+	"fmt"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultEmailSubject = "[ICAPeg] {{.ServiceName}} blocked {{.FileName}}"
+	defaultEmailBody    = "ICAPeg blocked a file.\r\n\r\n" +
+		"Time: {{.Time.Format \"2006-01-02T15:04:05Z07:00\"}}\r\n" +
+		"Service: {{.ServiceName}} ({{.Vendor}})\r\n" +
+		"Method: {{.Method}}\r\n" +
+		"Client IP: {{.ClientIP}}\r\n" +
+		"URL: {{.RequestedURL}}\r\n" +
+		"File name: {{.FileName}}\r\n" +
+		"File size: {{.FileSize}}\r\n" +
+		"SHA-256: {{.FileHash}}\r\n" +
+		"Threat: {{.Threat}}\r\n" +
+		"{{if .Delivered}}The file was delivered before the verdict, its later downloads are blocked.\r\n{{end}}" +
+		"X-ICAP-Metadata: {{.XICAPMetadata}}\r\n"
+	digestEmailSubject = "[ICAPeg] digest of {{len .}} blocked files"
+)
+
+// the detections which wait for the next digest, the next ones are dropped until it's sent
+const maxPendingDetections = 1000
+
+// EmailAlerter sends emails through an SMTP server when a service blocks a file
+type EmailAlerter struct {
+	smtpAddr         string
+	auth             smtp.Auth
+	from             string
+	to               []string
+	subjectTmpl      *template.Template
+	bodyTmpl         *template.Template
+	digestSubject    *template.Template
+	digestMode       bool
+	digestInterval   time.Duration
+	maxEmailsPerHour int
+	thresholds       *thresholds
+	mu               sync.Mutex
+	sentTimes        []time.Time
+	pending          []*Detection
+	dropped          int
+	sends            sync.WaitGroup // the emails which are being sent in the background
+	sendMail         func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// initEmailAlerter reads [app.email_alerts] section and returns nil if it doesn't exist or it's disabled
+func initEmailAlerter() *EmailAlerter {
+	if !readValues.IsSecExists("app.email_alerts") || !readValues.ReadValuesBool("app.email_alerts.enabled") {
+		return nil
+	}
+	logging.Logger.Debug("loading email alerts configuration")
+	e := &EmailAlerter{
+		smtpAddr: fmt.Sprintf("%s:%d", readValues.ReadValuesString("app.email_alerts.smtp_host"),
+			readValues.ReadValuesInt("app.email_alerts.smtp_port")),
+		from:             readValues.ReadValuesString("app.email_alerts.from"),
+		to:               readValues.ReadValuesSlice("app.email_alerts.to"),
+		digestMode:       readValues.ReadValuesBool("app.email_alerts.digest_mode"),
+		digestInterval:   readValues.ReadValuesDuration("app.email_alerts.digest_interval") * time.Second,
+		maxEmailsPerHour: readValues.ReadValuesInt("app.email_alerts.max_emails_per_hour"),
+		sendMail:         smtp.SendMail,
+	}
+	username := readValues.ReadValuesString("app.email_alerts.username")
+	if username != "" {
+		e.auth = smtp.PlainAuth("", username, readValues.ReadValuesString("app.email_alerts.password"),
+			readValues.ReadValuesString("app.email_alerts.smtp_host"))
+	}
+	var err error
+	e.subjectTmpl, err = template.New("subject").Parse(readValues.ReadValuesString("app.email_alerts.subject_template"))
+	if err != nil {
+		logging.Logger.Error("email alerts subject template is not valid, the default one is used: " + err.Error())
+		e.subjectTmpl = template.Must(template.New("subject").Parse(defaultEmailSubject))
+	}
+	e.bodyTmpl = template.Must(template.New("body").Parse(defaultEmailBody))
+	if bodyFile := readValues.ReadValuesString("app.email_alerts.body_template_file"); bodyFile != "" {
+		tmpl, err := template.ParseFiles(bodyFile)
+		if err != nil {
+			logging.Logger.Error("email alerts body template file is not valid, the default one is used: " + err.Error())
+		} else {
+			e.bodyTmpl = tmpl
+		}
+	}
+	e.digestSubject = template.Must(template.New("digest").Parse(digestEmailSubject))
+	if e.digestMode && e.digestInterval <= 0 {
+		logging.Logger.Error("email alerts digest_mode requires a positive digest_interval, the detections are " +
+			"sent immediately")
+		e.digestMode = false
+	}
+
+	limits := make(map[string]int)
+	if readValues.IsSecExists("app.email_alerts.thresholds") {
+		for serviceName, value := range readValues.ReadValuesMap("app.email_alerts.thresholds") {
+			limit, err := strconv.Atoi(value)
+			if err != nil {
+				logging.Logger.Error("email alerts threshold of " + serviceName + " service is not valid")
+				continue
+			}
+			limits[serviceName] = limit
+		}
+	}
+	e.thresholds = newThresholds(limits, readValues.ReadValuesDuration("app.email_alerts.threshold_window")*time.Second)
+
+	if e.digestInterval > 0 {
+		go e.digestLoop()
+	}
+	return e
+}
+
+// Alert is called on every detection, it sends the email immediately unless digest mode is on
+// or the rate limit has been reached, in these cases the detection waits for the next digest
+func (e *EmailAlerter) Alert(detection *Detection) {
+	if !e.thresholds.reached(detection.ServiceName, detection.Time) {
+		return
+	}
+	e.mu.Lock()
+	if e.digestMode || !e.allowed(detection.Time) {
+		e.queue(detection)
+		e.mu.Unlock()
+		return
+	}
+	e.sentTimes = append(e.sentTimes, detection.Time)
+	e.mu.Unlock()
+
+	subject, body, err := e.render(e.subjectTmpl, detection, []*Detection{detection})
+	if err != nil {
+		logging.Logger.Error("couldn't render the email alert: " + err.Error())
+		return
+	}
+	e.sends.Add(1)
+	go func() {
+		defer e.sends.Done()
+		e.send(subject, body)
+	}()
+}
+
+// allowed checks the number of emails sent in the last hour against max_emails_per_hour
+func (e *EmailAlerter) allowed(now time.Time) bool {
+	if e.maxEmailsPerHour <= 0 {
+		return true
+	}
+	var lastHour []time.Time
+	for _, sent := range e.sentTimes {
+		if now.Sub(sent) < time.Hour {
+			lastHour = append(lastHour, sent)
+		}
+	}
+	e.sentTimes = lastHour
+	return len(e.sentTimes) < e.maxEmailsPerHour
+}
+
+// queue adds the detection to the next digest, it's dropped if there is no digest or the digest is full
+func (e *EmailAlerter) queue(detection *Detection) {
+	if e.digestInterval <= 0 || len(e.pending) >= maxPendingDetections {
+		e.dropped++
+		if e.dropped == 1 {
+			logging.Logger.Warn("the email alerts limit has been reached, the next detections are dropped")
+		}
+		return
+	}
+	e.pending = append(e.pending, detection)
+}
+
+func (e *EmailAlerter) digestLoop() {
+	ticker := time.NewTicker(e.digestInterval)
+	for range ticker.C {
+		e.flush()
+	}
+}
+
+// flush sends one email containing all detections waiting for the digest
+func (e *EmailAlerter) flush() {
+	e.mu.Lock()
+	detections, dropped := e.pending, e.dropped
+	e.pending, e.dropped = nil, 0
+	if len(detections) > 0 {
+		e.sentTimes = append(e.sentTimes, time.Now())
+	}
+	e.mu.Unlock()
+	if dropped != 0 {
+		logging.Logger.Warn(strconv.Itoa(dropped) + " email alerts were dropped since the last digest")
+	}
+	if len(detections) == 0 {
+		return
+	}
+	subject, body, err := e.render(e.digestSubject, detections, detections)
+	if err != nil {
+		logging.Logger.Error("couldn't render the email alerts digest: " + err.Error())
+		return
+	}
+	e.send(subject, body)
+}
+
+func (e *EmailAlerter) render(subjectTmpl *template.Template, subjectData interface{},
+	detections []*Detection) (string, string, error) {
+	subject := &bytes.Buffer{}
+	if err := subjectTmpl.Execute(subject, subjectData); err != nil {
+		return "", "", err
+	}
+	body := &bytes.Buffer{}
+	for i, detection := range detections {
+		if i != 0 {
+			body.WriteString("\r\n----------\r\n\r\n")
+		}
+		if err := e.bodyTmpl.Execute(body, detection); err != nil {
+			return "", "", err
+		}
+	}
+	// the subject has the values of the detection, ex: the file name, a line break would start another header
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(subject.String()), body.String(), nil
+}
+
+func (e *EmailAlerter) send(subject, body string) {
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %s\r\n", e.from)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n\r\n")
+	msg.WriteString(body)
+	if err := e.sendMail(e.smtpAddr, e.auth, e.from, e.to, msg.Bytes()); err != nil {
+		logging.Logger.Error("couldn't send the email alert: " + err.Error())
+		return
+	}
+	logging.Logger.Debug("email alert was sent: " + subject)
+}
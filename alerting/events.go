@@ -0,0 +1,56 @@
+package alerting
+
+import "icapeg/events"
+
+// the number of events which wait for the alerters, the alerts are dropped when the alerters can't keep up
+const alertingQueueSize = 1024
+
+// subscribeToEvents makes the alerters consume the detections, the vendor state changes and the saturated
+// bulkheads from the event bus, so the transactions don't wait for the alert channels
+func subscribeToEvents() {
+	events.Subscribe("alerting", []string{events.TypeDetection, events.TypeVendorStateChanged,
+		events.TypeBulkheadSaturated}, events.SubscriberFunc(handleEvent), alertingQueueSize)
+}
+
+// handleEvent notifies the alerters about an event of the bus, a vendor is alerted about when it goes down
+func handleEvent(event *events.Event) {
+	switch event.Type {
+	case events.TypeDetection:
+		v := event.Verdict
+		Notify(&Detection{
+			Time:          v.Time,
+			XICAPMetadata: v.XICAPMetadata,
+			ServiceName:   v.ServiceName,
+			Tenant:        v.Tenant,
+			Vendor:        v.Vendor,
+			Method:        v.Method,
+			ClientIP:      v.ClientIP,
+			Username:      v.Username,
+			RequestedURL:  v.RequestedURL,
+			FileName:      v.FileName,
+			FileHash:      v.FileHash,
+			FileDigests:   v.FileDigests,
+			FileSize:      v.FileSize,
+			Threat:        v.Threat,
+			Delivered:     v.Delivered,
+		})
+	case events.TypeVendorStateChanged:
+		if event.VendorState.State != events.VendorStateDown {
+			return
+		}
+		NotifyVendorDown(&VendorDownEvent{
+			Time:          event.Time,
+			XICAPMetadata: event.VendorState.XICAPMetadata,
+			ServiceName:   event.VendorState.ServiceName,
+			Tenant:        event.VendorState.Tenant,
+			Vendor:        event.VendorState.Vendor,
+			Error:         event.VendorState.Error,
+		})
+	case events.TypeBulkheadSaturated:
+		NotifyQueueSaturated(&QueueSaturatedEvent{
+			Time:          event.Time,
+			XICAPMetadata: event.Bulkhead.XICAPMetadata,
+			Bulkhead:      event.Bulkhead.Bulkhead,
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package alerting
+
+import (
+	"encoding/hex"
+	"errors"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// the OIDs of the traps and of their variables under the enterprise OID of [app.snmp_traps]
+const (
+	snmpTrapVendorDown     = ".0.1"
+	snmpTrapDetectionSpike = ".0.2"
+	snmpTrapQueueSaturated = ".0.3"
+	snmpVarService         = ".1.1"
+	snmpVarVendor          = ".1.2"
+	snmpVarMessage         = ".1.3"
+	snmpVarCount           = ".1.4"
+	snmpVarXICAPMetadata   = ".1.5"
+)
+
+// the standard OIDs of the first variables of every SNMPv2 trap
+const (
+	sysUpTimeOID   = ".1.3.6.1.2.1.1.3.0"
+	snmpTrapOIDOID = ".1.3.6.1.6.3.1.1.4.1.0"
+)
+
+// QueueSaturatedEvent represents a request which was rejected because the bulkhead of a service or a tenant is full
+type QueueSaturatedEvent struct {
+	Time          time.Time
+	XICAPMetadata string
+	Bulkhead      string
+}
+
+// QueueSaturatedAlerter is implemented by the alerters which notify about the saturated bulkheads
+type QueueSaturatedAlerter interface {
+	QueueSaturated(event *QueueSaturatedEvent)
+}
+
+// NotifyQueueSaturated sends the saturation to all registered alerters which support it
+func NotifyQueueSaturated(event *QueueSaturatedEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	alertersMu.RLock()
+	defer alertersMu.RUnlock()
+	for _, alerter := range alerters {
+		if queueAlerter, ok := alerter.(QueueSaturatedAlerter); ok {
+			queueAlerter.QueueSaturated(event)
+		}
+	}
+}
+
+// SNMPTrapper sends SNMPv2c or SNMPv3 traps to the NOC when a vendor is down, the detections spike or
+// a bulkhead is saturated, a trap of the same event and service is sent once every interval
+type SNMPTrapper struct {
+	params         gosnmp.GoSNMP
+	enterpriseOID  string
+	interval       time.Duration
+	spikeThreshold int
+	spikes         *thresholds
+	startTime      time.Time
+	mu             sync.Mutex
+	lastSent       map[string]time.Time
+	send           func(trap gosnmp.SnmpTrap) error
+}
+
+// initSNMPTrapper reads [app.snmp_traps] section and returns nil if it doesn't exist or it's disabled
+func initSNMPTrapper() *SNMPTrapper {
+	if !readValues.IsSecExists("app.snmp_traps") || !readValues.ReadValuesBool("app.snmp_traps.enabled") {
+		return nil
+	}
+	logging.Logger.Debug("loading SNMP traps configuration")
+	host, port, err := net.SplitHostPort(readValues.ReadValuesString("app.snmp_traps.target"))
+	if err != nil {
+		logging.Logger.Error("SNMP traps target is not valid, the traps are disabled: " + err.Error())
+		return nil
+	}
+	portNumber, _ := strconv.Atoi(port)
+	params := gosnmp.GoSNMP{
+		Target:    host,
+		Port:      uint16(portNumber),
+		Transport: "udp",
+		Timeout:   5 * time.Second,
+		MaxOids:   gosnmp.MaxOids,
+	}
+	switch version := readValues.ReadValuesString("app.snmp_traps.version"); version {
+	case "2c":
+		params.Version = gosnmp.Version2c
+		params.Community = readValues.ReadValuesString("app.snmp_traps.community")
+	case "3":
+		params.Version = gosnmp.Version3
+		if err = snmpV3Params(&params); err != nil {
+			logging.Logger.Error("SNMPv3 configuration is not valid, the traps are disabled: " + err.Error())
+			return nil
+		}
+	default:
+		logging.Logger.Error("SNMP traps version must be 2c or 3, the traps are disabled")
+		return nil
+	}
+	s := newSNMPTrapper(params, readValues.ReadValuesString("app.snmp_traps.enterprise_oid"),
+		readValues.ReadValuesDuration("app.snmp_traps.interval")*time.Second,
+		readValues.ReadValuesInt("app.snmp_traps.spike_threshold"),
+		readValues.ReadValuesDuration("app.snmp_traps.spike_window")*time.Second)
+	return s
+}
+
+// snmpV3Params reads the user based security model of the SNMPv3 traps
+func snmpV3Params(params *gosnmp.GoSNMP) error {
+	usm := &gosnmp.UsmSecurityParameters{
+		UserName:                 readValues.ReadValuesString("app.snmp_traps.username"),
+		AuthenticationPassphrase: readValues.ReadValuesString("app.snmp_traps.auth_password"),
+		PrivacyPassphrase:        readValues.ReadValuesString("app.snmp_traps.priv_password"),
+	}
+	engineID, err := hex.DecodeString(readValues.ReadValuesString("app.snmp_traps.engine_id"))
+	if err != nil || len(engineID) < 5 {
+		return errors.New("engine_id must be a hex string of at least 5 bytes")
+	}
+	usm.AuthoritativeEngineID = string(engineID)
+	switch strings.ToUpper(readValues.ReadValuesString("app.snmp_traps.auth_protocol")) {
+	case "":
+		usm.AuthenticationProtocol = gosnmp.NoAuth
+	case "MD5":
+		usm.AuthenticationProtocol = gosnmp.MD5
+	case "SHA":
+		usm.AuthenticationProtocol = gosnmp.SHA
+	case "SHA256":
+		usm.AuthenticationProtocol = gosnmp.SHA256
+	default:
+		return errors.New("auth_protocol must be empty, MD5, SHA or SHA256")
+	}
+	switch strings.ToUpper(readValues.ReadValuesString("app.snmp_traps.priv_protocol")) {
+	case "":
+		usm.PrivacyProtocol = gosnmp.NoPriv
+	case "DES":
+		usm.PrivacyProtocol = gosnmp.DES
+	case "AES":
+		usm.PrivacyProtocol = gosnmp.AES
+	default:
+		return errors.New("priv_protocol must be empty, DES or AES")
+	}
+	switch {
+	case usm.PrivacyProtocol != gosnmp.NoPriv && usm.AuthenticationProtocol == gosnmp.NoAuth:
+		return errors.New("priv_protocol needs auth_protocol")
+	case usm.PrivacyProtocol != gosnmp.NoPriv:
+		params.MsgFlags = gosnmp.AuthPriv
+	case usm.AuthenticationProtocol != gosnmp.NoAuth:
+		params.MsgFlags = gosnmp.AuthNoPriv
+	default:
+		params.MsgFlags = gosnmp.NoAuthNoPriv
+	}
+	params.SecurityModel = gosnmp.UserSecurityModel
+	params.SecurityParameters = usm
+	return nil
+}
+
+func newSNMPTrapper(params gosnmp.GoSNMP, enterpriseOID string, interval time.Duration, spikeThreshold int,
+	spikeWindow time.Duration) *SNMPTrapper {
+	s := &SNMPTrapper{
+		params:         params,
+		enterpriseOID:  "." + strings.Trim(enterpriseOID, "."),
+		interval:       interval,
+		spikeThreshold: spikeThreshold,
+		spikes:         newThresholds(map[string]int{"all": spikeThreshold}, spikeWindow),
+		startTime:      time.Now(),
+		lastSent:       make(map[string]time.Time),
+	}
+	s.send = s.sendTrap
+	return s
+}
+
+// Alert counts the detections of all services, a trap is sent when spike_threshold detections happen in
+// spike_window
+func (s *SNMPTrapper) Alert(detection *Detection) {
+	if s.spikeThreshold <= 0 || !s.spikes.reached("all", detection.Time) {
+		return
+	}
+	s.trap(snmpTrapDetectionSpike, "spike", detection.Time, []gosnmp.SnmpPDU{
+		s.variable(snmpVarService, detection.ServiceName),
+		s.variable(snmpVarMessage, strconv.Itoa(s.spikeThreshold)+" detections in "+s.spikes.window.String()+
+			", the last one is "+detection.Threat),
+		{Name: s.enterpriseOID + snmpVarCount, Type: gosnmp.Gauge32, Value: uint(s.spikeThreshold)},
+		s.variable(snmpVarXICAPMetadata, detection.XICAPMetadata),
+	})
+}
+
+// VendorDown sends a trap when a service can't reach its vendor
+func (s *SNMPTrapper) VendorDown(event *VendorDownEvent) {
+	s.trap(snmpTrapVendorDown, "vendor_down:"+event.ServiceName, event.Time, []gosnmp.SnmpPDU{
+		s.variable(snmpVarService, event.ServiceName),
+		s.variable(snmpVarVendor, event.Vendor),
+		s.variable(snmpVarMessage, event.Error),
+		s.variable(snmpVarXICAPMetadata, event.XICAPMetadata),
+	})
+}
+
+// QueueSaturated sends a trap when a bulkhead rejects a request
+func (s *SNMPTrapper) QueueSaturated(event *QueueSaturatedEvent) {
+	s.trap(snmpTrapQueueSaturated, "queue_saturated:"+event.Bulkhead, event.Time, []gosnmp.SnmpPDU{
+		s.variable(snmpVarService, event.Bulkhead),
+		s.variable(snmpVarMessage, event.Bulkhead+" has too many in-flight requests"),
+		s.variable(snmpVarXICAPMetadata, event.XICAPMetadata),
+	})
+}
+
+func (s *SNMPTrapper) variable(oid, value string) gosnmp.SnmpPDU {
+	return gosnmp.SnmpPDU{Name: s.enterpriseOID + oid, Type: gosnmp.OctetString, Value: value}
+}
+
+// trap sends the trap in the background unless a trap of the same key was sent in the last interval
+func (s *SNMPTrapper) trap(trapOID, key string, now time.Time, variables []gosnmp.SnmpPDU) {
+	s.mu.Lock()
+	if last, sent := s.lastSent[key]; sent && now.Sub(last) < s.interval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSent[key] = now
+	s.mu.Unlock()
+
+	trap := gosnmp.SnmpTrap{Variables: append([]gosnmp.SnmpPDU{
+		{Name: sysUpTimeOID, Type: gosnmp.TimeTicks, Value: uint32(time.Since(s.startTime) / (10 * time.Millisecond))},
+		{Name: snmpTrapOIDOID, Type: gosnmp.ObjectIdentifier, Value: s.enterpriseOID + trapOID},
+	}, variables...)}
+	go func() {
+		if err := s.send(trap); err != nil {
+			logging.Logger.Error("couldn't send the SNMP trap: " + err.Error())
+		}
+	}()
+}
+
+func (s *SNMPTrapper) sendTrap(trap gosnmp.SnmpTrap) error {
+	params := s.params
+	if usm, ok := s.params.SecurityParameters.(*gosnmp.UsmSecurityParameters); ok {
+		params.SecurityParameters = usm.Copy()
+	}
+	if err := params.Connect(); err != nil {
+		return err
+	}
+	defer params.Conn.Close()
+	_, err := params.SendTrap(trap)
+	return err
+}
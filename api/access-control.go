@@ -0,0 +1,48 @@
+package api
+
+import (
+	"errors"
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/access"
+	"net"
+)
+
+// authorize is a func to check that the ICAP client (ex: a proxy) may use the service, it's identified by its
+// address and/or by the shared secret in the secret header of [app.access_control]. It answers the request with
+// ICAP 403 and returns an error if the client isn't allowed
+func (i *ICAPRequest) authorize(xICAPMetadata string) error {
+	accessControl := i.appCfg.AccessControl
+	if accessControl == nil {
+		return nil
+	}
+	address, _, err := net.SplitHostPort(i.req.RemoteAddr)
+	if err != nil {
+		address = i.req.RemoteAddr
+	}
+	// the connections of the unix socket listeners have no IP, their address is "@" or empty
+	logged := address
+	if net.ParseIP(address) == nil {
+		logged = "unix socket"
+	}
+	client, allowed := access.Authorize(accessControl.Clients, address,
+		i.req.Header.Get(accessControl.SecretHeader), i.serviceName)
+	if allowed {
+		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
+			"the ICAP client "+client.Name+" may use "+i.serviceName+" service"))
+		return nil
+	}
+	event := map[string]interface{}{
+		"service":     i.serviceName,
+		"icap_client": logged,
+		"reason":      "unknown client",
+	}
+	if client != nil {
+		event["client"] = client.Name
+		event["reason"] = "service not allowed"
+	}
+	logging.Logger.Warn(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventAccessDenied, event))
+	i.publishBlock(utils.EventAccessDenied, event, xICAPMetadata)
+	i.w.WriteHeader(utils.ForbiddenStatusCodeStr, nil, false)
+	return errors.New("the ICAP client isn't allowed to use " + i.serviceName + " service")
+}
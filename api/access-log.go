@@ -0,0 +1,97 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/icap"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/digests"
+	general_functions "icapeg/service/services-utilities/general-functions"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// accessLogWriter records the status code of the ICAP response for the access log
+type accessLogWriter struct {
+	icap.ResponseWriter
+	statusCode int
+}
+
+func (w *accessLogWriter) WriteHeader(code int, httpMessage interface{}, hasBody bool) {
+	if w.statusCode == 0 {
+		w.statusCode = code
+	}
+	w.ResponseWriter.WriteHeader(code, httpMessage, hasBody)
+}
+
+// logAccess is a func to record the ICAP transaction in the access log, the client identifiers
+// are pseudonymized in the privacy mode
+func (i *ICAPRequest) logAccess(w *accessLogWriter, start time.Time, xICAPMetadata string) {
+	fields := []zap.Field{
+		zap.String("X-ICAP-Metadata", xICAPMetadata),
+		zap.String("icap_client", i.req.RemoteAddr),
+		zap.String("method", i.req.Method),
+		zap.String("service", i.serviceName),
+		zap.Int("status", w.statusCode),
+		zap.Int64("duration_ms", time.Since(start).Milliseconds()),
+	}
+	if i.tenant != "" {
+		fields = append(fields, zap.String("tenant", i.tenant))
+	}
+	if i.scanProfile != nil {
+		fields = append(fields, zap.String("scan_profile", i.scanProfile.Name))
+	}
+	if clientIP := i.clientIP(); clientIP != "" {
+		fields = append(fields, zap.String("client_ip", logging.ClientIP(clientIP)))
+	}
+	if username := i.clientUsername(); username != "" {
+		fields = append(fields, zap.String("username", logging.Username(username)))
+	}
+	if i.req.Request != nil && i.req.Request.URL != nil {
+		fields = append(fields, zap.String("url", i.req.Request.URL.String()))
+	}
+	if i.verdict != "" {
+		fields = append(fields, zap.String("verdict", i.verdict))
+	}
+	if i.threat != "" {
+		fields = append(fields, zap.String("threat", i.threat))
+	}
+	if i.vendorStatus != 0 {
+		fields = append(fields, zap.Int("vendor_status", i.vendorStatus))
+	}
+	if i.fileName != "" {
+		fields = append(fields, zap.String("file_name", i.fileName), zap.Int64("file_size", i.fileSize),
+			zap.String("sha256", i.fileHash))
+	}
+	logging.AccessLogger.Info("icap_transaction", fields...)
+}
+
+// recordFile is a func to keep the name, the size and the SHA-256 of the scanned file for the access log while
+// the spooled body is still open, the digests of the vendor are kept if it computed them. Nothing is computed
+// if the access log is disabled
+func (i *ICAPRequest) recordFile(vendorMsgs map[string]interface{}, xICAPMetadata string) {
+	if i.body == nil || !logging.AccessLogger.Core().Enabled(zapcore.InfoLevel) {
+		return
+	}
+	i.fileName = vendorMsg(vendorMsgs, utils.VendorMsgFileName)
+	if i.fileName == "" {
+		generalFunc := general_functions.NewGeneralFunc(&http_message.HttpMsg{Request: i.req.Request,
+			Response: i.req.Response}, xICAPMetadata)
+		i.fileName = generalFunc.GetFileName()
+	}
+	i.fileSize = i.body.Size()
+	if fileDigests, exists := vendorMsgs[utils.VendorMsgFileDigests].(map[string]string); exists {
+		i.fileHash = fileDigests[digests.SHA256]
+	}
+	if i.fileHash == "" {
+		fileDigests, err := digests.ComputeWith([]string{digests.SHA256}, i.body.Open())
+		if err != nil {
+			logging.Logger.Warn(utils.PrepareLogMsg(xICAPMetadata, "couldn't hash the file for the access log: "+
+				err.Error()))
+			return
+		}
+		i.fileHash = fileDigests[digests.SHA256]
+	}
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"errors"
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/icap"
+	"icapeg/logging"
+	"icapeg/service"
+	services_utilities "icapeg/service/services-utilities"
+	"icapeg/service/services-utilities/archives"
+	general_functions "icapeg/service/services-utilities/general-functions"
+	"icapeg/service/services-utilities/metrics"
+	"icapeg/service/services-utilities/spool"
+	"io"
+	"net/http"
+	"net/textproto"
+	"path"
+	"strconv"
+	"time"
+)
+
+// errMemberVerdict stops the extraction of an archive once a file of it got a verdict
+var errMemberVerdict = errors.New("the archive got the verdict of its file")
+
+// scanArchive is a func to extract the zip, tar, gzip and 7z files of a service which has an archive_scan section
+// and to scan each of their files with the vendor of the service, the first malicious file blocks the archive.
+// The encrypted archives and the ones which exceed the extraction limits get the policies of the service. It
+// returns true if the archive got its verdict, false if it's scanned as a whole like the other files
+func (i *ICAPRequest) scanArchive(icapHeader textproto.MIMEHeader, xICAPMetadata string) (processingResult, bool) {
+	cfg := i.appCfg.ServicesInstances[i.serviceName].ArchiveScan
+	if cfg == nil {
+		return processingResult{}, false
+	}
+	var body *spool.Body
+	var err error
+	if i.methodName == utils.ICAPModeReq {
+		body, err = bodyOf(&i.req.Request.Body)
+	} else if i.req.Response != nil {
+		body, err = bodyOf(&i.req.Response.Body)
+	}
+	if body == nil || err != nil || archives.Detect(body.Head(archives.DetectLen)) == archives.None {
+		return processingResult{}, false
+	}
+
+	var verdict processingResult
+	limits := archives.Limits{
+		MaxDepth:      cfg.MaxDepth,
+		MaxMembers:    cfg.MaxMembers,
+		MaxMemberSize: int64(cfg.MaxMemberSize),
+		MaxTotalSize:  int64(cfg.MaxTotalSize),
+		MaxRatio:      cfg.MaxRatio,
+	}
+	err = archives.ExtractFrom(body, body.Size(), limits, func(m archives.Member) error {
+		r := i.scanMember(m.Name, m.Data, icapHeader, xICAPMetadata)
+		// the archive is scanned as a whole if the vendor fails to scan one of its files
+		if r.IcapStatusCode == utils.InternalServerErrStatusCodeStr || r.IcapStatusCode == utils.RequestTimeOutStatusCodeStr {
+			return errors.New("the vendor couldn't scan " + m.Name)
+		}
+		if r.vendorMsgs[utils.VendorMsgVerdict] != utils.SampleSeverityMalicious {
+			return nil
+		}
+		r.vendorMsgs[utils.VendorMsgArchiveMember] = m.Name
+		verdict = r
+		return errMemberVerdict
+	})
+	switch {
+	case err == errMemberVerdict:
+		logging.Logger.Info(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventArchiveMember, map[string]interface{}{
+			"service": i.serviceName,
+			"method":  i.methodName,
+			"member":  verdict.vendorMsgs[utils.VendorMsgArchiveMember],
+			"threat":  vendorMsg(verdict.vendorMsgs, utils.VendorMsgThreat),
+		}))
+		return verdict, true
+	case errors.Is(err, archives.ErrEncrypted):
+		return i.archivePolicy(cfg.EncryptedPolicy, "encrypted", body, xICAPMetadata)
+	case errors.Is(err, archives.ErrBomb):
+		logging.Logger.Warn(utils.PrepareLogMsg(xICAPMetadata, err.Error()))
+		return i.archivePolicy(cfg.BombPolicy, "bomb", body, xICAPMetadata)
+	case err != nil:
+		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "scanning the archive as a whole, couldn't extract it: "+
+			err.Error()))
+	}
+	return processingResult{}, false
+}
+
+// scanMember is a func to scan a file of an archive or of an upload with the vendor of the service, the file is
+// sent like the body of the HTTP message whose URL path is the one of the archive followed by the path of the file
+func (i *ICAPRequest) scanMember(name string, data []byte, icapHeader textproto.MIMEHeader,
+	xICAPMetadata string) processingResult {
+	request := i.req.Request.Clone(i.req.Request.Context())
+	request.URL.Path = path.Join(request.URL.Path, name)
+	request.RequestURI = i.req.Request.RequestURI
+	httpMsg := &http_message.HttpMsg{Request: request}
+	if i.methodName == utils.ICAPModeReq {
+		request.Body = icap.NewBody(data)
+		request.Header.Set(utils.ContentLength, strconv.Itoa(len(data)))
+		request.Header.Del(utils.ContentType)
+	} else {
+		httpMsg.Response = &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     http.StatusText(http.StatusOK),
+			Proto:      i.req.Response.Proto,
+			ProtoMajor: i.req.Response.ProtoMajor,
+			ProtoMinor: i.req.Response.ProtoMinor,
+			Header:     http.Header{utils.ContentLength: {strconv.Itoa(len(data))}},
+			Body:       icap.NewBody(data),
+			Request:    request,
+		}
+	}
+
+	start := time.Now()
+	requiredService := service.GetService(i.vendor, i.serviceName, i.methodName, httpMsg, xICAPMetadata)
+	r := i.callVendor(requiredService, false, icapHeader, xICAPMetadata)
+	metrics.RecordVendor(i.serviceName, i.vendor, len(data), time.Since(start))
+	if r.vendorMsgs == nil {
+		r.vendorMsgs = make(map[string]interface{})
+	}
+	return r
+}
+
+// archivePolicy is a func to apply the encrypted or bomb policy of the service to the archive which couldn't be
+// extracted, the pass_through policy scans the archive as a whole so false is returned
+func (i *ICAPRequest) archivePolicy(policy, reason string, body *spool.Body, xICAPMetadata string) (processingResult,
+	bool) {
+	logging.Logger.Info(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventArchivePolicy, map[string]interface{}{
+		"service": i.serviceName,
+		"method":  i.methodName,
+		"archive": reason,
+		"policy":  policy,
+	}))
+	vendorMsgs := map[string]interface{}{utils.VendorMsgArchivePolicy: reason + ": " + policy}
+	switch policy {
+	case utils.ArchivePolicyPassThrough:
+		return processingResult{}, false
+	case utils.ArchivePolicyAllow:
+		if i.methodName == utils.ICAPModeReq {
+			i.req.Request.Body = body.Open()
+			return processingResult{IcapStatusCode: utils.NoModificationStatusCodeStr, httpMsg: i.req.Request,
+				vendorMsgs: vendorMsgs}, true
+		}
+		i.req.Response.Body = body.Open()
+		return processingResult{IcapStatusCode: utils.NoModificationStatusCodeStr, httpMsg: i.req.Response,
+			vendorMsgs: vendorMsgs}, true
+	}
+
+	generalFunc := general_functions.NewGeneralFunc(&http_message.HttpMsg{Request: i.req.Request, Response: i.req.Response},
+		xICAPMetadata)
+	fileSize := strconv.FormatInt(body.Size(), 10)
+	serviceHeaders := make(map[string]string)
+	services_utilities.AddThreatHeaders(serviceHeaders, services_utilities.Violation{FileName: generalFunc.GetFileName(),
+		Threat: "Archive-" + reason, Type: services_utilities.ThreatTypeContainer,
+		Resolution: services_utilities.ResolutionBlocked})
+	if i.methodName == utils.ICAPModeResp {
+		htmlPage := generalFunc.GenHtmlPage(utils.BlockPagePath, utils.ErrPageReasonArchiveNotScanned, i.serviceName, "-",
+			i.req.Request.RequestURI, fileSize, xICAPMetadata)
+		response := generalFunc.ErrPageResp(http.StatusForbidden, htmlPage.Len())
+		response.Body = io.NopCloser(htmlPage)
+		i.alteringBlockResponse(response)
+		return processingResult{IcapStatusCode: utils.OkStatusCodeStr, httpMsg: response, serviceHeaders: serviceHeaders,
+			vendorMsgs: vendorMsgs}, true
+	}
+	htmlPage, request, err := generalFunc.ReqModErrPage(utils.ErrPageReasonArchiveNotScanned, i.serviceName, "-", fileSize)
+	if err != nil {
+		logging.Logger.Error(utils.PrepareLogMsg(xICAPMetadata, "couldn't prepare the block page: "+err.Error()))
+		return processingResult{IcapStatusCode: utils.InternalServerErrStatusCodeStr, vendorMsgs: vendorMsgs}, true
+	}
+	request.Body = io.NopCloser(htmlPage)
+	return processingResult{IcapStatusCode: utils.OkStatusCodeStr, httpMsg: request, serviceHeaders: serviceHeaders,
+		vendorMsgs: vendorMsgs}, true
+}
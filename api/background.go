@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+	"sync"
+)
+
+// the goroutines which keep processing the requests after their ICAP responses were written, like the ones of the
+// shadow services and of the deferred scans, the graceful shutdown waits for them
+var background sync.WaitGroup
+
+// goBackground runs fn in a goroutine which WaitBackground waits for
+func goBackground(fn func()) {
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		fn()
+	}()
+}
+
+// WaitBackground waits for the requests which are processed in the background to finish, it returns the error
+// of ctx if ctx is done first
+func WaitBackground(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"errors"
+	utils "icapeg/consts"
+	"icapeg/icap"
+	"icapeg/logging"
+)
+
+// badRequest is a func to answer an ICAP request whose encapsulated body is malformed, like a missing CRLF
+// or a truncated chunk, with 400, the connection is closed since the rest of the stream can't be parsed.
+// The violation is told in the X-ICAP-Error header in strict mode
+func (i *ICAPRequest) badRequest(err error, xICAPMetadata string) {
+	logging.Logger.Error(utils.PrepareLogMsg(xICAPMetadata, "malformed ICAP request body: "+err.Error()))
+	i.w.Header().Set("Connection", "close")
+	var violation *icap.ViolationError
+	if errors.As(err, &violation) {
+		i.w.Header().Set(icap.ViolationHeader, violation.Reason)
+	}
+	i.w.WriteHeader(utils.BadRequestStatusCodeStr, nil, false)
+	i.w.Flush()
+	i.w.Abort()
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/statistics"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// RejectReasonHeader tells the ICAP client why its request was rejected
+const RejectReasonHeader = "X-Reject-Reason"
+
+// rejectDeclaredOversize is a func to reject the ICAP request before reading its body if the Content-Length of
+// the encapsulated HTTP message exceeds the max_size of [app.body_limit]
+func (i *ICAPRequest) rejectDeclaredOversize(xICAPMetadata string) bool {
+	if i.appCfg.BodyLimit == nil || i.methodName == utils.ICAPModeOptions {
+		return false
+	}
+	var header http.Header
+	if i.methodName == utils.ICAPModeResp && i.req.Response != nil {
+		header = i.req.Response.Header
+	} else if i.methodName == utils.ICAPModeReq && i.req.Request != nil {
+		header = i.req.Request.Header
+	}
+	declared, err := strconv.ParseInt(header.Get(utils.ContentLength), 10, 64)
+	if err != nil || declared <= i.appCfg.BodyLimit.MaxSize {
+		return false
+	}
+	i.rejectOversize(declared, false, xICAPMetadata)
+	return true
+}
+
+// limitBody is a func to cap the body which is read into memory at one byte beyond the max_size of
+// [app.body_limit], so an oversize body is detected without reading the rest of it
+func (i *ICAPRequest) limitBody(body io.Reader) io.Reader {
+	if i.appCfg.BodyLimit == nil {
+		return body
+	}
+	return io.LimitReader(body, i.appCfg.BodyLimit.MaxSize+1)
+}
+
+// isOversize reports whether the body which was read exceeds the max_size of [app.body_limit]
+func (i *ICAPRequest) isOversize(bodyLen int) bool {
+	return i.appCfg.BodyLimit != nil && int64(bodyLen) > i.appCfg.BodyLimit.MaxSize
+}
+
+// rejectOversize is a func to answer the ICAP request whose body exceeds the max_size of [app.body_limit] with
+// the status code of the section and the reason in the X-Reject-Reason header, without scanning it. size is the
+// declared length of the body, or the bytes which were read before the limit was reached. The connection is
+// closed if the rest of the body after a preview was being read, since it can't be skipped
+func (i *ICAPRequest) rejectOversize(size int64, afterPreview bool, xICAPMetadata string) {
+	limit := i.appCfg.BodyLimit
+	reason := "the body exceeds the limit of " + strconv.FormatInt(limit.MaxSize, 10) + " bytes"
+	details := map[string]interface{}{
+		"service":     i.serviceName,
+		"size":        size,
+		"max_size":    limit.MaxSize,
+		"status_code": limit.StatusCode,
+	}
+	logging.Logger.Warn(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventOversizeReject, details))
+	i.publishBlock(utils.EventOversizeReject, details, xICAPMetadata)
+	i.verdict = statistics.VerdictOversize
+	i.w.Header().Set(RejectReasonHeader, reason)
+	if afterPreview {
+		i.w.Header().Set("Connection", "close")
+	}
+	i.w.WriteHeader(limit.StatusCode, nil, false)
+	if afterPreview {
+		i.w.Flush()
+		i.w.Abort()
+	}
+}
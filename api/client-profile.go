@@ -0,0 +1,187 @@
+package api
+
+import (
+	"encoding/base64"
+	"icapeg/config"
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/bulkhead"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// squid peculiarities
+const (
+	// squid caches the OPTIONS response for Options-TTL seconds, without it squid re-sends OPTIONS every request
+	squidOptionsTTL = "3600"
+	// squid icap_send_client_ip on, most ICAP clients use the same header
+	clientIPHeader = "X-Client-IP"
+	// icap_client_username_header default value, the value is base64 encoded if icap_client_username_encode is on
+	squidUsernameHeader = "X-Client-Username"
+	// squid sends the ICAP trailers which it supports in their own Allow line, ex: Allow: 204 and Allow: trailers
+	allowHeader = "Allow"
+)
+
+// ProxySG peculiarities
+const (
+	// the user is base64 encoded with its authentication scheme, ex: WinNT://DOMAIN/user or LDAP://server/cn=user,dc=example
+	proxySGUsernameHeader = "X-Authenticated-User"
+	proxySGGroupsHeader   = "X-Authenticated-Groups"
+	proxySGOptionsTTL     = "3600"
+)
+
+// the authentication schemes of X-Authenticated-User
+var proxySGAuthSchemes = []string{"WinNT://", "LDAP://", "Local://", "SAML://", "Radius://"}
+
+// clientIP returns the IP address of the HTTP client which the ICAP client sent
+func (i *ICAPRequest) clientIP() string {
+	return i.req.Header.Get(clientIPHeader)
+}
+
+// allowed returns the Allow header of the ICAP request, the Allow lines of squid are joined in one list
+func (i *ICAPRequest) allowed() string {
+	switch i.appCfg.ClientProfile {
+	case utils.ClientProfileSquid:
+		return strings.Join(i.req.Header.Values(allowHeader), ", ")
+	}
+	return i.req.Header.Get(allowHeader)
+}
+
+// clientUsername returns the authenticated user of the HTTP client which the ICAP client sent
+func (i *ICAPRequest) clientUsername() string {
+	switch i.appCfg.ClientProfile {
+	case utils.ClientProfileSquid:
+		return decodeBase64Username(i.req.Header.Get(squidUsernameHeader))
+	case utils.ClientProfileProxySG:
+		return decodeProxySGUsername(i.req.Header.Get(proxySGUsernameHeader))
+	}
+	return ""
+}
+
+// decodeProxySGUsername decodes the X-Authenticated-User of ProxySG and removes its authentication
+// scheme, WinNT://DOMAIN/user becomes DOMAIN\user
+func decodeProxySGUsername(username string) string {
+	username = decodeBase64Username(username)
+	for _, scheme := range proxySGAuthSchemes {
+		if strings.HasPrefix(username, scheme) {
+			username = strings.TrimPrefix(username, scheme)
+			if scheme == "WinNT://" {
+				username = strings.Replace(username, "/", "\\", 1)
+			}
+			break
+		}
+	}
+	return username
+}
+
+// decodeBase64Username decodes a base64 encoded username, the username is returned as it is
+// if it isn't encoded
+func decodeBase64Username(username string) string {
+	if username == "" {
+		return ""
+	}
+	decoded, err := base64.StdEncoding.DecodeString(username)
+	if err != nil {
+		return username
+	}
+	for _, r := range string(decoded) {
+		if !unicode.IsPrint(r) {
+			return username
+		}
+	}
+	return string(decoded)
+}
+
+// istagValue returns the ISTag header value, squid rejects the ISTag if it isn't a quoted string
+// as the RFC 3507 requires
+func (i *ICAPRequest) istagValue(ISTgValue string) string {
+	switch i.appCfg.ClientProfile {
+	case utils.ClientProfileSquid:
+		if !strings.HasPrefix(ISTgValue, "\"") {
+			return strconv.Quote(ISTgValue)
+		}
+	}
+	return ISTgValue
+}
+
+// addingProfileOptionsHeaders adds the OPTIONS response headers which the client profile expects
+func (i *ICAPRequest) addingProfileOptionsHeaders(xICAPMetadata string) {
+	switch i.appCfg.ClientProfile {
+	case utils.ClientProfileSquid:
+		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "adding the OPTIONS headers of squid profile"))
+		i.h.Set("Options-TTL", squidOptionsTTL)
+		if maxConcurrent := bulkhead.MaxConcurrent(i.serviceName); maxConcurrent > 0 {
+			i.h.Set("Max-Connections", strconv.Itoa(maxConcurrent))
+		}
+		// squid sends a preview only for the files which match Transfer-Preview and sends nothing
+		// for the files which match Transfer-Ignore
+		if ignored := i.transferIgnore(); ignored != "" {
+			i.h.Set("Transfer-Ignore", ignored)
+		}
+	case utils.ClientProfileProxySG:
+		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "adding the OPTIONS headers of ProxySG profile"))
+		i.h.Set("Options-TTL", proxySGOptionsTTL)
+		i.h.Set("Service-ID", i.serviceName)
+		// ProxySG sends only the client headers which the ICAP server asked for in X-Include
+		i.h.Set("X-Include", clientIPHeader+", "+proxySGUsernameHeader+", "+proxySGGroupsHeader)
+	}
+}
+
+// alteringBlockResponse applies the expectations of the client profile to the HTTP response which
+// replaces a blocked file, ProxySG caches the altered response like the original one unless it's marked
+// as not cacheable so the block page would be served from its cache after the threat is cleaned
+func (i *ICAPRequest) alteringBlockResponse(httpMsg interface{}) {
+	resp, isResp := httpMsg.(*http.Response)
+	if !isResp || resp == nil {
+		return
+	}
+	switch i.appCfg.ClientProfile {
+	case utils.ClientProfileProxySG:
+		resp.Header.Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		resp.Header.Del("Expires")
+		resp.Header.Del("Last-Modified")
+		resp.Header.Del("ETag")
+	}
+}
+
+// clientRendersPatiencePage reports whether the ICAP client shows its own patience page to the user
+// during long scans, ProxySG does that so ICAPeg must not send its own
+func (i *ICAPRequest) clientRendersPatiencePage() bool {
+	return i.appCfg.ClientProfile == utils.ClientProfileProxySG
+}
+
+// transferIgnore returns the bypass extensions of the service as a Transfer-Ignore list,
+// the list is empty if the service bypasses everything except the process extensions. The OPTIONS response
+// is shared by the scan profiles, so an extension which one of them doesn't bypass isn't in the list
+func (i *ICAPRequest) transferIgnore() string {
+	serviceInstance := i.appCfg.ServicesInstances[i.serviceName]
+	var ignored []string
+	for _, ext := range serviceInstance.BypassExtensions {
+		if ext == utils.Any {
+			return ""
+		}
+		bypassedByAll := true
+		for _, profile := range serviceInstance.Profiles {
+			if !profileBypasses(profile, ext) {
+				bypassedByAll = false
+				break
+			}
+		}
+		if bypassedByAll {
+			ignored = append(ignored, ext)
+		}
+	}
+	return strings.Join(ignored, ", ")
+}
+
+// profileBypasses reports whether the scan profile bypasses the files of the extension, the asterisk bypasses
+// the extensions which aren't in the other arrays
+func profileBypasses(profile *config.ScanProfileConfig, ext string) bool {
+	if contains(profile.BypassExtensions, ext) {
+		return true
+	}
+	return contains(profile.BypassExtensions, utils.Any) && !contains(profile.ProcessExtensions, ext) &&
+		!contains(profile.RejectExtensions, ext)
+}
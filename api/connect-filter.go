@@ -0,0 +1,58 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	general_functions "icapeg/service/services-utilities/general-functions"
+	"icapeg/service/services-utilities/hostpolicy"
+	"io"
+	"net/http"
+)
+
+// filterConnect is a func to check the destination of a CONNECT request against the CONNECT filter of the service,
+// so the HTTPS destinations are blocked at tunnel setup even without SSL bump. A blocked tunnel is answered
+// with a 403 response and true is returned, an allowed one is sent to the policy service of the filter if it has
+func (i *ICAPRequest) filterConnect(xICAPMetadata string) bool {
+	cfg := i.appCfg.ServicesInstances[i.serviceName].ConnectFilter
+	if cfg == nil || i.methodName != utils.ICAPModeReq || i.req.Request.Method != http.MethodConnect {
+		return false
+	}
+	authority := i.req.Request.Host
+	if authority == "" && i.req.Request.URL != nil {
+		authority = i.req.Request.URL.Host
+	}
+	host, port := hostpolicy.SplitAuthority(authority)
+	reason := ""
+	if !hostpolicy.PortAllowed(cfg.AllowedPorts, port) {
+		reason = "port " + port + " isn't allowed"
+	} else if pattern := hostpolicy.Blocked(cfg.BlockedHosts, host); pattern != "" {
+		reason = "host matches " + pattern
+	}
+	if reason == "" {
+		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "the CONNECT to "+authority+" is allowed"))
+		if cfg.Service != "" && cfg.Service != i.serviceName && i.routedFrom == "" {
+			i.routeTo(cfg.Service, "the CONNECT to "+authority, xICAPMetadata)
+		}
+		return false
+	}
+
+	details := map[string]interface{}{
+		"service": i.serviceName,
+		"host":    host,
+		"port":    port,
+		"reason":  reason,
+	}
+	logging.Logger.Info(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventConnectBlocked, details))
+	i.publishBlock(utils.EventConnectBlocked, details, xICAPMetadata)
+	vendorMsgs := map[string]interface{}{utils.VendorMsgConnectBlocked: reason}
+	generalFunc := general_functions.NewGeneralFunc(&http_message.HttpMsg{Request: i.req.Request}, xICAPMetadata)
+	htmlPage := generalFunc.GenHtmlPage(utils.BlockPagePath, utils.ErrPageReasonDestinationBlocked, i.serviceName, "-",
+		authority, "0", xICAPMetadata)
+	response := generalFunc.ErrPageResp(http.StatusForbidden, htmlPage.Len())
+	response.Body = io.NopCloser(htmlPage)
+	i.alteringBlockResponse(response)
+	i.w.WriteHeader(utils.OkStatusCodeStr, response, true)
+	i.allHeaders(utils.OkStatusCodeStr, nil, nil, vendorMsgs, xICAPMetadata)
+	return true
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	general_functions "icapeg/service/services-utilities/general-functions"
+	"icapeg/service/services-utilities/quotas"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// enforceQuota is a func to count the bytes which are scanned for the HTTP client or the tenant, and to bypass
+// or block the HTTP message without scanning it if they exceeded their quota in the rolling window, so a single
+// runaway host doesn't use the metered vendor APIs up. It returns true if the ICAP request was answered
+func (i *ICAPRequest) enforceQuota(xICAPMetadata string) bool {
+	q := quotas.Current()
+	if q == nil {
+		return false
+	}
+	key := i.quotaKey(q)
+	exceeded, used, limit := q.Exceeded(key)
+	if !exceeded {
+		// the preview was counted already if the rest of the body arrived after 100 Continue
+		q.Add(key, i.scannedBytes-i.quotaBytes)
+		i.quotaBytes = i.scannedBytes
+		return false
+	}
+
+	details := map[string]interface{}{
+		"service":   i.serviceName,
+		"method":    i.methodName,
+		q.Per:       key,
+		"action":    q.Action,
+		"used":      used,
+		"max_bytes": limit,
+		"window":    q.Window().String(),
+	}
+	logging.Logger.Warn(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventQuotaExceeded, details))
+	if q.Action == quotas.ActionBypass {
+		i.Is204Allowed = i.bypass204Allowed()
+		i.returnOriginal()
+		return true
+	}
+
+	i.publishBlock(utils.EventQuotaExceeded, details, xICAPMetadata)
+	httpMsg := &http_message.HttpMsg{Request: i.req.Request, Response: i.req.Response}
+	requestURI := "-"
+	if i.req.Request != nil && i.req.Request.URL != nil {
+		requestURI = i.req.Request.URL.String()
+	}
+	generalFunc := general_functions.NewGeneralFunc(httpMsg, xICAPMetadata)
+	htmlPage := generalFunc.GenHtmlPage(utils.BlockPagePath, utils.ErrPageReasonQuotaExceeded, i.serviceName, "-",
+		requestURI, strconv.Itoa(i.scannedBytes), xICAPMetadata)
+	response := generalFunc.ErrPageResp(http.StatusForbidden, htmlPage.Len())
+	i.alteringBlockResponse(response)
+	i.w.WriteHeader(utils.OkStatusCodeStr, response, true)
+	io.Copy(i.w, htmlPage)
+	return true
+}
+
+// quotaKey returns the HTTP client or the tenant whose bytes are counted in the quota
+func (i *ICAPRequest) quotaKey(q *quotas.Quotas) string {
+	if q.Per == quotas.PerTenant {
+		return i.tenant
+	}
+	return i.clientIP()
+}
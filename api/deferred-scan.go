@@ -0,0 +1,105 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"icapeg/cache"
+	utils "icapeg/consts"
+	"icapeg/events"
+	"icapeg/jobs"
+	"icapeg/logging"
+	"icapeg/service"
+	"icapeg/service/services-utilities/spool"
+	"io"
+	"net/textproto"
+	"net/url"
+	"strconv"
+)
+
+// deferredScan is an interim response which delivered the file before the vendor scanned it
+type deferredScan struct {
+	IcapStatusCode int
+}
+
+// deferringScan reports whether the file is delivered before scanning it and returns its SHA-256,
+// the files whose hash was found malicious before are always scanned first
+func (i *ICAPRequest) deferringScan(body *spool.Body) (string, bool) {
+	cfg := i.appCfg.ServicesInstances[i.serviceName].DeferredScan
+	if cfg == nil || body.Size() < int64(cfg.MinSize) {
+		return "", false
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, body.Open()); err != nil {
+		return "", false
+	}
+	fileHash := hex.EncodeToString(hash.Sum(nil))
+	return fileHash, !cache.IsHashBlocked(fileHash)
+}
+
+// startDeferredScan sends the original HTTP response to the ICAP client and queues the scan of the file, a
+// malicious verdict notifies the alerters and adds the file hash to the hash blocklist. It returns nil if the
+// deferred scan queue of the service is full, the file is scanned before it's delivered then
+func (i *ICAPRequest) startDeferredScan(requiredService service.Service, partial bool, icapHeader textproto.MIMEHeader,
+	release func(), body *spool.Body, fileHash string, xICAPMetadata string) *deferredScan {
+	cfg := i.appCfg.ServicesInstances[i.serviceName].DeferredScan
+	queue := jobs.Deferred(i.serviceName, cfg)
+	if !queue.Reserve() {
+		logging.Logger.Warn(utils.PrepareLogMsg(xICAPMetadata, "the deferred scan queue of "+i.serviceName+
+			" is full, scanning the file before delivering it"))
+		return nil
+	}
+	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
+		"delivering the HTTP response before scanning it, the file is scanned in the background"))
+	i.deliveredBeforeScan = true
+	delivered := &deferredScan{IcapStatusCode: utils.NoModificationStatusCodeStr}
+	if i.Is204Allowed {
+		i.w.WriteHeader(utils.NoModificationStatusCodeStr, nil, false)
+	} else {
+		resp := *i.req.Response
+		resp.Header = i.req.Response.Header.Clone()
+		resp.Body = body.Open()
+		i.w.WriteHeader(utils.OkStatusCodeStr, &resp, true)
+		delivered.IcapStatusCode = utils.OkStatusCodeStr
+	}
+
+	var requestedURL *url.URL
+	if i.req.Request != nil {
+		requestedURL = i.req.Request.URL
+	}
+	// the file is kept in the job for the queue dir only, the scan of this process reads the spooled body
+	var content []byte
+	if cfg.QueueDir != "" {
+		content, _ = io.ReadAll(body.Open())
+	}
+	releaseBody := i.retainBody()
+	// the queued scans are waited for by the graceful shutdown like the other background scans
+	background.Add(1)
+	queue.Submit(jobs.DeferredJob(i.serviceName, xICAPMetadata, requestedURL, content), func() *events.VerdictEvent {
+		defer background.Done()
+		defer releaseBody()
+		r := i.callProcessing(requiredService, partial, icapHeader, xICAPMetadata)
+		release()
+		i.notifyVerdict(r.httpMsg, r.vendorMsgs, xICAPMetadata)
+		event := i.verdictEvent(r.vendorMsgs, xICAPMetadata)
+		if event.FileHash == "" {
+			event.FileHash = fileHash
+		}
+		if r.vendorMsgs[utils.VendorMsgVerdict] == utils.SampleSeverityMalicious {
+			logging.Logger.Warn(utils.PrepareLogMsg(xICAPMetadata, "a delivered file was found malicious by "+
+				i.serviceName+", blocking its later downloads: "+fileHash))
+			cache.BlockHash(fileHash, i.serviceName, fmt.Sprint(r.vendorMsgs[utils.VendorMsgThreat]), cfg.BlocklistTTL)
+			return event
+		}
+		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "the deferred scan of "+i.serviceName+
+			" returned ICAP response with status code "+strconv.Itoa(r.IcapStatusCode)))
+		return event
+	})
+	return delivered
+}
+
+// finish does nothing because the file was already delivered
+func (d *deferredScan) finish(IcapStatusCode int, httpMsg interface{}, vendorMsgs map[string]interface{},
+	xICAPMetadata string) int {
+	return d.IcapStatusCode
+}
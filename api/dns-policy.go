@@ -0,0 +1,61 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	general_functions "icapeg/service/services-utilities/general-functions"
+	"icapeg/service/services-utilities/hostpolicy"
+	"icapeg/service/services-utilities/rpz"
+	"io"
+	"net/http"
+)
+
+// checkDNSPolicy is a func to check the destination host of a REQMOD request against the DNS firewall (RPZ) of
+// the service, so the gateway blocks the hosts which the DNS firewall infrastructure blocks. A blocked request is
+// answered with a 403 response which has the block page and true is returned
+func (i *ICAPRequest) checkDNSPolicy(xICAPMetadata string) bool {
+	checker, exists := rpz.Get(i.serviceName)
+	if !exists || i.methodName != utils.ICAPModeReq {
+		return false
+	}
+	authority := i.req.Request.Host
+	if authority == "" && i.req.Request.URL != nil {
+		authority = i.req.Request.URL.Host
+	}
+	host, _ := hostpolicy.SplitAuthority(authority)
+	verdict, err := checker.Check(host)
+	if err != nil {
+		if checker.FailOpen() {
+			logging.Logger.Warn(utils.PrepareLogMsg(xICAPMetadata,
+				"couldn't check "+host+" against the DNS policy, it's allowed: "+err.Error()))
+			return false
+		}
+		logging.Logger.Warn(utils.PrepareLogMsg(xICAPMetadata,
+			"couldn't check "+host+" against the DNS policy, it's blocked: "+err.Error()))
+		verdict = rpz.Verdict{Blocked: true, Action: rpz.ActionFailClosed}
+	}
+	if !verdict.Blocked {
+		return false
+	}
+
+	details := map[string]interface{}{
+		"service": i.serviceName,
+		"host":    host,
+		"action":  verdict.Action,
+		"zone":    verdict.Zone,
+		"target":  verdict.Target,
+	}
+	logging.Logger.Info(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventDNSPolicy, details))
+	i.publishBlock(utils.EventDNSPolicy, details, xICAPMetadata)
+	vendorMsgs := map[string]interface{}{utils.VendorMsgDNSPolicy: verdict.Action}
+	generalFunc := general_functions.NewGeneralFunc(&http_message.HttpMsg{Request: i.req.Request}, xICAPMetadata)
+	htmlPage := generalFunc.GenHtmlPage(utils.BlockPagePath, utils.ErrPageReasonDestinationBlocked, i.serviceName, "-",
+		authority, "0", xICAPMetadata)
+	response := generalFunc.ErrPageResp(http.StatusForbidden, htmlPage.Len())
+	response.Body = io.NopCloser(htmlPage)
+	i.alteringBlockResponse(response)
+	i.w.WriteHeader(utils.OkStatusCodeStr, response, true)
+	i.allHeaders(utils.OkStatusCodeStr, nil, nil, vendorMsgs, xICAPMetadata)
+	return true
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/geoip"
+	"icapeg/service/services-utilities/policies"
+	"icapeg/service/services-utilities/quotas"
+	"icapeg/service/services-utilities/rpz"
+	"icapeg/service/services-utilities/streaming"
+	"net/http"
+)
+
+// answerEarly is a func to answer the ICAP request before its body is read if its verdict doesn't depend on
+// the body (ex: its service is disabled at runtime), so the ICAP client gets the 204 while it's still sending
+// the body. The ICAP server discards the rest of the body or closes the connection if it's too long to be
+// discarded. It returns true if the ICAP request was answered
+func (i *ICAPRequest) answerEarly(xICAPMetadata string) bool {
+	// a preview is answered after the preview only, the rest of the body isn't sent unless it's asked for
+	if !i.Is204Allowed || i.methodName == utils.ICAPModeOptions || i.req.Header.Get("Preview") != "" {
+		return false
+	}
+	if !i.decideBeforeScan(true, false, xICAPMetadata) {
+		return false
+	}
+	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "answered with 204 before reading the body"))
+	return true
+}
+
+// decideBeforeScan is a func to take the decisions which answer the ICAP request without scanning it, in their
+// order. Before the body is read (early), the decisions stop at the first one which needs the body or which
+// doesn't bypass the HTTP message, so the ICAP request is answered early only if it would be bypassed the same
+// way after its body is read. It returns true if the ICAP request was answered
+func (i *ICAPRequest) decideBeforeScan(early, partial bool, xICAPMetadata string) bool {
+	//answering the HTTP message which a policy rule bypasses or blocks
+	if early && i.policy != nil && i.policy.Action == policies.ActionBlock {
+		return false
+	}
+	if i.applyPolicy(xICAPMetadata) {
+		return true
+	}
+	//checking the destination of the HTTPS tunnel if the HTTP message is a CONNECT request
+	if early && i.appCfg.ServicesInstances[i.serviceName].ConnectFilter != nil &&
+		i.methodName == utils.ICAPModeReq && i.req.Request.Method == http.MethodConnect {
+		return false
+	}
+	if i.filterConnect(xICAPMetadata) {
+		return true
+	}
+	//checking the destination host against the DNS firewall (RPZ) of the service in REQMOD
+	if _, hasDNSPolicy := rpz.Get(i.serviceName); early && hasDNSPolicy && i.methodName == utils.ICAPModeReq {
+		return false
+	}
+	if i.checkDNSPolicy(xICAPMetadata) {
+		return true
+	}
+	//routing the HTTP message to another service upon the country of its server or its file type
+	//if the service has a routing table, the other service may not be bypassed
+	serviceCfg := i.appCfg.ServicesInstances[i.serviceName]
+	if early && i.routedFrom == "" && (len(serviceCfg.Routes) > 0 || len(serviceCfg.GeoRoutes) > 0 && geoip.Enabled()) {
+		return false
+	}
+	i.routeByCountry(xICAPMetadata)
+	i.routeByFileType(xICAPMetadata)
+	//returning the HTTP message as it is if its service was disabled at runtime from the admin API
+	if i.bypassDisabledService(xICAPMetadata) {
+		return true
+	}
+	//checking the file hash of the streaming media in the hash lists instead of scanning them
+	if p := streaming.Current(); early && i.isStreamingMedia(p) && p.Action == streaming.ActionHashOnly {
+		return false
+	}
+	if i.checkStreamingMediaHash(partial, xICAPMetadata) {
+		return true
+	}
+	//bypassing or blocking the HTTP message without scanning it if its client or tenant
+	//exceeded its quota of scanned bytes, no bytes are counted before the body is read
+	if q := quotas.Current(); early && q != nil && q.Action != quotas.ActionBypass {
+		if exceeded, _, _ := q.Exceeded(i.quotaKey(q)); exceeded {
+			return false
+		}
+	}
+	if i.enforceQuota(xICAPMetadata) {
+		return true
+	}
+	//selecting the scan profile which the ICAP client asked for, the vendor scans with its keys
+	if !early {
+		i.selectScanProfile(xICAPMetadata)
+	}
+	//forcing, relaxing or blocking the scan upon the User-Agent of the HTTP client if the service has policies for it
+	if rule := i.userAgentRule(); early && rule != nil && rule.Action != utils.UserAgentActionBypass {
+		return false
+	}
+	return i.applyUserAgentPolicy(xICAPMetadata)
+}
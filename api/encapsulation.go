@@ -0,0 +1,43 @@
+package api
+
+import (
+	"errors"
+	utils "icapeg/consts"
+	"icapeg/logging"
+)
+
+// missingEncapsulation returns what the ICAP request lacks of the HTTP messages which its method needs, a
+// REQMOD request needs the HTTP request and a RESPMOD request needs the HTTP response, the HTTP request of
+// RESPMOD is optional in RFC 3507
+func (i *ICAPRequest) missingEncapsulation() string {
+	switch i.methodName {
+	case utils.ICAPModeReq:
+		if i.req.Request == nil {
+			return "REQMOD request without an encapsulated HTTP request (req-hdr)"
+		}
+		if i.req.Request.URL == nil {
+			return "REQMOD request whose encapsulated HTTP request has no URL"
+		}
+	case utils.ICAPModeResp:
+		if i.req.Response == nil {
+			return "RESPMOD request without an encapsulated HTTP response (res-hdr)"
+		}
+	}
+	return ""
+}
+
+// validateEncapsulation answers the ICAP request with 400 if the HTTP message which its method processes is
+// missing, the connection is closed since a body without its header can't be skipped
+func (i *ICAPRequest) validateEncapsulation(xICAPMetadata string) error {
+	missing := i.missingEncapsulation()
+	if missing == "" {
+		return nil
+	}
+	logging.Logger.Error(utils.PrepareLogMsg(xICAPMetadata, "bad ICAP request to the service "+i.serviceName+
+		": "+missing+", Encapsulated: "+i.req.Header.Get("Encapsulated")))
+	i.w.Header().Set("Connection", "close")
+	i.w.WriteHeader(utils.BadRequestStatusCodeStr, nil, false)
+	i.w.Flush()
+	i.w.Abort()
+	return errors.New(missing)
+}
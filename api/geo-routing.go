@@ -0,0 +1,34 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/geoip"
+)
+
+// serverIPHeader is the ICAP header which has the IP address of the HTTP server (ProxySG and squid send it)
+const serverIPHeader = "X-Server-IP"
+
+// routeByCountry is a func to replace the service of the ICAP request with the service which the GeoIP routing
+// table of the service has for the country of the HTTP server, the server is the X-Server-IP of the ICAP client
+// or the resolved host of the HTTP request
+func (i *ICAPRequest) routeByCountry(xICAPMetadata string) {
+	routes := i.appCfg.ServicesInstances[i.serviceName].GeoRoutes
+	if len(routes) == 0 || i.routedFrom != "" || !geoip.Enabled() {
+		return
+	}
+	host := i.req.Request.Host
+	if host == "" && i.req.Request.URL != nil {
+		host = i.req.Request.URL.Host
+	}
+	country := geoip.Country(geoip.ServerIP(i.req.Header.Get(serverIPHeader), host))
+	if country == "" {
+		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "the country of the HTTP server "+host+" is unknown"))
+		return
+	}
+	serviceName, exists := routes[country]
+	if !exists || serviceName == i.serviceName {
+		return
+	}
+	i.routeTo(serviceName, "the HTTP message of the "+country+" server "+host, xICAPMetadata)
+}
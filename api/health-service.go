@@ -0,0 +1,44 @@
+package api
+
+import (
+	"errors"
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/health"
+	"icapeg/version"
+	"net/http"
+)
+
+// the ISTag of the ICAP responses of the health service
+const healthServiceISTag = "\"ICAPeg-health\""
+
+// isHealthService is a func to check if the URL path of the ICAP request is the reserved health service of
+// [app.health]
+func (i *ICAPRequest) isHealthService() bool {
+	name := health.ICAPService()
+	return name != "" && i.serviceName == name
+}
+
+// answerHealth is a func to answer the OPTIONS requests of the health service with 200 if ICAPeg is ready and
+// 503 if it isn't, so the ICAP clients which probe their ICAP servers with OPTIONS stop sending it transactions.
+// The other methods aren't allowed
+func (i *ICAPRequest) answerHealth(xICAPMetadata string) error {
+	err := errors.New("health service")
+	i.methodName = i.req.Method
+	if i.methodName != utils.ICAPModeOptions {
+		i.w.WriteHeader(utils.MethodNotAllowedForServiceCodeStr, nil, false)
+		return err
+	}
+	i.h["ISTag"] = []string{i.istagValue(healthServiceISTag)}
+	i.h.Set("Methods", utils.ICAPModeResp+", "+utils.ICAPModeReq)
+	i.h.Set("X-ICAP-Server", version.ServerHeader())
+	if !health.Ready() {
+		logging.Logger.Warn(utils.PrepareLogMsg(xICAPMetadata, "answering the health service with 503, ICAPeg isn't ready"))
+		i.h["X-ICAPeg-Health"] = []string{"not_ready"}
+		i.w.WriteHeader(utils.ServiceOverloadedCodeStr, nil, false)
+		return err
+	}
+	i.h["X-ICAPeg-Health"] = []string{"ready"}
+	i.w.WriteHeader(http.StatusOK, nil, false)
+	return err
+}
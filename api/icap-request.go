@@ -1,21 +1,32 @@
 package api
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"icapeg/config"
 	utils "icapeg/consts"
+	"icapeg/events"
 	http_message "icapeg/http-message"
 	"icapeg/icap"
 	"icapeg/logging"
 	"icapeg/service"
+	"icapeg/service/services-utilities/hotswap"
+	"icapeg/service/services-utilities/istag"
+	"icapeg/service/services-utilities/policies"
+	"icapeg/service/services-utilities/spool"
+	"icapeg/service/services-utilities/toggles"
+	"icapeg/service/services-utilities/toptalkers"
+	"icapeg/service/services-utilities/tracing"
+	"icapeg/version"
 	"io"
-	"io/ioutil"
-	"math/rand"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ICAPRequest struct is used to encapsulate important information of the ICAP request like method name, etc
@@ -24,24 +35,44 @@ type ICAPRequest struct {
 	req                    *icap.Request
 	h                      http.Header
 	Is204Allowed           bool
+	Is206Allowed           bool // the ICAP client appends the original body to a 206 response (Use-Original-Body)
+	rateLimited            bool // the request was taken from the rate limit of the service
 	isShadowServiceEnabled bool
 	appCfg                 *config.AppConfig
 	serviceName            string
+	tenant                 string
+	scanProfile            *config.ScanProfileConfig // nil if the service scans with the keys of its section
+	routedFrom             string
+	policy                 *policies.Rule // the policy rule which matched the transaction, nil if none did
+	deliveredBeforeScan    bool
+	body                   *spool.Body // the spooled body of the HTTP message, nil in OPTIONS mode
+	scannedBytes           int
+	quotaBytes             int // the scanned bytes which were counted in the quota, the preview before 100 Continue
+	verdict                string
+	threat                 string
+	vendorStatus           int    // the ICAP status code which the service returned
+	fileName               string // the name, the size and the SHA-256 of the scanned file, see recordFile
+	fileSize               int64
+	fileHash               string
+	recordedRequest        []byte
 	methodName             string
 	vendor                 string
+	vendorOptionsHeaders   map[string]string // the OPTIONS headers of the vendor, see service.OptionsHeaders
 	optionsReqHeaders      map[string]interface{}
 	optionsRespHeaders     map[string]interface{}
 	generalReqHeaders      map[string]interface{}
 	generalRespHeaders     map[string]interface{}
+	traceCtx               context.Context // the context of the span of the transaction, see tracing
 }
 
 // NewICAPRequest is a func to create a new instance from struct IcapRequest yo handle upcoming ICAP requests
 func NewICAPRequest(w icap.ResponseWriter, req *icap.Request) *ICAPRequest {
 	ICAPRequest := &ICAPRequest{
-		w:      w,
-		req:    req,
-		h:      w.Header(),
-		appCfg: config.App(),
+		w:        w,
+		req:      req,
+		h:        w.Header(),
+		appCfg:   config.App(),
+		traceCtx: context.Background(),
 	}
 	for serviceName, serviceInstance := range ICAPRequest.appCfg.ServicesInstances {
 		service.InitServiceConfig(serviceInstance.Vendor, serviceName)
@@ -52,19 +83,42 @@ func NewICAPRequest(w icap.ResponseWriter, req *icap.Request) *ICAPRequest {
 // RequestInitialization is a fun to retrieve the important information from the ICAP request
 // and initialize the ICAP response
 func (i *ICAPRequest) RequestInitialization() (string, error) {
-	xICAPMetadata := i.generateICAPReqMetaData(utils.ICAPRequestIdLen)
+	_, span := tracing.Start(i.traceCtx, "RequestInitialization")
+	defer span.End()
+	xICAPMetadata := utils.NewTransactionID()
+	// every ICAP response has the identifier of its transaction, so it's found in the logs of ICAPeg
+	i.h.Set(utils.TransactionIDHeader, xICAPMetadata)
+	logging.Logger.Info(utils.PrepareLogMsg(xICAPMetadata, "a request was sent to ICAPeg"))
 	logging.Logger.Info(utils.PrepareLogMsg(xICAPMetadata, "Validating the received ICAP request"))
 	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "Creating an instance from ICAPeg configuration"))
 	i.appCfg = config.App()
 
 	// checking if the service doesn't exist in toml file
-	// if it does not exist, the response will be 404 ICAP Service Not Found
+	// if it does not exist, the response will be 404 ICAP Service Not Found unless unknown_service says otherwise
 	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "checking if the service doesn't exist in toml file"))
 	i.serviceName = i.req.URL.Path[1:len(i.req.URL.Path)]
-	if !i.isServiceExists(xICAPMetadata) {
-		i.w.WriteHeader(utils.ICAPServiceNotFoundCodeStr, nil, false)
-		err := errors.New("service doesn't exist")
-		logging.Logger.Error(err.Error())
+	if i.isHealthService() {
+		return xICAPMetadata, i.answerHealth(xICAPMetadata)
+	}
+	// the services of a virtual host are resolved from its own table, without tenants and aliases
+	isVirtualHost, hostService := i.resolveVirtualHost(xICAPMetadata)
+	if !isVirtualHost {
+		if err := i.resolveTenant(xICAPMetadata); err != nil {
+			i.w.WriteHeader(utils.ICAPServiceNotFoundCodeStr, nil, false)
+			logging.Logger.Error(utils.PrepareLogMsg(xICAPMetadata, err.Error()))
+			return xICAPMetadata, err
+		}
+		i.resolveServiceAlias(xICAPMetadata)
+	} else if hostService != "" {
+		i.serviceName = hostService
+	}
+	if (isVirtualHost && hostService == "") || !i.isServiceExists(xICAPMetadata) {
+		return xICAPMetadata, i.answerUnknownService(xICAPMetadata)
+	}
+	utils.SetTransactionService(xICAPMetadata, i.serviceName)
+
+	// checking if the ICAP client may use the service, if it may not, the response will be 403 Forbidden
+	if err := i.authorize(xICAPMetadata); err != nil {
 		return xICAPMetadata, err
 	}
 
@@ -75,25 +129,46 @@ func (i *ICAPRequest) RequestInitialization() (string, error) {
 		if !i.isMethodAllowed(xICAPMetadata) {
 			i.w.WriteHeader(utils.MethodNotAllowedForServiceCodeStr, nil, false)
 			err := errors.New("method is not allowed")
-			logging.Logger.Error(err.Error())
+			logging.Logger.Error(utils.PrepareLogMsg(xICAPMetadata, err.Error()))
 			return xICAPMetadata, err
 		}
 		i.methodName = i.req.Method
 	}
 
+	// checking if the HTTP message which the method processes is encapsulated
+	// if it's missing, the response will be 400 Bad Request
+	if err := i.validateEncapsulation(xICAPMetadata); err != nil {
+		return xICAPMetadata, err
+	}
+
+	//applying the first policy rule which matches the transaction, it may replace its service
+	i.selectPolicy(xICAPMetadata)
+
 	//getting vendor name which depends on the name of the service
 	i.vendor = i.getVendorName(xICAPMetadata)
 
 	//adding important headers to options ICAP response
 	requiredService := service.GetService(i.vendor, i.serviceName, i.methodName,
 		&http_message.HttpMsg{Request: i.req.Request, Response: i.req.Response}, xICAPMetadata)
+	if requiredService == nil {
+		i.w.WriteHeader(utils.InternalServerErrStatusCodeStr, nil, false)
+		err := errors.New("the vendor " + i.vendor + " of " + i.serviceName + " service isn't registered")
+		logging.Logger.Error(utils.PrepareLogMsg(xICAPMetadata, err.Error()))
+		return xICAPMetadata, err
+	}
 	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "adding ISTAG Service Headers"))
-	i.addingISTAGServiceHeaders(requiredService.ISTagValue())
+	i.addingISTAGServiceHeaders(i.serviceISTag(requiredService))
+	//the vendors which have their own OPTIONS headers add them
+	if headers, hasHeaders := requiredService.(service.OptionsHeaders); hasHeaders && i.methodName == utils.ICAPModeOptions {
+		i.vendorOptionsHeaders = headers.OptionsHeaders()
+	}
 
 	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "checking if returning 24 to ICAP client is allowed or not"))
 	i.Is204Allowed = i.is204Allowed(xICAPMetadata)
+	i.Is206Allowed = strings.Contains(i.allowed(), strconv.Itoa(utils.PartialContentStatusCodeStr))
 
-	i.isShadowServiceEnabled = config.AppCfg.ServicesInstances[i.serviceName].ShadowService
+	i.isShadowServiceEnabled = i.appCfg.ServicesInstances[i.serviceName].ShadowService ||
+		(i.policy != nil && i.policy.Action == policies.ActionShadow)
 
 	//checking if the shadow service is enabled or not to apply shadow service mode
 	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
@@ -101,7 +176,8 @@ func (i *ICAPRequest) RequestInitialization() (string, error) {
 	if i.isShadowServiceEnabled && i.methodName != "OPTIONS" {
 		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "shadow service mode i on"))
 		i.shadowService(xICAPMetadata)
-		go i.RequestProcessing(xICAPMetadata)
+		shadowRequest := i.shadowCopy()
+		goBackground(func() { shadowRequest.RequestProcessing(xICAPMetadata) })
 		return xICAPMetadata, errors.New("shadow service")
 	} else {
 		if i.appCfg.DebuggingHeaders {
@@ -118,16 +194,52 @@ func (i *ICAPRequest) RequestInitialization() (string, error) {
 func (i *ICAPRequest) RequestProcessing(xICAPMetadata string) {
 	logging.Logger.Info(utils.PrepareLogMsg(xICAPMetadata,
 		"processing ICAP request upon the service and method required"))
+	defer utils.ForgetTransaction(xICAPMetadata)
+	var span trace.Span
+	i.traceCtx, span = tracing.Start(i.traceCtx, "RequestProcessing")
+	defer span.End()
+	//answering with 204 before reading the body if the verdict doesn't depend on it
+	if i.answerEarly(xICAPMetadata) {
+		return
+	}
+	//returning the streaming media as they are before reading their endless body
+	if i.bypassStreamingMedia(xICAPMetadata) {
+		return
+	}
+	//rejecting the request whose declared body exceeds the absolute limit before reading it
+	if i.rejectDeclaredOversize(xICAPMetadata) {
+		return
+	}
+	//bypassing or blocking the request whose declared body exceeds the max file size of the service before reading it
+	if i.enforceDeclaredSizeLimit(xICAPMetadata) {
+		return
+	}
 	partial := false
 	if i.methodName != utils.ICAPModeOptions {
-		file := &bytes.Buffer{}
 		fileLen := 0
 
 		if i.methodName == utils.ICAPModeResp {
-			io.Copy(file, i.req.Response.Body)
-			fileLen = file.Len()
-			i.req.Response.Header.Set(utils.ContentLength, strconv.Itoa(len(file.Bytes())))
-			i.req.Response.Body = io.NopCloser(bytes.NewBuffer(file.Bytes()))
+			//the body is spooled, the bytes above the memory limit of body_spooling are kept in a temporary
+			//file which is removed when the transaction ends
+			original := i.req.Response.Body
+			body, err := i.spoolBody(i.limitScannedBody(original))
+			if err != nil {
+				i.badRequest(err, xICAPMetadata)
+				return
+			}
+			defer body.Close()
+			if i.isOversize(int(body.Size())) {
+				i.rejectOversize(body.Size(), false, xICAPMetadata)
+				return
+			}
+			if i.enforceSizeLimit(body, original, xICAPMetadata) {
+				return
+			}
+			i.body = body
+			fileLen = int(body.Size())
+			i.scannedBytes = fileLen
+			i.req.Response.Header.Set(utils.ContentLength, strconv.Itoa(fileLen))
+			i.req.Response.Body = body.Open()
 
 		} else {
 			if i.req.Method == utils.ICAPModeReq {
@@ -139,11 +251,26 @@ func (i *ICAPRequest) RequestProcessing(xICAPMetadata string) {
 				} else {
 					i.req.OrgRequest = new
 				}
-				body, _ := ioutil.ReadAll(i.req.Request.Body)
-				i.req.OrgRequest.Body = io.NopCloser(bytes.NewBuffer(body))
+				original := i.req.Request.Body
+				body, err := i.spoolBody(i.limitScannedBody(original))
+				if err != nil {
+					i.badRequest(err, xICAPMetadata)
+					return
+				}
+				defer body.Close()
+				if i.isOversize(int(body.Size())) {
+					i.rejectOversize(body.Size(), false, xICAPMetadata)
+					return
+				}
+				if i.enforceSizeLimit(body, original, xICAPMetadata) {
+					return
+				}
+				i.body = body
+				i.scannedBytes = int(body.Size())
+				i.req.OrgRequest.Body = body.Open()
 				i.req.OrgRequest.Header = i.req.Request.Header
-				i.req.OrgRequest.Header.Set(utils.ContentLength, strconv.Itoa(len(body)))
-				i.req.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+				i.req.OrgRequest.Header.Set(utils.ContentLength, strconv.Itoa(i.scannedBytes))
+				i.req.Request.Body = body.Open()
 
 			}
 
@@ -162,6 +289,7 @@ func (i *ICAPRequest) RequestProcessing(xICAPMetadata string) {
 	}
 
 	i.HostHeader()
+	i.recordRequest()
 
 	// check the method name
 	switch i.methodName {
@@ -211,6 +339,13 @@ func (i *ICAPRequest) RespAndReqMods(partial bool, xICAPMetadata string) {
 	if i.req.Request == nil {
 		i.req.Request = &http.Request{}
 	}
+	//answering the HTTP message without scanning it if a decision which comes before the scan
+	//bypasses or blocks it (ex: a policy rule, the DNS policy or the quota)
+	if i.decideBeforeScan(false, partial, xICAPMetadata) {
+		return
+	}
+	//counting the scan by the vendor until it finishes, so a vendor which is swapped out at runtime is drained
+	defer hotswap.Begin(i.serviceName, i.vendor)()
 	//initialize the service by creating instance from the required service
 	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
 		"initialize the service by creating instance from the required service"))
@@ -223,9 +358,23 @@ func (i *ICAPRequest) RespAndReqMods(partial bool, xICAPMetadata string) {
 	// send request to services
 	///////////////// start service ////////////////////////////////////////////////////////////////////
 
+	//waiting for a free slot in the bulkheads of the tenant and the service, so a slow vendor
+	//or a busy tenant queues its own traffic only
+	if !i.limitRate(xICAPMetadata) {
+		return
+	}
+	release, acquired := i.acquireBulkheads(xICAPMetadata)
+	if !acquired {
+		return
+	}
+
 	//icap.Request.Response
+	scanStart := time.Now()
+	result, interim := i.processing(requiredService, partial, i.req.Header, release, xICAPMetadata)
+	scanLatency := time.Since(scanStart)
 	IcapStatusCode, httpMsg, serviceHeaders, httpMshHeadersBeforeProcessing, httpMshHeadersAfterProcessing,
-		vendorMsgs := requiredService.Processing(partial, i.req.Header)
+		vendorMsgs := result.IcapStatusCode, result.httpMsg, result.serviceHeaders, result.httpMshHeadersBeforeProcessing,
+		result.httpMshHeadersAfterProcessing, result.vendorMsgs
 
 	// adding the headers which the service wants to add them in the ICAP response
 	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
@@ -241,7 +390,24 @@ func (i *ICAPRequest) RespAndReqMods(partial bool, xICAPMetadata string) {
 	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
 		"checking if shadow service mode is enabled to add logs instead of returning another"))
 	if i.isShadowServiceEnabled {
-		//add logs here
+		i.recordShadowVerdict(result, scanLatency, xICAPMetadata)
+		return
+	}
+
+	i.verdict = verdictOf(vendorMsgs, interim != nil)
+	i.threat = vendorMsg(vendorMsgs, utils.VendorMsgThreat)
+	i.vendorStatus = IcapStatusCode
+	i.recordFile(vendorMsgs, xICAPMetadata)
+	i.addThreatHeaders(vendorMsgs)
+	i.notifyVerdict(httpMsg, vendorMsgs, xICAPMetadata)
+	i.compareShadow(result, scanLatency, partial || interim != nil, xICAPMetadata)
+
+	//the ICAP response was already started by trickling the original bytes or a patience page,
+	//so it's completed upon the verdict
+	if interim != nil {
+		IcapStatusCode = interim.finish(IcapStatusCode, httpMsg, vendorMsgs, xICAPMetadata)
+		i.allHeaders(IcapStatusCode, httpMshHeadersBeforeProcessing, httpMshHeadersAfterProcessing, vendorMsgs,
+			xICAPMetadata)
 		return
 	}
 
@@ -257,14 +423,29 @@ func (i *ICAPRequest) RespAndReqMods(partial bool, xICAPMetadata string) {
 			i.serviceName+" returned ICAP response with status code "+strconv.Itoa(utils.Continue)))
 		//in case the service returned 100 continue
 		//we will get the rest of the body from the client
-		httpMsgBody := i.preview(xICAPMetadata)
+		httpMsgBody, err := i.preview(xICAPMetadata)
+		if err != nil {
+			i.badRequest(err, xICAPMetadata)
+			return
+		}
+		defer httpMsgBody.Close()
+		if i.isOversize(int(httpMsgBody.Size())) {
+			i.rejectOversize(httpMsgBody.Size(), true, xICAPMetadata)
+			return
+		}
+		if i.enforceSizeLimit(httpMsgBody, nil, xICAPMetadata) {
+			return
+		}
 		i.methodName = i.req.Method
+		i.body = httpMsgBody
+		i.scannedBytes = int(httpMsgBody.Size())
 		if i.req.Method == utils.ICAPModeReq {
-			i.req.Request.Body = io.NopCloser(bytes.NewBuffer(httpMsgBody.Bytes()))
-			i.req.OrgRequest.Body = io.NopCloser(bytes.NewBuffer(httpMsgBody.Bytes()))
+			i.req.Request.Body = httpMsgBody.Open()
+			i.req.OrgRequest.Body = httpMsgBody.Open()
 		} else {
-			i.req.Response.Body = io.NopCloser(bytes.NewBuffer(httpMsgBody.Bytes()))
+			i.req.Response.Body = httpMsgBody.Open()
 		}
+		i.recordRequest()
 		i.allHeaders(IcapStatusCode, httpMshHeadersBeforeProcessing, httpMshHeadersAfterProcessing, vendorMsgs,
 			xICAPMetadata)
 		i.RespAndReqMods(false, xICAPMetadata)
@@ -275,21 +456,29 @@ func (i *ICAPRequest) RespAndReqMods(partial bool, xICAPMetadata string) {
 	case utils.NoModificationStatusCodeStr:
 		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
 			i.serviceName+" returned ICAP response with status code "+strconv.Itoa(utils.NoModificationStatusCodeStr)))
-		if i.Is204Allowed {
+		// a 204 is always allowed after a preview which isn't the whole body, the rest of the body wasn't read
+		if i.Is204Allowed || partial {
 			i.w.WriteHeader(utils.NoModificationStatusCodeStr, nil, false)
 		} else {
 
 			IcapStatusCode = utils.OkStatusCodeStr
 			if i.methodName == utils.ICAPModeReq {
 				IcapStatusCode = utils.OkStatusCodeStr
-				body, _ := ioutil.ReadAll(i.req.OrgRequest.Body)
-				i.req.Request.Body = io.NopCloser(bytes.NewBuffer(body))
-				i.req.Request.Header.Set(utils.ContentLength, strconv.Itoa(len(body)))
+				//the ICAP response writer sets the Content-Length from the spooled body
+				i.req.Request.Body = i.originalBody(i.req.OrgRequest.Body)
 				defer i.req.Request.Body.Close()
-				i.w.WriteHeader(utils.OkStatusCodeStr, i.req.Request, true)
+				if i.writePartialContent(i.req.Request, xICAPMetadata) {
+					IcapStatusCode = utils.PartialContentStatusCodeStr
+				} else {
+					i.w.WriteHeader(utils.OkStatusCodeStr, i.req.Request, true)
+				}
 			} else {
 				IcapStatusCode = utils.OkStatusCodeStr
-				i.w.WriteHeader(utils.OkStatusCodeStr, httpMsg, true)
+				if i.writePartialContent(httpMsg, xICAPMetadata) {
+					IcapStatusCode = utils.PartialContentStatusCodeStr
+				} else {
+					i.w.WriteHeader(utils.OkStatusCodeStr, httpMsg, true)
+				}
 			}
 
 			//i.w.WriteHeader(utils.OkStatusCodeStr, httpMsg, true)
@@ -297,7 +486,11 @@ func (i *ICAPRequest) RespAndReqMods(partial bool, xICAPMetadata string) {
 	case utils.OkStatusCodeStr:
 		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
 			i.serviceName+" returned ICAP response with status code "+strconv.Itoa(utils.OkStatusCodeStr)))
-		i.w.WriteHeader(utils.OkStatusCodeStr, httpMsg, true)
+		if i.writePartialContent(httpMsg, xICAPMetadata) {
+			IcapStatusCode = utils.PartialContentStatusCodeStr
+		} else {
+			i.w.WriteHeader(utils.OkStatusCodeStr, httpMsg, true)
+		}
 	case utils.BadRequestStatusCodeStr:
 		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
 			i.serviceName+" returned ICAP response with status code "+strconv.Itoa(utils.BadRequestStatusCodeStr)))
@@ -329,6 +522,37 @@ func (i *ICAPRequest) allHeaders(IcapStatusCode int, httpMshHeadersBeforeProcess
 	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, final))
 }
 
+// notifyVerdict is a func to notify the alerters about the detections and the unreachable vendors, the detected
+// files are quarantined
+func (i *ICAPRequest) notifyVerdict(httpMsg interface{}, vendorMsgs map[string]interface{}, xICAPMetadata string) {
+	i.publishVerdict(vendorMsgs, xICAPMetadata)
+	if vendorMsgs[utils.VendorMsgVerdict] == utils.SampleSeverityMalicious {
+		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "notifying the alerters about the detection"))
+		i.notifyDetection(vendorMsgs, xICAPMetadata)
+		i.quarantineFile(vendorMsgs, xICAPMetadata)
+		i.alteringBlockResponse(httpMsg)
+		toptalkers.Block(i.clientIP())
+	}
+	if vendorErr, exists := vendorMsgs[utils.VendorMsgError]; exists {
+		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "notifying the alerters that the vendor is unreachable"))
+		events.VendorFailed(&events.VendorStateEvent{
+			XICAPMetadata: xICAPMetadata,
+			ServiceName:   i.serviceName,
+			Tenant:        i.tenant,
+			Vendor:        i.vendor,
+			Error:         fmt.Sprint(vendorErr),
+		})
+	}
+}
+
+// notifyDetection is a func to send the verdict of the service to the alerters
+func (i *ICAPRequest) notifyDetection(vendorMsgs map[string]interface{}, xICAPMetadata string) {
+	if !events.Subscribed(events.TypeDetection) {
+		return
+	}
+	events.Detection(i.verdictEvent(vendorMsgs, xICAPMetadata))
+}
+
 // adding headers to the logging
 func (i *ICAPRequest) addHeadersToLogs(xICAPMetadata string) {
 	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "printing ICAP request headers in logs"))
@@ -336,7 +560,7 @@ func (i *ICAPRequest) addHeadersToLogs(xICAPMetadata string) {
 		res := key + " : "
 		innerRes := ""
 		for i := 0; i < len(element); i++ {
-			innerRes += element[i]
+			innerRes += logging.RedactHeader(key, element[i])
 			if i != len(element)-1 {
 				innerRes += ", "
 			}
@@ -362,6 +586,16 @@ func (i *ICAPRequest) isServiceExists(xICAPMetadata string) bool {
 
 }
 
+// resolveServiceAlias is a func to replace the service name with the service which its alias points to,
+// aliases are case-insensitive because the config keys are
+func (i *ICAPRequest) resolveServiceAlias(xICAPMetadata string) {
+	if serviceName, exists := i.appCfg.ServiceAliases[strings.ToLower(i.serviceName)]; exists {
+		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
+			"the requested service "+i.serviceName+" is an alias of "+serviceName+" service"))
+		i.serviceName = serviceName
+	}
+}
+
 // getMethodName is a func to get the name of the method of the ICAP request
 func (i *ICAPRequest) getMethodName(xICAPMetadata string) string {
 	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "getting the method name"))
@@ -378,9 +612,9 @@ func (i *ICAPRequest) isMethodAllowed(xICAPMetadata string) bool {
 	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
 		"checking if the method in the ICAP request is allowed in config.go file or not"))
 	if i.methodName == "RESPMOD" {
-		return i.appCfg.ServicesInstances[i.serviceName].RespMode
+		return i.appCfg.ServicesInstances[i.serviceName].RespMode && !toggles.IsMethodDisabled(i.serviceName, "RESPMOD")
 	} else if i.methodName == "REQMOD" {
-		return i.appCfg.ServicesInstances[i.serviceName].ReqMode
+		return i.appCfg.ServicesInstances[i.serviceName].ReqMode && !toggles.IsMethodDisabled(i.serviceName, "REQMOD")
 
 	}
 	if i.methodName == "OPTIONS" {
@@ -393,27 +627,40 @@ func (i *ICAPRequest) isMethodAllowed(xICAPMetadata string) bool {
 func (i *ICAPRequest) getVendorName(xICAPMetadata string) string {
 	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
 		"getting the vendor of the service which in the ICAP request"))
-	return i.appCfg.ServicesInstances[i.serviceName].Vendor
+	return hotswap.Vendor(i.serviceName, i.appCfg.ServicesInstances[i.serviceName].Vendor)
 }
 
 // addingISTAGServiceHeaders is a func to add the important header to ICAP response
 func (i *ICAPRequest) addingISTAGServiceHeaders(ISTgValue string) {
-	i.h["ISTag"] = []string{ISTgValue}
+	i.h["ISTag"] = []string{i.istagValue(ISTgValue)}
 	i.h["Service"] = []string{i.appCfg.ServicesInstances[i.serviceName].ServiceCaption}
 }
 
+// serviceISTag is a func to get the ISTag of the service from its configuration hash and the definition version
+// of its vendor, the version is the one which the vendor returns with SignatureVersion or the one which is polled
+// in [app.istag] for it
+func (i *ICAPRequest) serviceISTag(requiredService service.Service) string {
+	var version string
+	if versioned, ok := requiredService.(service.SignatureVersion); ok {
+		version = versioned.SignatureVersion()
+	} else if polled, exists := istag.Version(i.vendor); exists {
+		version = polled
+	}
+	return istag.ServiceTag(i.serviceName, i.appCfg.ServicesInstances[i.serviceName].Keys.Hash(), version)
+}
+
 // is204Allowed is a func to check if ICAP request has the header "204 : Allowed" or not
 func (i *ICAPRequest) is204Allowed(xICAPMetadata string) bool {
 	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
 		"checking if (Allow : 204) header exists in ICAP request"))
 	Is204Allowed := false
 	if _, exist := i.req.Header["Allow"]; exist &&
-		i.req.Header.Get("Allow") == strconv.Itoa(utils.NoModificationStatusCodeStr) {
+		i.allowed() == strconv.Itoa(utils.NoModificationStatusCodeStr) {
 		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
 			"Allow : 204 header exists in ICAP request"))
 		Is204Allowed = true
 	} else if _, exist := i.req.Header["Allow"]; exist &&
-		strings.Contains(i.req.Header.Get("Allow"), strconv.Itoa(utils.NoModificationStatusCodeStr)) {
+		strings.Contains(i.allowed(), strconv.Itoa(utils.NoModificationStatusCodeStr)) {
 		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
 			"Allow : 204 header exists in ICAP request"))
 		Is204Allowed = true
@@ -422,6 +669,12 @@ func (i *ICAPRequest) is204Allowed(xICAPMetadata string) bool {
 	return Is204Allowed
 }
 
+// bypass204Allowed reports whether the HTTP message which is bypassed may be returned with a 204, a 204 is always
+// allowed after a preview which isn't the whole body
+func (i *ICAPRequest) bypass204Allowed() bool {
+	return i.Is204Allowed || (i.req.Header.Get("Preview") != "" && i.req.EndIndicator != "0; ieof")
+}
+
 // shadowService is a func to apply the shadow service
 func (i *ICAPRequest) shadowService(xICAPMetadata string) {
 	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
@@ -433,37 +686,68 @@ func (i *ICAPRequest) shadowService(xICAPMetadata string) {
 		i.h["X-ICAPeg-Shadow-Service"] = []string{"true"}
 	}
 	if i.Is204Allowed { // following RFC3507, if the request has Allow: 204 header, it is to be checked and if it doesn't exists, return the request as it is to the ICAP client, https://tools.ietf.org/html/rfc3507#section-4.6
+		// the rest of the body is discarded once the request is answered, so it's spooled before the 204
+		// for the shadow service to scan it in the background
+		var err error
+		if i.req.Method == "REQMOD" && i.req.Request != nil {
+			err = i.spoolShadowBody(&i.req.Request.Body)
+		} else if i.req.Method == "RESPMOD" && i.req.Response != nil {
+			err = i.spoolShadowBody(&i.req.Response.Body)
+		}
+		if err != nil {
+			i.badRequest(err, xICAPMetadata)
+			return
+		}
 		i.w.WriteHeader(utils.NoModificationStatusCodeStr, nil, false)
 	} else {
+		// the body is spooled before it's returned, so the shadow service scans it again from the spool
 		if i.req.Method == "REQMOD" {
+			body, err := i.spoolBody(i.req.Request.Body)
+			if err != nil {
+				i.badRequest(err, xICAPMetadata)
+				return
+			}
+			i.req.Request.Body = body.Open()
 			i.w.WriteHeader(utils.OkStatusCodeStr, i.req.Request, true)
-			tempBody, _ := ioutil.ReadAll(i.req.Request.Body)
-			i.w.Write(tempBody)
-			i.req.Request.Body = io.NopCloser(bytes.NewBuffer(tempBody))
+			i.req.Request.Body = body.Open()
 		} else if i.req.Method == "RESPMOD" {
+			body, err := i.spoolBody(i.req.Response.Body)
+			if err != nil {
+				i.badRequest(err, xICAPMetadata)
+				return
+			}
+			i.req.Response.Body = body.Open()
 			i.w.WriteHeader(utils.OkStatusCodeStr, i.req.Response, true)
-			tempBody, _ := ioutil.ReadAll(i.req.Response.Body)
-			i.w.Write(tempBody)
-			i.req.Response.Body = io.NopCloser(bytes.NewBuffer(tempBody))
+			i.req.Response.Body = body.Open()
 		}
 	}
 }
 
+// spoolShadowBody is a func to replace the body of the HTTP message with its spooled copy
+func (i *ICAPRequest) spoolShadowBody(body *io.ReadCloser) error {
+	if *body == nil {
+		return nil
+	}
+	spooled, err := i.spoolBody(*body)
+	if err != nil {
+		return err
+	}
+	*body = spooled.Open()
+	return nil
+}
+
 // getEnabledMethods is a func get all enable method of a specific service
 func (i *ICAPRequest) getEnabledMethods(xICAPMetadata string) string {
 	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
 		"getting all enable method of a specific service)"))
 	var allMethods []string
-	if i.appCfg.ServicesInstances[i.serviceName].RespMode {
+	if i.appCfg.ServicesInstances[i.serviceName].RespMode && !toggles.IsMethodDisabled(i.serviceName, "RESPMOD") {
 		allMethods = append(allMethods, "RESPMOD")
 	}
-	if i.appCfg.ServicesInstances[i.serviceName].ReqMode {
+	if i.appCfg.ServicesInstances[i.serviceName].ReqMode && !toggles.IsMethodDisabled(i.serviceName, "REQMOD") {
 		allMethods = append(allMethods, "REQMOD")
 	}
-	if len(allMethods) == 1 {
-		return allMethods[0]
-	}
-	return allMethods[0] + ", " + allMethods[1]
+	return strings.Join(allMethods, ", ")
 }
 
 func (i *ICAPRequest) servicePreview() (bool, string) {
@@ -477,6 +761,10 @@ func (i *ICAPRequest) optionsMode(serviceName, xICAPMetadata string) {
 		"preparing headers in OPTIONS mode response"))
 	i.h.Set("Methods", i.getEnabledMethods(xICAPMetadata))
 	i.h.Set("Allow", "204")
+	// the ICAP client sends Allow: 206 only if the service answers with the parts of the bodies which changed
+	if i.appCfg.ServicesInstances[serviceName].UseOriginalBody {
+		i.h.Set("Allow", "204, 206")
+	}
 	// Add preview if preview_enabled is true in config.go
 	previewEnabled, previewBytes := i.servicePreview()
 	if previewEnabled == true {
@@ -485,21 +773,31 @@ func (i *ICAPRequest) optionsMode(serviceName, xICAPMetadata string) {
 		}
 	}
 	i.h.Set("Transfer-Preview", utils.Any)
-	i.w.WriteHeader(http.StatusOK, nil, false)
+	// the build of ICAPeg, so the fleets can audit which build every gateway runs
+	i.h.Set("X-ICAP-Server", version.ServerHeader())
+	i.addingProfileOptionsHeaders(xICAPMetadata)
+	for key, value := range i.vendorOptionsHeaders {
+		i.h.Set(key, value)
+	}
+	// the capability document of the service in the opt-body if options_body is enabled
+	if optBody := i.optionsBodyDocument(xICAPMetadata); optBody != nil {
+		i.h.Set("Opt-body-type", optionsBodyType)
+		i.w.WriteHeader(http.StatusOK, nil, true)
+		i.w.Write(optBody)
+	} else {
+		i.w.WriteHeader(http.StatusOK, nil, false)
+	}
 	i.optionsRespHeaders = i.LogICAPResHeaders(http.StatusOK)
 }
 
 // preview function is used to get the rest of the http message from the client after sending
 // a preview about the body first
-func (i *ICAPRequest) preview(xICAPMetadata string) *bytes.Buffer {
+func (i *ICAPRequest) preview(xICAPMetadata string) (*spool.Body, error) {
 	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
 		"getting the rest of the body from client after the service returned ICAP "+
 			"response with status code"+strconv.Itoa(utils.Continue)))
-	r := icap.GetTheRest()
-	c := io.NopCloser(r)
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(c)
-	return buf
+	r := i.req.Rest()
+	return spool.Read(i.limitBody(i.limitScannedBody(r)))
 }
 
 func (i *ICAPRequest) LogICAPReqHeaders() map[string]interface{} {
@@ -513,7 +811,7 @@ func (i *ICAPRequest) LogICAPReqHeaders() map[string]interface{} {
 				values += ", "
 			}
 		}
-		reqHeaders[key] = value
+		reqHeaders[key] = logging.RedactHeaderValues(key, value)
 	}
 	return reqHeaders
 }
@@ -529,17 +827,7 @@ func (i *ICAPRequest) LogICAPResHeaders(statusCode int) map[string]interface{} {
 				values += ", "
 			}
 		}
-		respHeaders[key] = value
+		respHeaders[key] = logging.RedactHeaderValues(key, value)
 	}
 	return respHeaders
 }
-
-func (i *ICAPRequest) generateICAPReqMetaData(size int) string {
-
-	name := make([]rune, size)
-	var letterRunes = []rune(utils.IdentifierString)
-	for i := range name {
-		name[i] += letterRunes[rand.Intn(len(letterRunes))]
-	}
-	return string(name)
-}
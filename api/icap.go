@@ -1,22 +1,46 @@
 package api
 
 import (
+	utils "icapeg/consts"
 	"icapeg/icap"
-	"icapeg/logging"
+	"icapeg/recording"
+	"icapeg/service/services-utilities/tracing"
+	"time"
 )
 
 // ToICAPEGServe is the ICAsP Request Handler for all modes and services:
 func ToICAPEGServe(w icap.ResponseWriter, req *icap.Request) {
-	logging.Logger.Info("a request was sent to ICAPeg")
+	start := time.Now()
+	var recorder *recordingWriter
+	if recording.Enabled() {
+		recorder = newRecordingWriter(w)
+		w = recorder
+	}
+	accessWriter := &accessLogWriter{ResponseWriter: w}
+	//the span of the transaction is the child of the trace context which the ICAP client sent
+	ctx, span := tracing.StartTransaction(req)
+	_, newSpan := tracing.Start(ctx, "NewICAPRequest")
 	//Creating new instance from struct IcapRequest yo handle upcoming ICAP requests
-	ICAPRequest := NewICAPRequest(w, req)
+	ICAPRequest := NewICAPRequest(accessWriter, req)
+	ICAPRequest.traceCtx = ctx
+	newSpan.End()
 
 	//calling RequestInitialization to retrieve the important information from the ICAP request
 	//and initialize the ICAP response
 	xICAPMetadata, err := ICAPRequest.RequestInitialization()
+	defer ICAPRequest.endTrace(span, accessWriter, xICAPMetadata)
+	defer ICAPRequest.logAccess(accessWriter, start, xICAPMetadata)
+	defer ICAPRequest.recordMetrics(accessWriter, start)
+	defer ICAPRequest.saveRecording(recorder, xICAPMetadata)
 	if err != nil {
+		// the shadow service keeps processing the request in the background
+		if !ICAPRequest.isShadowServiceEnabled {
+			utils.ForgetTransaction(xICAPMetadata)
+		}
 		return
 	}
+	defer ICAPRequest.countTalker()
+	defer ICAPRequest.recordStatistics(start)
 	// after initialization, we call RequestProcessing func to process the ICAP request with a service
 	ICAPRequest.RequestProcessing(xICAPMetadata)
 }
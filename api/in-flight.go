@@ -0,0 +1,29 @@
+package api
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// the number of the scans of every service which haven't returned yet
+var inFlightScans sync.Map
+
+// scanStarted counts a scan of the service as in flight until the returned func is called
+func scanStarted(serviceName string) func() {
+	counter, _ := inFlightScans.LoadOrStore(serviceName, new(int64))
+	atomic.AddInt64(counter.(*int64), 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() { atomic.AddInt64(counter.(*int64), -1) })
+	}
+}
+
+// InFlightScans returns the number of the scans of every service which haven't returned yet
+func InFlightScans() map[string]int64 {
+	result := make(map[string]int64)
+	inFlightScans.Range(func(serviceName, counter interface{}) bool {
+		result[serviceName.(string)] = atomic.LoadInt64(counter.(*int64))
+		return true
+	})
+	return result
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	general_functions "icapeg/service/services-utilities/general-functions"
+	"icapeg/service/services-utilities/spool"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// maxWaitFallback holds the copies of the original HTTP message which are used if the service
+// doesn't return its verdict in the max wait of the service
+type maxWaitFallback struct {
+	request  *http.Request
+	response *http.Response
+	body     *spool.Body
+}
+
+// newMaxWaitFallback copies the original HTTP message before the service processes it, response is
+// the headers of the HTTP response and body is its body in RESPMOD
+func (i *ICAPRequest) newMaxWaitFallback(response *http.Response, body *spool.Body) *maxWaitFallback {
+	fallback := &maxWaitFallback{response: response, body: body}
+	if i.req.Request != nil {
+		request := *i.req.Request
+		request.Header = i.req.Request.Header.Clone()
+		if i.req.Request.URL != nil {
+			requestURL := *i.req.Request.URL
+			request.URL = &requestURL
+		}
+		fallback.request = &request
+	}
+	if i.methodName == utils.ICAPModeReq && i.req.OrgRequest != nil && i.req.OrgRequest.Body != nil {
+		fallback.body, _ = bodyOf(&i.req.OrgRequest.Body)
+	}
+	return fallback
+}
+
+// openBody returns a reader of the original body from its start
+func (f *maxWaitFallback) openBody() io.ReadCloser {
+	if f.body == nil {
+		return http.NoBody
+	}
+	return f.body.Open()
+}
+
+// size returns the size of the original body
+func (f *maxWaitFallback) size() int64 {
+	if f.body == nil {
+		return 0
+	}
+	return f.body.Size()
+}
+
+// awaitVerdict waits for the result of the service, if the service has a max wait and the result doesn't arrive
+// in it, the HTTP message is bypassed or blocked according to the max wait action of the service
+func (i *ICAPRequest) awaitVerdict(result <-chan processingResult, start time.Time, fallback *maxWaitFallback,
+	xICAPMetadata string) processingResult {
+	if fallback == nil {
+		return <-result
+	}
+	cfg := i.appCfg.ServicesInstances[i.serviceName].MaxWait
+	timer := time.NewTimer(cfg.Timeout - time.Since(start))
+	defer timer.Stop()
+	select {
+	case r := <-result:
+		return r
+	case <-timer.C:
+	}
+
+	logging.Logger.Warn(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventMaxWaitExceeded, map[string]interface{}{
+		"service":  i.serviceName,
+		"method":   i.methodName,
+		"action":   cfg.Action,
+		"max_wait": cfg.Timeout.String(),
+	}))
+	// the service keeps processing in the background, so a late detection still notifies the alerters
+	goBackground(func() {
+		r := <-result
+		i.notifyVerdict(r.httpMsg, r.vendorMsgs, xICAPMetadata)
+	})
+	vendorMsgs := map[string]interface{}{utils.VendorMsgMaxWait: cfg.Action}
+	if cfg.Action == utils.MaxWaitActionBlock {
+		return i.maxWaitBlock(fallback, vendorMsgs, xICAPMetadata)
+	}
+	if i.Is204Allowed {
+		return processingResult{IcapStatusCode: utils.NoModificationStatusCodeStr, vendorMsgs: vendorMsgs}
+	}
+	r := processingResult{IcapStatusCode: utils.OkStatusCodeStr, vendorMsgs: vendorMsgs}
+	if fallback.response != nil {
+		response := *fallback.response
+		response.Body = fallback.openBody()
+		r.httpMsg = &response
+	} else if fallback.request != nil {
+		fallback.request.Body = fallback.openBody()
+		fallback.request.Header.Set(utils.ContentLength, strconv.FormatInt(fallback.size(), 10))
+		r.httpMsg = fallback.request
+	}
+	return r
+}
+
+// maxWaitBlock returns the block page which replaces the HTTP message whose verdict took longer than the max wait
+func (i *ICAPRequest) maxWaitBlock(fallback *maxWaitFallback, vendorMsgs map[string]interface{},
+	xICAPMetadata string) processingResult {
+	if fallback.request == nil {
+		fallback.request = &http.Request{Header: http.Header{}}
+	}
+	if fallback.request.URL == nil {
+		fallback.request.URL = &url.URL{}
+	}
+	generalFunc := general_functions.NewGeneralFunc(&http_message.HttpMsg{Request: fallback.request}, xICAPMetadata)
+	fileSize := strconv.FormatInt(fallback.size(), 10)
+	if i.methodName == utils.ICAPModeResp {
+		htmlPage := generalFunc.GenHtmlPage(utils.BlockPagePath, utils.ErrPageReasonScanTimedOut, i.serviceName, "-",
+			fallback.request.RequestURI, fileSize, xICAPMetadata)
+		response := generalFunc.ErrPageResp(http.StatusForbidden, htmlPage.Len())
+		response.Body = io.NopCloser(htmlPage)
+		i.alteringBlockResponse(response)
+		return processingResult{IcapStatusCode: utils.OkStatusCodeStr, httpMsg: response, vendorMsgs: vendorMsgs}
+	}
+	htmlPage, request, err := generalFunc.ReqModErrPage(utils.ErrPageReasonScanTimedOut, i.serviceName, "-", fileSize)
+	if err != nil {
+		logging.Logger.Error(utils.PrepareLogMsg(xICAPMetadata, "couldn't prepare the block page: "+err.Error()))
+		return processingResult{IcapStatusCode: utils.InternalServerErrStatusCodeStr, vendorMsgs: vendorMsgs}
+	}
+	request.Body = io.NopCloser(htmlPage)
+	return processingResult{IcapStatusCode: utils.OkStatusCodeStr, httpMsg: request, vendorMsgs: vendorMsgs}
+}
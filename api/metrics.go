@@ -0,0 +1,26 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	"icapeg/service/services-utilities/metrics"
+	"time"
+)
+
+// recordMetrics is a func to count the ICAP request and its response in the Prometheus metrics, the requests
+// of the services which don't exist are counted as the unknown service so a scanner can't add series
+func (i *ICAPRequest) recordMetrics(w *accessLogWriter, start time.Time) {
+	if !metrics.Enabled() {
+		return
+	}
+	serviceName := i.serviceName
+	if _, exists := i.appCfg.ServicesInstances[serviceName]; !exists {
+		serviceName = "unknown"
+	}
+	method := i.req.Method
+	switch method {
+	case utils.ICAPModeReq, utils.ICAPModeResp, utils.ICAPModeOptions:
+	default:
+		method = "other"
+	}
+	metrics.RecordRequest(serviceName, method, w.statusCode, time.Since(start))
+}
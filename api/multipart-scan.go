@@ -0,0 +1,101 @@
+package api
+
+import (
+	"bytes"
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/formdata"
+	"io"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// scanMultipart is a func to scan each file of a multipart/form-data upload in REQMOD with the vendor of the
+// service if it has a multipart_scan section, instead of scanning the form as one blob. A malicious file blocks
+// the whole request with the block policy, or it's removed from the form with the strip policy and the request
+// is sent on with the other parts. It returns true if the upload got its verdict, false if it's scanned as a whole
+// like the other HTTP messages (ex: it isn't a form, it has no file or the vendor failed to scan one of its files)
+func (i *ICAPRequest) scanMultipart(icapHeader textproto.MIMEHeader, xICAPMetadata string) (processingResult, bool) {
+	cfg := i.appCfg.ServicesInstances[i.serviceName].MultipartScan
+	if cfg == nil || i.methodName != utils.ICAPModeReq {
+		return processingResult{}, false
+	}
+	boundary, isForm := formdata.Boundary(i.req.Request.Header.Get(utils.ContentType))
+	if !isForm {
+		return processingResult{}, false
+	}
+	body, err := bodyOf(&i.req.Request.Body)
+	if err != nil {
+		return processingResult{}, false
+	}
+	parts, err := formdata.ParseFrom(body.Open(), boundary, cfg.MaxParts)
+	if err != nil {
+		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "scanning the upload as a whole, couldn't parse it: "+
+			err.Error()))
+		return processingResult{}, false
+	}
+
+	var verdict processingResult
+	var kept []*formdata.Part
+	var stripped []string
+	files := 0
+	for _, p := range parts {
+		if !p.IsFile() {
+			kept = append(kept, p)
+			continue
+		}
+		files++
+		r := i.scanMember(path.Base(p.FileName), p.Data, icapHeader, xICAPMetadata)
+		if r.IcapStatusCode == utils.InternalServerErrStatusCodeStr || r.IcapStatusCode == utils.RequestTimeOutStatusCodeStr {
+			logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "scanning the upload as a whole, the vendor couldn't scan "+
+				p.FileName))
+			return processingResult{}, false
+		}
+		if r.vendorMsgs[utils.VendorMsgVerdict] != utils.SampleSeverityMalicious {
+			kept = append(kept, p)
+			continue
+		}
+		if stripped == nil {
+			r.vendorMsgs[utils.VendorMsgFormFile] = p.FileName
+			verdict = r
+		}
+		stripped = append(stripped, p.FileName)
+		if cfg.InfectedPolicy == utils.MultipartPolicyBlock {
+			break
+		}
+	}
+	if files == 0 {
+		return processingResult{}, false
+	}
+	if stripped == nil {
+		return processingResult{IcapStatusCode: utils.NoModificationStatusCodeStr, httpMsg: i.req.Request,
+			vendorMsgs: make(map[string]interface{})}, true
+	}
+
+	if cfg.InfectedPolicy == utils.MultipartPolicyBlock {
+		logging.Logger.Info(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventFormFileBlocked, map[string]interface{}{
+			"service": i.serviceName,
+			"file":    verdict.vendorMsgs[utils.VendorMsgFormFile],
+			"threat":  vendorMsg(verdict.vendorMsgs, utils.VendorMsgThreat),
+		}))
+		return verdict, true
+	}
+	rebuilt, err := formdata.Build(kept, boundary)
+	if err != nil {
+		// the malicious files can't be removed, so the request is blocked
+		logging.Logger.Error(utils.PrepareLogMsg(xICAPMetadata, "couldn't rebuild the upload, blocking it: "+err.Error()))
+		return verdict, true
+	}
+	logging.Logger.Info(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventFormFileStripped, map[string]interface{}{
+		"service": i.serviceName,
+		"files":   strings.Join(stripped, ", "),
+		"threat":  vendorMsg(verdict.vendorMsgs, utils.VendorMsgThreat),
+	}))
+	verdict.vendorMsgs[utils.VendorMsgStrippedFiles] = stripped
+	i.req.Request.Body = io.NopCloser(bytes.NewReader(rebuilt))
+	i.req.Request.Header.Set(utils.ContentLength, strconv.Itoa(len(rebuilt)))
+	return processingResult{IcapStatusCode: utils.OkStatusCodeStr, httpMsg: i.req.Request,
+		serviceHeaders: verdict.serviceHeaders, vendorMsgs: verdict.vendorMsgs}, true
+}
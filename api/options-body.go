@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/bulkhead"
+	"icapeg/service/services-utilities/hotswap"
+	"icapeg/service/services-utilities/rules"
+	"icapeg/version"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// the Opt-body-type of the capability document in the opt-body of the OPTIONS responses
+const optionsBodyType = "application/json"
+
+// optionsBody is the capability document of a service in the opt-body of its OPTIONS responses
+type optionsBody struct {
+	Server        version.BuildInfo `json:"server"`
+	Service       string            `json:"service"`
+	Caption       string            `json:"caption"`
+	Vendor        string            `json:"vendor"`
+	Vendors       []string          `json:"vendors"` // the vendors of the service and of the services it routes to
+	Methods       []string          `json:"methods"`
+	Limits        optionsBodyLimits `json:"limits"`
+	PolicyVersion string            `json:"policy_version,omitempty"`
+	Rules         *optionsBodyRules `json:"rules,omitempty"`
+}
+
+type optionsBodyLimits struct {
+	MaxFileSize   int  `json:"max_file_size"`           // bytes, 0 = unlimited
+	PreviewBytes  *int `json:"preview_bytes,omitempty"` // missing if the previews are disabled
+	MaxConcurrent int  `json:"max_concurrent"`          // 0 = unlimited
+}
+
+type optionsBodyRules struct {
+	Rules      int        `json:"rules"`
+	LastReload *time.Time `json:"last_reload,omitempty"`
+}
+
+// optionsBodyDocument is a func to build the capability document of the service, it returns nil if the
+// opt-body is disabled
+func (i *ICAPRequest) optionsBodyDocument(xICAPMetadata string) []byte {
+	if i.appCfg.OptionsBody == nil {
+		return nil
+	}
+	serviceInstance := i.appCfg.ServicesInstances[i.serviceName]
+	doc := optionsBody{
+		Server:        version.Info(),
+		Service:       i.serviceName,
+		Caption:       serviceInstance.ServiceCaption,
+		Vendor:        hotswap.Vendor(i.serviceName, serviceInstance.Vendor),
+		Methods:       []string{},
+		PolicyVersion: i.appCfg.OptionsBody.PolicyVersion,
+		Limits: optionsBodyLimits{
+			MaxFileSize:   serviceInstance.MaxFileSize,
+			MaxConcurrent: bulkhead.MaxConcurrent(i.serviceName),
+		},
+	}
+	if serviceInstance.RespMode {
+		doc.Methods = append(doc.Methods, utils.ICAPModeResp)
+	}
+	if serviceInstance.ReqMode {
+		doc.Methods = append(doc.Methods, utils.ICAPModeReq)
+	}
+	if previewEnabled, previewBytes := i.servicePreview(); previewEnabled {
+		if pb, err := strconv.Atoi(previewBytes); err == nil && pb >= 0 {
+			doc.Limits.PreviewBytes = &pb
+		}
+	}
+
+	vendors := map[string]bool{doc.Vendor: true}
+	targets := make([]string, 0, len(serviceInstance.Routes)+len(serviceInstance.GeoRoutes)+1)
+	for _, target := range serviceInstance.Routes {
+		targets = append(targets, target)
+	}
+	for _, target := range serviceInstance.GeoRoutes {
+		targets = append(targets, target)
+	}
+	if serviceInstance.ConnectFilter != nil && serviceInstance.ConnectFilter.Service != "" {
+		targets = append(targets, serviceInstance.ConnectFilter.Service)
+	}
+	for _, target := range targets {
+		if targetInstance, exists := i.appCfg.ServicesInstances[target]; exists {
+			vendors[hotswap.Vendor(target, targetInstance.Vendor)] = true
+		}
+	}
+	for vendor := range vendors {
+		doc.Vendors = append(doc.Vendors, vendor)
+	}
+	sort.Strings(doc.Vendors)
+
+	if status, enabled := rules.CurrentStatus(); enabled {
+		doc.Rules = &optionsBodyRules{Rules: status.Rules, LastReload: status.LastReload}
+	}
+
+	body, err := json.Marshal(doc)
+	if err != nil {
+		logging.Logger.Error(utils.PrepareLogMsg(xICAPMetadata, "couldn't encode the OPTIONS opt-body: "+err.Error()))
+		return nil
+	}
+	return body
+}
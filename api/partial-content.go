@@ -0,0 +1,102 @@
+package api
+
+import (
+	"bytes"
+	utils "icapeg/consts"
+	"icapeg/icap"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/spool"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// minOriginalSuffix is the shortest end of the original body which is answered with 206, unless it's the whole
+// original body, so the small bodies aren't split for a few bytes
+const minOriginalSuffix = 4096
+
+// writePartialContent is a func to answer with 206 Partial Content if the service has use_original_body and the
+// ICAP client allows 206: the HTTP message is sent with the part of its body which precedes the unchanged end of
+// the original body, and the ICAP client appends that end from the body it already has (use-original-body)
+// instead of receiving it again. It returns true if the ICAP request was answered
+func (i *ICAPRequest) writePartialContent(httpMsg interface{}, xICAPMetadata string) bool {
+	serviceInstance := i.appCfg.ServicesInstances[i.serviceName]
+	if !i.Is206Allowed || serviceInstance == nil || !serviceInstance.UseOriginalBody || i.body == nil {
+		return false
+	}
+	var header http.Header
+	var body *io.ReadCloser
+	switch msg := httpMsg.(type) {
+	case *http.Request:
+		header, body = msg.Header, &msg.Body
+	case *http.Response:
+		header, body = msg.Header, &msg.Body
+	default:
+		return false
+	}
+	if *body == nil || header == nil {
+		return false
+	}
+	part, offset, found := originalSuffix(body, i.body)
+	if !found {
+		return false
+	}
+
+	length := int64(len(part)) + i.body.Size() - offset
+	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "answering with 206, "+strconv.Itoa(len(part))+
+		" bytes are sent and the ICAP client appends the original body from "+strconv.FormatInt(offset, 10)))
+	header.Del("Transfer-Encoding")
+	header.Set(utils.ContentLength, strconv.FormatInt(length, 10))
+	*body = icap.NewBody(part)
+	i.w.Header().Set(icap.UseOriginalBodyHeader, strconv.FormatInt(offset, 10))
+	i.w.WriteHeader(utils.PartialContentStatusCodeStr, httpMsg, true)
+	return true
+}
+
+// originalSuffix returns the part of the body which precedes its longest end which is the end of the original
+// body too, and the offset of that end in the original body. It returns false if the end is shorter than
+// minOriginalSuffix and isn't the whole original body, the body is put back for the 200 response then
+func originalSuffix(body *io.ReadCloser, original *spool.Body) ([]byte, int64, bool) {
+	// the original body which is returned as it is isn't read
+	if spooled, isSpooled := spool.Of(*body); isSpooled && spooled == original {
+		return nil, 0, true
+	}
+	data, err := io.ReadAll(*body)
+	(*body).Close()
+	*body = icap.NewBody(data)
+	if err != nil {
+		return nil, 0, false
+	}
+
+	// the ends are compared backwards, one block at a time
+	size := original.Size()
+	common := int64(0)
+	block := make([]byte, 32*1024)
+	for common < size && common < int64(len(data)) {
+		n := int64(len(block))
+		if remaining := size - common; remaining < n {
+			n = remaining
+		}
+		if remaining := int64(len(data)) - common; remaining < n {
+			n = remaining
+		}
+		got, _ := original.ReadAt(block[:n], size-common-n)
+		if int64(got) != n {
+			break
+		}
+		tail := data[int64(len(data))-common-n : int64(len(data))-common]
+		if bytes.Equal(tail, block[:n]) {
+			common += n
+			continue
+		}
+		// the first difference from the end of the block
+		for k := n - 1; k >= 0 && tail[k] == block[k]; k-- {
+			common++
+		}
+		break
+	}
+	if common == 0 || (common < minOriginalSuffix && common != size) {
+		return nil, 0, false
+	}
+	return data[:int64(len(data))-common], size - common, true
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/spool"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// ScanPartialHeader tags the HTTP messages whose first max_filesize bytes only were scanned
+const ScanPartialHeader = "X-Scan-Partial"
+
+// truncateOversize is a func to give the service the first max_filesize bytes of a larger body if the service
+// scans them instead of bypassing or blocking the whole file, it returns the func which returns the whole
+// HTTP message tagged with X-Scan-Partial unless the service found a threat. It returns nil if the body
+// isn't truncated
+func (i *ICAPRequest) truncateOversize(partial bool, xICAPMetadata string) func(r processingResult) processingResult {
+	maxFileSize, scanPartial := i.scanLimits()
+	if partial || !scanPartial || maxFileSize <= 0 || i.scannedBytes <= maxFileSize {
+		return nil
+	}
+	var header http.Header
+	var body *spool.Body
+	var err error
+	if i.methodName == utils.ICAPModeReq {
+		body, err = bodyOf(&i.req.Request.Body)
+		header = i.req.Request.Header.Clone()
+	} else {
+		body, err = bodyOf(&i.req.Response.Body)
+		header = i.req.Response.Header.Clone()
+	}
+	if err != nil || body.Size() <= int64(maxFileSize) {
+		return nil
+	}
+	// the service streams the first max_filesize bytes of the spooled body
+	truncated := io.NopCloser(io.NewSectionReader(body, 0, int64(maxFileSize)))
+	if i.methodName == utils.ICAPModeReq {
+		i.req.Request.Body = truncated
+		i.req.Request.Header.Set(utils.ContentLength, strconv.Itoa(maxFileSize))
+	} else {
+		i.req.Response.Body = truncated
+		i.req.Response.Header.Set(utils.ContentLength, strconv.Itoa(maxFileSize))
+	}
+	i.scannedBytes = maxFileSize
+	logging.Logger.Warn(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventScanPartial, map[string]interface{}{
+		"service":       i.serviceName,
+		"method":        i.methodName,
+		"file_size":     body.Size(),
+		"scanned_bytes": maxFileSize,
+	}))
+
+	return func(r processingResult) processingResult {
+		// the verdict of the scanned bytes is kept if it isn't clean, a rewritten body is dropped since
+		// it was rewritten from the truncated file
+		if (r.IcapStatusCode != utils.OkStatusCodeStr && r.IcapStatusCode != utils.NoModificationStatusCodeStr) ||
+			r.vendorMsgs[utils.VendorMsgVerdict] == utils.SampleSeverityMalicious {
+			return r
+		}
+		header.Set(ScanPartialHeader, "true")
+		header.Set(utils.ContentLength, strconv.FormatInt(body.Size(), 10))
+		r.IcapStatusCode = utils.OkStatusCodeStr
+		if i.methodName == utils.ICAPModeReq {
+			i.req.Request.Header = header
+			i.req.Request.Body = body.Open()
+			r.httpMsg = i.req.Request
+		} else {
+			i.req.Response.Header = header
+			i.req.Response.Body = body.Open()
+			r.httpMsg = i.req.Response
+		}
+		return r
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/patience"
+	"icapeg/service/services-utilities/spool"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// patiencePage is an interim response which shows a patience page to the browser while the vendor is
+// scanning the download, the browser fetches the scanned response from the HTTP server of ICAPeg later
+type patiencePage struct {
+	id string
+}
+
+// patiencePageApplies reports whether a download of size bytes gets a patience page, only the downloads
+// which a user is waiting for in the browser get it, not the images and the scripts of the web pages
+func (i *ICAPRequest) patiencePageApplies(size int) bool {
+	cfg := i.appCfg.ServicesInstances[i.serviceName].PatiencePage
+	if cfg == nil || size < cfg.MinSize || i.clientRendersPatiencePage() {
+		return false
+	}
+	if !strings.Contains(i.req.Request.Header.Get("Accept"), utils.HTMLContentType) {
+		return false
+	}
+	contentType := i.req.Response.Header.Get(utils.ContentType)
+	if semicolon := strings.Index(contentType, ";"); semicolon != -1 {
+		contentType = contentType[:semicolon]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	for _, pattern := range cfg.ContentTypes {
+		pattern = strings.ToLower(pattern)
+		if pattern == utils.Any || pattern == contentType ||
+			(strings.HasSuffix(pattern, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(pattern, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// startPatiencePage registers the pending download and sends the patience page to the ICAP client, the
+// scanned response is stored once result arrives. It returns nil if the patience page couldn't be sent
+func (i *ICAPRequest) startPatiencePage(headerOnly *http.Response, body *spool.Body, result <-chan processingResult,
+	xICAPMetadata string) *patiencePage {
+	cfg := i.appCfg.ServicesInstances[i.serviceName].PatiencePage
+	fileName := ""
+	if i.req.Request.URL != nil {
+		fileName = path.Base(i.req.Request.URL.Path)
+	}
+	download := &patience.Download{
+		FileName:    fileName,
+		ServiceName: i.serviceName,
+		PagePath:    cfg.Page,
+		Refresh:     cfg.Refresh,
+		Size:        body.Size(),
+	}
+	id, err := patience.Register(download, cfg.TTL, patience.Limits{MaxDownloads: cfg.MaxDownloads,
+		MaxBytes: cfg.MaxBytes})
+	if err == patience.ErrTooManyDownloads {
+		logging.Logger.Warn(utils.PrepareLogMsg(xICAPMetadata, err.Error()+", waiting for the verdict without it"))
+		return nil
+	}
+	if err != nil {
+		logging.Logger.Error(utils.PrepareLogMsg(xICAPMetadata, "couldn't register the patience page download: "+err.Error()))
+		return nil
+	}
+	page, err := patience.RenderPage(cfg.Page, &patience.Page{
+		FileName:       fileName,
+		ServiceName:    i.serviceName,
+		DownloadURL:    cfg.DownloadURL + "/patience/" + id,
+		RefreshSeconds: int(cfg.Refresh.Seconds()),
+	})
+	if err != nil {
+		logging.Logger.Error(utils.PrepareLogMsg(xICAPMetadata, "couldn't render the patience page: "+err.Error()))
+		return nil
+	}
+
+	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
+		"the vendor is still scanning after "+cfg.Delay.String()+", sending a patience page to the ICAP client"))
+	pageResp := &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     strconv.Itoa(http.StatusOK) + " " + http.StatusText(http.StatusOK),
+		Proto:      headerOnly.Proto,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(string(page))),
+	}
+	pageResp.Header.Set(utils.ContentType, utils.HTMLContentType+"; charset=utf-8")
+	pageResp.Header.Set(utils.ContentLength, strconv.Itoa(len(page)))
+	pageResp.Header.Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	i.w.WriteHeader(utils.OkStatusCodeStr, pageResp, true)
+
+	// the original body is kept for the download, which returns it if the service doesn't modify it
+	body.Retain()
+	goBackground(func() {
+		defer body.Close()
+		r := <-result
+		i.notifyVerdict(r.httpMsg, r.vendorMsgs, xICAPMetadata)
+		statusCode, header, scanned := scannedResponse(r, headerOnly, body, xICAPMetadata)
+		logging.Logger.Info(utils.PrepareLogMsg(xICAPMetadata, "the download behind the patience page was scanned, "+
+			i.serviceName+" returned "+strconv.Itoa(r.IcapStatusCode)))
+		patience.Complete(id, statusCode, header, scanned)
+	})
+	return &patiencePage{id: id}
+}
+
+// scannedResponse returns the HTTP response which the browser gets after the patience page, the original
+// response if the service didn't modify it, the response of the service (ex: the block page) otherwise. The
+// returned body is spooled for the download, which closes it
+func scannedResponse(r processingResult, original *http.Response, body *spool.Body,
+	xICAPMetadata string) (int, http.Header, *spool.Body) {
+	header := original.Header.Clone()
+	header.Del(utils.ContentLength)
+	if r.IcapStatusCode == utils.NoModificationStatusCodeStr {
+		body.Retain()
+		return original.StatusCode, header, body
+	}
+	if resp, isResp := r.httpMsg.(*http.Response); isResp && resp != nil &&
+		(r.IcapStatusCode == utils.OkStatusCodeStr || r.IcapStatusCode == utils.BadRequestStatusCodeStr) {
+		if resp.Body == nil {
+			resp.Body = http.NoBody
+		}
+		scanned, spooled := spool.Of(resp.Body)
+		var err error
+		if spooled {
+			scanned.Retain()
+		} else {
+			scanned, err = spool.Read(resp.Body)
+		}
+		resp.Body.Close()
+		if err == nil {
+			header = resp.Header.Clone()
+			header.Del(utils.ContentLength)
+			return resp.StatusCode, header, scanned
+		}
+		logging.Logger.Error(utils.PrepareLogMsg(xICAPMetadata,
+			"couldn't spool the scanned response of the patience page download: "+err.Error()))
+	}
+	unscanned, _ := spool.New(strings.NewReader("the download couldn't be scanned"), 0, "")
+	return http.StatusForbidden, http.Header{utils.ContentType: {"text/plain; charset=utf-8"}}, unscanned
+}
+
+// finish does nothing because the patience page was already completed, the verdict is handled
+// in the background once the service returns it
+func (p *patiencePage) finish(IcapStatusCode int, httpMsg interface{}, vendorMsgs map[string]interface{},
+	xICAPMetadata string) int {
+	return IcapStatusCode
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	general_functions "icapeg/service/services-utilities/general-functions"
+	"icapeg/service/services-utilities/hostpolicy"
+	"icapeg/service/services-utilities/policies"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// policyTransaction returns what the policy rules match: the ICAP client, the HTTP client and the encapsulated
+// HTTP message. The size is the declared Content-Length because the body isn't read yet
+func (i *ICAPRequest) policyTransaction() policies.Transaction {
+	t := policies.Transaction{Service: i.serviceName, ICAPClient: i.req.RemoteAddr, ClientIP: i.clientIP(), Size: -1}
+	if i.req.Request != nil {
+		host := i.req.Request.Host
+		if host == "" && i.req.Request.URL != nil {
+			host = i.req.Request.URL.Host
+		}
+		t.Host, _ = hostpolicy.SplitAuthority(host)
+		t.Method = i.req.Request.Method
+		t.UserAgent = i.req.Request.Header.Get("User-Agent")
+	}
+	header := http.Header(nil)
+	if i.methodName == utils.ICAPModeResp && i.req.Response != nil {
+		header = i.req.Response.Header
+	} else if i.methodName == utils.ICAPModeReq && i.req.Request != nil {
+		header = i.req.Request.Header
+	}
+	if header != nil {
+		t.ContentType = header.Get(utils.ContentType)
+		if size, err := strconv.ParseInt(header.Get(utils.ContentLength), 10, 64); err == nil && size >= 0 {
+			t.Size = size
+		}
+	}
+	return t
+}
+
+// selectPolicy is a func to find the first policy rule which matches the transaction, the service action
+// replaces the service of the ICAP request and the shadow action processes it like a shadow service. The bypass
+// and block actions answer the ICAP request later, see applyPolicy
+func (i *ICAPRequest) selectPolicy(xICAPMetadata string) {
+	if len(i.appCfg.Policies) == 0 || i.methodName == utils.ICAPModeOptions {
+		return
+	}
+	rule := policies.First(i.appCfg.Policies, i.policyTransaction())
+	if rule == nil {
+		return
+	}
+	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "the transaction matches "+rule.Name+
+		" policy, its action is "+rule.Action))
+	if rule.Action == policies.ActionService {
+		target := i.appCfg.ServicesInstances[rule.Service]
+		if (i.methodName == utils.ICAPModeReq && !target.ReqMode) || (i.methodName == utils.ICAPModeResp && !target.RespMode) {
+			logging.Logger.Warn(utils.PrepareLogMsg(xICAPMetadata,
+				rule.Service+" service of "+rule.Name+" policy doesn't support "+i.methodName+", the policy isn't applied"))
+			return
+		}
+		i.serviceName = rule.Service
+		utils.SetTransactionService(xICAPMetadata, i.serviceName)
+	}
+	i.policy = rule
+	if i.appCfg.DebuggingHeaders {
+		i.h["X-ICAPeg-Policy"] = []string{rule.Name}
+	}
+}
+
+// applyPolicy is a func to answer the ICAP request whose policy rule bypasses or blocks it, the bypassed HTTP
+// message is returned as it is and the blocked one is answered with a 403 response which has the block page.
+// It returns true if the ICAP request was answered
+func (i *ICAPRequest) applyPolicy(xICAPMetadata string) bool {
+	if i.policy == nil {
+		return false
+	}
+	switch i.policy.Action {
+	case policies.ActionBypass:
+		i.Is204Allowed = i.bypass204Allowed()
+		i.returnOriginal()
+		return true
+	case policies.ActionBlock:
+	default:
+		return false
+	}
+
+	details := map[string]interface{}{
+		"service": i.serviceName,
+		"method":  i.methodName,
+		"policy":  i.policy.Name,
+		"client":  i.clientIP(),
+	}
+	logging.Logger.Info(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventPolicyBlocked, details))
+	i.publishBlock(utils.EventPolicyBlocked, details, xICAPMetadata)
+	httpMsg := &http_message.HttpMsg{Request: i.req.Request, Response: i.req.Response}
+	requestURI := "-"
+	if i.req.Request.URL != nil {
+		requestURI = i.req.Request.URL.String()
+	}
+	generalFunc := general_functions.NewGeneralFunc(httpMsg, xICAPMetadata)
+	htmlPage := generalFunc.GenHtmlPage(utils.BlockPagePath, utils.ErrPageReasonPolicyBlocked, i.serviceName, "-",
+		requestURI, strconv.Itoa(i.scannedBytes), xICAPMetadata)
+	response := generalFunc.ErrPageResp(http.StatusForbidden, htmlPage.Len())
+	i.alteringBlockResponse(response)
+	i.w.WriteHeader(utils.OkStatusCodeStr, response, true)
+	io.Copy(i.w, htmlPage)
+	i.allHeaders(utils.OkStatusCodeStr, nil, nil, map[string]interface{}{utils.VendorMsgPolicy: i.policy.Name}, xICAPMetadata)
+	return true
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	"icapeg/service"
+	"icapeg/service/services-utilities/metrics"
+	"icapeg/service/services-utilities/spool"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+// processingResult holds the values which Processing func of a service returns
+type processingResult struct {
+	IcapStatusCode                 int
+	httpMsg                        interface{}
+	serviceHeaders                 map[string]string
+	httpMshHeadersBeforeProcessing map[string]interface{}
+	httpMshHeadersAfterProcessing  map[string]interface{}
+	vendorMsgs                     map[string]interface{}
+}
+
+// interimResponse is an ICAP response which was sent to the ICAP client before the service returned
+// its verdict (trickling or a patience page), finish completes it upon the verdict and returns
+// the ICAP status code which is logged
+type interimResponse interface {
+	finish(IcapStatusCode int, httpMsg interface{}, vendorMsgs map[string]interface{}, xICAPMetadata string) int
+}
+
+// processing is a func to call Processing func of the service, if the service has trickling or a patience
+// page and the vendor takes longer than their delay to scan a large file, an interim response is sent to
+// the ICAP client while waiting for the verdict and it's returned. If the service defers scanning the large
+// files, they are delivered before the verdict, and if the service has a max wait, the verdict is awaited
+// up to the max wait only. release is called once the service returned
+func (i *ICAPRequest) processing(requiredService service.Service, partial bool, icapHeader textproto.MIMEHeader,
+	release func(), xICAPMetadata string) (processingResult, interimResponse) {
+	start := time.Now()
+	serviceInstance := i.appCfg.ServicesInstances[i.serviceName]
+	maxWait := serviceInstance.MaxWait != nil && !partial && !i.isShadowServiceEnabled
+	interim := (serviceInstance.Trickling != nil || serviceInstance.PatiencePage != nil ||
+		serviceInstance.DeferredScan != nil) && !partial && i.methodName == utils.ICAPModeResp && !i.isShadowServiceEnabled
+	if !maxWait && !interim {
+		defer release()
+		return i.callProcessing(requiredService, partial, icapHeader, xICAPMetadata), nil
+	}
+	// the vendor may still be scanning after the ICAP request was answered (ex: after the max wait), it gets
+	// its own copy of the ICAP headers because the services add theirs while the access log reads them
+	icapHeader = textproto.MIMEHeader(http.Header(icapHeader).Clone())
+
+	// the original HTTP message is copied before processing because the service may change it
+	var body *spool.Body
+	var err error
+	var original *http.Response
+	if i.methodName == utils.ICAPModeResp {
+		body, err = bodyOf(&i.req.Response.Body)
+		headerOnly := *i.req.Response
+		headerOnly.Header = i.req.Response.Header.Clone()
+		headerOnly.Body = nil
+		if headerOnly.Header.Get(utils.ContentLength) == "" {
+			headerOnly.Header.Set(utils.ContentLength, strconv.FormatInt(body.Size(), 10))
+		}
+		original = &headerOnly
+	}
+	var fallback *maxWaitFallback
+	if maxWait {
+		fallback = i.newMaxWaitFallback(original, body)
+	}
+
+	showPatiencePage, trickling := false, false
+	if interim && err == nil {
+		if fileHash, deferring := i.deferringScan(body); deferring {
+			if delivered := i.startDeferredScan(requiredService, partial, icapHeader, release, body, fileHash,
+				xICAPMetadata); delivered != nil {
+				return processingResult{}, delivered
+			}
+		}
+		showPatiencePage = i.patiencePageApplies(int(body.Size()))
+		trickling = !showPatiencePage && serviceInstance.Trickling != nil &&
+			body.Size() >= int64(serviceInstance.Trickling.MinSize)
+	}
+
+	result := make(chan processingResult, 1)
+	releaseBody := i.retainBody()
+	go func() {
+		defer releaseBody()
+		result <- i.callProcessing(requiredService, partial, icapHeader, xICAPMetadata)
+		release()
+	}()
+	if !showPatiencePage && !trickling {
+		return i.awaitVerdict(result, start, fallback, xICAPMetadata), nil
+	}
+
+	var delay time.Duration
+	if showPatiencePage {
+		delay = serviceInstance.PatiencePage.Delay
+	} else {
+		delay = serviceInstance.Trickling.Delay
+	}
+	if maxWait && serviceInstance.MaxWait.Timeout <= delay {
+		return i.awaitVerdict(result, start, fallback, xICAPMetadata), nil
+	}
+	select {
+	case r := <-result:
+		return r, nil
+	case <-time.After(delay):
+	}
+
+	if showPatiencePage {
+		if p := i.startPatiencePage(original, body, result, xICAPMetadata); p != nil {
+			return processingResult{IcapStatusCode: utils.OkStatusCodeStr}, p
+		}
+		return i.awaitVerdict(result, start, fallback, xICAPMetadata), nil
+	}
+	t := i.startTrickling(original, body, xICAPMetadata)
+	r := i.awaitVerdict(result, start, fallback, xICAPMetadata)
+	t.wait()
+	return r, t
+}
+
+// callProcessing calls Processing func of the service and packs its returned values, the service scans the
+// first max_filesize bytes of a larger body only if it has scan_partial_if_max_file_size_exceeded. The files of
+// an archive are scanned before the archive if the service has an archive_scan section, and the files of an upload
+// are scanned one by one if the service has a multipart_scan section. The vendor is called within the scan_timeout
+// of the service and its failures get the fail_policy of the service
+func (i *ICAPRequest) callProcessing(requiredService service.Service, partial bool,
+	icapHeader textproto.MIMEHeader, xICAPMetadata string) processingResult {
+	restoreOversize := i.truncateOversize(partial, xICAPMetadata)
+	if !partial && restoreOversize == nil {
+		if verdict, found := i.scanMultipart(icapHeader, xICAPMetadata); found {
+			return verdict
+		}
+		if verdict, found := i.scanArchive(icapHeader, xICAPMetadata); found {
+			return verdict
+		}
+	}
+	endTrace := i.startVendorTrace(xICAPMetadata)
+	start := time.Now()
+	r := i.callVendor(requiredService, partial, icapHeader, xICAPMetadata)
+	metrics.RecordVendor(i.serviceName, i.vendor, i.scannedBytes, time.Since(start))
+	endTrace(r)
+	if restoreOversize != nil {
+		r = restoreOversize(r)
+	}
+	return i.applyFailPolicy(r, xICAPMetadata)
+}
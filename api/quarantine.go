@@ -0,0 +1,63 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/digests"
+	"icapeg/service/services-utilities/quarantine"
+	"io"
+	"time"
+)
+
+// quarantineFile is a func to store the blocked file with the metadata of its transaction in the quarantine of
+// the service, in the background so the ICAP response isn't delayed by the upload
+func (i *ICAPRequest) quarantineFile(vendorMsgs map[string]interface{}, xICAPMetadata string) {
+	q := quarantine.Of(i.serviceName)
+	if q == nil {
+		return
+	}
+	event := i.verdictEvent(vendorMsgs, xICAPMetadata)
+	sample := &quarantine.Sample{
+		Time:          time.Now(),
+		XICAPMetadata: xICAPMetadata,
+		ServiceName:   event.ServiceName,
+		Vendor:        event.Vendor,
+		Method:        event.Method,
+		Verdict:       event.Verdict,
+		Threat:        event.Threat,
+		ClientIP:      event.ClientIP,
+		Username:      event.Username,
+		RequestedURL:  event.RequestedURL,
+		FileName:      event.FileName,
+		SHA256:        event.FileDigests[digests.SHA256],
+	}
+	if sample.SHA256 == "" {
+		sample.SHA256 = i.fileHash
+	}
+	var open func() io.ReadCloser
+	body := i.body
+	if body != nil && body.Size() > 0 {
+		sample.FileSize = body.Size()
+		open = func() io.ReadCloser { return body.Open() }
+	}
+	releaseBody := i.retainBody()
+	goBackground(func() {
+		defer releaseBody()
+		if sample.SHA256 == "" && open != nil {
+			if fileDigests, err := digests.ComputeWith([]string{digests.SHA256}, open()); err == nil {
+				sample.SHA256 = fileDigests[digests.SHA256]
+			}
+		}
+		if err := q.Store(sample, open); err != nil {
+			logging.Logger.Error(utils.PrepareLogMsg(xICAPMetadata, "couldn't quarantine the blocked file of "+
+				i.serviceName+": "+err.Error()))
+			return
+		}
+		if sample.Payload == "" {
+			logging.Logger.Info(utils.PrepareLogMsg(xICAPMetadata, "the metadata of the blocked file was quarantined "+
+				"without the file, "+sample.SkipReason))
+			return
+		}
+		logging.Logger.Info(utils.PrepareLogMsg(xICAPMetadata, "the blocked file was quarantined: "+sample.Payload))
+	})
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/ratelimit"
+	"net"
+	"strconv"
+	"time"
+)
+
+// limitRate is a func to take the ICAP request from the rate limit of the ICAP client (ex: a proxy) in the
+// service, the previewed requests are taken once. It answers the request and returns false if the ICAP client
+// exceeded its requests per second
+func (i *ICAPRequest) limitRate(xICAPMetadata string) bool {
+	if i.rateLimited {
+		return true
+	}
+	i.rateLimited = true
+	client, _, err := net.SplitHostPort(i.req.RemoteAddr)
+	if err != nil {
+		client = i.req.RemoteAddr
+	}
+	allowed, retryAfter := ratelimit.Allow(i.serviceName, client)
+	if allowed {
+		return true
+	}
+	logging.Logger.Warn(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventRateLimited, map[string]interface{}{
+		"service":     i.serviceName,
+		"icap_client": client,
+	}))
+	i.overloaded(retryAfter, xICAPMetadata)
+	return false
+}
+
+// overloaded is a func to answer the ICAP request which exceeded the limits of the service with ICAP 503 and
+// its Retry-After, or with the HTTP message as it is if the service fails open
+func (i *ICAPRequest) overloaded(retryAfter time.Duration, xICAPMetadata string) {
+	if serviceInstance := i.appCfg.ServicesInstances[i.serviceName]; serviceInstance != nil &&
+		serviceInstance.Keys.Bool("fail_open") {
+		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
+			i.serviceName+" service fails open, returning the HTTP message as it is"))
+		i.Is204Allowed = i.bypass204Allowed()
+		i.returnOriginal()
+		return
+	}
+	if retryAfter > 0 {
+		// the Retry-After is rounded up to whole seconds
+		i.h["Retry-After"] = []string{strconv.Itoa(int((retryAfter + time.Second - 1) / time.Second))}
+	}
+	i.w.WriteHeader(utils.ServiceOverloadedCodeStr, nil, false)
+}
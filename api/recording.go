@@ -0,0 +1,112 @@
+package api
+
+import (
+	"bytes"
+	"icapeg/icap"
+	"icapeg/recording"
+	"icapeg/service/services-utilities/spool"
+	"io"
+	"net/http"
+)
+
+// recordingWriter records the ICAP response of the transaction, the body is capped like the request body
+type recordingWriter struct {
+	icap.ResponseWriter
+	statusCode int
+	httpMsg    interface{}
+	icapHeader http.Header
+	body       cappedBuffer
+}
+
+func newRecordingWriter(w icap.ResponseWriter) *recordingWriter {
+	return &recordingWriter{ResponseWriter: w, body: cappedBuffer{limit: recording.MaxBodySize()}}
+}
+
+func (w *recordingWriter) WriteHeader(code int, httpMessage interface{}, hasBody bool) {
+	recorded := w.statusCode == 0
+	if recorded && hasBody {
+		// the ICAP response writer copies the body of the HTTP message itself
+		switch msg := httpMessage.(type) {
+		case *http.Response:
+			msg.Body = teeBody(msg.Body, &w.body)
+		case *http.Request:
+			msg.Body = teeBody(msg.Body, &w.body)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code, httpMessage, hasBody)
+	if recorded {
+		w.statusCode, w.httpMsg, w.icapHeader = code, httpMessage, w.Header().Clone()
+	}
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func teeBody(body io.ReadCloser, w io.Writer) io.ReadCloser {
+	if body == nil {
+		return nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(body, w), body}
+}
+
+// cappedBuffer keeps the first limit bytes which are written to it, a limit of zero keeps everything
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.limit > 0 {
+		room := b.limit - b.Len()
+		if room <= 0 {
+			return len(p), nil
+		}
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+			return len(p), nil
+		}
+	}
+	return b.Buffer.Write(p)
+}
+
+// recordRequest is a func to take the wire representation of the ICAP request for the recording, it's taken
+// again if the rest of the body arrives after the preview
+func (i *ICAPRequest) recordRequest() {
+	if !recording.Enabled() {
+		return
+	}
+	var spooled *spool.Body
+	if i.req.Response != nil && i.req.Response.Body != nil {
+		spooled, _ = bodyOf(&i.req.Response.Body)
+	} else if i.req.Request != nil && i.req.Request.Body != nil {
+		spooled, _ = bodyOf(&i.req.Request.Body)
+	}
+	// the recorded bytes only are read from the spooled body
+	var body []byte
+	if spooled != nil {
+		size := spooled.Size()
+		if max := int64(recording.MaxBodySize()); max > 0 && size > max {
+			size = max
+		}
+		body = spooled.Head(int(size))
+	}
+	i.recordedRequest = recording.DumpRequest(i.req.Method, i.req.RawURL, i.req.Header, i.req.Request, i.req.Response,
+		body)
+}
+
+// saveRecording is a func to write the recorded ICAP request and response of the transaction
+func (i *ICAPRequest) saveRecording(w *recordingWriter, xICAPMetadata string) {
+	if w == nil {
+		return
+	}
+	var response []byte
+	if w.statusCode != 0 {
+		response = recording.DumpResponse(w.statusCode, w.icapHeader, w.httpMsg, w.body.Bytes())
+	}
+	recording.Save(xICAPMetadata, i.recordedRequest, response)
+}
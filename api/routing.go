@@ -0,0 +1,91 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	general_functions "icapeg/service/services-utilities/general-functions"
+	"icapeg/service/services-utilities/hotswap"
+	"icapeg/service/services-utilities/spool"
+)
+
+// file type groups which can be used in the routing table of a service instead of listing the extensions
+var fileTypeGroups = map[string][]string{
+	"executables": {"exe", "dll", "msi", "com", "bat", "cmd", "scr", "ps1", "vbs", "jar", "elf", "sh", "apk", "dmg", "deb", "rpm"},
+	"documents":   {"pdf", "doc", "docx", "docm", "xls", "xlsx", "xlsm", "ppt", "pptx", "pptm", "rtf", "odt", "ods", "odp"},
+	"archives":    {"zip", "rar", "7z", "tar", "gz", "bz2", "xz", "cab", "iso"},
+}
+
+// routeByFileType is a func to replace the service of the ICAP request with the service which the routing
+// table of the service has for the type of the HTTP message body, the type is sniffed the same way the services do
+func (i *ICAPRequest) routeByFileType(xICAPMetadata string) {
+	routes := i.appCfg.ServicesInstances[i.serviceName].Routes
+	if len(routes) == 0 || i.routedFrom != "" {
+		return
+	}
+	fileExtension := i.sniffFileType(xICAPMetadata)
+	serviceName, exists := routes[fileExtension]
+	if !exists {
+		for group, exts := range fileTypeGroups {
+			if target, grouped := routes[group]; grouped && contains(exts, fileExtension) {
+				serviceName, exists = target, true
+				break
+			}
+		}
+	}
+	if !exists || serviceName == i.serviceName {
+		return
+	}
+	i.routeTo(serviceName, "the "+fileExtension+" file", xICAPMetadata)
+}
+
+// routeTo is a func to replace the service of the ICAP request with the target service of a routing table,
+// what describes the routed HTTP message in the logs
+func (i *ICAPRequest) routeTo(serviceName, what, xICAPMetadata string) {
+	target := i.appCfg.ServicesInstances[serviceName]
+	if (i.methodName == utils.ICAPModeReq && !target.ReqMode) || (i.methodName == utils.ICAPModeResp && !target.RespMode) {
+		logging.Logger.Warn(utils.PrepareLogMsg(xICAPMetadata,
+			serviceName+" service doesn't support "+i.methodName+", "+what+" isn't routed"))
+		return
+	}
+	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
+		"routing "+what+" from "+i.serviceName+" service to "+serviceName+" service"))
+	i.routedFrom = i.serviceName
+	i.serviceName = serviceName
+	utils.SetTransactionService(xICAPMetadata, serviceName)
+	i.vendor = hotswap.Vendor(serviceName, target.Vendor)
+	if i.appCfg.DebuggingHeaders {
+		i.h["X-ICAPeg-Routed-Service"] = []string{serviceName}
+	}
+}
+
+// sniffFileType is a func to get the extension of the HTTP message body without consuming the body, the file
+// type is sniffed from the head of the spooled body
+func (i *ICAPRequest) sniffFileType(xICAPMetadata string) string {
+	httpMsg := &http_message.HttpMsg{Request: i.req.Request}
+	var body *spool.Body
+	var err error
+	var contentType string
+	if i.methodName == utils.ICAPModeReq {
+		body, err = bodyOf(&i.req.Request.Body)
+		contentType = i.req.Request.Header.Get(utils.ContentType)
+	} else {
+		httpMsg.Response = i.req.Response
+		body, err = bodyOf(&i.req.Response.Body)
+		contentType = i.req.Response.Header.Get(utils.ContentType)
+	}
+	if err != nil {
+		return utils.Unknown
+	}
+	generalFunc := general_functions.NewGeneralFunc(httpMsg, xICAPMetadata)
+	return generalFunc.GetMimeExtension(body.Head(spool.SniffLen), contentType, generalFunc.GetFileName())
+}
+
+func contains(arr []string, s string) bool {
+	for _, element := range arr {
+		if element == s {
+			return true
+		}
+	}
+	return false
+}
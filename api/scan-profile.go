@@ -0,0 +1,43 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"strings"
+)
+
+// selectScanProfile is a func to select the scan profile of the service which the ICAP client asked for in the
+// scan profile header, so the proxy chooses how deep a request is scanned per ACL without separate services.
+// The default profile of the service is used if the client asked for none or for one which the service hasn't
+func (i *ICAPRequest) selectScanProfile(xICAPMetadata string) {
+	serviceInstance := i.appCfg.ServicesInstances[i.serviceName]
+	if len(serviceInstance.Profiles) == 0 {
+		return
+	}
+	name := strings.ToLower(strings.TrimSpace(i.req.Header.Get(i.appCfg.ScanProfileHeader)))
+	if _, exists := serviceInstance.Profiles[name]; name != "" && !exists {
+		logging.Logger.Warn(utils.PrepareLogMsg(xICAPMetadata, i.serviceName+" has no "+name+
+			" scan profile, scanning with its default one"))
+		name = ""
+	}
+	if name == "" {
+		name = serviceInstance.DefaultProfile
+	}
+	if name == "" {
+		return
+	}
+	i.scanProfile = serviceInstance.Profiles[name]
+	utils.SetTransactionProfile(xICAPMetadata, name)
+	i.h.Set(i.appCfg.ScanProfileHeader, i.scanProfile.Name)
+	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, i.serviceName+" scans with "+name+" scan profile"))
+}
+
+// scanLimits returns the max_filesize of the scan and whether the first max_filesize bytes of a larger body
+// are scanned, from the scan profile of the request or else from the service
+func (i *ICAPRequest) scanLimits() (int, bool) {
+	if i.scanProfile != nil {
+		return i.scanProfile.MaxFileSize, i.scanProfile.ScanPartial
+	}
+	serviceInstance := i.appCfg.ServicesInstances[i.serviceName]
+	return serviceInstance.MaxFileSize, serviceInstance.ScanPartial
+}
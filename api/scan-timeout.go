@@ -0,0 +1,99 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"icapeg/service"
+	"net/textproto"
+	"time"
+)
+
+// callVendor is a func to call Processing func of the service, the vendor of a service which has a scan_timeout
+// must return in it or the scan times out with 408 and a vendor error. The vendor keeps scanning in the background
+// because it can't be interrupted, its late result is dropped
+func (i *ICAPRequest) callVendor(requiredService service.Service, partial bool, icapHeader textproto.MIMEHeader,
+	xICAPMetadata string) processingResult {
+	scanTimeout := i.appCfg.ServicesInstances[i.serviceName].ScanTimeout
+	if scanTimeout <= 0 {
+		var r processingResult
+		r.IcapStatusCode, r.httpMsg, r.serviceHeaders, r.httpMshHeadersBeforeProcessing, r.httpMshHeadersAfterProcessing,
+			r.vendorMsgs = requiredService.Processing(partial, icapHeader)
+		return r
+	}
+
+	result := make(chan processingResult, 1)
+	releaseBody := i.retainBody()
+	go func() {
+		defer releaseBody()
+		var r processingResult
+		r.IcapStatusCode, r.httpMsg, r.serviceHeaders, r.httpMshHeadersBeforeProcessing, r.httpMshHeadersAfterProcessing,
+			r.vendorMsgs = requiredService.Processing(partial, icapHeader)
+		result <- r
+	}()
+	timer := time.NewTimer(scanTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-result:
+		return r
+	case <-timer.C:
+	}
+	logging.Logger.Warn(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventScanTimedOut, map[string]interface{}{
+		"service":      i.serviceName,
+		"vendor":       i.vendor,
+		"method":       i.methodName,
+		"scan_timeout": scanTimeout.String(),
+	}))
+	return processingResult{IcapStatusCode: utils.RequestTimeOutStatusCodeStr, vendorMsgs: map[string]interface{}{
+		utils.VendorMsgError: "the vendor didn't return its verdict in " + scanTimeout.String(),
+	}}
+}
+
+// applyFailPolicy is a func to answer the HTTP message whose vendor couldn't be reached (after its retries) or timed
+// out with the fail_policy of the service: open returns the HTTP message as it is with 204 and closed fails the
+// ICAP request with 500. The result of the vendor is kept if the service has no fail_policy or the vendor answered
+func (i *ICAPRequest) applyFailPolicy(r processingResult, xICAPMetadata string) processingResult {
+	policy := i.appCfg.ServicesInstances[i.serviceName].FailPolicy
+	if policy == "" || !vendorFailed(r) {
+		return r
+	}
+	if policy == utils.FailPolicyOpen && r.IcapStatusCode == utils.NoModificationStatusCodeStr {
+		// the vendor itself bypassed the HTTP message, ex: bypass_on_api_error
+		return r
+	}
+	logging.Logger.Warn(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventFailPolicy, map[string]interface{}{
+		"service":     i.serviceName,
+		"vendor":      i.vendor,
+		"method":      i.methodName,
+		"fail_policy": policy,
+		"error":       vendorMsg(r.vendorMsgs, utils.VendorMsgError),
+	}))
+	vendorMsgs := r.vendorMsgs
+	if vendorMsgs == nil {
+		vendorMsgs = make(map[string]interface{})
+	}
+	vendorMsgs[utils.VendorMsgFailPolicy] = policy
+	if policy == utils.FailPolicyClosed {
+		return processingResult{IcapStatusCode: utils.InternalServerErrStatusCodeStr, vendorMsgs: vendorMsgs,
+			httpMshHeadersBeforeProcessing: r.httpMshHeadersBeforeProcessing}
+	}
+	open := processingResult{IcapStatusCode: utils.NoModificationStatusCodeStr, httpMsg: i.req.Request,
+		vendorMsgs: vendorMsgs, httpMshHeadersBeforeProcessing: r.httpMshHeadersBeforeProcessing}
+	if i.methodName == utils.ICAPModeResp && i.req.Response != nil {
+		response := *i.req.Response
+		response.Body = i.originalBody(i.req.Response.Body)
+		open.httpMsg = &response
+	}
+	return open
+}
+
+// vendorFailed reports whether the vendor couldn't return a verdict, it reports its error in the vendor messages
+// or it timed out. A malicious verdict is kept even if one of the vendors of the service failed
+func vendorFailed(r processingResult) bool {
+	if r.vendorMsgs[utils.VendorMsgVerdict] == utils.SampleSeverityMalicious {
+		return false
+	}
+	if _, exists := r.vendorMsgs[utils.VendorMsgError]; exists {
+		return true
+	}
+	return r.IcapStatusCode == utils.InternalServerErrStatusCodeStr || r.IcapStatusCode == utils.RequestTimeOutStatusCodeStr
+}
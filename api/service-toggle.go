@@ -0,0 +1,37 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/toggles"
+)
+
+// bypassDisabledService is a func to answer the ICAP request without scanning if its service was disabled
+// at runtime, the HTTP message is returned as it is
+func (i *ICAPRequest) bypassDisabledService(xICAPMetadata string) bool {
+	if !toggles.IsDisabled(i.serviceName) {
+		return false
+	}
+	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, i.serviceName+" service is disabled at runtime"))
+	i.returnOriginal()
+	return true
+}
+
+// returnOriginal is a func to answer the ICAP request with the HTTP message as it is, with 204 if the ICAP
+// client allows it
+func (i *ICAPRequest) returnOriginal() {
+	if i.Is204Allowed {
+		i.w.WriteHeader(utils.NoModificationStatusCodeStr, nil, false)
+		return
+	}
+	// the spooled body is streamed to the ICAP client from its start
+	if i.req.Method == utils.ICAPModeReq {
+		i.req.Request.Body = i.originalBody(i.req.Request.Body)
+		i.w.WriteHeader(utils.OkStatusCodeStr, i.req.Request, true)
+		i.req.Request.Body = i.originalBody(i.req.Request.Body)
+	} else {
+		i.req.Response.Body = i.originalBody(i.req.Response.Body)
+		i.w.WriteHeader(utils.OkStatusCodeStr, i.req.Response, true)
+		i.req.Response.Body = i.originalBody(i.req.Response.Body)
+	}
+}
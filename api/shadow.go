@@ -0,0 +1,140 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	"icapeg/service"
+	"icapeg/service/services-utilities/hotswap"
+	"icapeg/service/services-utilities/metrics"
+	"icapeg/service/services-utilities/shadow"
+	"net/http"
+	"net/textproto"
+	"time"
+)
+
+// recordShadowVerdict is a func to log the verdict which a shadow service would have returned with its vendor
+// headers and its latency, and to count it in the metrics
+func (i *ICAPRequest) recordShadowVerdict(r processingResult, latency time.Duration, xICAPMetadata string) {
+	verdict := verdictOf(r.vendorMsgs, false)
+	logging.Logger.Info(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventShadowVerdict, map[string]interface{}{
+		"service":        i.serviceName,
+		"vendor":         i.vendor,
+		"method":         i.methodName,
+		"verdict":        verdict,
+		"threat":         vendorMsg(r.vendorMsgs, utils.VendorMsgThreat),
+		"icap_status":    r.IcapStatusCode,
+		"vendor_headers": r.serviceHeaders,
+		"latency_ms":     latency.Milliseconds(),
+		"requested_url":  i.requestedURL(),
+	}))
+	metrics.RecordShadowVerdict(i.serviceName, i.vendor, verdict)
+}
+
+// compareShadow is a func to scan the HTTP message again with the shadow service of the service's shadow_compare
+// section in the background and to compare its verdict with the one of the service, the comparisons are logged
+// and added to the agreement report of the admin API. The HTTP messages which were scanned partially aren't compared
+func (i *ICAPRequest) compareShadow(r processingResult, latency time.Duration, partial bool, xICAPMetadata string) {
+	cfg := i.appCfg.ServicesInstances[i.serviceName].ShadowCompare
+	if cfg == nil || partial || i.body == nil || r.vendorMsgs[utils.VendorMsgMaxWait] != nil {
+		return
+	}
+	target := i.appCfg.ServicesInstances[cfg.Service]
+	if target == nil || (i.methodName == utils.ICAPModeReq && !target.ReqMode) ||
+		(i.methodName == utils.ICAPModeResp && !target.RespMode) {
+		return
+	}
+	httpMsg := &http_message.HttpMsg{Request: i.req.Request.Clone(i.req.Request.Context())}
+	if i.methodName == utils.ICAPModeReq {
+		httpMsg.Request.Body = i.body.Open()
+	} else {
+		response := *i.req.Response
+		response.Header = i.req.Response.Header.Clone()
+		response.Body = i.body.Open()
+		httpMsg.Response = &response
+	}
+	comparison := shadow.Comparison{
+		Time:             time.Now(),
+		XICAPMetadata:    xICAPMetadata,
+		Primary:          i.serviceName,
+		Shadow:           cfg.Service,
+		Method:           i.methodName,
+		RequestedURL:     i.requestedURL(),
+		PrimaryVerdict:   verdictOf(r.vendorMsgs, false),
+		PrimaryThreat:    vendorMsg(r.vendorMsgs, utils.VendorMsgThreat),
+		PrimaryLatencyMs: latency.Milliseconds(),
+	}
+	vendor := hotswap.Vendor(cfg.Service, target.Vendor)
+	icapHeader := i.req.Header
+	releaseBody := i.retainBody()
+	goBackground(func() {
+		defer releaseBody()
+		requiredService := service.GetService(vendor, cfg.Service, i.methodName, httpMsg, xICAPMetadata)
+		if requiredService == nil {
+			return
+		}
+		start := time.Now()
+		_, _, _, _, _, vendorMsgs := requiredService.Processing(false, icapHeader)
+		comparison.ShadowLatencyMs = time.Since(start).Milliseconds()
+		comparison.ShadowVerdict = verdictOf(vendorMsgs, false)
+		comparison.ShadowThreat = vendorMsg(vendorMsgs, utils.VendorMsgThreat)
+		logging.Logger.Info(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventShadowCompare, map[string]interface{}{
+			"primary":            comparison.Primary,
+			"shadow":             comparison.Shadow,
+			"method":             comparison.Method,
+			"primary_verdict":    comparison.PrimaryVerdict,
+			"shadow_verdict":     comparison.ShadowVerdict,
+			"primary_latency_ms": comparison.PrimaryLatencyMs,
+			"shadow_latency_ms":  comparison.ShadowLatencyMs,
+			"agree":              comparison.Agree(),
+		}))
+		shadow.Record(comparison, cfg.MaxSamples)
+		metrics.RecordComparison(comparison.Primary, comparison.Shadow, comparison.Agree())
+	})
+}
+
+// requestedURL returns the URL of the encapsulated HTTP request, empty if there's none
+func (i *ICAPRequest) requestedURL() string {
+	if i.req.Request == nil || i.req.Request.URL == nil {
+		return ""
+	}
+	return i.req.Request.URL.String()
+}
+
+// shadowWriter is the ResponseWriter of the processing of a shadow service in the background, the ICAP client
+// was answered already, so what the processing writes is discarded
+type shadowWriter struct {
+	header http.Header
+}
+
+func (w *shadowWriter) Header() http.Header { return w.header }
+
+func (w *shadowWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+func (w *shadowWriter) WriteRaw(string) {}
+
+func (w *shadowWriter) WriteHeader(int, interface{}, bool) {}
+
+func (w *shadowWriter) Flush() error { return nil }
+
+func (w *shadowWriter) Abort() {}
+
+// shadowCopy returns a copy of the ICAP request which a shadow service processes in the background, it has its
+// own headers and writer, so the processing doesn't race with the logs of the ICAP response which was written
+func (i *ICAPRequest) shadowCopy() *ICAPRequest {
+	req := *i.req
+	req.Header = textproto.MIMEHeader(http.Header(i.req.Header).Clone())
+	if i.req.Request != nil {
+		req.Request = i.req.Request.Clone(i.req.Request.Context())
+	}
+	if i.req.Response != nil {
+		response := *i.req.Response
+		response.Header = i.req.Response.Header.Clone()
+		req.Response = &response
+	}
+	shadowRequest := *i
+	shadowRequest.req = &req
+	shadowRequest.w = &shadowWriter{header: i.h.Clone()}
+	shadowRequest.h = shadowRequest.w.Header()
+	return &shadowRequest
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	general_functions "icapeg/service/services-utilities/general-functions"
+	"icapeg/service/services-utilities/spool"
+	"icapeg/service/services-utilities/statistics"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// sizeLimit returns the max_filesize of the service and its size_limit_action if it bypasses or blocks the larger
+// bodies before they're read and sent to the vendor, 0 if the vendor decides. The shadow services never answer
+// the ICAP client, their vendors get the whole body
+func (i *ICAPRequest) sizeLimit() (int, string) {
+	serviceInstance := i.appCfg.ServicesInstances[i.serviceName]
+	if serviceInstance == nil || serviceInstance.MaxFileSize <= 0 || i.methodName == utils.ICAPModeOptions ||
+		i.isShadowServiceEnabled {
+		return 0, ""
+	}
+	switch serviceInstance.SizeLimitAction {
+	case utils.SizeLimitActionBypass, utils.SizeLimitActionBlock:
+		return serviceInstance.MaxFileSize, serviceInstance.SizeLimitAction
+	}
+	return 0, ""
+}
+
+// enforceDeclaredSizeLimit is a func to bypass or block the HTTP message before reading its body if its
+// Content-Length exceeds the max_filesize of the service. It returns true if the ICAP request was answered
+func (i *ICAPRequest) enforceDeclaredSizeLimit(xICAPMetadata string) bool {
+	maxFileSize, action := i.sizeLimit()
+	if maxFileSize == 0 {
+		return false
+	}
+	var header http.Header
+	if i.methodName == utils.ICAPModeResp && i.req.Response != nil {
+		header = i.req.Response.Header
+	} else if i.methodName == utils.ICAPModeReq && i.req.Request != nil {
+		header = i.req.Request.Header
+	}
+	declared, err := strconv.ParseInt(header.Get(utils.ContentLength), 10, 64)
+	if err != nil || declared <= int64(maxFileSize) {
+		return false
+	}
+	i.answerSizeLimit(action, declared, nil, false, xICAPMetadata)
+	return true
+}
+
+// limitScannedBody is a func to stop reading the body one byte beyond the max_filesize of the service if it
+// bypasses or blocks the larger bodies, so the bodies without a Content-Length (chunked) are caught without
+// reading the rest of them. A body which was spooled already is returned as it is
+func (i *ICAPRequest) limitScannedBody(body io.Reader) io.Reader {
+	maxFileSize, _ := i.sizeLimit()
+	if _, isSpooled := spool.Of(body); maxFileSize == 0 || isSpooled {
+		return body
+	}
+	return io.LimitReader(body, int64(maxFileSize)+1)
+}
+
+// enforceSizeLimit is a func to bypass or block the HTTP message whose body which was read exceeds the
+// max_filesize of the service, rest is the rest of the body which wasn't read, nil if the rest of the body after
+// a preview was being read. It returns true if the ICAP request was answered
+func (i *ICAPRequest) enforceSizeLimit(body *spool.Body, rest io.Reader, xICAPMetadata string) bool {
+	maxFileSize, action := i.sizeLimit()
+	if maxFileSize == 0 || body.Size() <= int64(maxFileSize) {
+		return false
+	}
+	if rest == nil {
+		i.answerSizeLimit(action, body.Size(), nil, true, xICAPMetadata)
+		return true
+	}
+	i.answerSizeLimit(action, body.Size(), io.MultiReader(body.Open(), rest), false, xICAPMetadata)
+	return true
+}
+
+// answerSizeLimit is a func to answer the ICAP request whose body exceeds the max_filesize of the service without
+// scanning it, the HTTP message is returned as it is if the service bypasses the larger bodies and replaced by the
+// block page otherwise. size is the declared length of the body, or the bytes which were read before the limit was
+// reached. body is the whole body which is streamed back to the ICAP client if 204 isn't allowed, nil if none of it
+// was read yet. The connection is closed once the response is written if the rest of the body after a preview was
+// being read, since it can't be skipped
+func (i *ICAPRequest) answerSizeLimit(action string, size int64, body io.Reader, afterPreview bool,
+	xICAPMetadata string) {
+	maxFileSize, _ := i.sizeLimit()
+	details := map[string]interface{}{
+		"service":      i.serviceName,
+		"method":       i.methodName,
+		"size":         size,
+		"max_filesize": maxFileSize,
+		"action":       action,
+	}
+	logging.Logger.Warn(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventSizeLimit, details))
+	i.verdict = statistics.VerdictNone
+	if afterPreview {
+		i.w.Header().Set("Connection", "close")
+	}
+	if action == utils.SizeLimitActionBypass {
+		if i.bypass204Allowed() {
+			i.w.WriteHeader(utils.NoModificationStatusCodeStr, nil, false)
+			return
+		}
+		if i.methodName == utils.ICAPModeReq {
+			if body != nil {
+				i.req.Request.Body = io.NopCloser(body)
+			}
+			i.w.WriteHeader(utils.OkStatusCodeStr, i.req.Request, true)
+		} else {
+			if body != nil {
+				i.req.Response.Body = io.NopCloser(body)
+			}
+			i.w.WriteHeader(utils.OkStatusCodeStr, i.req.Response, true)
+		}
+		return
+	}
+
+	i.verdict = statistics.VerdictOversize
+	i.publishBlock(utils.EventSizeLimit, details, xICAPMetadata)
+	httpMsg := &http_message.HttpMsg{Request: i.req.Request, Response: i.req.Response}
+	requestURI := "-"
+	if i.req.Request != nil && i.req.Request.URL != nil {
+		requestURI = i.req.Request.URL.String()
+	}
+	generalFunc := general_functions.NewGeneralFunc(httpMsg, xICAPMetadata)
+	htmlPage := generalFunc.GenHtmlPage(utils.BlockPagePath, utils.ErrPageReasonMaxFileExceeded, i.serviceName, "-",
+		requestURI, strconv.FormatInt(size, 10), xICAPMetadata)
+	response := generalFunc.ErrPageResp(http.StatusForbidden, htmlPage.Len())
+	i.alteringBlockResponse(response)
+	i.w.WriteHeader(utils.OkStatusCodeStr, response, true)
+	io.Copy(i.w, htmlPage)
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"icapeg/service/services-utilities/spool"
+	"io"
+	"net/http"
+)
+
+// spoolBody is a func to read the body of the HTTP message from the ICAP client once, up to the max_size of
+// [app.body_limit], the bytes above the memory limit of [app.body_spooling] are kept in a temporary file. A body
+// which was spooled already (ex: by the shadow service) isn't read again
+func (i *ICAPRequest) spoolBody(body io.Reader) (*spool.Body, error) {
+	if spooled, isSpooled := spool.Of(body); isSpooled {
+		return spooled, nil
+	}
+	return spool.Read(i.limitBody(body))
+}
+
+// bodyOf is a func to get the body of the HTTP message for the steps which inspect it, the steps read a
+// spooled body with Open, Head or ReadAt instead of holding a copy of it in memory, so the HTTP message keeps
+// streaming it to the service. Any other body (ex: one which a step replaced) is spooled in memory and the
+// HTTP message gets a reader of it
+func bodyOf(body *io.ReadCloser) (*spool.Body, error) {
+	if spooled, isSpooled := spool.Of(*body); isSpooled {
+		return spooled, nil
+	}
+	if *body == nil {
+		*body = http.NoBody
+	}
+	spooled, err := spool.New(*body, 0, "")
+	*body = spooled.Open()
+	return spooled, err
+}
+
+// retainBody is a func to keep the spooled body for a goroutine which may outlive the transaction (ex: a scan
+// which continues after the max wait of the service), the returned func releases it
+func (i *ICAPRequest) retainBody() func() {
+	body := i.body
+	if body == nil {
+		return func() {}
+	}
+	body.Retain()
+	return func() { body.Close() }
+}
+
+// originalBody is a func to get a reader of the spooled body from its start, for the ICAP responses which
+// return the HTTP message as it is
+func (i *ICAPRequest) originalBody(body io.ReadCloser) io.ReadCloser {
+	if i.body == nil {
+		return body
+	}
+	return i.body.Open()
+}
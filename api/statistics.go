@@ -0,0 +1,36 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	"icapeg/service/services-utilities/statistics"
+	"time"
+)
+
+// verdictOf returns the verdict of the vendor messages for the statistics, the verdict is pending if it's
+// awaited after the max wait or after an interim response which isn't completed upon it
+func verdictOf(vendorMsgs map[string]interface{}, interim bool) string {
+	if _, exists := vendorMsgs[utils.VendorMsgMaxWait]; exists || (interim && vendorMsgs == nil) {
+		return statistics.VerdictPending
+	}
+	if vendorMsgs[utils.VendorMsgVerdict] == utils.SampleSeverityMalicious {
+		return statistics.VerdictMalicious
+	}
+	if _, exists := vendorMsgs[utils.VendorMsgError]; exists {
+		return statistics.VerdictError
+	}
+	return statistics.VerdictClean
+}
+
+// recordStatistics is a func to count the transaction in the statistics which the admin API exports,
+// the transactions which didn't reach a service aren't counted
+func (i *ICAPRequest) recordStatistics(start time.Time) {
+	if !statistics.Enabled() || i.methodName == utils.ICAPModeOptions {
+		return
+	}
+	verdict := i.verdict
+	if verdict == "" {
+		verdict = statistics.VerdictNone
+	}
+	statistics.Record(statistics.Key{Service: i.serviceName, Vendor: i.vendor, Verdict: verdict}, i.scannedBytes,
+		time.Since(start))
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"icapeg/cache"
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/digests"
+	general_functions "icapeg/service/services-utilities/general-functions"
+	"icapeg/service/services-utilities/streaming"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// isStreamingMedia is a func to check if the HTTP response of RESPMOD is streaming media upon its Content-Type
+func (i *ICAPRequest) isStreamingMedia(p *streaming.Policy) bool {
+	return p != nil && i.methodName == utils.ICAPModeResp && i.req.Response != nil &&
+		p.Matches(i.req.Response.Header.Get(utils.ContentType))
+}
+
+// bypassStreamingMedia is a func to return the HTTP response of streaming media as it is before its body is
+// read, the body is streamed back to the ICAP client as it arrives if 204 isn't allowed. It returns true if
+// the ICAP request was answered
+func (i *ICAPRequest) bypassStreamingMedia(xICAPMetadata string) bool {
+	p := streaming.Current()
+	if !i.isStreamingMedia(p) || p.Action != streaming.ActionBypass {
+		return false
+	}
+	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
+		"bypassing the streaming media "+i.req.Response.Header.Get(utils.ContentType)))
+	if i.bypass204Allowed() {
+		i.w.WriteHeader(utils.NoModificationStatusCodeStr, nil, false)
+		return true
+	}
+	i.w.WriteHeader(utils.OkStatusCodeStr, i.req.Response, true)
+	return true
+}
+
+// checkStreamingMediaHash is a func to check the file hash of the HTTP response of streaming media in the
+// hash lists instead of sending it to the vendor, it's blocked if it's in the denylist and returned as it is
+// otherwise. It returns true if the ICAP request was answered
+func (i *ICAPRequest) checkStreamingMediaHash(partial bool, xICAPMetadata string) bool {
+	p := streaming.Current()
+	if !i.isStreamingMedia(p) || p.Action != streaming.ActionHashOnly {
+		return false
+	}
+	if partial {
+		// the rest of the body is read after the preview bytes again
+		whole, err := i.preview(xICAPMetadata)
+		if err != nil {
+			i.badRequest(err, xICAPMetadata)
+			return true
+		}
+		defer whole.Close()
+		i.body = whole
+	}
+	body := i.body
+	if i.isOversize(int(body.Size())) {
+		i.rejectOversize(body.Size(), partial, xICAPMetadata)
+		return true
+	}
+	if partial && i.enforceSizeLimit(body, nil, xICAPMetadata) {
+		return true
+	}
+	i.scannedBytes = int(body.Size())
+	fileSize := strconv.FormatInt(body.Size(), 10)
+	i.req.Response.Header.Set(utils.ContentLength, fileSize)
+	i.req.Response.Body = body.Open()
+
+	fileDigests, _ := digests.Compute(body.Open())
+	fileHash := fileDigests[digests.SHA256]
+	list, entry, found := cache.LookupHashList(fileHash)
+	if !found || list != cache.DenyListName {
+		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
+			"the file hash of the streaming media isn't in the denylist, it's returned as it is"))
+		i.returnOriginal()
+		return true
+	}
+
+	generalFunc := general_functions.NewGeneralFunc(&http_message.HttpMsg{Request: i.req.Request,
+		Response: i.req.Response}, xICAPMetadata)
+	vendorMsgs := map[string]interface{}{
+		"hash_list":                list,
+		utils.VendorMsgVerdict:     utils.SampleSeverityMalicious,
+		utils.VendorMsgThreat:      strings.TrimSuffix("Denylisted: "+entry.Comment, ": "),
+		utils.VendorMsgFileName:    generalFunc.GetFileName(),
+		utils.VendorMsgFileHash:    fileHash,
+		utils.VendorMsgFileDigests: fileDigests,
+		utils.VendorMsgFileSize:    fileSize,
+	}
+	requestURI := "-"
+	if i.req.Request != nil && i.req.Request.URL != nil {
+		requestURI = i.req.Request.URL.String()
+	}
+	generalFunc.SetThreatName(vendorMsg(vendorMsgs, utils.VendorMsgThreat))
+	htmlPage := generalFunc.GenHtmlPage(utils.BlockPagePath, utils.ErrPageReasonFileIsNotSafe, i.serviceName,
+		fileHash, requestURI, fileSize, xICAPMetadata)
+	response := generalFunc.ErrPageResp(http.StatusForbidden, htmlPage.Len())
+	i.verdict = verdictOf(vendorMsgs, false)
+	i.threat = vendorMsg(vendorMsgs, utils.VendorMsgThreat)
+	i.notifyVerdict(response, vendorMsgs, xICAPMetadata)
+	i.w.WriteHeader(utils.OkStatusCodeStr, response, true)
+	io.Copy(i.w, htmlPage)
+	return true
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"errors"
+	utils "icapeg/consts"
+	"icapeg/events"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/bulkhead"
+	"strconv"
+	"strings"
+)
+
+// resolveTenant is a func to find the tenant of the ICAP request from the URL prefix (/tenant/service)
+// or from the tenant header, and to replace the requested service with the service of that tenant
+func (i *ICAPRequest) resolveTenant(xICAPMetadata string) error {
+	if len(i.appCfg.Tenants) == 0 {
+		return nil
+	}
+	tenant := ""
+	if slash := strings.Index(i.serviceName, "/"); slash != -1 {
+		tenant, i.serviceName = strings.ToLower(i.serviceName[:slash]), i.serviceName[slash+1:]
+	} else if i.appCfg.TenantHeader != "" {
+		tenant = strings.ToLower(i.req.Header.Get(i.appCfg.TenantHeader))
+	}
+	if tenant == "" {
+		return nil
+	}
+	tenantCfg, exists := i.appCfg.Tenants[tenant]
+	if !exists {
+		return errors.New("tenant " + tenant + " doesn't exist")
+	}
+	serviceName, exists := tenantCfg.Services[strings.ToLower(i.serviceName)]
+	if !exists {
+		return errors.New("service " + i.serviceName + " doesn't exist in " + tenant + " tenant")
+	}
+	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
+		"the requested service "+i.serviceName+" of "+tenant+" tenant is served by "+serviceName+" service"))
+	i.tenant = tenant
+	i.serviceName = serviceName
+	utils.SetTransactionTenant(xICAPMetadata, tenant)
+	return nil
+}
+
+// acquireBulkheads is a func to wait for a free worker of the ICAP server, then for a free slot in the bulkhead
+// of the tenant and in the bulkhead of the service, it returns ICAP 503 response if one of them is full unless
+// the service fails open. The body is read already, so the slow ICAP clients don't hold the workers
+func (i *ICAPRequest) acquireBulkheads(xICAPMetadata string) (func(), bool) {
+	releaseWorker, acquired := i.req.AcquireWorker()
+	if !acquired {
+		logging.Logger.Warn(utils.PrepareLogMsg(xICAPMetadata,
+			"no worker of the ICAP server is free, returning "+strconv.Itoa(utils.ServiceOverloadedCodeStr)))
+		i.overloaded(0, xICAPMetadata)
+		return nil, false
+	}
+	names := []string{i.serviceName}
+	if i.tenant != "" {
+		names = []string{bulkhead.TenantName(i.tenant), i.serviceName}
+	}
+	releases := []func(){releaseWorker}
+	release := func() {
+		for _, r := range releases {
+			r()
+		}
+	}
+	for _, name := range names {
+		r, acquired := bulkhead.Acquire(name)
+		if !acquired {
+			release()
+			logging.Logger.Warn(utils.PrepareLogMsg(xICAPMetadata,
+				name+" has too many in-flight requests, returning "+strconv.Itoa(utils.ServiceOverloadedCodeStr)))
+			events.Emit(&events.Event{Type: events.TypeBulkheadSaturated,
+				Bulkhead: &events.BulkheadSaturatedEvent{XICAPMetadata: xICAPMetadata, Bulkhead: name}})
+			i.overloaded(bulkhead.RetryAfter(name), xICAPMetadata)
+			return nil, false
+		}
+		releases = append(releases, r)
+	}
+	releases = append(releases, scanStarted(i.serviceName))
+	return release, true
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	services_utilities "icapeg/service/services-utilities"
+	"icapeg/service/services-utilities/statistics"
+)
+
+// addThreatHeaders is a func to add the threat headers (X-Infection-Found, X-Violations-Found, X-Response-Info
+// and X-Response-Desc) of a malicious verdict whose vendor didn't add them, ex: a vendor of a plugin, so the ICAP
+// clients log the threats of every service
+func (i *ICAPRequest) addThreatHeaders(vendorMsgs map[string]interface{}) {
+	if i.verdict != statistics.VerdictMalicious || i.h.Get(services_utilities.InfectionFoundHeader) != "" {
+		return
+	}
+	fileName := vendorMsg(vendorMsgs, utils.VendorMsgArchiveMember)
+	if fileName == "" {
+		fileName = vendorMsg(vendorMsgs, utils.VendorMsgFileName)
+	}
+	headers := make(map[string]string)
+	services_utilities.AddThreatHeaders(headers, services_utilities.Violation{FileName: fileName, Threat: i.threat,
+		Type: services_utilities.ThreatTypeInfection, Resolution: services_utilities.ResolutionBlocked})
+	for key, value := range headers {
+		i.h[key] = []string{value}
+	}
+}
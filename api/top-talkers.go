@@ -0,0 +1,15 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	"icapeg/service/services-utilities/toptalkers"
+)
+
+// countTalker is a func to count the request and the scanned bytes of the HTTP client in the top talkers,
+// the blocks are counted upon the verdict since it may arrive after the ICAP response
+func (i *ICAPRequest) countTalker() {
+	if !toptalkers.Enabled() || i.methodName == utils.ICAPModeOptions {
+		return
+	}
+	toptalkers.Request(i.clientIP(), i.scannedBytes)
+}
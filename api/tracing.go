@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	utils "icapeg/consts"
+	"icapeg/service/services-utilities/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// endTrace ends the root span of the transaction with its service, its ICAP status code and its verdict
+func (i *ICAPRequest) endTrace(span trace.Span, w *accessLogWriter, xICAPMetadata string) {
+	span.SetAttributes(
+		attribute.String("icap.transaction_id", xICAPMetadata),
+		attribute.String("icap.service", i.serviceName),
+		attribute.Int("icap.status_code", w.statusCode),
+	)
+	if i.verdict != "" {
+		span.SetAttributes(attribute.String("icap.verdict", i.verdict))
+	}
+	if i.threat != "" {
+		span.SetAttributes(attribute.String("icap.threat", i.threat))
+	}
+	if w.statusCode >= 500 {
+		span.SetStatus(codes.Error, utils.PrepareLogMsg(xICAPMetadata, "ICAP "+i.req.Method+" failed"))
+	}
+	span.End()
+}
+
+// startVendorTrace starts the span of the call of the vendor of the service, the vendor traces its backend calls
+// as its children until the returned func ends it with the result of the vendor
+func (i *ICAPRequest) startVendorTrace(xICAPMetadata string) func(r processingResult) {
+	ctx, span := tracing.Start(i.traceCtx, "vendor "+i.vendor,
+		attribute.String("icap.service", i.serviceName),
+		attribute.String("icap.vendor", i.vendor),
+		attribute.Int("icap.scanned_bytes", i.scannedBytes),
+	)
+	tracing.Bind(xICAPMetadata, ctx)
+	return func(r processingResult) {
+		tracing.Forget(xICAPMetadata)
+		span.SetAttributes(attribute.Int("icap.status_code", r.IcapStatusCode))
+		if verdict := vendorMsg(r.vendorMsgs, utils.VendorMsgVerdict); verdict != "" {
+			span.SetAttributes(attribute.String("icap.verdict", verdict))
+		}
+		var err error
+		if vendorErr := vendorMsg(r.vendorMsgs, utils.VendorMsgError); vendorErr != "" {
+			err = errors.New(vendorErr)
+		}
+		tracing.End(span, err)
+	}
+}
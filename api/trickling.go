@@ -0,0 +1,96 @@
+package api
+
+import (
+	"icapeg/config"
+	utils "icapeg/consts"
+	"icapeg/icap"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/spool"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// trickle drips the original bytes of an HTTP response to the ICAP client while the vendor is scanning it,
+// so the browser doesn't time out on big downloads. The last byte is held back until the verdict arrives
+type trickle struct {
+	w    icap.ResponseWriter
+	cfg  *config.TricklingConfig
+	body *spool.Body
+	mu   sync.Mutex
+	sent int64
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startTrickling sends the HTTP response headers and starts dripping the body to the ICAP client
+func (i *ICAPRequest) startTrickling(headerOnly *http.Response, body *spool.Body, xICAPMetadata string) *trickle {
+	cfg := i.appCfg.ServicesInstances[i.serviceName].Trickling
+	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
+		"the vendor is still scanning after "+cfg.Delay.String()+", trickling the original bytes to the ICAP client"))
+	t := &trickle{w: i.w, cfg: cfg, body: body, stop: make(chan struct{}), done: make(chan struct{})}
+	i.w.WriteHeader(utils.OkStatusCodeStr, headerOnly, true)
+	go t.drip()
+	return t
+}
+
+// wait stops dripping once the service returned its verdict
+func (t *trickle) wait() {
+	close(t.stop)
+	<-t.done
+}
+
+// drip writes bytes per interval of the original body to the ICAP client until stop is closed
+func (t *trickle) drip() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if !t.write(int64(t.cfg.BytesPerInterval), t.body.Size()-1) {
+			return
+		}
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// write sends up to n bytes of the body without passing limit, it returns false if nothing is left to
+// send or the connection is broken
+func (t *trickle) write(n, limit int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	end := t.sent + n
+	if end > limit {
+		end = limit
+	}
+	if end <= t.sent {
+		return false
+	}
+	if _, err := io.Copy(t.w, io.NewSectionReader(t.body, t.sent, end-t.sent)); err != nil {
+		return false
+	}
+	t.sent = end
+	return t.w.Flush() == nil
+}
+
+// finish completes the trickled response according to the verdict, the rest of the original bytes are sent
+// if the file is clean, otherwise the connection is aborted so the ICAP client discards the partial download
+func (t *trickle) finish(IcapStatusCode int, httpMsg interface{}, vendorMsgs map[string]interface{},
+	xICAPMetadata string) int {
+	if vendorMsgs[utils.VendorMsgVerdict] == utils.SampleSeverityMalicious ||
+		vendorMsgs[utils.VendorMsgMaxWait] == utils.MaxWaitActionBlock ||
+		(IcapStatusCode != utils.NoModificationStatusCodeStr && IcapStatusCode != utils.OkStatusCodeStr) {
+		logging.Logger.Info(utils.PrepareLogMsg(xICAPMetadata, "aborting the trickled response after sending "+
+			strconv.FormatInt(t.sent, 10)+" bytes, the service returned "+strconv.Itoa(IcapStatusCode)))
+		t.w.Abort()
+		return IcapStatusCode
+	}
+	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "sending the rest of the trickled response"))
+	t.write(t.body.Size(), t.body.Size())
+	return utils.OkStatusCodeStr
+}
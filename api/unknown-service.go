@@ -0,0 +1,66 @@
+package api
+
+import (
+	"errors"
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	general_functions "icapeg/service/services-utilities/general-functions"
+	"icapeg/version"
+	"io"
+	"net/http"
+)
+
+// the ISTag of the ICAP responses to the unknown services
+const unknownServiceISTag = "\"ICAPeg-unknown\""
+
+// answerUnknownService is a func to answer an ICAP request whose URL path isn't a service or an alias of a
+// service, with 404 by default or with the HTTP message as it is or a block page if the unknown_service action
+// says so, since some proxies fail the request of the user on an ICAP 404. The OPTIONS requests get the
+// capabilities of a service which accepts both modes, so the proxies don't mark the path down
+func (i *ICAPRequest) answerUnknownService(xICAPMetadata string) error {
+	err := errors.New("service doesn't exist")
+	action := i.appCfg.UnknownServiceAction
+	logging.Logger.Error(utils.PrepareLogMsg(xICAPMetadata, "the requested service "+i.serviceName+
+		" doesn't exist, answering with the "+action+" action"))
+	if action == utils.UnknownServiceActionNotFound {
+		i.w.WriteHeader(utils.ICAPServiceNotFoundCodeStr, nil, false)
+		return err
+	}
+
+	i.methodName = i.req.Method
+	i.h["ISTag"] = []string{i.istagValue(unknownServiceISTag)}
+	if i.methodName == utils.ICAPModeOptions {
+		i.h.Set("Methods", utils.ICAPModeResp+", "+utils.ICAPModeReq)
+		i.h.Set("Allow", "204")
+		i.h.Set("X-ICAP-Server", version.ServerHeader())
+		i.addingProfileOptionsHeaders(xICAPMetadata)
+		i.w.WriteHeader(http.StatusOK, nil, false)
+		return err
+	}
+	if encapErr := i.validateEncapsulation(xICAPMetadata); encapErr != nil {
+		return encapErr
+	}
+
+	if action == utils.UnknownServiceActionBypass {
+		i.Is204Allowed = i.is204Allowed(xICAPMetadata)
+		i.Is204Allowed = i.bypass204Allowed()
+		i.HostHeader()
+		i.returnOriginal()
+		return err
+	}
+
+	httpMsg := &http_message.HttpMsg{Request: i.req.Request, Response: i.req.Response}
+	requestURI := "-"
+	if i.req.Request != nil && i.req.Request.URL != nil {
+		requestURI = i.req.Request.URL.String()
+	}
+	generalFunc := general_functions.NewGeneralFunc(httpMsg, xICAPMetadata)
+	htmlPage := generalFunc.GenHtmlPage(utils.BlockPagePath, utils.ErrPageReasonUnknownService, i.serviceName, "-",
+		requestURI, "0", xICAPMetadata)
+	response := generalFunc.ErrPageResp(http.StatusForbidden, htmlPage.Len())
+	i.alteringBlockResponse(response)
+	i.w.WriteHeader(utils.OkStatusCodeStr, response, true)
+	io.Copy(i.w, htmlPage)
+	return err
+}
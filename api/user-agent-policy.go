@@ -0,0 +1,76 @@
+package api
+
+import (
+	"icapeg/config"
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	general_functions "icapeg/service/services-utilities/general-functions"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// userAgentRule returns the first User-Agent policy of the service whose pattern matches the User-Agent of the
+// encapsulated HTTP request, nil if none matches
+func (i *ICAPRequest) userAgentRule() *config.UserAgentRuleConfig {
+	serviceInstance := i.appCfg.ServicesInstances[i.serviceName]
+	if serviceInstance == nil || len(serviceInstance.UserAgentRules) == 0 || i.req.Request == nil {
+		return nil
+	}
+	userAgent := i.req.Request.Header.Get("User-Agent")
+	for _, rule := range serviceInstance.UserAgentRules {
+		if rule.Pattern.MatchString(userAgent) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// applyUserAgentPolicy is a func to apply the User-Agent policy of the service to the HTTP message, so the
+// downloads of the scripting tools (ex: curl, wget, powershell) are scanned whatever their extension is and
+// the known software update agents are relaxed. The scan and profile actions replace the scan profile which the
+// ICAP client asked for, the bypass and block actions answer the ICAP request and true is returned
+func (i *ICAPRequest) applyUserAgentPolicy(xICAPMetadata string) bool {
+	rule := i.userAgentRule()
+	if rule == nil {
+		return false
+	}
+	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "the User-Agent matches "+rule.Name+
+		" policy of "+i.serviceName+" service, its action is "+rule.Action))
+	switch rule.Action {
+	case utils.UserAgentActionScan, utils.UserAgentActionProfile:
+		i.scanProfile = i.appCfg.ServicesInstances[i.serviceName].Profiles[rule.Profile]
+		utils.SetTransactionProfile(xICAPMetadata, rule.Profile)
+		i.h.Set(i.appCfg.ScanProfileHeader, i.scanProfile.Name)
+		return false
+	case utils.UserAgentActionBypass:
+		i.Is204Allowed = i.bypass204Allowed()
+		i.returnOriginal()
+		return true
+	}
+
+	details := map[string]interface{}{
+		"service":    i.serviceName,
+		"method":     i.methodName,
+		"policy":     rule.Name,
+		"action":     rule.Action,
+		"user_agent": i.req.Request.Header.Get("User-Agent"),
+	}
+	logging.Logger.Info(utils.PrepareEventLogMsg(xICAPMetadata, utils.EventUserAgentPolicy, details))
+	i.publishBlock(utils.EventUserAgentPolicy, details, xICAPMetadata)
+	httpMsg := &http_message.HttpMsg{Request: i.req.Request, Response: i.req.Response}
+	requestURI := "-"
+	if i.req.Request.URL != nil {
+		requestURI = i.req.Request.URL.String()
+	}
+	generalFunc := general_functions.NewGeneralFunc(httpMsg, xICAPMetadata)
+	htmlPage := generalFunc.GenHtmlPage(utils.BlockPagePath, utils.ErrPageReasonClientBlocked, i.serviceName, "-",
+		requestURI, strconv.Itoa(i.scannedBytes), xICAPMetadata)
+	response := generalFunc.ErrPageResp(http.StatusForbidden, htmlPage.Len())
+	i.alteringBlockResponse(response)
+	i.w.WriteHeader(utils.OkStatusCodeStr, response, true)
+	io.Copy(i.w, htmlPage)
+	i.allHeaders(utils.OkStatusCodeStr, nil, nil, map[string]interface{}{utils.VendorMsgUserAgent: rule.Name}, xICAPMetadata)
+	return true
+}
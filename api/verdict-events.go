@@ -0,0 +1,71 @@
+package api
+
+import (
+	"fmt"
+	utils "icapeg/consts"
+	"icapeg/events"
+	"net"
+)
+
+// publishVerdict is a func to send the verdict of the service to the event sinks, it's called again
+// with the final verdict when the verdict was pending
+func (i *ICAPRequest) publishVerdict(vendorMsgs map[string]interface{}, xICAPMetadata string) {
+	if !events.Enabled() || vendorMsgs == nil {
+		return
+	}
+	events.Publish(i.verdictEvent(vendorMsgs, xICAPMetadata))
+}
+
+// publishBlock is a func to send the blocked event of the ICAP request which was blocked before its service
+// scanned it, reason is the event of its log and details are the fields of that log
+func (i *ICAPRequest) publishBlock(reason string, details map[string]interface{}, xICAPMetadata string) {
+	if !events.Subscribed(events.TypeBlocked) {
+		return
+	}
+	icapClient, _, err := net.SplitHostPort(i.req.RemoteAddr)
+	if err != nil {
+		icapClient = i.req.RemoteAddr
+	}
+	events.Blocked(&events.BlockedEvent{
+		XICAPMetadata: xICAPMetadata,
+		ServiceName:   i.serviceName,
+		Method:        i.methodName,
+		Reason:        reason,
+		ICAPClient:    icapClient,
+		ClientIP:      i.clientIP(),
+		Username:      i.clientUsername(),
+		RequestedURL:  i.requestedURL(),
+		Details:       details,
+	})
+}
+
+// verdictEvent returns the verdict event of the transaction from the vendor messages of the service
+func (i *ICAPRequest) verdictEvent(vendorMsgs map[string]interface{}, xICAPMetadata string) *events.VerdictEvent {
+	fileDigests, _ := vendorMsgs[utils.VendorMsgFileDigests].(map[string]string)
+	return &events.VerdictEvent{
+		XICAPMetadata: xICAPMetadata,
+		ServiceName:   i.serviceName,
+		Tenant:        i.tenant,
+		Vendor:        i.vendor,
+		Method:        i.methodName,
+		Verdict:       verdictOf(vendorMsgs, false),
+		ClientIP:      i.clientIP(),
+		Username:      i.clientUsername(),
+		RequestedURL:  i.requestedURL(),
+		FileName:      vendorMsg(vendorMsgs, utils.VendorMsgFileName),
+		FileHash:      vendorMsg(vendorMsgs, utils.VendorMsgFileHash),
+		FileDigests:   fileDigests,
+		FileSize:      vendorMsg(vendorMsgs, utils.VendorMsgFileSize),
+		Threat:        vendorMsg(vendorMsgs, utils.VendorMsgThreat),
+		Delivered:     i.deliveredBeforeScan,
+	}
+}
+
+// vendorMsg returns a vendor message as a string, it's empty if the vendor didn't send the message
+func vendorMsg(vendorMsgs map[string]interface{}, key string) string {
+	value, exists := vendorMsgs[key]
+	if !exists || value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"net"
+	"strings"
+)
+
+// resolveVirtualHost is a func to find the virtual host of the ICAP request from the authority of its URL or
+// from its Host header, and to replace the requested service with the service of that virtual host. It
+// returns false if the host isn't a virtual host, and true with an empty service name if the virtual host
+// doesn't expose the requested service
+func (i *ICAPRequest) resolveVirtualHost(xICAPMetadata string) (bool, string) {
+	if len(i.appCfg.VirtualHosts) == 0 {
+		return false, ""
+	}
+	host := i.req.URL.Host
+	if host == "" {
+		host = i.req.Header.Get("Host")
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	hostCfg, exists := i.appCfg.VirtualHosts[host]
+	if !exists {
+		return false, ""
+	}
+	serviceName, exists := hostCfg.Services[strings.ToLower(i.serviceName)]
+	if !exists {
+		logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata,
+			"the requested service "+i.serviceName+" isn't exposed by "+hostCfg.Name+" virtual host"))
+		return true, ""
+	}
+	logging.Logger.Debug(utils.PrepareLogMsg(xICAPMetadata, "the requested service "+i.serviceName+" of "+
+		hostCfg.Name+" virtual host ("+host+") is served by "+serviceName+" service"))
+	return true, serviceName
+}
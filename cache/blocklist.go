@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// BlocklistName is the name of the hash blocklist in the caches registry
+const BlocklistName = "blocklist"
+
+// BlockedHash is a file hash which was found malicious after the file was delivered
+type BlockedHash struct {
+	ServiceName string    `json:"service_name"`
+	Threat      string    `json:"threat"`
+	BlockedAt   time.Time `json:"blocked_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// HashBlocklist is an in-memory list of malicious file hashes, the files with a blocked hash
+// are always scanned before they are delivered
+type HashBlocklist struct {
+	mu      sync.Mutex
+	entries map[string]BlockedHash
+	now     func() time.Time
+}
+
+var hashBlocklist = NewHashBlocklist()
+
+func init() {
+	Register(BlocklistName, hashBlocklist)
+}
+
+// NewHashBlocklist creates an empty hash blocklist
+func NewHashBlocklist() *HashBlocklist {
+	return &HashBlocklist{entries: make(map[string]BlockedHash), now: time.Now}
+}
+
+// Block adds the file hash to the blocklist for ttl, a ttl of zero means forever
+func (b *HashBlocklist) Block(fileHash, serviceName, threat string, ttl time.Duration) BlockedHash {
+	now := b.now()
+	blocked := BlockedHash{ServiceName: serviceName, Threat: threat, BlockedAt: now}
+	if ttl > 0 {
+		blocked.ExpiresAt = now.Add(ttl)
+	}
+	b.Put(fileHash, blocked)
+	return blocked
+}
+
+// Put adds the blocked hash as it is, ex: a hash which another instance blocked
+func (b *HashBlocklist) Put(fileHash string, blocked BlockedHash) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries[fileHash] = blocked
+}
+
+// Get returns the entry of the file hash if it's blocked
+func (b *HashBlocklist) Get(fileHash string) (BlockedHash, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	blocked, exists := b.entries[fileHash]
+	if !exists {
+		return BlockedHash{}, false
+	}
+	if !blocked.ExpiresAt.IsZero() && !b.now().Before(blocked.ExpiresAt) {
+		delete(b.entries, fileHash)
+		return BlockedHash{}, false
+	}
+	return blocked, true
+}
+
+// Flush removes all the hashes of the blocklist
+func (b *HashBlocklist) Flush() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	flushed := len(b.entries)
+	b.entries = make(map[string]BlockedHash)
+	return flushed
+}
+
+// Len returns the number of the blocked hashes, the expired ones are counted until they are looked up
+func (b *HashBlocklist) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.entries)
+}
+
+// BlockHash adds the file hash to the hash blocklist
+func BlockHash(fileHash, serviceName, threat string, ttl time.Duration) {
+	blocked := hashBlocklist.Block(fileHash, serviceName, threat, ttl)
+	if r := getReplicator(); r != nil {
+		r.HashBlocked(fileHash, blocked)
+	}
+}
+
+// IsHashBlocked reports whether the file hash is in the hash blocklist
+func IsHashBlocked(fileHash string) bool {
+	_, blocked := hashBlocklist.Get(fileHash)
+	return blocked
+}
@@ -0,0 +1,353 @@
+package cache
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	verdictsBucket = []byte("verdicts")
+	lruBucket      = []byte("lru")
+)
+
+// storedVerdict is the value which is stored in the verdicts bucket
+type storedVerdict struct {
+	Verdict    Verdict `json:"verdict"`
+	LastAccess int64   `json:"last_access"`
+}
+
+// the interval of saving the accesses of the verdicts which were read, a read doesn't write the database
+const accessSaveInterval = 10 * time.Second
+
+// BoltStore persists the verdicts in a bbolt database so they survive restarts,
+// the store is bounded by size and evicts the least recently used verdicts
+//
+// The lru bucket is an index ordered by the last access time, its keys are
+// the 8 bytes big endian last access time followed by the verdict key. The
+// accesses of the reads are kept in memory and saved in batches, every write
+// and the periodic save move them to the index before it's used
+type BoltStore struct {
+	mu       sync.Mutex
+	db       *bolt.DB
+	maxBytes int64
+	size     int64
+
+	accessMu sync.Mutex
+	accessed map[string]int64 // the last access of the verdicts which were read, 0 = expired
+	done     chan struct{}
+}
+
+// OpenBoltStore opens (or creates) the database file, max bytes of zero means unlimited
+func OpenBoltStore(path string, maxBytes int64) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	s := &BoltStore{db: db, maxBytes: maxBytes, accessed: make(map[string]int64), done: make(chan struct{})}
+	size := int64(0)
+	err = db.Update(func(tx *bolt.Tx) error {
+		verdicts, err := tx.CreateBucketIfNotExists(verdictsBucket)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.CreateBucketIfNotExists(lruBucket); err != nil {
+			return err
+		}
+		return verdicts.ForEach(func(k, v []byte) error {
+			size += int64(len(k) + len(v))
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s.size = size
+	go s.saveAccessesPeriodically()
+	return s, nil
+}
+
+func lruKey(lastAccess int64, key string) []byte {
+	result := make([]byte, 8+len(key))
+	binary.BigEndian.PutUint64(result, uint64(lastAccess))
+	copy(result[8:], key)
+	return result
+}
+
+// Get returns the verdict of the key and marks it as recently used, the access is saved later
+func (s *BoltStore) Get(key string, now time.Time) (Verdict, bool) {
+	var stored storedVerdict
+	found := false
+	s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(verdictsBucket).Get([]byte(key))
+		found = raw != nil && json.Unmarshal(raw, &stored) == nil && now.Before(stored.Verdict.ExpiresAt)
+		if raw != nil && !found {
+			// the expired or unreadable verdict is removed with the next save
+			s.access(key, 0)
+		}
+		return nil
+	})
+	if !found {
+		return Verdict{}, false
+	}
+	s.access(key, now.UnixNano())
+	return stored.Verdict, true
+}
+
+// access records the last access of the verdict which was read until it's saved
+func (s *BoltStore) access(key string, lastAccess int64) {
+	s.accessMu.Lock()
+	s.accessed[key] = lastAccess
+	s.accessMu.Unlock()
+}
+
+// Put stores the verdict and evicts the least recently used verdicts if the store exceeded its size
+func (s *BoltStore) Put(key string, verdict Verdict, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	accessed := s.takeAccesses()
+	delete(accessed, key)
+	sizeChange := int64(0)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if sizeChange, err = s.saveAccesses(tx, accessed, now); err != nil {
+			return err
+		}
+		change, err := s.put(tx, key, verdict, now, tx.Bucket(verdictsBucket).Get([]byte(key)))
+		if err != nil {
+			return err
+		}
+		sizeChange += change
+		lru := tx.Bucket(lruBucket).Cursor()
+		for k, _ := lru.First(); k != nil && s.maxBytes > 0 && s.size+sizeChange > s.maxBytes; k, _ = lru.First() {
+			evicted := string(k[8:])
+			if change, err = s.delete(tx, evicted, tx.Bucket(verdictsBucket).Get([]byte(evicted))); err != nil {
+				return err
+			}
+			sizeChange += change
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.size += sizeChange
+	return nil
+}
+
+// takeAccesses returns the accesses which weren't saved and forgets them
+func (s *BoltStore) takeAccesses() map[string]int64 {
+	s.accessMu.Lock()
+	defer s.accessMu.Unlock()
+	accessed := s.accessed
+	s.accessed = make(map[string]int64)
+	return accessed
+}
+
+// saveAccesses moves the verdicts which were read to their last access in the lru index and removes the
+// expired ones, it returns the change of the size of the store
+func (s *BoltStore) saveAccesses(tx *bolt.Tx, accessed map[string]int64, now time.Time) (int64, error) {
+	sizeChange := int64(0)
+	for key, lastAccess := range accessed {
+		raw := tx.Bucket(verdictsBucket).Get([]byte(key))
+		if raw == nil {
+			continue
+		}
+		var stored storedVerdict
+		if err := json.Unmarshal(raw, &stored); err != nil || !now.Before(stored.Verdict.ExpiresAt) {
+			change, err := s.delete(tx, key, raw)
+			if err != nil {
+				return 0, err
+			}
+			sizeChange += change
+			continue
+		}
+		if lastAccess <= stored.LastAccess {
+			continue
+		}
+		change, err := s.put(tx, key, stored.Verdict, time.Unix(0, lastAccess), raw)
+		if err != nil {
+			return 0, err
+		}
+		sizeChange += change
+	}
+	return sizeChange, nil
+}
+
+// saveAccessesPeriodically saves the accesses of the reads every accessSaveInterval until the store is closed
+func (s *BoltStore) saveAccessesPeriodically() {
+	ticker := time.NewTicker(accessSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.flushAccesses()
+		}
+	}
+}
+
+// flushAccesses saves the accesses of the reads in their own transaction
+func (s *BoltStore) flushAccesses() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	accessed := s.takeAccesses()
+	if len(accessed) == 0 {
+		return nil
+	}
+	sizeChange := int64(0)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		sizeChange, err = s.saveAccesses(tx, accessed, time.Now())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.size += sizeChange
+	return nil
+}
+
+// put writes the verdict and its lru index, old is the current value of the key if it exists. It returns the
+// change of the size of the store, which is applied once the transaction is committed
+func (s *BoltStore) put(tx *bolt.Tx, key string, verdict Verdict, now time.Time, old []byte) (int64, error) {
+	sizeChange, err := s.delete(tx, key, old)
+	if err != nil {
+		return 0, err
+	}
+	raw, err := json.Marshal(&storedVerdict{Verdict: verdict, LastAccess: now.UnixNano()})
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Bucket(verdictsBucket).Put([]byte(key), raw); err != nil {
+		return 0, err
+	}
+	if err = tx.Bucket(lruBucket).Put(lruKey(now.UnixNano(), key), nil); err != nil {
+		return 0, err
+	}
+	return sizeChange + int64(len(key)+len(raw)), nil
+}
+
+// delete removes the verdict and its lru index, raw is the current value of the key. It returns the change
+// of the size of the store, which is applied once the transaction is committed
+func (s *BoltStore) delete(tx *bolt.Tx, key string, raw []byte) (int64, error) {
+	if raw == nil {
+		return 0, nil
+	}
+	var stored storedVerdict
+	if err := json.Unmarshal(raw, &stored); err == nil {
+		if err = tx.Bucket(lruBucket).Delete(lruKey(stored.LastAccess, key)); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Bucket(verdictsBucket).Delete([]byte(key)); err != nil {
+		return 0, err
+	}
+	return -int64(len(key) + len(raw)), nil
+}
+
+// Find returns the unexpired entries whose key ends with the file hash
+func (s *BoltStore) Find(fileHash string, now time.Time) []Entry {
+	var result []Entry
+	s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(verdictsBucket).ForEach(func(k, v []byte) error {
+			if !strings.HasSuffix(string(k), "|"+fileHash) {
+				return nil
+			}
+			var stored storedVerdict
+			if err := json.Unmarshal(v, &stored); err != nil || !now.Before(stored.Verdict.ExpiresAt) {
+				return nil
+			}
+			serviceName, signatureVersion, hash := splitKey(string(k))
+			result = append(result, Entry{
+				ServiceName:      serviceName,
+				SignatureVersion: signatureVersion,
+				FileHash:         hash,
+				Verdict:          stored.Verdict,
+			})
+			return nil
+		})
+	})
+	return result
+}
+
+// Delete removes the verdicts of a file hash, if service name is empty the verdicts of all services are removed
+func (s *BoltStore) Delete(fileHash, serviceName string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted, sizeChange := 0, int64(0)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var keys []string
+		tx.Bucket(verdictsBucket).ForEach(func(k, v []byte) error {
+			keyService, _, hash := splitKey(string(k))
+			if hash == fileHash && (serviceName == "" || serviceName == keyService) {
+				keys = append(keys, string(k))
+			}
+			return nil
+		})
+		for _, key := range keys {
+			change, err := s.delete(tx, key, tx.Bucket(verdictsBucket).Get([]byte(key)))
+			if err != nil {
+				return err
+			}
+			sizeChange += change
+			deleted++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0
+	}
+	s.size += sizeChange
+	return deleted
+}
+
+// Flush removes all the verdicts of the store
+func (s *BoltStore) Flush() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flushed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		flushed = tx.Bucket(verdictsBucket).Stats().KeyN
+		for _, bucket := range [][]byte{verdictsBucket, lruBucket} {
+			if err := tx.DeleteBucket(bucket); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0
+	}
+	s.takeAccesses()
+	s.size = 0
+	return flushed
+}
+
+// Size returns the number of bytes of the stored keys and values
+func (s *BoltStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Close saves the accesses of the reads and closes the database
+func (s *BoltStore) Close() error {
+	close(s.done)
+	s.flushAccesses()
+	return s.db.Close()
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "verdicts.db")
+	now := time.Now()
+	store, err := OpenBoltStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verdict := Verdict{Malicious: true, Threat: "Eicar-Signature", StoredAt: now, ExpiresAt: now.Add(time.Hour)}
+	if err = store.Put(Key("clamav", "1", "bad-hash"), verdict, now); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = OpenBoltStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	c := NewVerdictCache(time.Hour, time.Hour, 0)
+	c.store = store
+	got, found := c.Get(Key("clamav", "1", "bad-hash"))
+	if !found || got.Threat != "Eicar-Signature" {
+		t.Fatalf("verdict should survive reopening the store, got %v %v", got, found)
+	}
+	if entries := c.Find("bad-hash"); len(entries) != 1 {
+		t.Fatalf("found %d entries, expected 1", len(entries))
+	}
+	if deleted := c.Delete("bad-hash", ""); deleted != 1 || store.Size() != 0 {
+		t.Fatalf("deleted %d entries, store size %d", deleted, store.Size())
+	}
+}
+
+func TestBoltStoreEviction(t *testing.T) {
+	now := time.Now()
+	verdict := Verdict{StoredAt: now, ExpiresAt: now.Add(time.Hour)}
+	store, err := OpenBoltStore(filepath.Join(t.TempDir(), "verdicts.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.Put("a", verdict, now)
+	// limit the store to roughly two entries
+	store.maxBytes = store.Size()*2 + 1
+	store.Put("b", verdict, now.Add(time.Second))
+	store.Get("a", now.Add(2*time.Second))
+	store.Put("c", verdict, now.Add(3*time.Second))
+	if _, found := store.Get("b", now.Add(4*time.Second)); found {
+		t.Fatalf("the least recently used verdict should be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, found := store.Get(key, now.Add(5*time.Second)); !found {
+			t.Fatalf("verdict %s should still be stored", key)
+		}
+	}
+}
+
+func TestBoltStoreSavesTheReadsLater(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "verdicts.db")
+	now := time.Now()
+	verdict := Verdict{StoredAt: now, ExpiresAt: now.Add(time.Hour)}
+	store, err := OpenBoltStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put("a", verdict, now)
+	store.Put("b", verdict, now.Add(time.Second))
+	writes := func() int64 {
+		stats := store.db.Stats()
+		return stats.TxStats.GetWrite()
+	}
+	written := writes()
+	if _, found := store.Get("a", now.Add(2*time.Second)); !found {
+		t.Fatal("verdict a should be stored")
+	}
+	if writes() != written {
+		t.Fatal("reading a verdict shouldn't write the database")
+	}
+	size := store.Size()
+	store.Close()
+
+	// the access of a is saved by Close, so b is the least recently used verdict after reopening the store
+	store, err = OpenBoltStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if store.Size() != size {
+		t.Fatalf("the size of the reopened store is %d, expected %d", store.Size(), size)
+	}
+	store.maxBytes = size + 1
+	store.Put("c", verdict, now.Add(3*time.Second))
+	if _, found := store.Get("b", now.Add(4*time.Second)); found {
+		t.Fatalf("the least recently used verdict should be evicted")
+	}
+	if _, found := store.Get("a", now.Add(4*time.Second)); !found {
+		t.Fatalf("the verdict which was read should still be stored")
+	}
+}
@@ -0,0 +1,384 @@
+package cache
+
+import (
+	"container/list"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Verdict is the result of scanning a file which is stored in the verdict cache
+type Verdict struct {
+	Malicious bool      `json:"malicious"`
+	Threat    string    `json:"threat"`
+	StoredAt  time.Time `json:"stored_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Store keeps the verdicts of the cache beyond the memory of the instance, the cache is written through to it
+// and the verdicts which aren't in memory are read from it, ex: a bbolt database or Redis
+type Store interface {
+	Get(key string, now time.Time) (Verdict, bool)
+	Put(key string, verdict Verdict, now time.Time) error
+	Find(fileHash string, now time.Time) []Entry
+	Delete(fileHash, serviceName string) int
+	Flush() int
+	Size() int64
+	Close() error
+}
+
+// ttls are the TTLs of the malicious and the clean verdicts of a service
+type ttls struct {
+	malicious time.Duration
+	clean     time.Duration
+}
+
+type entry struct {
+	key     string
+	verdict Verdict
+}
+
+// VerdictCache is an in-memory LRU cache of verdicts keyed by service name,
+// vendor signature version and the SHA-256 of the file. mu guards the memory of the cache only, the store is
+// called without it so a slow store doesn't serialize the lookups which memory answers
+type VerdictCache struct {
+	mu           sync.Mutex
+	maliciousTTL time.Duration
+	cleanTTL     time.Duration
+	maxEntries   int
+	serviceTTLs  map[string]ttls // the services whose TTLs replace the TTLs of the cache
+	entries      map[string]*list.Element
+	lru          *list.List
+	hits         uint64
+	misses       uint64
+	evictions    uint64
+	store        Store
+	now          func() time.Time
+}
+
+// Stats represents the statistics of a verdict cache
+type Stats struct {
+	Entries      int    `json:"entries"`
+	MaxEntries   int    `json:"max_entries"`
+	Hits         uint64 `json:"hits"`
+	Misses       uint64 `json:"misses"`
+	Evictions    uint64 `json:"evictions"`
+	MaliciousTTL string `json:"malicious_ttl"`
+	CleanTTL     string `json:"clean_ttl"`
+	DiskBytes    int64  `json:"disk_bytes,omitempty"`
+}
+
+// Entry is a verdict with the parts of its cache key
+type Entry struct {
+	ServiceName      string  `json:"service_name"`
+	SignatureVersion string  `json:"signature_version"`
+	FileHash         string  `json:"file_hash"`
+	Verdict          Verdict `json:"verdict"`
+}
+
+var verdictCache *VerdictCache
+
+// InitVerdictCache reads [app.verdict_cache] section, the cache stays disabled if the section doesn't exist.
+// The services which have a verdict_cache sub section cache their verdicts with their own TTLs
+func InitVerdictCache(services []string) {
+	if !readValues.IsSecExists("app.verdict_cache") || !readValues.ReadValuesBool("app.verdict_cache.enabled") {
+		return
+	}
+	logging.Logger.Info("loading the verdict cache configuration")
+	verdictCache = NewVerdictCache(
+		readValues.ReadValuesDuration("app.verdict_cache.malicious_ttl")*time.Second,
+		readValues.ReadValuesDuration("app.verdict_cache.clean_ttl")*time.Second,
+		readValues.ReadValuesInt("app.verdict_cache.max_entries"))
+	for _, serviceName := range services {
+		if !readValues.IsSecExists(serviceName + ".verdict_cache") {
+			continue
+		}
+		verdictCache.SetServiceTTLs(serviceName,
+			readValues.ReadValuesDuration(serviceName+".verdict_cache.malicious_ttl")*time.Second,
+			readValues.ReadValuesDuration(serviceName+".verdict_cache.clean_ttl")*time.Second)
+	}
+	if readValues.IsSecExists("app.verdict_cache.redis") && readValues.ReadValuesBool("app.verdict_cache.redis.enabled") {
+		store, err := OpenRedisStore(readValues.ReadValuesString("app.verdict_cache.redis.addr"),
+			readValues.ReadValuesString("app.verdict_cache.redis.password"),
+			readValues.ReadValuesInt("app.verdict_cache.redis.db"),
+			readValues.ReadValuesString("app.verdict_cache.redis.key_prefix"))
+		if err != nil {
+			logging.Logger.Error("couldn't connect to the Redis of the verdict cache, verdicts are cached in memory only: " + err.Error())
+		} else {
+			verdictCache.store = store
+		}
+	} else if readValues.IsSecExists("app.verdict_cache.persistent") &&
+		readValues.ReadValuesBool("app.verdict_cache.persistent.enabled") {
+		store, err := OpenBoltStore(readValues.ReadValuesString("app.verdict_cache.persistent.path"),
+			int64(readValues.ReadValuesInt("app.verdict_cache.persistent.max_size_mb"))*1024*1024)
+		if err != nil {
+			logging.Logger.Error("couldn't open the persistent verdict cache, verdicts are cached in memory only: " + err.Error())
+		} else {
+			verdictCache.store = store
+		}
+	}
+	Register(VerdictCacheName, verdictCache)
+}
+
+// NewVerdictCache creates a verdict cache, a TTL of zero disables caching that kind of verdicts
+// and max entries of zero means unlimited
+func NewVerdictCache(maliciousTTL, cleanTTL time.Duration, maxEntries int) *VerdictCache {
+	return &VerdictCache{
+		maliciousTTL: maliciousTTL,
+		cleanTTL:     cleanTTL,
+		maxEntries:   maxEntries,
+		serviceTTLs:  make(map[string]ttls),
+		entries:      make(map[string]*list.Element),
+		lru:          list.New(),
+		now:          time.Now,
+	}
+}
+
+// SetServiceTTLs sets the TTLs of the verdicts of a service, ex: a hash lookup whose verdicts change less often
+// than the verdicts of an antivirus
+func (c *VerdictCache) SetServiceTTLs(serviceName string, maliciousTTL, cleanTTL time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.serviceTTLs[serviceName] = ttls{malicious: maliciousTTL, clean: cleanTTL}
+}
+
+// Key returns the cache key of a file, including the signature version in the key
+// makes definition updates invalidate the old verdicts
+func Key(serviceName, signatureVersion, fileHash string) string {
+	return serviceName + "|" + signatureVersion + "|" + fileHash
+}
+
+// splitKey returns the service name, the signature version and the file hash of a key
+func splitKey(key string) (string, string, string) {
+	first := strings.Index(key, "|")
+	last := strings.LastIndex(key, "|")
+	if first == -1 || first == last {
+		return "", "", key
+	}
+	return key[:first], key[first+1 : last], key[last+1:]
+}
+
+// Get returns the verdict of the key if it exists and hasn't expired, the verdicts which aren't in memory are
+// read from the store
+func (c *VerdictCache) Get(key string) (Verdict, bool) {
+	c.mu.Lock()
+	if element, exists := c.entries[key]; exists {
+		e := element.Value.(*entry)
+		if !c.now().Before(e.verdict.ExpiresAt) {
+			c.removeElement(element)
+			c.misses++
+			c.mu.Unlock()
+			return Verdict{}, false
+		}
+		c.lru.MoveToFront(element)
+		c.hits++
+		verdict := e.verdict
+		c.mu.Unlock()
+		return verdict, true
+	}
+	c.mu.Unlock()
+
+	verdict, found := Verdict{}, false
+	if c.store != nil {
+		verdict, found = c.store.Get(key, c.now())
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !found {
+		c.misses++
+		return Verdict{}, false
+	}
+	// a verdict which was put while the store was read is newer
+	if _, exists := c.entries[key]; !exists {
+		c.add(key, verdict)
+	}
+	c.hits++
+	return verdict, true
+}
+
+// Set stores the verdict with the TTL of its kind, malicious verdicts and clean verdicts have separate TTLs,
+// the TTLs of the service of the key replace the TTLs of the cache. It returns false if the TTL of the kind is zero
+func (c *VerdictCache) Set(key string, malicious bool, threat string) (Verdict, bool) {
+	serviceName, _, _ := splitKey(key)
+	c.mu.Lock()
+	t, exists := c.serviceTTLs[serviceName]
+	c.mu.Unlock()
+	if !exists {
+		t = ttls{malicious: c.maliciousTTL, clean: c.cleanTTL}
+	}
+	ttl := t.clean
+	if malicious {
+		ttl = t.malicious
+	}
+	if ttl <= 0 {
+		return Verdict{}, false
+	}
+	now := c.now()
+	verdict := Verdict{Malicious: malicious, Threat: threat, StoredAt: now, ExpiresAt: now.Add(ttl)}
+	c.Put(key, verdict)
+	return verdict, true
+}
+
+// Put stores the verdict as it is, ex: a verdict which another instance stored
+func (c *VerdictCache) Put(key string, verdict Verdict) {
+	c.mu.Lock()
+	c.add(key, verdict)
+	c.mu.Unlock()
+	if c.store != nil {
+		if err := c.store.Put(key, verdict, c.now()); err != nil {
+			logging.Logger.Error("couldn't store the verdict in the persistent verdict cache: " + err.Error())
+		}
+	}
+}
+
+// add stores the verdict in memory and evicts the least recently used verdicts above max entries
+func (c *VerdictCache) add(key string, verdict Verdict) {
+	if element, exists := c.entries[key]; exists {
+		element.Value.(*entry).verdict = verdict
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&entry{key: key, verdict: verdict})
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.removeElement(c.lru.Back())
+		c.evictions++
+	}
+}
+
+// Stats returns the statistics of the cache
+func (c *VerdictCache) Stats() Stats {
+	var diskBytes int64
+	if c.store != nil {
+		diskBytes = c.store.Size()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		DiskBytes:    diskBytes,
+		Entries:      c.lru.Len(),
+		MaxEntries:   c.maxEntries,
+		Hits:         c.hits,
+		Misses:       c.misses,
+		Evictions:    c.evictions,
+		MaliciousTTL: c.maliciousTTL.String(),
+		CleanTTL:     c.cleanTTL.String(),
+	}
+}
+
+// Find returns all the unexpired verdicts of a file hash, one verdict per service and signature version
+func (c *VerdictCache) Find(fileHash string) []Entry {
+	if c.store != nil {
+		// the persistent store is written through, so it has every verdict which is in memory
+		return c.store.Find(fileHash, c.now())
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var result []Entry
+	now := c.now()
+	for key, element := range c.entries {
+		serviceName, signatureVersion, hash := splitKey(key)
+		verdict := element.Value.(*entry).verdict
+		if hash == fileHash && now.Before(verdict.ExpiresAt) {
+			result = append(result, Entry{
+				ServiceName:      serviceName,
+				SignatureVersion: signatureVersion,
+				FileHash:         hash,
+				Verdict:          verdict,
+			})
+		}
+	}
+	return result
+}
+
+// Delete removes the verdicts of a file hash, if service name is empty the verdicts of all services are removed
+func (c *VerdictCache) Delete(fileHash, serviceName string) int {
+	c.mu.Lock()
+	deleted := 0
+	for key, element := range c.entries {
+		keyService, _, hash := splitKey(key)
+		if hash == fileHash && (serviceName == "" || serviceName == keyService) {
+			c.removeElement(element)
+			deleted++
+		}
+	}
+	c.mu.Unlock()
+	if c.store != nil {
+		deleted = c.store.Delete(fileHash, serviceName)
+	}
+	return deleted
+}
+
+// Flush removes all the entries of the cache
+func (c *VerdictCache) Flush() int {
+	c.mu.Lock()
+	flushed := c.lru.Len()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.mu.Unlock()
+	if c.store != nil {
+		flushed = c.store.Flush()
+	}
+	return flushed
+}
+
+// Len returns the number of entries in the cache
+func (c *VerdictCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *VerdictCache) removeElement(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*entry).key)
+}
+
+// GetVerdict looks up the verdict of a file in the verdict cache if it's enabled
+func GetVerdict(serviceName, signatureVersion, fileHash string) (Verdict, bool) {
+	if verdictCache == nil {
+		return Verdict{}, false
+	}
+	return verdictCache.Get(Key(serviceName, signatureVersion, fileHash))
+}
+
+// VerdictCacheStats returns the statistics of the verdict cache, it returns false if the cache is disabled
+func VerdictCacheStats() (Stats, bool) {
+	if verdictCache == nil {
+		return Stats{}, false
+	}
+	return verdictCache.Stats(), true
+}
+
+// LookupVerdicts returns the cached verdicts of a file hash
+func LookupVerdicts(fileHash string) []Entry {
+	if verdictCache == nil {
+		return nil
+	}
+	return verdictCache.Find(fileHash)
+}
+
+// DeleteVerdicts removes the cached verdicts of a file hash
+func DeleteVerdicts(fileHash, serviceName string) int {
+	if verdictCache == nil {
+		return 0
+	}
+	if r := getReplicator(); r != nil {
+		r.VerdictsDeleted(fileHash, serviceName)
+	}
+	return verdictCache.Delete(fileHash, serviceName)
+}
+
+// SetVerdict stores the verdict of a file in the verdict cache if it's enabled
+func SetVerdict(serviceName, signatureVersion, fileHash string, malicious bool, threat string) {
+	if verdictCache == nil {
+		return
+	}
+	key := Key(serviceName, signatureVersion, fileHash)
+	if verdict, stored := verdictCache.Set(key, malicious, threat); stored {
+		if r := getReplicator(); r != nil {
+			r.VerdictStored(key, verdict)
+		}
+	}
+}
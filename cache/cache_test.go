@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerdictCacheTTL(t *testing.T) {
+	now := time.Now()
+	c := NewVerdictCache(time.Hour, time.Minute, 0)
+	c.now = func() time.Time { return now }
+	c.Set(Key("clamav", "ClamAV 0.103/1", "clean-hash"), false, "")
+	c.Set(Key("clamav", "ClamAV 0.103/1", "bad-hash"), true, "Eicar-Signature")
+
+	now = now.Add(2 * time.Minute)
+	if _, found := c.Get(Key("clamav", "ClamAV 0.103/1", "clean-hash")); found {
+		t.Fatalf("clean verdict should expire after the clean TTL")
+	}
+	verdict, found := c.Get(Key("clamav", "ClamAV 0.103/1", "bad-hash"))
+	if !found || !verdict.Malicious || verdict.Threat != "Eicar-Signature" {
+		t.Fatalf("malicious verdict should still be cached, got %v %v", verdict, found)
+	}
+	if _, found := c.Get(Key("clamav", "ClamAV 0.103/2", "bad-hash")); found {
+		t.Fatalf("a new signature version should not reuse old verdicts")
+	}
+}
+
+func TestVerdictCacheServiceTTLs(t *testing.T) {
+	now := time.Now()
+	c := NewVerdictCache(time.Hour, time.Minute, 0)
+	c.now = func() time.Time { return now }
+	c.SetServiceTTLs("clhashlookup", time.Hour, time.Hour)
+	c.Set(Key("clamav", "", "clean-hash"), false, "")
+	c.Set(Key("clhashlookup", "", "clean-hash"), false, "")
+
+	now = now.Add(2 * time.Minute)
+	if _, found := c.Get(Key("clamav", "", "clean-hash")); found {
+		t.Fatalf("the clean verdict of clamav should expire after the clean TTL of the cache")
+	}
+	if _, found := c.Get(Key("clhashlookup", "", "clean-hash")); !found {
+		t.Fatalf("the clean verdict of clhashlookup should be cached for the clean TTL of the service")
+	}
+}
+
+func TestVerdictCacheEviction(t *testing.T) {
+	c := NewVerdictCache(time.Hour, time.Hour, 2)
+	c.Set("a", false, "")
+	c.Set("b", false, "")
+	c.Get("a")
+	c.Set("c", false, "")
+	if _, found := c.Get("b"); found {
+		t.Fatalf("the least recently used entry should be evicted")
+	}
+	if c.Len() != 2 {
+		t.Fatalf("cache has %d entries, expected 2", c.Len())
+	}
+}
+
+func TestVerdictCacheZeroTTL(t *testing.T) {
+	c := NewVerdictCache(time.Hour, 0, 0)
+	c.Set("clean", false, "")
+	if c.Len() != 0 {
+		t.Fatalf("clean verdicts should not be cached when clean TTL is zero")
+	}
+}
+
+func TestHashBlocklist(t *testing.T) {
+	now := time.Now()
+	b := NewHashBlocklist()
+	b.now = func() time.Time { return now }
+	b.Block("bad-hash", "clamav", "Eicar-Signature", time.Hour)
+	b.Block("forever-hash", "clamav", "Eicar-Signature", 0)
+	if blocked, exists := b.Get("bad-hash"); !exists || blocked.Threat != "Eicar-Signature" {
+		t.Fatalf("expected bad-hash to be blocked, got %v %v", blocked, exists)
+	}
+	now = now.Add(2 * time.Hour)
+	if _, exists := b.Get("bad-hash"); exists {
+		t.Fatalf("bad-hash should expire after its TTL")
+	}
+	if _, exists := b.Get("forever-hash"); !exists {
+		t.Fatalf("a hash blocked without TTL should never expire")
+	}
+	if flushed := b.Flush(); flushed != 1 {
+		t.Fatalf("expected 1 flushed hash, got %d", flushed)
+	}
+}
+
+// slowStore is a store whose reads wait until release is closed, reading is sent once a read started
+type slowStore struct {
+	reading chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStore) Get(key string, now time.Time) (Verdict, bool) {
+	s.reading <- struct{}{}
+	<-s.release
+	return Verdict{Threat: "from the store", ExpiresAt: now.Add(time.Hour)}, true
+}
+func (s *slowStore) Put(key string, verdict Verdict, now time.Time) error { return nil }
+func (s *slowStore) Find(fileHash string, now time.Time) []Entry          { return nil }
+func (s *slowStore) Delete(fileHash, serviceName string) int              { return 0 }
+func (s *slowStore) Flush() int                                           { return 0 }
+func (s *slowStore) Size() int64                                          { return 0 }
+func (s *slowStore) Close() error                                         { return nil }
+
+func TestVerdictCacheDoesntWaitForTheStore(t *testing.T) {
+	store := &slowStore{reading: make(chan struct{}, 2), release: make(chan struct{})}
+	c := NewVerdictCache(time.Hour, time.Hour, 0)
+	c.store = store
+	c.Set("in-memory", true, "Eicar-Signature")
+
+	fromStore := make(chan Verdict)
+	go func() {
+		verdict, _ := c.Get("in-store")
+		fromStore <- verdict
+	}()
+	<-store.reading
+	// the verdict in memory is returned while the store is read
+	done := make(chan struct{})
+	go func() {
+		c.Get("in-memory")
+		c.Set("other", false, "")
+		c.Stats()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the cache waited for the store to answer a verdict which is in memory")
+	}
+	close(store.release)
+	if verdict := <-fromStore; verdict.Threat != "from the store" {
+		t.Fatalf("unexpected verdict of the store %+v", verdict)
+	}
+	if verdict, found := c.Get("in-store"); !found || verdict.Threat != "from the store" {
+		t.Fatalf("the verdict of the store should be kept in memory, got %+v", verdict)
+	}
+}
@@ -0,0 +1,283 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// the names of the hash lists
+const (
+	AllowListName = "allow"
+	DenyListName  = "deny"
+)
+
+// HashListEntry is a file hash in the allowlist or the denylist, an entry without expiry never expires
+type HashListEntry struct {
+	Hash      string     `json:"hash"`
+	Comment   string     `json:"comment,omitempty"`
+	AddedAt   time.Time  `json:"added_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// HashLists are the allowlist and the denylist of file hashes which are checked before calling the vendors,
+// the lists are persisted in a JSON file which is reloaded when it changes, so the instances which share
+// the file (ex: on a shared volume) share the lists
+type HashLists struct {
+	mu      sync.RWMutex
+	path    string
+	lists   map[string]map[string]HashListEntry
+	feeds   map[string]feedHashes
+	modTime time.Time
+	now     func() time.Time
+}
+
+// feedHashes are the file hashes of a feed, they're replaced at once when the feed is updated and they
+// aren't saved in the file
+type feedHashes struct {
+	list    string
+	entries map[string]HashListEntry
+}
+
+var hashLists *HashLists
+
+// InitHashLists reads the optional [app.hash_lists] section, the hash lists stay disabled if it doesn't exist
+func InitHashLists() {
+	if !readValues.IsSecExists("app.hash_lists") || !readValues.ReadValuesBool("app.hash_lists.enabled") {
+		return
+	}
+	logging.Logger.Info("loading the hash lists")
+	lists, err := OpenHashLists(readValues.ReadValuesString("app.hash_lists.path"))
+	if err != nil {
+		logging.Logger.Error("couldn't load the hash lists, they are disabled: " + err.Error())
+		return
+	}
+	hashLists = lists
+	if interval := readValues.ReadValuesDuration("app.hash_lists.reload_interval") * time.Second; interval > 0 {
+		go lists.reloadLoop(interval)
+	}
+}
+
+// OpenHashLists loads the hash lists from the file, the file is created when the lists change
+func OpenHashLists(path string) (*HashLists, error) {
+	h := &HashLists{
+		path: path,
+		lists: map[string]map[string]HashListEntry{
+			AllowListName: make(map[string]HashListEntry),
+			DenyListName:  make(map[string]HashListEntry),
+		},
+		feeds: make(map[string]feedHashes),
+		now:   time.Now,
+	}
+	if err := h.reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// reload reads the file if it changed since the last time it was read
+func (h *HashLists) reload() error {
+	info, err := os.Stat(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if info.ModTime().Equal(h.modTime) {
+		return nil
+	}
+	raw, err := os.ReadFile(h.path)
+	if err != nil {
+		return err
+	}
+	stored := make(map[string][]HashListEntry)
+	if err = json.Unmarshal(raw, &stored); err != nil {
+		return err
+	}
+	for name := range h.lists {
+		h.lists[name] = make(map[string]HashListEntry)
+		for _, entry := range stored[name] {
+			h.lists[name][entry.Hash] = entry
+		}
+	}
+	h.modTime = info.ModTime()
+	return nil
+}
+
+func (h *HashLists) reloadLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := h.reload(); err != nil {
+			logging.Logger.Error("couldn't reload the hash lists: " + err.Error())
+		}
+	}
+}
+
+// save writes the lists to a temporary file and renames it, so the other instances never read a partial file
+func (h *HashLists) save() error {
+	stored := make(map[string][]HashListEntry)
+	for name := range h.lists {
+		stored[name] = h.sorted(name)
+	}
+	raw, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(h.path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := h.path + ".tmp"
+	if err = os.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, h.path); err != nil {
+		return err
+	}
+	if info, err := os.Stat(h.path); err == nil {
+		h.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// sorted returns the unexpired entries of the list ordered by the time they were added
+func (h *HashLists) sorted(name string) []HashListEntry {
+	now := h.now()
+	result := []HashListEntry{}
+	for _, entry := range h.lists[name] {
+		if entry.ExpiresAt == nil || now.Before(*entry.ExpiresAt) {
+			result = append(result, entry)
+		}
+	}
+	sort.Slice(result, func(a, b int) bool { return result[a].AddedAt.Before(result[b].AddedAt) })
+	return result
+}
+
+// Add adds the file hash to the list, a ttl of zero means the entry never expires
+func (h *HashLists) Add(name, fileHash, comment string, ttl time.Duration) (HashListEntry, error) {
+	entry := HashListEntry{Hash: fileHash, Comment: comment, AddedAt: h.now()}
+	if ttl > 0 {
+		expiresAt := entry.AddedAt.Add(ttl)
+		entry.ExpiresAt = &expiresAt
+	}
+	if err := h.Put(name, entry); err != nil {
+		return entry, err
+	}
+	if r := getReplicator(); r != nil {
+		r.HashListChanged(name, entry, false)
+	}
+	return entry, nil
+}
+
+// Put adds the entry to the list as it is, ex: an entry which another instance added
+func (h *HashLists) Put(name string, entry HashListEntry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	list, exists := h.lists[name]
+	if !exists {
+		return errors.New("hash list " + name + " doesn't exist")
+	}
+	list[entry.Hash] = entry
+	return h.save()
+}
+
+// Remove removes the file hash from the list, it returns false if the hash isn't in the list
+func (h *HashLists) Remove(name, fileHash string) (bool, error) {
+	removed, err := h.Delete(name, fileHash)
+	if removed {
+		if r := getReplicator(); r != nil {
+			r.HashListChanged(name, HashListEntry{Hash: fileHash}, true)
+		}
+	}
+	return removed, err
+}
+
+// Delete removes the file hash from the list without telling the other instances
+func (h *HashLists) Delete(name, fileHash string) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	list, exists := h.lists[name]
+	if !exists {
+		return false, errors.New("hash list " + name + " doesn't exist")
+	}
+	if _, exists = list[fileHash]; !exists {
+		return false, nil
+	}
+	delete(list, fileHash)
+	return true, h.save()
+}
+
+// List returns the unexpired entries of the list
+func (h *HashLists) List(name string) ([]HashListEntry, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if _, exists := h.lists[name]; !exists {
+		return nil, errors.New("hash list " + name + " doesn't exist")
+	}
+	return h.sorted(name), nil
+}
+
+// Lookup returns the list which has the file hash, the denylist has priority over the allowlist
+func (h *HashLists) Lookup(fileHash string) (string, HashListEntry, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	now := h.now()
+	for _, name := range []string{DenyListName, AllowListName} {
+		entry, exists := h.lists[name][fileHash]
+		if exists && (entry.ExpiresAt == nil || now.Before(*entry.ExpiresAt)) {
+			return name, entry, true
+		}
+		for _, feed := range h.feeds {
+			if entry, exists = feed.entries[fileHash]; exists && feed.list == name {
+				return name, entry, true
+			}
+		}
+	}
+	return "", HashListEntry{}, false
+}
+
+// SetFeed replaces the file hashes of the feed in the list, they're added to the entries of the list
+func (h *HashLists) SetFeed(feed, name string, hashes []string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, exists := h.lists[name]; !exists {
+		return errors.New("hash list " + name + " doesn't exist")
+	}
+	now := h.now()
+	entries := make(map[string]HashListEntry, len(hashes))
+	for _, fileHash := range hashes {
+		entries[fileHash] = HashListEntry{Hash: fileHash, Comment: "feed " + feed, AddedAt: now}
+	}
+	h.feeds[feed] = feedHashes{list: name, entries: entries}
+	return nil
+}
+
+// SetFeedHashes replaces the file hashes of the feed in the list if the hash lists are enabled
+func SetFeedHashes(feed, name string, hashes []string) error {
+	if hashLists == nil {
+		return errors.New("the hash lists are disabled")
+	}
+	return hashLists.SetFeed(feed, name, hashes)
+}
+
+// LookupHashList returns the list which has the file hash if the hash lists are enabled
+func LookupHashList(fileHash string) (string, HashListEntry, bool) {
+	if hashLists == nil {
+		return "", HashListEntry{}, false
+	}
+	return hashLists.Lookup(fileHash)
+}
+
+// GetHashLists returns the hash lists, it returns nil if they are disabled
+func GetHashLists() *HashLists {
+	return hashLists
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHashListsLookup(t *testing.T) {
+	now := time.Now()
+	h, err := OpenHashLists(filepath.Join(t.TempDir(), "hash-lists.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.now = func() time.Time { return now }
+	h.Add(AllowListName, "internal-tool", "signed by us", 0)
+	h.Add(AllowListName, "both", "", 0)
+	h.Add(DenyListName, "both", "incident 42", 0)
+	h.Add(DenyListName, "temporary", "", time.Hour)
+
+	if list, _, found := h.Lookup("internal-tool"); !found || list != AllowListName {
+		t.Fatalf("expected internal-tool in the allowlist, got %q %v", list, found)
+	}
+	if list, entry, _ := h.Lookup("both"); list != DenyListName || entry.Comment != "incident 42" {
+		t.Fatalf("the denylist should have priority, got %q %v", list, entry)
+	}
+	now = now.Add(2 * time.Hour)
+	if _, _, found := h.Lookup("temporary"); found {
+		t.Fatalf("temporary should expire after its TTL")
+	}
+	if removed, _ := h.Remove(AllowListName, "internal-tool"); !removed {
+		t.Fatalf("expected internal-tool to be removed")
+	}
+	if _, err := h.Add("unknown", "hash", "", 0); err == nil {
+		t.Fatalf("expected an error for an unknown list")
+	}
+
+	h.SetFeed("malware", DenyListName, []string{"feed-hash", "internal-tool"})
+	if list, _, found := h.Lookup("feed-hash"); !found || list != DenyListName {
+		t.Fatalf("expected the hash of the feed in the denylist, got %q %v", list, found)
+	}
+	h.SetFeed("malware", DenyListName, []string{"internal-tool"})
+	if _, _, found := h.Lookup("feed-hash"); found {
+		t.Fatalf("the hashes of a feed should be replaced when it's updated")
+	}
+}
+
+func TestHashListsSharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hash-lists.json")
+	first, _ := OpenHashLists(path)
+	second, _ := OpenHashLists(path)
+	first.Add(DenyListName, "bad-hash", "", 0)
+
+	// make sure the modification time differs from the one which the second instance saw
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+	if err := second.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if list, _, found := second.Lookup("bad-hash"); !found || list != DenyListName {
+		t.Fatalf("the second instance should load the hash which the first one added")
+	}
+}
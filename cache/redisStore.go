@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// the timeout of every command which is sent to Redis, the scans continue without the verdict if it's reached
+const redisTimeout = 2 * time.Second
+
+// the number of keys which are asked for by every SCAN call
+const redisScanCount = 1000
+
+// RedisStore keeps the verdicts in Redis so they are shared by all the instances which use it, every verdict
+// is a key which expires with the verdict, so Redis evicts the expired verdicts itself
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// OpenRedisStore connects to Redis, the keys of the verdicts are the cache keys prefixed by prefix
+func OpenRedisStore(addr, password string, db int, prefix string) (*RedisStore, error) {
+	client := redis.NewClient(&redis.Options{Addr: addr, Password: password, DB: db})
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &RedisStore{client: client, prefix: prefix}, nil
+}
+
+// Get returns the verdict of the key if Redis has it
+func (s *RedisStore) Get(key string, now time.Time) (Verdict, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	raw, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	if err != nil {
+		return Verdict{}, false
+	}
+	var verdict Verdict
+	if err = json.Unmarshal(raw, &verdict); err != nil || !now.Before(verdict.ExpiresAt) {
+		return Verdict{}, false
+	}
+	return verdict, true
+}
+
+// Put stores the verdict with the expiry of the verdict
+func (s *RedisStore) Put(key string, verdict Verdict, now time.Time) error {
+	ttl := verdict.ExpiresAt.Sub(now)
+	if ttl <= 0 {
+		return nil
+	}
+	raw, err := json.Marshal(&verdict)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return s.client.Set(ctx, s.prefix+key, raw, ttl).Err()
+}
+
+// scan returns the keys which match the pattern, the pattern is prefixed by prefix
+func (s *RedisStore) scan(pattern string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	var keys []string
+	iter := s.client.Scan(ctx, 0, s.prefix+pattern, redisScanCount).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys
+}
+
+// Find returns the unexpired entries of the file hash
+func (s *RedisStore) Find(fileHash string, now time.Time) []Entry {
+	var result []Entry
+	for _, key := range s.scan("*|" + fileHash) {
+		key = key[len(s.prefix):]
+		verdict, found := s.Get(key, now)
+		if !found {
+			continue
+		}
+		serviceName, signatureVersion, hash := splitKey(key)
+		result = append(result, Entry{
+			ServiceName:      serviceName,
+			SignatureVersion: signatureVersion,
+			FileHash:         hash,
+			Verdict:          verdict,
+		})
+	}
+	return result
+}
+
+// del removes the keys and returns the number of the removed ones
+func (s *RedisStore) del(keys []string) int {
+	if len(keys) == 0 {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	deleted, _ := s.client.Del(ctx, keys...).Result()
+	return int(deleted)
+}
+
+// Delete removes the verdicts of a file hash, if service name is empty the verdicts of all services are removed
+func (s *RedisStore) Delete(fileHash, serviceName string) int {
+	pattern := "*|" + fileHash
+	if serviceName != "" {
+		pattern = serviceName + "|" + pattern
+	}
+	return s.del(s.scan(pattern))
+}
+
+// Flush removes all the verdicts which have the prefix
+func (s *RedisStore) Flush() int {
+	return s.del(s.scan("*"))
+}
+
+// Size returns zero, Redis accounts for its memory itself
+func (s *RedisStore) Size() int64 {
+	return 0
+}
+
+// Close closes the connections to Redis
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// the names of the caches which can be flushed
+const (
+	VerdictCacheName = "verdict"
+	URLCacheName     = "url"
+)
+
+// Flusher is implemented by every cache which can be flushed through the admin API
+type Flusher interface {
+	Flush() int
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Flusher)
+)
+
+// Register adds a cache to the caches which can be flushed by name
+func Register(name string, flusher Flusher) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = flusher
+}
+
+// Names returns the names of the registered caches
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Flush removes all the entries of the cache with the given name and returns the number of removed entries
+func Flush(name string) (int, error) {
+	removed, err := ApplyFlush(name)
+	if err == nil {
+		if r := getReplicator(); r != nil {
+			r.Flushed(name)
+		}
+	}
+	return removed, err
+}
+
+// ApplyFlush removes all the entries of the cache with the given name without telling the other instances
+func ApplyFlush(name string) (int, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	flusher, exists := registry[name]
+	if !exists {
+		return 0, errors.New("cache " + name + " doesn't exist or it's disabled")
+	}
+	return flusher.Flush(), nil
+}
+
+// FlushAll removes all the entries of all registered caches
+func FlushAll() map[string]int {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	result := make(map[string]int)
+	r := getReplicator()
+	for name, flusher := range registry {
+		result[name] = flusher.Flush()
+		if r != nil {
+			r.Flushed(name)
+		}
+	}
+	return result
+}
+
+// Sizes returns the number of entries of every registered cache which can tell it
+func Sizes() map[string]int {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	result := make(map[string]int)
+	for name, flusher := range registry {
+		if sizer, ok := flusher.(interface{ Len() int }); ok {
+			result[name] = sizer.Len()
+		}
+	}
+	return result
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+)
+
+// Replicator is told about the changes which are made on this instance, ex: the cluster mode sends them
+// to the other gateway instances
+type Replicator interface {
+	VerdictStored(key string, verdict Verdict)
+	VerdictsDeleted(fileHash, serviceName string)
+	Flushed(name string)
+	HashListChanged(name string, entry HashListEntry, removed bool)
+	HashBlocked(fileHash string, blocked BlockedHash)
+}
+
+var (
+	replicatorMu sync.RWMutex
+	replicator   Replicator
+)
+
+// SetReplicator sets the replicator of the caches and the hash lists, nil stops the replication
+func SetReplicator(r Replicator) {
+	replicatorMu.Lock()
+	defer replicatorMu.Unlock()
+	replicator = r
+}
+
+func getReplicator() Replicator {
+	replicatorMu.RLock()
+	defer replicatorMu.RUnlock()
+	return replicator
+}
+
+// the Apply funcs make the changes which are received from other instances, they aren't replicated again
+
+// ApplyVerdict stores a verdict which another instance stored in its verdict cache
+func ApplyVerdict(key string, verdict Verdict) {
+	if verdictCache == nil {
+		return
+	}
+	verdictCache.Put(key, verdict)
+}
+
+// ApplyVerdictsDeleted removes the cached verdicts of a file hash which another instance removed
+func ApplyVerdictsDeleted(fileHash, serviceName string) int {
+	if verdictCache == nil {
+		return 0
+	}
+	return verdictCache.Delete(fileHash, serviceName)
+}
+
+// ApplyHashListChange adds or removes a hash list entry which another instance added or removed
+func ApplyHashListChange(name string, entry HashListEntry, removed bool) error {
+	if hashLists == nil {
+		return errors.New("the hash lists are disabled")
+	}
+	if removed {
+		_, err := hashLists.Delete(name, entry.Hash)
+		return err
+	}
+	return hashLists.Put(name, entry)
+}
+
+// ApplyBlockedHash adds a file hash which another instance blocked to the hash blocklist
+func ApplyBlockedHash(fileHash string, blocked BlockedHash) {
+	hashBlocklist.Put(fileHash, blocked)
+}
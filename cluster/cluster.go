@@ -0,0 +1,264 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"icapeg/cache"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"icapeg/service/services-utilities/hotswap"
+	"icapeg/service/services-utilities/toggles"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// the types of the changes which are sent to the other instances
+const (
+	TypeVerdict         = "verdict"
+	TypeVerdictsDeleted = "verdicts_deleted"
+	TypeFlush           = "flush"
+	TypeHashList        = "hash_list"
+	TypeHashBlocked     = "hash_blocked"
+	TypeServiceToggle   = "service_toggle"
+	TypeVendorSwap      = "vendor_swap"
+)
+
+// the number of changes which wait to be published, the changes are dropped when Redis can't keep up
+const publishQueueSize = 1024
+
+// Config represents [app.cluster] section configuration
+type Config struct {
+	RedisAddr  string
+	Password   string
+	Channel    string
+	InstanceID string
+}
+
+// Message is a change which an instance sends to the other instances of the cluster
+type Message struct {
+	Origin  string          `json:"origin"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type verdictPayload struct {
+	Key     string        `json:"key"`
+	Verdict cache.Verdict `json:"verdict"`
+}
+
+type verdictsDeletedPayload struct {
+	Hash    string `json:"hash"`
+	Service string `json:"service,omitempty"`
+}
+
+type flushPayload struct {
+	Cache string `json:"cache"`
+}
+
+type hashListPayload struct {
+	List    string              `json:"list"`
+	Entry   cache.HashListEntry `json:"entry"`
+	Removed bool                `json:"removed,omitempty"`
+}
+
+type hashBlockedPayload struct {
+	Hash    string            `json:"hash"`
+	Blocked cache.BlockedHash `json:"blocked"`
+}
+
+type serviceTogglePayload struct {
+	Service string `json:"service"`
+	Method  string `json:"method,omitempty"` // empty if the whole service was toggled
+	Enabled bool   `json:"enabled"`
+}
+
+type vendorSwapPayload struct {
+	Service string `json:"service"`
+	Vendor  string `json:"vendor,omitempty"`
+}
+
+// Node is this instance in the cluster, it publishes the local changes and applies the changes of the others
+type Node struct {
+	instanceID string
+	queue      chan []byte
+}
+
+// InitCluster reads the optional [app.cluster] section, the caches, the hash lists, the service toggles and the
+// vendor swaps aren't shared with the other instances if it doesn't exist
+func InitCluster() {
+	if !readValues.IsSecExists("app.cluster") || !readValues.ReadValuesBool("app.cluster.enabled") {
+		return
+	}
+	cfg := Config{
+		RedisAddr:  readValues.ReadValuesString("app.cluster.redis_addr"),
+		Password:   readValues.ReadValuesString("app.cluster.password"),
+		Channel:    readValues.ReadValuesString("app.cluster.channel"),
+		InstanceID: readValues.ReadValuesString("app.cluster.instance_id"),
+	}
+	if cfg.InstanceID == "" {
+		hostname, _ := os.Hostname()
+		cfg.InstanceID = hostname + "-" + strconv.Itoa(os.Getpid())
+	}
+	client := redis.NewClient(&redis.Options{Addr: cfg.RedisAddr, Password: cfg.Password})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		logging.Logger.Error("couldn't connect to the Redis of the cluster, the instance runs alone: " + err.Error())
+		client.Close()
+		return
+	}
+
+	node := NewNode(cfg.InstanceID)
+	go node.publish(client, cfg.Channel)
+	go node.subscribe(client, cfg.Channel)
+	cache.SetReplicator(node)
+	toggles.SetReplicator(node.ServiceToggled)
+	hotswap.SetReplicator(node.VendorSwapped)
+	logging.Logger.Info("cluster mode is on, " + cfg.InstanceID + " shares its changes on " + cfg.Channel +
+		" channel of " + cfg.RedisAddr)
+}
+
+// NewNode creates the node of the instance, the changes are queued until they are published
+func NewNode(instanceID string) *Node {
+	return &Node{instanceID: instanceID, queue: make(chan []byte, publishQueueSize)}
+}
+
+func (n *Node) publish(client *redis.Client, channel string) {
+	for data := range n.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := client.Publish(ctx, channel, data).Err(); err != nil {
+			logging.Logger.Error("couldn't publish a change to the cluster: " + err.Error())
+		}
+		cancel()
+	}
+}
+
+// subscribe applies the changes of the other instances, the Redis client subscribes again after a reconnect
+func (n *Node) subscribe(client *redis.Client, channel string) {
+	pubsub := client.Subscribe(context.Background(), channel)
+	for msg := range pubsub.Channel() {
+		if err := n.Apply([]byte(msg.Payload)); err != nil {
+			logging.Logger.Error("couldn't apply a change of the cluster: " + err.Error())
+		}
+	}
+}
+
+// send queues the change, it never blocks the ICAP transaction which made the change
+func (n *Node) send(kind string, payload interface{}) {
+	data, err := n.Encode(kind, payload)
+	if err != nil {
+		logging.Logger.Error("couldn't encode a change to the cluster: " + err.Error())
+		return
+	}
+	select {
+	case n.queue <- data:
+	default:
+		logging.Logger.Warn("the cluster publish queue is full, a " + kind + " change isn't shared")
+	}
+}
+
+// Encode returns the message of a change of this instance
+func (n *Node) Encode(kind string, payload interface{}) ([]byte, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Message{Origin: n.instanceID, Type: kind, Payload: raw})
+}
+
+// Apply makes the change of a message which another instance sent, the messages of this instance are ignored
+func (n *Node) Apply(data []byte) error {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+	if msg.Origin == n.instanceID {
+		return nil
+	}
+	switch msg.Type {
+	case TypeVerdict:
+		var p verdictPayload
+		if err := json.Unmarshal(msg.Payload, &p); err != nil {
+			return err
+		}
+		cache.ApplyVerdict(p.Key, p.Verdict)
+	case TypeVerdictsDeleted:
+		var p verdictsDeletedPayload
+		if err := json.Unmarshal(msg.Payload, &p); err != nil {
+			return err
+		}
+		cache.ApplyVerdictsDeleted(p.Hash, p.Service)
+	case TypeFlush:
+		var p flushPayload
+		if err := json.Unmarshal(msg.Payload, &p); err != nil {
+			return err
+		}
+		// the cache may be disabled on this instance
+		cache.ApplyFlush(p.Cache)
+	case TypeHashList:
+		var p hashListPayload
+		if err := json.Unmarshal(msg.Payload, &p); err != nil {
+			return err
+		}
+		return cache.ApplyHashListChange(p.List, p.Entry, p.Removed)
+	case TypeHashBlocked:
+		var p hashBlockedPayload
+		if err := json.Unmarshal(msg.Payload, &p); err != nil {
+			return err
+		}
+		cache.ApplyBlockedHash(p.Hash, p.Blocked)
+	case TypeServiceToggle:
+		var p serviceTogglePayload
+		if err := json.Unmarshal(msg.Payload, &p); err != nil {
+			return err
+		}
+		toggles.Apply(p.Service, p.Method, p.Enabled)
+	case TypeVendorSwap:
+		var p vendorSwapPayload
+		if err := json.Unmarshal(msg.Payload, &p); err != nil {
+			return err
+		}
+		hotswap.Apply(p.Service, p.Vendor)
+	default:
+		logging.Logger.Warn("unknown cluster change type " + msg.Type + " from " + msg.Origin)
+	}
+	return nil
+}
+
+// VerdictStored is called when a verdict is stored in the verdict cache of this instance
+func (n *Node) VerdictStored(key string, verdict cache.Verdict) {
+	n.send(TypeVerdict, verdictPayload{Key: key, Verdict: verdict})
+}
+
+// VerdictsDeleted is called when the cached verdicts of a file hash are removed on this instance
+func (n *Node) VerdictsDeleted(fileHash, serviceName string) {
+	n.send(TypeVerdictsDeleted, verdictsDeletedPayload{Hash: fileHash, Service: serviceName})
+}
+
+// Flushed is called when a cache is flushed on this instance
+func (n *Node) Flushed(name string) {
+	n.send(TypeFlush, flushPayload{Cache: name})
+}
+
+// HashListChanged is called when an entry is added to or removed from a hash list on this instance
+func (n *Node) HashListChanged(name string, entry cache.HashListEntry, removed bool) {
+	n.send(TypeHashList, hashListPayload{List: name, Entry: entry, Removed: removed})
+}
+
+// HashBlocked is called when a file hash is added to the hash blocklist of this instance
+func (n *Node) HashBlocked(fileHash string, blocked cache.BlockedHash) {
+	n.send(TypeHashBlocked, hashBlockedPayload{Hash: fileHash, Blocked: blocked})
+}
+
+// ServiceToggled is called when a service or one of its methods is enabled or disabled at runtime on this instance
+func (n *Node) ServiceToggled(service, method string, enabled bool) {
+	n.send(TypeServiceToggle, serviceTogglePayload{Service: service, Method: method, Enabled: enabled})
+}
+
+// VendorSwapped is called when the vendor of a service is swapped at runtime on this instance
+func (n *Node) VendorSwapped(service, vendor string) {
+	n.send(TypeVendorSwap, vendorSwapPayload{Service: service, Vendor: vendor})
+}
@@ -0,0 +1,45 @@
+package cluster
+
+import (
+	"icapeg/cache"
+	"icapeg/service/services-utilities/hotswap"
+	"icapeg/service/services-utilities/toggles"
+	"testing"
+	"time"
+)
+
+func TestApplyChangesOfOtherInstances(t *testing.T) {
+	local, remote := NewNode("local"), NewNode("remote")
+	blocked := cache.BlockedHash{ServiceName: "clamav", Threat: "Eicar", BlockedAt: time.Now()}
+	data, err := remote.Encode(TypeHashBlocked, hashBlockedPayload{Hash: "abc", Blocked: blocked})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = local.Apply(data); err != nil || !cache.IsHashBlocked("abc") {
+		t.Fatalf("the hash blocked by another instance should be blocked, err %v", err)
+	}
+
+	data, _ = remote.Encode(TypeServiceToggle, serviceTogglePayload{Service: "echo", Enabled: false})
+	if err = local.Apply(data); err != nil || !toggles.IsDisabled("echo") {
+		t.Fatalf("the service disabled by another instance should be disabled, err %v", err)
+	}
+
+	data, _ = remote.Encode(TypeServiceToggle, serviceTogglePayload{Service: "clamav", Method: "REQMOD", Enabled: false})
+	if err = local.Apply(data); err != nil || !toggles.IsMethodDisabled("clamav", "REQMOD") || toggles.IsDisabled("clamav") {
+		t.Fatalf("only the method disabled by another instance should be disabled, err %v", err)
+	}
+
+	data, _ = remote.Encode(TypeVendorSwap, vendorSwapPayload{Service: "echo", Vendor: "clamav"})
+	if err = local.Apply(data); err != nil || hotswap.Vendor("echo", "echo") != "clamav" {
+		t.Fatalf("the vendor swapped by another instance should back the service, err %v", err)
+	}
+
+	// the messages of the instance itself come back from the channel and are ignored
+	data, _ = local.Encode(TypeServiceToggle, serviceTogglePayload{Service: "echo", Enabled: true})
+	if err = local.Apply(data); err != nil || !toggles.IsDisabled("echo") {
+		t.Fatalf("the own messages should be ignored, err %v", err)
+	}
+	if err = local.Apply([]byte("{")); err == nil {
+		t.Fatalf("a malformed message should be an error")
+	}
+}
@@ -2,40 +2,296 @@ package config
 
 import (
 	"fmt"
+	utils "icapeg/consts"
+	"icapeg/icap"
 	"icapeg/logging"
 	"icapeg/readValues"
+	"icapeg/service/services-utilities/access"
+	"icapeg/service/services-utilities/policies"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/spf13/viper"
+	"go.uber.org/zap/zapcore"
 )
 
 type serviceIcapInfo struct {
-	Vendor         string
-	ServiceCaption string
-	ServiceTag     string
-	ReqMode        bool
-	RespMode       bool
-	ShadowService  bool
-	PreviewEnabled bool
-	PreviewBytes   string
+	Vendor           string
+	ServiceCaption   string
+	ServiceTag       string
+	ReqMode          bool
+	RespMode         bool
+	ShadowService    bool
+	MaxFileSize      int
+	ScanPartial      bool          // the first max_filesize bytes of the larger files are scanned
+	SizeLimitAction  string        // bypass or block the larger files before reading them, "" = the vendor decides
+	UseOriginalBody  bool          // the unchanged part of the body is answered with 206 and Use-Original-Body
+	ScanTimeout      time.Duration // the vendor must return its verdict in it, 0 = no timeout
+	FailPolicy       string        // open or closed when the vendor is unreachable, "" = the vendor decides
+	PreviewEnabled   bool
+	PreviewBytes     string
+	BypassExtensions []string
+	Routes           map[string]string
+	GeoRoutes        map[string]string
+	Trickling        *TricklingConfig
+	PatiencePage     *PatiencePageConfig
+	DeferredScan     *DeferredScanConfig
+	MaxWait          *MaxWaitConfig
+	ConnectFilter    *ConnectFilterConfig
+	ArchiveScan      *ArchiveScanConfig
+	MultipartScan    *MultipartScanConfig
+	ShadowCompare    *ShadowCompareConfig
+	MultiVendor      *MultiVendorConfig            // nil if the service has one vendor
+	FileTypes        *FileTypeRulesConfig          // nil if the service has neither MIME type rules nor require_type_match
+	Profiles         map[string]*ScanProfileConfig // by the names of the scan profiles of the service
+	DefaultProfile   string                        // the profile of the requests which select none, empty = the service keys
+	UserAgentRules   []*UserAgentRuleConfig        // in the order of their names, the first matching rule applies
+	Keys             *ServiceConfig                // the parsed keys of the service section
 }
 
+// TricklingConfig represents [<service>.trickling] section configuration
+type TricklingConfig struct {
+	MinSize          int
+	Delay            time.Duration
+	Interval         time.Duration
+	BytesPerInterval int
+}
+
+// PatiencePageConfig represents [<service>.patience_page] section configuration
+type PatiencePageConfig struct {
+	ContentTypes []string
+	MinSize      int
+	Delay        time.Duration
+	Refresh      time.Duration
+	TTL          time.Duration
+	Page         string
+	DownloadURL  string
+	MaxDownloads int   // the downloads of the service which are kept at once, pending or scanned
+	MaxBytes     int64 // the bytes of the downloads of the service which are kept at once
+}
+
+// DeferredScanConfig represents [<service>.deferred_scan] section configuration
+type DeferredScanConfig struct {
+	MinSize        int
+	BlocklistTTL   time.Duration
+	Workers        int    // the deferred scans which run at a time
+	QueueSize      int    // the deferred scans which wait for a worker, the files above it are scanned before delivery
+	QueueDir       string // the pending deferred scans are kept in it so they're scanned after a restart, "" = not kept
+	WebhookURL     string // the verdicts of the deferred scans are posted to it, "" = not posted
+	WebhookTimeout time.Duration
+}
+
+// MaxWaitConfig represents [<service>.max_wait] section configuration
+type MaxWaitConfig struct {
+	Timeout time.Duration
+	Action  string
+}
+
+// ConnectFilterConfig represents [<service>.connect_filter] section configuration
+type ConnectFilterConfig struct {
+	BlockedHosts []string
+	AllowedPorts []string
+	Service      string
+}
+
+// MultiVendorConfig represents the vendors keys of a service section and its [<service>.vendor_timeouts] section,
+// the verdicts of the vendors are aggregated with any_block, all_block or first_verdict
+type MultiVendorConfig struct {
+	Vendors     []string
+	FanOut      string
+	Aggregation string
+	Timeouts    map[string]time.Duration // by vendor, every vendor has one
+}
+
+// FileTypeRulesConfig represents the MIME type keys of a service section, the MIME types are the ones which are
+// detected from the magic bytes of the files
+type FileTypeRulesConfig struct {
+	ProcessMimeTypes []string
+	RejectMimeTypes  []string
+	BypassMimeTypes  []string
+	RequireTypeMatch bool // the extension and the MIME type rules must agree and the extension must match the magic bytes
+}
+
+// ArchiveScanConfig represents [<service>.archive_scan] section configuration, the policies are block, allow or
+// pass_through
+type ArchiveScanConfig struct {
+	MaxDepth        int
+	MaxMembers      int
+	MaxMemberSize   int
+	MaxTotalSize    int
+	MaxRatio        int
+	EncryptedPolicy string
+	BombPolicy      string
+}
+
+// MultipartScanConfig represents [<service>.multipart_scan] section configuration, the infected policies are
+// block or strip
+type MultipartScanConfig struct {
+	MaxParts       int
+	InfectedPolicy string
+}
+
+// ShadowCompareConfig represents [<service>.shadow_compare] section configuration, the shadow service scans the
+// HTTP messages of the service in the background and their verdicts are compared
+type ShadowCompareConfig struct {
+	Service    string
+	MaxSamples int
+}
+
+// ScanProfileConfig represents [<service>.profiles.<name>] section configuration, a profile which is selected
+// by the scan profile header of an ICAP request replaces the keys of the service which it has
+type ScanProfileConfig struct {
+	Name                   string
+	MaxFileSize            int
+	ScanPartial            bool
+	BypassExtensions       []string
+	ProcessExtensions      []string
+	RejectExtensions       []string
+	ReturnOrigIfMaxSizeExc bool
+	BypassOnApiError       bool
+}
+
+// UserAgentRuleConfig represents [<service>.user_agent_policies.<name>] section configuration, a rule whose
+// pattern matches the User-Agent of the encapsulated HTTP request decides how the HTTP message is scanned
+type UserAgentRuleConfig struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Action  string // scan, bypass, block or profile
+	Profile string // the scan profile of the profile action, and the forced one of the scan action
+}
+
+// ConnectionTimeoutsConfig represents [app.connection_timeouts] section configuration
+type ConnectionTimeoutsConfig struct {
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+	ReadHeader time.Duration
+}
+
+// OptionsBodyConfig represents [app.options_body] section configuration
+type OptionsBodyConfig struct {
+	PolicyVersion string
+}
+
+// BodyLimitConfig represents [app.body_limit] section configuration
+type BodyLimitConfig struct {
+	MaxSize    int64 // the bytes of the encapsulated HTTP body, the larger ones aren't read
+	StatusCode int   // the ICAP status code of the rejected requests
+}
+
+// TLSConfig represents the tls_* keys of [app] section configuration
+type TLSConfig struct {
+	Cert string
+	Key  string
+	// the CA bundle which the client certificates are verified against (mTLS), empty if they aren't verified
+	ClientCA string
+	// the clients without a certificate are rejected, else they're accepted and the given certificates verified
+	ClientCertRequired bool
+}
+
+// ListenerConfig represents [app.listeners.<name>] section configuration
+type ListenerConfig struct {
+	Name       string
+	Network    string      // tcp or unix
+	Address    string      // the TCP address, ex: ":1344", or the path of the unix socket
+	SocketMode os.FileMode // the permissions of the unix socket, 0 keeps the ones of the umask
+	TLS        *TLSConfig  // nil if the listener isn't over TLS (icaps)
+}
+
+// TenantConfig represents [app.tenants.<tenant>] section configuration
+type TenantConfig struct {
+	Services      map[string]string
+	MaxConcurrent int
+	QueueTimeout  time.Duration
+}
+
+// VirtualHostConfig represents [app.virtual_hosts.<name>] section configuration
+type VirtualHostConfig struct {
+	Name     string
+	Hosts    []string
+	Services map[string]string
+}
+
+// AccessControlConfig represents [app.access_control] section configuration
+type AccessControlConfig struct {
+	SecretHeader string           // the ICAP header which carries the shared secrets of the clients
+	Clients      []*access.Client // in the order of their names
+}
+
+// defaultSecretHeader is the ICAP header of the shared secrets without the secret_header key
+const defaultSecretHeader = "X-ICAP-Secret"
+
 // AppConfig represents the app configuration
 type AppConfig struct {
-	Port               int
-	LogLevel           string
-	WriteLogsToConsole bool
-	BypassExtensions   []string
-	ProcessExtensions  []string
-	PreviewBytes       string
-	PreviewEnabled     bool
-	DebuggingHeaders   bool
-	Services           []string
-	ServicesInstances  map[string]*serviceIcapInfo
+	Port                 int
+	LogLevel             string
+	WriteLogsToConsole   bool
+	BypassExtensions     []string
+	ProcessExtensions    []string
+	PreviewBytes         string
+	PreviewEnabled       bool
+	DebuggingHeaders     bool
+	ClientProfile        string
+	Services             []string
+	ServiceAliases       map[string]string
+	UnknownServiceAction string
+	VirtualHosts         map[string]*VirtualHostConfig // by the host names of the virtual hosts
+	OptionsBody          *OptionsBodyConfig            // nil if the OPTIONS responses have no opt-body
+	BodyLimit            *BodyLimitConfig              // nil if the bodies are only limited by the max_filesize of the services
+	TenantHeader         string
+	ScanProfileHeader    string // the ICAP header which selects the scan profile of a request
+	Tenants              map[string]*TenantConfig
+	Policies             []*policies.Rule     // in the order of their names, the first matching rule applies
+	AccessControl        *AccessControlConfig // nil if every ICAP client may use every service
+	ServicesInstances    map[string]*serviceIcapInfo
+	ConnectionTimeouts   ConnectionTimeoutsConfig
+	TLS                  *TLSConfig        // nil if the ICAP listener isn't over TLS (icaps)
+	Listeners            []*ListenerConfig // in the order of their names, the port of [app] without [app.listeners]
+	TLSReloadInterval    time.Duration     // how often the certificate files are checked for changes, 0 = on SIGHUP only
+	DrainTimeout         time.Duration     // the max wait of the graceful shutdown for the requests which are being processed
+	MaxConcurrency       int               // the max ICAP requests which are processed at once, 0 = no limit
+	WorkerTimeout        time.Duration     // the max wait of a request for a worker, it gets 503 after it
 }
 
+// defaultDrainTimeout is the drain timeout of the graceful shutdown without the [app.shutdown] section
+const defaultDrainTimeout = 30 * time.Second
+
+// defaultWorkerTimeout is the max wait for a worker without the worker_timeout key
+const defaultWorkerTimeout = 30 * time.Second
+
+// defaultVendorTimeout is the wait for the verdict of every vendor of a service which has the vendors key but no
+// vendor_timeout
+const defaultVendorTimeout = 60 * time.Second
+
+// the workers, the queue size and the webhook timeout of the deferred scans of a [<service>.deferred_scan] section
+// which doesn't set them
+const (
+	defaultDeferredWorkers   = 4
+	defaultDeferredQueueSize = 64
+	defaultWebhookTimeout    = 10 * time.Second
+)
+
+// AppCfg is the configuration which Init and Reload are reading, the requests read the published one of App
 var AppCfg AppConfig
 
+var (
+	// the configuration of the requests, it's replaced as a whole when the configuration is reloaded
+	published atomic.Value // *AppConfig
+	reloadMu  sync.Mutex
+	reloading bool
+	// the reader of the keys, the one of Reload records the keys which don't exist instead of exiting
+	values = &readValues.Reader{}
+)
+
+// invalidConfig is the panic of an invalid configuration while it's reloaded
+type invalidConfig string
+
 // Init initializes the configuration
 func Init() {
 	viper.SetConfigName("config")
@@ -44,18 +300,132 @@ func Init() {
 	viper.AddConfigPath("/usr/local/etc/icapeg/")
 	viper.AddConfigPath("$HOME/.config/icapeg")
 	viper.AddConfigPath(".")
-	if readValues.IsSecExists("app") {
+	// the ICAPEG_ environment variables and the secret references are resolved while the file is loaded
+	if _, err := readValues.Load(); err != nil {
+		fmt.Println("couldn't load the config file: " + err.Error())
+		os.Exit(1)
+	}
+	if !readValues.IsSecExists("app") {
 		fmt.Println("app section doesn't exist in config file")
 	}
 	AppCfg = AppConfig{
-		Port:               readValues.ReadValuesInt("app.port"),
-		LogLevel:           readValues.ReadValuesString("app.log_level"),
-		WriteLogsToConsole: readValues.ReadValuesBool("app.write_logs_to_console"),
-		DebuggingHeaders:   readValues.ReadValuesBool("app.debugging_headers"),
-		Services:           readValues.ReadValuesSlice("app.services"),
+		Port:               values.ReadValuesInt("app.port"),
+		LogLevel:           values.ReadValuesString("app.log_level"),
+		WriteLogsToConsole: values.ReadValuesBool("app.write_logs_to_console"),
+		DebuggingHeaders:   values.ReadValuesBool("app.debugging_headers"),
+		ClientProfile:      values.ReadValuesString("app.client_profile"),
+		Services:           values.ReadValuesSlice("app.services"),
 	}
 	logging.InitializeLogger(AppCfg.LogLevel, AppCfg.WriteLogsToConsole)
+	initLogOutputs()
+	initSplunkHEC()
 	logging.Logger.Info("Reading config.toml file")
+	//the headers whose values are replaced by their digests before they are logged
+	if readValues.IsSecExists("app.log_redaction") && values.ReadValuesBool("app.log_redaction.enabled") {
+		key := ""
+		if readValues.IsSecExists("app.log_redaction.key") {
+			key = values.ReadValuesString("app.log_redaction.key")
+		}
+		logging.InitRedaction(values.ReadValuesSlice("app.log_redaction.headers"), key)
+	}
+	//the privacy mode pseudonymizes the IP addresses and the usernames of the clients in the logs only,
+	//the policies still get them intact
+	if readValues.IsSecExists("app.privacy") && values.ReadValuesBool("app.privacy.enabled") {
+		mode := values.ReadValuesString("app.privacy.mode")
+		salt := values.ReadValuesString("app.privacy.salt")
+		if mode != logging.PrivacyModeHash && mode != logging.PrivacyModeTruncate {
+			invalid("privacy mode must be " + logging.PrivacyModeHash + " or " + logging.PrivacyModeTruncate)
+		}
+		if salt == "" {
+			invalid("privacy salt can't be empty")
+		}
+		logging.InitPrivacy(mode, salt)
+	}
+	//the caps on the headers of the ICAP requests, the requests which exceed them are rejected with 400
+	if readValues.IsSecExists("app.header_limits") {
+		icap.SetHeaderLimits(icap.HeaderLimits{
+			MaxICAPHeaderBytes: values.ReadValuesInt("app.header_limits.max_icap_header_bytes"),
+			MaxICAPHeaderCount: values.ReadValuesInt("app.header_limits.max_icap_header_count"),
+			MaxHTTPHeaderBytes: values.ReadValuesInt("app.header_limits.max_http_header_bytes"),
+			MaxHTTPHeaderCount: values.ReadValuesInt("app.header_limits.max_http_header_count"),
+		})
+	}
+	//the deadlines of the ICAP connections, so slow or dead clients can't hold the connections forever
+	if readValues.IsSecExists("app.connection_timeouts") {
+		AppCfg.ConnectionTimeouts = ConnectionTimeoutsConfig{
+			Read:       values.ReadValuesDuration("app.connection_timeouts.read_timeout") * time.Second,
+			Write:      values.ReadValuesDuration("app.connection_timeouts.write_timeout") * time.Second,
+			Idle:       values.ReadValuesDuration("app.connection_timeouts.idle_timeout") * time.Second,
+			ReadHeader: values.ReadValuesDuration("app.connection_timeouts.header_read_timeout") * time.Second,
+		}
+	}
+	//SIGTERM and SIGINT stop accepting ICAP connections and wait for the requests which are being processed
+	AppCfg.DrainTimeout = defaultDrainTimeout
+	if readValues.IsSecExists("app.shutdown") {
+		AppCfg.DrainTimeout = values.ReadValuesDuration("app.shutdown.drain_timeout") * time.Second
+	}
+	//the ICAP listener over TLS (icaps), the certificate is reloaded when its files change or on SIGHUP
+	AppCfg.TLS = readTLSConfig("app")
+	initListeners()
+	if readValues.IsSecExists("app.tls_reload_interval") {
+		AppCfg.TLSReloadInterval = values.ReadValuesDuration("app.tls_reload_interval") * time.Second
+	}
+	//validating the ICAP requests against RFC 3507, the violations are rejected instead of parsed leniently
+	if readValues.IsSecExists("app.strict_rfc3507") {
+		icap.SetStrictMode(values.ReadValuesBool("app.strict_rfc3507"))
+	}
+	//the bounded processing of the requests and the size of the reused buffers of the connections
+	if readValues.IsSecExists("app.max_concurrency") {
+		if AppCfg.MaxConcurrency = values.ReadValuesInt("app.max_concurrency"); AppCfg.MaxConcurrency < 0 {
+			invalid("max_concurrency can't be negative")
+		}
+	}
+	AppCfg.WorkerTimeout = defaultWorkerTimeout
+	if readValues.IsSecExists("app.worker_timeout") {
+		if AppCfg.WorkerTimeout = values.ReadValuesDuration("app.worker_timeout") * time.Second; AppCfg.WorkerTimeout <= 0 {
+			invalid("worker_timeout must be above 0")
+		}
+	}
+	if readValues.IsSecExists("app.buffer_size") {
+		icap.SetBufferSize(values.ReadValuesInt("app.buffer_size"))
+	}
+	loadServices()
+	initServiceLevels()
+	publish()
+}
+
+// InitTestConfig initializes the configuration from the file of the integration tests instead of the config
+// paths, the ICAPEG_ environment variables still override its keys
+func InitTestConfig(configFile string) {
+	viper.SetConfigFile(configFile)
+	Init()
+}
+
+// loadServices reads the sections of the app and of the services which are reloaded without restarting
+// ICAPeg, the ones of the listener and of the logs are read by Init only
+func loadServices() {
+	//a JSON document of the capabilities of the service in the opt-body of the OPTIONS responses
+	if readValues.IsSecExists("app.options_body") && values.ReadValuesBool("app.options_body.enabled") {
+		AppCfg.OptionsBody = &OptionsBodyConfig{
+			PolicyVersion: values.ReadValuesString("app.options_body.policy_version"),
+		}
+	}
+	//the absolute cap on the encapsulated HTTP bodies, the larger bodies are rejected without reading them
+	if readValues.IsSecExists("app.body_limit") && values.ReadValuesBool("app.body_limit.enabled") {
+		AppCfg.BodyLimit = &BodyLimitConfig{
+			MaxSize:    int64(values.ReadValuesInt("app.body_limit.max_size")),
+			StatusCode: utils.RequestEntityTooLargeStatusCodeStr,
+		}
+		if readValues.IsSecExists("app.body_limit.status_code") {
+			AppCfg.BodyLimit.StatusCode = values.ReadValuesInt("app.body_limit.status_code")
+		}
+		if AppCfg.BodyLimit.MaxSize <= 0 || AppCfg.BodyLimit.StatusCode < 400 || AppCfg.BodyLimit.StatusCode > 599 {
+			invalid("body_limit max_size must be positive and its status_code must be a 4xx or 5xx code")
+		}
+	}
+	if !isClientProfileValid(AppCfg.ClientProfile) {
+		invalid("client_profile value in config.toml file is not valid")
+	}
 
 	//this loop to make sure that all services in the array of services has sections in the config file and from request mode and response mode
 	//there is one at least from them are enabled in every service
@@ -64,19 +434,18 @@ func Init() {
 	for i := 0; i < len(AppCfg.Services); i++ {
 		serviceName := AppCfg.Services[i]
 		if !readValues.IsSecExists(serviceName) {
-			logging.Logger.Fatal(serviceName + " section doesn't exist")
-			fmt.Println(serviceName + " section doesn't exist")
-			os.Exit(1)
+			invalid(serviceName + " section doesn't exist")
+		}
+		keys := readServiceConfig(serviceName)
+		if !keys.Bool("req_mode") && !keys.Bool("resp_mode") {
+			invalid("Request mode and response mode are disabled together in " + serviceName + " service")
 		}
-		if !readValues.ReadValuesBool(serviceName+".req_mode") && !readValues.ReadValuesBool(serviceName+".resp_mode") {
-			logging.Logger.Fatal("Request mode and response mode are disabled together in " + serviceName + " service")
-			fmt.Println("Request mode and response mode are disabled together in " + serviceName + " service")
-			os.Exit(1)
+		if keys.Int("max_filesize") < 0 {
+			invalid("max_filesize value of " + serviceName + " service is not valid")
 		}
-		if readValues.ReadValuesInt(serviceName+".max_filesize") < 0 {
-			logging.Logger.Fatal("max_filesize value in config.toml file is not valid")
-			fmt.Println("max_filesize value in config.toml file is not valid")
-			os.Exit(1)
+		//the preview size is sent in the OPTIONS responses of the service, the ICAP clients send the previews upon it
+		if keys.Bool("preview_enabled") && keys.Int("preview_bytes") < 0 {
+			invalid("preview_bytes of " + serviceName + " service must be a number of bytes")
 		}
 		//checking if extensions arrays are valid in every service
 		//arrays are valid if there is only one array has asterisk and no two arrays has same file type
@@ -84,14 +453,11 @@ func Init() {
 		ext := make(map[string]bool)
 		asterisks := 0
 		//bypass
-		bypass := readValues.ReadValuesSlice(serviceName + ".bypass_extensions")
+		bypass := keys.Slice("bypass_extensions")
 		for i := 0; i < len(bypass); i++ {
 			if bypass[i] == "*" && len(bypass) != 1 {
-				logging.Logger.Fatal("bypass_extensions array has one asterisk \"*\"" +
-					" and other extensions but asterisk should be the only element in the array otherwise add extensions as you want")
-				fmt.Println("bypass_extensions array has one asterisk \"*\"" +
+				invalid("bypass_extensions array has one asterisk \"*\"" +
 					" and other extensions but asterisk should be the only element in the array otherwise add extensions as you want")
-				os.Exit(1)
 			}
 			if bypass[i] == "*" {
 				asterisks++
@@ -99,22 +465,16 @@ func Init() {
 			if ext[bypass[i]] == false {
 				ext[bypass[i]] = true
 			} else {
-				logging.Logger.Fatal("This extension \"" + bypass[i] + "\" was " +
+				invalid("This extension \"" + bypass[i] + "\" was " +
 					"stored in multiple arrays (bypass_extensions or reject_extensions)")
-				fmt.Println("This extension \"" + bypass[i] + "\" was " +
-					"stored in multiple arrays (bypass_extensions or reject_extensions)")
-				os.Exit(1)
 			}
 		}
 		//process
-		process := readValues.ReadValuesSlice(serviceName + ".process_extensions")
+		process := keys.Slice("process_extensions")
 		for i := 0; i < len(process); i++ {
 			if process[i] == "*" && len(process) != 1 {
-				logging.Logger.Fatal("process_extensions array has one asterisk \"*\" and other extensions " +
-					"but asterisk should be the only element in the array otherwise add extensions as you want")
-				fmt.Println("process_extensions array has one asterisk \"*\" and other extensions " +
+				invalid("process_extensions array has one asterisk \"*\" and other extensions " +
 					"but asterisk should be the only element in the array otherwise add extensions as you want")
-				os.Exit(1)
 			}
 			if process[i] == "*" {
 				asterisks++
@@ -122,20 +482,15 @@ func Init() {
 			if ext[process[i]] == false {
 				ext[process[i]] = true
 			} else {
-				logging.Logger.Fatal("This extension \"" + process[i] + "\" is stored in multiple arrays")
-				fmt.Println("This extension \"" + process[i] + "\" is stored in multiple arrays")
-				os.Exit(1)
+				invalid("This extension \"" + process[i] + "\" is stored in multiple arrays")
 			}
 		}
 		//reject
-		reject := readValues.ReadValuesSlice(serviceName + ".reject_extensions")
+		reject := keys.Slice("reject_extensions")
 		for i := 0; i < len(reject); i++ {
 			if reject[i] == "*" && len(reject) != 1 {
-				logging.Logger.Fatal("reject_extensions array has one asterisk \"*\" and other extensions but asterisk " +
+				invalid("reject_extensions array has one asterisk \"*\" and other extensions but asterisk " +
 					"should be the only element in the array otherwise add extensions as you want")
-				fmt.Println("reject_extensions array has one asterisk \"*\" and other extensions but asterisk " +
-					"should be the only element in the array otherwise add extensions as you want")
-				os.Exit(1)
 			}
 			if reject[i] == "*" {
 				asterisks++
@@ -143,31 +498,900 @@ func Init() {
 			if ext[reject[i]] == false {
 				ext[reject[i]] = true
 			} else {
-				logging.Logger.Fatal("This extension \"" + reject[i] + "\" is stored in multiple arrays")
-				fmt.Println("This extension \"" + reject[i] + "\" is stored in multiple arrays")
-				os.Exit(1)
+				invalid("This extension \"" + reject[i] + "\" is stored in multiple arrays")
 			}
 		}
 		if asterisks != 1 {
-			logging.Logger.Fatal("There is no \"*\" stored in any extension arrays")
-			fmt.Println("There is no \"*\" stored in any extension arrays")
-			os.Exit(1)
+			invalid("There is no \"*\" stored in any extension arrays")
+		}
+
+		//MIME type rules which classify the files by their magic bytes instead of their renamable extensions
+		var fileTypes *FileTypeRulesConfig
+		if keys.Has("process_mime_types") || keys.Has("reject_mime_types") || keys.Has("bypass_mime_types") ||
+			keys.Bool("require_type_match") {
+			fileTypes = &FileTypeRulesConfig{
+				ProcessMimeTypes: keys.Slice("process_mime_types"),
+				RejectMimeTypes:  keys.Slice("reject_mime_types"),
+				BypassMimeTypes:  keys.Slice("bypass_mime_types"),
+				RequireTypeMatch: keys.Bool("require_type_match"),
+			}
+			mimeTypes := make(map[string]bool)
+			for _, arr := range [][]string{fileTypes.ProcessMimeTypes, fileTypes.RejectMimeTypes, fileTypes.BypassMimeTypes} {
+				for _, mimeType := range arr {
+					if mimeType != "*" && !strings.Contains(mimeType, "/") {
+						invalid(serviceName + " service: \"" + mimeType + "\" isn't a MIME type, ex: application/pdf or image/*")
+					}
+					if mimeTypes[strings.ToLower(mimeType)] {
+						invalid(serviceName + " service: the MIME type \"" + mimeType + "\" is stored in multiple arrays")
+					}
+					mimeTypes[strings.ToLower(mimeType)] = true
+				}
+			}
 		}
 
+		sizeLimitAction := keys.String("size_limit_action")
 		AppCfg.ServicesInstances[serviceName] = &serviceIcapInfo{
-			Vendor:         readValues.ReadValuesString(serviceName + ".vendor"),
-			ServiceTag:     readValues.ReadValuesString(serviceName + ".service_tag"),
-			ServiceCaption: readValues.ReadValuesString(serviceName + ".service_caption"),
-			ReqMode:        readValues.ReadValuesBool(serviceName + ".req_mode"),
-			RespMode:       readValues.ReadValuesBool(serviceName + ".resp_mode"),
-			ShadowService:  readValues.ReadValuesBool(serviceName + ".shadow_service"),
-			PreviewBytes:   readValues.ReadValuesString(serviceName + ".preview_bytes"),
-			PreviewEnabled: readValues.ReadValuesBool(serviceName + ".preview_enabled"),
+			Vendor:           keys.String("vendor"),
+			ServiceTag:       keys.String("service_tag"),
+			ServiceCaption:   keys.String("service_caption"),
+			ReqMode:          keys.Bool("req_mode"),
+			RespMode:         keys.Bool("resp_mode"),
+			ShadowService:    keys.Bool("shadow_service"),
+			MaxFileSize:      keys.Int("max_filesize"),
+			ScanPartial:      keys.Bool("scan_partial_if_max_file_size_exceeded") || sizeLimitAction == utils.SizeLimitActionPartialScan,
+			SizeLimitAction:  sizeLimitAction,
+			UseOriginalBody:  keys.Bool("use_original_body"),
+			ScanTimeout:      keys.Duration("scan_timeout") * time.Second,
+			FailPolicy:       keys.String("fail_policy"),
+			PreviewBytes:     strconv.Itoa(keys.Int("preview_bytes")),
+			PreviewEnabled:   keys.Bool("preview_enabled"),
+			BypassExtensions: bypass,
+			FileTypes:        fileTypes,
+			Keys:             keys,
+		}
+	}
+
+	//routing tables which send the HTTP messages of a service to other services upon their file types
+	for serviceName, serviceInstance := range AppCfg.ServicesInstances {
+		if !readValues.IsSecExists(serviceName + ".routing") {
+			continue
+		}
+		serviceInstance.Routes = values.ReadValuesMap(serviceName + ".routing")
+		for fileType, target := range serviceInstance.Routes {
+			if _, exists := AppCfg.ServicesInstances[target]; !exists {
+				invalid(serviceName + " routes " + fileType + " files to " + target + " which isn't in the services array")
+			}
+		}
+	}
+
+	//GeoIP routing tables which send the HTTP messages of a service to other services upon the countries of their servers
+	for serviceName, serviceInstance := range AppCfg.ServicesInstances {
+		if !readValues.IsSecExists(serviceName + ".geo_routing") {
+			continue
+		}
+		serviceInstance.GeoRoutes = make(map[string]string)
+		for country, target := range values.ReadValuesMap(serviceName + ".geo_routing") {
+			if _, exists := AppCfg.ServicesInstances[target]; !exists {
+				invalid(serviceName + " routes the servers of " + country + " to " + target + " which isn't in the services array")
+			}
+			serviceInstance.GeoRoutes[strings.ToUpper(country)] = target
+		}
+	}
+
+	//trickling drips the original bytes of the large files to the ICAP client while the vendor is scanning them
+	for serviceName, serviceInstance := range AppCfg.ServicesInstances {
+		if !readValues.IsSecExists(serviceName+".trickling") || !values.ReadValuesBool(serviceName+".trickling.enabled") {
+			continue
+		}
+		serviceInstance.Trickling = &TricklingConfig{
+			MinSize:          values.ReadValuesInt(serviceName + ".trickling.min_size"),
+			Delay:            values.ReadValuesDuration(serviceName+".trickling.delay") * time.Second,
+			Interval:         values.ReadValuesDuration(serviceName+".trickling.interval") * time.Second,
+			BytesPerInterval: values.ReadValuesInt(serviceName + ".trickling.bytes_per_interval"),
+		}
+		if serviceInstance.Trickling.Interval <= 0 || serviceInstance.Trickling.BytesPerInterval <= 0 {
+			invalid(serviceName + " trickling interval and bytes_per_interval must be greater than zero")
+		}
+	}
+
+	//patience pages which are shown to the browsers while the vendor is scanning the large downloads
+	for serviceName, serviceInstance := range AppCfg.ServicesInstances {
+		if !readValues.IsSecExists(serviceName+".patience_page") || !values.ReadValuesBool(serviceName+".patience_page.enabled") {
+			continue
+		}
+		serviceInstance.PatiencePage = &PatiencePageConfig{
+			ContentTypes: values.ReadValuesSlice(serviceName + ".patience_page.content_types"),
+			MinSize:      values.ReadValuesInt(serviceName + ".patience_page.min_size"),
+			Delay:        values.ReadValuesDuration(serviceName+".patience_page.delay") * time.Second,
+			Refresh:      values.ReadValuesDuration(serviceName+".patience_page.refresh") * time.Second,
+			TTL:          values.ReadValuesDuration(serviceName+".patience_page.ttl") * time.Second,
+			Page:         values.ReadValuesString(serviceName + ".patience_page.page"),
+			DownloadURL:  strings.TrimSuffix(values.ReadValuesString(serviceName+".patience_page.download_url"), "/"),
+			MaxDownloads: utils.PatiencePageMaxDownloads,
+			MaxBytes:     utils.PatiencePageMaxBytes,
+		}
+		if readValues.IsSecExists(serviceName + ".patience_page.max_downloads") {
+			serviceInstance.PatiencePage.MaxDownloads = values.ReadValuesInt(serviceName + ".patience_page.max_downloads")
+		}
+		if readValues.IsSecExists(serviceName + ".patience_page.max_bytes") {
+			serviceInstance.PatiencePage.MaxBytes = int64(values.ReadValuesInt(serviceName + ".patience_page.max_bytes"))
+		}
+		if serviceInstance.PatiencePage.MaxDownloads <= 0 || serviceInstance.PatiencePage.MaxBytes <= 0 {
+			invalid(serviceName + " patience page max_downloads and max_bytes must be greater than zero")
+		}
+		if _, err := os.Stat(serviceInstance.PatiencePage.Page); err != nil {
+			invalid(serviceName + " patience page " + serviceInstance.PatiencePage.Page + " doesn't exist")
+		}
+	}
+
+	//deferred scanning delivers the large files before scanning them and blocks the later downloads of the malicious ones
+	for serviceName, serviceInstance := range AppCfg.ServicesInstances {
+		if !readValues.IsSecExists(serviceName+".deferred_scan") || !values.ReadValuesBool(serviceName+".deferred_scan.enabled") {
+			continue
+		}
+		section := serviceName + ".deferred_scan"
+		deferredScan := &DeferredScanConfig{
+			MinSize:        values.ReadValuesInt(section + ".min_size"),
+			BlocklistTTL:   values.ReadValuesDuration(section+".blocklist_ttl") * time.Second,
+			Workers:        defaultDeferredWorkers,
+			QueueSize:      defaultDeferredQueueSize,
+			WebhookTimeout: defaultWebhookTimeout,
+		}
+		if readValues.IsSecExists(section + ".workers") {
+			deferredScan.Workers = values.ReadValuesInt(section + ".workers")
+		}
+		if readValues.IsSecExists(section + ".queue_size") {
+			deferredScan.QueueSize = values.ReadValuesInt(section + ".queue_size")
+		}
+		if readValues.IsSecExists(section + ".queue_dir") {
+			deferredScan.QueueDir = values.ReadValuesString(section + ".queue_dir")
+		}
+		if readValues.IsSecExists(section + ".webhook_url") {
+			deferredScan.WebhookURL = values.ReadValuesString(section + ".webhook_url")
+		}
+		if readValues.IsSecExists(section + ".webhook_timeout") {
+			deferredScan.WebhookTimeout = values.ReadValuesDuration(section+".webhook_timeout") * time.Second
+		}
+		if deferredScan.Workers <= 0 || deferredScan.QueueSize < 0 {
+			invalid(serviceName + " deferred scan workers must be positive and its queue_size can't be negative")
+		}
+		if deferredScan.WebhookURL != "" {
+			if u, err := url.Parse(deferredScan.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				invalid(serviceName + " deferred scan webhook_url must be an http or https URL")
+			}
 		}
+		serviceInstance.DeferredScan = deferredScan
 	}
+
+	//max wait caps the latency which scanning adds to the HTTP messages
+	for serviceName, serviceInstance := range AppCfg.ServicesInstances {
+		if !readValues.IsSecExists(serviceName + ".max_wait") {
+			continue
+		}
+		serviceInstance.MaxWait = &MaxWaitConfig{
+			Timeout: values.ReadValuesDuration(serviceName+".max_wait.timeout") * time.Second,
+			Action:  values.ReadValuesString(serviceName + ".max_wait.action"),
+		}
+		if serviceInstance.MaxWait.Timeout <= 0 {
+			serviceInstance.MaxWait = nil
+			continue
+		}
+		if serviceInstance.MaxWait.Action != utils.MaxWaitActionBypass && serviceInstance.MaxWait.Action != utils.MaxWaitActionBlock {
+			invalid(serviceName + " max_wait action must be " + utils.MaxWaitActionBypass + " or " + utils.MaxWaitActionBlock)
+		}
+	}
+
+	//the services which run several vendors and aggregate their verdicts
+	for serviceName, serviceInstance := range AppCfg.ServicesInstances {
+		if serviceInstance.Vendor != utils.MultiVendor {
+			continue
+		}
+		keys := serviceInstance.Keys
+		multiVendor := &MultiVendorConfig{
+			Vendors:     keys.Slice("vendors"),
+			FanOut:      keys.String("fan_out"),
+			Aggregation: keys.String("aggregation"),
+			Timeouts:    make(map[string]time.Duration),
+		}
+		if multiVendor.FanOut == "" {
+			multiVendor.FanOut = utils.FanOutParallel
+		}
+		if multiVendor.Aggregation == "" {
+			multiVendor.Aggregation = utils.AggregationAnyBlock
+		}
+		timeout := keys.Duration("vendor_timeout") * time.Second
+		if timeout <= 0 {
+			timeout = defaultVendorTimeout
+		}
+		for _, vendor := range multiVendor.Vendors {
+			if _, exists := multiVendor.Timeouts[vendor]; exists {
+				invalid(serviceName + " service: the vendor " + vendor + " is in the vendors array more than once")
+			}
+			multiVendor.Timeouts[vendor] = timeout
+		}
+		if readValues.IsSecExists(serviceName + ".vendor_timeouts") {
+			for vendor := range readValues.ReadKeys(serviceName + ".vendor_timeouts") {
+				if _, exists := multiVendor.Timeouts[vendor]; !exists {
+					invalid(serviceName + " vendor_timeouts has " + vendor + " which isn't in the vendors array")
+				}
+				multiVendor.Timeouts[vendor] = values.ReadValuesDuration(serviceName+".vendor_timeouts."+vendor) * time.Second
+				if multiVendor.Timeouts[vendor] <= 0 {
+					invalid(serviceName + " vendor_timeouts of " + vendor + " must be a positive number of seconds")
+				}
+			}
+		}
+		serviceInstance.MultiVendor = multiVendor
+	}
+
+	//the services which scan the files whose hashes the hash lookup services don't know
+	for serviceName, serviceInstance := range AppCfg.ServicesInstances {
+		target := serviceInstance.Keys.String("fallback_service")
+		if target == "" {
+			continue
+		}
+		if _, exists := AppCfg.ServicesInstances[target]; !exists || target == serviceName {
+			invalid(serviceName + " falls back on " + target + " which isn't another service of the services array")
+		}
+	}
+
+	//CONNECT filters which check the destinations of the HTTPS tunnels in REQMOD instead of passing them through
+	for serviceName, serviceInstance := range AppCfg.ServicesInstances {
+		if !readValues.IsSecExists(serviceName+".connect_filter") || !values.ReadValuesBool(serviceName+".connect_filter.enabled") {
+			continue
+		}
+		serviceInstance.ConnectFilter = &ConnectFilterConfig{
+			BlockedHosts: values.ReadValuesSlice(serviceName + ".connect_filter.blocked_hosts"),
+			AllowedPorts: values.ReadValuesSlice(serviceName + ".connect_filter.allowed_ports"),
+			Service:      values.ReadValuesString(serviceName + ".connect_filter.service"),
+		}
+		if target := serviceInstance.ConnectFilter.Service; target != "" {
+			if _, exists := AppCfg.ServicesInstances[target]; !exists {
+				invalid(serviceName + " sends the CONNECT requests to " + target + " which isn't in the services array")
+			}
+		}
+	}
+
+	//archive scanning extracts the zip, tar, gzip and 7z files and scans each of their files with the vendor
+	for serviceName, serviceInstance := range AppCfg.ServicesInstances {
+		if !readValues.IsSecExists(serviceName+".archive_scan") || !values.ReadValuesBool(serviceName+".archive_scan.enabled") {
+			continue
+		}
+		serviceInstance.ArchiveScan = &ArchiveScanConfig{
+			MaxDepth:        values.ReadValuesInt(serviceName + ".archive_scan.max_depth"),
+			MaxMembers:      values.ReadValuesInt(serviceName + ".archive_scan.max_members"),
+			MaxMemberSize:   values.ReadValuesInt(serviceName + ".archive_scan.max_member_size"),
+			MaxTotalSize:    values.ReadValuesInt(serviceName + ".archive_scan.max_total_size"),
+			MaxRatio:        values.ReadValuesInt(serviceName + ".archive_scan.max_ratio"),
+			EncryptedPolicy: values.ReadValuesString(serviceName + ".archive_scan.encrypted_policy"),
+			BombPolicy:      values.ReadValuesString(serviceName + ".archive_scan.bomb_policy"),
+		}
+		for _, policy := range []string{serviceInstance.ArchiveScan.EncryptedPolicy, serviceInstance.ArchiveScan.BombPolicy} {
+			switch policy {
+			case utils.ArchivePolicyBlock, utils.ArchivePolicyAllow, utils.ArchivePolicyPassThrough:
+			default:
+				invalid(serviceName + " archive_scan encrypted_policy and bomb_policy must be " + utils.ArchivePolicyBlock +
+					", " + utils.ArchivePolicyAllow + " or " + utils.ArchivePolicyPassThrough)
+			}
+		}
+		if serviceInstance.ArchiveScan.MaxDepth < 1 {
+			invalid(serviceName + " archive_scan max_depth must be at least 1")
+		}
+	}
+
+	//multipart scanning scans each file of the multipart/form-data uploads in REQMOD with the vendor
+	for serviceName, serviceInstance := range AppCfg.ServicesInstances {
+		if !readValues.IsSecExists(serviceName+".multipart_scan") || !values.ReadValuesBool(serviceName+".multipart_scan.enabled") {
+			continue
+		}
+		serviceInstance.MultipartScan = &MultipartScanConfig{
+			MaxParts:       values.ReadValuesInt(serviceName + ".multipart_scan.max_parts"),
+			InfectedPolicy: values.ReadValuesString(serviceName + ".multipart_scan.infected_policy"),
+		}
+		switch serviceInstance.MultipartScan.InfectedPolicy {
+		case utils.MultipartPolicyBlock, utils.MultipartPolicyStrip:
+		default:
+			invalid(serviceName + " multipart_scan infected_policy must be " + utils.MultipartPolicyBlock + " or " +
+				utils.MultipartPolicyStrip)
+		}
+	}
+
+	//shadow comparisons which scan the HTTP messages of a service with another service to compare their verdicts
+	for serviceName, serviceInstance := range AppCfg.ServicesInstances {
+		if !readValues.IsSecExists(serviceName+".shadow_compare") || !values.ReadValuesBool(serviceName+".shadow_compare.enabled") {
+			continue
+		}
+		serviceInstance.ShadowCompare = &ShadowCompareConfig{
+			Service: values.ReadValuesString(serviceName + ".shadow_compare.service"),
+		}
+		if readValues.IsSecExists(serviceName + ".shadow_compare.max_samples") {
+			serviceInstance.ShadowCompare.MaxSamples = values.ReadValuesInt(serviceName + ".shadow_compare.max_samples")
+		}
+		target := serviceInstance.ShadowCompare.Service
+		if _, exists := AppCfg.ServicesInstances[target]; !exists || target == serviceName {
+			invalid(serviceName + " shadow_compare service must be another service of the services array, it's " + target)
+		}
+	}
+
+	//scan profiles which the ICAP clients select per request, ex: a stricter profile for the untrusted users
+	AppCfg.ScanProfileHeader = utils.ScanProfileHeader
+	if readValues.IsSecExists("app.scan_profile_header") {
+		AppCfg.ScanProfileHeader = values.ReadValuesString("app.scan_profile_header")
+	}
+	for serviceName, serviceInstance := range AppCfg.ServicesInstances {
+		initScanProfiles(serviceName, serviceInstance)
+	}
+
+	//User-Agent policies which force, relax or block the scans of the HTTP clients, ex: scanning every curl download
+	for serviceName, serviceInstance := range AppCfg.ServicesInstances {
+		initUserAgentPolicies(serviceName, serviceInstance)
+	}
+
+	//service aliases which map the ICAP URLs of other ICAP servers (ex: c-icap /srv_clamav) onto a configured service
+	AppCfg.ServiceAliases = make(map[string]string)
+	if readValues.IsSecExists("app.service_aliases") {
+		logging.Logger.Debug("checking that all service aliases point to configured services")
+		for alias, serviceName := range values.ReadValuesMap("app.service_aliases") {
+			if _, exists := AppCfg.ServicesInstances[serviceName]; !exists {
+				invalid("service alias " + alias + " points to " + serviceName + " which isn't in the services array")
+			}
+			if _, exists := AppCfg.ServicesInstances[alias]; exists {
+				invalid("service alias " + alias + " has the same name of a service")
+			}
+			AppCfg.ServiceAliases[alias] = serviceName
+		}
+	}
+
+	//the answer to the ICAP requests whose URL paths aren't services or aliases, some proxies fail the request of the user on 404
+	AppCfg.UnknownServiceAction = utils.UnknownServiceActionNotFound
+	if readValues.IsSecExists("app.unknown_service") {
+		AppCfg.UnknownServiceAction = values.ReadValuesString("app.unknown_service.action")
+		switch AppCfg.UnknownServiceAction {
+		case utils.UnknownServiceActionNotFound, utils.UnknownServiceActionBypass, utils.UnknownServiceActionBlock:
+		default:
+			invalid("unknown_service action must be " + utils.UnknownServiceActionNotFound + ", " +
+				utils.UnknownServiceActionBypass + " or " + utils.UnknownServiceActionBlock)
+		}
+	}
+
+	//virtual hosts which expose different service sets under different DNS names of the same gateway
+	AppCfg.VirtualHosts = make(map[string]*VirtualHostConfig)
+	if readValues.IsSecExists("app.virtual_hosts") {
+		logging.Logger.Debug("checking that the services of all virtual hosts are configured services")
+		for _, name := range readValues.ReadSubSections("app.virtual_hosts") {
+			hostSec := "app.virtual_hosts." + name
+			hostCfg := &VirtualHostConfig{
+				Name:     name,
+				Hosts:    values.ReadValuesSlice(hostSec + ".hosts"),
+				Services: values.ReadValuesMap(hostSec + ".services"),
+			}
+			for hostService, serviceName := range hostCfg.Services {
+				if _, exists := AppCfg.ServicesInstances[serviceName]; !exists {
+					invalid(name + " virtual host service " + hostService + " points to " + serviceName + " which isn't in the services array")
+				}
+			}
+			for _, host := range hostCfg.Hosts {
+				host = strings.ToLower(host)
+				if other, exists := AppCfg.VirtualHosts[host]; exists {
+					invalid("host " + host + " is in " + other.Name + " and " + name + " virtual hosts")
+				}
+				AppCfg.VirtualHosts[host] = hostCfg
+			}
+		}
+	}
+
+	//tenants which are resolved from the URL prefix (/tenant/service) or from the tenant header
+	AppCfg.Tenants = make(map[string]*TenantConfig)
+	if readValues.IsSecExists("app.tenants") {
+		logging.Logger.Debug("checking that the services of all tenants are configured services")
+		AppCfg.TenantHeader = values.ReadValuesString("app.tenants.header")
+		for _, tenant := range readValues.ReadSubSections("app.tenants") {
+			tenantSec := "app.tenants." + tenant
+			tenantCfg := &TenantConfig{
+				Services:      values.ReadValuesMap(tenantSec + ".services"),
+				MaxConcurrent: values.ReadValuesInt(tenantSec + ".max_concurrent"),
+				QueueTimeout:  values.ReadValuesDuration(tenantSec+".queue_timeout") * time.Second,
+			}
+			for tenantService, serviceName := range tenantCfg.Services {
+				if _, exists := AppCfg.ServicesInstances[serviceName]; !exists {
+					invalid(tenant + " tenant service " + tenantService + " points to " + serviceName + " which isn't in the services array")
+				}
+			}
+			AppCfg.Tenants[tenant] = tenantCfg
+		}
+	}
+
+	//policy rules which route, bypass, block or shadow the transactions upon their ICAP client and HTTP message
+	initPolicies()
+
+	//the ICAP clients which may use the services, by their addresses and/or their shared secrets
+	initAccessControl()
+}
+
+// readTLSConfig reads the tls_* keys of the section, it returns nil if its listener isn't over TLS
+func readTLSConfig(section string) *TLSConfig {
+	if !readValues.IsSecExists(section+".tls_enabled") || !values.ReadValuesBool(section+".tls_enabled") {
+		return nil
+	}
+	tlsCfg := &TLSConfig{
+		Cert: values.ReadValuesString(section + ".tls_cert"),
+		Key:  values.ReadValuesString(section + ".tls_key"),
+	}
+	if readValues.IsSecExists(section + ".tls_client_ca") {
+		tlsCfg.ClientCA = values.ReadValuesString(section + ".tls_client_ca")
+	}
+	if tlsCfg.ClientCA != "" {
+		tlsCfg.ClientCertRequired = true
+		if readValues.IsSecExists(section + ".tls_client_auth") {
+			switch values.ReadValuesString(section + ".tls_client_auth") {
+			case "require":
+			case "verify_if_given":
+				tlsCfg.ClientCertRequired = false
+			default:
+				invalid(section + " tls_client_auth must be require or verify_if_given")
+			}
+		}
+	}
+	return tlsCfg
+}
+
+// initListeners reads the ICAP listeners of the optional [app.listeners] section, ex: a unix socket for a
+// co-located Squid and a TCP port for the remote proxies. Without it ICAPeg listens on the port of [app] only,
+// over TLS if its tls_* keys enable it
+func initListeners() {
+	if !readValues.IsSecExists("app.listeners") {
+		AppCfg.Listeners = []*ListenerConfig{{Name: "default", Network: "tcp",
+			Address: fmt.Sprintf(":%d", AppCfg.Port), TLS: AppCfg.TLS}}
+		return
+	}
+	AppCfg.Listeners = nil
+	addresses := make(map[string]string)
+	for _, name := range readValues.ReadSubSections("app.listeners") {
+		listenerSec := "app.listeners." + name
+		listenerCfg := &ListenerConfig{
+			Name:    name,
+			Network: "tcp",
+			Address: values.ReadValuesString(listenerSec + ".address"),
+			TLS:     readTLSConfig(listenerSec),
+		}
+		if readValues.IsSecExists(listenerSec + ".network") {
+			listenerCfg.Network = values.ReadValuesString(listenerSec + ".network")
+		}
+		switch listenerCfg.Network {
+		case "tcp":
+		case "unix":
+			if readValues.IsSecExists(listenerSec + ".socket_mode") {
+				mode, err := strconv.ParseUint(values.ReadValuesString(listenerSec+".socket_mode"), 8, 32)
+				if err != nil || mode > 0777 {
+					invalid(name + " listener socket_mode must be octal permissions, ex: \"0660\"")
+				}
+				listenerCfg.SocketMode = os.FileMode(mode)
+			}
+		default:
+			invalid(name + " listener network must be tcp or unix")
+		}
+		if listenerCfg.Address == "" {
+			invalid(name + " listener address can't be empty")
+		}
+		if other, exists := addresses[listenerCfg.Address]; exists {
+			invalid("address " + listenerCfg.Address + " is in " + other + " and " + name + " listeners")
+		}
+		addresses[listenerCfg.Address] = name
+		AppCfg.Listeners = append(AppCfg.Listeners, listenerCfg)
+	}
+	if len(AppCfg.Listeners) == 0 {
+		invalid("listeners section has no listener")
+	}
+}
+
+// initPolicies reads the rules of the optional [app.policies] section, they're its sub sections and the sections
+// of its policy file
+func initPolicies() {
+	AppCfg.Policies = nil
+	if !readValues.IsSecExists("app.policies") || !values.ReadValuesBool("app.policies.enabled") {
+		return
+	}
+	sections := make(map[string]map[string]interface{})
+	for _, name := range readValues.ReadSubSections("app.policies") {
+		sections[name] = readValues.ReadKeys("app.policies." + name)
+	}
+	if readValues.IsSecExists("app.policies.file") {
+		file := values.ReadValuesString("app.policies.file")
+		fileSections, err := policies.ReadFile(file)
+		if err != nil {
+			invalid("couldn't read the policy file " + file + ": " + err.Error())
+		}
+		for name, keys := range fileSections {
+			if _, exists := sections[name]; exists {
+				invalid(name + " policy is in config.toml and in the policy file " + file)
+			}
+			sections[name] = keys
+		}
+	}
+	for name, keys := range sections {
+		rule, err := policies.Parse(name, keys)
+		if err != nil {
+			invalid(err.Error())
+		}
+		for _, serviceName := range append(append([]string{}, rule.Services...), rule.Service) {
+			if _, exists := AppCfg.ServicesInstances[serviceName]; serviceName != "" && !exists {
+				invalid(name + " policy points to " + serviceName + " which isn't in the services array")
+			}
+		}
+		AppCfg.Policies = append(AppCfg.Policies, rule)
+	}
+	policies.Sort(AppCfg.Policies)
+}
+
+// initAccessControl reads the clients of the optional [app.access_control] section, they're its sub sections.
+// Without it any ICAP client which reaches the port may use every service
+func initAccessControl() {
+	AppCfg.AccessControl = nil
+	if !readValues.IsSecExists("app.access_control") || !values.ReadValuesBool("app.access_control.enabled") {
+		return
+	}
+	AppCfg.AccessControl = &AccessControlConfig{SecretHeader: defaultSecretHeader}
+	if readValues.IsSecExists("app.access_control.secret_header") {
+		AppCfg.AccessControl.SecretHeader = values.ReadValuesString("app.access_control.secret_header")
+	}
+	for _, name := range readValues.ReadSubSections("app.access_control") {
+		clientSec := "app.access_control." + name
+		client := &access.Client{Name: name}
+		if readValues.IsSecExists(clientSec + ".ips") {
+			var err error
+			if client.Networks, err = access.ParseNetworks(values.ReadValuesSlice(clientSec + ".ips")); err != nil {
+				invalid(name + " access control client: " + err.Error())
+			}
+		}
+		if readValues.IsSecExists(clientSec + ".secret") {
+			client.Secret = values.ReadValuesString(clientSec + ".secret")
+		}
+		if len(client.Networks) == 0 && client.Secret == "" {
+			invalid(name + " access control client must have ips or a secret")
+		}
+		if readValues.IsSecExists(clientSec + ".services") {
+			client.Services = values.ReadValuesSlice(clientSec + ".services")
+		}
+		for _, serviceName := range client.Services {
+			if _, exists := AppCfg.ServicesInstances[serviceName]; !exists {
+				invalid(name + " access control client points to " + serviceName + " which isn't in the services array")
+			}
+		}
+		AppCfg.AccessControl.Clients = append(AppCfg.AccessControl.Clients, client)
+	}
+}
+
+// initScanProfiles reads the [<service>.profiles.<name>] sections of the service, the keys which a profile
+// doesn't have are read from the service section
+func initScanProfiles(serviceName string, serviceInstance *serviceIcapInfo) {
+	if !readValues.IsSecExists(serviceName + ".profiles") {
+		return
+	}
+	serviceInstance.Profiles = make(map[string]*ScanProfileConfig)
+	for _, name := range readValues.ReadSubSections(serviceName + ".profiles") {
+		profileSec := serviceName + ".profiles." + name
+		key := func(k string) string {
+			if readValues.IsSecExists(profileSec + "." + k) {
+				return profileSec + "." + k
+			}
+			return serviceName + "." + k
+		}
+		profile := &ScanProfileConfig{
+			Name:                   name,
+			MaxFileSize:            values.ReadValuesInt(key("max_filesize")),
+			BypassExtensions:       values.ReadValuesSlice(key("bypass_extensions")),
+			ProcessExtensions:      values.ReadValuesSlice(key("process_extensions")),
+			RejectExtensions:       values.ReadValuesSlice(key("reject_extensions")),
+			ReturnOrigIfMaxSizeExc: values.ReadValuesBool(key("return_original_if_max_file_size_exceeded")),
+		}
+		if readValues.IsSecExists(key("scan_partial_if_max_file_size_exceeded")) {
+			profile.ScanPartial = values.ReadValuesBool(key("scan_partial_if_max_file_size_exceeded"))
+		}
+		if readValues.IsSecExists(key("bypass_on_api_error")) {
+			profile.BypassOnApiError = values.ReadValuesBool(key("bypass_on_api_error"))
+		}
+		if profile.MaxFileSize < 0 {
+			invalid(name + " scan profile of " + serviceName + " has an invalid max_filesize")
+		}
+		if err := checkExtensionArrays(profile.BypassExtensions, profile.ProcessExtensions, profile.RejectExtensions); err != "" {
+			invalid(name + " scan profile of " + serviceName + ": " + err)
+		}
+		serviceInstance.Profiles[strings.ToLower(name)] = profile
+	}
+	if readValues.IsSecExists(serviceName + ".profiles.default") {
+		serviceInstance.DefaultProfile = strings.ToLower(values.ReadValuesString(serviceName + ".profiles.default"))
+		if _, exists := serviceInstance.Profiles[serviceInstance.DefaultProfile]; !exists && serviceInstance.DefaultProfile != "" {
+			invalid(serviceName + " default scan profile " + serviceInstance.DefaultProfile + " doesn't exist")
+		}
+	}
+}
+
+// initUserAgentPolicies reads the [<service>.user_agent_policies.<name>] sections of the service, the scan action
+// scans with a profile which processes every extension, it's added to the scan profiles of the service
+func initUserAgentPolicies(serviceName string, serviceInstance *serviceIcapInfo) {
+	if !readValues.IsSecExists(serviceName + ".user_agent_policies") {
+		return
+	}
+	for _, name := range readValues.ReadSubSections(serviceName + ".user_agent_policies") {
+		ruleSec := serviceName + ".user_agent_policies." + name
+		pattern, err := regexp.Compile(values.ReadValuesString(ruleSec + ".pattern"))
+		if err != nil {
+			invalid(name + " User-Agent policy of " + serviceName + " has an invalid pattern: " + err.Error())
+		}
+		rule := &UserAgentRuleConfig{
+			Name:    name,
+			Pattern: pattern,
+			Action:  strings.ToLower(values.ReadValuesString(ruleSec + ".action")),
+		}
+		switch rule.Action {
+		case utils.UserAgentActionBypass, utils.UserAgentActionBlock:
+		case utils.UserAgentActionScan:
+			rule.Profile = utils.UserAgentScanProfile
+			if serviceInstance.Profiles == nil {
+				serviceInstance.Profiles = make(map[string]*ScanProfileConfig)
+			}
+			serviceInstance.Profiles[rule.Profile] = forcedScanProfile(serviceName)
+		case utils.UserAgentActionProfile:
+			if readValues.IsSecExists(ruleSec + ".profile") {
+				rule.Profile = strings.ToLower(values.ReadValuesString(ruleSec + ".profile"))
+			}
+			if _, exists := serviceInstance.Profiles[rule.Profile]; !exists {
+				invalid(name + " User-Agent policy of " + serviceName + " selects the scan profile \"" +
+					rule.Profile + "\" which doesn't exist")
+			}
+		default:
+			invalid(name + " User-Agent policy of " + serviceName + " has an invalid action, it must be scan, bypass, block or profile")
+		}
+		serviceInstance.UserAgentRules = append(serviceInstance.UserAgentRules, rule)
+	}
+}
+
+// forcedScanProfile returns the scan profile of the scan action of the User-Agent policies, it has the keys of
+// the service section but it sends the files of every extension which isn't rejected to the vendor
+func forcedScanProfile(serviceName string) *ScanProfileConfig {
+	profile := &ScanProfileConfig{
+		Name:                   utils.UserAgentScanProfile,
+		MaxFileSize:            values.ReadValuesInt(serviceName + ".max_filesize"),
+		ProcessExtensions:      []string{utils.Any},
+		ReturnOrigIfMaxSizeExc: values.ReadValuesBool(serviceName + ".return_original_if_max_file_size_exceeded"),
+	}
+	for _, ext := range values.ReadValuesSlice(serviceName + ".reject_extensions") {
+		if ext != utils.Any {
+			profile.RejectExtensions = append(profile.RejectExtensions, ext)
+		}
+	}
+	if readValues.IsSecExists(serviceName + ".scan_partial_if_max_file_size_exceeded") {
+		profile.ScanPartial = values.ReadValuesBool(serviceName + ".scan_partial_if_max_file_size_exceeded")
+	}
+	if readValues.IsSecExists(serviceName + ".bypass_on_api_error") {
+		profile.BypassOnApiError = values.ReadValuesBool(serviceName + ".bypass_on_api_error")
+	}
+	return profile
+}
+
+// checkExtensionArrays checks that only one of the extension arrays has the asterisk, alone, and that no
+// extension is in two arrays, it returns the problem or an empty string
+func checkExtensionArrays(bypass, process, reject []string) string {
+	ext := make(map[string]bool)
+	asterisks := 0
+	for _, arr := range [][]string{bypass, process, reject} {
+		for _, e := range arr {
+			if e == utils.Any {
+				if len(arr) != 1 {
+					return "an extensions array has the asterisk \"*\" and other extensions"
+				}
+				asterisks++
+			}
+			if ext[e] {
+				return "the extension \"" + e + "\" is stored in multiple arrays"
+			}
+			ext[e] = true
+		}
+	}
+	if asterisks != 1 {
+		return "there must be one asterisk \"*\" in the extensions arrays"
+	}
+	return ""
+}
+
+// initServiceLevels reads the log levels of the services which log apart from the log level of the app
+func initServiceLevels() {
+	serviceLevels := make(map[string]zapcore.Level)
+	for serviceName, serviceInstance := range AppCfg.ServicesInstances {
+		if !serviceInstance.Keys.Has("log_level") {
+			continue
+		}
+		level, err := zapcore.ParseLevel(serviceInstance.Keys.String("log_level"))
+		if err != nil {
+			invalid(serviceName + " log_level value in config.toml file is not valid")
+		}
+		serviceLevels[serviceName] = level
+	}
+	logging.InitServiceLevels(AppCfg.LogLevel, serviceLevels)
+}
+
+// ScanProfile returns the scan profile which the transaction of the service selected, nil if it uses the keys
+// of the service section
+func ScanProfile(serviceName, xICAPMetadata string) *ScanProfileConfig {
+	serviceInstance, exists := App().ServicesInstances[serviceName]
+	if !exists || serviceInstance.Profiles == nil {
+		return nil
+	}
+	return serviceInstance.Profiles[utils.TransactionProfile(xICAPMetadata)]
 }
 
-// App returns the app configuration instance
+// isClientProfileValid checks that the client profile is one of the supported ICAP clients
+func isClientProfileValid(clientProfile string) bool {
+	switch clientProfile {
+	case utils.ClientProfileGeneric, utils.ClientProfileSquid, utils.ClientProfileProxySG:
+		return true
+	}
+	return false
+}
+
+// App returns the app configuration instance, a request keeps the instance which it got for its whole
+// processing so it isn't changed by a reload
 func App() *AppConfig {
+	if app, loaded := published.Load().(*AppConfig); loaded {
+		return app
+	}
 	return &AppCfg
 }
+
+// publish makes the configuration which was read the one of the next requests, the maps of the services
+// are made again by every read so the previous configuration isn't changed
+func publish() {
+	app := AppCfg
+	published.Store(&app)
+}
+
+// invalid reports an invalid configuration, ICAPeg exits if it's starting and the reload fails otherwise
+func invalid(msg string) {
+	if reloading {
+		panic(invalidConfig(msg))
+	}
+	fmt.Println(msg)
+	logging.Logger.Fatal(msg)
+	os.Exit(1)
+}
+
+// Reload reads config.toml again and swaps the configuration of the app and of its services, the requests
+// which are being processed keep the previous one. The port, the TLS listener, the connection timeouts, the
+// header limits, the strict mode and the logs need a restart. stage is called with the new configuration
+// before it's published, the configuration isn't swapped if it returns an error. An invalid configuration
+// is reported by the returned error and the previous one is kept
+func Reload(stage func(app *AppConfig) error) (err error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	restore, err := readValues.Load()
+	if err != nil {
+		return err
+	}
+	reloading = true
+	values = readValues.NewReader()
+	defer func() {
+		// a missing key is reported rather than what it caused, ex: a section which was read as empty
+		missing := values.Err()
+		reloading = false
+		values = &readValues.Reader{}
+		if r := recover(); r != nil {
+			if _, isInvalid := r.(invalidConfig); !isInvalid && missing == nil {
+				panic(r)
+			}
+			err = fmt.Errorf("%v", r)
+		}
+		if missing != nil {
+			err = missing
+		}
+		if err != nil {
+			restore()
+		}
+	}()
+
+	previous := App()
+	AppCfg = AppConfig{
+		Port:               previous.Port,
+		LogLevel:           previous.LogLevel,
+		WriteLogsToConsole: previous.WriteLogsToConsole,
+		DebuggingHeaders:   values.ReadValuesBool("app.debugging_headers"),
+		ClientProfile:      values.ReadValuesString("app.client_profile"),
+		Services:           values.ReadValuesSlice("app.services"),
+		ConnectionTimeouts: previous.ConnectionTimeouts,
+		TLS:                previous.TLS,
+		Listeners:          previous.Listeners,
+		TLSReloadInterval:  previous.TLSReloadInterval,
+		DrainTimeout:       previous.DrainTimeout,
+		MaxConcurrency:     previous.MaxConcurrency,
+		WorkerTimeout:      previous.WorkerTimeout,
+	}
+	loadServices()
+	if err = values.Err(); err != nil {
+		return err
+	}
+	if stage != nil {
+		// the vendors read the keys of the services from the configuration which is staged
+		staging.Store(&AppCfg)
+		defer staging.Store((*AppConfig)(nil))
+		if err = stage(&AppCfg); err != nil {
+			return err
+		}
+	}
+	publish()
+	logging.Logger.Info("config.toml was reloaded, the services are " + strings.Join(AppCfg.Services, ", "))
+	return nil
+}
+
+// initLogOutputs reads the optional [app.log_outputs] section, the debug log keeps write_logs_to_console behaviour
+// and the access and audit logs stay disabled if their subsections don't exist
+func initLogOutputs() {
+	if !readValues.IsSecExists("app.log_outputs") || !values.ReadValuesBool("app.log_outputs.enabled") {
+		return
+	}
+	outputs := make(map[string]*logging.Output)
+	for _, stream := range []string{"debug", "access", "audit"} {
+		name := "app.log_outputs." + stream
+		if !readValues.IsSecExists(name) {
+			continue
+		}
+		output := &logging.Output{
+			Destination: values.ReadValuesString(name + ".destination"),
+			Encoder:     values.ReadValuesString(name + ".encoder"),
+		}
+		switch output.Destination {
+		case logging.DestinationStdout, logging.DestinationFile, logging.DestinationBoth:
+		case logging.DestinationSyslog:
+			output.SyslogAddress = values.ReadValuesString(name + ".address")
+			if readValues.IsSecExists(name + ".facility") {
+				output.SyslogFacility = values.ReadValuesString(name + ".facility")
+			}
+		default:
+			invalid(stream + " log destination must be stdout, file, both or syslog")
+		}
+		switch output.Encoder {
+		case logging.EncoderJSON, logging.EncoderConsole:
+		case logging.EncoderCEF, logging.EncoderLEEF:
+			if readValues.IsSecExists(name + ".fields") {
+				output.Fields = values.ReadValuesMap(name + ".fields")
+			}
+		default:
+			invalid(stream + " log encoder must be json, console, cef or leef")
+		}
+		if output.Destination == logging.DestinationFile || output.Destination == logging.DestinationBoth {
+			output.Path = values.ReadValuesString(name + ".path")
+			if readValues.IsSecExists(name + ".max_size") {
+				output.MaxSize = int64(values.ReadValuesInt(name+".max_size")) * 1024 * 1024
+				if output.MaxSize <= 0 {
+					invalid(stream + " log max_size must be a positive number of megabytes")
+				}
+			}
+			if readValues.IsSecExists(name + ".max_backups") {
+				output.MaxBackups = values.ReadValuesInt(name + ".max_backups")
+			}
+		}
+		outputs[stream] = output
+	}
+	if err := logging.InitializeOutputs(outputs["debug"], outputs["access"], outputs["audit"]); err != nil {
+		invalid("couldn't open the log outputs: " + err.Error())
+	}
+}
+
+// initSplunkHEC reads the optional [app.splunk_hec] section, the access and audit logs are sent to the
+// HTTP Event Collector in addition to their outputs
+func initSplunkHEC() {
+	if !readValues.IsSecExists("app.splunk_hec") || !values.ReadValuesBool("app.splunk_hec.enabled") {
+		return
+	}
+	err := logging.SplunkHEC(logging.SplunkConfig{
+		URL:           values.ReadValuesString("app.splunk_hec.url"),
+		Token:         values.ReadValuesString("app.splunk_hec.token"),
+		Index:         values.ReadValuesString("app.splunk_hec.index"),
+		Host:          values.ReadValuesString("app.splunk_hec.host"),
+		Streams:       values.ReadValuesSlice("app.splunk_hec.streams"),
+		BatchSize:     values.ReadValuesInt("app.splunk_hec.batch_size"),
+		FlushInterval: values.ReadValuesDuration("app.splunk_hec.flush_interval") * time.Second,
+		BufferSize:    values.ReadValuesInt("app.splunk_hec.buffer_size"),
+		Timeout:       values.ReadValuesDuration("app.splunk_hec.timeout") * time.Second,
+	})
+	if err != nil {
+		invalid("the Splunk HEC configuration is not valid: " + err.Error())
+	}
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"icapeg/readValues"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testConfig has an app section and an echo service, the placeholders are the keys of the app section which
+// the tests change
+const testConfig = `
+[app]
+port = 1344
+log_level = "error"
+write_logs_to_console = false
+services = ["echo"]
+%s
+
+[echo]
+vendor = "echo"
+service_caption = "config test service"
+service_tag = "TEST ICAP"
+req_mode = true
+resp_mode = true
+shadow_service = false
+preview_bytes = "1024"
+preview_enabled = true
+max_filesize = 0
+process_extensions = ["*"]
+bypass_extensions = []
+reject_extensions = []
+return_original_if_max_file_size_exceeded = false
+scan_partial_if_max_file_size_exceeded = false
+return_400_if_file_ext_rejected = false
+`
+
+const validAppKeys = "debugging_headers = false\nclient_profile = \"generic\""
+
+var configFile string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "icapeg-config")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// the logs directory is created in the working directory
+	if err = os.Chdir(dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	configFile = filepath.Join(dir, "config.toml")
+	if err = os.WriteFile(configFile, []byte(fmt.Sprintf(testConfig, validAppKeys)), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// the echo vendor registers its keys in its package, which imports this one
+	RegisterVendorKeys("echo", map[string]KeySpec{
+		"return_original_if_max_file_size_exceeded": {Kind: BoolKey, Mandatory: true},
+		"return_400_if_file_ext_rejected":           {Kind: BoolKey, Mandatory: true},
+	})
+	InitTestConfig(configFile)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// writeConfig replaces the keys of the app section which the tests change, the configuration is restored when
+// the test ends
+func writeConfig(t *testing.T, appKeys string) {
+	t.Helper()
+	writeConfigFile(t, fmt.Sprintf(testConfig, appKeys))
+}
+
+// writeConfigFile replaces config.toml, the configuration is restored when the test ends
+func writeConfigFile(t *testing.T, content string) {
+	t.Helper()
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.WriteFile(configFile, []byte(fmt.Sprintf(testConfig, validAppKeys)), 0644)
+		if err := Reload(nil); err != nil {
+			t.Errorf("couldn't restore the configuration: %v", err)
+		}
+	})
+}
+
+func TestReload(t *testing.T) {
+	writeConfig(t, "debugging_headers = true\nclient_profile = \"squid\"")
+	var staged *AppConfig
+	err := Reload(func(app *AppConfig) error {
+		staged = app
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := App()
+	if !app.DebuggingHeaders || app.ClientProfile != "squid" || staged.ClientProfile != "squid" {
+		t.Fatalf("the reloaded configuration wasn't published: %+v", app)
+	}
+	if app.ServicesInstances["echo"] == nil {
+		t.Fatal("the echo service wasn't reloaded")
+	}
+}
+
+func TestReloadRollback(t *testing.T) {
+	tests := []struct {
+		name    string
+		appKeys string
+		stage   func(app *AppConfig) error
+		err     string
+	}{
+		{
+			name:    "missing key",
+			appKeys: "debugging_headers = true",
+			err:     "app.client_profile doesn't exist",
+		},
+		{
+			name:    "invalid value",
+			appKeys: "debugging_headers = true\nclient_profile = \"unknown\"",
+			err:     "client_profile value in config.toml file is not valid",
+		},
+		{
+			name:    "stage error",
+			appKeys: "debugging_headers = true\nclient_profile = \"squid\"",
+			stage: func(app *AppConfig) error {
+				return errors.New("a vendor rejected the configuration")
+			},
+			err: "a vendor rejected the configuration",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := App()
+			writeConfig(t, tt.appKeys)
+			err := Reload(tt.stage)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Reload returned %v, want %q", err, tt.err)
+			}
+			if App() != previous {
+				t.Fatal("the configuration of the failed reload was published")
+			}
+			// the keys are read from the previous file again
+			if debugging := readValues.ReadValuesBool("app.debugging_headers"); debugging {
+				t.Fatal("the snapshot of the failed reload wasn't restored")
+			}
+		})
+	}
+}
+
+func TestReadWhileReloading(t *testing.T) {
+	writeConfig(t, "debugging_headers = true")
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// the goroutines which read the configuration while it's reloaded have their own reader, the key
+		// which is missing from every snapshot is recorded by it and not by the reload
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			reader := readValues.NewReader()
+			reader.ReadValuesString("app.unknown_key")
+			if reader.Err() == nil {
+				t.Error("the reader didn't record the missing key")
+				return
+			}
+			if port := readValues.ReadValuesInt("app.port"); port != 1344 || App().Port != 1344 {
+				t.Errorf("the port is %d while the configuration is reloaded, want 1344", port)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		var missing *readValues.MissingKeyError
+		if err := Reload(nil); !errors.As(err, &missing) || missing.Key != "app.client_profile" {
+			t.Errorf("Reload returned %v, want the missing client_profile", err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,369 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	utils "icapeg/consts"
+	"icapeg/readValues"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// KeyKind is the type of the value of a key of a service section
+type KeyKind int
+
+const (
+	StringKey KeyKind = iota
+	BoolKey
+	IntKey
+	DurationKey // a number (its unit is up to the key, ex: seconds) or a Go duration like "1m30s"
+	SliceKey
+)
+
+// KeySpec describes a key of a service section
+type KeySpec struct {
+	Kind      KeyKind
+	Mandatory bool
+	Values    []string // the values which a string key may have, any value if it's empty
+}
+
+// ServiceConfig represents the keys of a [<service>] section, they're parsed and validated once when config.toml
+// is read so the vendors read typed values instead of looking the keys up at request time
+type ServiceConfig struct {
+	Name   string
+	values map[string]interface{}
+	hash   string // the digest of the section and its subsections as they're written in config.toml
+}
+
+// the keys which every service may have, the vendors register their own keys with RegisterVendorKeys
+var serviceKeys = map[string]KeySpec{
+	"vendor":             {Kind: StringKey, Mandatory: true},
+	"service_caption":    {Kind: StringKey, Mandatory: true},
+	"service_tag":        {Kind: StringKey},
+	"req_mode":           {Kind: BoolKey, Mandatory: true},
+	"resp_mode":          {Kind: BoolKey, Mandatory: true},
+	"shadow_service":     {Kind: BoolKey, Mandatory: true},
+	"preview_enabled":    {Kind: BoolKey, Mandatory: true},
+	"preview_bytes":      {Kind: IntKey, Mandatory: true},
+	"process_extensions": {Kind: SliceKey, Mandatory: true},
+	"reject_extensions":  {Kind: SliceKey, Mandatory: true},
+	"bypass_extensions":  {Kind: SliceKey, Mandatory: true},
+	"max_filesize":       {Kind: IntKey, Mandatory: true},
+	"return_original_if_max_file_size_exceeded": {Kind: BoolKey},
+	"scan_partial_if_max_file_size_exceeded":    {Kind: BoolKey},
+	"size_limit_action":                         {Kind: StringKey, Values: []string{utils.SizeLimitActionBypass, utils.SizeLimitActionBlock, utils.SizeLimitActionPartialScan}},
+	"return_400_if_file_ext_rejected":           {Kind: BoolKey},
+	"use_original_body":                         {Kind: BoolKey},
+	"log_level":                                 {Kind: StringKey},
+	"fail_threshold":                            {Kind: IntKey},
+	"process_mime_types":                        {Kind: SliceKey},
+	"reject_mime_types":                         {Kind: SliceKey},
+	"bypass_mime_types":                         {Kind: SliceKey},
+	"require_type_match":                        {Kind: BoolKey},
+	"fail_open":                                 {Kind: BoolKey},
+	"scan_timeout":                              {Kind: DurationKey},
+	"retry_count":                               {Kind: IntKey},
+	"retry_backoff":                             {Kind: DurationKey},
+	"fail_policy":                               {Kind: StringKey, Values: []string{utils.FailPolicyOpen, utils.FailPolicyClosed}},
+}
+
+// the keys of the services which list several vendors instead of one, the vendors read their own keys from the
+// same section
+var multiVendorKeys = map[string]KeySpec{
+	"vendors":        {Kind: SliceKey, Mandatory: true},
+	"fan_out":        {Kind: StringKey, Values: []string{utils.FanOutParallel, utils.FanOutSequential}},
+	"aggregation":    {Kind: StringKey, Values: []string{utils.AggregationAnyBlock, utils.AggregationAllBlock, utils.AggregationFirstVerdict}},
+	"vendor_timeout": {Kind: DurationKey},
+}
+
+// the subsections of a service section, they're read and validated by the features which they configure
+var serviceSubsections = map[string]bool{
+	"routing": true, "geo_routing": true, "trickling": true, "patience_page": true, "deferred_scan": true,
+	"max_wait": true, "connect_filter": true, "dns_policy": true, "profiles": true, "user_agent_policies": true,
+	"retry": true, "bulkhead": true, "block_page": true, "verdict_cache": true, "archive_scan": true,
+	"rate_limit": true, "vendor_timeouts": true, "quarantine": true, "multipart_scan": true,
+	"shadow_compare": true,
+}
+
+var (
+	vendorKeysMu sync.RWMutex
+	vendorKeys   = make(map[string]map[string]KeySpec)
+	// the configuration which Reload is reading, so the vendors read the keys of the new one before it's published
+	staging atomic.Value // *AppConfig
+)
+
+// RegisterVendorKeys adds the keys which the services of a vendor may have to the ones of every service, the
+// vendors register them in their init funcs. The services of a vendor which didn't register its keys (ex: the
+// vendor of a plugin which is loaded after config.toml was read) have their other keys unchecked
+func RegisterVendorKeys(vendor string, keys map[string]KeySpec) {
+	vendorKeysMu.Lock()
+	defer vendorKeysMu.Unlock()
+	vendorKeys[vendor] = keys
+}
+
+// Service returns the parsed keys of the service, nil if it isn't in the services array. The keys of the
+// configuration which is being reloaded are returned while it's being reloaded
+func Service(serviceName string) *ServiceConfig {
+	app := App()
+	if staged, _ := staging.Load().(*AppConfig); staged != nil {
+		app = staged
+	}
+	serviceInstance, exists := app.ServicesInstances[serviceName]
+	if !exists {
+		return nil
+	}
+	return serviceInstance.Keys
+}
+
+// readServiceConfig parses the keys of the service section upon their specs: a mandatory key which is missing,
+// a key which isn't known and a value which doesn't have the type of its key make the configuration invalid
+func readServiceConfig(serviceName string) *ServiceConfig {
+	raw := readValues.ReadKeys(serviceName)
+	vendors := []string{""}
+	if _, exists := raw["vendor"]; exists {
+		vendors[0] = values.ReadValuesString(serviceName + ".vendor")
+	}
+	_, multiVendor := raw["vendors"]
+	if multiVendor {
+		if vendors[0] != "" {
+			invalid(serviceName + " service: it has both vendor and vendors keys")
+		}
+		vendors = values.ReadValuesSlice(serviceName + ".vendors")
+		if len(vendors) == 0 {
+			invalid(serviceName + " service: the vendors array is empty")
+		}
+	}
+	specs := make(map[string]KeySpec, len(serviceKeys))
+	for key, spec := range serviceKeys {
+		specs[key] = spec
+	}
+	if multiVendor {
+		delete(specs, "vendor")
+		for key, spec := range multiVendorKeys {
+			specs[key] = spec
+		}
+	}
+	//the keys of every vendor are checked if all of them registered their keys
+	vendorKnown := true
+	vendorKeysMu.RLock()
+	for _, vendor := range vendors {
+		if vendor == utils.MultiVendor {
+			vendorKeysMu.RUnlock()
+			invalid(serviceName + " service: " + utils.MultiVendor + " can't be one of its vendors")
+		}
+		keys, known := vendorKeys[vendor]
+		vendorKnown = vendorKnown && known
+		for key, spec := range keys {
+			specs[key] = spec
+		}
+	}
+	vendorKeysMu.RUnlock()
+
+	names := make([]string, 0, len(specs))
+	for key := range specs {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	cfg := &ServiceConfig{Name: serviceName, values: make(map[string]interface{}), hash: sectionHash(serviceName)}
+	for _, key := range names {
+		spec := specs[key]
+		value, exists := raw[key]
+		if !exists {
+			if spec.Mandatory {
+				invalid(serviceName + " service: the mandatory key " + key + " is missing")
+			}
+			continue
+		}
+		if !validValue(spec.Kind, value) {
+			invalid(fmt.Sprintf("%s service: %s must be %s, it's %v", serviceName, key, kindName(spec.Kind), value))
+		}
+		varName := serviceName + "." + key
+		switch spec.Kind {
+		case StringKey:
+			cfg.values[key] = values.ReadValuesString(varName)
+			if len(spec.Values) != 0 && !oneOf(spec.Values, cfg.values[key].(string)) {
+				invalid(serviceName + " service: " + key + " must be " + strings.Join(spec.Values, ", ") + ", it's " +
+					cfg.values[key].(string))
+			}
+		case BoolKey:
+			cfg.values[key] = values.ReadValuesBool(varName)
+		case IntKey:
+			cfg.values[key] = values.ReadValuesInt(varName)
+		case DurationKey:
+			cfg.values[key] = values.ReadValuesDuration(varName)
+		case SliceKey:
+			cfg.values[key] = values.ReadValuesSlice(varName)
+		}
+	}
+
+	if multiVendor {
+		cfg.values["vendor"] = utils.MultiVendor
+	}
+	if !vendorKnown {
+		return cfg
+	}
+	var unknown []string
+	for key := range raw {
+		if _, known := specs[key]; !known {
+			unknown = append(unknown, key)
+		}
+	}
+	for _, subsection := range readValues.ReadSubSections(serviceName) {
+		if !serviceSubsections[subsection] {
+			unknown = append(unknown, subsection)
+		}
+	}
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		invalid(serviceName + " service: unknown key " + strings.Join(unknown, ", "))
+	}
+	return cfg
+}
+
+// sectionHash returns the SHA-256 of the keys of the section and of its subsections, the keys are sorted so the
+// hash changes only when a value changes
+func sectionHash(section string) string {
+	h := sha256.New()
+	var digest func(name string)
+	digest = func(name string) {
+		fmt.Fprintln(h, name, readValues.ReadKeys(name))
+		for _, subsection := range readValues.ReadSubSections(name) {
+			digest(name + "." + subsection)
+		}
+	}
+	digest(section)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// validValue reports whether the value of config.toml can be read as the kind, the values of the env vars
+// ("$_" values) are checked instead of their names
+func validValue(kind KeyKind, value interface{}) bool {
+	if s, isString := value.(string); isString && strings.HasPrefix(s, "$_") {
+		value = readValues.ReadStringFromEnv(s[2:])
+		if kind == SliceKey {
+			return true
+		}
+	}
+	switch kind {
+	case StringKey:
+		switch value.(type) {
+		case []interface{}, map[string]interface{}:
+			return false
+		}
+		return true
+	case BoolKey:
+		switch v := value.(type) {
+		case bool:
+			return true
+		case string:
+			_, err := strconv.ParseBool(v)
+			return err == nil
+		}
+	case IntKey:
+		switch v := value.(type) {
+		case int, int64:
+			return true
+		case string:
+			_, err := strconv.Atoi(strings.TrimSpace(v))
+			return err == nil
+		}
+	case DurationKey:
+		switch v := value.(type) {
+		case int, int64:
+			return true
+		case string:
+			if _, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+				return true
+			}
+			_, err := time.ParseDuration(v)
+			return err == nil
+		}
+	case SliceKey:
+		switch value.(type) {
+		case []interface{}, string:
+			return true
+		}
+	}
+	return false
+}
+
+func oneOf(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func kindName(kind KeyKind) string {
+	switch kind {
+	case BoolKey:
+		return "true or false"
+	case IntKey:
+		return "an integer"
+	case DurationKey:
+		return "a number or a duration"
+	case SliceKey:
+		return "an array"
+	}
+	return "a string"
+}
+
+// value returns the parsed value of the key, nil if the section doesn't have it
+func (s *ServiceConfig) value(key string) interface{} {
+	if s == nil {
+		return nil
+	}
+	return s.values[key]
+}
+
+// Hash returns the SHA-256 of the section and its subsections in config.toml, ex: the ISTag of the service changes
+// with it. It's empty if the service isn't in the services array
+func (s *ServiceConfig) Hash() string {
+	if s == nil {
+		return ""
+	}
+	return s.hash
+}
+
+// Has reports whether the section has the key
+func (s *ServiceConfig) Has(key string) bool {
+	return s.value(key) != nil
+}
+
+// String returns the value of a string key, empty if the section doesn't have it
+func (s *ServiceConfig) String(key string) string {
+	value, _ := s.value(key).(string)
+	return value
+}
+
+// Bool returns the value of a boolean key, false if the section doesn't have it
+func (s *ServiceConfig) Bool(key string) bool {
+	value, _ := s.value(key).(bool)
+	return value
+}
+
+// Int returns the value of an integer key, 0 if the section doesn't have it
+func (s *ServiceConfig) Int(key string) int {
+	value, _ := s.value(key).(int)
+	return value
+}
+
+// Duration returns the value of a duration key like readValues.ReadValuesDuration does, a number is a number
+// of nanoseconds which the vendor multiplies by the unit of the key. 0 if the section doesn't have it
+func (s *ServiceConfig) Duration(key string) time.Duration {
+	value, _ := s.value(key).(time.Duration)
+	return value
+}
+
+// Slice returns the value of an array key, nil if the section doesn't have it
+func (s *ServiceConfig) Slice(key string) []string {
+	value, _ := s.value(key).([]string)
+	return value
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReadServiceConfigValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string // the line of the echo section which the test replaces
+		new  string
+		err  string
+	}{
+		{
+			name: "unknown key",
+			old:  "shadow_service = false\n",
+			new:  "shadow_service = false\nscan_everything = true\n",
+			err:  "echo service: unknown key scan_everything",
+		},
+		{
+			name: "unknown subsection",
+			old:  "return_400_if_file_ext_rejected = false\n",
+			new:  "return_400_if_file_ext_rejected = false\n\n[echo.unknown]\nkey = 1\n",
+			err:  "echo service: unknown key unknown",
+		},
+		{
+			name: "missing mandatory key",
+			old:  "preview_enabled = true\n",
+			new:  "",
+			err:  "echo service: the mandatory key preview_enabled is missing",
+		},
+		{
+			name: "missing mandatory key of the vendor",
+			old:  "return_400_if_file_ext_rejected = false\n",
+			new:  "",
+			err:  "echo service: the mandatory key return_400_if_file_ext_rejected is missing",
+		},
+		{
+			name: "string instead of a boolean",
+			old:  "req_mode = true\n",
+			new:  "req_mode = \"yes\"\n",
+			err:  "echo service: req_mode must be true or false, it's yes",
+		},
+		{
+			name: "string instead of an integer",
+			old:  "max_filesize = 0\n",
+			new:  "max_filesize = \"many\"\n",
+			err:  "echo service: max_filesize must be an integer, it's many",
+		},
+		{
+			name: "array instead of a string",
+			old:  "service_caption = \"config test service\"\n",
+			new:  "service_caption = [\"config\", \"test\"]\n",
+			err:  "echo service: service_caption must be a string",
+		},
+		{
+			name: "invalid duration",
+			old:  "shadow_service = false\n",
+			new:  "shadow_service = false\nscan_timeout = \"soon\"\n",
+			err:  "echo service: scan_timeout must be a number or a duration, it's soon",
+		},
+		{
+			name: "value which isn't one of the values of the key",
+			old:  "shadow_service = false\n",
+			new:  "shadow_service = false\nfail_policy = \"maybe\"\n",
+			err:  "echo service: fail_policy must be open, closed, it's maybe",
+		},
+		{
+			name: "vendor and vendors",
+			old:  "shadow_service = false\n",
+			new:  "shadow_service = false\nvendors = [\"echo\"]\n",
+			err:  "echo service: it has both vendor and vendors keys",
+		},
+	}
+	valid := fmt.Sprintf(testConfig, validAppKeys)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(valid, tt.old) {
+				t.Fatalf("the test configuration doesn't have %q", tt.old)
+			}
+			previous := App()
+			writeConfigFile(t, strings.Replace(valid, tt.old, tt.new, 1))
+			err := Reload(nil)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Reload returned %v, want %q", err, tt.err)
+			}
+			if App() != previous {
+				t.Fatal("the invalid configuration was published")
+			}
+		})
+	}
+}
+
+func TestReadServiceConfigValues(t *testing.T) {
+	writeConfigFile(t, strings.Replace(fmt.Sprintf(testConfig, validAppKeys), "shadow_service = false\n",
+		"shadow_service = false\nscan_timeout = \"1m30s\"\nretry_count = \"3\"\nfail_policy = \"closed\"\n", 1))
+	if err := Reload(nil); err != nil {
+		t.Fatal(err)
+	}
+	keys := Service("echo")
+	if keys.String("vendor") != "echo" || !keys.Bool("req_mode") || keys.Int("preview_bytes") != 1024 {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+	if keys.Duration("scan_timeout").String() != "1m30s" || keys.Int("retry_count") != 3 ||
+		keys.String("fail_policy") != "closed" || len(keys.Slice("process_extensions")) != 1 {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+	if keys.Has("size_limit_action") || keys.Hash() == "" {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+}
@@ -13,31 +13,178 @@ const (
 	SampleSeverityMalicious = "malicious"
 )
 
+// the ICAP client profiles, a profile adapts ICAPeg to the peculiarities of an ICAP client
+const (
+	ClientProfileGeneric = "generic"
+	ClientProfileSquid   = "squid"
+	ClientProfileProxySG = "proxysg"
+)
+
+// ScanProfileHeader is the default ICAP header which selects the scan profile of a request, ex: the squid
+// directive adaptation_meta X-Scan-Profile "strict" untrusted_users
+const ScanProfileHeader = "X-Scan-Profile"
+
 // the common constants
 const (
-	Unknown                           = "unknown"
-	Any                               = "*"
-	NoModificationStatusCodeStr       = 204
-	BadRequestStatusCodeStr           = 400
-	OkStatusCodeStr                   = 200
-	InternalServerErrStatusCodeStr    = 500
-	Continue                          = 100
-	RequestTimeOutStatusCodeStr       = 408
-	MethodNotAllowedForServiceCodeStr = 405
-	ICAPServiceNotFoundCodeStr        = 404
-	HeaderEncapsulated                = "Encapsulated"
-	ICAPPrefix                        = "icap_"
-	NoVendor                          = "none"
-	ContentLength                     = "Content-Length"
-	ContentType                       = "Content-Type"
-	HTMLContentType                   = "text/html"
-	ProcessExts                       = "process"
-	RejectExts                        = "reject"
-	BypassExts                        = "bypass"
-	BlockPagePath                     = "block-page.html"
-	ErrPageReasonFileRejected         = "fileRejected"
-	ErrPageReasonMaxFileExceeded      = "maxFileSizeExceeded"
-	ErrPageReasonFileIsNotSafe        = "fileIsNotSafe"
-	ICAPRequestIdLen                  = 20
-	IdentifierString                  = "abcdefghijklmnopqrstuvwxyz0123456789"
+	Unknown                            = "unknown"
+	Any                                = "*"
+	NoModificationStatusCodeStr        = 204
+	BadRequestStatusCodeStr            = 400
+	OkStatusCodeStr                    = 200
+	PartialContentStatusCodeStr        = 206
+	InternalServerErrStatusCodeStr     = 500
+	Continue                           = 100
+	RequestTimeOutStatusCodeStr        = 408
+	RequestEntityTooLargeStatusCodeStr = 413
+	MethodNotAllowedForServiceCodeStr  = 405
+	ICAPServiceNotFoundCodeStr         = 404
+	ForbiddenStatusCodeStr             = 403
+	ServiceOverloadedCodeStr           = 503
+	HeaderEncapsulated                 = "Encapsulated"
+	ICAPPrefix                         = "icap_"
+	NoVendor                           = "none"
+	ContentLength                      = "Content-Length"
+	ContentType                        = "Content-Type"
+	HTMLContentType                    = "text/html"
+	ProcessExts                        = "process"
+	RejectExts                         = "reject"
+	BypassExts                         = "bypass"
+	BlockPagePath                      = "block-page.html"
+	ErrPageReasonFileRejected          = "fileRejected"
+	ErrPageReasonMaxFileExceeded       = "maxFileSizeExceeded"
+	ErrPageReasonFileIsNotSafe         = "fileIsNotSafe"
+	ErrPageReasonScanTimedOut          = "scanTimedOut"
+	ErrPageReasonDestinationBlocked    = "destinationBlocked"
+	ErrPageReasonUnknownService        = "unknownService"
+	ErrPageReasonQuotaExceeded         = "quotaExceeded"
+	ErrPageReasonClientBlocked         = "clientBlocked"
+	ErrPageReasonArchiveNotScanned     = "archiveNotScanned"
+	ErrPageReasonPolicyBlocked         = "policyBlocked"
+	ErrPageReasonSensitiveData         = "sensitiveDataFound"
+	ErrPageReasonURLBlocked            = "urlBlocked"
+	ICAPRequestIdLen                   = 20
+	TransactionIDHeader                = "X-ICAP-Transaction-ID"
+	IdentifierString                   = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// the keys of the vendor messages map which the services use to report their verdict
+const (
+	VendorMsgVerdict        = "verdict"
+	VendorMsgThreat         = "threat"
+	VendorMsgFileName       = "file_name"
+	VendorMsgFileHash       = "file_hash"
+	VendorMsgFileDigests    = "file_digests"
+	VendorMsgFileSize       = "file_size"
+	VendorMsgError          = "vendor_error"
+	VendorMsgMaxWait        = "max_wait_exceeded"
+	VendorMsgConnectBlocked = "connect_blocked"
+	VendorMsgDNSPolicy      = "dns_policy"
+	VendorMsgUserAgent      = "user_agent_policy"
+	VendorMsgArchiveMember  = "archive_member"
+	VendorMsgArchivePolicy  = "archive_policy"
+	VendorMsgFormFile       = "form_file"
+	VendorMsgStrippedFiles  = "stripped_files"
+	VendorMsgFailPolicy     = "fail_policy"
+	VendorMsgPolicy         = "policy"
+	VendorMsgVendors        = "vendors"
+	VendorMsgDLPMatches     = "dlp_matches"
+	VendorMsgDLPAction      = "dlp_action"
+	VendorMsgURLFilter      = "url_filter"
+)
+
+// the actions of a service when its max wait for the verdict is exceeded
+const (
+	MaxWaitActionBypass = "bypass"
+	MaxWaitActionBlock  = "block"
+)
+
+// the default limits of the downloads which a service keeps for its patience pages
+const (
+	PatiencePageMaxDownloads = 100
+	PatiencePageMaxBytes     = 10 * 1024 * 1024 * 1024
+)
+
+// the policies of a service for the encrypted archives and the ones which exceed its extraction limits
+const (
+	ArchivePolicyBlock       = "block"
+	ArchivePolicyAllow       = "allow"
+	ArchivePolicyPassThrough = "pass_through"
+)
+
+// the answers of a service whose vendor is unreachable or doesn't return its verdict in the scan timeout
+const (
+	FailPolicyOpen   = "open"
+	FailPolicyClosed = "closed"
+)
+
+// the policies of a service for the multipart/form-data uploads which have a malicious file
+const (
+	MultipartPolicyBlock = "block"
+	MultipartPolicyStrip = "strip"
+)
+
+// the actions of a DLP service on the HTTP requests which have sensitive data
+const (
+	DLPActionBlock  = "block"
+	DLPActionRedact = "redact"
+)
+
+// the actions of a service on the HTTP messages whose bodies exceed its max_filesize
+const (
+	SizeLimitActionBypass      = "bypass"
+	SizeLimitActionBlock       = "block"
+	SizeLimitActionPartialScan = "partial_scan"
+)
+
+// the vendor of the services which list several vendors, how it runs them and how it aggregates their verdicts
+const (
+	MultiVendor             = "multi_vendor"
+	FanOutParallel          = "parallel"
+	FanOutSequential        = "sequential"
+	AggregationAnyBlock     = "any_block"
+	AggregationAllBlock     = "all_block"
+	AggregationFirstVerdict = "first_verdict"
+)
+
+// the actions of the User-Agent policies of a service
+const (
+	UserAgentActionScan    = "scan"
+	UserAgentActionBypass  = "bypass"
+	UserAgentActionBlock   = "block"
+	UserAgentActionProfile = "profile"
+	// the scan profile of the scan action, it processes every extension
+	UserAgentScanProfile = "user-agent-scan"
+)
+
+// the answers to the ICAP requests whose URL paths aren't services or aliases of services
+const (
+	UnknownServiceActionNotFound = "not_found"
+	UnknownServiceActionBypass   = "bypass"
+	UnknownServiceActionBlock    = "block"
+)
+
+// the names of the events which are logged with PrepareEventLogMsg
+const (
+	EventMaxWaitExceeded  = "max_wait_exceeded"
+	EventConnectBlocked   = "connect_blocked"
+	EventDNSPolicy        = "dns_policy_blocked"
+	EventQuotaExceeded    = "quota_exceeded"
+	EventScanPartial      = "scan_partial"
+	EventOversizeReject   = "oversize_rejected"
+	EventUserAgentPolicy  = "user_agent_policy"
+	EventArchiveMember    = "archive_member_blocked"
+	EventArchivePolicy    = "archive_policy"
+	EventFormFileBlocked  = "form_file_blocked"
+	EventFormFileStripped = "form_file_stripped"
+	EventScanTimedOut     = "scan_timed_out"
+	EventFailPolicy       = "fail_policy"
+	EventShadowVerdict    = "shadow_verdict"
+	EventShadowCompare    = "shadow_comparison"
+	EventFileTypeMismatch = "file_type_mismatch"
+	EventRateLimited      = "rate_limited"
+	EventPolicyBlocked    = "policy_blocked"
+	EventDLPMatch         = "dlp_match"
+	EventSizeLimit        = "size_limit_exceeded"
+	EventURLBlocked       = "url_blocked"
+	EventAccessDenied     = "access_denied"
 )
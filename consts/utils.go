@@ -1,16 +1,101 @@
 package utils
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"strings"
+	"sync"
 )
 
+// NewTransactionID returns a new X-ICAP-Metadata, the identifier of an ICAP transaction (or of a scan job) in the
+// logs, the access log and the X-ICAP-Transaction-ID header. It's drawn from crypto/rand, so the identifiers of
+// the instances of a cluster and of the restarts of an instance don't repeat
+func NewTransactionID() string {
+	id := make([]byte, ICAPRequestIdLen)
+	if _, err := rand.Read(id); err != nil {
+		panic("couldn't read crypto/rand: " + err.Error())
+	}
+	for i, b := range id {
+		id[i] = IdentifierString[int(b)%len(IdentifierString)]
+	}
+	return string(id)
+}
+
+// transactionTenants, transactionServices and transactionProfiles map the X-ICAP-Metadata of the in-flight
+// transactions to their tenants, their services and their scan profiles
+var transactionTenants, transactionServices, transactionProfiles sync.Map
+
+// SetTransactionTenant tags the logs of the transaction with its tenant
+func SetTransactionTenant(xICAPMetadata, tenant string) {
+	transactionTenants.Store(xICAPMetadata, tenant)
+}
+
+// TransactionTenant returns the tenant of the transaction, it's empty if the transaction has no tenant
+func TransactionTenant(xICAPMetadata string) string {
+	if tenant, exists := transactionTenants.Load(xICAPMetadata); exists {
+		return tenant.(string)
+	}
+	return ""
+}
+
+// SetTransactionService tags the logs of the transaction with its service, so they follow the log level of the service
+func SetTransactionService(xICAPMetadata, serviceName string) {
+	transactionServices.Store(xICAPMetadata, serviceName)
+}
+
+// TransactionService returns the service of the transaction, it's empty if the service isn't resolved yet
+func TransactionService(xICAPMetadata string) string {
+	if serviceName, exists := transactionServices.Load(xICAPMetadata); exists {
+		return serviceName.(string)
+	}
+	return ""
+}
+
+// SetTransactionProfile makes the services of the transaction scan it with their scan profile
+func SetTransactionProfile(xICAPMetadata, profile string) {
+	transactionProfiles.Store(xICAPMetadata, profile)
+}
+
+// TransactionProfile returns the scan profile of the transaction, it's empty if the transaction selected none
+func TransactionProfile(xICAPMetadata string) string {
+	if profile, exists := transactionProfiles.Load(xICAPMetadata); exists {
+		return profile.(string)
+	}
+	return ""
+}
+
+// ForgetTransaction removes the tenant and the service of a finished transaction
+func ForgetTransaction(xICAPMetadata string) {
+	transactionTenants.Delete(xICAPMetadata)
+	transactionServices.Delete(xICAPMetadata)
+	transactionProfiles.Delete(xICAPMetadata)
+}
+
 func PrepareLogMsg(xICAPMetadata, msg string) string {
 	logPlaceolder := make(map[string]interface{})
 	logPlaceolder["X-ICAP-Metadata"] = xICAPMetadata
+	if tenant := TransactionTenant(xICAPMetadata); tenant != "" {
+		logPlaceolder["tenant"] = tenant
+	}
 	logPlaceolder["log"] = msg
 	jsonHeaders, _ := json.Marshal(logPlaceolder)
 	final := string(jsonHeaders)
 	final = strings.ReplaceAll(final, `\`, "")
 	return final
 }
+
+// PrepareEventLogMsg prepares the log message of an event, the events are logged with their name in
+// the "event" field so they can be audited apart from the other logs
+func PrepareEventLogMsg(xICAPMetadata, event string, fields map[string]interface{}) string {
+	logPlaceolder := make(map[string]interface{})
+	for key, value := range fields {
+		logPlaceolder[key] = value
+	}
+	logPlaceolder["X-ICAP-Metadata"] = xICAPMetadata
+	if tenant := TransactionTenant(xICAPMetadata); tenant != "" {
+		logPlaceolder["tenant"] = tenant
+	}
+	logPlaceolder["event"] = event
+	jsonHeaders, _ := json.Marshal(logPlaceolder)
+	return string(jsonHeaders)
+}
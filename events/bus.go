@@ -0,0 +1,211 @@
+package events
+
+import (
+	"fmt"
+	"icapeg/logging"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the types of the events of the event bus
+const (
+	TypeTransactionCompleted = "transaction_completed"
+	TypeDetection            = "detection"
+	TypeVendorStateChanged   = "vendor_state_changed"
+	TypeConfigReloaded       = "config_reloaded"
+	TypeBulkheadSaturated    = "bulkhead_saturated"
+	TypeBlocked              = "blocked"
+)
+
+// knownTypes are the types of the events of the event bus
+var knownTypes = map[string]bool{
+	TypeTransactionCompleted: true,
+	TypeDetection:            true,
+	TypeVendorStateChanged:   true,
+	TypeConfigReloaded:       true,
+	TypeBulkheadSaturated:    true,
+	TypeBlocked:              true,
+}
+
+// the default number of events which wait for a subscriber, the events are dropped when it can't keep up
+const defaultQueueSize = 1024
+
+// Event is an event of the event bus, it has the payload of its type only
+type Event struct {
+	Type        string                  `json:"type"`
+	Time        time.Time               `json:"time"`
+	Verdict     *VerdictEvent           `json:"verdict,omitempty"`      // transaction_completed and detection
+	VendorState *VendorStateEvent       `json:"vendor_state,omitempty"` // vendor_state_changed
+	Config      *ConfigReloadedEvent    `json:"config,omitempty"`       // config_reloaded
+	Bulkhead    *BulkheadSaturatedEvent `json:"bulkhead,omitempty"`     // bulkhead_saturated
+	Blocked     *BlockedEvent           `json:"blocked,omitempty"`      // blocked
+}
+
+// ConfigReloadedEvent represents the configurations which were loaded again without restarting ICAPeg
+type ConfigReloadedEvent struct {
+	Source   string   `json:"source"`   // what reloaded them, ex: sighup or admin_api
+	Sections []string `json:"sections"` // ex: certificates, vendor_credentials
+	Error    string   `json:"error,omitempty"`
+}
+
+// BulkheadSaturatedEvent represents a request which was rejected because the bulkhead of a service or a tenant is full
+type BulkheadSaturatedEvent struct {
+	XICAPMetadata string `json:"x_icap_metadata"`
+	Bulkhead      string `json:"bulkhead"`
+}
+
+// BlockedEvent represents an ICAP request which was blocked before its service scanned it, ex: by a policy rule,
+// the size limit or the access control. The blocks of the vendors are transaction_completed events
+type BlockedEvent struct {
+	XICAPMetadata string                 `json:"x_icap_metadata"`
+	ServiceName   string                 `json:"service"`
+	Method        string                 `json:"method,omitempty"`
+	Reason        string                 `json:"reason"` // the event of the log, ex: policy_blocked
+	ICAPClient    string                 `json:"icap_client,omitempty"`
+	ClientIP      string                 `json:"client_ip,omitempty"`
+	Username      string                 `json:"username,omitempty"`
+	RequestedURL  string                 `json:"url,omitempty"`
+	Details       map[string]interface{} `json:"details,omitempty"` // the fields of the log of the event
+}
+
+// Subscriber consumes the events of the bus, the sinks (ex: NATS, webhooks, chat channels, SIEMs) and the
+// alerters subscribe to the types of events which they need
+type Subscriber interface {
+	Handle(event *Event)
+}
+
+// SubscriberFunc is a func which is a Subscriber
+type SubscriberFunc func(event *Event)
+
+// Handle calls f
+func (f SubscriberFunc) Handle(event *Event) {
+	f(event)
+}
+
+// SubscriberStats represents the events which a subscriber got and the ones which were dropped
+type SubscriberStats struct {
+	Types     []string `json:"types"`
+	Queued    int      `json:"queued"`
+	Delivered uint64   `json:"delivered"`
+	Dropped   uint64   `json:"dropped"`
+}
+
+type subscription struct {
+	name       string
+	types      map[string]bool // empty = all types
+	subscriber Subscriber
+	queue      chan *Event // nil = the events are handled by the goroutine which emits them
+	delivered  uint64
+	dropped    uint64
+}
+
+var (
+	subscriptionsMu sync.RWMutex
+	subscriptions   []*subscription
+)
+
+// Subscribe adds the subscriber of the types of events, all types if types is empty. A subscriber whose queue
+// size is positive handles its events in its own goroutine, so a slow sink doesn't delay the transactions or
+// the other sinks, and the events are dropped when its queue is full. A queue size of zero handles the events
+// in the goroutine which emits them, for the subscribers which queue the events themselves
+func Subscribe(name string, types []string, subscriber Subscriber, queueSize int) {
+	s := &subscription{name: name, types: make(map[string]bool), subscriber: subscriber}
+	for _, eventType := range types {
+		s.types[eventType] = true
+	}
+	if queueSize > 0 {
+		s.queue = make(chan *Event, queueSize)
+		go func() {
+			for event := range s.queue {
+				s.subscriber.Handle(event)
+				atomic.AddUint64(&s.delivered, 1)
+			}
+		}()
+	}
+	subscriptionsMu.Lock()
+	defer subscriptionsMu.Unlock()
+	subscriptions = append(subscriptions, s)
+}
+
+// Subscribed reports whether any subscriber gets the events of the type, so the events aren't built for nothing
+func Subscribed(eventType string) bool {
+	subscriptionsMu.RLock()
+	defer subscriptionsMu.RUnlock()
+	for _, s := range subscriptions {
+		if len(s.types) == 0 || s.types[eventType] {
+			return true
+		}
+	}
+	return false
+}
+
+// Emit sends the event to the subscribers of its type
+func Emit(event *Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	subscriptionsMu.RLock()
+	defer subscriptionsMu.RUnlock()
+	for _, s := range subscriptions {
+		if len(s.types) != 0 && !s.types[event.Type] {
+			continue
+		}
+		if s.queue == nil {
+			s.subscriber.Handle(event)
+			atomic.AddUint64(&s.delivered, 1)
+			continue
+		}
+		select {
+		case s.queue <- event:
+		default:
+			if atomic.AddUint64(&s.dropped, 1) == 1 {
+				logging.Logger.Warn("the event subscriber " + s.name + " can't keep up, its events are dropped")
+			}
+		}
+	}
+}
+
+// Stats returns the stats of every subscriber by its name
+func Stats() map[string]SubscriberStats {
+	subscriptionsMu.RLock()
+	defer subscriptionsMu.RUnlock()
+	result := make(map[string]SubscriberStats, len(subscriptions))
+	for _, s := range subscriptions {
+		stats := SubscriberStats{
+			Types:     []string{},
+			Queued:    len(s.queue),
+			Delivered: atomic.LoadUint64(&s.delivered),
+			Dropped:   atomic.LoadUint64(&s.dropped),
+		}
+		for eventType := range s.types {
+			stats.Types = append(stats.Types, eventType)
+		}
+		sort.Strings(stats.Types)
+		result[s.name] = stats
+	}
+	return result
+}
+
+// ConfigReloaded emits the config_reloaded event of the sections, err is the error of the reload if it failed
+func ConfigReloaded(source string, sections []string, err error) {
+	event := &ConfigReloadedEvent{Source: source, Sections: sections}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	Emit(&Event{Type: TypeConfigReloaded, Config: event})
+}
+
+// Blocked emits the blocked event of an ICAP request
+func Blocked(event *BlockedEvent) {
+	Emit(&Event{Type: TypeBlocked, Blocked: event})
+}
+
+// sinkName returns the name of the subscription of a verdict sink, ex: nats for NATSSink
+func sinkName(sink Sink) string {
+	name := fmt.Sprintf("%T", sink)
+	name = name[strings.LastIndex(name, ".")+1:]
+	return strings.ToLower(strings.TrimSuffix(name, "Sink"))
+}
@@ -0,0 +1,375 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the longest wait between the retries of a bulk request while Elasticsearch is down
+const maxBulkRetryDelay = time.Minute
+
+// ElasticsearchConfig represents [app.elasticsearch] section configuration
+type ElasticsearchConfig struct {
+	URLs            []string
+	Username        string
+	Password        string
+	APIKey          string
+	Index           string
+	IndexDateFormat string // a Go time layout which is appended to the index, "" = the index is an alias or a data stream
+	BatchSize       int
+	FlushInterval   time.Duration
+	BufferSize      int
+	Timeout         time.Duration
+}
+
+// ElasticsearchSink indexes the verdict events as ECS documents with the bulk API, the documents are buffered
+// and sent again while Elasticsearch is unreachable or overloaded, the new ones are dropped when the buffer
+// is full
+type ElasticsearchSink struct {
+	cfg    ElasticsearchConfig
+	client *http.Client
+	wake   chan struct{}
+
+	mu      sync.Mutex
+	pending []bulkDocument
+	node    int // the node which gets the next bulk request, the next node is used after a failure
+}
+
+type bulkDocument struct {
+	index  string
+	source []byte
+}
+
+// ecsDocument is a verdict event in the fields of Elastic Common Schema, the fields which ECS doesn't have
+// are in icapeg
+type ecsDocument struct {
+	Timestamp time.Time `json:"@timestamp"`
+	Event     struct {
+		Kind     string   `json:"kind"`
+		Category []string `json:"category"`
+		Type     []string `json:"type"`
+		Action   string   `json:"action"`
+		Outcome  string   `json:"outcome"`
+		Dataset  string   `json:"dataset"`
+		ID       string   `json:"id"`
+	} `json:"event"`
+	Observer struct {
+		Product string `json:"product"`
+		Type    string `json:"type"`
+	} `json:"observer"`
+	Service struct {
+		Name string `json:"name"`
+	} `json:"service"`
+	Source *ecsSource `json:"source,omitempty"`
+	User   *ecsUser   `json:"user,omitempty"`
+	URL    *ecsURL    `json:"url,omitempty"`
+	File   *ecsFile   `json:"file,omitempty"`
+	Threat *ecsThreat `json:"threat,omitempty"`
+	ICAPeg struct {
+		Verdict   string `json:"verdict"`
+		Vendor    string `json:"vendor"`
+		Method    string `json:"method"`
+		Tenant    string `json:"tenant,omitempty"`
+		Delivered bool   `json:"delivered,omitempty"`
+		JobID     string `json:"job_id,omitempty"`
+	} `json:"icapeg"`
+}
+
+type ecsSource struct {
+	IP string `json:"ip"`
+}
+
+type ecsUser struct {
+	Name string `json:"name"`
+}
+
+type ecsURL struct {
+	Full string `json:"full"`
+}
+
+type ecsFile struct {
+	Name string   `json:"name,omitempty"`
+	Size int64    `json:"size,omitempty"`
+	Hash *ecsHash `json:"hash,omitempty"`
+}
+
+type ecsHash struct {
+	SHA256 string `json:"sha256"`
+}
+
+type ecsThreat struct {
+	Software struct {
+		Name string `json:"name"`
+	} `json:"software"`
+}
+
+// initElasticsearchSink reads [app.elasticsearch] section and returns nil if it doesn't exist or it's disabled
+func initElasticsearchSink() *ElasticsearchSink {
+	if !readValues.IsSecExists("app.elasticsearch") || !readValues.ReadValuesBool("app.elasticsearch.enabled") {
+		return nil
+	}
+	logging.Logger.Debug("loading Elasticsearch configuration")
+	cfg := ElasticsearchConfig{
+		URLs:            readValues.ReadValuesSlice("app.elasticsearch.urls"),
+		Username:        readValues.ReadValuesString("app.elasticsearch.username"),
+		Password:        readValues.ReadValuesString("app.elasticsearch.password"),
+		APIKey:          readValues.ReadValuesString("app.elasticsearch.api_key"),
+		Index:           readValues.ReadValuesString("app.elasticsearch.index"),
+		IndexDateFormat: readValues.ReadValuesString("app.elasticsearch.index_date_format"),
+		BatchSize:       readValues.ReadValuesInt("app.elasticsearch.batch_size"),
+		FlushInterval:   readValues.ReadValuesDuration("app.elasticsearch.flush_interval") * time.Second,
+		BufferSize:      readValues.ReadValuesInt("app.elasticsearch.buffer_size"),
+		Timeout:         readValues.ReadValuesDuration("app.elasticsearch.timeout") * time.Second,
+	}
+	sink, err := NewElasticsearchSink(cfg)
+	if err != nil {
+		logging.Logger.Error("the Elasticsearch configuration is not valid, the verdict events aren't indexed: " +
+			err.Error())
+		return nil
+	}
+	go sink.flushLoop()
+	return sink
+}
+
+// NewElasticsearchSink creates the sink from its configuration, the configuration is checked
+func NewElasticsearchSink(cfg ElasticsearchConfig) (*ElasticsearchSink, error) {
+	if len(cfg.URLs) == 0 || cfg.Index == "" {
+		return nil, errors.New("urls and index are required")
+	}
+	if cfg.BatchSize <= 0 || cfg.BufferSize < cfg.BatchSize {
+		return nil, errors.New("batch_size must be positive and buffer_size can't be less than batch_size")
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	for n, url := range cfg.URLs {
+		cfg.URLs[n] = strings.TrimSuffix(url, "/")
+	}
+	return &ElasticsearchSink{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}, wake: make(chan struct{}, 1)}, nil
+}
+
+// Publish buffers the document of the event, it never blocks the ICAP transaction
+func (s *ElasticsearchSink) Publish(event *VerdictEvent) {
+	source, err := json.Marshal(ecsDocumentOf(event))
+	if err != nil {
+		logging.Logger.Error("couldn't encode the verdict event: " + err.Error())
+		return
+	}
+	index := s.cfg.Index
+	if s.cfg.IndexDateFormat != "" {
+		index += "-" + event.Time.UTC().Format(s.cfg.IndexDateFormat)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) >= s.cfg.BufferSize {
+		logging.Logger.Warn("the Elasticsearch buffer is full, the verdict event of " + event.XICAPMetadata +
+			" is dropped")
+		return
+	}
+	s.pending = append(s.pending, bulkDocument{index: index, source: source})
+	if len(s.pending) >= s.cfg.BatchSize {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ecsDocumentOf maps the verdict event to the fields of Elastic Common Schema
+func ecsDocumentOf(event *VerdictEvent) *ecsDocument {
+	doc := &ecsDocument{Timestamp: event.Time}
+	doc.Event.Kind, doc.Event.Category, doc.Event.Type = "event", []string{"file"}, []string{"info"}
+	doc.Event.Action, doc.Event.Outcome, doc.Event.Dataset, doc.Event.ID = "scan", "success", "icapeg.verdicts",
+		event.XICAPMetadata
+	switch event.Verdict {
+	case "malicious":
+		doc.Event.Kind, doc.Event.Category, doc.Event.Type = "alert", []string{"malware", "file"}, []string{"denied"}
+		if event.Delivered {
+			doc.Event.Type = []string{"info"}
+		}
+	case "error":
+		doc.Event.Outcome = "failure"
+	case "pending":
+		doc.Event.Outcome = "unknown"
+	}
+	doc.Observer.Product, doc.Observer.Type = "ICAPeg", "proxy"
+	doc.Service.Name = event.ServiceName
+	if event.ClientIP != "" {
+		doc.Source = &ecsSource{IP: event.ClientIP}
+	}
+	if event.Username != "" {
+		doc.User = &ecsUser{Name: event.Username}
+	}
+	if event.RequestedURL != "" {
+		doc.URL = &ecsURL{Full: event.RequestedURL}
+	}
+	if event.FileName != "" || event.FileHash != "" || event.FileSize != "" {
+		doc.File = &ecsFile{Name: event.FileName}
+		doc.File.Size, _ = strconv.ParseInt(event.FileSize, 10, 64)
+		if event.FileHash != "" {
+			doc.File.Hash = &ecsHash{SHA256: event.FileHash}
+		}
+	}
+	if event.Threat != "" {
+		doc.Threat = &ecsThreat{}
+		doc.Threat.Software.Name = event.Threat
+	}
+	doc.ICAPeg.Verdict, doc.ICAPeg.Vendor, doc.ICAPeg.Method = event.Verdict, event.Vendor, event.Method
+	doc.ICAPeg.Tenant, doc.ICAPeg.Delivered, doc.ICAPeg.JobID = event.Tenant, event.Delivered, event.JobID
+	return doc
+}
+
+// flushLoop sends the buffered documents every flush interval or once a batch is full, the wait between
+// the retries doubles while the bulk requests fail
+func (s *ElasticsearchSink) flushLoop() {
+	retryDelay := time.Duration(0)
+	for {
+		if retryDelay > 0 {
+			time.Sleep(retryDelay)
+		} else {
+			select {
+			case <-s.wake:
+			case <-time.After(s.cfg.FlushInterval):
+			}
+		}
+		if err := s.Flush(); err != nil {
+			logging.Logger.Error("couldn't index the verdict events in Elasticsearch, retrying: " + err.Error())
+			retryDelay = nextRetryDelay(retryDelay)
+			continue
+		}
+		retryDelay = 0
+	}
+}
+
+func nextRetryDelay(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return time.Second
+	}
+	if delay*2 > maxBulkRetryDelay {
+		return maxBulkRetryDelay
+	}
+	return delay * 2
+}
+
+// Flush sends the buffered documents in batches, the documents which weren't indexed because of an outage
+// or an overload stay in the buffer and an error is returned
+func (s *ElasticsearchSink) Flush() error {
+	for {
+		s.mu.Lock()
+		n := len(s.pending)
+		if n > s.cfg.BatchSize {
+			n = s.cfg.BatchSize
+		}
+		batch := append([]bulkDocument(nil), s.pending[:n]...)
+		s.mu.Unlock()
+		if len(batch) == 0 {
+			return nil
+		}
+
+		retry, err := s.bulk(batch)
+		s.mu.Lock()
+		// the documents which are published while the request is sent are behind the batch
+		s.pending = append(retry, s.pending[n:]...)
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if len(retry) > 0 {
+			return errors.New(strconv.Itoa(len(retry)) + " documents were rejected by an overloaded cluster")
+		}
+	}
+}
+
+// bulk sends the batch and returns the documents which must be sent again
+func (s *ElasticsearchSink) bulk(batch []bulkDocument) ([]bulkDocument, error) {
+	var body bytes.Buffer
+	for _, doc := range batch {
+		// create works with the indices and the data streams
+		action, _ := json.Marshal(map[string]map[string]string{"create": {"_index": doc.index}})
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc.source)
+		body.WriteByte('\n')
+	}
+	s.mu.Lock()
+	url := s.cfg.URLs[s.node%len(s.cfg.URLs)]
+	s.mu.Unlock()
+	req, err := http.NewRequest(http.MethodPost, url+"/_bulk", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if s.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+s.cfg.APIKey)
+	} else if s.cfg.Username != "" {
+		req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.nextNode()
+		return batch, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		s.nextNode()
+		return batch, errors.New(url + " returned status code " + strconv.Itoa(resp.StatusCode))
+	}
+	if resp.StatusCode != http.StatusOK {
+		// the request itself is wrong, ex: the credentials, sending it again doesn't help
+		logging.Logger.Error("Elasticsearch rejected the bulk request with status code " +
+			strconv.Itoa(resp.StatusCode) + ", " + strconv.Itoa(len(batch)) + " documents are dropped: " +
+			string(respBody))
+		return nil, nil
+	}
+	return bulkRetries(batch, respBody), nil
+}
+
+// bulkRetries returns the documents of the bulk response which were rejected because the cluster was
+// overloaded, the documents which were rejected for another reason (ex: a mapping conflict) are dropped
+func bulkRetries(batch []bulkDocument, respBody []byte) []bulkDocument {
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil || !result.Errors {
+		return nil
+	}
+	var retry []bulkDocument
+	for n, item := range result.Items {
+		if n >= len(batch) {
+			break
+		}
+		for _, status := range item {
+			switch {
+			case status.Status == http.StatusTooManyRequests || status.Status >= 500:
+				retry = append(retry, batch[n])
+			case status.Error != nil:
+				logging.Logger.Error("Elasticsearch rejected a verdict event: " + status.Error.Type + ": " +
+					status.Error.Reason)
+			}
+		}
+	}
+	return retry
+}
+
+func (s *ElasticsearchSink) nextNode() {
+	s.mu.Lock()
+	s.node++
+	s.mu.Unlock()
+}
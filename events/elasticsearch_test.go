@@ -0,0 +1,63 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"icapeg/logging"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestElasticsearchBulkRetry(t *testing.T) {
+	logging.Logger = zap.NewNop()
+	var requests [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var lines []string
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		requests = append(requests, lines)
+		if len(requests) == 1 {
+			w.Write([]byte(`{"errors":true,"items":[{"create":{"status":201}},{"create":{"status":429}}]}`))
+			return
+		}
+		w.Write([]byte(`{"errors":false,"items":[{"create":{"status":201}}]}`))
+	}))
+	defer server.Close()
+
+	sink, err := NewElasticsearchSink(ElasticsearchConfig{URLs: []string{server.URL + "/"}, Index: "icapeg-verdicts",
+		IndexDateFormat: "2006.01.02", BatchSize: 10, BufferSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Publish(&VerdictEvent{Time: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), ServiceName: "clamav",
+		Verdict: "malicious", FileHash: "abc", FileSize: "68", Threat: "Eicar"})
+	sink.Publish(&VerdictEvent{Time: time.Date(2026, 1, 2, 3, 4, 6, 0, time.UTC), ServiceName: "clamav",
+		Verdict: "clean"})
+
+	if err = sink.Flush(); err == nil {
+		t.Fatalf("expected an error for the overloaded cluster")
+	}
+	if len(requests[0]) != 4 || requests[0][0] != `{"create":{"_index":"icapeg-verdicts-2026.01.02"}}` {
+		t.Fatalf("unexpected bulk request %q", requests[0])
+	}
+	var doc map[string]interface{}
+	json.Unmarshal([]byte(requests[0][1]), &doc)
+	if doc["event"].(map[string]interface{})["kind"] != "alert" ||
+		doc["file"].(map[string]interface{})["size"] != float64(68) {
+		t.Fatalf("unexpected document %s", requests[0][1])
+	}
+
+	if err = sink.Flush(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(requests) != 2 || len(requests[1]) != 2 || !strings.Contains(requests[1][1], `"verdict":"clean"`) {
+		t.Fatalf("only the rejected document should be sent again, got %q", requests[1:])
+	}
+}
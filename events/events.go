@@ -0,0 +1,77 @@
+package events
+
+import (
+	"icapeg/logging"
+	"time"
+)
+
+// VerdictEvent represents the verdict of a service on a scanned HTTP message, it's published to the event sinks
+type VerdictEvent struct {
+	Time          time.Time         `json:"time"`
+	XICAPMetadata string            `json:"x_icap_metadata"`
+	ServiceName   string            `json:"service"`
+	Tenant        string            `json:"tenant,omitempty"`
+	Vendor        string            `json:"vendor"`
+	Method        string            `json:"method"`
+	Verdict       string            `json:"verdict"`
+	ClientIP      string            `json:"client_ip,omitempty"`
+	Username      string            `json:"username,omitempty"`
+	RequestedURL  string            `json:"url,omitempty"`
+	FileName      string            `json:"file_name,omitempty"`
+	FileHash      string            `json:"file_hash,omitempty"`
+	FileDigests   map[string]string `json:"file_digests,omitempty"` // the digests which were configured in [app.digests]
+	FileSize      string            `json:"file_size,omitempty"`
+	Threat        string            `json:"threat,omitempty"`
+	Delivered     bool              `json:"delivered,omitempty"` // the file was delivered to the user before the verdict
+	JobID         string            `json:"job_id,omitempty"`    // the id of the scan job which was pulled from a queue
+}
+
+// Sink is the interface which every verdict event sink (NATS, RabbitMQ, etc) implements, Publish must not block
+// the transaction
+type Sink interface {
+	Publish(event *VerdictEvent)
+}
+
+// InitEvents reads the event sink sections of config.toml file and registers the enabled sinks
+func InitEvents() {
+	logging.Logger.Info("loading the event sinks configuration")
+	if sink := initNATSSink(); sink != nil {
+		Register(sink)
+	}
+	if sink := initRabbitMQSink(); sink != nil {
+		Register(sink)
+	}
+	if sink := initElasticsearchSink(); sink != nil {
+		Register(sink)
+	}
+	initForwarding()
+}
+
+// Register subscribes a sink to the transaction_completed events of the bus, the sinks queue the events
+// themselves so they're handed over by the goroutine of the transaction
+func Register(sink Sink) {
+	Subscribe(sinkName(sink), []string{TypeTransactionCompleted}, SubscriberFunc(func(event *Event) {
+		sink.Publish(event.Verdict)
+	}), 0)
+}
+
+// Enabled reports whether any sink gets the verdict events, so the events aren't built for nothing
+func Enabled() bool {
+	return Subscribed(TypeTransactionCompleted)
+}
+
+// Publish emits the verdict event of a completed transaction
+func Publish(event *VerdictEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	Emit(&Event{Type: TypeTransactionCompleted, Time: event.Time, Verdict: event})
+}
+
+// Detection emits the malicious verdict event, the alerters notify the security team about it
+func Detection(event *VerdictEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	Emit(&Event{Type: TypeDetection, Time: event.Time, Verdict: event})
+}
@@ -0,0 +1,72 @@
+package events
+
+import "testing"
+
+type recordingSink struct {
+	events []*VerdictEvent
+}
+
+func (s *recordingSink) Publish(event *VerdictEvent) {
+	s.events = append(s.events, event)
+}
+
+func TestPublish(t *testing.T) {
+	sink := &recordingSink{}
+	Register(sink)
+	if !Enabled() {
+		t.Fatalf("the events should be enabled after registering a sink")
+	}
+	Publish(&VerdictEvent{ServiceName: "clamav", Verdict: "malicious"})
+	if len(sink.events) != 1 || sink.events[0].Time.IsZero() {
+		t.Fatalf("expected the event with its time, got %+v", sink.events)
+	}
+}
+
+func TestSubject(t *testing.T) {
+	if subject := Subject("icapeg.verdicts.", "clam av.prod"); subject != "icapeg.verdicts.clam_av_prod" {
+		t.Fatalf("unexpected subject %q", subject)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	var detections []*Event
+	Subscribe("detections", []string{TypeDetection}, SubscriberFunc(func(event *Event) {
+		detections = append(detections, event)
+	}), 0)
+	if Subscribed(TypeConfigReloaded) {
+		t.Fatalf("no subscriber should get the config_reloaded events")
+	}
+	Detection(&VerdictEvent{ServiceName: "clamav", Verdict: "malicious"})
+	ConfigReloaded("sighup", []string{"certificates"}, nil)
+	if len(detections) != 1 || detections[0].Type != TypeDetection || detections[0].Verdict.ServiceName != "clamav" {
+		t.Fatalf("expected the detection only, got %+v", detections)
+	}
+
+	block := make(chan struct{})
+	Subscribe("slow", []string{TypeConfigReloaded}, SubscriberFunc(func(event *Event) { <-block }), 1)
+	for i := 0; i < 3; i++ {
+		ConfigReloaded("admin_api", []string{"rules"}, nil)
+	}
+	if stats := Stats()["slow"]; stats.Dropped == 0 {
+		t.Fatalf("the events of a full queue should be dropped, got %+v", stats)
+	}
+	close(block)
+}
+
+func TestVendorState(t *testing.T) {
+	var states []string
+	Subscribe("vendor-states", []string{TypeVendorStateChanged}, SubscriberFunc(func(event *Event) {
+		states = append(states, event.VendorState.ServiceName+" "+event.VendorState.State)
+	}), 0)
+	VendorReached("hashlookup")
+	VendorFailed(&VendorStateEvent{ServiceName: "hashlookup", Vendor: "clhashlookup", Error: "timeout"})
+	VendorFailed(&VendorStateEvent{ServiceName: "hashlookup", Vendor: "clhashlookup", Error: "timeout"})
+	if _, down := DownVendors()["hashlookup"]; !down {
+		t.Fatalf("the vendor should be down")
+	}
+	VendorReached("hashlookup")
+	VendorReached("hashlookup")
+	if len(states) != 2 || states[0] != "hashlookup down" || states[1] != "hashlookup up" {
+		t.Fatalf("expected one event per state change, got %v", states)
+	}
+}
@@ -0,0 +1,185 @@
+package events
+
+import (
+	"errors"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// the defaults of [app.events] section
+const (
+	defaultForwardQueueSize = 10000
+	defaultForwardRetries   = 5
+	defaultForwardBackoff   = time.Second
+	maxForwardRetryDelay    = time.Minute
+	defaultForwardBatchSize = 1
+)
+
+// ForwardConfig represents [app.events] section configuration, the buffering and the retries which every
+// forwarding sink (webhook, Kafka, syslog) shares
+type ForwardConfig struct {
+	Types        []string      // the event types which are forwarded, all of them if it's empty
+	QueueSize    int           // the events which wait for a sink, the new ones are dropped while its queue is full
+	MaxRetries   int           // the retries of a batch which couldn't be delivered before it's dropped
+	RetryBackoff time.Duration // the wait before the first retry, it's doubled after every retry up to a minute
+}
+
+// Forwarder delivers the events of the bus to a system outside ICAPeg, ex: a SIEM, a webhook or a broker.
+// Forward is called by the goroutine of the sink only, a batch whose Forward fails is retried unless the
+// error is permanent
+type Forwarder interface {
+	Forward(batch []*Event) error
+}
+
+// permanentError is an error of a batch which would fail again, ex: the webhook rejected it with 400
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// forwardSink queues the events of a Forwarder and delivers them in batches of up to batchSize events in its
+// own goroutine, so a slow or unreachable system never delays the transactions
+type forwardSink struct {
+	name      string
+	cfg       ForwardConfig
+	target    Forwarder
+	batchSize int
+	queue     chan *Event
+	dropped   uint64
+}
+
+// initForwarding reads [app.events] section and subscribes its enabled sinks to the bus
+func initForwarding() {
+	if !readValues.IsSecExists("app.events") || !readValues.ReadValuesBool("app.events.enabled") {
+		return
+	}
+	logging.Logger.Debug("loading the event forwarding configuration")
+	cfg := ForwardConfig{
+		QueueSize:    defaultForwardQueueSize,
+		MaxRetries:   defaultForwardRetries,
+		RetryBackoff: defaultForwardBackoff,
+	}
+	if readValues.IsSecExists("app.events.types") {
+		cfg.Types = readValues.ReadValuesSlice("app.events.types")
+	}
+	if readValues.IsSecExists("app.events.queue_size") {
+		cfg.QueueSize = readValues.ReadValuesInt("app.events.queue_size")
+	}
+	if readValues.IsSecExists("app.events.max_retries") {
+		cfg.MaxRetries = readValues.ReadValuesInt("app.events.max_retries")
+	}
+	if readValues.IsSecExists("app.events.retry_backoff") {
+		cfg.RetryBackoff = readValues.ReadValuesDuration("app.events.retry_backoff") * time.Second
+	}
+	if cfg.QueueSize <= 0 || cfg.MaxRetries < 0 || cfg.RetryBackoff <= 0 {
+		logging.Logger.Error("the events configuration is not valid, the events aren't forwarded: queue_size " +
+			"and retry_backoff must be positive and max_retries can't be negative")
+		return
+	}
+	for _, eventType := range cfg.Types {
+		if !knownTypes[eventType] {
+			logging.Logger.Error("the events configuration is not valid, the events aren't forwarded: unknown " +
+				"event type " + eventType)
+			return
+		}
+	}
+
+	for _, sink := range []struct {
+		name      string
+		batchSize int
+		init      func(section string) (Forwarder, error)
+	}{
+		{"webhook", defaultForwardBatchSize, initWebhookForwarder},
+		{"kafka", kafkaBatchSize, initKafkaForwarder},
+		{"syslog", defaultForwardBatchSize, initSyslogForwarder},
+	} {
+		section := "app.events." + sink.name
+		if !readValues.IsSecExists(section) || !readValues.ReadValuesBool(section+".enabled") {
+			continue
+		}
+		target, err := sink.init(section)
+		if err != nil {
+			logging.Logger.Error("the " + sink.name + " events configuration is not valid, the events aren't " +
+				"forwarded to it: " + err.Error())
+			continue
+		}
+		Forward(sink.name, cfg, target, sink.batchSize)
+	}
+}
+
+// Forward subscribes the forwarder to the events of the types of the configuration, the events are queued and
+// delivered in batches of up to batchSize events
+func Forward(name string, cfg ForwardConfig, target Forwarder, batchSize int) {
+	if batchSize <= 0 {
+		batchSize = defaultForwardBatchSize
+	}
+	s := &forwardSink{name: name, cfg: cfg, target: target, batchSize: batchSize,
+		queue: make(chan *Event, cfg.QueueSize)}
+	go s.deliverLoop()
+	Subscribe(name, cfg.Types, s, 0)
+}
+
+// Handle queues the event, it never blocks the transaction
+func (s *forwardSink) Handle(event *Event) {
+	select {
+	case s.queue <- event:
+	default:
+		if atomic.AddUint64(&s.dropped, 1) == 1 {
+			logging.Logger.Warn("the queue of the " + s.name + " sink is full, its events are dropped")
+		}
+	}
+}
+
+// deliverLoop takes the queued events in batches, the events which are already queued join the first one
+func (s *forwardSink) deliverLoop() {
+	for event := range s.queue {
+		batch := []*Event{event}
+	fill:
+		for len(batch) < s.batchSize {
+			select {
+			case next := <-s.queue:
+				batch = append(batch, next)
+			default:
+				break fill
+			}
+		}
+		s.deliver(batch)
+	}
+}
+
+// deliver forwards the batch, it waits and tries again while it fails with an error which isn't permanent
+// and drops the batch after the last retry
+func (s *forwardSink) deliver(batch []*Event) {
+	delay := s.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := s.target.Forward(batch)
+		if err == nil {
+			return
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) || attempt == s.cfg.MaxRetries {
+			logging.Logger.Error("couldn't forward " + eventsCount(len(batch)) + " to the " + s.name +
+				" sink, they're dropped: " + err.Error())
+			return
+		}
+		logging.Logger.Warn("couldn't forward " + eventsCount(len(batch)) + " to the " + s.name +
+			" sink, retrying in " + delay.String() + ": " + err.Error())
+		time.Sleep(delay)
+		if delay *= 2; delay > maxForwardRetryDelay {
+			delay = maxForwardRetryDelay
+		}
+	}
+}
+
+func eventsCount(n int) string {
+	if n == 1 {
+		return "1 event"
+	}
+	return strconv.Itoa(n) + " events"
+}
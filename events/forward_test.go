@@ -0,0 +1,102 @@
+package events
+
+import (
+	"icapeg/logging"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestWebhookForwarderRetries(t *testing.T) {
+	logging.Logger = zap.NewNop()
+	statuses := []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusBadRequest}
+	var signatures, bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		signatures = append(signatures, r.Header.Get(WebhookSignatureHeader))
+		if r.Header.Get(WebhookEventHeader) != TypeBlocked {
+			t.Errorf("unexpected event header %q", r.Header.Get(WebhookEventHeader))
+		}
+		w.WriteHeader(statuses[len(bodies)-1])
+	}))
+	defer server.Close()
+
+	webhook, err := NewWebhookForwarder(server.URL, "s3cret", server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &forwardSink{name: "webhook", cfg: ForwardConfig{MaxRetries: 3, RetryBackoff: time.Millisecond},
+		target: webhook}
+	event := &Event{Type: TypeBlocked, Blocked: &BlockedEvent{ServiceName: "clamav", Reason: "policy_blocked"}}
+	s.deliver([]*Event{event})
+	if len(bodies) != 2 || bodies[0] != bodies[1] {
+		t.Fatalf("the event should be posted again after the 503, got %v", bodies)
+	}
+	if signatures[0] != Sign([]byte("s3cret"), []byte(bodies[0])) || !strings.HasPrefix(signatures[0], "sha256=") {
+		t.Fatalf("unexpected signature %q", signatures[0])
+	}
+	// a 400 is permanent, the event isn't posted again
+	s.deliver([]*Event{event})
+	if len(bodies) != 3 {
+		t.Fatalf("the rejected event shouldn't be retried, got %d posts", len(bodies))
+	}
+}
+
+type failingForwarder struct {
+	calls int
+}
+
+func (f *failingForwarder) Forward(batch []*Event) error {
+	f.calls++
+	return io.ErrUnexpectedEOF
+}
+
+func TestForwardSinkDropsAfterTheRetries(t *testing.T) {
+	logging.Logger = zap.NewNop()
+	target := &failingForwarder{}
+	s := &forwardSink{name: "failing", cfg: ForwardConfig{MaxRetries: 2, RetryBackoff: time.Millisecond},
+		target: target}
+	s.deliver([]*Event{{Type: TypeConfigReloaded}})
+	if target.calls != 3 {
+		t.Fatalf("the batch should be tried once and retried twice, got %d calls", target.calls)
+	}
+}
+
+func TestSyslogForwarder(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	forwarder, err := NewSyslogForwarder(logging.Output{Destination: logging.DestinationSyslog,
+		Encoder: logging.EncoderCEF, SyslogAddress: "udp://" + conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = forwarder.Forward([]*Event{{Type: TypeBlocked, Time: time.Now(), Blocked: &BlockedEvent{
+		XICAPMetadata: "abc", ServiceName: "clamav", Reason: "policy_blocked",
+		Details: map[string]interface{}{"policy": "guests"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := string(buf[:n])
+	for _, part := range []string{"CEF:0|ICAPeg|ICAPeg|", "|blocked|", "externalId=abc", "outcome=blocked",
+		"details_policy=guests", "cs1=clamav"} {
+		if !strings.Contains(message, part) {
+			t.Errorf("the message should have %q: %s", part, message)
+		}
+	}
+}
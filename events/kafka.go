@@ -0,0 +1,136 @@
+package events
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"icapeg/readValues"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// the events which are written to Kafka at once
+const kafkaBatchSize = 100
+
+// the header of the Kafka messages which has the type of their events
+const kafkaEventHeader = "event_type"
+
+// KafkaConfig represents [app.events.kafka] section configuration
+type KafkaConfig struct {
+	Brokers       []string
+	Topic         string
+	TLS           bool
+	SASLMechanism string // plain, scram-sha-256 or scram-sha-512, "" = no authentication
+	Username      string
+	Password      string
+	Timeout       time.Duration
+}
+
+// KafkaForwarder writes the events as JSON messages to a topic, the key of a message is the service of its event
+// so the events of a service stay in order in their partition
+type KafkaForwarder struct {
+	writer  *kafka.Writer
+	timeout time.Duration
+}
+
+// initKafkaForwarder reads [app.events.kafka] section
+func initKafkaForwarder(section string) (Forwarder, error) {
+	cfg := KafkaConfig{
+		Brokers: readValues.ReadValuesSlice(section + ".brokers"),
+		Topic:   readValues.ReadValuesString(section + ".topic"),
+		Timeout: 10 * time.Second,
+	}
+	if readValues.IsSecExists(section + ".tls") {
+		cfg.TLS = readValues.ReadValuesBool(section + ".tls")
+	}
+	if readValues.IsSecExists(section + ".sasl_mechanism") {
+		cfg.SASLMechanism = strings.ToLower(readValues.ReadValuesString(section + ".sasl_mechanism"))
+		cfg.Username = readValues.ReadValuesString(section + ".username")
+		cfg.Password = readValues.ReadValuesString(section + ".password")
+	}
+	if readValues.IsSecExists(section + ".timeout") {
+		cfg.Timeout = readValues.ReadValuesDuration(section+".timeout") * time.Second
+	}
+	return NewKafkaForwarder(cfg)
+}
+
+// NewKafkaForwarder creates the forwarder from its configuration, the configuration is checked
+func NewKafkaForwarder(cfg KafkaConfig) (*KafkaForwarder, error) {
+	if len(cfg.Brokers) == 0 || cfg.Topic == "" {
+		return nil, errors.New("brokers and topic are required")
+	}
+	if cfg.Timeout <= 0 {
+		return nil, errors.New("timeout must be positive")
+	}
+	transport := &kafka.Transport{}
+	if cfg.TLS {
+		transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	var err error
+	switch cfg.SASLMechanism {
+	case "":
+	case "plain":
+		transport.SASL = plain.Mechanism{Username: cfg.Username, Password: cfg.Password}
+	case "scram-sha-256":
+		transport.SASL, err = scram.Mechanism(scram.SHA256, cfg.Username, cfg.Password)
+	case "scram-sha-512":
+		transport.SASL, err = scram.Mechanism(scram.SHA512, cfg.Username, cfg.Password)
+	default:
+		err = errors.New("sasl_mechanism must be plain, scram-sha-256 or scram-sha-512")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &KafkaForwarder{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Topic:        cfg.Topic,
+			Balancer:     &kafka.Hash{},
+			BatchSize:    kafkaBatchSize,
+			BatchTimeout: 10 * time.Millisecond,
+			// the batches are retried by the sink with the backoff of [app.events]
+			MaxAttempts:  1,
+			RequiredAcks: kafka.RequireAll,
+			Transport:    transport,
+		},
+		timeout: cfg.Timeout,
+	}, nil
+}
+
+// Forward writes the batch and waits for the acknowledgements of the brokers
+func (f *KafkaForwarder) Forward(batch []*Event) error {
+	messages := make([]kafka.Message, 0, len(batch))
+	for _, event := range batch {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return &permanentError{err}
+		}
+		messages = append(messages, kafka.Message{
+			Key:     []byte(eventService(event)),
+			Value:   value,
+			Headers: []kafka.Header{{Key: kafkaEventHeader, Value: []byte(event.Type)}},
+			Time:    event.Time,
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	return f.writer.WriteMessages(ctx, messages...)
+}
+
+// eventService returns the service of the event, it's empty for the events which aren't of a service
+func eventService(event *Event) string {
+	switch {
+	case event.Verdict != nil:
+		return event.Verdict.ServiceName
+	case event.Blocked != nil:
+		return event.Blocked.ServiceName
+	case event.VendorState != nil:
+		return event.VendorState.ServiceName
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+package events
+
+import (
+	"encoding/json"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// the number of events which wait to be published, the events are dropped when NATS can't keep up
+const natsQueueSize = 4096
+
+// NATSSink publishes the verdict events as JSON on a subject per service, with JetStream acknowledgements
+// if jetstream is set
+type NATSSink struct {
+	conn          *nats.Conn
+	js            nats.JetStreamContext
+	subjectPrefix string
+	queue         chan *VerdictEvent
+}
+
+// initNATSSink reads [app.nats_events] section and returns nil if it doesn't exist or it's disabled
+func initNATSSink() *NATSSink {
+	if !readValues.IsSecExists("app.nats_events") || !readValues.ReadValuesBool("app.nats_events.enabled") {
+		return nil
+	}
+	logging.Logger.Debug("loading NATS events configuration")
+	options := []nats.Option{nats.Name("icapeg"), nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				logging.Logger.Warn("disconnected from NATS, the verdict events wait for the reconnect: " + err.Error())
+			}
+		})}
+	if credentials := readValues.ReadValuesString("app.nats_events.credentials_file"); credentials != "" {
+		options = append(options, nats.UserCredentials(credentials))
+	}
+	conn, err := nats.Connect(readValues.ReadValuesString("app.nats_events.url"), options...)
+	if err != nil {
+		logging.Logger.Error("couldn't connect to NATS, the verdict events aren't published: " + err.Error())
+		return nil
+	}
+	sink := &NATSSink{
+		conn:          conn,
+		subjectPrefix: readValues.ReadValuesString("app.nats_events.subject_prefix"),
+		queue:         make(chan *VerdictEvent, natsQueueSize),
+	}
+	if readValues.ReadValuesBool("app.nats_events.jetstream") {
+		if sink.js, err = conn.JetStream(nats.MaxWait(5 * time.Second)); err != nil {
+			logging.Logger.Error("couldn't use JetStream, the verdict events aren't published: " + err.Error())
+			conn.Close()
+			return nil
+		}
+	}
+	go sink.publishLoop()
+	return sink
+}
+
+// Publish queues the event, it never blocks the ICAP transaction
+func (s *NATSSink) Publish(event *VerdictEvent) {
+	select {
+	case s.queue <- event:
+	default:
+		logging.Logger.Warn("the NATS events queue is full, the verdict event of " + event.XICAPMetadata +
+			" is dropped")
+	}
+}
+
+func (s *NATSSink) publishLoop() {
+	for event := range s.queue {
+		data, err := json.Marshal(event)
+		if err != nil {
+			logging.Logger.Error("couldn't encode the verdict event: " + err.Error())
+			continue
+		}
+		subject := Subject(s.subjectPrefix, event.ServiceName)
+		if s.js != nil {
+			_, err = s.js.Publish(subject, data)
+		} else {
+			err = s.conn.Publish(subject, data)
+		}
+		if err != nil {
+			logging.Logger.Error("couldn't publish the verdict event to NATS: " + err.Error())
+		}
+	}
+}
+
+// Subject returns the NATS subject of the events of the service, the characters which NATS uses in
+// subjects (. * > and spaces) are replaced in the service name
+func Subject(prefix, serviceName string) string {
+	return topic(prefix, serviceName)
+}
+
+// topic appends the service name to the prefix as a single word of a NATS subject or an AMQP routing key
+func topic(prefix, serviceName string) string {
+	replacer := strings.NewReplacer(".", "_", "*", "_", ">", "_", "#", "_", " ", "_")
+	return strings.TrimSuffix(prefix, ".") + "." + replacer.Replace(serviceName)
+}
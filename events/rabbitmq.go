@@ -0,0 +1,133 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// the number of events which wait to be published, the events are dropped when RabbitMQ can't keep up
+const rabbitMQQueueSize = 4096
+
+// the wait before connecting to RabbitMQ again after the connection failed
+const rabbitMQRetryDelay = 5 * time.Second
+
+// RabbitMQSink publishes the verdict events as JSON to an exchange with a routing key per service,
+// the publishes are confirmed by the broker and the sink connects again when the connection is lost
+type RabbitMQSink struct {
+	url              string
+	exchange         string
+	routingKeyPrefix string
+	persistent       bool
+	queue            chan *VerdictEvent
+
+	conn    *amqp.Connection
+	channel *amqp.Channel
+}
+
+// initRabbitMQSink reads [app.rabbitmq_events] section and returns nil if it doesn't exist or it's disabled
+func initRabbitMQSink() *RabbitMQSink {
+	if !readValues.IsSecExists("app.rabbitmq_events") || !readValues.ReadValuesBool("app.rabbitmq_events.enabled") {
+		return nil
+	}
+	logging.Logger.Debug("loading RabbitMQ events configuration")
+	sink := &RabbitMQSink{
+		url:              readValues.ReadValuesString("app.rabbitmq_events.url"),
+		exchange:         readValues.ReadValuesString("app.rabbitmq_events.exchange"),
+		routingKeyPrefix: readValues.ReadValuesString("app.rabbitmq_events.routing_key_prefix"),
+		persistent:       readValues.ReadValuesBool("app.rabbitmq_events.persistent"),
+		queue:            make(chan *VerdictEvent, rabbitMQQueueSize),
+	}
+	if err := sink.connect(); err != nil {
+		logging.Logger.Error("couldn't connect to RabbitMQ, the verdict events wait for the connection: " +
+			err.Error())
+	}
+	go sink.publishLoop()
+	return sink
+}
+
+// Publish queues the event, it never blocks the ICAP transaction
+func (s *RabbitMQSink) Publish(event *VerdictEvent) {
+	select {
+	case s.queue <- event:
+	default:
+		logging.Logger.Warn("the RabbitMQ events queue is full, the verdict event of " + event.XICAPMetadata +
+			" is dropped")
+	}
+}
+
+// connect opens the connection and a channel in confirm mode
+func (s *RabbitMQSink) connect() error {
+	conn, err := amqp.Dial(s.url)
+	if err != nil {
+		return err
+	}
+	channel, err := conn.Channel()
+	if err == nil {
+		err = channel.Confirm(false)
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	s.conn, s.channel = conn, channel
+	return nil
+}
+
+func (s *RabbitMQSink) close() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn, s.channel = nil, nil
+}
+
+func (s *RabbitMQSink) publishLoop() {
+	for event := range s.queue {
+		data, err := json.Marshal(event)
+		if err != nil {
+			logging.Logger.Error("couldn't encode the verdict event: " + err.Error())
+			continue
+		}
+		// the event is kept until the broker confirms it, the events behind it wait in the queue
+		for {
+			if err = s.publish(topic(s.routingKeyPrefix, event.ServiceName), data); err == nil {
+				break
+			}
+			logging.Logger.Error("couldn't publish the verdict event to RabbitMQ: " + err.Error())
+			s.close()
+			time.Sleep(rabbitMQRetryDelay)
+		}
+	}
+}
+
+func (s *RabbitMQSink) publish(routingKey string, data []byte) error {
+	if s.channel == nil || s.conn.IsClosed() {
+		s.close()
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	msg := amqp.Publishing{ContentType: "application/json", Timestamp: time.Now(), Body: data}
+	if s.persistent {
+		msg.DeliveryMode = amqp.Persistent
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	confirmation, err := s.channel.PublishWithDeferredConfirmWithContext(ctx, s.exchange, routingKey, false, false, msg)
+	if err != nil {
+		return err
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		// the broker refused the event, ex: the exchange doesn't exist, it isn't sent again
+		logging.Logger.Error("RabbitMQ refused the verdict event, it's dropped")
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package events
+
+import (
+	"encoding/json"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"sort"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// initSyslogForwarder reads [app.events.syslog] section, the events are encoded like the entries of the log
+// streams (CEF by default) and [app.events.syslog.fields] maps their fields to the keys of CEF and LEEF
+func initSyslogForwarder(section string) (Forwarder, error) {
+	output := logging.Output{
+		Destination:    logging.DestinationSyslog,
+		Encoder:        logging.EncoderCEF,
+		SyslogAddress:  readValues.ReadValuesString(section + ".address"),
+		SyslogFacility: readValues.ReadValuesString(section + ".facility"),
+	}
+	if readValues.IsSecExists(section + ".encoder") {
+		output.Encoder = readValues.ReadValuesString(section + ".encoder")
+	}
+	if readValues.IsSecExists(section + ".fields") {
+		output.Fields = readValues.ReadValuesMap(section + ".fields")
+	}
+	return NewSyslogForwarder(output)
+}
+
+// SyslogForwarder sends every event to a syslog server as a CEF, LEEF or JSON message whose event id is the
+// type of the event, so the SIEMs which ingest the access log over syslog ingest the events the same way
+type SyslogForwarder struct {
+	core zapcore.Core
+}
+
+// NewSyslogForwarder creates the forwarder of the output
+func NewSyslogForwarder(output logging.Output) (*SyslogForwarder, error) {
+	core, err := logging.NewCore(output)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogForwarder{core: core}, nil
+}
+
+// Forward sends the events one by one
+func (f *SyslogForwarder) Forward(batch []*Event) error {
+	for _, event := range batch {
+		fields, err := eventFields(event)
+		if err != nil {
+			return &permanentError{err}
+		}
+		entry := zapcore.Entry{Level: zapcore.InfoLevel, Time: event.Time, Message: event.Type}
+		if event.Type == TypeDetection || event.Type == TypeBlocked {
+			entry.Level = zapcore.WarnLevel
+		}
+		if err = f.core.Write(entry, fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventFields returns the fields of the payload of the event with the names of the access log, ex: the
+// X-ICAP-Metadata. The nested objects are flattened with their keys joined by underscores, ex: details_policy,
+// and the blocked events have the blocked verdict
+func eventFields(event *Event) ([]zapcore.Field, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	var payload map[string]interface{}
+	if err = json.Unmarshal(data, &payload); err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{})
+	for _, value := range payload {
+		if object, isObject := value.(map[string]interface{}); isObject {
+			flatten("", object, values)
+		}
+	}
+	if xICAPMetadata, exists := values["x_icap_metadata"]; exists {
+		delete(values, "x_icap_metadata")
+		values["X-ICAP-Metadata"] = xICAPMetadata
+	}
+	if event.Type == TypeBlocked {
+		values["verdict"] = "blocked"
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := make([]zapcore.Field, 0, len(names))
+	for _, name := range names {
+		fields = append(fields, zap.Any(name, values[name]))
+	}
+	return fields, nil
+}
+
+// flatten adds the values of the object to values with the prefix, the nested objects are flattened too
+func flatten(prefix string, object map[string]interface{}, values map[string]interface{}) {
+	for key, value := range object {
+		if nested, isObject := value.(map[string]interface{}); isObject {
+			flatten(prefix+key+"_", nested, values)
+			continue
+		}
+		values[prefix+key] = value
+	}
+}
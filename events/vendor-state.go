@@ -0,0 +1,77 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// the states of the vendors of the vendor_state_changed events
+const (
+	VendorStateDown = "down"
+	VendorStateUp   = "up"
+)
+
+// VendorStateEvent represents a vendor of a service which became unreachable or reachable again
+type VendorStateEvent struct {
+	XICAPMetadata string    `json:"x_icap_metadata,omitempty"` // the transaction which found the state
+	ServiceName   string    `json:"service"`
+	Tenant        string    `json:"tenant,omitempty"`
+	Vendor        string    `json:"vendor"`
+	State         string    `json:"state"`
+	Error         string    `json:"error,omitempty"`      // the error of the failed call of a vendor which is down
+	DownSince     time.Time `json:"down_since,omitempty"` // when the vendor went down, for both states
+}
+
+var (
+	vendorStatesMu sync.Mutex
+	// the services whose vendors are down, a service which isn't in the map is up
+	vendorStates = make(map[string]*VendorStateEvent)
+)
+
+// VendorFailed records a call of a service which couldn't reach its vendor, the vendor_state_changed event is
+// emitted when the vendor goes down only, not for every failed call of the outage
+func VendorFailed(event *VendorStateEvent) {
+	vendorStatesMu.Lock()
+	if _, down := vendorStates[event.ServiceName]; down {
+		vendorStatesMu.Unlock()
+		return
+	}
+	event.State = VendorStateDown
+	if event.DownSince.IsZero() {
+		event.DownSince = time.Now()
+	}
+	vendorStates[event.ServiceName] = event
+	vendorStatesMu.Unlock()
+	Emit(&Event{Type: TypeVendorStateChanged, Time: event.DownSince, VendorState: event})
+}
+
+// VendorReached records a call of a service which reached its vendor, the vendor_state_changed event is emitted
+// if the vendor was down
+func VendorReached(serviceName string) {
+	vendorStatesMu.Lock()
+	down, exists := vendorStates[serviceName]
+	if !exists {
+		vendorStatesMu.Unlock()
+		return
+	}
+	delete(vendorStates, serviceName)
+	vendorStatesMu.Unlock()
+	Emit(&Event{Type: TypeVendorStateChanged, VendorState: &VendorStateEvent{
+		ServiceName: serviceName,
+		Tenant:      down.Tenant,
+		Vendor:      down.Vendor,
+		State:       VendorStateUp,
+		DownSince:   down.DownSince,
+	}})
+}
+
+// DownVendors returns the vendor_state_changed events of the services whose vendors are down
+func DownVendors() map[string]VendorStateEvent {
+	vendorStatesMu.Lock()
+	defer vendorStatesMu.Unlock()
+	result := make(map[string]VendorStateEvent, len(vendorStates))
+	for serviceName, event := range vendorStates {
+		result[serviceName] = *event
+	}
+	return result
+}
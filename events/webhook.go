@@ -0,0 +1,95 @@
+package events
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"icapeg/readValues"
+	"icapeg/service/services-utilities/proxy"
+	"net/http"
+	"time"
+)
+
+// the headers of the webhook requests, the signature is the HMAC-SHA256 of the body with the secret
+const (
+	WebhookEventHeader     = "X-ICAPeg-Event"
+	WebhookSignatureHeader = "X-ICAPeg-Signature"
+	webhookSignaturePrefix = "sha256="
+)
+
+// the outbound proxy of the webhook calls, a [app.outbound_proxy.events] section gives them their own
+const eventsProxy = "events"
+
+// WebhookForwarder posts every event as JSON to a URL, the body is signed if the webhook has a secret so the
+// receiver can check that it was sent by ICAPeg
+type WebhookForwarder struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+// initWebhookForwarder reads [app.events.webhook] section
+func initWebhookForwarder(section string) (Forwarder, error) {
+	timeout := 10 * time.Second
+	if readValues.IsSecExists(section + ".timeout") {
+		timeout = readValues.ReadValuesDuration(section+".timeout") * time.Second
+	}
+	var secret string
+	if readValues.IsSecExists(section + ".secret") {
+		secret = readValues.ReadValuesString(section + ".secret")
+	}
+	return NewWebhookForwarder(readValues.ReadValuesString(section+".url"), secret,
+		&http.Client{Transport: proxy.Transport(eventsProxy), Timeout: timeout})
+}
+
+// NewWebhookForwarder creates the forwarder of the URL, the bodies aren't signed if the secret is empty
+func NewWebhookForwarder(url, secret string, client *http.Client) (*WebhookForwarder, error) {
+	if url == "" {
+		return nil, errors.New("url is required")
+	}
+	return &WebhookForwarder{url: url, secret: []byte(secret), client: client}, nil
+}
+
+// Forward posts the events one by one, the 4xx answers other than 408 and 429 are permanent errors
+func (f *WebhookForwarder) Forward(batch []*Event) error {
+	for _, event := range batch {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return &permanentError{err}
+		}
+		req, err := http.NewRequest(http.MethodPost, f.url, bytes.NewReader(body))
+		if err != nil {
+			return &permanentError{err}
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(WebhookEventHeader, event.Type)
+		if len(f.secret) != 0 {
+			req.Header.Set(WebhookSignatureHeader, Sign(f.secret, body))
+		}
+		resp, err := f.client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode/100 == 2:
+		case resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusRequestTimeout &&
+			resp.StatusCode != http.StatusTooManyRequests:
+			return &permanentError{errors.New("the webhook returned " + resp.Status)}
+		default:
+			return errors.New("the webhook returned " + resp.Status)
+		}
+	}
+	return nil
+}
+
+// Sign returns the value of the signature header of the body, sha256= and the hex HMAC-SHA256 of the body
+// with the secret
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return webhookSignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
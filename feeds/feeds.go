@@ -0,0 +1,212 @@
+package feeds
+
+import (
+	"errors"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// the types of the feeds, the type tells what is reloaded after the file of the feed is replaced
+const (
+	TypeFile     = "file"
+	TypeGeoIP    = "geoip"
+	TypeHashList = "hash_list"
+)
+
+// Config represents [app.feeds.<feed>] section configuration
+type Config struct {
+	Name         string
+	Type         string
+	URL          string
+	Path         string
+	Interval     time.Duration
+	ChecksumURL  string
+	SignatureURL string
+	PublicKey    string
+	List         string
+}
+
+// Status is the state of a feed which the admin API returns
+type Status struct {
+	Name            string     `json:"name"`
+	Type            string     `json:"type"`
+	URL             string     `json:"url"`
+	Path            string     `json:"path"`
+	IntervalSeconds int        `json:"interval_seconds"`
+	SHA256          string     `json:"sha256,omitempty"`
+	Updates         int        `json:"updates"`
+	LastCheck       *time.Time `json:"last_check,omitempty"`
+	LastUpdate      *time.Time `json:"last_update,omitempty"`
+	NextCheck       *time.Time `json:"next_check,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+}
+
+// Feed downloads a file on a schedule, verifies it and replaces the file which ICAPeg uses
+type Feed struct {
+	cfg      Config
+	client   *http.Client
+	updating sync.Mutex // one update of the feed at a time, the scheduled one or the one of the admin API
+
+	mu           sync.Mutex
+	etag         string
+	lastModified string
+	status       Status
+}
+
+// the reloaders load the replaced file of a feed, a reloader error keeps the previous file
+var reloaders = map[string]func(cfg Config, path string) error{
+	TypeFile:     func(Config, string) error { return nil },
+	TypeGeoIP:    reloadGeoIP,
+	TypeHashList: reloadHashList,
+}
+
+var (
+	feedsMu sync.RWMutex
+	feeds   = make(map[string]*Feed)
+)
+
+// RegisterReloader adds a feed type, reload is called with the path of the file after every update
+func RegisterReloader(feedType string, reload func(cfg Config, path string) error) {
+	feedsMu.Lock()
+	defer feedsMu.Unlock()
+	reloaders[feedType] = reload
+}
+
+// InitFeeds reads the optional [app.feeds] section and starts updating every feed on its schedule,
+// the files are never downloaded if it doesn't exist
+func InitFeeds() {
+	if !readValues.IsSecExists("app.feeds") || !readValues.ReadValuesBool("app.feeds.enabled") {
+		return
+	}
+	client := &http.Client{Timeout: readValues.ReadValuesDuration("app.feeds.timeout") * time.Second}
+	for _, name := range readValues.ReadSubSections("app.feeds") {
+		sec := "app.feeds." + name
+		cfg := Config{
+			Name:         name,
+			Type:         readValues.ReadValuesString(sec + ".type"),
+			URL:          readValues.ReadValuesString(sec + ".url"),
+			Path:         readValues.ReadValuesString(sec + ".path"),
+			Interval:     readValues.ReadValuesDuration(sec+".interval") * time.Second,
+			ChecksumURL:  readValues.ReadValuesString(sec + ".checksum_url"),
+			SignatureURL: readValues.ReadValuesString(sec + ".signature_url"),
+			PublicKey:    readValues.ReadValuesString(sec + ".public_key"),
+		}
+		if cfg.Type == TypeHashList {
+			cfg.List = readValues.ReadValuesString(sec + ".list")
+		}
+		feed, err := New(cfg, client)
+		if err != nil {
+			logging.Logger.Error("the " + name + " feed is disabled: " + err.Error())
+			continue
+		}
+		if err = feed.loadExisting(); err != nil {
+			logging.Logger.Error("couldn't load the file of the " + name + " feed: " + err.Error())
+		}
+		feedsMu.Lock()
+		feeds[name] = feed
+		feedsMu.Unlock()
+		go feed.run()
+	}
+}
+
+// New creates a feed from its configuration, the configuration is checked
+func New(cfg Config, client *http.Client) (*Feed, error) {
+	feedsMu.RLock()
+	_, known := reloaders[cfg.Type]
+	feedsMu.RUnlock()
+	if !known {
+		return nil, errors.New("unknown feed type " + cfg.Type)
+	}
+	if cfg.URL == "" || cfg.Path == "" {
+		return nil, errors.New("url and path are required")
+	}
+	if cfg.Interval <= 0 {
+		return nil, errors.New("interval must be positive")
+	}
+	if cfg.SignatureURL != "" {
+		if _, err := parsePublicKey(cfg.PublicKey); err != nil {
+			return nil, err
+		}
+	}
+	return &Feed{cfg: cfg, client: client, status: Status{Name: cfg.Name, Type: cfg.Type, URL: cfg.URL,
+		Path: cfg.Path, IntervalSeconds: int(cfg.Interval.Seconds())}}, nil
+}
+
+// run checks the feed at startup and then on its interval
+func (f *Feed) run() {
+	for {
+		f.Update()
+		next := time.Now().Add(f.cfg.Interval)
+		f.mu.Lock()
+		f.status.NextCheck = &next
+		f.mu.Unlock()
+		time.Sleep(f.cfg.Interval)
+	}
+}
+
+// Update downloads the feed if it changed, verifies it and replaces its file, it returns whether the file
+// was replaced
+func (f *Feed) Update() (bool, error) {
+	f.updating.Lock()
+	defer f.updating.Unlock()
+	updated, sum, err := f.update()
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status.LastCheck = &now
+	f.status.LastError = ""
+	if err != nil {
+		f.status.LastError = err.Error()
+		logging.Logger.Error("couldn't update the " + f.cfg.Name + " feed: " + err.Error())
+		return false, err
+	}
+	if updated {
+		f.status.LastUpdate = &now
+		f.status.SHA256 = sum
+		f.status.Updates++
+		logging.Logger.Info("the " + f.cfg.Name + " feed was updated, sha256 " + sum)
+	}
+	return updated, nil
+}
+
+// Status returns the state of the feed
+func (f *Feed) Status() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status
+}
+
+// Enabled reports whether any feed is updated
+func Enabled() bool {
+	feedsMu.RLock()
+	defer feedsMu.RUnlock()
+	return len(feeds) > 0
+}
+
+// Statuses returns the states of all feeds ordered by their names
+func Statuses() []Status {
+	feedsMu.RLock()
+	defer feedsMu.RUnlock()
+	result := make([]Status, 0, len(feeds))
+	for _, feed := range feeds {
+		result = append(result, feed.Status())
+	}
+	sort.Slice(result, func(a, b int) bool { return result[a].Name < result[b].Name })
+	return result
+}
+
+// Update updates the feed with the given name now
+func Update(name string) (Status, error) {
+	feedsMu.RLock()
+	feed, exists := feeds[name]
+	feedsMu.RUnlock()
+	if !exists {
+		return Status{}, errors.New("feed " + name + " doesn't exist")
+	}
+	_, err := feed.Update()
+	return feed.Status(), err
+}
@@ -0,0 +1,77 @@
+package feeds
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"icapeg/logging"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestUpdateVerifiesAndReplacesTheFile(t *testing.T) {
+	logging.Logger = zap.NewNop()
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	content := []byte("rule one")
+	checksum := sha256.Sum256(content)
+	signature := ed25519.Sign(privateKey, content)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rules":
+			w.Write(content)
+		case "/rules.sha256":
+			w.Write([]byte(hex.EncodeToString(checksum[:]) + "  rules\n"))
+		case "/rules.sig":
+			w.Write([]byte(base64.StdEncoding.EncodeToString(signature)))
+		}
+	}))
+	defer server.Close()
+
+	loaded := 0
+	RegisterReloader("test", func(cfg Config, path string) error {
+		if raw, _ := os.ReadFile(path); string(raw) == "broken" {
+			return errors.New("broken file")
+		}
+		loaded++
+		return nil
+	})
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	feed, err := New(Config{Name: "rules", Type: "test", URL: server.URL + "/rules", Path: path, Interval: time.Hour,
+		ChecksumURL: server.URL + "/rules.sha256", SignatureURL: server.URL + "/rules.sig",
+		PublicKey: base64.StdEncoding.EncodeToString(publicKey)}, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated, err := feed.Update(); err != nil || !updated || loaded != 1 {
+		t.Fatalf("the verified feed should be replaced and loaded, updated %v loaded %d err %v", updated, loaded, err)
+	}
+	if updated, _ := feed.Update(); updated || loaded != 1 {
+		t.Fatalf("an unchanged feed shouldn't be loaded again")
+	}
+
+	content, signature = []byte("rule two"), ed25519.Sign(privateKey, []byte("tampered"))
+	if _, err = feed.Update(); err == nil || feed.Status().LastError == "" {
+		t.Fatalf("a checksum mismatch should fail the update")
+	}
+	checksum = sha256.Sum256(content)
+	if _, err = feed.Update(); err == nil {
+		t.Fatalf("an invalid signature should fail the update")
+	}
+	content = []byte("broken")
+	checksum, signature = sha256.Sum256(content), ed25519.Sign(privateKey, content)
+	if _, err = feed.Update(); err == nil {
+		t.Fatalf("a file which can't be loaded should fail the update")
+	}
+	if raw, _ := os.ReadFile(path); string(raw) != "rule one" {
+		t.Fatalf("the previous file should be kept, got %q", raw)
+	}
+}
@@ -0,0 +1,251 @@
+package feeds
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"icapeg/cache"
+	"icapeg/service/services-utilities/geoip"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// the suffixes of the downloaded file before it's verified and of the replaced file until the new one is loaded
+const (
+	downloadSuffix = ".download"
+	previousSuffix = ".previous"
+)
+
+// update downloads the feed to a temporary file and replaces the file of the feed after checking its
+// checksum and its signature, the previous file is restored if the new one can't be loaded
+func (f *Feed) update() (bool, string, error) {
+	req, err := http.NewRequest(http.MethodGet, f.cfg.URL, nil)
+	if err != nil {
+		return false, "", err
+	}
+	f.mu.Lock()
+	if f.etag != "" {
+		req.Header.Set("If-None-Match", f.etag)
+	}
+	if f.lastModified != "" {
+		req.Header.Set("If-Modified-Since", f.lastModified)
+	}
+	current := f.status.SHA256
+	f.mu.Unlock()
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return false, "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, "", fmt.Errorf("the feed server returned %s", resp.Status)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(f.cfg.Path), os.ModePerm); err != nil {
+		return false, "", err
+	}
+	tmp := f.cfg.Path + downloadSuffix
+	defer os.Remove(tmp)
+	sum, err := download(resp.Body, tmp)
+	if err != nil {
+		return false, "", err
+	}
+	if sum != current {
+		if err = f.verify(tmp, sum); err != nil {
+			return false, "", err
+		}
+		if err = f.replace(tmp); err != nil {
+			return false, "", err
+		}
+	}
+	f.mu.Lock()
+	f.etag, f.lastModified = resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	f.mu.Unlock()
+	return sum != current, sum, nil
+}
+
+// loadExisting loads the file which the feed downloaded before the restart, so the feed is used even if
+// its server isn't reachable, the file isn't downloaded again if it didn't change
+func (f *Feed) loadExisting() error {
+	file, err := os.Open(f.cfg.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	feedsMu.RLock()
+	reload := reloaders[f.cfg.Type]
+	feedsMu.RUnlock()
+	if err = reload(f.cfg, f.cfg.Path); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.status.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	f.mu.Unlock()
+	return nil
+}
+
+// download writes the body to the file and returns its SHA-256
+func download(body io.Reader, path string) (string, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// verify checks the downloaded file against the published checksum and signature of the feed if it has them
+func (f *Feed) verify(path, sum string) error {
+	if f.cfg.ChecksumURL != "" {
+		published, err := f.fetch(f.cfg.ChecksumURL)
+		if err != nil {
+			return errors.New("couldn't download the checksum: " + err.Error())
+		}
+		// a sha256sum file has the checksum in its first field
+		fields := strings.Fields(string(published))
+		if len(fields) == 0 || !strings.EqualFold(fields[0], sum) {
+			return errors.New("the checksum of the downloaded file doesn't match the published checksum")
+		}
+	}
+	if f.cfg.SignatureURL != "" {
+		signature, err := f.fetch(f.cfg.SignatureURL)
+		if err != nil {
+			return errors.New("couldn't download the signature: " + err.Error())
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err = verifySignature(f.cfg.PublicKey, content, signature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Feed) fetch(url string) ([]byte, error) {
+	resp, err := f.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the feed server returned %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+}
+
+// verifySignature checks the Ed25519 signature of the content, the signature is raw or base64 encoded
+func verifySignature(publicKey string, content, signature []byte) error {
+	key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	if len(signature) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(signature)))
+		if err != nil {
+			return errors.New("malformed signature: " + err.Error())
+		}
+		signature = decoded
+	}
+	if !ed25519.Verify(key, content, signature) {
+		return errors.New("the signature of the downloaded file isn't valid")
+	}
+	return nil
+}
+
+// parsePublicKey decodes the base64 encoded Ed25519 public key of a feed
+func parsePublicKey(publicKey string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("public_key must be a base64 encoded Ed25519 public key")
+	}
+	return key, nil
+}
+
+// replace renames the downloaded file to the file of the feed and loads it, the previous file is kept until
+// the new one is loaded
+func (f *Feed) replace(tmp string) error {
+	previous := f.cfg.Path + previousSuffix
+	_, err := os.Stat(f.cfg.Path)
+	hadPrevious := err == nil
+	if hadPrevious {
+		if err = os.Rename(f.cfg.Path, previous); err != nil {
+			return err
+		}
+	}
+	if err = os.Rename(tmp, f.cfg.Path); err != nil {
+		if hadPrevious {
+			os.Rename(previous, f.cfg.Path)
+		}
+		return err
+	}
+	feedsMu.RLock()
+	reload := reloaders[f.cfg.Type]
+	feedsMu.RUnlock()
+	if err = reload(f.cfg, f.cfg.Path); err != nil {
+		if hadPrevious {
+			os.Rename(previous, f.cfg.Path)
+		} else {
+			os.Remove(f.cfg.Path)
+		}
+		return errors.New("couldn't load the downloaded file, the previous one is kept: " + err.Error())
+	}
+	if hadPrevious {
+		os.Remove(previous)
+	}
+	return nil
+}
+
+func reloadGeoIP(_ Config, path string) error {
+	return geoip.Reload(path)
+}
+
+// reloadHashList loads a file which has a file hash in the first field of every line into the hash list
+// of the feed, empty lines and the lines which start with # are skipped
+func reloadHashList(cfg Config, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var hashes []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hashes = append(hashes, strings.ToLower(strings.Fields(line)[0]))
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	return cache.SetFeedHashes(cfg.Name, cfg.List, hashes)
+}
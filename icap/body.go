@@ -0,0 +1,83 @@
+package icap
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Body is the body of an HTTP message which is rewritten in memory, the ICAP response writer sets the
+// Content-Length of the encapsulated HTTP message from its length
+type Body struct {
+	*bytes.Reader
+}
+
+// NewBody returns b as the body of an HTTP message
+func NewBody(b []byte) *Body {
+	return &Body{Reader: bytes.NewReader(b)}
+}
+
+// Close does nothing, the body is in memory
+func (b *Body) Close() error {
+	return nil
+}
+
+// fixupFraming makes the headers of the encapsulated HTTP message describe the body which is sent, so a
+// vendor which modified the body doesn't have to keep them consistent. The ICAP client frames the body again,
+// so the Transfer-Encoding is dropped, and the Content-Length is set if the length of the body is known, the
+// ICAP client sends the body chunked otherwise. The ETag and the Content-MD5 of the original body are dropped
+// if the body changed, and the Content-Encoding is dropped if the body isn't encoded anymore (ex: the vendor
+// returned the decompressed file). The 206 responses keep their headers as they are
+func fixupFraming(header http.Header, body io.ReadCloser) {
+	if header == nil {
+		return
+	}
+	if _, xIcap206Exists := header["X-Icap-206"]; xIcap206Exists {
+		return
+	}
+	header.Del("Transfer-Encoding")
+	b, known := body.(*Body)
+	if !known {
+		if sized, isSized := body.(interface{ Len() int }); isSized {
+			header.Set("Content-Length", strconv.Itoa(sized.Len()))
+		}
+		return
+	}
+
+	// the unread part of the body is read in place, it isn't copied for every response
+	content := io.NewSectionReader(b, b.Size()-int64(b.Len()), int64(b.Len()))
+	length := strconv.FormatInt(content.Size(), 10)
+	if header.Get("Content-Length") != length || !matchesContentMD5(header, content) {
+		header.Del("ETag")
+		header.Del("Content-MD5")
+	}
+	header.Set("Content-Length", length)
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		var magic [2]byte
+		if n, _ := content.ReadAt(magic[:], 0); !bytes.Equal(magic[:n], gzipMagic) {
+			header.Del("Content-Encoding")
+		}
+	}
+}
+
+// gzipMagic is the first bytes of every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// matchesContentMD5 reports whether the Content-MD5 of the header is the digest of the content, a header
+// without Content-MD5 matches every content
+func matchesContentMD5(header http.Header, content *io.SectionReader) bool {
+	contentMD5 := header.Get("Content-MD5")
+	if contentMD5 == "" {
+		return true
+	}
+	digest := md5.New()
+	if _, err := io.Copy(digest, content); err != nil {
+		return false
+	}
+	return contentMD5 == base64.StdEncoding.EncodeToString(digest.Sum(nil))
+}
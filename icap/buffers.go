@@ -0,0 +1,97 @@
+// The buffers of the connections, they're reused by the next connections instead of being allocated for each.
+
+package icap
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// the sizes of the buffers until SetBufferSize is called: the read and write buffers of the connections and
+// the buffers which the bodies are copied with
+const (
+	defaultConnBufferSize = 4 << 10
+	defaultCopyBufferSize = 32 << 10
+)
+
+var (
+	connBufferSize = defaultConnBufferSize
+	copyBufferSize = defaultCopyBufferSize
+	readerPool     sync.Pool
+	writerPool     sync.Pool
+	copyBufPool    sync.Pool
+)
+
+// SetBufferSize sets the size of the read and write buffers of the connections and of the buffers which the
+// bodies are copied with, it's called before the server is started. A size below 4KB is 4KB, 0 keeps the defaults.
+func SetBufferSize(size int) {
+	connBufferSize, copyBufferSize = defaultConnBufferSize, defaultCopyBufferSize
+	if size > 0 {
+		if size < defaultConnBufferSize {
+			size = defaultConnBufferSize
+		}
+		connBufferSize, copyBufferSize = size, size
+	}
+	// the buffers of the previous size aren't reused
+	readerPool, writerPool, copyBufPool = sync.Pool{}, sync.Pool{}, sync.Pool{}
+}
+
+// newBufReader returns a reader of r with a buffer of the pool.
+func newBufReader(r io.Reader) *bufio.Reader {
+	if br, ok := readerPool.Get().(*bufio.Reader); ok {
+		br.Reset(r)
+		return br
+	}
+	return bufio.NewReaderSize(r, connBufferSize)
+}
+
+// putBufReader returns the reader to the pool, it mustn't be used after.
+func putBufReader(br *bufio.Reader) {
+	if br.Size() != connBufferSize {
+		return
+	}
+	br.Reset(nil)
+	readerPool.Put(br)
+}
+
+// newBufWriter returns a writer to w with a buffer of the pool.
+func newBufWriter(w io.Writer) *bufio.Writer {
+	if bw, ok := writerPool.Get().(*bufio.Writer); ok {
+		bw.Reset(w)
+		return bw
+	}
+	return bufio.NewWriterSize(w, connBufferSize)
+}
+
+// putBufWriter returns the writer to the pool, it mustn't be used after.
+func putBufWriter(bw *bufio.Writer) {
+	if bw.Size() != connBufferSize {
+		return
+	}
+	bw.Reset(nil)
+	writerPool.Put(bw)
+}
+
+// getCopyBuf returns a buffer of the pool to copy a body with, it's returned with putCopyBuf.
+func getCopyBuf() *[]byte {
+	if bufp, ok := copyBufPool.Get().(*[]byte); ok {
+		return bufp
+	}
+	buf := make([]byte, copyBufferSize)
+	return &buf
+}
+
+func putCopyBuf(bufp *[]byte) {
+	if len(*bufp) == copyBufferSize {
+		copyBufPool.Put(bufp)
+	}
+}
+
+// CopyBuffer copies src to dst like io.Copy with a buffer of the pool, so the copies of the bodies don't
+// allocate a buffer each.
+func CopyBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	bufp := getCopyBuf()
+	defer putCopyBuf(bufp)
+	return io.CopyBuffer(dst, src, *bufp)
+}
@@ -9,13 +9,23 @@ package icap
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"strconv"
 )
 
 const maxLineLength = 4096 // assumed <= bufio.defaultBufSize
 
+// the chunks of the ICAP clients are far smaller, a larger chunk length is a malformed or a malicious request
+const (
+	maxChunkSize       = 1 << 30
+	maxChunkSizeDigits = 16
+)
+
 var errLineTooLong = errors.New("header line too long")
 
 // NewChunkedReader returns a new chunkedReader that translates the data read from r
@@ -51,7 +61,23 @@ func (cr *chunkedReader) beginChunk() {
 		return
 	}
 	if cr.n == 0 {
-		cr.err = io.EOF
+		cr.readTrailer()
+	}
+}
+
+// readTrailer reads the trailer of the last chunk up to and including the empty line which ends it, so the
+// reader of the rest of a preview or of the next request starts after the body
+func (cr *chunkedReader) readTrailer() {
+	for count := 0; cr.err == nil; count++ {
+		var line []byte
+		if line, cr.err = readLine(cr.r); cr.err != nil {
+			return
+		}
+		if len(line) == 0 {
+			cr.err = io.EOF
+		} else if count >= headerLimits.MaxHTTPHeaderCount {
+			cr.err = ErrTooManyHeaders
+		}
 	}
 }
 
@@ -70,6 +96,10 @@ func (cr *chunkedReader) Read(b []uint8) (n int, err error) {
 	}
 	n, cr.err = cr.r.Read(b)
 	cr.n -= uint64(n)
+	if cr.err == io.EOF && cr.n > 0 {
+		// the connection ended in the middle of a chunk
+		cr.err = io.ErrUnexpectedEOF
+	}
 	if cr.n == 0 && cr.err == nil {
 		// end of chunk (CRLF)
 		if _, cr.err = io.ReadFull(cr.r, cr.buf[:]); cr.err == nil {
@@ -99,6 +129,9 @@ func readLine(b *bufio.Reader) (p []byte, err error) {
 	if len(p) >= maxLineLength {
 		return nil, errLineTooLong
 	}
+	if strictMode && !bytes.HasSuffix(p, []byte("\r\n")) {
+		return nil, violation(http.StatusBadRequest, "the chunk-size line must end with CRLF")
+	}
 	return trimTrailingWhitespace(p), nil
 }
 
@@ -123,18 +156,32 @@ func isASCIISpace(b byte) bool {
 // would result in double chunking or chunking with a Content-Length
 // length, both of which are wrong.
 func NewChunkedWriter(w io.Writer) io.WriteCloser {
-	return &chunkedWriter{w}
+	return &chunkedWriter{Wire: w}
+}
+
+// newConnChunkedWriter returns the chunkedWriter of the bodies of the responses of a
+// connection, the small chunks are buffered by bw and the large ones are written with
+// their framing to conn in one vectored write, instead of being copied to bw.
+func newConnChunkedWriter(bw *bufio.Writer, conn *deadlineConn) io.WriteCloser {
+	return &chunkedWriter{Wire: bw, bw: bw, conn: conn}
 }
 
 // Writing to chunkedWriter translates to writing in HTTP chunked Transfer
 // Encoding wire format to the underlying Wire chunkedWriter.
 type chunkedWriter struct {
 	Wire io.Writer
+
+	bw   *bufio.Writer // the buffer of Wire, nil if the chunks are written to Wire only
+	conn *deadlineConn // the connection under bw
+
+	head [maxChunkSizeDigits + 2]byte // the size line of the chunk
+	vecs [3][]byte                    // the size line, the data and the CRLF of a vectored write
+	bufs net.Buffers
 }
 
+var crlf = []byte("\r\n")
+
 // Write the contents of data as one chunk to Wire.
-// NOTE: Note that the corresponding chunk-writing procedure in Conn.Write has
-// a bug since it does not check for success of io.WriteString
 func (cw *chunkedWriter) Write(data []byte) (n int, err error) {
 
 	// Don't send 0-length data. It looks like EOF for chunked encoding.
@@ -142,7 +189,12 @@ func (cw *chunkedWriter) Write(data []byte) (n int, err error) {
 		return 0, nil
 	}
 
-	if _, err = fmt.Fprintf(cw.Wire, "%x\r\n", len(data)); err != nil {
+	head := strconv.AppendUint(cw.head[:0], uint64(len(data)), 16)
+	head = append(head, '\r', '\n')
+	if cw.conn != nil && len(data) >= cw.bw.Size() {
+		return cw.writeVectored(head, data)
+	}
+	if _, err = cw.Wire.Write(head); err != nil {
 		return 0, err
 	}
 	if n, err = cw.Wire.Write(data); err != nil {
@@ -152,17 +204,45 @@ func (cw *chunkedWriter) Write(data []byte) (n int, err error) {
 		err = io.ErrShortWrite
 		return
 	}
-	_, err = io.WriteString(cw.Wire, "\r\n")
+	_, err = cw.Wire.Write(crlf)
 
 	return
 }
 
+// writeVectored flushes what's buffered, then writes the chunk to the connection.
+func (cw *chunkedWriter) writeVectored(head, data []byte) (int, error) {
+	if err := cw.bw.Flush(); err != nil {
+		return 0, err
+	}
+	cw.vecs = [3][]byte{head, data, crlf}
+	cw.bufs = cw.vecs[:]
+	written, err := cw.conn.writeBuffers(&cw.bufs)
+	// the data isn't kept after the write
+	cw.vecs = [3][]byte{}
+	n := int(written) - len(head)
+	if n < 0 {
+		n = 0
+	} else if n > len(data) {
+		n = len(data)
+	}
+	if err == nil && n != len(data) {
+		err = io.ErrShortWrite
+	}
+	return n, err
+}
+
 func (cw *chunkedWriter) Close() error {
 	_, err := io.WriteString(cw.Wire, "0\r\n")
 	return err
 }
 
 func parseHexUint(v []byte) (n uint64, err error) {
+	if len(v) == 0 {
+		return 0, errors.New("empty chunk length")
+	}
+	if len(v) > maxChunkSizeDigits {
+		return 0, fmt.Errorf("invalid chunk length: '%s'", v)
+	}
 	for _, b := range v {
 		n <<= 4
 		switch {
@@ -177,5 +257,8 @@ func parseHexUint(v []byte) (n uint64, err error) {
 		}
 		n |= uint64(b)
 	}
+	if n > maxChunkSize {
+		return 0, fmt.Errorf("chunk length %d is too large", n)
+	}
 	return
 }
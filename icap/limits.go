@@ -0,0 +1,92 @@
+// Limits on the headers of the ICAP requests.
+
+package icap
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+)
+
+// HeaderLimits caps the ICAP headers and the encapsulated HTTP headers of a request, so a misbehaving
+// or a malicious client can't make the server hold unbounded headers in memory. A zero field keeps its default.
+type HeaderLimits struct {
+	MaxICAPHeaderBytes int // the bytes of the ICAP headers, the request line isn't counted
+	MaxICAPHeaderCount int
+	MaxHTTPHeaderBytes int // the bytes of each encapsulated HTTP header, the start line is counted
+	MaxHTTPHeaderCount int
+}
+
+// DefaultHeaderLimits are the limits until SetHeaderLimits is called.
+var DefaultHeaderLimits = HeaderLimits{
+	MaxICAPHeaderBytes: 64 << 10,
+	MaxICAPHeaderCount: 100,
+	MaxHTTPHeaderBytes: 1 << 20,
+	MaxHTTPHeaderCount: 1000,
+}
+
+// ErrHeaderTooLarge and ErrTooManyHeaders are returned by ReadRequest for the requests which exceed the limits,
+// they are answered with 400.
+var (
+	ErrHeaderTooLarge = errors.New("icap: header too large")
+	ErrTooManyHeaders = errors.New("icap: too many headers")
+)
+
+var headerLimits = DefaultHeaderLimits
+
+// SetHeaderLimits sets the header limits of the requests which are read after it.
+func SetHeaderLimits(l HeaderLimits) {
+	if l.MaxICAPHeaderBytes <= 0 {
+		l.MaxICAPHeaderBytes = DefaultHeaderLimits.MaxICAPHeaderBytes
+	}
+	if l.MaxICAPHeaderCount <= 0 {
+		l.MaxICAPHeaderCount = DefaultHeaderLimits.MaxICAPHeaderCount
+	}
+	if l.MaxHTTPHeaderBytes <= 0 {
+		l.MaxHTTPHeaderBytes = DefaultHeaderLimits.MaxHTTPHeaderBytes
+	}
+	if l.MaxHTTPHeaderCount <= 0 {
+		l.MaxHTTPHeaderCount = DefaultHeaderLimits.MaxHTTPHeaderCount
+	}
+	headerLimits = l
+}
+
+// readHeaderBlock reads the header lines up to and including the empty line which ends them,
+// it stops as soon as the block exceeds maxBytes or maxCount lines.
+func readHeaderBlock(r *bufio.Reader, maxBytes, maxCount int) ([]byte, error) {
+	var block []byte
+	count := 0
+	partial := false
+	for {
+		line, err := r.ReadSlice('\n')
+		if len(block)+len(line) > maxBytes {
+			return nil, ErrHeaderTooLarge
+		}
+		block = append(block, line...)
+		if err == bufio.ErrBufferFull {
+			// a line longer than the buffer, the rest of it is read by the next ReadSlice
+			partial = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !partial && len(bytes.TrimRight(line, "\r\n")) == 0 {
+			return block, nil
+		}
+		partial = false
+		if count++; count > maxCount {
+			return nil, ErrTooManyHeaders
+		}
+	}
+}
+
+// checkHTTPHeader checks the number of the header lines of an encapsulated HTTP header, the bytes
+// are checked against the Encapsulated offsets before the header is read.
+func checkHTTPHeader(raw []byte) error {
+	// the start line and the empty line which ends the header aren't header lines
+	if bytes.Count(raw, []byte("\n"))-2 > headerLimits.MaxHTTPHeaderCount {
+		return ErrTooManyHeaders
+	}
+	return nil
+}
@@ -42,10 +42,23 @@ type Request struct {
 	// The HTTP messages.
 	Request  *http.Request
 	Response *http.Response
+
+	body io.Reader         // the body which is read from the connection, nil in the previews
+	rw   *bufio.ReadWriter // the connection, the rest of a preview is read from it after "100 Continue"
+	srv  *Server           // the server which read the request, its workers process it
+}
+
+// AcquireWorker waits for a free worker of the server which read the request if the server bounds the requests
+// by MaxConcurrency, it returns the func which releases the worker. The handlers call it once the body is read,
+// so the clients which are slow to send their bodies don't hold the workers. It returns false if no worker was
+// free within the WorkerTimeout of the server or the server was shut down, the request should get 503 then
+func (r *Request) AcquireWorker() (release func(), acquired bool) {
+	return r.srv.acquireWorker()
 }
 
-var origBuf *bufio.ReadWriter
-var origReader io.Reader
+// maxDiscardedBody is the max of the body left unread by the handler which is discarded to keep the
+// connection alive, the connection is closed instead if the rest of the body is longer
+const maxDiscardedBody = 1 << 20
 
 // ReadRequest reads and parses a request from b.
 func ReadRequest(b *bufio.ReadWriter) (req *Request, err error) {
@@ -55,10 +68,12 @@ func ReadRequest(b *bufio.ReadWriter) (req *Request, err error) {
 	// Read first line.
 	var s string
 	s, err = tp.ReadLine()
+	// the CRLF which ends the body of the previous request on the connection may be left unread
+	for blank := 0; err == nil && s == "" && blank < 2; blank++ {
+		s, err = tp.ReadLine()
+	}
 	if err != nil {
-		if err == io.EOF {
-			err = io.ErrUnexpectedEOF
-		}
+		// io.EOF means the ICAP client closed the connection between two requests
 		return nil, err
 	}
 
@@ -73,10 +88,19 @@ func ReadRequest(b *bufio.ReadWriter) (req *Request, err error) {
 		return nil, err
 	}
 
-	req.Header, err = tp.ReadMIMEHeader()
+	rawHeader, err := readHeaderBlock(b.Reader, headerLimits.MaxICAPHeaderBytes, headerLimits.MaxICAPHeaderCount)
 	if err != nil {
 		return nil, err
 	}
+	req.Header, err = textproto.NewReader(bufio.NewReader(bytes.NewReader(rawHeader))).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	if strictMode {
+		if err = validateStrict(req); err != nil {
+			return nil, err
+		}
+	}
 
 	s = req.Header.Get("Encapsulated")
 	if s == "" {
@@ -94,9 +118,12 @@ func ReadRequest(b *bufio.ReadWriter) (req *Request, err error) {
 		}
 		key := item[:eq]
 		value, err := strconv.Atoi(item[eq+1:])
-		if err != nil {
+		if err != nil || value < prevValue {
 			return nil, &badStringError{"malformed Encapsulated: header", s}
 		}
+		if value-prevValue > headerLimits.MaxHTTPHeaderBytes {
+			return nil, ErrHeaderTooLarge
+		}
 
 		// Calculate the length of the previous section.
 		switch prevKey {
@@ -137,6 +164,9 @@ func ReadRequest(b *bufio.ReadWriter) (req *Request, err error) {
 		if err != nil {
 			return nil, err
 		}
+		if err = checkHTTPHeader(rawReqHdr); err != nil {
+			return nil, err
+		}
 	}
 	if respHdrLen > 0 {
 		rawRespHdr = make([]byte, respHdrLen)
@@ -144,15 +174,17 @@ func ReadRequest(b *bufio.ReadWriter) (req *Request, err error) {
 		if err != nil {
 			return nil, err
 		}
+		if err = checkHTTPHeader(rawRespHdr); err != nil {
+			return nil, err
+		}
 	}
 
 	var bodyReader io.ReadCloser = emptyReader(0)
 	if hasBody {
 		if p := req.Header.Get("Preview"); p != "" {
 
-			req.Preview, err = ioutil.ReadAll(newChunkedReader(b))
-			origBuf = b
-			origReader = bytes.NewBuffer(req.Preview)
+			req.Preview, err = ioutil.ReadAll(newChunkedReader(b.Reader))
+			req.rw = b
 			req.EndIndicator = "0"
 			if err != nil {
 				if strings.Contains(err.Error(), "ieof") {
@@ -163,10 +195,15 @@ func ReadRequest(b *bufio.ReadWriter) (req *Request, err error) {
 					return nil, err
 				}
 			}
+			if n, _ := strconv.Atoi(p); strictMode && len(req.Preview) > n {
+				return nil, violation(http.StatusBadRequest, "the preview has %d bytes, more than Preview: %s",
+					len(req.Preview), p)
+			}
 			var r io.Reader = bytes.NewBuffer(req.Preview)
 			bodyReader = ioutil.NopCloser(r)
 		} else {
-			bodyReader = ioutil.NopCloser(newChunkedReader(b))
+			req.body = newChunkedReader(b.Reader)
+			bodyReader = ioutil.NopCloser(req.body)
 		}
 	}
 
@@ -237,26 +274,45 @@ func (emptyReader) Close() error {
 // A continueReader sends a "100 Continue" message the first time Read
 // is called, creates a ChunkedReader, and reads from that.
 type continueReader struct {
-	buf *bufio.ReadWriter // the underlying connection
-	cr  io.Reader         // the ChunkedReader
+	req *Request  // the request of the preview
+	cr  io.Reader // the ChunkedReader
 }
 
 func (c *continueReader) Read(p []byte) (n int, err error) {
 	if c.cr == nil {
-		_, err := c.buf.WriteString("ICAP/1.0 100 Continue\r\n\r\n")
+		buf := c.req.rw
+		_, err := buf.WriteString("ICAP/1.0 100 Continue\r\n\r\n")
 		if err != nil {
 			return 0, err
 		}
-		err = c.buf.Flush()
+		err = buf.Flush()
 		if err != nil {
 			return 0, err
 		}
-		c.cr = newChunkedReader(c.buf)
+		c.cr = newChunkedReader(buf.Reader)
+		// the rest which the handler leaves unread is discarded like a body without preview
+		c.req.body = c.cr
 	}
 
 	return c.cr.Read(p)
 }
 
-func GetTheRest() io.Reader {
-	return io.MultiReader(origReader, &continueReader{buf: origBuf})
+// Rest returns the whole body of a request with a preview, the preview is followed by the rest of the body
+// which the ICAP client sends after the "100 Continue" written at the first read past the preview. The preview
+// is the whole body if it ended with "0; ieof", the ICAP client doesn't wait for "100 Continue" then
+func (req *Request) Rest() io.Reader {
+	if req.rw == nil || req.EndIndicator == "0; ieof" {
+		return bytes.NewReader(req.Preview)
+	}
+	return io.MultiReader(bytes.NewReader(req.Preview), &continueReader{req: req})
+}
+
+// discardBody reads the rest of the body which the handler answered without reading (ex: an early 204),
+// it returns false if the connection can't be reused for the next request
+func (req *Request) discardBody() bool {
+	if req.body == nil {
+		return true
+	}
+	_, err := io.CopyN(io.Discard, req.body, maxDiscardedBody+1)
+	return err == io.EOF
 }
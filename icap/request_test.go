@@ -0,0 +1,157 @@
+package icap
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const respmodHead = "RESPMOD icap://icap.example.net/echo ICAP/1.0\r\n" +
+	"Host: icap.example.net\r\n"
+
+const httpRespHdr = "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\n"
+
+func readTestRequest(wire string) (*Request, error) {
+	return ReadRequest(bufio.NewReadWriter(bufio.NewReader(strings.NewReader(wire)), bufio.NewWriter(io.Discard)))
+}
+
+// readTestBody reads the request and its body like the ICAP handler does
+func readTestBody(wire string) error {
+	req, err := readTestRequest(wire)
+	if err != nil {
+		return err
+	}
+	if req.Response != nil && req.Response.Body != nil {
+		_, err = io.ReadAll(req.Response.Body)
+	}
+	return err
+}
+
+func TestReadRequestRejectsMalformedRequests(t *testing.T) {
+	tests := map[string]string{
+		"decreasing offsets": respmodHead + "Encapsulated: res-hdr=40, res-body=10\r\n\r\n" + httpRespHdr,
+		"negative offset":    respmodHead + "Encapsulated: res-hdr=0, res-body=-5\r\n\r\n" + httpRespHdr,
+		"huge offset":        respmodHead + "Encapsulated: res-hdr=0, res-body=99999999999\r\n\r\n" + httpRespHdr,
+		"truncated headers":  respmodHead + "Encapsulated: res-hdr=0, res-body=4096\r\n\r\n" + httpRespHdr,
+		"oversized chunk": respmodHead + "Encapsulated: res-hdr=0, res-body=" + strconv.Itoa(len(httpRespHdr)) + "\r\n\r\n" +
+			httpRespHdr + "fffffffffffffffffff\r\nabc\r\n0\r\n\r\n",
+		"missing CRLF": respmodHead + "Encapsulated: res-hdr=0, res-body=" + strconv.Itoa(len(httpRespHdr)) + "\r\n\r\n" +
+			httpRespHdr + "3\r\nabcdef\r\n0\r\n\r\n",
+		"truncated body": respmodHead + "Encapsulated: res-hdr=0, res-body=" + strconv.Itoa(len(httpRespHdr)) + "\r\n\r\n" +
+			httpRespHdr + "10\r\nabc",
+		"truncated preview": respmodHead + "Preview: 10\r\nEncapsulated: res-hdr=0, res-body=" + strconv.Itoa(len(httpRespHdr)) +
+			"\r\n\r\n" + httpRespHdr + "a\r\n0123",
+	}
+	for name, wire := range tests {
+		if err := readTestBody(wire); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestReadRequestSkipsTheCRLFOfThePreviousRequest(t *testing.T) {
+	req, err := readTestRequest("\r\n" + respmodHead + "Encapsulated: null-body=0\r\n\r\n")
+	if err != nil || req.Method != "RESPMOD" {
+		t.Fatalf("expected a RESPMOD request, got %v", err)
+	}
+	if _, err = readTestRequest(""); err != io.EOF {
+		t.Fatalf("expected io.EOF on a closed connection, got %v", err)
+	}
+}
+
+func FuzzReadRequest(f *testing.F) {
+	f.Add(respmodHead + "Encapsulated: res-hdr=0, res-body=" + strconv.Itoa(len(httpRespHdr)) + "\r\n\r\n" +
+		httpRespHdr + "3\r\nabc\r\n0\r\n\r\n")
+	f.Add(respmodHead + "Preview: 3\r\nEncapsulated: res-hdr=0, res-body=" + strconv.Itoa(len(httpRespHdr)) + "\r\n\r\n" +
+		httpRespHdr + "3\r\nabc\r\n0; ieof\r\n\r\n")
+	f.Fuzz(func(t *testing.T, wire string) {
+		readTestBody(wire)
+	})
+}
+
+func TestReadRequestHeaderLimits(t *testing.T) {
+	SetHeaderLimits(HeaderLimits{MaxICAPHeaderBytes: 128, MaxICAPHeaderCount: 3, MaxHTTPHeaderCount: 2})
+	defer SetHeaderLimits(DefaultHeaderLimits)
+	manyHeaders := "HTTP/1.1 200 OK\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n"
+	body := "Encapsulated: res-hdr=0, null-body=" + strconv.Itoa(len(httpRespHdr)) + "\r\n\r\n" + httpRespHdr
+	if _, err := readTestRequest(respmodHead + body); err != nil {
+		t.Fatalf("expected the request within the limits to be read, got %v", err)
+	}
+	tests := map[string]struct {
+		wire string
+		err  error
+	}{
+		"ICAP header bytes": {respmodHead + "X-Long: " + strings.Repeat("a", 128) + "\r\n" + body, ErrHeaderTooLarge},
+		"ICAP header count": {respmodHead + "A: 1\r\nB: 2\r\nC: 3\r\n" + body, ErrTooManyHeaders},
+		"HTTP header count": {respmodHead + "Encapsulated: res-hdr=0, null-body=" + strconv.Itoa(len(manyHeaders)) +
+			"\r\n\r\n" + manyHeaders, ErrTooManyHeaders},
+	}
+	for name, test := range tests {
+		if _, err := readTestRequest(test.wire); err != test.err {
+			t.Errorf("%s: expected %v, got %v", name, test.err, err)
+		}
+	}
+}
+
+func TestReadRequestStrictMode(t *testing.T) {
+	SetStrictMode(true)
+	defer SetStrictMode(false)
+	body := "Encapsulated: res-hdr=0, res-body=" + strconv.Itoa(len(httpRespHdr)) + "\r\n\r\n" + httpRespHdr
+	if err := readTestBody(respmodHead + body + "3\r\nabc\r\n0\r\n\r\n"); err != nil {
+		t.Fatalf("a conforming request should be accepted, got %v", err)
+	}
+	tests := map[string]struct {
+		wire string
+		code int
+	}{
+		"unknown method":       {"PUT icap://icap.example.net/echo ICAP/1.0\r\nHost: x\r\n\r\n", 501},
+		"version":              {"OPTIONS icap://icap.example.net/echo ICAP/2.0\r\nHost: x\r\n\r\n", 505},
+		"missing host":         {"OPTIONS icap://icap.example.net/echo ICAP/1.0\r\n\r\n", 400},
+		"missing encapsulated": {respmodHead + "\r\n", 400},
+		"sections order":       {respmodHead + "Encapsulated: res-hdr=0, req-hdr=10, null-body=20\r\n\r\n", 400},
+		"no body section":      {respmodHead + "Encapsulated: res-hdr=0\r\n\r\n", 400},
+		"wrong body":           {respmodHead + "Encapsulated: res-hdr=0, req-body=10\r\n\r\n", 400},
+		"long preview":         {respmodHead + "Preview: 2\r\n" + body + "3\r\nabc\r\n0\r\n\r\n", 400},
+		"bare LF chunk":        {respmodHead + body + "3\nabc\r\n0\r\n\r\n", 400},
+	}
+	for name, test := range tests {
+		err := readTestBody(test.wire)
+		violation, isViolation := err.(*ViolationError)
+		if !isViolation || violation.StatusCode != test.code {
+			t.Errorf("%s: expected a %d violation, got %v", name, test.code, err)
+		}
+	}
+}
+
+func TestReadRequestRestOfPreview(t *testing.T) {
+	wire := respmodHead + "Preview: 4\r\nEncapsulated: res-hdr=0, res-body=" + strconv.Itoa(len(httpRespHdr)) +
+		"\r\n\r\n" + httpRespHdr + "4\r\nabcd\r\n0\r\n\r\n" + "3\r\nefg\r\n0\r\n\r\n"
+	var sent strings.Builder
+	req, err := ReadRequest(bufio.NewReadWriter(bufio.NewReader(strings.NewReader(wire)), bufio.NewWriter(&sent)))
+	if err != nil || string(req.Preview) != "abcd" {
+		t.Fatalf("unexpected preview %q, err %v", req.Preview, err)
+	}
+	body, err := io.ReadAll(req.Rest())
+	if err != nil || string(body) != "abcdefg" {
+		t.Fatalf("the rest of the body should follow the preview, got %q, err %v", body, err)
+	}
+	if sent.String() != "ICAP/1.0 100 Continue\r\n\r\n" {
+		t.Fatalf("100 Continue should be sent before the rest, got %q", sent.String())
+	}
+}
+
+func TestReadRequestPreviewIeof(t *testing.T) {
+	wire := respmodHead + "Preview: 4\r\nEncapsulated: res-hdr=0, res-body=" + strconv.Itoa(len(httpRespHdr)) +
+		"\r\n\r\n" + httpRespHdr + "3\r\nabc\r\n0; ieof\r\n\r\n"
+	var sent strings.Builder
+	req, err := ReadRequest(bufio.NewReadWriter(bufio.NewReader(strings.NewReader(wire)), bufio.NewWriter(&sent)))
+	if err != nil || req.EndIndicator != "0; ieof" {
+		t.Fatalf("unexpected end indicator %q, err %v", req.EndIndicator, err)
+	}
+	body, err := io.ReadAll(req.Rest())
+	if err != nil || string(body) != "abc" || sent.Len() != 0 {
+		t.Fatalf("the preview which ended with ieof is the whole body, got %q, sent %q", body, sent.String())
+	}
+}
@@ -7,17 +7,16 @@
 package icap
 
 import (
-	"bytes"
 	"errors"
-	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"net/http/httputil"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"golang.org/x/net/http/httpguts"
 )
 
 // ResponseWriter ---
@@ -40,15 +39,29 @@ type ResponseWriter interface {
 	// httpMessage may be an *http.Request or an *http.Response.
 	// hasBody should be true if there will be calls to Write(), generating a message body.
 	WriteHeader(code int, httpMessage interface{}, hasBody bool)
+
+	// Flush sends the buffered data to the ICAP client.
+	Flush() error
+
+	// Abort closes the connection without finishing the ICAP response, so the ICAP client
+	// treats the HTTP message which was being sent as failed.
+	Abort()
 }
 
+// UseOriginalBodyHeader is set in the ICAP header of a 206 Partial Content response to the offset of the
+// original body which the ICAP client appends to the body of the response. It isn't sent, the offset is
+// sent in the use-original-body extension of the last chunk
+const UseOriginalBodyHeader = "Use-Original-Body"
+
 type respWriter struct {
-	conn        *conn          // information on the connection
-	req         *Request       // the request that is being responded to
-	header      http.Header    // the ICAP header to write for the response
-	wroteHeader bool           // true if the headers have already been written
-	wroteRaw    bool           // true if raw data was written to the connection
-	cw          io.WriteCloser // the chunked writer used to write the body
+	conn            *conn          // information on the connection
+	req             *Request       // the request that is being responded to
+	header          http.Header    // the ICAP header to write for the response
+	wroteHeader     bool           // true if the headers have already been written
+	wroteRaw        bool           // true if raw data was written to the connection
+	cw              io.WriteCloser // the chunked writer used to write the body
+	aborted         bool           // true if the connection was closed before finishing the response
+	useOriginalBody string         // the offset of the original body after the body of a 206 response
 }
 
 func (w *respWriter) Header() http.Header {
@@ -66,6 +79,15 @@ func (w *respWriter) Write(p []byte) (n int, err error) {
 	return w.cw.Write(p)
 }
 
+func (w *respWriter) Flush() error {
+	return w.conn.buf.Flush()
+}
+
+func (w *respWriter) Abort() {
+	w.aborted = true
+	w.conn.rwc.Close()
+}
+
 func (w *respWriter) WriteRaw(p string) {
 	bw := w.conn.buf.Writer
 	io.WriteString(bw, p)
@@ -78,92 +100,140 @@ func (w *respWriter) WriteHeader(code int, httpMessage interface{}, hasBody bool
 		return
 	}
 
-	// Make the HTTP header and the Encapsulated: header.
-	var header []byte
-	var encap string
-	var err error
+	// Make the HTTP header and the Encapsulated: header, they're appended to the buffers of the
+	// connection which are reused by its next responses.
+	c := w.conn
+	header := c.httpHeaderBuf[:0]
+	var encap []byte
+
+	// the HTTP message of a 206 response describes the whole body, its body is the part before the original one
+	partialContent := false
+	if code == http.StatusPartialContent {
+		if offset := w.header.Get(UseOriginalBodyHeader); offset != "" && hasBody {
+			w.useOriginalBody, partialContent = offset, true
+		}
+		w.header.Del(UseOriginalBodyHeader)
+	}
 
 	switch msg := httpMessage.(type) {
 	case *http.Request:
-		header, err = httpRequestHeader(msg)
-		if err != nil {
-			break
+		if hasBody && !partialContent {
+			fixupFraming(msg.Header, msg.Body)
 		}
+		header = httpRequestHeader(header, msg)
 		if hasBody {
-			encap = fmt.Sprintf("req-hdr=0, req-body=%d", len(header))
+			encap = append(c.encapBuf[:0], "req-hdr=0, req-body="...)
 		} else {
-			encap = fmt.Sprintf("req-hdr=0, null-body=%d", len(header))
+			encap = append(c.encapBuf[:0], "req-hdr=0, null-body="...)
 		}
+		encap = strconv.AppendInt(encap, int64(len(header)), 10)
 
 	case *http.Response:
-		header, err = httpResponseHeader(msg)
-		if err != nil {
-			break
+		if hasBody && !partialContent {
+			fixupFraming(msg.Header, msg.Body)
 		}
+		header = httpResponseHeader(header, msg)
 		if hasBody {
-			encap = fmt.Sprintf("res-hdr=0, res-body=%d", len(header))
+			encap = append(c.encapBuf[:0], "res-hdr=0, res-body="...)
 		} else {
-			encap = fmt.Sprintf("res-hdr=0, null-body=%d", len(header))
+			encap = append(c.encapBuf[:0], "res-hdr=0, null-body="...)
 		}
+		encap = strconv.AppendInt(encap, int64(len(header)), 10)
 	}
 
-	if encap == "" {
+	if encap == nil {
 		if hasBody {
 			method := w.req.Method
 			if len(method) > 3 {
 				method = method[0:3]
 			}
-			method = strings.ToLower(method)
-			encap = fmt.Sprintf("%s-body=0", method)
+			encap = append(c.encapBuf[:0], strings.ToLower(method)...)
+			encap = append(encap, "-body=0"...)
 		} else {
-			encap = "null-body=0"
+			encap = append(c.encapBuf[:0], "null-body=0"...)
 		}
 	}
-	w.header.Set("Encapsulated", encap)
-	if _, ok := w.header["Date"]; !ok {
-		w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
-	}
 
 	//w.header.Set("Connection", "close")
 
-	bw := w.conn.buf.Writer
-	status := StatusText(code)
-	if status == "" {
-		status = fmt.Sprintf("status code %d", code)
-	}
-	fmt.Fprintf(bw, "ICAP/1.0 %d %s\r\n", code, status)
-	w.header.Write(bw)
-	io.WriteString(bw, "\r\n")
-
-	if header != nil {
-		bw.Write(header)
+	buf := append(c.headerBuf[:0], "ICAP/1.0 "...)
+	buf = strconv.AppendInt(buf, int64(code), 10)
+	buf = append(buf, ' ')
+	if status := StatusText(code); status != "" {
+		buf = append(buf, status...)
+	} else {
+		buf = append(buf, "status code "...)
+		buf = strconv.AppendInt(buf, int64(code), 10)
 	}
+	buf = append(buf, "\r\n"...)
+	buf = c.appendICAPHeader(buf, w.header, encap)
+	buf = append(buf, "\r\n"...)
+	buf = append(buf, header...)
+	c.buf.Write(buf)
+	c.headerBuf, c.httpHeaderBuf, c.encapBuf = keepBuffer(buf), keepBuffer(header), keepBuffer(encap)
 
 	w.wroteHeader = true
 	if hasBody {
-		w.cw = httputil.NewChunkedWriter(w.conn.buf.Writer)
+		if c.chunked == nil {
+			c.chunked = newConnChunkedWriter(c.buf.Writer, c.dc)
+		}
+		w.cw = c.chunked
 	}
 	if hasBody {
 		switch msg := httpMessage.(type) {
 		case *http.Response:
-			requestBody, _ := ioutil.ReadAll(msg.Body)
-			w.Write(requestBody)
+			w.copyBody(msg.Body)
 		case *http.Request:
-			requestBody, _ := ioutil.ReadAll(msg.Body)
-			w.Write(requestBody)
+			w.copyBody(msg.Body)
 
 		}
 	}
 
 }
 
+// copyBody streams the body of the HTTP message to the ICAP client, every read is flushed to the
+// connection so a body which is still downloading from a vendor isn't buffered in memory
+func (w *respWriter) copyBody(body io.ReadCloser) {
+	if body == nil {
+		return
+	}
+	defer body.Close()
+	bufp := getCopyBuf()
+	defer putCopyBuf(bufp)
+	buf := *bufp
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			if werr := w.conn.buf.Flush(); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Println("error while streaming the HTTP message body: " + err.Error())
+			}
+			return
+		}
+	}
+}
+
 func (w *respWriter) finishRequest() {
+	if w.aborted {
+		return
+	}
 	if !w.wroteHeader {
 		w.WriteHeader(http.StatusOK, nil, false)
 	}
 
 	if w.cw != nil && !w.wroteRaw {
-		w.cw.Close()
+		if w.useOriginalBody != "" {
+			io.WriteString(w.conn.buf, "0; use-original-body="+w.useOriginalBody+"\r\n")
+		} else {
+			w.cw.Close()
+		}
 		w.cw = nil
 		io.WriteString(w.conn.buf, "\r\n")
 	}
@@ -202,40 +272,33 @@ func (w *respWriter) finishRequest() {
 //	return buf.Bytes(), nil
 //}
 
-func httpRequestHeader(req *http.Request) (hdr []byte, err error) {
-	buf := new(bytes.Buffer)
+// httpRequestHeader appends the headers for an HTTP request to dst
+// in a form suitable for including in an ICAP message.
+func httpRequestHeader(dst []byte, req *http.Request) []byte {
 	if req.Proto == "" {
 		req.Proto = "HTTP/1.1"
 	}
-	_, err = fmt.Fprintf(buf, "%s %s %s\r\n", req.Method, req.URL, req.Proto)
-	if err != nil {
-		return nil, err
+	dst = append(dst, req.Method...)
+	dst = append(dst, ' ')
+	if req.URL != nil {
+		dst = append(dst, req.URL.String()...)
+	} else {
+		dst = append(dst, "<nil>"...)
 	}
+	dst = append(dst, ' ')
+	dst = append(dst, req.Proto...)
+	dst = append(dst, "\r\n"...)
 	if _, xIcap206Exists := req.Header["X-Icap-206"]; xIcap206Exists {
-		err := req.Header.Write(buf)
-		if err != nil {
-			return nil, err
-		}
+		dst = appendHeader(dst, req.Header, "")
 	} else {
-		err := req.Header.WriteSubset(buf, map[string]bool{
-			"Transfer-Encoding": true,
-			"Content-Length":    false,
-		})
-		if err != nil {
-			return nil, err
-		}
+		dst = appendHeader(dst, req.Header, "Transfer-Encoding")
 	}
-	_, err = io.WriteString(buf, "\r\n")
-	if err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+	return append(dst, "\r\n"...)
 }
 
-// httpResponseHeader returns the headers for an HTTP response
-// as a slice of bytes.
-func httpResponseHeader(resp *http.Response) (hdr []byte, err error) {
-	buf := new(bytes.Buffer)
+// httpResponseHeader appends the headers for an HTTP response
+// to dst.
+func httpResponseHeader(dst []byte, resp *http.Response) []byte {
 	// Status line
 	text := resp.Status
 	if text == "" {
@@ -248,26 +311,121 @@ func httpResponseHeader(resp *http.Response) (hdr []byte, err error) {
 	if proto == "" {
 		proto = "HTTP/1.1"
 	}
-	_, err = fmt.Fprintf(buf, "%s %s\r\n", proto, text)
-	if err != nil {
-		return nil, err
+	dst = append(dst, proto...)
+	dst = append(dst, ' ')
+	dst = append(dst, text...)
+	dst = append(dst, "\r\n"...)
+	dst = appendHeader(dst, resp.Header, "")
+	return append(dst, "\r\n"...)
+}
+
+// appendHeader appends the fields of h except the exclude one like http.Header.Write writes
+// them: sorted by their keys, without the fields whose names are invalid, and with the
+// newlines of the values replaced by spaces. It doesn't allocate when the keys fit in
+// the scratch array.
+func appendHeader(dst []byte, h http.Header, exclude string) []byte {
+	var scratch [32]string
+	keys := scratch[:0]
+	for key := range h {
+		if key != exclude {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		dst = appendField(dst, key, h[key], false)
 	}
-	if _, xIcap206Exists := resp.Header["X-Icap-206"]; xIcap206Exists {
-		err := resp.Header.Write(buf)
-		if err != nil {
-			return nil, err
+	return dst
+}
+
+// appendICAPHeader appends the ICAP header of a response, the Encapsulated field is encap and
+// the Date field is added if the handler didn't set one. They're merged in the sorted fields
+// without being set in h, so the header of every response doesn't allocate them.
+func (c *conn) appendICAPHeader(dst []byte, h http.Header, encap []byte) []byte {
+	keys := c.headerKeys[:0]
+	for key := range h {
+		if key != "Encapsulated" {
+			keys = append(keys, key)
 		}
-	} else {
-		err := resp.Header.WriteSubset(buf, map[string]bool{})
-		if err != nil {
-			return nil, err
+	}
+	_, hasDate := h["Date"]
+	if !hasDate {
+		keys = append(keys, "Date")
+	}
+	keys = append(keys, "Encapsulated")
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch {
+		case key == "Encapsulated":
+			dst = append(dst, "Encapsulated: "...)
+			dst = append(dst, encap...)
+			dst = append(dst, "\r\n"...)
+		case key == "Date" && !hasDate:
+			dst = append(dst, "Date: "...)
+			dst = time.Now().UTC().AppendFormat(dst, http.TimeFormat)
+			dst = append(dst, "\r\n"...)
+		default:
+			dst = appendField(dst, key, h[key], true)
+		}
+	}
+	c.headerKeys = keys[:0]
+	return dst
+}
+
+// appendField appends the lines of the values of a header field, folds says whether the folded
+// lines of the values are kept.
+func appendField(dst []byte, key string, values []string, folds bool) []byte {
+	if !httpguts.ValidHeaderFieldName(key) {
+		return dst
+	}
+	for _, v := range values {
+		dst = append(dst, key...)
+		dst = append(dst, ": "...)
+		dst = appendFieldValue(dst, v, folds)
+		dst = append(dst, "\r\n"...)
+	}
+	return dst
+}
+
+// appendFieldValue appends v without its leading and trailing whitespace, and with its CR and
+// LF replaced by spaces so a value can't inject header lines. The folds (CRLF followed by a
+// space or a tab) continue the field, they're kept if folds is true, ex: the lines of the
+// X-Violations-Found field of the ICAP header.
+func appendFieldValue(dst []byte, v string, folds bool) []byte {
+	for len(v) > 0 && isASCIISpace(v[0]) {
+		v = v[1:]
+	}
+	for len(v) > 0 && isASCIISpace(v[len(v)-1]) {
+		v = v[:len(v)-1]
+	}
+	if strings.IndexAny(v, "\r\n") < 0 {
+		return append(dst, v...)
+	}
+	for i := 0; i < len(v); i++ {
+		b := v[i]
+		switch {
+		case folds && b == '\r' && i+2 < len(v) && v[i+1] == '\n' && (v[i+2] == ' ' || v[i+2] == '\t'):
+			dst = append(dst, "\r\n"...)
+			i++
+		case b == '\r' || b == '\n':
+			dst = append(dst, ' ')
+		default:
+			dst = append(dst, b)
 		}
 	}
-	_, err = io.WriteString(buf, "\r\n")
-	if err != nil {
-		return nil, err
+	return dst
+}
+
+// the largest header buffer which a connection keeps for its next responses, the buffers of
+// the rare larger headers are left to the garbage collector
+const maxKeptHeaderBuffer = 64 * 1024
+
+// keepBuffer returns the buffer emptied for the next response of the connection.
+func keepBuffer(buf []byte) []byte {
+	if cap(buf) > maxKeptHeaderBuffer {
+		return nil
 	}
-	return buf.Bytes(), nil
+	return buf[:0]
 }
 
 // Return value if nonempty, def otherwise.
@@ -5,8 +5,15 @@
 package icap
 
 import (
+	"bytes"
 	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
 )
 
 const serverAddr = "localhost:11344"
@@ -82,3 +89,181 @@ func HandleREQMOD2(w ResponseWriter, req *Request) {
 	w.WriteHeader(200, req.Request, true)
 	io.WriteString(w, newBody)
 }
+
+func TestFixupFramingOfRewrittenBody(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "http://www.origin-server.com/form.pl", nil)
+	req.Header.Set("Content-Length", "30")
+	req.Header.Set("Transfer-Encoding", "chunked")
+	req.Body = NewBody([]byte("card=XXXX"))
+	fixupFraming(req.Header, req.Body)
+	header := httpRequestHeader(nil, req)
+	if !strings.Contains(string(header), "Content-Length: 9\r\n") || strings.Contains(string(header), "Transfer-Encoding") {
+		t.Fatalf("the framing of the rewritten body wasn't fixed up:\n%s", header)
+	}
+
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Transfer-Encoding": {"chunked"}},
+		Body: io.NopCloser(strings.NewReader("streamed"))}
+	fixupFraming(resp.Header, resp.Body)
+	if _, exists := resp.Header["Transfer-Encoding"]; exists || resp.Header.Get("Content-Length") != "" {
+		t.Fatalf("a body of unknown length should be framed by the ICAP client %v", resp.Header)
+	}
+}
+
+func TestFixupFramingDropsStaleHeaders(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Length": {"120"},
+		"Content-Encoding": {"gzip"}, "Etag": {`"v1"`}}, Body: NewBody([]byte("the decompressed file"))}
+	fixupFraming(resp.Header, resp.Body)
+	for _, stale := range []string{"Content-Encoding", "Etag"} {
+		if resp.Header.Get(stale) != "" {
+			t.Fatalf("the stale %s header should be dropped %v", stale, resp.Header)
+		}
+	}
+
+	// the unmodified body keeps its headers
+	gzipped := []byte{0x1f, 0x8b, 0x08, 0x00}
+	resp = &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Length": {"4"},
+		"Content-Encoding": {"gzip"}, "Etag": {`"v1"`}, "Content-Md5": {"w7wZokingFT2l83+y6fw5Q=="}},
+		Body: NewBody(gzipped)}
+	fixupFraming(resp.Header, resp.Body)
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.Header.Get("Etag") == "" {
+		t.Fatalf("the headers of the unmodified body should be kept %v", resp.Header)
+	}
+}
+
+// wireConn is a connection which records what's written to it, or discards it if wire is nil so the
+// benchmarks measure the response writer
+type wireConn struct {
+	net.Conn
+	wire *bytes.Buffer
+}
+
+func (c *wireConn) Write(p []byte) (int, error) {
+	if c.wire != nil {
+		c.wire.Write(p)
+	}
+	return len(p), nil
+}
+
+func (c *wireConn) Close() error                       { return nil }
+func (c *wireConn) SetWriteDeadline(t time.Time) error { return nil }
+func (c *wireConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1344}
+}
+
+func TestWriteHeaderWireFormat(t *testing.T) {
+	wire := new(bytes.Buffer)
+	c, _ := newConn(&wireConn{wire: wire}, nil, timeouts{})
+	body := bytes.Repeat([]byte("x"), 10000)
+	for n := 0; n < 2; n++ {
+		wire.Reset()
+		w := &respWriter{conn: c, req: &Request{Method: "RESPMOD"}, header: make(http.Header)}
+		w.header.Set("ISTag", `"epoch-1"`)
+		w.header.Set("Date", "Wed, 12 Oct 2022 10:00:00 GMT")
+		w.header.Set("Encapsulated", "null-body=0")
+		w.header["Bad Key"] = []string{"dropped"}
+		resp := &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Proto: "HTTP/1.1",
+			Header: http.Header{"X-Injected": {" a\r\nSet-Cookie: b "}}, Body: NewBody(body)}
+		w.WriteHeader(http.StatusOK, resp, true)
+		w.finishRequest()
+
+		httpHeader := "HTTP/1.1 200 OK\r\nContent-Length: 10000\r\nX-Injected: a  Set-Cookie: b\r\n\r\n"
+		expected := "ICAP/1.0 200 OK\r\nDate: Wed, 12 Oct 2022 10:00:00 GMT\r\nEncapsulated: res-hdr=0, res-body=" +
+			strconv.Itoa(len(httpHeader)) + "\r\nIstag: \"epoch-1\"\r\n\r\n" + httpHeader +
+			"2710\r\n" + string(body) + "\r\n0\r\n\r\n"
+		if wire.String() != expected {
+			t.Fatalf("response %d: unexpected wire format:\n%q\nexpected:\n%q", n, wire.String()[:300], expected[:300])
+		}
+	}
+
+	// the small chunks are buffered with their framing
+	wire.Reset()
+	w := &respWriter{conn: c, req: &Request{Method: "REQMOD"}, header: make(http.Header)}
+	w.WriteHeader(http.StatusOK, nil, true)
+	io.WriteString(w, "abc")
+	w.finishRequest()
+	if !strings.HasPrefix(wire.String(), "ICAP/1.0 200 OK\r\nDate: ") ||
+		!strings.HasSuffix(wire.String(), "\r\nEncapsulated: req-body=0\r\n\r\n3\r\nabc\r\n0\r\n\r\n") {
+		t.Fatalf("unexpected wire format of the small chunks:\n%q", wire.String())
+	}
+}
+
+func TestWriteHeaderKeepsICAPFolds(t *testing.T) {
+	wire := new(bytes.Buffer)
+	c, _ := newConn(&wireConn{wire: wire}, nil, timeouts{})
+	w := &respWriter{conn: c, req: &Request{Method: "RESPMOD"}, header: make(http.Header)}
+	w.header.Set("Date", "Wed, 12 Oct 2022 10:00:00 GMT")
+	w.header.Set("X-Violations-Found", "1\r\n\teicar.com\r\n\tEicar-Signature\r\nX-Injected: a")
+	resp := &http.Response{StatusCode: http.StatusForbidden, Status: "403 Forbidden", Proto: "HTTP/1.1",
+		Header: http.Header{"X-Folded": {"a\r\n\tb"}}}
+	w.WriteHeader(http.StatusOK, resp, false)
+	w.finishRequest()
+	if !strings.Contains(wire.String(), "X-Violations-Found: 1\r\n\teicar.com\r\n\tEicar-Signature  X-Injected: a\r\n") {
+		t.Fatalf("the folds of the ICAP header should be kept and the other newlines replaced:\n%q", wire.String())
+	}
+	if !strings.Contains(wire.String(), "X-Folded: a  \tb\r\n") {
+		t.Fatalf("the folds of the HTTP header shouldn't be kept:\n%q", wire.String())
+	}
+}
+
+func TestWriteHeaderPartialContent(t *testing.T) {
+	wire := new(bytes.Buffer)
+	c, _ := newConn(&wireConn{wire: wire}, nil, timeouts{})
+	w := &respWriter{conn: c, req: &Request{Method: "RESPMOD"}, header: make(http.Header)}
+	w.header.Set("Date", "Wed, 12 Oct 2022 10:00:00 GMT")
+	w.header.Set(UseOriginalBodyHeader, "5")
+	// the Content-Length is the length of the whole body, not of the part which is sent
+	resp := &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Proto: "HTTP/1.1",
+		Header: http.Header{"Content-Length": {"12"}}, Body: NewBody([]byte("<b>new</b>"))}
+	w.WriteHeader(http.StatusPartialContent, resp, true)
+	w.finishRequest()
+
+	httpHeader := "HTTP/1.1 200 OK\r\nContent-Length: 12\r\n\r\n"
+	expected := "ICAP/1.0 206 Partial Content\r\nDate: Wed, 12 Oct 2022 10:00:00 GMT\r\nEncapsulated: res-hdr=0, res-body=" +
+		strconv.Itoa(len(httpHeader)) + "\r\n\r\n" + httpHeader + "a\r\n<b>new</b>\r\n0; use-original-body=5\r\n\r\n"
+	if wire.String() != expected {
+		t.Fatalf("unexpected wire format of the 206 response:\n%q\nexpected:\n%q", wire.String(), expected)
+	}
+}
+
+// benchmarkResponses writes b.N ICAP responses to a connection which discards them, respond writes one response
+func benchmarkResponses(b *testing.B, respond func(w *respWriter)) {
+	c, _ := newConn(&wireConn{}, nil, timeouts{})
+	req := &Request{Method: "RESPMOD"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		w := &respWriter{conn: c, req: req, header: make(http.Header)}
+		w.header.Set("ISTag", `"epoch-1665581214-6e1fd3c2"`)
+		w.header.Set("Service", "clamav service")
+		w.header.Set("X-ICAP-Metadata", "sttfyrkesz9qhg8firjq")
+		respond(w)
+		w.finishRequest()
+	}
+}
+
+func BenchmarkWriteHeader204(b *testing.B) {
+	benchmarkResponses(b, func(w *respWriter) {
+		w.WriteHeader(http.StatusNoContent, nil, false)
+	})
+}
+
+func BenchmarkWriteHeaderBlockPage(b *testing.B) {
+	page := []byte(strings.Repeat("<p>the file is blocked</p>", 40))
+	benchmarkResponses(b, func(w *respWriter) {
+		resp := &http.Response{StatusCode: http.StatusForbidden, Status: "403 Forbidden", Proto: "HTTP/1.1",
+			Header: http.Header{"Content-Type": {"text/html"}, "Cache-Control": {"no-store"},
+				"X-Icapeg-Reason": {"fileIsNotSafe"}},
+			Body: NewBody(page)}
+		w.WriteHeader(http.StatusOK, resp, true)
+	})
+}
+
+func BenchmarkWriteBody1MB(b *testing.B) {
+	body := make([]byte, 1<<20)
+	b.SetBytes(int64(len(body)))
+	benchmarkResponses(b, func(w *respWriter) {
+		resp := &http.Response{StatusCode: http.StatusOK, Proto: "HTTP/1.1",
+			Header: http.Header{"Content-Type": {"application/pdf"}}, Body: NewBody(body)}
+		w.WriteHeader(http.StatusOK, resp, true)
+	})
+}
@@ -9,13 +9,18 @@ package icap
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 
 	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -45,17 +50,86 @@ type conn struct {
 	handler    Handler           // request handler
 	rwc        net.Conn          // i/o connection
 	buf        *bufio.ReadWriter // buffered rwc
+	dc         *deadlineConn     // the deadlines of rwc
+	timeouts   timeouts
+	srv        *Server // the server of the connection, nil in the tests
+	idle       int32   // 1 while the connection waits for its next request, Shutdown closes it then
+
+	// the buffers of the response headers, they're reused by the next responses of the connection
+	headerBuf     []byte
+	httpHeaderBuf []byte
+	encapBuf      []byte
+	headerKeys    []string
+	chunked       io.WriteCloser // the chunked writer of the response bodies
+}
+
+// timeouts are the timeouts of a connection, a zero timeout is no timeout.
+type timeouts struct {
+	read       time.Duration // of every read, so a dead peer can't hold the connection
+	write      time.Duration // of every write
+	idle       time.Duration // the wait for the next request on a kept-alive connection
+	readHeader time.Duration // the reading of the ICAP and HTTP headers and the preview of a request
+}
+
+// deadlineConn sets the deadline of every read and write before it's done, a read limit
+// is a deadline which the reads can't extend, like the one of the headers.
+type deadlineConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	readLimit    time.Time
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	var deadline time.Time
+	if c.readTimeout != 0 {
+		deadline = time.Now().Add(c.readTimeout)
+	}
+	if !c.readLimit.IsZero() {
+		deadline = c.readLimit
+	}
+	if c.readTimeout != 0 || !c.readLimit.IsZero() {
+		c.Conn.SetReadDeadline(deadline)
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	if c.writeTimeout != 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	return c.Conn.Write(p)
+}
+
+// writeBuffers writes the buffers with one vectored write (writev) when the connection
+// supports it, like the TCP connections do.
+func (c *deadlineConn) writeBuffers(bufs *net.Buffers) (int64, error) {
+	if c.writeTimeout != 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	return bufs.WriteTo(c.Conn)
+}
+
+// limitReads sets the read limit to timeout from now, a zero timeout removes the limit.
+func (c *deadlineConn) limitReads(timeout time.Duration) {
+	c.readLimit = time.Time{}
+	if timeout != 0 {
+		c.readLimit = time.Now().Add(timeout)
+	} else if c.readTimeout == 0 {
+		// the reads don't set their deadlines, so the deadline of the previous limit is removed here
+		c.Conn.SetReadDeadline(time.Time{})
+	}
 }
 
 // Create new connection from rwc.
-func newConn(rwc net.Conn, handler Handler) (c *conn, err error) {
+func newConn(rwc net.Conn, handler Handler, t timeouts) (c *conn, err error) {
 	c = new(conn)
 	c.remoteAddr = rwc.RemoteAddr().String()
 	c.handler = handler
-	c.rwc = rwc
-	br := bufio.NewReader(rwc)
-	bw := bufio.NewWriter(rwc)
-	c.buf = bufio.NewReadWriter(br, bw)
+	c.dc = &deadlineConn{Conn: rwc, readTimeout: t.read, writeTimeout: t.write}
+	c.rwc = c.dc
+	c.timeouts = t
+	c.buf = bufio.NewReadWriter(newBufReader(c.rwc), newBufWriter(c.rwc))
 
 	return c, nil
 }
@@ -71,6 +145,7 @@ func (c *conn) readRequest() (w *respWriter, err error) {
 		req = new(Request)
 	} else {
 		req.RemoteAddr = c.remoteAddr
+		req.srv = c.srv
 	}
 
 	w = new(respWriter)
@@ -84,6 +159,8 @@ func (c *conn) readRequest() (w *respWriter, err error) {
 func (c *conn) close() {
 	if c.buf != nil {
 		c.buf.Flush()
+		putBufReader(c.buf.Reader)
+		putBufWriter(c.buf.Writer)
 		c.buf = nil
 	}
 	if c.rwc != nil {
@@ -92,8 +169,70 @@ func (c *conn) close() {
 	}
 }
 
+// badRequest writes the 400 response of a malformed request, a violation of RFC 3507 in strict mode is
+// answered with its own status code and tells the violation in the X-ICAP-Error header.
+func (c *conn) badRequest(err error) {
+	if c.rwc == nil {
+		return
+	}
+	code, violationHeader := http.StatusBadRequest, ""
+	var violation *ViolationError
+	if errors.As(err, &violation) {
+		code, violationHeader = violation.StatusCode, ViolationHeader+": "+violation.Reason+"\r\n"
+	}
+	c.rwc.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(c.rwc, "ICAP/1.0 %d %s\r\nConnection: close\r\n%sEncapsulated: null-body=0\r\n\r\n",
+		code, StatusText(code), violationHeader)
+}
+
+// rstAvoidanceDelay is how long a connection which is closed after its response waits for the client to close it
+const rstAvoidanceDelay = 500 * time.Millisecond
+
+// closeWriteAndWait closes the writing side of the connection and discards what the client still sends for a
+// while, so the unread rest of a request doesn't reset the connection before the client reads the response.
+func (c *conn) closeWriteAndWait() {
+	c.buf.Flush()
+	if cw, ok := c.rwc.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	c.rwc.SetReadDeadline(time.Now().Add(rstAvoidanceDelay))
+	io.Copy(io.Discard, c.rwc)
+}
+
+// isNetError reports whether err is an error of the connection itself, like a timeout or a reset.
+func isNetError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// the number of the open ICAP connections
+var activeConns int64
+
+// ActiveConnections returns the number of the open ICAP connections.
+func ActiveConnections() int64 {
+	return atomic.LoadInt64(&activeConns)
+}
+
+// the number of the requests which wait for a worker of the server
+var waitingRequests int64
+
+// WaitingRequests returns the number of the ICAP requests which wait for a worker, MaxConcurrency requests
+// are processed already.
+func WaitingRequests() int64 {
+	return atomic.LoadInt64(&waitingRequests)
+}
+
+// process serves the request, the handler waits for a worker of the server once the body is read (see
+// Request.AcquireWorker).
+func (c *conn) process(w *respWriter) {
+	c.handler.ServeICAP(w, w.req)
+	w.finishRequest()
+}
+
 // Serve a new connection.
 func (c *conn) serve(debugLevel int) {
+	atomic.AddInt64(&activeConns, 1)
+	defer atomic.AddInt64(&activeConns, -1)
 	defer func() {
 
 		err := recover()
@@ -105,27 +244,54 @@ func (c *conn) serve(debugLevel int) {
 		fmt.Fprintf(&buf, "icap: panic serving %v: %v\n", c.remoteAddr, err)
 		buf.Write(debug.Stack())
 		log.Print(buf.String())
+		c.close()
 
 	}()
 	for {
 		var w *respWriter
-		w, err := c.readRequest()
-		// In a case of parsing error there should be an option to handle a dummy request to not fail the whole service.
-		if w == nil {
-			c.rwc.Close()
-
+		// a kept-alive connection waits for the next request up to the idle timeout, then the headers
+		// of the request have to arrive within the header timeout whatever the pace of the client is
+		if c.timeouts.idle != 0 {
+			c.dc.limitReads(c.timeouts.idle)
+		}
+		atomic.StoreInt32(&c.idle, 1)
+		if c.srv.shuttingDown() {
 			break
 		}
+		_, err := c.buf.Peek(1)
+		atomic.StoreInt32(&c.idle, 0)
 		if err != nil {
-
-			log.Println("error while reading request:", err)
-			c.rwc.Close()
 			break
-
+		}
+		c.dc.limitReads(c.timeouts.readHeader)
+		w, err = c.readRequest()
+		c.dc.limitReads(0)
+		if err != nil {
+			// a malformed request gets a 400 before the connection is closed, the connection
+			// can't be reused since the end of the request in the stream is unknown
+			if err != io.EOF && !isNetError(err) {
+				log.Println("error while reading request:", err)
+				c.badRequest(err)
+			}
+			break
 		}
 
-		c.handler.ServeICAP(w, w.req)
-		w.finishRequest()
+		c.process(w)
+		// a response with Connection: close ends the connection once it's written, the rest of the request
+		// isn't read
+		if !w.aborted && w.header.Get("Connection") == "close" {
+			c.closeWriteAndWait()
+			break
+		}
+		// the rest of a body which wasn't read is discarded before the next request, a long one aborts
+		// the connection so the client stops sending it
+		if w.aborted || !w.req.discardBody() {
+			break
+		}
+		// the server is being shut down, the connection isn't kept alive for another request
+		if c.srv.shuttingDown() {
+			break
+		}
 	}
 
 	c.close()
@@ -133,11 +299,156 @@ func (c *conn) serve(debugLevel int) {
 
 // A Server defines parameters for running an ICAP server.
 type Server struct {
-	Addr         string  // TCP address to listen on, ":1344" if empty
-	Handler      Handler // handler to invoke
-	ReadTimeout  time.Duration
-	WriteTimeout time.Duration
-	DebugLevel   int
+	Addr              string        // TCP address to listen on, ":1344" if empty
+	Handler           Handler       // handler to invoke
+	ReadTimeout       time.Duration // the max wait of every read from a connection, zero means no timeout
+	WriteTimeout      time.Duration // the max wait of every write to a connection, zero means no timeout
+	IdleTimeout       time.Duration // the max wait for the next request on a kept-alive connection, zero means ReadTimeout
+	ReadHeaderTimeout time.Duration // the time to read the headers and the preview of a request, zero means no timeout
+	TLSConfig         *tls.Config   // the TLS configuration of ListenAndServeTLS, its certificates are used if the files are empty
+	DebugLevel        int
+	MaxConcurrency    int           // the max requests which are processed at once, the others wait for a worker, zero means no limit
+	WorkerTimeout     time.Duration // the max wait for a worker, zero means no timeout
+
+	mu         sync.Mutex
+	workers    chan struct{} // the workers which process the requests, nil if MaxConcurrency is zero
+	done       chan struct{} // closed by Shutdown, so the requests stop waiting for a worker
+	listeners  map[net.Listener]struct{}
+	conns      map[*conn]struct{}
+	inShutdown int32
+}
+
+// ErrServerClosed is returned by Serve, ListenAndServe and ListenAndServeTLS after Shutdown was called
+var ErrServerClosed = errors.New("icap: Server closed")
+
+// shuttingDown reports whether Shutdown was called, a nil server is never shut down
+func (srv *Server) shuttingDown() bool {
+	return srv != nil && atomic.LoadInt32(&srv.inShutdown) != 0
+}
+
+// acquireWorker waits for a free worker if the requests are bounded by MaxConcurrency, it returns the func
+// which releases the worker. It returns false if no worker was free within WorkerTimeout or the server was
+// shut down while the request waited. A nil server doesn't bound them
+func (srv *Server) acquireWorker() (release func(), acquired bool) {
+	if srv == nil || srv.MaxConcurrency <= 0 {
+		return func() {}, true
+	}
+	srv.mu.Lock()
+	if srv.workers == nil {
+		srv.workers = make(chan struct{}, srv.MaxConcurrency)
+	}
+	workers, done := srv.workers, srv.doneChan()
+	srv.mu.Unlock()
+	select {
+	case workers <- struct{}{}:
+		return func() { <-workers }, true
+	default:
+	}
+
+	atomic.AddInt64(&waitingRequests, 1)
+	defer atomic.AddInt64(&waitingRequests, -1)
+	var timeout <-chan time.Time
+	if srv.WorkerTimeout > 0 {
+		timer := time.NewTimer(srv.WorkerTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case workers <- struct{}{}:
+		return func() { <-workers }, true
+	case <-timeout:
+	case <-done:
+	}
+	return nil, false
+}
+
+// doneChan returns the channel which Shutdown closes, srv.mu must be held
+func (srv *Server) doneChan() chan struct{} {
+	if srv.done == nil {
+		srv.done = make(chan struct{})
+	}
+	return srv.done
+}
+
+// trackListener adds the listener to the ones which Shutdown closes, false if the server is shut down already
+func (srv *Server) trackListener(l net.Listener, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if !add {
+		delete(srv.listeners, l)
+		return true
+	}
+	if srv.shuttingDown() {
+		return false
+	}
+	if srv.listeners == nil {
+		srv.listeners = make(map[net.Listener]struct{})
+	}
+	srv.listeners[l] = struct{}{}
+	return true
+}
+
+func (srv *Server) trackConn(c *conn, add bool) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.conns == nil {
+		srv.conns = make(map[*conn]struct{})
+	}
+	if add {
+		srv.conns[c] = struct{}{}
+	} else {
+		delete(srv.conns, c)
+	}
+}
+
+// closeIdleConns closes the connections which wait for their next request, it reports whether
+// all the connections are closed
+func (srv *Server) closeIdleConns() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for c := range srv.conns {
+		if atomic.LoadInt32(&c.idle) != 0 {
+			// the net.Conn is closed, rwc may be set to nil by the goroutine of the connection
+			c.dc.Conn.Close()
+			delete(srv.conns, c)
+		}
+	}
+	return len(srv.conns) == 0
+}
+
+// Shutdown stops the server without interrupting the requests which are being served: it closes the listeners
+// and the idle connections and ends the waits for a worker, then it waits for the other connections to finish their requests and to close.
+// It returns the error of ctx if ctx is done first, the connections which are still being served are left open
+func (srv *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&srv.inShutdown, 1)
+	srv.mu.Lock()
+	// the requests which wait for a worker get 503 instead of waiting for the connections to be closed
+	select {
+	case <-srv.doneChan():
+	default:
+		close(srv.done)
+	}
+	var err error
+	for l := range srv.listeners {
+		if cErr := l.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+		delete(srv.listeners, l)
+	}
+	srv.mu.Unlock()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if srv.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
 }
 
 // ListenAndServe listens on the TCP network address srv.Addr and then
@@ -155,19 +466,25 @@ func (srv *Server) ListenAndServe() error {
 	return srv.Serve(l)
 }
 
-// ListenAndServeTLS ---
+// ListenAndServeTLS listens on srv.Addr like ListenAndServe and serves the connections over TLS (icaps), the
+// cert and key files may be empty if srv.TLSConfig has the certificates or GetCertificate
 func (srv *Server) ListenAndServeTLS(cert, key string) error {
-
-	cer, err := tls.LoadX509KeyPair(cert, key)
-	if err != nil {
-		return err
+	config := &tls.Config{}
+	if srv.TLSConfig != nil {
+		config = srv.TLSConfig.Clone()
+	}
+	if cert != "" || key != "" || (len(config.Certificates) == 0 && config.GetCertificate == nil) {
+		cer, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return err
+		}
+		config.Certificates = []tls.Certificate{cer}
 	}
 	addr := srv.Addr
 	if addr == "" {
 		addr = ":1344"
 	}
 
-	config := &tls.Config{Certificates: []tls.Certificate{cer}}
 	l, err := tls.Listen("tcp", addr, config)
 	if err != nil {
 		return err
@@ -180,6 +497,10 @@ func (srv *Server) ListenAndServeTLS(cert, key string) error {
 // then call srv.Handler to reply to them.
 func (srv *Server) Serve(l net.Listener) error {
 	defer l.Close()
+	if !srv.trackListener(l, true) {
+		return ErrServerClosed
+	}
+	defer srv.trackListener(l, false)
 	handler := srv.Handler
 	if handler == nil {
 		handler = DefaultServeMux
@@ -188,23 +509,26 @@ func (srv *Server) Serve(l net.Listener) error {
 	for {
 		rw, err := l.Accept()
 		if err != nil {
+			if srv.shuttingDown() {
+				return ErrServerClosed
+			}
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				log.Printf("icap: Accept error: %v", err)
 				continue
 			}
 			return err
 		}
-		if srv.ReadTimeout != 0 {
-			rw.SetReadDeadline(time.Now().Add(srv.ReadTimeout))
-		}
-		if srv.WriteTimeout != 0 {
-			rw.SetWriteDeadline(time.Now().Add(srv.WriteTimeout))
-		}
-		c, err := newConn(rw, handler)
+		c, err := newConn(rw, handler, timeouts{read: srv.ReadTimeout, write: srv.WriteTimeout,
+			idle: srv.IdleTimeout, readHeader: srv.ReadHeaderTimeout})
 		if err != nil {
 			continue
 		}
-		go c.serve(srv.DebugLevel)
+		c.srv = srv
+		srv.trackConn(c, true)
+		go func() {
+			defer srv.trackConn(c, false)
+			c.serve(srv.DebugLevel)
+		}()
 	}
 	// The next line is only there to see one specific edge case which should never happen.
 	panic("Should never be reached")
@@ -0,0 +1,360 @@
+package icap
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServerClosesSlowHeaders(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {}), ReadTimeout: time.Minute,
+		ReadHeaderTimeout: 200 * time.Millisecond}
+	go srv.Serve(l)
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	start := time.Now()
+	// the request is sent slower than the header timeout allows, every read is within the read timeout
+	go func() {
+		for _, b := range []byte("OPTIONS icap://127.0.0.1/echo ICAP/1.0\r\n") {
+			if _, err := c.Write([]byte{b}); err != nil {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	io.ReadAll(c)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("the connection should be closed after the header timeout, it took %v", elapsed)
+	}
+}
+
+func TestServerDiscardsTheUnreadBody(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the handler answers before reading the body like an early 204
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.WriteHeader(http.StatusNoContent, nil, false)
+	})}
+	go srv.Serve(l)
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	wire := respmodHead + "Allow: 204\r\nEncapsulated: res-hdr=0, res-body=" + strconv.Itoa(len(httpRespHdr)) +
+		"\r\n\r\n" + httpRespHdr + "3\r\nabc\r\n0\r\n\r\n"
+	c.Write([]byte(wire + wire))
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(c)
+	for n := 0; n < 2; n++ {
+		line, err := r.ReadString('\n')
+		if err != nil || !strings.HasPrefix(line, "ICAP/1.0 204") {
+			t.Fatalf("response %d: expected a 204, got %q %v", n, line, err)
+		}
+		for line != "\r\n" && err == nil {
+			line, err = r.ReadString('\n')
+		}
+	}
+}
+
+func TestServerShutdownWaitsForTheRequests(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		close(started)
+		time.Sleep(300 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent, nil, false)
+	})}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+
+	idle, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte(respmodHead + "Allow: 204\r\nEncapsulated: res-hdr=0, res-body=" + strconv.Itoa(len(httpRespHdr)) +
+		"\r\n\r\n" + httpRespHdr + "0\r\n\r\n"))
+	<-started
+
+	if err = srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-served; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("expected ErrServerClosed, got %v", err)
+	}
+	// the request which was being served got its response before the connection was closed
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "ICAP/1.0 204") {
+		t.Fatalf("expected a 204, got %q %v", line, err)
+	}
+	idle.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = idle.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("the idle connection should be closed, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = srv.Shutdown(ctx); err != nil {
+		t.Fatalf("a server which was shut down has no connections, got %v", err)
+	}
+}
+
+func TestServerBoundsTheConcurrentRequests(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var running, maxRunning int32
+	srv := &Server{MaxConcurrency: 2, Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		release, acquired := r.AcquireWorker()
+		if !acquired {
+			w.WriteHeader(http.StatusServiceUnavailable, nil, false)
+			return
+		}
+		defer release()
+		n := atomic.AddInt32(&running, 1)
+		for m := atomic.LoadInt32(&maxRunning); n > m && !atomic.CompareAndSwapInt32(&maxRunning, m, n); {
+			m = atomic.LoadInt32(&maxRunning)
+		}
+		time.Sleep(100 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		w.WriteHeader(http.StatusNoContent, nil, false)
+	})}
+	go srv.Serve(l)
+	defer srv.Shutdown(context.Background())
+
+	var wg sync.WaitGroup
+	for n := 0; n < 6; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer c.Close()
+			c.Write([]byte(respmodHead + "Allow: 204\r\nEncapsulated: res-hdr=0, res-body=" +
+				strconv.Itoa(len(httpRespHdr)) + "\r\n\r\n" + httpRespHdr + "0\r\n\r\n"))
+			c.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if line, err := bufio.NewReader(c).ReadString('\n'); err != nil || !strings.HasPrefix(line, "ICAP/1.0 204") {
+				t.Errorf("expected a 204, got %q %v", line, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxRunning != 2 {
+		t.Fatalf("expected 2 requests processed at once, got %d", maxRunning)
+	}
+	if waiting := WaitingRequests(); waiting != 0 {
+		t.Fatalf("expected no request waiting for a worker, got %d", waiting)
+	}
+}
+
+func TestServerAnswersTheRequestsWithoutAWorker(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{MaxConcurrency: 1, WorkerTimeout: 200 * time.Millisecond,
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			release, acquired := r.AcquireWorker()
+			if !acquired {
+				w.WriteHeader(http.StatusServiceUnavailable, nil, false)
+				return
+			}
+			release()
+			w.WriteHeader(http.StatusNoContent, nil, false)
+		})}
+	go srv.Serve(l)
+	// the only worker is busy for the whole test
+	release, _ := srv.acquireWorker()
+	defer release()
+
+	send := func() string {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Error(err)
+			return ""
+		}
+		defer c.Close()
+		c.Write([]byte(respmodHead + "Allow: 204\r\nEncapsulated: res-hdr=0, res-body=" +
+			strconv.Itoa(len(httpRespHdr)) + "\r\n\r\n" + httpRespHdr + "0\r\n\r\n"))
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, _ := bufio.NewReader(c).ReadString('\n')
+		return line
+	}
+	start := time.Now()
+	if line := send(); !strings.HasPrefix(line, "ICAP/1.0 503") || time.Since(start) > 2*time.Second {
+		t.Fatalf("expected a 503 after the worker timeout, got %q after %v", line, time.Since(start))
+	}
+
+	// the shutdown ends the wait of a request without a worker timeout
+	srv.WorkerTimeout = 0
+	answered := make(chan string, 1)
+	go func() { answered <- send() }()
+	for deadline := time.Now().Add(2 * time.Second); WaitingRequests() == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(ctx) }()
+	select {
+	case line := <-answered:
+		if !strings.HasPrefix(line, "ICAP/1.0 503") {
+			t.Fatalf("expected a 503 on shutdown, got %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the request should stop waiting for a worker on shutdown")
+	}
+	if err = <-shutdown; err != nil {
+		t.Fatalf("the connections should be closed after their requests, got %v", err)
+	}
+}
+
+// BenchmarkServeRESPMOD sends RESPMOD requests over kept-alive loopback connections to a server which
+// returns the body with a modified header, it reports the transactions per second of the server
+func BenchmarkServeRESPMOD(b *testing.B) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		content, _ := io.ReadAll(r.Response.Body)
+		w.Header().Set("ISTag", `"epoch-1665581214-6e1fd3c2"`)
+		r.Response.Header.Set("X-Scanned", "clean")
+		r.Response.Body = NewBody(content)
+		w.WriteHeader(http.StatusOK, r.Response, true)
+	})}
+	go srv.Serve(l)
+
+	body := strings.Repeat("x", 4096)
+	wire := respmodHead + "Encapsulated: res-hdr=0, res-body=" + strconv.Itoa(len(httpRespHdr)) + "\r\n\r\n" +
+		httpRespHdr + strconv.FormatInt(int64(len(body)), 16) + "\r\n" + body + "\r\n0\r\n\r\n"
+	start := time.Now()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		for pb.Next() {
+			if _, err := io.WriteString(c, wire); err != nil {
+				b.Error(err)
+				return
+			}
+			if err := readTestResponse(r); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "tx/s")
+}
+
+// BenchmarkServeRESPMODNewConnections sends every RESPMOD request over a new loopback connection, like the ICAP
+// clients which don't keep the connections alive, so the buffers of the connections are allocated or reused for
+// every transaction. The sub benchmarks bound the requests which are processed at once
+func BenchmarkServeRESPMODNewConnections(b *testing.B) {
+	for _, maxConcurrency := range []int{0, 64} {
+		b.Run("max_concurrency="+strconv.Itoa(maxConcurrency), func(b *testing.B) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			srv := &Server{MaxConcurrency: maxConcurrency, Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+				io.Copy(io.Discard, r.Response.Body)
+				release, _ := r.AcquireWorker()
+				defer release()
+				w.Header().Set("ISTag", `"epoch-1665581214-6e1fd3c2"`)
+				r.Response.Header.Set("X-Scanned", "clean")
+				w.WriteHeader(http.StatusOK, r.Response, true)
+			})}
+			go srv.Serve(l)
+			defer srv.Shutdown(context.Background())
+
+			body := strings.Repeat("x", 64<<10)
+			wire := respmodHead + "Encapsulated: res-hdr=0, res-body=" + strconv.Itoa(len(httpRespHdr)) + "\r\n\r\n" +
+				httpRespHdr + strconv.FormatInt(int64(len(body)), 16) + "\r\n" + body + "\r\n0\r\n\r\n"
+			start := time.Now()
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					c, err := net.Dial("tcp", l.Addr().String())
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					go io.WriteString(c, wire)
+					err = readTestResponse(bufio.NewReader(c))
+					c.Close()
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "tx/s")
+		})
+	}
+}
+
+// readTestResponse reads an ICAP response which encapsulates an HTTP header and a body
+func readTestResponse(r *bufio.Reader) error {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "ICAP/1.0 200") {
+		return errors.New("unexpected response " + line)
+	}
+	// the ICAP header and the HTTP header
+	for blank := 0; blank < 2; {
+		if line, err = r.ReadString('\n'); err != nil {
+			return err
+		}
+		if line == "\r\n" {
+			blank++
+		}
+	}
+	_, err = io.Copy(io.Discard, newChunkedReader(r))
+	return err
+}
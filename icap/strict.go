@@ -0,0 +1,121 @@
+// Strict RFC 3507 conformance of the ICAP requests.
+
+package icap
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ViolationError is returned for the requests which violate RFC 3507 in strict mode, the request is
+// answered with its status code and the violation in the X-ICAP-Error header.
+type ViolationError struct {
+	StatusCode int
+	Reason     string
+}
+
+func (e *ViolationError) Error() string {
+	return "icap: RFC 3507 violation: " + e.Reason
+}
+
+// ViolationHeader is the header of the error responses which tells the violation of the request.
+const ViolationHeader = "X-ICAP-Error"
+
+var strictMode bool
+
+// SetStrictMode makes the requests which are read after it be validated against RFC 3507, the lenient
+// default accepts the sloppy clients as long as the request can be parsed.
+func SetStrictMode(strict bool) {
+	strictMode = strict
+}
+
+func violation(statusCode int, format string, a ...interface{}) error {
+	return &ViolationError{StatusCode: statusCode, Reason: fmt.Sprintf(format, a...)}
+}
+
+// the sections which RFC 3507 allows in the Encapsulated header of every method in their order, the last
+// one is one of the body sections
+var encapsulatedSections = map[string]struct {
+	headers []string
+	bodies  []string
+}{
+	"REQMOD":  {headers: []string{"req-hdr"}, bodies: []string{"req-body", "null-body"}},
+	"RESPMOD": {headers: []string{"req-hdr", "res-hdr"}, bodies: []string{"res-body", "null-body"}},
+	"OPTIONS": {bodies: []string{"opt-body", "null-body"}},
+}
+
+// validateStrict checks the request line and the ICAP headers of the request against RFC 3507.
+func validateStrict(req *Request) error {
+	sections, known := encapsulatedSections[req.Method]
+	if !known {
+		return violation(http.StatusNotImplemented, "%s isn't an ICAP method", req.Method)
+	}
+	if req.Proto != "ICAP/1.0" {
+		return violation(http.StatusHTTPVersionNotSupported, "%s isn't ICAP/1.0", req.Proto)
+	}
+	if req.URL.Scheme != "icap" && req.URL.Scheme != "icaps" {
+		return violation(http.StatusBadRequest, "the request URI must be an icap:// URI")
+	}
+	if req.Header.Get("Host") == "" {
+		return violation(http.StatusBadRequest, "the Host header is required")
+	}
+	if preview, exists := req.Header["Preview"]; exists {
+		if n, err := strconv.Atoi(preview[0]); err != nil || n < 0 || len(preview) > 1 {
+			return violation(http.StatusBadRequest, "the Preview header must be a single number of bytes")
+		}
+	}
+
+	encapsulated, exists := req.Header["Encapsulated"]
+	if !exists {
+		if req.Method == "OPTIONS" {
+			return nil
+		}
+		return violation(http.StatusBadRequest, "the Encapsulated header is required in %s", req.Method)
+	}
+	if len(encapsulated) > 1 {
+		return violation(http.StatusBadRequest, "the Encapsulated header must be sent once")
+	}
+	headers := sections.headers
+	prevValue := -1
+	items := strings.Split(encapsulated[0], ", ")
+	for n, item := range items {
+		eq := strings.Index(item, "=")
+		if eq == -1 {
+			return violation(http.StatusBadRequest, "the Encapsulated section %q isn't name=offset", item)
+		}
+		key := item[:eq]
+		value, err := strconv.Atoi(item[eq+1:])
+		if err != nil || (n == 0 && value != 0) || value <= prevValue {
+			return violation(http.StatusBadRequest, "the Encapsulated offsets must start at 0 and increase")
+		}
+		prevValue = value
+		if n == len(items)-1 {
+			if !containsString(sections.bodies, key) {
+				return violation(http.StatusBadRequest, "the Encapsulated header of %s must end with %s",
+					req.Method, strings.Join(sections.bodies, " or "))
+			}
+			break
+		}
+		// the headers are optional but in their order
+		for len(headers) > 0 && headers[0] != key {
+			headers = headers[1:]
+		}
+		if len(headers) == 0 {
+			return violation(http.StatusBadRequest, "%s isn't allowed here in the Encapsulated header of %s",
+				key, req.Method)
+		}
+		headers = headers[1:]
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,301 @@
+package jobs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"icapeg/config"
+	utils "icapeg/consts"
+	"icapeg/events"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/proxy"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// the outbound proxy of the webhook calls, a [app.outbound_proxy.deferred_scan] section gives them their own
+const webhookProxy = "deferred_scan"
+
+// the attempts to post a verdict to the webhook, the wait doubles after every failed attempt
+const (
+	webhookAttempts = 3
+	webhookBackoff  = time.Second
+)
+
+// DeferredQueue scans the files which a service delivered before their verdict, workers files at a time. The
+// pending scans are kept in the queue dir so the files which were delivered before a restart are scanned after it,
+// and every verdict is written in the audit log and posted to the webhook of the service
+type DeferredQueue struct {
+	serviceName string
+	cfg         *config.DeferredScanConfig
+	slots       chan struct{} // the scans which are waiting or running
+	tasks       chan *deferredTask
+	scanner     *Scanner
+	client      *http.Client
+}
+
+// deferredTask is a pending deferred scan, scan is nil for the scans which were restored from the queue dir
+type deferredTask struct {
+	job  *Job
+	scan func() *events.VerdictEvent
+	path string // the file of the job in the queue dir, "" if it isn't kept
+}
+
+var (
+	deferredMu     sync.RWMutex
+	deferredQueues = make(map[string]*DeferredQueue)
+)
+
+// InitDeferredQueues starts the workers of the services which have a [<service>.deferred_scan] section and
+// queues the scans which were pending in their queue dir
+func InitDeferredQueues() {
+	for serviceName, serviceInstance := range config.App().ServicesInstances {
+		if serviceInstance.DeferredScan == nil {
+			continue
+		}
+		q := Deferred(serviceName, serviceInstance.DeferredScan)
+		if serviceInstance.DeferredScan.QueueDir != "" {
+			go q.restore()
+		}
+	}
+}
+
+// NewDeferredQueue creates the queue of the deferred scans of the service and starts its workers
+func NewDeferredQueue(serviceName string, cfg *config.DeferredScanConfig) *DeferredQueue {
+	q := &DeferredQueue{
+		serviceName: serviceName,
+		cfg:         cfg,
+		slots:       make(chan struct{}, cfg.Workers+cfg.QueueSize),
+		tasks:       make(chan *deferredTask, cfg.Workers+cfg.QueueSize),
+		scanner:     NewScanner(http.DefaultClient, cfg.BlocklistTTL),
+		client:      &http.Client{Transport: proxy.Transport(webhookProxy), Timeout: cfg.WebhookTimeout},
+	}
+	for n := 0; n < cfg.Workers; n++ {
+		go q.work()
+	}
+	return q
+}
+
+// Deferred returns the deferred scan queue of the service, it's created with cfg if the service has none yet, ex:
+// deferred scanning was enabled by a reload. The queue keeps its first configuration until a restart
+func Deferred(serviceName string, cfg *config.DeferredScanConfig) *DeferredQueue {
+	deferredMu.RLock()
+	q, exists := deferredQueues[serviceName]
+	deferredMu.RUnlock()
+	if exists {
+		return q
+	}
+	deferredMu.Lock()
+	defer deferredMu.Unlock()
+	if q, exists = deferredQueues[serviceName]; !exists {
+		logging.Logger.Debug("starting the " + strconv.Itoa(cfg.Workers) + " deferred scan workers of " + serviceName)
+		q = NewDeferredQueue(serviceName, cfg)
+		deferredQueues[serviceName] = q
+	}
+	return q
+}
+
+// Reserve takes a place in the queue before the file is delivered, it returns false if the queue is full so
+// the file is scanned before it's delivered
+func (q *DeferredQueue) Reserve() bool {
+	select {
+	case q.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Submit queues the scan of a file which has a reserved place, scan returns the verdict event of the scan. The
+// job is kept in the queue dir until the verdict is reported, so the file is scanned from the job after a restart
+func (q *DeferredQueue) Submit(job *Job, scan func() *events.VerdictEvent) {
+	task := &deferredTask{job: job, scan: scan}
+	if q.cfg.QueueDir != "" {
+		var err error
+		if task.path, err = q.persist(job); err != nil {
+			logging.Logger.Error(utils.PrepareLogMsg(job.ID, "couldn't keep the deferred scan in the queue dir, "+
+				"it's lost on restart: "+err.Error()))
+		}
+	}
+	q.tasks <- task
+}
+
+func (q *DeferredQueue) work() {
+	for task := range q.tasks {
+		q.run(task)
+		<-q.slots
+	}
+}
+
+// run scans the file of the task and reports its verdict, the job is removed from the queue dir afterwards
+func (q *DeferredQueue) run(task *deferredTask) {
+	var event *events.VerdictEvent
+	if task.scan != nil {
+		event = task.scan()
+	} else {
+		var err error
+		if event, err = q.scanner.Scan(task.job); err != nil {
+			logging.Logger.Error(utils.PrepareLogMsg(task.job.ID, "couldn't scan the restored deferred scan of "+
+				q.serviceName+": "+err.Error()))
+		}
+	}
+	if event != nil {
+		event.Delivered = true
+		q.report(event)
+	}
+	if task.path != "" {
+		os.Remove(task.path)
+	}
+}
+
+// report writes the verdict of a deferred scan in the audit log and posts it to the webhook
+func (q *DeferredQueue) report(event *events.VerdictEvent) {
+	logging.AuditLogger.Info("deferred_scan_verdict",
+		zap.String("x_icap_metadata", event.XICAPMetadata),
+		zap.String("service", event.ServiceName),
+		zap.String("verdict", event.Verdict),
+		zap.String("threat", event.Threat),
+		zap.String("url", event.RequestedURL),
+		zap.String("file_hash", event.FileHash),
+		zap.String("job_id", event.JobID),
+	)
+	if q.cfg.WebhookURL == "" {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		logging.Logger.Error(utils.PrepareLogMsg(event.XICAPMetadata, "couldn't encode the deferred scan verdict: "+
+			err.Error()))
+		return
+	}
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		if err = q.post(body); err == nil {
+			return
+		}
+		if attempt == webhookAttempts {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	logging.Logger.Error(utils.PrepareLogMsg(event.XICAPMetadata, "couldn't post the deferred scan verdict to the "+
+		"webhook of "+q.serviceName+": "+err.Error()))
+}
+
+func (q *DeferredQueue) post(body []byte) error {
+	resp, err := q.client.Post(q.cfg.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New("the webhook returned " + resp.Status)
+	}
+	return nil
+}
+
+// persist writes the job in <queue_dir>/<service>/<job id>.json, the write is atomic so a restart never reads
+// half of a job
+func (q *DeferredQueue) persist(job *Job) (string, error) {
+	dir := filepath.Join(q.cfg.QueueDir, q.serviceName)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Join(dir, job.ID+".json")
+	tmp, err := os.CreateTemp(dir, ".pending-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return name, nil
+}
+
+// restore queues the jobs which were pending in the queue dir, oldest first, it waits for a place in the queue
+// for each of them
+func (q *DeferredQueue) restore() {
+	dir := filepath.Join(q.cfg.QueueDir, q.serviceName)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logging.Logger.Error("couldn't read the deferred scans of " + q.serviceName + ": " + err.Error())
+		}
+		return
+	}
+	type pending struct {
+		path     string
+		modified time.Time
+	}
+	var jobs []pending
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		jobs = append(jobs, pending{filepath.Join(dir, entry.Name()), info.ModTime()})
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].modified.Before(jobs[b].modified) })
+	if len(jobs) != 0 {
+		logging.Logger.Info("scanning the " + strconv.Itoa(len(jobs)) + " deferred scans of " + q.serviceName +
+			" which were pending before the restart")
+	}
+	for _, p := range jobs {
+		data, err := os.ReadFile(p.path)
+		if err != nil {
+			logging.Logger.Error("couldn't read the deferred scan " + p.path + ": " + err.Error())
+			continue
+		}
+		job, err := Decode(data)
+		if err != nil {
+			logging.Logger.Error("dropping the deferred scan " + p.path + ": " + err.Error())
+			os.Remove(p.path)
+			continue
+		}
+		job.Service = q.serviceName
+		q.slots <- struct{}{}
+		q.tasks <- &deferredTask{job: job, path: p.path}
+	}
+}
+
+// DeferredJob returns the job of a file which is delivered before its verdict, the id of the job is the
+// X-ICAP-Metadata of the transaction
+func DeferredJob(serviceName, xICAPMetadata string, requestedURL *url.URL, body []byte) *Job {
+	job := &Job{ID: xICAPMetadata, Service: serviceName, Content: body}
+	if requestedURL == nil {
+		return job
+	}
+	if requestedURL.Scheme == "http" || requestedURL.Scheme == "https" {
+		job.URL = requestedURL.String()
+	} else {
+		job.FileName = path.Base(requestedURL.Path)
+	}
+	return job
+}
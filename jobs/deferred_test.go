@@ -0,0 +1,66 @@
+package jobs
+
+import (
+	"encoding/json"
+	"icapeg/config"
+	"icapeg/events"
+	"icapeg/logging"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestDeferredQueue(t *testing.T) {
+	logging.Logger = zap.NewNop()
+	verdicts := make(chan *events.VerdictEvent, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := &events.VerdictEvent{}
+		json.NewDecoder(r.Body).Decode(event)
+		verdicts <- event
+	}))
+	defer webhook.Close()
+
+	dir := t.TempDir()
+	q := NewDeferredQueue("clamav", &config.DeferredScanConfig{Workers: 1, QueueDir: dir, WebhookURL: webhook.URL,
+		WebhookTimeout: time.Second})
+	if !q.Reserve() {
+		t.Fatal("the queue should have a place for the first scan")
+	}
+	if q.Reserve() {
+		t.Fatal("the queue without queue_size should be full while its worker is busy")
+	}
+	kept := filepath.Join(dir, "clamav", "tx1.json")
+	scanning, finish := make(chan struct{}), make(chan struct{})
+	q.Submit(&Job{ID: "tx1", Service: "clamav", Content: []byte("EICAR")}, func() *events.VerdictEvent {
+		close(scanning)
+		<-finish
+		return &events.VerdictEvent{XICAPMetadata: "tx1", ServiceName: "clamav", Verdict: "malicious"}
+	})
+	<-scanning
+	if _, err := os.Stat(kept); err != nil {
+		t.Fatalf("the pending scan should be kept in the queue dir: %v", err)
+	}
+	close(finish)
+
+	select {
+	case event := <-verdicts:
+		if event.XICAPMetadata != "tx1" || event.Verdict != "malicious" || !event.Delivered {
+			t.Fatalf("unexpected verdict event %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the verdict wasn't posted to the webhook")
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(kept); os.IsNotExist(err) && q.Reserve() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the reported scan should leave the queue dir and free its place")
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package jobs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"icapeg/cache"
+	"icapeg/config"
+	utils "icapeg/consts"
+	"icapeg/events"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	"icapeg/service"
+	"icapeg/service/services-utilities/hotswap"
+	"icapeg/service/services-utilities/statistics"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// the host of the URL of the jobs which only have the content of the file, the services take the file name from
+// the path of the URL
+const jobHost = "scan-job.icapeg"
+
+// Job is a file which is scanned in the background by a service, ex: a file which a security automation
+// pulled from a mailbox. The file is the content or it's downloaded from the URL
+type Job struct {
+	ID       string `json:"id"`
+	Service  string `json:"service"`
+	URL      string `json:"url,omitempty"`
+	FileName string `json:"file_name,omitempty"`
+	Content  []byte `json:"content,omitempty"` // base64 in JSON
+}
+
+// Decode reads a job from JSON and checks it, a job which isn't valid is never scanned
+func Decode(data []byte) (*Job, error) {
+	job := &Job{}
+	if err := json.Unmarshal(data, job); err != nil {
+		return nil, err
+	}
+	if job.Service == "" {
+		return nil, errors.New("the job has no service")
+	}
+	if len(job.Content) == 0 && job.URL == "" {
+		return nil, errors.New("the job has neither content nor url")
+	}
+	if job.URL != "" {
+		if u, err := url.Parse(job.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, errors.New("the url of the job must be an http or https URL")
+		}
+	}
+	return job, nil
+}
+
+// Scanner scans the jobs with the services of config.toml file
+type Scanner struct {
+	client       *http.Client
+	blocklistTTL time.Duration
+}
+
+// NewScanner creates a scanner, the files of the jobs are downloaded with client and the hashes of the
+// malicious ones are blocked for blocklistTTL, zero doesn't block them
+func NewScanner(client *http.Client, blocklistTTL time.Duration) *Scanner {
+	return &Scanner{client: client, blocklistTTL: blocklistTTL}
+}
+
+// Scan scans the file of the job with its service in RESPMOD and returns the verdict event, which is also
+// published to the event sinks. An error is returned if the job couldn't be scanned
+func (s *Scanner) Scan(job *Job) (*events.VerdictEvent, error) {
+	serviceInstance, exists := config.App().ServicesInstances[job.Service]
+	if !exists {
+		return nil, errors.New("the service " + job.Service + " doesn't exist")
+	}
+	if !serviceInstance.RespMode {
+		return nil, errors.New("the service " + job.Service + " doesn't support RESPMOD")
+	}
+	xICAPMetadata := utils.NewTransactionID()
+	logging.Logger.Info(utils.PrepareLogMsg(xICAPMetadata, "scanning the job "+job.ID+" by "+job.Service))
+
+	httpMsg, body, err := s.httpMsg(job)
+	if err != nil {
+		return nil, err
+	}
+	service.InitServiceConfig(serviceInstance.Vendor, job.Service)
+	vendor := hotswap.Vendor(job.Service, serviceInstance.Vendor)
+	requiredService := service.GetService(vendor, job.Service, utils.ICAPModeResp, httpMsg, xICAPMetadata)
+	if requiredService == nil {
+		return nil, errors.New("the vendor " + vendor + " doesn't exist")
+	}
+	defer hotswap.Begin(job.Service, vendor)()
+	_, _, _, _, _, vendorMsgs := requiredService.Processing(false, textproto.MIMEHeader{})
+
+	fileDigests, _ := vendorMsgs[utils.VendorMsgFileDigests].(map[string]string)
+	event := &events.VerdictEvent{
+		XICAPMetadata: xICAPMetadata,
+		ServiceName:   job.Service,
+		Vendor:        vendor,
+		Method:        utils.ICAPModeResp,
+		Verdict:       statistics.VerdictClean,
+		RequestedURL:  httpMsg.Request.URL.String(),
+		FileName:      vendorMsg(vendorMsgs, utils.VendorMsgFileName),
+		FileHash:      vendorMsg(vendorMsgs, utils.VendorMsgFileHash),
+		FileDigests:   fileDigests,
+		FileSize:      vendorMsg(vendorMsgs, utils.VendorMsgFileSize),
+		Threat:        vendorMsg(vendorMsgs, utils.VendorMsgThreat),
+		JobID:         job.ID,
+	}
+	if event.FileHash == "" {
+		sum := sha256.Sum256(body)
+		event.FileHash = hex.EncodeToString(sum[:])
+	}
+	if vendorErr, failed := vendorMsgs[utils.VendorMsgError]; failed {
+		event.Verdict = statistics.VerdictError
+		events.VendorFailed(&events.VendorStateEvent{
+			XICAPMetadata: xICAPMetadata,
+			ServiceName:   job.Service,
+			Vendor:        vendor,
+			Error:         fmt.Sprint(vendorErr),
+		})
+	}
+	if vendorMsgs[utils.VendorMsgVerdict] == utils.SampleSeverityMalicious {
+		event.Verdict = statistics.VerdictMalicious
+		s.notifyDetection(event)
+	}
+	events.Publish(event)
+	return event, nil
+}
+
+// httpMsg builds the HTTP response which the service scans, the file is downloaded if the job doesn't have it
+func (s *Scanner) httpMsg(job *Job) (*http_message.HttpMsg, []byte, error) {
+	requestedURL := job.URL
+	if requestedURL == "" {
+		requestedURL = "http://" + jobHost + "/" + url.PathEscape(job.FileName)
+	}
+	req, err := http.NewRequest(http.MethodGet, requestedURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.RequestURI = requestedURL
+
+	body, contentType := job.Content, ""
+	if len(body) == 0 {
+		if body, contentType, err = s.download(req); err != nil {
+			return nil, nil, err
+		}
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	resp := &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+	resp.Header.Set(utils.ContentLength, strconv.Itoa(len(body)))
+	resp.Header.Set("Content-Type", contentType)
+	return &http_message.HttpMsg{Request: req, Response: resp}, body, nil
+}
+
+func (s *Scanner) download(req *http.Request) ([]byte, string, error) {
+	resp, err := s.client.Do(req.Clone(req.Context()))
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.New("downloading " + req.URL.String() + " returned " + resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	return body, resp.Header.Get("Content-Type"), err
+}
+
+// notifyDetection sends the malicious verdict to the alerters and blocks the file hash
+func (s *Scanner) notifyDetection(event *events.VerdictEvent) {
+	logging.Logger.Warn(utils.PrepareLogMsg(event.XICAPMetadata, "the file of the job "+event.JobID+
+		" was found malicious by "+event.ServiceName))
+	events.Detection(event)
+	if s.blocklistTTL > 0 {
+		cache.BlockHash(event.FileHash, event.ServiceName, event.Threat, s.blocklistTTL)
+	}
+}
+
+// vendorMsg returns a vendor message as a string, it's empty if the vendor didn't send the message
+func vendorMsg(vendorMsgs map[string]interface{}, key string) string {
+	value, exists := vendorMsgs[key]
+	if !exists || value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
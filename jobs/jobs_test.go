@@ -0,0 +1,24 @@
+package jobs
+
+import "testing"
+
+func TestDecode(t *testing.T) {
+	job, err := Decode([]byte(`{"id":"1","service":"clamav","file_name":"a.txt","content":"aGVsbG8="}`))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if string(job.Content) != "hello" || job.FileName != "a.txt" {
+		t.Fatalf("unexpected job %+v", job)
+	}
+
+	for _, data := range []string{
+		`{"id":"2","content":"aGVsbG8="}`,
+		`{"id":"3","service":"clamav"}`,
+		`{"id":"4","service":"clamav","url":"file:///etc/passwd"}`,
+		`not json`,
+	} {
+		if _, err = Decode([]byte(data)); err == nil {
+			t.Fatalf("expected an error for %s", data)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// the wait before connecting to RabbitMQ again after the connection was lost
+const retryDelay = 5 * time.Second
+
+// Consumer pulls the scan jobs from a RabbitMQ queue, prefetch jobs are scanned at a time. A job is acked once
+// it's scanned and the verdict event is sent to the reply_to queue of the job if it has one
+type Consumer struct {
+	url      string
+	queue    string
+	prefetch int
+	scanner  *Scanner
+}
+
+// InitJobs reads the optional [app.rabbitmq_jobs] section and starts pulling the scan jobs, the jobs are
+// never pulled if it doesn't exist
+func InitJobs() {
+	if !readValues.IsSecExists("app.rabbitmq_jobs") || !readValues.ReadValuesBool("app.rabbitmq_jobs.enabled") {
+		return
+	}
+	logging.Logger.Debug("loading RabbitMQ jobs configuration")
+	c := &Consumer{
+		url:      readValues.ReadValuesString("app.rabbitmq_jobs.url"),
+		queue:    readValues.ReadValuesString("app.rabbitmq_jobs.queue"),
+		prefetch: readValues.ReadValuesInt("app.rabbitmq_jobs.prefetch"),
+		scanner: NewScanner(&http.Client{
+			Timeout: readValues.ReadValuesDuration("app.rabbitmq_jobs.download_timeout") * time.Second,
+		}, readValues.ReadValuesDuration("app.rabbitmq_jobs.blocklist_ttl")*time.Second),
+	}
+	if c.prefetch <= 0 {
+		c.prefetch = 1
+	}
+	go c.run()
+}
+
+// run consumes the queue and connects again whenever the connection is lost
+func (c *Consumer) run() {
+	for {
+		if err := c.consume(); err != nil {
+			logging.Logger.Error("couldn't pull the scan jobs from RabbitMQ: " + err.Error())
+		}
+		time.Sleep(retryDelay)
+	}
+}
+
+func (c *Consumer) consume() error {
+	conn, err := amqp.Dial(c.url)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	if err = channel.Qos(c.prefetch, 0, false); err != nil {
+		return err
+	}
+	deliveries, err := channel.Consume(c.queue, "icapeg", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	logging.Logger.Info("pulling the scan jobs from the RabbitMQ queue " + c.queue)
+
+	var workers sync.WaitGroup
+	for n := 0; n < c.prefetch; n++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for delivery := range deliveries {
+				c.handle(channel, delivery)
+			}
+		}()
+	}
+	workers.Wait()
+	return amqp.ErrClosed
+}
+
+// handle scans the job of the delivery, the jobs which aren't valid are rejected and the jobs which couldn't be
+// scanned are queued again once
+func (c *Consumer) handle(channel *amqp.Channel, delivery amqp.Delivery) {
+	job, err := Decode(delivery.Body)
+	if err != nil {
+		logging.Logger.Error("rejecting the scan job " + delivery.MessageId + ": " + err.Error())
+		delivery.Nack(false, false)
+		return
+	}
+	if job.ID == "" {
+		job.ID = delivery.MessageId
+	}
+	event, err := c.scanner.Scan(job)
+	if err != nil {
+		logging.Logger.Error("couldn't scan the job " + job.ID + ", redelivered: " +
+			strconv.FormatBool(delivery.Redelivered) + ": " + err.Error())
+		delivery.Nack(false, !delivery.Redelivered)
+		return
+	}
+	if delivery.ReplyTo != "" {
+		c.reply(channel, delivery, event)
+	}
+	delivery.Ack(false)
+}
+
+// reply sends the verdict event to the reply_to queue of the job through the default exchange
+func (c *Consumer) reply(channel *amqp.Channel, delivery amqp.Delivery, event interface{}) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		logging.Logger.Error("couldn't encode the verdict of the scan job: " + err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = channel.PublishWithContext(ctx, "", delivery.ReplyTo, false, false, amqp.Publishing{
+		ContentType:   "application/json",
+		CorrelationId: delivery.CorrelationId,
+		Timestamp:     time.Now(),
+		Body:          data,
+	})
+	if err != nil {
+		logging.Logger.Error("couldn't send the verdict of the scan job to " + delivery.ReplyTo + ": " + err.Error())
+	}
+}
@@ -4,10 +4,50 @@ import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"os"
+	"path/filepath"
 )
 
 var Logger *zap.Logger
 
+// coreLevel is the level of the cores of Logger, it's lowered to the lowest service level if the services
+// have their own log levels
+var coreLevel = zap.NewAtomicLevel()
+
+// AccessLogger records one entry per ICAP transaction and AuditLogger records the changes which are made through
+// the admin API, they log nothing unless their outputs are configured
+var (
+	AccessLogger = zap.NewNop()
+	AuditLogger  = zap.NewNop()
+)
+
+// the destinations of a log stream
+const (
+	DestinationStdout = "stdout"
+	DestinationFile   = "file"
+	DestinationBoth   = "both"
+	DestinationSyslog = "syslog"
+)
+
+// the encoders of a log stream
+const (
+	EncoderJSON    = "json"
+	EncoderConsole = "console"
+)
+
+// Output is the destination and the encoder of a log stream, path is the file of the file destination and
+// fields maps the fields of the entries to the keys of the SIEM encoders. The file is rotated once it reaches
+// MaxSize bytes if MaxSize is set, and the syslog destination sends the entries to SyslogAddress
+type Output struct {
+	Destination    string
+	Path           string
+	Encoder        string
+	Fields         map[string]string
+	MaxSize        int64
+	MaxBackups     int
+	SyslogAddress  string
+	SyslogFacility string
+}
+
 func InitializeLogger(logLevel string, writeLogsToConsole bool) {
 	config := zap.NewProductionEncoderConfig()
 	config.EncodeTime = zapcore.ISO8601TimeEncoder
@@ -17,18 +57,104 @@ func InitializeLogger(logLevel string, writeLogsToConsole bool) {
 	logFile, _ := os.OpenFile("logs/logs.json", os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
 	writer := zapcore.AddSync(logFile)
 	defaultLogLevel, _ := zapcore.ParseLevel(logLevel)
+	coreLevel.SetLevel(defaultLogLevel)
 	var core zapcore.Core
 	if writeLogsToConsole {
 		consoleEncoder := zapcore.NewConsoleEncoder(config)
 		core = zapcore.NewTee(
-			zapcore.NewCore(fileEncoder, writer, defaultLogLevel),
-			zapcore.NewCore(consoleEncoder, zapcore.AddSync(os.Stdout), defaultLogLevel),
+			zapcore.NewCore(fileEncoder, writer, coreLevel),
+			zapcore.NewCore(consoleEncoder, zapcore.AddSync(os.Stdout), coreLevel),
 		)
 	} else {
 		core = zapcore.NewTee(
-			zapcore.NewCore(fileEncoder, writer, defaultLogLevel),
+			zapcore.NewCore(fileEncoder, writer, coreLevel),
 		)
 	}
 
 	Logger = zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel))
 }
+
+// InitializeOutputs replaces the debug log with its configured output and enables the access and audit logs
+// which have outputs, a nil output keeps the stream as it is
+func InitializeOutputs(debug, access, audit *Output) error {
+	if debug != nil {
+		core, err := newCore(*debug, coreLevel)
+		if err != nil {
+			return err
+		}
+		Logger = zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel))
+	}
+	for _, stream := range []struct {
+		output *Output
+		logger **zap.Logger
+	}{{access, &AccessLogger}, {audit, &AuditLogger}} {
+		if stream.output == nil {
+			continue
+		}
+		core, err := newCore(*stream.output, zapcore.InfoLevel)
+		if err != nil {
+			return err
+		}
+		*stream.logger = zap.New(core)
+	}
+	return nil
+}
+
+// NewCore returns the core which writes the entries of the info level and above to the output, ex: the syslog
+// sink of the event bus, its Write returns the error of the destination so the entry can be written again
+func NewCore(output Output) (zapcore.Core, error) {
+	return newCore(output, zapcore.InfoLevel)
+}
+
+// newCore returns the core which writes a log stream to its destinations with its encoder, the console
+// encoder colors the levels on stdout
+func newCore(output Output, level zapcore.LevelEnabler) (zapcore.Core, error) {
+	config := zap.NewProductionEncoderConfig()
+	config.EncodeTime = zapcore.ISO8601TimeEncoder
+	var cores []zapcore.Core
+	if output.Destination == DestinationFile || output.Destination == DestinationBoth {
+		if err := os.MkdirAll(filepath.Dir(output.Path), os.ModePerm); err != nil {
+			return nil, err
+		}
+		var writer zapcore.WriteSyncer
+		if output.MaxSize > 0 {
+			file, err := newRotatingFile(output.Path, output.MaxSize, output.MaxBackups)
+			if err != nil {
+				return nil, err
+			}
+			writer = file
+		} else {
+			logFile, err := os.OpenFile(output.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				return nil, err
+			}
+			writer = zapcore.AddSync(logFile)
+		}
+		cores = append(cores, zapcore.NewCore(newEncoder(output, config), writer, level))
+	}
+	if output.Destination == DestinationSyslog {
+		writer, err := newSyslogWriter(output.SyslogAddress, output.SyslogFacility)
+		if err != nil {
+			return nil, err
+		}
+		cores = append(cores, &syslogCore{LevelEnabler: level, encoder: newEncoder(output, config), writer: writer})
+	}
+	if output.Destination == DestinationStdout || output.Destination == DestinationBoth {
+		stdoutConfig := config
+		if output.Encoder == EncoderConsole {
+			stdoutConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		}
+		cores = append(cores, zapcore.NewCore(newEncoder(output, stdoutConfig), zapcore.AddSync(os.Stdout), level))
+	}
+	return zapcore.NewTee(cores...), nil
+}
+
+func newEncoder(output Output, config zapcore.EncoderConfig) zapcore.Encoder {
+	switch output.Encoder {
+	case EncoderConsole:
+		return zapcore.NewConsoleEncoder(config)
+	case EncoderCEF, EncoderLEEF:
+		return newSIEMEncoder(output.Encoder, output.Fields)
+	}
+	return zapcore.NewJSONEncoder(config)
+}
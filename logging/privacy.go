@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/textproto"
+	"strings"
+)
+
+// the privacy modes of the client identifiers in the logs
+const (
+	PrivacyModeHash     = "hash"
+	PrivacyModeTruncate = "truncate"
+)
+
+// the headers which have the IP addresses and the usernames of the HTTP clients
+var (
+	clientIPHeaders = map[string]bool{"X-Client-Ip": true, "X-Forwarded-For": true}
+	usernameHeaders = map[string]bool{"X-Client-Username": true, "X-Authenticated-User": true}
+)
+
+var (
+	privacyMode string
+	privacySalt []byte
+)
+
+// InitPrivacy enables the privacy mode of the client identifiers in the logs, the salt is per deployment
+// so the pseudonyms of a client can be correlated in the logs of a deployment only
+func InitPrivacy(mode, salt string) {
+	privacyMode = mode
+	privacySalt = []byte(salt)
+}
+
+// pseudonym returns the beginning of the salted digest of the value
+func pseudonym(value string) string {
+	mac := hmac.New(sha256.New, privacySalt)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// ClientIP returns the IP address of the client as it's logged, it's hashed in the hash mode and its host part
+// is removed in the truncate mode (/24 of IPv4 and /48 of IPv6)
+func ClientIP(ip string) string {
+	ip = strings.TrimSpace(ip)
+	if privacyMode == "" || ip == "" {
+		return ip
+	}
+	if privacyMode == PrivacyModeTruncate {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			if v4 := parsed.To4(); v4 != nil {
+				return v4.Mask(net.CIDRMask(24, 32)).String()
+			}
+			return parsed.Mask(net.CIDRMask(48, 128)).String()
+		}
+	}
+	return "ip:" + pseudonym(ip)
+}
+
+// Username returns the username of the client as it's logged, it's hashed in both modes because
+// a truncated username still identifies the client
+func Username(username string) string {
+	if privacyMode == "" || username == "" {
+		return username
+	}
+	return "user:" + pseudonym(username)
+}
+
+// pseudonymizeHeader returns the value of the header with its client identifiers pseudonymized
+func pseudonymizeHeader(header, value string) string {
+	if privacyMode == "" {
+		return value
+	}
+	header = textproto.CanonicalMIMEHeaderKey(header)
+	if usernameHeaders[header] {
+		return Username(value)
+	}
+	if !clientIPHeaders[header] {
+		return value
+	}
+	ips := strings.Split(value, ",")
+	for i := range ips {
+		ips[i] = ClientIP(ips[i])
+	}
+	return strings.Join(ips, ", ")
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/textproto"
+)
+
+// the prefix of the digests which replace the values of the redacted headers
+const redactedPrefix = "redacted:hmac-sha256:"
+
+// the headers whose values are replaced by their digests in the logs, the keys are canonical header names
+var redactedHeaders map[string]bool
+
+var (
+	// the key of the digests, so the low-entropy credentials (ex: basic auth) can't be recovered from the logs
+	// by a dictionary attack
+	redactionKey []byte
+	// the key of the deployments which have none, the digests are correlated until the process restarts only
+	processKey = newProcessKey()
+)
+
+func newProcessKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("couldn't generate the key of the redacted headers: " + err.Error())
+	}
+	return key
+}
+
+// InitRedaction sets the headers whose values are redacted from the logs and the secret key of their digests,
+// the header names are case-insensitive. A random key is used if key is empty
+func InitRedaction(headers []string, key string) {
+	redactedHeaders = make(map[string]bool)
+	for _, header := range headers {
+		redactedHeaders[textproto.CanonicalMIMEHeaderKey(header)] = true
+	}
+	redactionKey = processKey
+	if key != "" {
+		redactionKey = []byte(key)
+	}
+}
+
+// IsRedacted reports whether the values of the header are redacted from the logs
+func IsRedacted(header string) bool {
+	return redactedHeaders[textproto.CanonicalMIMEHeaderKey(header)]
+}
+
+// RedactHeader returns the value of the header as it's logged, the value of a redacted header is replaced with
+// the beginning of its HMAC-SHA256 digest, so the same credential or cookie can still be correlated across log
+// lines, and the client identifiers are pseudonymized in the privacy mode
+func RedactHeader(header, value string) string {
+	if !IsRedacted(header) {
+		return pseudonymizeHeader(header, value)
+	}
+	mac := hmac.New(sha256.New, redactionKey)
+	mac.Write([]byte(value))
+	return redactedPrefix + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// RedactHeaderValues returns the values of the header as they are logged
+func RedactHeaderValues(header string, values []string) []string {
+	if !IsRedacted(header) && privacyMode == "" {
+		return values
+	}
+	redacted := make([]string, len(values))
+	for i, value := range values {
+		redacted[i] = RedactHeader(header, value)
+	}
+	return redacted
+}
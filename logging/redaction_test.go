@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactHeader(t *testing.T) {
+	InitRedaction([]string{"authorization", "X-API-Key"}, "deployment-key")
+	defer InitRedaction(nil, "")
+
+	redacted := RedactHeader("Authorization", "Bearer secret")
+	if !strings.HasPrefix(redacted, redactedPrefix) || strings.Contains(redacted, "secret") {
+		t.Fatalf("expected a digest, got %q", redacted)
+	}
+	if RedactHeader("AUTHORIZATION", "Bearer secret") != redacted {
+		t.Fatalf("the same value should have the same digest whatever the case of the header name is")
+	}
+	if values := RedactHeaderValues("x-api-key", []string{"a", "b"}); values[0] == "a" || values[0] == values[1] {
+		t.Fatalf("every value should be replaced by its own digest, got %v", values)
+	}
+	if RedactHeader("Content-Type", "text/html") != "text/html" {
+		t.Fatalf("the other headers shouldn't be redacted")
+	}
+	InitRedaction([]string{"Authorization"}, "")
+	if RedactHeader("Authorization", "Bearer secret") == redacted {
+		t.Fatalf("the digests should depend on the key")
+	}
+}
+
+func TestPrivacyMode(t *testing.T) {
+	InitPrivacy(PrivacyModeTruncate, "deployment-salt")
+	defer InitPrivacy("", "")
+
+	if ip := RedactHeader("X-Client-IP", "192.168.10.23"); ip != "192.168.10.0" {
+		t.Fatalf("expected the /24 of the IPv4 address, got %q", ip)
+	}
+	if ip := ClientIP("2001:db8:1:2::5"); ip != "2001:db8:1::" {
+		t.Fatalf("expected the /48 of the IPv6 address, got %q", ip)
+	}
+	if values := RedactHeaderValues("X-Forwarded-For", []string{"10.1.2.3, 10.4.5.6"}); values[0] != "10.1.2.0, 10.4.5.0" {
+		t.Fatalf("every address of X-Forwarded-For should be truncated, got %v", values)
+	}
+	user := RedactHeader("X-Authenticated-User", "alice")
+	if !strings.HasPrefix(user, "user:") || user != Username("alice") {
+		t.Fatalf("the username should be hashed, got %q", user)
+	}
+	InitPrivacy(PrivacyModeHash, "another-salt")
+	if Username("alice") == user {
+		t.Fatalf("the pseudonyms should depend on the salt")
+	}
+	if ip := ClientIP("192.168.10.23"); !strings.HasPrefix(ip, "ip:") {
+		t.Fatalf("the IP address should be hashed, got %q", ip)
+	}
+}
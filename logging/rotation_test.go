@@ -0,0 +1,29 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.json")
+	file, err := newRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err = file.Write([]byte(entry)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, expected := range map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"} {
+		content, err := os.ReadFile(name)
+		if err != nil || string(content) != expected {
+			t.Fatalf("%s should have %q, got %q (%v)", name, expected, content, err)
+		}
+	}
+	if _, err = os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatal("the oldest file should be removed")
+	}
+}
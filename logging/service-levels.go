@@ -0,0 +1,65 @@
+package logging
+
+import (
+	utils "icapeg/consts"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// the beginning of the messages which are prepared by PrepareLogMsg and PrepareEventLogMsg
+const transactionMsgPrefix = `{"X-ICAP-Metadata":"`
+
+// serviceLevelCore filters the logs of the transactions by the log levels of their services,
+// the other logs are filtered by the log level of the app
+type serviceLevelCore struct {
+	zapcore.Core
+	level         zapcore.Level
+	serviceLevels map[string]zapcore.Level
+}
+
+// InitServiceLevels makes the logs of the transactions of the services follow the log levels of the services,
+// ex: debug for a service which is being onboarded and warn for the rest
+func InitServiceLevels(logLevel string, serviceLevels map[string]zapcore.Level) {
+	if len(serviceLevels) == 0 {
+		return
+	}
+	level, _ := zapcore.ParseLevel(logLevel)
+	lowest := level
+	for _, serviceLevel := range serviceLevels {
+		if serviceLevel < lowest {
+			lowest = serviceLevel
+		}
+	}
+	coreLevel.SetLevel(lowest)
+	Logger = Logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &serviceLevelCore{Core: core, level: level, serviceLevels: serviceLevels}
+	}))
+}
+
+func (c *serviceLevelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &serviceLevelCore{Core: c.Core.With(fields), level: c.level, serviceLevels: c.serviceLevels}
+}
+
+func (c *serviceLevelCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if entry.Level < c.levelOf(entry.Message) {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
+
+// levelOf returns the log level of the service of the transaction which the message belongs to
+func (c *serviceLevelCore) levelOf(msg string) zapcore.Level {
+	if !strings.HasPrefix(msg, transactionMsgPrefix) {
+		return c.level
+	}
+	xICAPMetadata := msg[len(transactionMsgPrefix):]
+	if end := strings.IndexByte(xICAPMetadata, '"'); end >= 0 {
+		xICAPMetadata = xICAPMetadata[:end]
+	}
+	if level, exists := c.serviceLevels[utils.TransactionService(xICAPMetadata)]; exists {
+		return level
+	}
+	return c.level
+}
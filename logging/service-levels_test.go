@@ -0,0 +1,33 @@
+package logging
+
+import (
+	utils "icapeg/consts"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestServiceLevels(t *testing.T) {
+	original := Logger
+	defer func() { Logger = original; coreLevel.SetLevel(zapcore.InfoLevel) }()
+	core, logs := observer.New(coreLevel)
+	Logger = zap.New(core)
+	InitServiceLevels("info", map[string]zapcore.Level{"clamav": zapcore.DebugLevel, "echo": zapcore.WarnLevel})
+
+	utils.SetTransactionService("onboarding", "clamav")
+	utils.SetTransactionService("quiet", "echo")
+	defer utils.ForgetTransaction("onboarding")
+	defer utils.ForgetTransaction("quiet")
+
+	Logger.Debug(utils.PrepareLogMsg("onboarding", "kept, clamav logs debug"))
+	Logger.Info(utils.PrepareLogMsg("quiet", "dropped, echo logs warn"))
+	Logger.Warn(utils.PrepareLogMsg("quiet", "kept, it's a warning"))
+	Logger.Debug("dropped, the app logs info")
+	Logger.Info(utils.PrepareLogMsg("unknown", "kept, the app logs info"))
+
+	if logs.Len() != 3 {
+		t.Fatalf("expected 3 logs, got %d: %v", logs.Len(), logs.All())
+	}
+}
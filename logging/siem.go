@@ -0,0 +1,171 @@
+package logging
+
+import (
+	"fmt"
+	"icapeg/version"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// the SIEM encoders of a log stream, ArcSight reads CEF and QRadar reads LEEF
+const (
+	EncoderCEF  = "cef"
+	EncoderLEEF = "leef"
+)
+
+// the keys of the SIEM formats which the fields of the access and audit logs have by default, the fields
+// which aren't mapped keep their names
+var (
+	defaultCEFFields = map[string]string{
+		"X-ICAP-Metadata": "externalId",
+		"client_ip":       "src",
+		"username":        "suser",
+		"url":             "request",
+		"method":          "requestMethod",
+		"service":         "cs1",
+		"threat":          "cs2",
+		"verdict":         "outcome",
+		"status":          "cn1",
+		"duration_ms":     "cn2",
+		"remote_addr":     "shost",
+		"path":            "request",
+	}
+	defaultLEEFFields = map[string]string{
+		"X-ICAP-Metadata": "externalId",
+		"client_ip":       "src",
+		"username":        "usrName",
+		"url":             "url",
+		"method":          "requestMethod",
+		"service":         "service",
+		"threat":          "threat",
+		"verdict":         "cat",
+		"status":          "status",
+		"duration_ms":     "duration",
+		"remote_addr":     "src",
+		"path":            "url",
+	}
+)
+
+var siemBufferPool = buffer.NewPool()
+
+// siemEncoder encodes every entry as a CEF or LEEF line, the message of the entry is the event id and the
+// verdict gives the severity
+type siemEncoder struct {
+	*zapcore.MapObjectEncoder
+	format string
+	fields map[string]string
+}
+
+// newSIEMEncoder returns the encoder of the format, fields maps the fields of the entries to the keys of the
+// format over the default mapping, an empty key drops the field. The names of the fields are case insensitive
+// like the keys of config.toml file
+func newSIEMEncoder(format string, fields map[string]string) zapcore.Encoder {
+	mapping := defaultCEFFields
+	if format == EncoderLEEF {
+		mapping = defaultLEEFFields
+	}
+	merged := make(map[string]string, len(mapping)+len(fields))
+	for field, key := range mapping {
+		merged[strings.ToLower(field)] = key
+	}
+	for field, key := range fields {
+		merged[strings.ToLower(field)] = key
+	}
+	return &siemEncoder{MapObjectEncoder: zapcore.NewMapObjectEncoder(), format: format, fields: merged}
+}
+
+func (e *siemEncoder) Clone() zapcore.Encoder {
+	clone := &siemEncoder{MapObjectEncoder: zapcore.NewMapObjectEncoder(), format: e.format, fields: e.fields}
+	for key, value := range e.MapObjectEncoder.Fields {
+		clone.MapObjectEncoder.Fields[key] = value
+	}
+	return clone
+}
+
+func (e *siemEncoder) EncodeEntry(entry zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	values := e.Clone().(*siemEncoder).MapObjectEncoder
+	for _, field := range fields {
+		field.AddTo(values)
+	}
+	severity := 3
+	switch fmt.Sprint(values.Fields["verdict"]) {
+	case "malicious":
+		severity = 8
+	case "error":
+		severity = 5
+	}
+	name := entry.Message
+	if threat, exists := values.Fields["threat"]; exists && threat != "" {
+		name = fmt.Sprint(threat)
+	}
+
+	// the extension keys are sorted, so the lines of the same event always look the same
+	fieldNames := make([]string, 0, len(values.Fields))
+	for field := range values.Fields {
+		fieldNames = append(fieldNames, field)
+	}
+	sort.Strings(fieldNames)
+
+	line := siemBufferPool.Get()
+	if e.format == EncoderLEEF {
+		line.AppendString("LEEF:1.0|ICAPeg|ICAPeg|" + leefHeader(version.Version) + "|" + leefHeader(entry.Message) + "|")
+		line.AppendString("devTime=" + entry.Time.Format("2006-01-02T15:04:05.000-0700") +
+			"\tdevTimeFormat=yyyy-MM-dd'T'HH:mm:ss.SSSZ\tsev=" + strconv.Itoa(severity))
+		for _, field := range fieldNames {
+			if key := e.key(field); key != "" {
+				line.AppendString("\t" + key + "=" + leefValue(fmt.Sprint(values.Fields[field])))
+			}
+		}
+	} else {
+		line.AppendString("CEF:0|ICAPeg|ICAPeg|" + cefHeader(version.Version) + "|" + cefHeader(entry.Message) + "|" +
+			cefHeader(name) + "|" + strconv.Itoa(severity) + "|")
+		line.AppendString("rt=" + strconv.FormatInt(entry.Time.UnixMilli(), 10))
+		for _, field := range fieldNames {
+			key := e.key(field)
+			if key == "" {
+				continue
+			}
+			line.AppendString(" " + key + "=" + cefValue(fmt.Sprint(values.Fields[field])))
+			// the custom keys of CEF are labeled with the names of the fields
+			if len(key) == 3 && (strings.HasPrefix(key, "cs") || strings.HasPrefix(key, "cn")) {
+				line.AppendString(" " + key + "Label=" + cefValue(field))
+			}
+		}
+	}
+	line.AppendString(zapcore.DefaultLineEnding)
+	return line, nil
+}
+
+// key returns the key of the field in the format, the characters which the formats don't allow in the keys
+// are removed from the names of the fields which aren't mapped
+func (e *siemEncoder) key(field string) string {
+	if key, mapped := e.fields[strings.ToLower(field)]; mapped {
+		return key
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return -1
+	}, field)
+}
+
+func cefHeader(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "|", `\|`, "\n", " ", "\r", " ").Replace(value)
+}
+
+func cefValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, "\n", `\n`, "\r", `\r`).Replace(value)
+}
+
+func leefHeader(value string) string {
+	return strings.NewReplacer("|", " ", "\t", " ", "\n", " ", "\r", " ").Replace(value)
+}
+
+func leefValue(value string) string {
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(value)
+}
@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestSIEMEncoders(t *testing.T) {
+	entry := zapcore.Entry{Message: "icap_transaction", Time: time.Unix(1700000000, 0)}
+	fields := []zapcore.Field{
+		zap.String("client_ip", "10.0.0.7"),
+		zap.String("url", "http://example.com/a=b"),
+		zap.String("service", "clamav"),
+		zap.String("verdict", "malicious"),
+		zap.String("threat", "Eicar|Test"),
+		zap.String("icap_client", "10.0.0.1:5555"),
+	}
+
+	line, err := newSIEMEncoder(EncoderCEF, map[string]string{"icap_client": "dvc", "x-icap-metadata": ""}).
+		EncodeEntry(entry, append(fields, zap.String("X-ICAP-Metadata", "abc")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cef := line.String()
+	for _, part := range []string{"CEF:0|ICAPeg|ICAPeg|", "|icap_transaction|Eicar\\|Test|8|rt=1700000000000",
+		" src=10.0.0.7", " request=http://example.com/a\\=b", " cs1=clamav cs1Label=service", " dvc=10.0.0.1:5555"} {
+		if !strings.Contains(cef, part) {
+			t.Fatalf("%q doesn't have %q", cef, part)
+		}
+	}
+	if strings.Contains(cef, "abc") {
+		t.Fatalf("the dropped field is in %q", cef)
+	}
+
+	line, err = newSIEMEncoder(EncoderLEEF, nil).EncodeEntry(entry, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leef := line.String()
+	for _, part := range []string{"LEEF:1.0|ICAPeg|ICAPeg|", "|icap_transaction|devTime=", "\tsev=8", "\tsrc=10.0.0.7",
+		"\tcat=malicious", "\ticap_client=10.0.0.1:5555"} {
+		if !strings.Contains(leef, part) {
+			t.Fatalf("%q doesn't have %q", leef, part)
+		}
+	}
+}
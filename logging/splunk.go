@@ -0,0 +1,241 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// the longest wait between the retries of a batch while Splunk is busy or unreachable
+const maxSplunkRetryDelay = time.Minute
+
+// SplunkConfig is the HTTP Event Collector which gets the access and audit logs
+type SplunkConfig struct {
+	URL           string // the collector endpoint, ex: https://splunk:8088/services/collector/event
+	Token         string
+	Index         string
+	Host          string
+	Streams       []string // access, audit or both
+	BatchSize     int
+	FlushInterval time.Duration
+	BufferSize    int
+	Timeout       time.Duration
+}
+
+// splunkSender batches the events of the access and audit logs and sends them to the collector, the events are
+// kept while the collector is busy and the new ones are dropped once the buffer is full
+type splunkSender struct {
+	cfg    SplunkConfig
+	client *http.Client
+	wake   chan struct{}
+
+	mu          sync.Mutex
+	pending     [][]byte
+	dropped     int
+	lastDropLog time.Time
+}
+
+// splunkCore is the zap core of a log stream which is sent to the collector
+type splunkCore struct {
+	zapcore.LevelEnabler
+	sender *splunkSender
+	source string
+	fields []zapcore.Field
+}
+
+// SplunkHEC sends the access and audit logs which are listed in the configuration to the collector too,
+// they are kept in their configured outputs
+func SplunkHEC(cfg SplunkConfig) error {
+	sender, err := newSplunkSender(cfg)
+	if err != nil {
+		return err
+	}
+	for _, stream := range cfg.Streams {
+		switch stream {
+		case "access":
+			AccessLogger = zap.New(zapcore.NewTee(AccessLogger.Core(), sender.core("icapeg:access")))
+		case "audit":
+			AuditLogger = zap.New(zapcore.NewTee(AuditLogger.Core(), sender.core("icapeg:audit")))
+		default:
+			return errors.New("unknown log stream " + stream + ", the streams are access and audit")
+		}
+	}
+	go sender.flushLoop()
+	return nil
+}
+
+func newSplunkSender(cfg SplunkConfig) (*splunkSender, error) {
+	if cfg.URL == "" || cfg.Token == "" {
+		return nil, errors.New("url and token are required")
+	}
+	if cfg.BatchSize <= 0 || cfg.BufferSize < cfg.BatchSize {
+		return nil, errors.New("batch_size must be positive and buffer_size can't be less than batch_size")
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.Host == "" {
+		cfg.Host, _ = os.Hostname()
+	}
+	return &splunkSender{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}, wake: make(chan struct{}, 1)}, nil
+}
+
+func (s *splunkSender) core(source string) *splunkCore {
+	return &splunkCore{LevelEnabler: zapcore.InfoLevel, sender: s, source: source}
+}
+
+func (c *splunkCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(append([]zapcore.Field(nil), c.fields...), fields...)
+	return &clone
+}
+
+func (c *splunkCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+// Write encodes the entry as a collector event, the fields of the entry are the fields of the event
+func (c *splunkCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	encoder := zapcore.NewMapObjectEncoder()
+	for _, field := range append(c.fields, fields...) {
+		field.AddTo(encoder)
+	}
+	encoder.Fields["msg"] = entry.Message
+	event, err := json.Marshal(map[string]interface{}{
+		"time":       float64(entry.Time.UnixNano()) / float64(time.Second),
+		"host":       c.sender.cfg.Host,
+		"source":     c.source,
+		"sourcetype": "_json",
+		"index":      c.sender.cfg.Index,
+		"event":      encoder.Fields,
+	})
+	if err != nil {
+		return err
+	}
+	c.sender.add(event)
+	return nil
+}
+
+func (c *splunkCore) Sync() error {
+	return nil
+}
+
+// add buffers the event, the event is dropped if the buffer is full, the drops are logged once a minute
+func (s *splunkSender) add(event []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) >= s.cfg.BufferSize {
+		s.dropped++
+		if time.Since(s.lastDropLog) >= time.Minute {
+			Logger.Warn("the Splunk buffer is full, " + strconv.Itoa(s.dropped) + " log events were dropped")
+			s.dropped, s.lastDropLog = 0, time.Now()
+		}
+		return
+	}
+	s.pending = append(s.pending, event)
+	if len(s.pending) >= s.cfg.BatchSize {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// flushLoop sends the buffered events every flush interval or once a batch is full, the wait between the
+// retries doubles while the collector is busy or unreachable, the collector may ask for a longer one
+func (s *splunkSender) flushLoop() {
+	retryDelay := time.Duration(0)
+	for {
+		if retryDelay > 0 {
+			time.Sleep(retryDelay)
+		} else {
+			select {
+			case <-s.wake:
+			case <-time.After(s.cfg.FlushInterval):
+			}
+		}
+		retryAfter, err := s.flush()
+		if err == nil {
+			retryDelay = 0
+			continue
+		}
+		Logger.Error("couldn't send the log events to Splunk, retrying: " + err.Error())
+		switch {
+		case retryAfter > 0:
+			retryDelay = retryAfter
+		case retryDelay == 0:
+			retryDelay = time.Second
+		default:
+			retryDelay *= 2
+		}
+		if retryDelay > maxSplunkRetryDelay {
+			retryDelay = maxSplunkRetryDelay
+		}
+	}
+}
+
+// flush sends the buffered events in batches, a batch which the collector couldn't take stays in the buffer
+// and the wait which the collector asked for is returned with the error
+func (s *splunkSender) flush() (time.Duration, error) {
+	for {
+		s.mu.Lock()
+		n := len(s.pending)
+		if n > s.cfg.BatchSize {
+			n = s.cfg.BatchSize
+		}
+		batch := s.pending[:n:n]
+		s.mu.Unlock()
+		if n == 0 {
+			return 0, nil
+		}
+		retryAfter, err := s.send(batch)
+		if err != nil {
+			return retryAfter, err
+		}
+		s.mu.Lock()
+		s.pending = s.pending[n:]
+		s.mu.Unlock()
+	}
+}
+
+// send posts the batch, an error is returned only if the batch must be sent again
+func (s *splunkSender) send(batch [][]byte) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, s.cfg.URL, bytes.NewReader(bytes.Join(batch, []byte("\n"))))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Splunk "+s.cfg.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		// 503 is returned while the indexers are busy or the queue of the collector is full
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return time.Duration(retryAfter) * time.Second, errors.New("the collector returned status code " +
+			strconv.Itoa(resp.StatusCode))
+	default:
+		// the batch itself is rejected, ex: the token isn't valid, sending it again doesn't help
+		Logger.Error("Splunk rejected " + strconv.Itoa(len(batch)) + " log events with status code " +
+			strconv.Itoa(resp.StatusCode) + ", they are dropped: " + string(body))
+		return 0, nil
+	}
+}
@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestSplunkSender(t *testing.T) {
+	Logger = zap.NewNop()
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Splunk secret" {
+			t.Errorf("unexpected authorization %q", r.Header.Get("Authorization"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	sender, err := newSplunkSender(SplunkConfig{URL: server.URL, Token: "secret", Index: "security", Host: "gw-1",
+		BatchSize: 2, BufferSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.New(sender.core("icapeg:access"))
+	for _, service := range []string{"clamav", "echo", "hashlookup", "dropped"} {
+		logger.Info("icap_transaction", zap.String("service", service), zap.Int("status", 204))
+	}
+
+	retryAfter, err := sender.flush()
+	if err == nil || retryAfter != 7*time.Second {
+		t.Fatalf("expected the busy collector to ask for 7s, got %v %v", retryAfter, err)
+	}
+	if _, err = sender.flush(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(bodies) != 3 || bodies[1] != bodies[0] || strings.Count(bodies[1], "\n") != 1 {
+		t.Fatalf("expected the first batch twice and then the rest, got %q", bodies)
+	}
+	if !strings.Contains(bodies[1], `"source":"icapeg:access"`) || !strings.Contains(bodies[1], `"service":"clamav"`) ||
+		!strings.Contains(bodies[1], `"index":"security"`) || !strings.Contains(bodies[2], `"service":"hashlookup"`) ||
+		strings.Contains(bodies[2], "dropped") {
+		t.Fatalf("unexpected events %q", bodies)
+	}
+}
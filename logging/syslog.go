@@ -0,0 +1,137 @@
+package logging
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// the facilities of the syslog destination
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "authpriv": 10,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogWriter sends the entries of a log stream to a syslog server as RFC 5424 messages, over udp, tcp
+// (one message per line) or a unix socket like /dev/log
+type syslogWriter struct {
+	mu       sync.Mutex
+	network  string
+	address  string
+	facility int
+	hostname string
+	conn     net.Conn
+}
+
+// syslogCore is the zap core of a log stream which is sent to syslog, the level of an entry is the severity
+// of its message
+type syslogCore struct {
+	zapcore.LevelEnabler
+	encoder zapcore.Encoder
+	writer  *syslogWriter
+}
+
+// newSyslogWriter parses the address of the server (udp://host:514, tcp://host:601 or unix:///dev/log)
+func newSyslogWriter(address, facility string) (*syslogWriter, error) {
+	network, addr, found := strings.Cut(address, "://")
+	if !found || addr == "" {
+		return nil, errors.New("the syslog address " + address + " must be udp://host:port, tcp://host:port or unix://path")
+	}
+	switch network {
+	case "udp", "tcp":
+	case "unix":
+		network = "unixgram"
+	default:
+		return nil, errors.New("the syslog network " + network + " must be udp, tcp or unix")
+	}
+	if facility == "" {
+		facility = "local0"
+	}
+	code, exists := syslogFacilities[facility]
+	if !exists {
+		return nil, errors.New("unknown syslog facility " + facility)
+	}
+	hostname, _ := os.Hostname()
+	return &syslogWriter{network: network, address: addr, facility: code, hostname: hostname}, nil
+}
+
+func (c *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.encoder = c.encoder.Clone()
+	for _, field := range fields {
+		field.AddTo(clone.encoder)
+	}
+	return &clone
+}
+
+func (c *syslogCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *syslogCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.encoder.EncodeEntry(entry, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+	return c.writer.send(syslogSeverity(entry.Level), entry.Time, strings.TrimRight(buf.String(), "\n"))
+}
+
+func (c *syslogCore) Sync() error {
+	return nil
+}
+
+// syslogSeverity maps the zap levels to the syslog severities
+func syslogSeverity(level zapcore.Level) int {
+	switch level {
+	case zapcore.DebugLevel:
+		return 7
+	case zapcore.InfoLevel:
+		return 6
+	case zapcore.WarnLevel:
+		return 4
+	case zapcore.ErrorLevel:
+		return 3
+	}
+	return 2
+}
+
+// format returns the RFC 5424 message of an entry
+func (w *syslogWriter) format(severity int, t time.Time, msg string) string {
+	return "<" + strconv.Itoa(w.facility*8+severity) + ">1 " + t.Format(time.RFC3339Nano) + " " + w.hostname +
+		" icapeg " + strconv.Itoa(os.Getpid()) + " - - " + msg
+}
+
+// send writes the message to the server, it connects again once if the connection was lost
+func (w *syslogWriter) send(severity int, t time.Time, msg string) error {
+	message := w.format(severity, t, msg)
+	if w.network == "tcp" {
+		message += "\n"
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			if w.conn, err = net.DialTimeout(w.network, w.address, 5*time.Second); err != nil {
+				w.conn = nil
+				continue
+			}
+		}
+		if _, err = w.conn.Write([]byte(message)); err == nil {
+			return nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	return err
+}
@@ -0,0 +1,42 @@
+package logging
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestSyslogCore(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	writer, err := newSyslogWriter("udp://"+server.LocalAddr().String(), "local3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	core := &syslogCore{LevelEnabler: zapcore.InfoLevel, encoder: zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "msg"}),
+		writer: writer}
+	zap.New(core).Warn("icap_transaction", zap.String("verdict", "malicious"))
+
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := string(buf[:n])
+	// local3 (19) * 8 + warning (4)
+	if !strings.HasPrefix(message, "<156>1 ") || !strings.HasSuffix(message, ` - - {"msg":"icap_transaction","verdict":"malicious"}`) {
+		t.Fatalf("unexpected syslog message %q", message)
+	}
+
+	if _, err = newSyslogWriter("localhost:514", ""); err == nil {
+		t.Fatal("an address without a network should be rejected")
+	}
+}
@@ -1,11 +1,29 @@
 package main
 
 import (
+	"fmt"
+	"icapeg/mockvendor"
+	"icapeg/recording"
 	"icapeg/server"
+	"icapeg/version"
+	"os"
 )
 
 func main() {
 
+	// icapeg replay [-addr host:port] <file.icap|dir>... re-sends the recorded ICAP transactions
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(recording.Replay(os.Args[2:]))
+	}
+	// icapeg mockvendor [-addr host:port] [-script file.json] runs a vendor API with scripted verdicts for the tests
+	if len(os.Args) > 1 && os.Args[1] == "mockvendor" {
+		os.Exit(mockvendor.Run(os.Args[2:]))
+	}
+	// icapeg version prints the build of the binary
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		fmt.Println(version.String())
+		return
+	}
 	server.StartServer()
 
 }
@@ -0,0 +1,37 @@
+package mockvendor
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Run runs the mock vendor until it fails, args are the arguments of "icapeg mockvendor"
+func Run(args []string) int {
+	flags := flag.NewFlagSet("mockvendor", flag.ContinueOnError)
+	addr := flags.String("addr", "localhost:9090", "the address which the mock vendor listens on")
+	scriptFile := flags.String("script", "", "the JSON script of the verdicts, empty = EICAR is malicious only")
+	apiKey := flags.String("api-key", "", "the API key which the calls must send, empty = no API key")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: icapeg mockvendor [-addr host:port] [-script file.json] [-api-key key]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	script := DefaultScript()
+	if *scriptFile != "" {
+		var err error
+		if script, err = LoadScript(*scriptFile); err != nil {
+			fmt.Fprintln(os.Stderr, "couldn't load the script:", err)
+			return 2
+		}
+	}
+	fmt.Printf("the mock vendor listens on %s with %d rules\n", *addr, len(script.Rules))
+	if err := http.ListenAndServe(*addr, New(script, *apiKey)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,148 @@
+package mockvendor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func post(t *testing.T, url, contentType string, body io.Reader) (*http.Response, Result) {
+	t.Helper()
+	resp, err := http.Post(url, contentType, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result Result
+	json.NewDecoder(resp.Body).Decode(&result)
+	return resp, result
+}
+
+func TestScan(t *testing.T) {
+	srv := httptest.NewServer(New(nil, ""))
+	defer srv.Close()
+
+	_, result := post(t, srv.URL+"/scan", "application/octet-stream", strings.NewReader("hello"))
+	if result.Verdict != VerdictClean {
+		t.Fatalf("a file which matches no rule should be clean, got %+v", result)
+	}
+
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	part, _ := w.CreateFormFile("file", "eicar.com")
+	part.Write([]byte(eicar))
+	w.Close()
+	_, result = post(t, srv.URL+"/scan", w.FormDataContentType(), body)
+	if result.Verdict != VerdictMalicious || result.Threat != "EICAR-Test-File" || result.Rule != "eicar" {
+		t.Fatalf("EICAR should be malicious, got %+v", result)
+	}
+}
+
+func TestScriptedStatusAndTimes(t *testing.T) {
+	latency := 0
+	script := &Script{LatencyMs: 1000, Rules: []Rule{
+		{Name: "outage", Filename: "*.pdf", Status: http.StatusServiceUnavailable, Times: 2, LatencyMs: &latency},
+		{Name: "pdf", Filename: "*.pdf", Verdict: VerdictMalicious, Threat: "PDF.Exploit", LatencyMs: &latency},
+	}}
+	s := New(script, "")
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, _ := post(t, srv.URL+"/scan?filename=doc.pdf", "application/pdf", strings.NewReader("%PDF"))
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("call %d: expected the scripted 503, got %d", i, resp.StatusCode)
+		}
+	}
+	resp, result := post(t, srv.URL+"/scan?filename=doc.pdf", "application/pdf", strings.NewReader("%PDF"))
+	if resp.StatusCode != http.StatusOK || result.Threat != "PDF.Exploit" {
+		t.Fatalf("the outage should end after 2 calls, got %d %+v", resp.StatusCode, result)
+	}
+	if calls := s.Calls(); len(calls) != 3 || calls[0].Rule != "outage" || calls[2].Rule != "pdf" {
+		t.Fatalf("unexpected calls %+v", calls)
+	}
+}
+
+func TestSubmitAndPoll(t *testing.T) {
+	srv := httptest.NewServer(New(&Script{PollAfterMs: 100, Rules: DefaultScript().Rules}, ""))
+	defer srv.Close()
+
+	resp, queued := post(t, srv.URL+"/submit", "application/octet-stream", strings.NewReader(eicar))
+	if resp.StatusCode != http.StatusAccepted || queued.ID == "" {
+		t.Fatalf("expected a queued submission, got %d %+v", resp.StatusCode, queued)
+	}
+	poll := func() Result {
+		resp, err := http.Get(srv.URL + "/result/" + queued.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var result Result
+		json.NewDecoder(resp.Body).Decode(&result)
+		return result
+	}
+	if result := poll(); result.Status != "pending" || result.Verdict != "" {
+		t.Fatalf("the submission should be pending, got %+v", result)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if result := poll(); result.Status != "done" || result.Verdict != VerdictMalicious {
+		t.Fatalf("the submission should be done, got %+v", result)
+	}
+	if resp, _ := http.Get(srv.URL + "/result/unknown"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("an unknown submission should be 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	srv := httptest.NewServer(New(&Script{Rules: []Rule{{Name: "macro", Contains: "AutoOpen", Rebuilt: "clean doc"}}}, ""))
+	defer srv.Close()
+
+	for body, expected := range map[string]string{"doc with AutoOpen": "clean doc", "plain doc": "plain doc"} {
+		resp, err := http.Post(srv.URL+"/rebuild", "application/msword", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rebuilt, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(rebuilt) != expected {
+			t.Fatalf("the rebuild of %q should be %q, got %q", body, expected, rebuilt)
+		}
+	}
+}
+
+func TestHashLookupAndAPIKey(t *testing.T) {
+	sum := sha256.Sum256([]byte("malware"))
+	hash := hex.EncodeToString(sum[:])
+	srv := httptest.NewServer(New(&Script{Rules: []Rule{{SHA256: hash, Verdict: VerdictMalicious}}}, "secret"))
+	defer srv.Close()
+
+	lookup := func(hash, key string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/lookup/sha256/"+hash, nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data := map[string]interface{}{}
+		json.NewDecoder(resp.Body).Decode(&data)
+		return resp.StatusCode, data
+	}
+	if status, _ := lookup(hash, "wrong"); status != http.StatusUnauthorized {
+		t.Fatalf("a wrong API key should be rejected, got %d", status)
+	}
+	if status, data := lookup(hash, "secret"); status != http.StatusOK || data["KnownMalicious"] == nil {
+		t.Fatalf("the hash should be known malicious, got %d %v", status, data)
+	}
+	if status, _ := lookup(strings.Repeat("0", 64), "secret"); status != http.StatusNotFound {
+		t.Fatalf("an unknown hash should be 404, got %d", status)
+	}
+}
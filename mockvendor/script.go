@@ -0,0 +1,148 @@
+package mockvendor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the verdicts of the mock vendor
+const (
+	VerdictClean     = "clean"
+	VerdictMalicious = "malicious"
+)
+
+// eicar is the EICAR test file, which the default script reports as malicious
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// Script is the scripted behavior of the mock vendor, the first rule which matches a file decides the answer,
+// a file which matches no rule is clean
+type Script struct {
+	LatencyMs   int    `json:"latency_ms"`    // the latency of every answer which no rule overrides
+	PollAfterMs int    `json:"poll_after_ms"` // the time a submitted file is pending before its result is done
+	Rules       []Rule `json:"rules"`
+}
+
+// Rule matches the files by their SHA-256, their content, their name and their size, the empty fields match
+// every file
+type Rule struct {
+	Name      string `json:"name"`
+	SHA256    string `json:"sha256"`
+	Contains  string `json:"contains"`
+	Filename  string `json:"filename"` // a glob pattern, ex: *.pdf
+	MinSize   int    `json:"min_size"`
+	MaxSize   int    `json:"max_size"` // 0 = no max
+	Verdict   string `json:"verdict"`  // clean by default
+	Threat    string `json:"threat"`
+	LatencyMs *int   `json:"latency_ms"`
+	Status    int    `json:"status"`  // the HTTP status code answered instead of the verdict, ex: 503 to test the retries
+	Times     int    `json:"times"`   // the rule matches the first times calls only, 0 = every call
+	Rebuilt   string `json:"rebuilt"` // the body which the rebuild endpoint returns, the original body if it's empty
+
+	mu    sync.Mutex
+	calls int
+}
+
+// DefaultScript reports the EICAR test file and its SHA-256 as malicious and every other file as clean, without
+// latency
+func DefaultScript() *Script {
+	sum := sha256.Sum256([]byte(eicar))
+	return &Script{Rules: []Rule{
+		{Name: "eicar", Contains: eicar, Verdict: VerdictMalicious, Threat: "EICAR-Test-File"},
+		{Name: "eicar-hash", SHA256: hex.EncodeToString(sum[:]), Verdict: VerdictMalicious, Threat: "EICAR-Test-File"},
+	}}
+}
+
+// LoadScript reads a script from a JSON file
+func LoadScript(file string) (*Script, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	s := &Script{}
+	if err = json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// file is a file which is sent to the mock vendor
+type file struct {
+	name   string
+	sha256 string
+	body   []byte
+}
+
+// match returns the first rule which matches the file, nil if there's none
+func (s *Script) match(f file) *Rule {
+	for i := range s.Rules {
+		if r := &s.Rules[i]; r.matches(f) {
+			return r
+		}
+	}
+	return nil
+}
+
+// matchHash returns the first rule of the SHA-256, the hash lookup API doesn't get the files
+func (s *Script) matchHash(hash string) *Rule {
+	for i := range s.Rules {
+		if r := &s.Rules[i]; r.SHA256 != "" && strings.EqualFold(r.SHA256, hash) && r.take() {
+			return r
+		}
+	}
+	return nil
+}
+
+func (r *Rule) matches(f file) bool {
+	if r.SHA256 != "" && !strings.EqualFold(r.SHA256, f.sha256) {
+		return false
+	}
+	if r.Contains != "" && !bytes.Contains(f.body, []byte(r.Contains)) {
+		return false
+	}
+	if r.Filename != "" {
+		if matched, _ := path.Match(r.Filename, f.name); !matched {
+			return false
+		}
+	}
+	if len(f.body) < r.MinSize || (r.MaxSize > 0 && len(f.body) > r.MaxSize) {
+		return false
+	}
+	return r.take()
+}
+
+// take counts a call of the rule, it returns false if the rule already matched its times calls
+func (r *Rule) take() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Times > 0 && r.calls >= r.Times {
+		return false
+	}
+	r.calls++
+	return true
+}
+
+// verdict returns the verdict, the threat and the name of the rule which matched, the file is clean if none did
+func (r *Rule) verdict() (string, string, string) {
+	if r == nil {
+		return VerdictClean, "", ""
+	}
+	if r.Verdict == "" {
+		return VerdictClean, r.Threat, r.Name
+	}
+	return r.Verdict, r.Threat, r.Name
+}
+
+// latency returns the latency of the answer of the rule
+func (s *Script) latency(r *Rule) time.Duration {
+	if r != nil && r.LatencyMs != nil {
+		return time.Duration(*r.LatencyMs) * time.Millisecond
+	}
+	return time.Duration(s.LatencyMs) * time.Millisecond
+}
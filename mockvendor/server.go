@@ -0,0 +1,271 @@
+package mockvendor
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the max size of a file which is sent to the mock vendor
+const maxFileSize = 256 << 20
+
+// Call is an API call which the mock vendor answered, the tests check the calls which the vendor got
+type Call struct {
+	Endpoint string    `json:"endpoint"`
+	Filename string    `json:"filename,omitempty"`
+	SHA256   string    `json:"sha256"`
+	Rule     string    `json:"rule,omitempty"`
+	Status   int       `json:"status"`
+	Time     time.Time `json:"time"`
+}
+
+// Result is the answer of a scan
+type Result struct {
+	ID      string `json:"id,omitempty"`
+	Status  string `json:"status,omitempty"` // queued, pending or done for the submitted files
+	Verdict string `json:"verdict,omitempty"`
+	Threat  string `json:"threat,omitempty"`
+	SHA256  string `json:"sha256,omitempty"`
+	Rule    string `json:"rule,omitempty"`
+}
+
+// Server emulates the API shapes of the vendors:
+//
+//	POST /scan                   the synchronous scan, the body or the "file" field of a multipart form
+//	POST /submit                 submits a file, GET /result/<id> polls its result until it's done
+//	POST /rebuild                the CDR rebuild, returns the rebuilt file
+//	GET /lookup/sha256/<sha256>  the hash lookup of hashlookup.circl.lu, 404 for the unknown hashes
+//	GET /calls                   the calls which the mock vendor answered
+type Server struct {
+	script *Script
+	apiKey string
+	mux    *http.ServeMux
+
+	mu          sync.Mutex
+	submissions map[string]*submission
+	calls       []Call
+}
+
+type submission struct {
+	result Result
+	doneAt time.Time
+}
+
+// New creates a mock vendor of the script, a non empty apiKey is required in the "Authorization: Bearer" or the
+// X-Api-Key header of every call
+func New(script *Script, apiKey string) *Server {
+	if script == nil {
+		script = DefaultScript()
+	}
+	s := &Server{script: script, apiKey: apiKey, mux: http.NewServeMux(), submissions: make(map[string]*submission)}
+	s.mux.HandleFunc("/scan", s.scan)
+	s.mux.HandleFunc("/submit", s.submit)
+	s.mux.HandleFunc("/result/", s.result)
+	s.mux.HandleFunc("/rebuild", s.rebuild)
+	s.mux.HandleFunc("/lookup/sha256/", s.lookup)
+	s.mux.HandleFunc("/calls", s.listCalls)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.apiKey != "" {
+		key := r.Header.Get("X-Api-Key")
+		if key == "" {
+			key = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(key), []byte(s.apiKey)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid API key"})
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// Calls returns the calls which the mock vendor answered
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+func (s *Server) scan(w http.ResponseWriter, r *http.Request) {
+	f, rule, ok := s.answer(w, r, "scan")
+	if !ok {
+		return
+	}
+	verdict, threat, name := rule.verdict()
+	writeJSON(w, http.StatusOK, Result{Verdict: verdict, Threat: threat, SHA256: f.sha256, Rule: name})
+}
+
+func (s *Server) submit(w http.ResponseWriter, r *http.Request) {
+	f, rule, ok := s.answer(w, r, "submit")
+	if !ok {
+		return
+	}
+	verdict, threat, name := rule.verdict()
+	id := newID()
+	s.mu.Lock()
+	s.submissions[id] = &submission{
+		result: Result{ID: id, Status: "done", Verdict: verdict, Threat: threat, SHA256: f.sha256, Rule: name},
+		doneAt: time.Now().Add(time.Duration(s.script.PollAfterMs) * time.Millisecond),
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusAccepted, Result{ID: id, Status: "queued", SHA256: f.sha256})
+}
+
+func (s *Server) result(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/result/")
+	s.mu.Lock()
+	sub, exists := s.submissions[id]
+	s.mu.Unlock()
+	if !exists {
+		s.record(Call{Endpoint: "result", Status: http.StatusNotFound})
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown submission " + id})
+		return
+	}
+	s.record(Call{Endpoint: "result", SHA256: sub.result.SHA256, Rule: sub.result.Rule, Status: http.StatusOK})
+	if time.Now().Before(sub.doneAt) {
+		writeJSON(w, http.StatusOK, Result{ID: id, Status: "pending", SHA256: sub.result.SHA256})
+		return
+	}
+	writeJSON(w, http.StatusOK, sub.result)
+}
+
+func (s *Server) rebuild(w http.ResponseWriter, r *http.Request) {
+	f, rule, ok := s.answer(w, r, "rebuild")
+	if !ok {
+		return
+	}
+	verdict, _, _ := rule.verdict()
+	body := f.body
+	if rule != nil && rule.Rebuilt != "" {
+		body = []byte(rule.Rebuilt)
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Mock-Verdict", verdict)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	hash := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/lookup/sha256/"))
+	rule := s.script.matchHash(hash)
+	time.Sleep(s.script.latency(rule))
+	call := Call{Endpoint: "lookup", SHA256: hash, Status: http.StatusOK}
+	switch {
+	case rule != nil && rule.Status != 0:
+		call.Rule, call.Status = rule.Name, rule.Status
+		writeJSON(w, rule.Status, map[string]string{"message": http.StatusText(rule.Status)})
+	case rule != nil:
+		verdict, threat, name := rule.verdict()
+		call.Rule = name
+		if verdict != VerdictMalicious {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"SHA-256": hash, "KnownGood": true})
+			break
+		}
+		if threat == "" {
+			threat = "malicious"
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"SHA-256": hash, "KnownMalicious": threat})
+	default:
+		call.Status = http.StatusNotFound
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Non existing SHA-256", "query": hash})
+	}
+	s.record(call)
+}
+
+func (s *Server) listCalls(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Calls())
+}
+
+// answer reads the file of a call, waits for the latency of its rule and answers the scripted status code of the
+// rule, it returns false if the call was answered
+func (s *Server) answer(w http.ResponseWriter, r *http.Request, endpoint string) (file, *Rule, bool) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return file{}, nil, false
+	}
+	f, err := readFile(r)
+	if err != nil {
+		s.record(Call{Endpoint: endpoint, Status: http.StatusBadRequest})
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return file{}, nil, false
+	}
+	rule := s.script.match(f)
+	time.Sleep(s.script.latency(rule))
+	call := Call{Endpoint: endpoint, Filename: f.name, SHA256: f.sha256, Status: http.StatusOK}
+	if rule != nil {
+		call.Rule = rule.Name
+		if rule.Status != 0 {
+			call.Status = rule.Status
+			s.record(call)
+			writeJSON(w, rule.Status, map[string]string{"error": http.StatusText(rule.Status)})
+			return file{}, nil, false
+		}
+	}
+	s.record(call)
+	return f, rule, true
+}
+
+// readFile reads the file of the request, the "file" field of a multipart form or the body, the name is the name
+// of the field, the filename query parameter or the X-File-Name header
+func readFile(r *http.Request) (file, error) {
+	f := file{name: r.URL.Query().Get("filename")}
+	if f.name == "" {
+		f.name = r.Header.Get("X-File-Name")
+	}
+	var body io.Reader = r.Body
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return f, err
+		}
+		part, header, err := r.FormFile("file")
+		if err != nil {
+			return f, err
+		}
+		defer part.Close()
+		body, f.name = part, header.Filename
+	}
+	b, err := io.ReadAll(io.LimitReader(body, maxFileSize))
+	if err != nil {
+		return f, err
+	}
+	sum := sha256.Sum256(b)
+	f.body, f.sha256 = b, hex.EncodeToString(sum[:])
+	return f, nil
+}
+
+func (s *Server) record(call Call) {
+	call.Time = time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, call)
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,115 @@
+package icapclient
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// chunkedReader reads an ICAP chunked body, unlike httputil.NewChunkedReader it keeps the extension of the
+// last chunk, ICAP servers put ieof and use-original-body there
+type chunkedReader struct {
+	r       *bufio.Reader
+	n       int64 // the bytes left in the current chunk
+	err     error
+	lastExt string
+}
+
+// writeChunk writes the data as a single chunk, an empty data writes nothing because it's the last chunk
+func writeChunk(w io.Writer, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "%x%s", len(data), CRLF); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, CRLF)
+	return err
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.err == nil && c.n == 0 {
+		c.err = c.beginChunk()
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	if int64(len(p)) > c.n {
+		p = p[:c.n]
+	}
+	n, err := c.r.Read(p)
+	c.n -= int64(n)
+	if c.n == 0 && err == nil {
+		// the data of every chunk ends with a CRLF
+		var line []byte
+		if line, err = c.readLine(); err == nil && len(line) != 0 {
+			err = errors.New("malformed chunked encoding")
+		}
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	c.err = err
+	return n, err
+}
+
+// beginChunk reads the size line of the next chunk, the trailer after the last chunk is discarded
+func (c *chunkedReader) beginChunk() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	size, ext := string(line), ""
+	if i := strings.IndexByte(size, ';'); i >= 0 {
+		size, ext = size[:i], strings.TrimSpace(size[i+1:])
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+	if err != nil || n < 0 {
+		return errors.New("invalid chunk size " + strconv.Quote(size))
+	}
+	if n > 0 {
+		c.n = n
+		return nil
+	}
+	c.lastExt = ext
+	for {
+		if line, err = c.readLine(); err != nil {
+			return err
+		}
+		if len(line) == 0 {
+			return io.EOF
+		}
+	}
+}
+
+func (c *chunkedReader) readLine() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, errors.New("the chunk line is too long")
+	}
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(line, CRLF), nil
+}
+
+// extension returns the value of the parameter in the extension of the last chunk
+func (c *chunkedReader) extension(name string) (string, bool) {
+	for _, param := range strings.Split(c.lastExt, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.EqualFold(key, name) {
+			return strings.Trim(value, `"`), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,87 @@
+package icapclient
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWriteChunk(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{data: "Hello World!", want: "c\r\nHello World!\r\n"},
+		{data: "This is another message. Alright bye!", want: "25\r\nThis is another message. Alright bye!\r\n"},
+		{data: "", want: ""},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := writeChunk(&buf, []byte(tt.data)); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("got %q, want %q", buf.String(), tt.want)
+		}
+	}
+}
+
+func TestChunkedReader(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunked   string
+		want      string
+		extension string
+		value     string
+	}{
+		{name: "one chunk", chunked: "c\r\nHello World!\r\n0\r\n\r\n", want: "Hello World!"},
+		{name: "chunks", chunked: "5\r\nHello\r\n7\r\n World!\r\n0\r\n\r\n", want: "Hello World!"},
+		{name: "ieof", chunked: "2\r\nhi\r\n0; ieof\r\n\r\n", want: "hi", extension: "ieof"},
+		{name: "use-original-body", chunked: "5\r\nHELLO\r\n0; use-original-body=5\r\n\r\n", want: "HELLO",
+			extension: "use-original-body", value: "5"},
+		{name: "trailer", chunked: "2\r\nhi\r\n0\r\nX-Trailer: 1\r\n\r\n", want: "hi"},
+		{name: "empty", chunked: "0\r\n\r\n", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &chunkedReader{r: bufio.NewReader(strings.NewReader(tt.chunked + "next"))}
+			data, err := io.ReadAll(r)
+			if err != nil || string(data) != tt.want {
+				t.Fatalf("got %q and error %v, want %q", data, err, tt.want)
+			}
+			if tt.extension != "" {
+				if value, found := r.extension(tt.extension); !found || value != tt.value {
+					t.Fatalf("got extension %q %v, want %q", value, found, tt.value)
+				}
+			}
+			// the reader stops after the last chunk
+			if rest, _ := io.ReadAll(r.r); string(rest) != "next" {
+				t.Fatalf("the bytes after the body were read, %q is left", rest)
+			}
+		})
+	}
+}
+
+func TestChunkedReaderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunked string
+		err     string
+	}{
+		{name: "invalid size", chunked: "zz\r\nhello\r\n0\r\n\r\n", err: "invalid chunk size"},
+		{name: "no CRLF after the data", chunked: "2\r\nhello\r\n0\r\n\r\n", err: "malformed chunked encoding"},
+		{name: "truncated data", chunked: "a\r\nhello", err: io.ErrUnexpectedEOF.Error()},
+		{name: "no last chunk", chunked: "5\r\nhello\r\n", err: io.ErrUnexpectedEOF.Error()},
+		{name: "line too long", chunked: strings.Repeat("1", 8192), err: "the chunk line is too long"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := io.ReadAll(&chunkedReader{r: bufio.NewReaderSize(strings.NewReader(tt.chunked), 4096)})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,384 @@
+package icapclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Client represents the icap client who makes the icap server calls, every call has its own connection
+type Client struct {
+	Timeout   time.Duration // the timeout of the dial and of every read and write, 0 = 15 seconds
+	Dialer    *net.Dialer
+	TLSConfig *tls.Config // used by the icaps:// URLs
+}
+
+// conn extends the deadline of the connection before every read and write, so a long body doesn't time out
+// while it's still moving
+type conn struct {
+	net.Conn
+	timeout time.Duration
+	once    sync.Once
+	done    chan struct{}
+}
+
+// transaction is the state of an ICAP call, it's needed to rebuild the original message after a 204 or a 206
+type transaction struct {
+	req      *Request
+	conn     *conn
+	body     io.ReadCloser
+	preview  []byte
+	eof      bool // the whole body fitted in the preview
+	bodySent bool
+}
+
+// errReader is the body of a message which can't be read
+type errReader struct {
+	err error
+}
+
+// body is a message body which closes its transaction once it's closed
+type body struct {
+	io.Reader
+	close func() error
+}
+
+// Do sends the request to the ICAP server, the body of the encapsulated message is streamed in chunks and only
+// its preview is sent if the request has one, the rest is sent if the server answers with 100 Continue.
+// After a 204 the original message is returned and after a 206 the modified part followed by the rest of the
+// original body, the original body is rebuilt with GetBody if it was already sent
+func (c *Client) Do(req *Request) (*Response, error) {
+	ctx := req.Context()
+	cn, err := c.dial(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &transaction{req: req, conn: cn, body: req.body()}
+	resp, err := t.do()
+	if err != nil {
+		cn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// Options makes an OPTIONS call to the ICAP service and returns its capabilities
+func (c *Client) Options(ctx context.Context, urlStr string) (*Options, error) {
+	req, err := NewRequestWithContext(ctx, MethodOPTIONS, urlStr, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("the OPTIONS call returned " + strconv.Itoa(resp.StatusCode) + " " + resp.Status)
+	}
+	return optionsOf(resp), nil
+}
+
+// dial connects to the ICAP server, the connection is closed once the context of the request is done
+func (c *Client) dial(ctx context.Context, req *Request) (*conn, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	dialer := c.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{Timeout: timeout}
+	}
+
+	port := req.URL.Port()
+	if port == "" {
+		port = defaultPort
+		if req.URL.Scheme == SchemeICAPS {
+			port = defaultTLSPort
+		}
+	}
+	addr := net.JoinHostPort(req.URL.Hostname(), port)
+
+	var nc net.Conn
+	var err error
+	if req.URL.Scheme == SchemeICAPS {
+		nc, err = (&tls.Dialer{NetDialer: dialer, Config: c.TLSConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		nc, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cn := &conn{Conn: nc, timeout: timeout, done: make(chan struct{})}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				cn.Close()
+			case <-cn.done:
+			}
+		}()
+	}
+	return cn, nil
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *conn) Close() error {
+	err := net.ErrClosed
+	c.once.Do(func() {
+		close(c.done)
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+func (t *transaction) do() (*Response, error) {
+	req := t.req
+	req.SetDefaultRequestHeaders() // assigning default headers if not set already
+	if t.body == nil {
+		req.Header.Del(PreviewHeader) // there is nothing to preview
+	}
+
+	logDebug("The request headers: ")
+	dumpDebug(req.Header)
+
+	chunkLength := req.ChunkLength
+	if chunkLength <= 0 {
+		chunkLength = defaultChunkLength
+	}
+	bw := bufio.NewWriterSize(t.conn, chunkLength+16)
+	br := bufio.NewReader(t.conn)
+
+	preview := req.previewSet && t.body != nil
+	if preview {
+		t.preview = make([]byte, req.PreviewBytes)
+		n, err := io.ReadFull(t.body, t.preview)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			t.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+		t.preview = t.preview[:n]
+	}
+
+	head, err := req.encapsulatedHeaders(t.body != nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := bw.Write(head); err != nil {
+		return nil, err
+	}
+
+	if preview {
+		if err := writeChunk(bw, t.preview); err != nil {
+			return nil, err
+		}
+		last := "0" + DoubleCRLF
+		if t.eof {
+			last = "0; ieof" + DoubleCRLF
+		}
+		if _, err := bw.WriteString(last); err != nil {
+			return nil, err
+		}
+		if err := bw.Flush(); err != nil {
+			return nil, err
+		}
+		resp, err := readResponseHeader(br)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusContinue {
+			return t.finish(resp, br)
+		}
+		if !t.eof {
+			logDebug("Sending the rest of the body after the preview, as received 100 Continue from the server...")
+			if err := t.sendBody(bw, chunkLength); err != nil {
+				return nil, err
+			}
+		}
+	} else if t.body != nil {
+		if err := t.sendBody(bw, chunkLength); err != nil {
+			return nil, err
+		}
+	} else if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+
+	for {
+		resp, err := readResponseHeader(br)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusContinue { // a 100 Continue which wasn't asked for is skipped
+			return t.finish(resp, br)
+		}
+	}
+}
+
+// sendBody streams the rest of the body in chunks and ends it with the last chunk
+func (t *transaction) sendBody(bw *bufio.Writer, chunkLength int) error {
+	t.bodySent = true
+	buf := make([]byte, chunkLength)
+	for {
+		n, err := t.body.Read(buf)
+		if werr := writeChunk(bw, buf[:n]); werr != nil {
+			return werr
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	t.body.Close()
+	if _, err := bw.WriteString("0" + DoubleCRLF); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// finish reads the encapsulated part of the final response
+func (t *transaction) finish(resp *Response, br *bufio.Reader) (*Response, error) {
+	bodyName, err := resp.readEncapsulated(br)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		t.conn.Close()
+		t.restoreOriginal(resp)
+	case bodyName == "req-body" || bodyName == "res-body":
+		chunked := &chunkedReader{r: br}
+		if resp.StatusCode == http.StatusPartialContent {
+			resp.setBody(bodyName, &partialBody{chunked: chunked, header: resp.Header, t: t})
+		} else {
+			resp.setBody(bodyName, &body{Reader: chunked, close: t.close})
+		}
+	default:
+		t.conn.Close()
+	}
+
+	return resp, nil
+}
+
+// restoreOriginal sets the original messages of the request as the messages of the 204 response
+func (t *transaction) restoreOriginal(resp *Response) {
+	req := t.req
+	if req.HTTPRequest != nil {
+		httpReq := *req.HTTPRequest
+		if req.Method == MethodREQMOD {
+			httpReq.Body = t.original(0)
+		}
+		resp.ContentRequest = &httpReq
+	}
+	if req.HTTPResponse != nil {
+		httpResp := *req.HTTPResponse
+		httpResp.Body = t.original(0)
+		resp.ContentResponse = &httpResp
+	}
+}
+
+// original returns the original body from the offset, the body is read again with GetBody if it was sent
+func (t *transaction) original(offset int64) io.ReadCloser {
+	var r io.ReadCloser
+	switch {
+	case t.body == nil:
+		return http.NoBody
+	case t.eof || !t.bodySent:
+		r = &body{Reader: io.MultiReader(bytes.NewReader(t.preview), t.body), close: t.body.Close}
+	case t.req.GetBody != nil:
+		var err error
+		if r, err = t.req.GetBody(); err != nil {
+			return io.NopCloser(&errReader{err: err})
+		}
+	default:
+		return io.NopCloser(&errReader{err: errors.New(ErrBodyConsumed)})
+	}
+	if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+		r.Close()
+		return io.NopCloser(&errReader{err: err})
+	}
+	return r
+}
+
+func (t *transaction) close() error {
+	if t.body != nil && (t.eof || !t.bodySent) {
+		t.body.Close()
+	}
+	return t.conn.Close()
+}
+
+// partialBody is the body of a 206 response, the part which the server sent is followed by the original body
+// from the offset in the Use-Original-Body header or in the use-original-body extension of the last chunk
+type partialBody struct {
+	chunked  *chunkedReader
+	header   http.Header
+	t        *transaction
+	original io.ReadCloser
+}
+
+func (p *partialBody) Read(b []byte) (int, error) {
+	if p.original != nil {
+		return p.original.Read(b)
+	}
+	n, err := p.chunked.Read(b)
+	if err != io.EOF {
+		return n, err
+	}
+	offset, found := p.chunked.extension("use-original-body")
+	if !found {
+		offset = p.header.Get(UseOriginalBodyHeader)
+	}
+	if offset == "" {
+		return n, io.EOF
+	}
+	off, perr := strconv.ParseInt(offset, 10, 64)
+	if perr != nil || off < 0 {
+		return n, errors.New("invalid use-original-body offset " + strconv.Quote(offset))
+	}
+	p.original = p.t.original(off)
+	return n, nil
+}
+
+func (p *partialBody) Close() error {
+	if p.original != nil {
+		p.original.Close()
+	}
+	return p.t.close()
+}
+
+func (e *errReader) Read([]byte) (int, error) {
+	return 0, e.err
+}
+
+func (b *body) Close() error {
+	return b.close()
+}
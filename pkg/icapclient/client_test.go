@@ -0,0 +1,410 @@
+package icapclient
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveOnce accepts a single connection and passes the reader and the connection to the handler
+func serveOnce(t *testing.T, handler func(br *bufio.Reader, conn net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handler(bufio.NewReader(conn), conn)
+	}()
+	return "icap://" + ln.Addr().String() + "/respmod"
+}
+
+// readRequestHead reads the ICAP header and the encapsulated HTTP headers of a RESPMOD request
+func readRequestHead(br *bufio.Reader) (textproto.MIMEHeader, error) {
+	tp := textproto.NewReader(br)
+	if _, err := tp.ReadLine(); err != nil {
+		return nil, err
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := http.ReadRequest(br); err != nil {
+		return nil, err
+	}
+	if _, err := http.ReadResponse(br, nil); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+func respmodRequest(t *testing.T, url, body string) *Request {
+	t.Helper()
+	httpReq, _ := http.NewRequest(http.MethodGet, "http://example.com/file.txt", nil)
+	httpResp := &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{"Content-Type": {"text/plain"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	req, err := NewRequest(MethodRESPMOD, url, httpReq, httpResp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func readBody(t *testing.T, body io.ReadCloser) string {
+	t.Helper()
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestDoPreviewContinue(t *testing.T) {
+	received := make(chan string, 1)
+	url := serveOnce(t, func(br *bufio.Reader, conn net.Conn) {
+		if _, err := readRequestHead(br); err != nil {
+			received <- err.Error()
+			return
+		}
+		preview, _ := io.ReadAll(&chunkedReader{r: br})
+		io.WriteString(conn, "ICAP/1.0 100 Continue\r\n\r\n")
+		rest, _ := io.ReadAll(&chunkedReader{r: br})
+		received <- string(preview) + "|" + string(rest)
+		resHdr := "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\n"
+		io.WriteString(conn, "ICAP/1.0 200 OK\r\nISTag: \"t1\"\r\nEncapsulated: res-hdr=0, res-body="+
+			strconv.Itoa(len(resHdr))+"\r\n\r\n"+resHdr+"8\r\nmodified\r\n0\r\n\r\n")
+	})
+
+	req := respmodRequest(t, url, "hello world")
+	if err := req.SetPreview(4); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "hell|o world" {
+		t.Fatalf("server received %q, want %q", got, "hell|o world")
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(ISTagHeader) != `"t1"` {
+		t.Fatalf("got status %d and ISTag %q", resp.StatusCode, resp.Header.Get(ISTagHeader))
+	}
+	if got := readBody(t, resp.ContentResponse.Body); got != "modified" {
+		t.Fatalf("got body %q, want %q", got, "modified")
+	}
+}
+
+func TestDo204RestoresOriginal(t *testing.T) {
+	tests := []struct {
+		name    string
+		preview int
+		getBody bool
+		want    string
+	}{
+		{name: "after preview", preview: 4, want: "hello world"},
+		{name: "after the whole body with GetBody", preview: -1, getBody: true, want: "hello world"},
+		{name: "after the whole body without GetBody", preview: -1, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := serveOnce(t, func(br *bufio.Reader, conn net.Conn) {
+				if _, err := readRequestHead(br); err != nil {
+					return
+				}
+				io.ReadAll(&chunkedReader{r: br})
+				io.WriteString(conn, "ICAP/1.0 204 No Content\r\nEncapsulated: null-body=0\r\n\r\n")
+			})
+			req := respmodRequest(t, url, "hello world")
+			if tt.preview >= 0 {
+				req.SetPreview(tt.preview)
+			}
+			if tt.getBody {
+				req.GetBody = func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("hello world")), nil
+				}
+			}
+			resp, err := (&Client{Timeout: 5 * time.Second}).Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusNoContent {
+				t.Fatalf("got status %d", resp.StatusCode)
+			}
+			data, err := io.ReadAll(resp.ContentResponse.Body)
+			if tt.want == "" {
+				if err == nil || err.Error() != ErrBodyConsumed {
+					t.Fatalf("got error %v, want %q", err, ErrBodyConsumed)
+				}
+				return
+			}
+			if err != nil || string(data) != tt.want {
+				t.Fatalf("got body %q and error %v, want %q", data, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDo206UsesOriginalBody(t *testing.T) {
+	url := serveOnce(t, func(br *bufio.Reader, conn net.Conn) {
+		if _, err := readRequestHead(br); err != nil {
+			return
+		}
+		io.ReadAll(&chunkedReader{r: br})
+		resHdr := "HTTP/1.1 200 OK\r\n\r\n"
+		io.WriteString(conn, "ICAP/1.0 206 Partial Content\r\nEncapsulated: res-hdr=0, res-body="+
+			strconv.Itoa(len(resHdr))+"\r\n\r\n"+resHdr+"5\r\nHELLO\r\n0; use-original-body=5\r\n\r\n")
+	})
+
+	req := respmodRequest(t, url, "hello world")
+	req.SetPreview(1024)
+	resp, err := (&Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readBody(t, resp.ContentResponse.Body); got != "HELLO world" {
+		t.Fatalf("got body %q, want %q", got, "HELLO world")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	url := serveOnce(t, func(br *bufio.Reader, conn net.Conn) {
+		tp := textproto.NewReader(br)
+		tp.ReadLine()
+		tp.ReadMIMEHeader()
+		io.WriteString(conn, "ICAP/1.0 200 OK\r\nMethods: RESPMOD, REQMOD\r\nISTag: \"x\"\r\nPreview: 1024\r\n"+
+			"Allow: 204, 206\r\nTransfer-Preview: *\r\nOptions-TTL: 3600\r\nMax-Connections: 100\r\n"+
+			"Encapsulated: null-body=0\r\n\r\n")
+	})
+
+	opts, err := (&Client{Timeout: 5 * time.Second}).Options(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.Supports(MethodREQMOD) || opts.Supports(MethodOPTIONS) || opts.Preview != 1024 || !opts.Allow204 ||
+		!opts.Allow206 || opts.TTL != time.Hour || opts.MaxConnections != 100 || opts.ISTag != `"x"` {
+		t.Fatalf("unexpected options %+v", opts)
+	}
+}
+
+func TestDumpRequest(t *testing.T) {
+	req := respmodRequest(t, "icap://localhost:1344/respmod", "hello")
+	data, err := DumpRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqHdr := "GET http://example.com/file.txt HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	resHdr := "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\n"
+	want := "RESPMOD icap://localhost:1344/respmod ICAP/1.0\r\nEncapsulated: req-hdr=0, res-hdr=" +
+		strconv.Itoa(len(reqHdr)) + ", res-body=" + strconv.Itoa(len(reqHdr)+len(resHdr)) + "\r\n\r\n" + reqHdr + resHdr +
+		"5\r\nhello\r\n0\r\n\r\n"
+	if string(data) != want {
+		t.Fatalf("got\n%q\nwant\n%q", data, want)
+	}
+	if got := readBody(t, req.HTTPResponse.Body); got != "hello" {
+		t.Fatalf("the body wasn't restored, got %q", got)
+	}
+}
+
+func TestDoPreviewWithTheWholeBody(t *testing.T) {
+	received := make(chan string, 1)
+	url := serveOnce(t, func(br *bufio.Reader, conn net.Conn) {
+		if _, err := readRequestHead(br); err != nil {
+			received <- err.Error()
+			return
+		}
+		preview := &chunkedReader{r: br}
+		data, _ := io.ReadAll(preview)
+		_, ieof := preview.extension("ieof")
+		received <- string(data) + "|" + strconv.FormatBool(ieof)
+		// no 100 Continue is needed, the whole body was sent
+		io.WriteString(conn, "ICAP/1.0 204 No Content\r\nEncapsulated: null-body=0\r\n\r\n")
+	})
+
+	req := respmodRequest(t, url, "hi")
+	req.SetPreview(4)
+	resp, err := (&Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "hi|true" {
+		t.Fatalf("server received %q, want the body with ieof", got)
+	}
+	if got := readBody(t, resp.ContentResponse.Body); got != "hi" {
+		t.Fatalf("got body %q, want the original one", got)
+	}
+}
+
+func TestDoChunkLength(t *testing.T) {
+	received := make(chan []string, 1)
+	url := serveOnce(t, func(br *bufio.Reader, conn net.Conn) {
+		if _, err := readRequestHead(br); err != nil {
+			return
+		}
+		var sizes []string
+		tp := textproto.NewReader(br)
+		for {
+			size, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			sizes = append(sizes, size)
+			if size == "0" {
+				tp.ReadLine()
+				break
+			}
+			tp.ReadLine()
+		}
+		received <- sizes
+		io.WriteString(conn, "ICAP/1.0 204 No Content\r\nEncapsulated: null-body=0\r\n\r\n")
+	})
+
+	req := respmodRequest(t, url, "hello world")
+	req.ChunkLength = 4
+	resp, err := (&Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.ContentResponse.Body.Close()
+	if got := strings.Join(<-received, ","); got != "4,4,3,0" {
+		t.Fatalf("got the chunks %s, want 4,4,3,0", got)
+	}
+}
+
+func TestDoReqmod(t *testing.T) {
+	received := make(chan string, 1)
+	url := serveOnce(t, func(br *bufio.Reader, conn net.Conn) {
+		tp := textproto.NewReader(br)
+		tp.ReadLine()
+		header, _ := tp.ReadMIMEHeader()
+		httpReq, err := http.ReadRequest(br)
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(&chunkedReader{r: br})
+		received <- header.Get("Encapsulated") + "|" + httpReq.URL.String() + "|" + string(body)
+		reqHdr := "POST /modified HTTP/1.1\r\nHost: example.com\r\n\r\n"
+		io.WriteString(conn, "ICAP/1.0 200 OK\r\nEncapsulated: req-hdr=0, req-body="+strconv.Itoa(len(reqHdr))+
+			"\r\n\r\n"+reqHdr+"7\r\nchanged\r\n0\r\n\r\n")
+	})
+
+	httpReq, _ := http.NewRequest(http.MethodPost, "http://example.com/upload", strings.NewReader("posted"))
+	req, err := NewRequest(MethodREQMOD, strings.Replace(url, "respmod", "reqmod", 1), httpReq, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqHdr := "POST http://example.com/upload HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	want := "req-hdr=0, req-body=" + strconv.Itoa(len(reqHdr)) + "|http://example.com/upload|posted"
+	if got := <-received; got != want {
+		t.Fatalf("server received %q, want %q", got, want)
+	}
+	if resp.ContentRequest == nil || resp.ContentRequest.URL.Path != "/modified" {
+		t.Fatalf("unexpected HTTP request %+v", resp.ContentRequest)
+	}
+	if got := readBody(t, resp.ContentRequest.Body); got != "changed" {
+		t.Fatalf("got body %q, want %q", got, "changed")
+	}
+}
+
+func TestDoErrors(t *testing.T) {
+	// the handlers which don't answer wait until the test ends
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	tests := []struct {
+		name    string
+		handler func(br *bufio.Reader, conn net.Conn)
+		timeout time.Duration
+		ctx     time.Duration
+		check   func(err error) bool
+	}{
+		{
+			name:    "connection closed",
+			handler: func(br *bufio.Reader, conn net.Conn) { readRequestHead(br) },
+			check:   func(err error) bool { return err == io.ErrUnexpectedEOF },
+		},
+		{
+			name: "not an ICAP response",
+			handler: func(br *bufio.Reader, conn net.Conn) {
+				readRequestHead(br)
+				io.ReadAll(&chunkedReader{r: br})
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\n")
+			},
+			check: func(err error) bool { return strings.HasPrefix(err.Error(), ErrInvalidTCPMsg) },
+		},
+		{
+			name: "truncated body",
+			handler: func(br *bufio.Reader, conn net.Conn) {
+				readRequestHead(br)
+				io.ReadAll(&chunkedReader{r: br})
+				io.WriteString(conn, "ICAP/1.0 200 OK\r\nEncapsulated: res-hdr=0, null-body=40\r\n\r\nHTTP/1.1")
+			},
+			check: func(err error) bool { return err != nil },
+		},
+		{
+			name:    "read timeout",
+			handler: func(br *bufio.Reader, conn net.Conn) { <-stop },
+			timeout: 50 * time.Millisecond,
+			check: func(err error) bool {
+				netErr, isNetErr := err.(net.Error)
+				return isNetErr && netErr.Timeout()
+			},
+		},
+		{
+			name:    "context done",
+			handler: func(br *bufio.Reader, conn net.Conn) { <-stop },
+			ctx:     50 * time.Millisecond,
+			check:   func(err error) bool { return err == context.DeadlineExceeded },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := respmodRequest(t, serveOnce(t, tt.handler), "hello world")
+			if tt.ctx != 0 {
+				ctx, cancel := context.WithTimeout(context.Background(), tt.ctx)
+				defer cancel()
+				req.SetContext(ctx)
+			}
+			timeout := tt.timeout
+			if timeout == 0 {
+				timeout = 5 * time.Second
+			}
+			_, err := (&Client{Timeout: timeout}).Do(req)
+			if err == nil || !tt.check(err) {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+
+	// nothing listens on the port of a closed listener
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	req := respmodRequest(t, "icap://"+ln.Addr().String()+"/respmod", "hello world")
+	if _, err := (&Client{Timeout: 5 * time.Second}).Do(req); err == nil {
+		t.Fatal("the call to a closed port should fail")
+	}
+}
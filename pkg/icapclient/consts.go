@@ -11,32 +11,29 @@ const (
 
 // the error messages
 const (
-	ErrInvalidScheme       = "the url scheme must be icap://"
+	ErrInvalidScheme       = "the url scheme must be icap:// or icaps://"
 	ErrMethodNotRegistered = "the requested method is not registered"
 	ErrInvalidHost         = "the requested host is invalid"
-	ErrConnectionNotOpen   = "no open connection to close"
 	ErrInvalidTCPMsg       = "invalid tcp message"
 	ErrREQMODWithNoReq     = "http request cannot be nil for method REQMOD"
 	ErrREQMODWithResp      = "http response must be nil for method REQMOD"
 	ErrRESPMODWithNoResp   = "http response cannot be nil for method RESPMOD"
+	ErrBodyConsumed        = "the body was sent to the ICAP server and the request has no GetBody to read it again"
 )
 
 // general constants required for the package
 const (
-	SchemeICAP                      = "icap"
-	ICAPVersion                     = "ICAP/1.0"
-	HTTPVersion                     = "HTTP/1.1"
-	SchemeHTTPReq                   = "http_request"
-	SchemeHTTPResp                  = "http_response"
-	CRLF                            = "\r\n"
-	DoubleCRLF                      = "\r\n\r\n"
-	LF                              = "\n"
-	bodyEndIndicator                = CRLF + "0" + CRLF
-	fullBodyEndIndicatorPreviewMode = "; ieof" + DoubleCRLF
-	icap100ContinueMsg              = "ICAP/1.0 100 Continue" + DoubleCRLF
-	icap204NoModsMsg                = "ICAP/1.0 204 No modifications"
-	defaultChunkLength              = 512
-	defaultTimeout                  = 15 * time.Second
+	SchemeICAP         = "icap"
+	SchemeICAPS        = "icaps"
+	ICAPVersion        = "ICAP/1.0"
+	HTTPVersion        = "HTTP/1.1"
+	CRLF               = "\r\n"
+	DoubleCRLF         = "\r\n\r\n"
+	LF                 = "\n"
+	defaultPort        = "1344"
+	defaultTLSPort     = "11344"
+	defaultChunkLength = 32 * 1024
+	defaultTimeout     = 15 * time.Second
 )
 
 // Common ICAP headers
@@ -54,4 +51,5 @@ const (
 	ServiceIDHeader        = "Service-ID"
 	TransferIgnoreHeader   = "Transfer-Ignore"
 	TransferCompleteHeader = "Transfer-Complete"
+	UseOriginalBodyHeader  = "Use-Original-Body"
 )
@@ -0,0 +1,59 @@
+// Package icapclient is a client package for the ICAP protocol (RFC 3507)
+//
+// Every call has its own connection, the body of the encapsulated message is streamed in chunks and only the
+// preview is sent when the request has one, the rest of the body is sent if the server answers with
+// 100 Continue. After a 204 the response has the original message and after a 206 the part which the server
+// modified followed by the rest of the original body. The body of the modified message is read from the
+// connection, so it must be closed.
+//
+// Here is a basic example:
+//
+//	package main
+//
+//	import (
+//		"context"
+//		"fmt"
+//		"log"
+//		"net/http"
+//		"time"
+//
+//		ic "icapeg/pkg/icapclient"
+//	)
+//
+//	func main() {
+//		/* preparing the http request & response required for the RESPMOD */
+//		httpReq, err := http.NewRequest(http.MethodGet, "http://localhost:8000/sample.pdf", nil)
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		httpResp, err := http.DefaultClient.Do(httpReq)
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//
+//		/* making the icap client & asking the service for its capabilities */
+//		client := &ic.Client{Timeout: 5 * time.Second}
+//		opts, err := client.Options(context.Background(), "icap://127.0.0.1:1344/respmod")
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//
+//		/* making the RESPMOD request call */
+//		req, err := ic.NewRequest(ic.MethodRESPMOD, "icap://127.0.0.1:1344/respmod", httpReq, httpResp)
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		if opts.Preview >= 0 {
+//			req.SetPreview(opts.Preview)
+//		}
+//		resp, err := client.Do(req)
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		defer resp.ContentResponse.Body.Close()
+//
+//		fmt.Println(resp.StatusCode)
+//	}
+//
+// See the examples directory for more.
+package icapclient
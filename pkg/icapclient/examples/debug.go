@@ -7,7 +7,7 @@ import (
 	"os"
 	"time"
 
-	ic "icapeg/icap-client"
+	ic "icapeg/pkg/icapclient"
 )
 
 func reqmodInDebug() {
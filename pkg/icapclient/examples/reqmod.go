@@ -6,7 +6,7 @@ import (
 	"net/http"
 	"time"
 
-	ic "icapeg/icap-client"
+	ic "icapeg/pkg/icapclient"
 )
 
 func makeReqmodCall() {
@@ -1,12 +1,15 @@
 package examples
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
-	ic "icapeg/icap-client"
+	ic "icapeg/pkg/icapclient"
 )
 
 func makeRespmodCall() {
@@ -27,21 +30,13 @@ func makeRespmodCall() {
 		log.Fatal(err)
 	}
 
-	/* making a icap request with OPTIONS method */
-	optReq, err := ic.NewRequest(ic.MethodOPTIONS, "icap://127.0.0.1:1344/respmod", nil, nil)
-
-	if err != nil {
-		log.Fatal(err)
-		return
-	}
-
 	/* making the icap client responsible for making the requests */
 	client := &ic.Client{
 		Timeout: 5 * time.Second,
 	}
 
-	/* making the OPTIONS request call */
-	optResp, err := client.Do(optReq)
+	/* asking the service for its capabilities with an OPTIONS call */
+	opts, err := client.Options(context.Background(), "icap://127.0.0.1:1344/respmod")
 
 	if err != nil {
 		log.Fatal(err)
@@ -55,7 +50,9 @@ func makeRespmodCall() {
 		log.Fatal(err)
 	}
 
-	req.SetPreview(optResp.PreviewBytes) // setting the preview bytes obtained from the OPTIONS call
+	if opts.Preview >= 0 {
+		req.SetPreview(opts.Preview) // only the preview is sent unless the service asks for the rest
+	}
 
 	/* making the RESPMOD request call */
 	resp, err := client.Do(req)
@@ -66,4 +63,10 @@ func makeRespmodCall() {
 
 	fmt.Println(resp.StatusCode)
 
+	/* the body of the modified response, or the original one after a 204, is streamed from the connection */
+	if resp.ContentResponse != nil {
+		defer resp.ContentResponse.Body.Close()
+		io.Copy(os.Stdout, resp.ContentResponse.Body)
+	}
+
 }
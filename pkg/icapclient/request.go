@@ -0,0 +1,280 @@
+package icapclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Request represents the icap client request data
+type Request struct {
+	Method       string
+	URL          *url.URL
+	Header       http.Header
+	HTTPRequest  *http.Request
+	HTTPResponse *http.Response
+	ChunkLength  int // the size of the chunks of the body which are sent, 0 = 32KB
+	PreviewBytes int
+
+	// GetBody returns a new reader of the body of the encapsulated message, it's used to rebuild the message
+	// after a 204 or a 206 response when its body was already sent. NewRequest sets it from the GetBody of the
+	// HTTP request in REQMOD
+	GetBody func() (io.ReadCloser, error)
+
+	ctx        context.Context
+	previewSet bool
+}
+
+// NewRequest is the factory function for Request
+func NewRequest(method, urlStr string, httpReq *http.Request, httpResp *http.Response) (*Request, error) {
+	return NewRequestWithContext(context.Background(), method, urlStr, httpReq, httpResp)
+}
+
+// NewRequestWithContext returns a request whose connection is closed once the context is done
+func NewRequestWithContext(ctx context.Context, method, urlStr string, httpReq *http.Request,
+	httpResp *http.Response) (*Request, error) {
+
+	method = strings.ToUpper(method)
+
+	u, err := url.Parse(urlStr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req := &Request{
+		Method:       method,
+		URL:          u,
+		Header:       make(map[string][]string),
+		HTTPRequest:  httpReq,
+		HTTPResponse: httpResp,
+		ctx:          ctx,
+	}
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	if method == MethodREQMOD && httpReq.GetBody != nil {
+		req.GetBody = httpReq.GetBody
+	}
+
+	return req, nil
+}
+
+// SetContext sets a context for the ICAP request, the connection is closed once it's done
+func (r *Request) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// Context returns the context of the request
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// SetPreview sends the first maxBytes bytes of the body as a preview, the rest of the body is sent only if
+// the ICAP server answers the preview with 100 Continue. maxBytes is usually the Preview of the OPTIONS response
+func (r *Request) SetPreview(maxBytes int) error {
+	if maxBytes < 0 {
+		return errors.New("the preview can't be negative")
+	}
+	r.Header.Set(PreviewHeader, strconv.Itoa(maxBytes))
+	r.PreviewBytes = maxBytes
+	r.previewSet = true
+	return nil
+}
+
+// SetDefaultRequestHeaders assigns some of the headers with its default value if they are not set already
+func (r *Request) SetDefaultRequestHeaders() {
+	if _, exists := r.Header["Allow"]; !exists {
+		r.Header.Add("Allow", "204") // assigning 204 by default if Allow not provided
+	}
+	if _, exists := r.Header["Host"]; !exists {
+		host := r.URL.Host
+		if host == "" {
+			host, _ = os.Hostname()
+		}
+		r.Header.Add("Host", host)
+	}
+}
+
+// ExtendHeader extends the current ICAP Request header with a new header
+func (r *Request) ExtendHeader(hdr http.Header) error {
+	for header, values := range hdr {
+
+		if header == EncapsulatedHeader {
+			continue
+		}
+
+		for _, value := range values {
+			if header == PreviewHeader {
+				pb, err := strconv.Atoi(value)
+				if err != nil {
+					return err
+				}
+				if err := r.SetPreview(pb); err != nil {
+					return err
+				}
+				continue
+			}
+			r.Header.Add(header, value)
+		}
+	}
+
+	return nil
+}
+
+// allows reports whether the Allow header of the request has the status code
+func (r *Request) allows(code string) bool {
+	for _, value := range r.Header.Values(AllowHeader) {
+		for _, allowed := range strings.Split(value, ",") {
+			if strings.TrimSpace(allowed) == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// body returns the body of the encapsulated message, nil if the message has no body
+func (r *Request) body() io.ReadCloser {
+	var body io.ReadCloser
+	switch r.Method {
+	case MethodREQMOD:
+		body = r.HTTPRequest.Body
+	case MethodRESPMOD:
+		body = r.HTTPResponse.Body
+	}
+	if body == http.NoBody {
+		return nil
+	}
+	return body
+}
+
+// encapsulatedHeaders returns the ICAP header block and the HTTP header blocks of the request, the
+// Encapsulated header has the offsets of the HTTP blocks
+func (r *Request) encapsulatedHeaders(hasBody bool) ([]byte, error) {
+	var httpBlocks bytes.Buffer
+	var encapsulated []string
+	if r.HTTPRequest != nil {
+		encapsulated = append(encapsulated, "req-hdr=0")
+		if err := writeRequestHeader(&httpBlocks, r.HTTPRequest); err != nil {
+			return nil, err
+		}
+	}
+	if r.Method == MethodRESPMOD {
+		encapsulated = append(encapsulated, "res-hdr="+strconv.Itoa(httpBlocks.Len()))
+		if err := writeResponseHeader(&httpBlocks, r.HTTPResponse); err != nil {
+			return nil, err
+		}
+	}
+	bodyOffset := strconv.Itoa(httpBlocks.Len())
+	switch {
+	case !hasBody:
+		encapsulated = append(encapsulated, "null-body="+bodyOffset)
+	case r.Method == MethodREQMOD:
+		encapsulated = append(encapsulated, "req-body="+bodyOffset)
+	default:
+		encapsulated = append(encapsulated, "res-body="+bodyOffset)
+	}
+
+	var head bytes.Buffer
+	fmt.Fprintf(&head, "%s %s %s%s", r.Method, r.URL.String(), ICAPVersion, CRLF)
+	header := r.Header.Clone()
+	header.Del(EncapsulatedHeader)
+	if err := header.Write(&head); err != nil {
+		return nil, err
+	}
+	head.WriteString(EncapsulatedHeader + ": " + strings.Join(encapsulated, ", ") + DoubleCRLF)
+	head.Write(httpBlocks.Bytes())
+	return head.Bytes(), nil
+}
+
+// writeRequestHeader writes the request line with the absolute URL and the header of the HTTP request
+func writeRequestHeader(w *bytes.Buffer, req *http.Request) error {
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	fmt.Fprintf(w, "%s %s %s%s", method, req.URL.String(), HTTPVersion, CRLF)
+	header := req.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if header.Get("Host") == "" {
+		host := req.Host
+		if host == "" {
+			host = req.URL.Host
+		}
+		header.Set("Host", host)
+	}
+	if err := header.Write(w); err != nil {
+		return err
+	}
+	w.WriteString(CRLF)
+	return nil
+}
+
+// writeResponseHeader writes the status line and the header of the HTTP response
+func writeResponseHeader(w *bytes.Buffer, resp *http.Response) error {
+	status := resp.Status
+	if status == "" {
+		status = strconv.Itoa(resp.StatusCode) + " " + http.StatusText(resp.StatusCode)
+	} else if !strings.HasPrefix(status, strconv.Itoa(resp.StatusCode)) {
+		status = strconv.Itoa(resp.StatusCode) + " " + status
+	}
+	fmt.Fprintf(w, "%s %s%s", HTTPVersion, status, CRLF)
+	if err := resp.Header.Write(w); err != nil {
+		return err
+	}
+	w.WriteString(CRLF)
+	return nil
+}
+
+// DumpRequest returns the given request in its ICAP/1.x wire representation without a preview, the body is
+// read and replaced by a new reader of the same bytes like httputil.DumpRequestOut does
+func DumpRequest(req *Request) ([]byte, error) {
+	var data []byte
+	body := req.body()
+	if body != nil {
+		var err error
+		if data, err = io.ReadAll(body); err != nil {
+			return nil, err
+		}
+		body.Close()
+		restored := io.NopCloser(bytes.NewReader(data))
+		if req.Method == MethodREQMOD {
+			req.HTTPRequest.Body = restored
+		} else {
+			req.HTTPResponse.Body = restored
+		}
+	}
+	saved := req.Header
+	req.Header = req.Header.Clone()
+	req.Header.Del(PreviewHeader)
+	head, err := req.encapsulatedHeaders(body != nil)
+	req.Header = saved
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		return head, nil
+	}
+	var dump bytes.Buffer
+	dump.Write(head)
+	if len(data) > 0 {
+		fmt.Fprintf(&dump, "%x%s%s%s", len(data), CRLF, data, CRLF)
+	}
+	dump.WriteString("0" + DoubleCRLF)
+	return dump.Bytes(), nil
+}
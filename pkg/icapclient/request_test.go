@@ -0,0 +1,244 @@
+package icapclient
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNewRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		urlStr   string
+		method   string
+		httpReq  *http.Request
+		httpResp *http.Response
+		err      error
+	}{
+		{name: "OPTIONS", urlStr: "icap://localhost:1344/something", method: MethodOPTIONS},
+		{name: "RESPMOD", urlStr: "icap://localhost:1344/something", method: MethodRESPMOD,
+			httpResp: &http.Response{}},
+		{name: "REQMOD", urlStr: "icap://localhost:1344/something", method: MethodREQMOD, httpReq: &http.Request{}},
+		{name: "lower case method", urlStr: "icaps://localhost/something", method: "options"},
+		{name: "unknown method", urlStr: "icap://localhost:1344/something", method: "invalid",
+			err: errors.New(ErrMethodNotRegistered)},
+		{name: "http scheme", urlStr: "http://localhost:1344/something", method: MethodOPTIONS,
+			err: errors.New(ErrInvalidScheme)},
+		{name: "no host", urlStr: "icap://", method: MethodOPTIONS, err: errors.New(ErrInvalidHost)},
+		{name: "REQMOD without request", urlStr: "icap://localhost:1344/something", method: MethodREQMOD,
+			err: errors.New(ErrREQMODWithNoReq)},
+		{name: "REQMOD with response", urlStr: "icap://localhost:1344/something", method: MethodREQMOD,
+			httpReq: &http.Request{}, httpResp: &http.Response{}, err: errors.New(ErrREQMODWithResp)},
+		{name: "RESPMOD without response", urlStr: "icap://localhost:1344/something", method: MethodRESPMOD,
+			httpReq: &http.Request{}, err: errors.New(ErrRESPMODWithNoResp)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRequest(tt.method, tt.urlStr, tt.httpReq, tt.httpResp); !reflect.DeepEqual(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNewRequestGetBody(t *testing.T) {
+	httpReq, _ := http.NewRequest(http.MethodPost, "http://someurl.com", strings.NewReader("Hello World"))
+	req, err := NewRequest(MethodREQMOD, "icap://localhost:1344/reqmod", httpReq, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.GetBody == nil {
+		t.Fatal("the GetBody of the HTTP request wasn't kept")
+	}
+	body, _ := req.GetBody()
+	if got := readBody(t, body); got != "Hello World" {
+		t.Fatalf("got body %q, want %q", got, "Hello World")
+	}
+}
+
+func TestDumpRequestOptions(t *testing.T) {
+	req, _ := NewRequest(MethodOPTIONS, "icap://localhost:1344/something", nil, nil)
+	data, err := DumpRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "OPTIONS icap://localhost:1344/something ICAP/1.0\r\nEncapsulated: null-body=0\r\n\r\n"
+	if string(data) != want {
+		t.Fatalf("got\n%q\nwant\n%q", data, want)
+	}
+}
+
+func TestDumpRequestReqmod(t *testing.T) {
+	httpReq, _ := http.NewRequest(http.MethodGet, "http://someurl.com", nil)
+	req, _ := NewRequest(MethodREQMOD, "icap://localhost:1344/something", httpReq, nil)
+	data, err := DumpRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqHdr := "GET http://someurl.com HTTP/1.1\r\nHost: someurl.com\r\n\r\n"
+	want := "REQMOD icap://localhost:1344/something ICAP/1.0\r\nEncapsulated: req-hdr=0, null-body=" +
+		strconv.Itoa(len(reqHdr)) + "\r\n\r\n" + reqHdr
+	if string(data) != want {
+		t.Fatalf("got\n%q\nwant\n%q", data, want)
+	}
+
+	httpReq, _ = http.NewRequest(http.MethodPost, "http://someurl.com", bytes.NewBufferString("Hello World"))
+	req, _ = NewRequest(MethodREQMOD, "icap://localhost:1344/something", httpReq, nil)
+	// the preview isn't dumped
+	req.SetPreview(4)
+	if data, err = DumpRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	reqHdr = "POST http://someurl.com HTTP/1.1\r\nHost: someurl.com\r\n\r\n"
+	want = "REQMOD icap://localhost:1344/something ICAP/1.0\r\nEncapsulated: req-hdr=0, req-body=" +
+		strconv.Itoa(len(reqHdr)) + "\r\n\r\n" + reqHdr + "b\r\nHello World\r\n0\r\n\r\n"
+	if string(data) != want {
+		t.Fatalf("got\n%q\nwant\n%q", data, want)
+	}
+	if req.Header.Get(PreviewHeader) != "4" {
+		t.Fatal("the Preview header of the request was removed")
+	}
+	if got := readBody(t, req.HTTPRequest.Body); got != "Hello World" {
+		t.Fatalf("the body wasn't restored, got %q", got)
+	}
+}
+
+func TestDumpRequestRespmod(t *testing.T) {
+	// the body of the HTTP request isn't encapsulated in RESPMOD
+	httpReq, _ := http.NewRequest(http.MethodPost, "http://someurl.com", bytes.NewBufferString("Hello World"))
+	httpResp := &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.0",
+		ProtoMajor:    1,
+		ProtoMinor:    0,
+		Header:        http.Header{"Content-Type": {"plain/text"}, "Content-Length": {"11"}},
+		ContentLength: 11,
+		Body:          io.NopCloser(strings.NewReader("Hello World")),
+	}
+	req, _ := NewRequest(MethodRESPMOD, "icap://localhost:1344/something", httpReq, httpResp)
+	data, err := DumpRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqHdr := "POST http://someurl.com HTTP/1.1\r\nHost: someurl.com\r\n\r\n"
+	resHdr := "HTTP/1.1 200 OK\r\nContent-Length: 11\r\nContent-Type: plain/text\r\n\r\n"
+	want := "RESPMOD icap://localhost:1344/something ICAP/1.0\r\nEncapsulated: req-hdr=0, res-hdr=" +
+		strconv.Itoa(len(reqHdr)) + ", res-body=" + strconv.Itoa(len(reqHdr)+len(resHdr)) + "\r\n\r\n" +
+		reqHdr + resHdr + "b\r\nHello World\r\n0\r\n\r\n"
+	if string(data) != want {
+		t.Fatalf("got\n%q\nwant\n%q", data, want)
+	}
+}
+
+func TestSetDefaultRequestHeaders(t *testing.T) {
+	req, _ := NewRequest(MethodOPTIONS, "icap://localhost:1344/something", nil, nil)
+	req.SetDefaultRequestHeaders()
+	if got := req.Header.Values("Allow"); !reflect.DeepEqual(got, []string{"204"}) {
+		t.Fatalf("got Allow %v, want [204]", got)
+	}
+	if got := req.Header.Get("Host"); got != "localhost:1344" {
+		t.Fatalf("got Host %q, want the host of the URL", got)
+	}
+
+	// the headers which are set are kept
+	req, _ = NewRequest(MethodOPTIONS, "icap://localhost:1344/something", nil, nil)
+	req.Header.Set("Host", "somehost")
+	req.Header.Set("Allow", "204, 206")
+	req.SetDefaultRequestHeaders()
+	if req.Header.Get("Host") != "somehost" || req.Header.Get("Allow") != "204, 206" {
+		t.Fatalf("the headers were replaced: %v", req.Header)
+	}
+
+	// the host name of the machine is used if the URL has none
+	req, _ = NewRequest(MethodOPTIONS, "icap://localhost:1344/something", nil, nil)
+	req.URL.Host = ""
+	req.SetDefaultRequestHeaders()
+	hostname, _ := os.Hostname()
+	if got := req.Header.Get("Host"); got != hostname {
+		t.Fatalf("got Host %q, want %q", got, hostname)
+	}
+}
+
+func TestExtendHeader(t *testing.T) {
+	tests := []struct {
+		name           string
+		defaultHeaders bool
+		allow          []string
+	}{
+		{name: "without the default headers", allow: []string{"205"}},
+		{name: "with the default headers", defaultHeaders: true, allow: []string{"204", "205"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := NewRequest(MethodOPTIONS, "icap://localhost:1344/something", nil, nil)
+			if tt.defaultHeaders {
+				req.SetDefaultRequestHeaders()
+			}
+			err := req.ExtendHeader(http.Header{
+				"Name":             {"some_name"},
+				"Address":          {"some_address1", "some_address2"},
+				"Allow":            {"205"},
+				"Preview":          {"1024"},
+				EncapsulatedHeader: {"null-body=0"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := req.Header.Values("Allow"); !reflect.DeepEqual(got, tt.allow) {
+				t.Errorf("got Allow %v, want %v", got, tt.allow)
+			}
+			if got := req.Header.Values("Address"); !reflect.DeepEqual(got, []string{"some_address1", "some_address2"}) {
+				t.Errorf("got Address %v", got)
+			}
+			if req.Header.Get("Name") != "some_name" {
+				t.Errorf("got Name %q", req.Header.Get("Name"))
+			}
+			if req.PreviewBytes != 1024 || req.Header.Get(PreviewHeader) != "1024" {
+				t.Errorf("the preview wasn't set, got %d", req.PreviewBytes)
+			}
+			if _, exists := req.Header[EncapsulatedHeader]; exists {
+				t.Error("the Encapsulated header was copied")
+			}
+		})
+	}
+
+	req, _ := NewRequest(MethodOPTIONS, "icap://localhost:1344/something", nil, nil)
+	if err := req.ExtendHeader(http.Header{"Preview": {"many"}}); err == nil {
+		t.Fatal("an invalid Preview should fail")
+	}
+}
+
+func TestSetPreview(t *testing.T) {
+	for _, method := range []string{MethodREQMOD, MethodRESPMOD} {
+		t.Run(method, func(t *testing.T) {
+			httpReq, _ := http.NewRequest(http.MethodPost, "http://someurl.com",
+				strings.NewReader("Hello World! Bye Bye World!"))
+			var httpResp *http.Response
+			if method == MethodRESPMOD {
+				httpResp = &http.Response{StatusCode: http.StatusOK, Header: http.Header{},
+					Body: io.NopCloser(strings.NewReader("Hello World! Bye Bye World!"))}
+			}
+			req, _ := NewRequest(method, "icap://localhost:1344/something", httpReq, httpResp)
+			if err := req.SetPreview(11); err != nil {
+				t.Fatal(err)
+			}
+			if req.PreviewBytes != 11 || req.Header.Get(PreviewHeader) != "11" {
+				t.Fatalf("got preview %d and header %q", req.PreviewBytes, req.Header.Get(PreviewHeader))
+			}
+			// the preview is read from the body when the request is sent only
+			if got := readBody(t, req.body()); got != "Hello World! Bye Bye World!" {
+				t.Fatalf("the body was read, got %q", got)
+			}
+			if err := req.SetPreview(-1); err == nil {
+				t.Fatal("a negative preview should fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package icapclient
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Response represents the icap server response data, the body of the modified message is read from the
+// connection while it's read, so it must be closed
+type Response struct {
+	StatusCode      int
+	Status          string
+	PreviewBytes    int
+	Header          http.Header
+	ContentRequest  *http.Request
+	ContentResponse *http.Response
+}
+
+// Options represents the OPTIONS response of an ICAP service
+type Options struct {
+	Methods          []string
+	Service          string
+	ISTag            string
+	Preview          int // -1 if the service doesn't support previews
+	TransferPreview  []string
+	TransferIgnore   []string
+	TransferComplete []string
+	Allow204         bool
+	Allow206         bool
+	MaxConnections   int
+	TTL              time.Duration
+	ServiceID        string
+	Header           http.Header
+}
+
+// encapsulatedEntry is an entry of the Encapsulated header, ex: res-hdr=120
+type encapsulatedEntry struct {
+	name   string
+	offset int
+}
+
+// ReadResponse reads an ICAP response from the reader, the body of the modified message is read from the
+// reader too
+func ReadResponse(b *bufio.Reader) (*Response, error) {
+	resp, err := readResponseHeader(b)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusContinue {
+		return resp, nil
+	}
+	bodyName, err := resp.readEncapsulated(b)
+	if err != nil {
+		return nil, err
+	}
+	if bodyName == "req-body" || bodyName == "res-body" {
+		resp.setBody(bodyName, io.NopCloser(&chunkedReader{r: b}))
+	}
+	return resp, nil
+}
+
+// readResponseHeader reads the status line and the header of an ICAP response
+func readResponseHeader(b *bufio.Reader) (*Response, error) {
+	tp := textproto.NewReader(b)
+	line, err := tp.ReadLine()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	proto, status, _ := strings.Cut(line, " ")
+	code, text, _ := strings.Cut(status, " ")
+	if !strings.HasPrefix(proto, "ICAP/") || len(code) != 3 {
+		return nil, errors.New(ErrInvalidTCPMsg + ": " + line)
+	}
+	resp := &Response{Status: strings.TrimSpace(text)}
+	if resp.StatusCode, err = strconv.Atoi(code); err != nil {
+		return nil, errors.New(ErrInvalidTCPMsg + ": " + line)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	resp.Header = http.Header(header)
+	if preview := resp.Header.Get(PreviewHeader); preview != "" {
+		resp.PreviewBytes, _ = strconv.Atoi(preview)
+	}
+	return resp, nil
+}
+
+// readEncapsulated reads the HTTP headers of the encapsulated part by their offsets and returns the name of
+// the body entry, null-body if the Encapsulated header is missing
+func (r *Response) readEncapsulated(b *bufio.Reader) (string, error) {
+	entries, err := parseEncapsulated(r.Header.Get(EncapsulatedHeader))
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "null-body", nil
+	}
+	for i, entry := range entries {
+		if !strings.HasSuffix(entry.name, "-hdr") {
+			if i != len(entries)-1 {
+				return "", errors.New("the body must be the last entry of the Encapsulated header")
+			}
+			return entry.name, nil
+		}
+		var section *bufio.Reader
+		if i == len(entries)-1 {
+			section = b
+		} else {
+			data := make([]byte, entries[i+1].offset-entry.offset)
+			if _, err := io.ReadFull(b, data); err != nil {
+				return "", err
+			}
+			section = bufio.NewReader(bytes.NewReader(data))
+		}
+		switch entry.name {
+		case "req-hdr":
+			if r.ContentRequest, err = http.ReadRequest(section); err != nil {
+				return "", err
+			}
+			r.ContentRequest.Body = http.NoBody
+		case "res-hdr":
+			if r.ContentResponse, err = http.ReadResponse(section, r.ContentRequest); err != nil {
+				return "", err
+			}
+			r.ContentResponse.Body = http.NoBody
+		default:
+			return "", errors.New("unknown Encapsulated entry " + entry.name)
+		}
+	}
+	return "null-body", nil
+}
+
+// setBody sets the body of the message which the body entry belongs to
+func (r *Response) setBody(name string, body io.ReadCloser) {
+	if name == "req-body" && r.ContentRequest != nil {
+		r.ContentRequest.Body = body
+	} else if name == "res-body" && r.ContentResponse != nil {
+		r.ContentResponse.Body = body
+	} else {
+		body.Close()
+	}
+}
+
+// parseEncapsulated parses the Encapsulated header, the entries are sorted by their offsets
+func parseEncapsulated(value string) ([]encapsulatedEntry, error) {
+	var entries []encapsulatedEntry
+	if strings.TrimSpace(value) == "" {
+		return entries, nil
+	}
+	for _, part := range strings.Split(value, ",") {
+		name, offset, found := strings.Cut(strings.TrimSpace(part), "=")
+		n, err := strconv.Atoi(strings.TrimSpace(offset))
+		if !found || err != nil || n < 0 {
+			return nil, errors.New("invalid Encapsulated header " + strconv.Quote(value))
+		}
+		entries = append(entries, encapsulatedEntry{name: strings.ToLower(strings.TrimSpace(name)), offset: n})
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].offset < entries[j].offset })
+	return entries, nil
+}
+
+// optionsOf reads the capabilities of the service from the OPTIONS response
+func optionsOf(resp *Response) *Options {
+	opts := &Options{
+		Methods:          splitList(resp.Header.Get(MethodsHeader)),
+		Service:          resp.Header.Get(ServiceHeader),
+		ISTag:            resp.Header.Get(ISTagHeader),
+		Preview:          -1,
+		TransferPreview:  splitList(resp.Header.Get(TransferPreviewHeader)),
+		TransferIgnore:   splitList(resp.Header.Get(TransferIgnoreHeader)),
+		TransferComplete: splitList(resp.Header.Get(TransferCompleteHeader)),
+		ServiceID:        resp.Header.Get(ServiceIDHeader),
+		Header:           resp.Header,
+	}
+	if preview, err := strconv.Atoi(resp.Header.Get(PreviewHeader)); err == nil {
+		opts.Preview = preview
+	}
+	for _, allowed := range splitList(strings.Join(resp.Header.Values(AllowHeader), ",")) {
+		switch allowed {
+		case "204":
+			opts.Allow204 = true
+		case "206":
+			opts.Allow206 = true
+		}
+	}
+	opts.MaxConnections, _ = strconv.Atoi(resp.Header.Get(MaxConnectionsHeader))
+	if ttl, err := strconv.Atoi(resp.Header.Get(OptionsTTLHeader)); err == nil {
+		opts.TTL = time.Duration(ttl) * time.Second
+	}
+	return opts
+}
+
+// splitList splits a comma separated header value
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Supports reports whether the service supports the method
+func (o *Options) Supports(method string) bool {
+	for _, m := range o.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
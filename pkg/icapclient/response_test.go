@@ -0,0 +1,126 @@
+package icapclient
+
+import (
+	"bufio"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func readResponseString(t *testing.T, s string) *Response {
+	t.Helper()
+	resp, err := ReadResponse(bufio.NewReader(strings.NewReader(s)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestReadResponseReqmod(t *testing.T) {
+	icapHdr := "ICAP/1.0 200 OK\r\n" +
+		"Date: Mon, 10 Jan 2000  09:55:21 GMT\r\n" +
+		"Server: ICAP-Server-Software/1.0\r\n" +
+		"Connection: close\r\n" +
+		"ISTag: \"W3E4R7U9-L2E4-2\"\r\n"
+	reqHdr := "GET /modified-path HTTP/1.1\r\n" +
+		"Host: www.origin-server.com\r\n" +
+		"Via: 1.0 icap-server.net (ICAP Example ReqMod Service 1.1)\r\n" +
+		"Accept: text/html, text/plain, image/gif\r\n" +
+		"Accept-Encoding: gzip, compress\r\n" +
+		"If-None-Match: \"xyzzy\", \"r2d2xxxx\"\r\n\r\n"
+	resp := readResponseString(t, icapHdr+"Encapsulated: req-hdr=0, null-body="+strconv.Itoa(len(reqHdr))+
+		"\r\n\r\n"+reqHdr)
+	if resp.StatusCode != http.StatusOK || resp.Status != "OK" || resp.PreviewBytes != 0 {
+		t.Fatalf("got status %d %q and preview %d", resp.StatusCode, resp.Status, resp.PreviewBytes)
+	}
+	if resp.Header.Get("Server") != "ICAP-Server-Software/1.0" || resp.Header.Get(ISTagHeader) != `"W3E4R7U9-L2E4-2"` {
+		t.Fatalf("unexpected header %v", resp.Header)
+	}
+	httpReq := resp.ContentRequest
+	if httpReq == nil || httpReq.URL.Path != "/modified-path" || httpReq.Host != "www.origin-server.com" ||
+		httpReq.Header.Get("If-None-Match") != `"xyzzy", "r2d2xxxx"` || httpReq.Body != http.NoBody {
+		t.Fatalf("unexpected HTTP request %+v", httpReq)
+	}
+
+	reqHdr = "POST /origin-resource/form.pl HTTP/1.1\r\n" +
+		"Host: www.origin-server.com\r\n" +
+		"Via: 1.0 icap-server.net (ICAP Example ReqMod Service 1.1)\r\n" +
+		"Accept: text/html, text/plain, image/gif\r\n" +
+		"Accept-Encoding: gzip, compress\r\n" +
+		"Pragma: no-cache\r\n" +
+		"Content-Length: 45\r\n\r\n"
+	resp = readResponseString(t, icapHdr+"Encapsulated: req-hdr=0, req-body="+strconv.Itoa(len(reqHdr))+
+		"\r\n\r\n"+reqHdr+"2d\r\nI am posting this information.  ICAP powered!\r\n0\r\n\r\n")
+	if resp.ContentRequest == nil || resp.ContentRequest.Method != http.MethodPost {
+		t.Fatalf("unexpected HTTP request %+v", resp.ContentRequest)
+	}
+	if got := readBody(t, resp.ContentRequest.Body); got != "I am posting this information.  ICAP powered!" {
+		t.Fatalf("got body %q", got)
+	}
+}
+
+func TestReadResponseRespmod(t *testing.T) {
+	resHdr := "HTTP/1.1 200 OK\r\n" +
+		"Date: Mon, 10 Jan 2000  09:55:21 GMT\r\n" +
+		"Via: 1.0 icap.example.org (ICAP Example RespMod Service 1.1)\r\n" +
+		"Server: Apache/1.3.6 (Unix)\r\n" +
+		"ETag: \"63840-1ab7-378d415b\"\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Length: 91\r\n\r\n"
+	body := "This is data that was returned by an origin server, but with value added by an ICAP server."
+	resp := readResponseString(t, "ICAP/1.0 200 OK\r\n"+
+		"Server: ICAP-Server-Software/1.0\r\n"+
+		"ISTag: \"W3E4R7U9-L2E4-2\"\r\n"+
+		"Encapsulated: res-hdr=0, res-body="+strconv.Itoa(len(resHdr))+"\r\n\r\n"+
+		resHdr+strconv.FormatInt(int64(len(body)), 16)+"\r\n"+body+"\r\n0\r\n\r\n")
+	if resp.StatusCode != http.StatusOK || resp.ContentRequest != nil {
+		t.Fatalf("got status %d and HTTP request %v", resp.StatusCode, resp.ContentRequest)
+	}
+	httpResp := resp.ContentResponse
+	if httpResp == nil || httpResp.StatusCode != http.StatusOK || httpResp.Header.Get("ETag") != `"63840-1ab7-378d415b"` {
+		t.Fatalf("unexpected HTTP response %+v", httpResp)
+	}
+	if got := readBody(t, httpResp.Body); got != body {
+		t.Fatalf("got body %q, want %q", got, body)
+	}
+}
+
+func TestReadResponseWithoutEncapsulated(t *testing.T) {
+	resp := readResponseString(t, "ICAP/1.0 100 Continue\r\n\r\n")
+	if resp.StatusCode != http.StatusContinue || resp.Status != "Continue" {
+		t.Fatalf("got status %d %q", resp.StatusCode, resp.Status)
+	}
+	resp = readResponseString(t, "ICAP/1.0 200 OK\r\nPreview: 1024\r\n\r\n")
+	if resp.PreviewBytes != 1024 || resp.ContentRequest != nil || resp.ContentResponse != nil {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+}
+
+func TestReadResponseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		resp string
+		err  string
+	}{
+		{name: "empty", resp: "", err: "unexpected EOF"},
+		{name: "HTTP status line", resp: "HTTP/1.1 200 OK\r\n\r\n", err: ErrInvalidTCPMsg},
+		{name: "invalid status code", resp: "ICAP/1.0 2x0 OK\r\n\r\n", err: ErrInvalidTCPMsg},
+		{name: "invalid Encapsulated", resp: "ICAP/1.0 200 OK\r\nEncapsulated: res-hdr\r\n\r\n",
+			err: "invalid Encapsulated header"},
+		{name: "body before the header", resp: "ICAP/1.0 200 OK\r\nEncapsulated: res-body=0, res-hdr=10\r\n\r\n",
+			err: "the body must be the last entry"},
+		{name: "unknown entry", resp: "ICAP/1.0 200 OK\r\nEncapsulated: opt-hdr=0, null-body=5\r\n\r\nabcde",
+			err: "unknown Encapsulated entry opt-hdr"},
+		{name: "truncated HTTP header", resp: "ICAP/1.0 200 OK\r\nEncapsulated: res-hdr=0, null-body=40\r\n\r\n" +
+			"HTTP/1.1 200 OK\r\n", err: "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadResponse(bufio.NewReader(strings.NewReader(tt.resp)))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
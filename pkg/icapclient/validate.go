@@ -18,7 +18,7 @@ func validMethod(method string) (bool, error) {
 // validURL validates the Server URL provided
 func validURL(url *url.URL) (bool, error) {
 
-	if url.Scheme != SchemeICAP {
+	if url.Scheme != SchemeICAP && url.Scheme != SchemeICAPS {
 		return false, errors.New(ErrInvalidScheme)
 	}
 
@@ -0,0 +1,52 @@
+// The echo plugin is a sample of the vendor contract of ICAPeg, it returns every HTTP message as it is. Build it
+// with the same Go version as the server and copy it to the dir of [app.plugins]:
+//
+//	go build -buildmode=plugin -o plugins/echo.so ./plugins/echo
+package main
+
+import (
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	"icapeg/service/registry"
+	"net/textproto"
+)
+
+// VendorName is the vendor of the services which use this plugin, ex: vendor = "plugin_echo"
+const VendorName = "plugin_echo"
+
+func init() {
+	registry.Register(VendorName, registry.Vendor{New: newEcho})
+}
+
+// echo processes one HTTP message
+type echo struct {
+	serviceName   string
+	methodName    string
+	httpMsg       *http_message.HttpMsg
+	xICAPMetadata string
+}
+
+func newEcho(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) registry.Service {
+	return &echo{serviceName: serviceName, methodName: methodName, httpMsg: httpMsg, xICAPMetadata: xICAPMetadata}
+}
+
+// Processing returns 204, the HTTP message is returned as it is, even after a preview
+func (e *echo) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int, interface{}, map[string]string,
+	map[string]interface{}, map[string]interface{}, map[string]interface{}) {
+	logging.Logger.Info(utils.PrepareLogMsg(e.xICAPMetadata, e.serviceName+" service returned the HTTP message as it is"))
+	var httpMsg interface{} = e.httpMsg.Response
+	if e.methodName == utils.ICAPModeReq {
+		httpMsg = e.httpMsg.Request
+	}
+	return utils.NoModificationStatusCodeStr, httpMsg, map[string]string{"X-ICAP-Metadata": e.xICAPMetadata},
+		map[string]interface{}{}, map[string]interface{}{}, map[string]interface{}{}
+}
+
+// ISTagValue returns the ISTag of the plugin, it never changes because the plugin has no definitions
+func (e *echo) ISTagValue() string {
+	return "plugin-echo-1"
+}
+
+// main is never called, go build ./... needs it in a main package
+func main() {}
@@ -0,0 +1,71 @@
+// The reject-all plugin is a sample of the vendor contract of ICAPeg, it blocks every HTTP message with a 403
+// response, ex: to quarantine a service during an incident. Build it with the same Go version as the server and
+// copy it to the dir of [app.plugins]:
+//
+//	go build -buildmode=plugin -o plugins/rejectall.so ./plugins/rejectall
+package main
+
+import (
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/icap"
+	"icapeg/logging"
+	"icapeg/service/registry"
+	"net/http"
+	"net/textproto"
+	"strconv"
+)
+
+// VendorName is the vendor of the services which use this plugin, ex: vendor = "reject_all"
+const VendorName = "reject_all"
+
+// the body of the 403 responses
+const rejectedPage = "This content is blocked by the security policy.\n"
+
+func init() {
+	registry.Register(VendorName, registry.Vendor{New: newRejectAll})
+}
+
+// rejectAll processes one HTTP message
+type rejectAll struct {
+	serviceName   string
+	xICAPMetadata string
+}
+
+func newRejectAll(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) registry.Service {
+	return &rejectAll{serviceName: serviceName, xICAPMetadata: xICAPMetadata}
+}
+
+// Processing answers every HTTP message with a 403 response, a preview is answered without the rest of the body
+func (r *rejectAll) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int, interface{}, map[string]string,
+	map[string]interface{}, map[string]interface{}, map[string]interface{}) {
+	logging.Logger.Info(utils.PrepareLogMsg(r.xICAPMetadata, r.serviceName+" service rejected the HTTP message"))
+	resp := &http.Response{
+		StatusCode: http.StatusForbidden,
+		Status:     strconv.Itoa(http.StatusForbidden) + " " + http.StatusText(http.StatusForbidden),
+		Header: http.Header{
+			utils.ContentType:   []string{"text/plain"},
+			utils.ContentLength: []string{strconv.Itoa(len(rejectedPage))},
+		},
+		Body: icap.NewBody([]byte(rejectedPage)),
+	}
+	vendorMsgs := map[string]interface{}{
+		utils.VendorMsgVerdict: utils.SampleSeverityMalicious,
+		utils.VendorMsgThreat:  "RejectAll",
+	}
+	return utils.OkStatusCodeStr, resp, map[string]string{"X-ICAP-Metadata": r.xICAPMetadata},
+		map[string]interface{}{}, map[string]interface{}{}, vendorMsgs
+}
+
+// ISTagValue returns the ISTag of the plugin, it never changes because the plugin has no definitions
+func (r *rejectAll) ISTagValue() string {
+	return "plugin-reject-all-1"
+}
+
+// OptionsHeaders asks the ICAP clients for a zero-byte preview, the verdict doesn't depend on the body
+func (r *rejectAll) OptionsHeaders() map[string]string {
+	return map[string]string{"Preview": "0"}
+}
+
+// main is never called, go build ./... needs it in a main package
+func main() {}
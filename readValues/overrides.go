@@ -0,0 +1,99 @@
+package readValues
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix is the prefix of the environment variables which override the keys of the configuration file, the
+// key app.port is overridden by ICAPEG_APP_PORT
+const EnvPrefix = "ICAPEG_"
+
+// resolve returns the configuration of the file with its keys overridden by the environment variables and its
+// secret references fetched from their secrets managers. A secret which can't be fetched fails the load, so a
+// vendor never starts without its credentials
+func resolve(v *viper.Viper) (*viper.Viper, error) {
+	settings := v.AllSettings()
+	secrets := newSecretResolver()
+	if err := resolveSection(settings, "", secrets); err != nil {
+		return nil, err
+	}
+	resolved := viper.New()
+	resolved.SetConfigFile(v.ConfigFileUsed())
+	if err := resolved.MergeConfigMap(settings); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// resolveSection resolves the keys of a section and of its sub sections, path is the path of the section
+func resolveSection(section map[string]interface{}, path string, secrets *secretResolver) error {
+	keys := make([]string, 0, len(section))
+	for key := range section {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+		if table, isTable := section[key].(map[string]interface{}); isTable {
+			if err := resolveSection(table, keyPath, secrets); err != nil {
+				return err
+			}
+			continue
+		}
+		value := section[key]
+		if override, found := os.LookupEnv(envName(keyPath)); found {
+			var err error
+			if value, err = convertOverride(override, value); err != nil {
+				return errors.New(envName(keyPath) + " can't override " + keyPath + ": " + err.Error())
+			}
+		}
+		value, err := secrets.resolveValue(value)
+		if err != nil {
+			return errors.New(keyPath + ": " + err.Error())
+		}
+		section[key] = value
+	}
+	return nil
+}
+
+// envName returns the name of the environment variable which overrides the key, the characters which can't be
+// in the name of a variable are replaced by underscores
+func envName(keyPath string) string {
+	return EnvPrefix + strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(keyPath))
+}
+
+// convertOverride converts the value of the environment variable to the type of the value in the file, the
+// values of the arrays are separated by commas
+func convertOverride(override string, value interface{}) (interface{}, error) {
+	switch value.(type) {
+	case bool:
+		return strconv.ParseBool(override)
+	case int, int64:
+		return strconv.ParseInt(override, 10, 64)
+	case float64:
+		return strconv.ParseFloat(override, 64)
+	case []interface{}:
+		values := []interface{}{}
+		for _, item := range strings.Split(override, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		return values, nil
+	}
+	return override, nil
+}
@@ -0,0 +1,98 @@
+package readValues
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestResolveEnvOverrides(t *testing.T) {
+	t.Setenv("ICAPEG_APP_PORT", "1345")
+	t.Setenv("ICAPEG_APP_SERVICES", "echo, clamav")
+	t.Setenv("ICAPEG_VIRUSTOTAL_BYPASS_ON_API_ERROR", "true")
+	v := viper.New()
+	v.SetConfigType("toml")
+	err := v.ReadConfig(strings.NewReader("[app]\nport = 1344\nservices = [\"echo\"]\n" +
+		"[virustotal]\nbypass_on_api_error = false\napi_key = \"key\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := resolve(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port := resolved.GetInt("app.port"); port != 1345 {
+		t.Errorf("app.port is %d, want 1345", port)
+	}
+	if services := resolved.GetStringSlice("app.services"); len(services) != 2 || services[1] != "clamav" {
+		t.Errorf("app.services is %v, want [echo clamav]", services)
+	}
+	if !resolved.GetBool("virustotal.bypass_on_api_error") {
+		t.Error("virustotal.bypass_on_api_error wasn't overridden")
+	}
+	if key := resolved.GetString("virustotal.api_key"); key != "key" {
+		t.Errorf("virustotal.api_key is %q, want the value of the file", key)
+	}
+
+	t.Setenv("ICAPEG_APP_PORT", "not a port")
+	if _, err = resolve(v); err == nil {
+		t.Error("an invalid override was accepted")
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" || r.URL.Path != "/v1/secret/data/icapeg" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"api_key":"from-vault"},"metadata":{"version":1}}}`))
+	}))
+	defer vault.Close()
+	aws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct{ SecretId string }
+		json.NewDecoder(r.Body).Decode(&input)
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" ||
+			!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			input.SecretId != "icapeg/clamav" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"SecretString":"{\"password\":\"from-aws\"}"}`))
+	}))
+	defer aws.Close()
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN", "token")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_ENDPOINT_URL_SECRETS_MANAGER", aws.URL)
+	t.Setenv("ICAPEG_CLAMAV_PASSWORD", "aws-sm:icapeg/clamav#password")
+
+	v := viper.New()
+	v.SetConfigType("toml")
+	err := v.ReadConfig(strings.NewReader("[virustotal]\napi_key = \"vault:secret/data/icapeg#api_key\"\n" +
+		"[clamav]\npassword = \"\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := resolve(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := resolved.GetString("virustotal.api_key"); key != "from-vault" {
+		t.Errorf("virustotal.api_key is %q, want the secret of vault", key)
+	}
+	if password := resolved.GetString("clamav.password"); password != "from-aws" {
+		t.Errorf("clamav.password is %q, want the secret of aws secrets manager", password)
+	}
+
+	t.Setenv("VAULT_TOKEN", "revoked")
+	if _, err = resolve(v); err == nil {
+		t.Error("a secret which couldn't be fetched was accepted")
+	}
+}
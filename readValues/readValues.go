@@ -4,30 +4,132 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/spf13/viper"
 )
 
+// MissingKeyError is the error of the Reader which read a key which doesn't exist
+type MissingKeyError struct {
+	Key string
+}
+
+func (e *MissingKeyError) Error() string {
+	return e.Key + " doesn't exist in config.go file"
+}
+
+var (
+	snapshotMu sync.RWMutex
+	// the configuration file as it was read, the reads of the keys don't read the file again so the
+	// requests which are processed while the configuration is reloaded read a consistent configuration
+	snapshot *viper.Viper
+	// the reader of the ReadValues funcs, it exits on the keys which don't exist
+	exiting = &Reader{}
+)
+
+// Load reads the configuration file into a new snapshot which the reads use from now on, the file is found
+// in the config paths of viper. Its keys are overridden by the ICAPEG_ environment variables and its secret
+// references are fetched again. It returns the func which restores the previous snapshot
+func Load() (restore func(), err error) {
+	if err = viper.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	v := viper.New()
+	v.SetConfigFile(viper.ConfigFileUsed())
+	if err = v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	if v, err = resolve(v); err != nil {
+		return nil, err
+	}
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+	previous := snapshot
+	snapshot = v
+	return func() {
+		snapshotMu.Lock()
+		defer snapshotMu.Unlock()
+		snapshot = previous
+	}, nil
+}
+
+// current returns the snapshot of the configuration file, it's read the first time a key is read
+func current() *viper.Viper {
+	snapshotMu.RLock()
+	v := snapshot
+	snapshotMu.RUnlock()
+	if v != nil {
+		return v
+	}
+	if _, err := Load(); err != nil {
+		log.Fatal(err.Error())
+	}
+	snapshotMu.RLock()
+	defer snapshotMu.RUnlock()
+	return snapshot
+}
+
+// Reader reads the keys like the ReadValues funcs do, the zero Reader exits on the keys which don't exist like
+// them. The Reader of NewReader reads them as their zero value and Err returns the first one, so the reload
+// reads the new configuration with its own Reader and fails without stopping ICAPeg while the other goroutines
+// keep exiting on a missing key
+type Reader struct {
+	lenient bool
+	mu      sync.Mutex
+	err     *MissingKeyError
+}
+
+// NewReader returns a Reader which records the keys which don't exist instead of exiting
+func NewReader() *Reader {
+	return &Reader{lenient: true}
+}
+
+// Err returns the first key which was read by the Reader but didn't exist, nil if there was none
+func (r *Reader) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		return nil
+	}
+	return r.err
+}
+
+// missingKey exits because a key which is required doesn't exist, the Reader of NewReader records it instead
+func (r *Reader) missingKey(varName string) {
+	if r.lenient {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.err == nil {
+			r.err = &MissingKeyError{Key: varName}
+		}
+		return
+	}
+	fmt.Println(varName + " doesn't exist in config.go file")
+	os.Exit(1)
+}
+
 // ReadValuesInt is used to get the int value of from toml or from env vars
 //if it found the value of var in the toml value starts with "$_", it calls ReadIntFromEnv which
 //retrieves th e value from env vars of the machine
 func ReadValuesInt(varName string) int {
+	return exiting.ReadValuesInt(varName)
+}
 
-	if err := viper.ReadInConfig(); err != nil {
-		log.Fatal(err.Error())
-	}
+// ReadValuesInt reads the int value of the key, the Reader of NewReader records it if it doesn't exist
+func (r *Reader) ReadValuesInt(varName string) int {
+	v := current()
 	var result int
-	tempName := viper.GetString(varName)
+	tempName := v.GetString(varName)
 	if strings.Index(tempName, "$_") == 0 {
 		result = ReadIntFromEnv(tempName[2:len(tempName)])
 	} else {
-		if !viper.IsSet(varName) {
-			fmt.Println(varName + " doesn't exist in config.go file")
-			os.Exit(1)
+		if !v.IsSet(varName) {
+			r.missingKey(varName)
 		}
-		result = viper.GetInt(varName)
+		result = v.GetInt(varName)
 	}
 	return result
 }
@@ -36,20 +138,21 @@ func ReadValuesInt(varName string) int {
 //if it found the value of var in the toml value starts with "$_", it calls ReadIntFromEnv which
 //retrieves th e value from env vars of the machine
 func ReadValuesString(varName string) string {
+	return exiting.ReadValuesString(varName)
+}
 
-	if err := viper.ReadInConfig(); err != nil {
-		log.Fatal(err.Error())
-	}
+// ReadValuesString reads the string value of the key, the Reader of NewReader records it if it doesn't exist
+func (r *Reader) ReadValuesString(varName string) string {
+	v := current()
 	var result string
-	tempName := viper.GetString(varName)
+	tempName := v.GetString(varName)
 	if strings.Index(tempName, "$_") == 0 {
 		result = ReadStringFromEnv(tempName[2:len(tempName)])
 	} else {
-		if !viper.IsSet(varName) {
-			fmt.Println(varName + " doesn't exist in config.go file")
-			os.Exit(1)
+		if !v.IsSet(varName) {
+			r.missingKey(varName)
 		}
-		result = viper.GetString(varName)
+		result = v.GetString(varName)
 	}
 	return result
 }
@@ -58,20 +161,21 @@ func ReadValuesString(varName string) string {
 //if it found the value of var in the toml value starts with "$_", it calls ReadIntFromEnv which
 //retrieves th e value from env vars of the machine
 func ReadValuesBool(varName string) bool {
+	return exiting.ReadValuesBool(varName)
+}
 
-	if err := viper.ReadInConfig(); err != nil {
-		log.Fatal(err.Error())
-	}
+// ReadValuesBool reads the bool value of the key, the Reader of NewReader records it if it doesn't exist
+func (r *Reader) ReadValuesBool(varName string) bool {
+	v := current()
 	var result bool
-	tempName := viper.GetString(varName)
+	tempName := v.GetString(varName)
 	if strings.Index(tempName, "$_") == 0 {
 		result = ReadBoolFromEnv(tempName[2:len(tempName)])
 	} else {
-		if !viper.IsSet(varName) {
-			fmt.Println(varName + " doesn't exist in config.go file")
-			os.Exit(1)
+		if !v.IsSet(varName) {
+			r.missingKey(varName)
 		}
-		result = viper.GetBool(varName)
+		result = v.GetBool(varName)
 	}
 	return result
 }
@@ -80,20 +184,21 @@ func ReadValuesBool(varName string) bool {
 //if it found the value of var in the toml value starts with "$_", it calls ReadIntFromEnv which
 //retrieves th e value from env vars of the machine
 func ReadValuesDuration(varName string) time.Duration {
+	return exiting.ReadValuesDuration(varName)
+}
 
-	if err := viper.ReadInConfig(); err != nil {
-		log.Fatal(err.Error())
-	}
+// ReadValuesDuration reads the duration value of the key, the Reader of NewReader records it if it doesn't exist
+func (r *Reader) ReadValuesDuration(varName string) time.Duration {
+	v := current()
 	var result time.Duration
-	tempName := viper.GetString(varName)
+	tempName := v.GetString(varName)
 	if strings.Index(tempName, "$_") == 0 {
 		result = ReadDurationFromEnv(tempName[2:len(tempName)])
 	} else {
-		if !viper.IsSet(varName) {
-			fmt.Println(varName + " doesn't exist in config.go file")
-			os.Exit(1)
+		if !v.IsSet(varName) {
+			r.missingKey(varName)
 		}
-		result = viper.GetDuration(varName)
+		result = v.GetDuration(varName)
 	}
 	return result
 }
@@ -102,25 +207,66 @@ func ReadValuesDuration(varName string) time.Duration {
 //if it found the value of var in the toml value starts with "$_", it calls ReadIntFromEnv which
 //retrieves th e value from env vars of the machine
 func ReadValuesSlice(varName string) []string {
+	return exiting.ReadValuesSlice(varName)
+}
 
-	if err := viper.ReadInConfig(); err != nil {
-		log.Fatal(err.Error())
-	}
+// ReadValuesSlice reads the string slice value of the key, the Reader of NewReader records it if it doesn't exist
+func (r *Reader) ReadValuesSlice(varName string) []string {
+	v := current()
 	var result []string
-	tempName := viper.GetString(varName)
+	tempName := v.GetString(varName)
 	if strings.Index(tempName, "$_") == 0 {
 		result = ReadSliceFromEnv(tempName[2:len(tempName)])
 	} else {
-		if !viper.IsSet(varName) {
-			fmt.Println(varName + " doesn't exist in config.go file")
-			os.Exit(1)
+		if !v.IsSet(varName) {
+			r.missingKey(varName)
 		}
-		result = viper.GetStringSlice(varName)
+		result = v.GetStringSlice(varName)
 	}
 	return result
 }
 
 // IsSecExists is used to check if a section exists in config.go file or not
 func IsSecExists(varName string) bool {
-	return viper.IsSet(varName)
+	return current().IsSet(varName)
+}
+
+// ReadValuesMap is used to get the string map value of a table from toml file
+func ReadValuesMap(varName string) map[string]string {
+	return exiting.ReadValuesMap(varName)
+}
+
+// ReadValuesMap reads the string map of the key, the Reader of NewReader records it if it doesn't exist
+func (r *Reader) ReadValuesMap(varName string) map[string]string {
+	v := current()
+	if !v.IsSet(varName) {
+		r.missingKey(varName)
+	}
+	return v.GetStringMapString(varName)
+}
+
+// ReadKeys is used to get the keys of a section in toml file without its sub sections, with their values as
+// they're in the file
+func ReadKeys(varName string) map[string]interface{} {
+	v := current()
+	result := make(map[string]interface{})
+	for key, value := range v.GetStringMap(varName) {
+		if _, isTable := value.(map[string]interface{}); !isTable {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// ReadSubSections is used to get the names of the sub sections (tables) of a section in toml file
+func ReadSubSections(varName string) []string {
+	v := current()
+	var result []string
+	for key, value := range v.GetStringMap(varName) {
+		if _, isTable := value.(map[string]interface{}); isTable {
+			result = append(result, key)
+		}
+	}
+	sort.Strings(result)
+	return result
 }
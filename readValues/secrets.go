@@ -0,0 +1,246 @@
+package readValues
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the prefixes of the values which are fetched from a secrets manager, vault:path#key reads the key of the
+// secret at path in HashiCorp Vault and aws-sm:name#key reads the secret name of AWS Secrets Manager, or the
+// key of its JSON object
+const (
+	vaultPrefix   = "vault:"
+	awsSMPrefix   = "aws-sm:"
+	secretTimeout = 10 * time.Second
+)
+
+// secretResolver fetches the secret references of a load of the configuration, a secret which several keys
+// reference is fetched once
+type secretResolver struct {
+	client  *http.Client
+	fetched map[string]string
+	now     func() time.Time
+}
+
+func newSecretResolver() *secretResolver {
+	return &secretResolver{client: &http.Client{Timeout: secretTimeout}, fetched: make(map[string]string),
+		now: time.Now}
+}
+
+// resolveValue replaces the value, or the values of an array, by its secret if it's a secret reference
+func (s *secretResolver) resolveValue(value interface{}) (interface{}, error) {
+	switch typed := value.(type) {
+	case string:
+		return s.resolveString(typed)
+	case []interface{}:
+		values := make([]interface{}, len(typed))
+		for i, item := range typed {
+			resolved, err := s.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = resolved
+		}
+		return values, nil
+	}
+	return value, nil
+}
+
+func (s *secretResolver) resolveString(value string) (interface{}, error) {
+	if !strings.HasPrefix(value, vaultPrefix) && !strings.HasPrefix(value, awsSMPrefix) {
+		return value, nil
+	}
+	if secret, found := s.fetched[value]; found {
+		return secret, nil
+	}
+	var secret string
+	var err error
+	if strings.HasPrefix(value, vaultPrefix) {
+		secret, err = s.fetchVault(strings.TrimPrefix(value, vaultPrefix))
+	} else {
+		secret, err = s.fetchAWSSecretsManager(strings.TrimPrefix(value, awsSMPrefix))
+	}
+	if err != nil {
+		return nil, errors.New("couldn't fetch " + value + ": " + err.Error())
+	}
+	s.fetched[value] = secret
+	return secret, nil
+}
+
+// fetchVault reads the secret from the KV secrets engine (version 1 or 2) of the Vault of VAULT_ADDR with the
+// token of VAULT_TOKEN, the key can be left out if the secret has a single key
+func (s *secretResolver) fetchVault(reference string) (string, error) {
+	path, key := splitReference(reference)
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return "", errors.New("VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	body, err := s.do(req)
+	if err != nil {
+		return "", err
+	}
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err = json.Unmarshal(body, &secret); err != nil {
+		return "", err
+	}
+	data := secret.Data
+	// the KV version 2 secrets have their keys in data.data
+	if inner, isInner := data["data"].(map[string]interface{}); isInner {
+		if _, hasMetadata := data["metadata"]; hasMetadata {
+			data = inner
+		}
+	}
+	return pickKey(data, key)
+}
+
+// fetchAWSSecretsManager reads the secret with GetSecretValue, the region and the credentials are the ones of
+// the AWS_REGION, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables
+func (s *secretResolver) fetchAWSSecretsManager(reference string) (string, error) {
+	name, key := splitReference(reference)
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if region == "" || accessKey == "" || secretKey == "" {
+		return "", errors.New("AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_SECRETS_MANAGER")
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+	payload, _ := json.Marshal(map[string]string{"SecretId": name})
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if sessionToken := os.Getenv("AWS_SESSION_TOKEN"); sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+	signAWS(req, payload, region, accessKey, secretKey, s.now().UTC())
+	body, err := s.do(req)
+	if err != nil {
+		return "", err
+	}
+	var secret struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err = json.Unmarshal(body, &secret); err != nil {
+		return "", err
+	}
+	if key == "" {
+		return secret.SecretString, nil
+	}
+	var data map[string]interface{}
+	if err = json.Unmarshal([]byte(secret.SecretString), &data); err != nil {
+		return "", errors.New("the secret isn't a JSON object, it has no key " + key)
+	}
+	return pickKey(data, key)
+}
+
+// do sends the request, it returns the body of a 2xx response
+func (s *secretResolver) do(req *http.Request) ([]byte, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, errors.New(req.Method + " " + req.URL.String() + ": " + resp.Status)
+	}
+	return body, nil
+}
+
+// splitReference splits the reference into the path or the name of the secret and the key after #
+func splitReference(reference string) (string, string) {
+	if i := strings.LastIndex(reference, "#"); i != -1 {
+		return reference[:i], reference[i+1:]
+	}
+	return reference, ""
+}
+
+// pickKey returns the value of the key of the secret, the value of its single key if key is empty
+func pickKey(data map[string]interface{}, key string) (string, error) {
+	if key == "" {
+		if len(data) != 1 {
+			return "", errors.New("the secret has " + fmt.Sprint(len(data)) + " keys, the reference needs #key")
+		}
+		for _, value := range data {
+			return fmt.Sprint(value), nil
+		}
+	}
+	value, found := data[key]
+	if !found {
+		return "", errors.New("the secret has no key " + key)
+	}
+	return fmt.Sprint(value), nil
+}
+
+// signAWS adds the Authorization header of AWS Signature Version 4 of Secrets Manager to the request, every
+// header of the request is signed with the host
+func signAWS(req *http.Request, payload []byte, region, accessKey, secretKey string, now time.Time) {
+	amzDate, date := now.Format("20060102T150405Z"), now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	names := []string{"host"}
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		names = append(names, name)
+		headers[name] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	payloadHash := sha256.Sum256(payload)
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{req.Method, path, req.URL.RawQuery, canonicalHeaders.String(),
+		signedHeaders, hex.EncodeToString(payloadHash[:])}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + region + "/secretsmanager/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	for _, part := range []string{region, "secretsmanager", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+hex.EncodeToString(hmacSHA256(key, stringToSign)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,124 @@
+package recording
+
+import (
+	"bytes"
+	"fmt"
+	"icapeg/icap"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the ICAP headers which aren't recorded, the Encapsulated header is computed again and the recorded
+// transactions are replayed without preview
+var skippedICAPHeaders = map[string]bool{"Encapsulated": true, "Preview": true}
+
+// DumpRequest returns the ICAP request in its wire representation, body is the body of the HTTP response in
+// RESPMOD or of the HTTP request in REQMOD, it's sent in one chunk
+func DumpRequest(method, rawURL string, icapHeader textproto.MIMEHeader, httpReq *http.Request, httpResp *http.Response,
+	body []byte) []byte {
+	return dumpMessage(fmt.Sprintf("%s %s ICAP/1.0", method, rawURL), icapHeader, httpReq, httpResp, body)
+}
+
+// DumpResponse returns the ICAP response in its wire representation, httpMsg is the *http.Request or
+// the *http.Response which the ICAP response has
+func DumpResponse(statusCode int, icapHeader http.Header, httpMsg interface{}, body []byte) []byte {
+	var httpReq *http.Request
+	var httpResp *http.Response
+	switch msg := httpMsg.(type) {
+	case *http.Request:
+		httpReq = msg
+	case *http.Response:
+		httpResp = msg
+	}
+	return dumpMessage(fmt.Sprintf("ICAP/1.0 %d %s", statusCode, icap.StatusText(statusCode)), icapHeader,
+		httpReq, httpResp, body)
+}
+
+func dumpMessage(startLine string, icapHeader map[string][]string, httpReq *http.Request, httpResp *http.Response,
+	body []byte) []byte {
+	var reqHdr, respHdr bytes.Buffer
+	if httpReq != nil && httpReq.Method != "" {
+		dumpRequestHeader(&reqHdr, httpReq)
+	}
+	if httpResp != nil {
+		dumpResponseHeader(&respHdr, httpResp)
+	}
+
+	var encapsulated []string
+	if reqHdr.Len() > 0 {
+		encapsulated = append(encapsulated, "req-hdr=0")
+	}
+	if respHdr.Len() > 0 {
+		encapsulated = append(encapsulated, "res-hdr="+strconv.Itoa(reqHdr.Len()))
+	}
+	bodyKey := "null-body"
+	if len(body) > 0 {
+		bodyKey = "req-body"
+		if respHdr.Len() > 0 {
+			bodyKey = "res-body"
+		}
+	}
+	encapsulated = append(encapsulated, bodyKey+"="+strconv.Itoa(reqHdr.Len()+respHdr.Len()))
+
+	var wire bytes.Buffer
+	wire.WriteString(startLine + "\r\n")
+	for _, key := range sortedKeys(icapHeader) {
+		if skippedICAPHeaders[key] {
+			continue
+		}
+		for _, value := range icapHeader[key] {
+			fmt.Fprintf(&wire, "%s: %s\r\n", key, value)
+		}
+	}
+	fmt.Fprintf(&wire, "Encapsulated: %s\r\n\r\n", strings.Join(encapsulated, ", "))
+	wire.Write(reqHdr.Bytes())
+	wire.Write(respHdr.Bytes())
+	if len(body) > 0 {
+		fmt.Fprintf(&wire, "%x\r\n", len(body))
+		wire.Write(body)
+		wire.WriteString("\r\n0\r\n\r\n")
+	}
+	return wire.Bytes()
+}
+
+func dumpRequestHeader(buf *bytes.Buffer, req *http.Request) {
+	target := req.RequestURI
+	if target == "" && req.URL != nil {
+		target = req.URL.String()
+	}
+	fmt.Fprintf(buf, "%s %s %s\r\n", req.Method, target, protoOf(req.Proto))
+	if req.Host != "" && req.Header.Get("Host") == "" {
+		fmt.Fprintf(buf, "Host: %s\r\n", req.Host)
+	}
+	req.Header.Write(buf)
+	buf.WriteString("\r\n")
+}
+
+func dumpResponseHeader(buf *bytes.Buffer, resp *http.Response) {
+	status := resp.Status
+	if status == "" {
+		status = strconv.Itoa(resp.StatusCode) + " " + http.StatusText(resp.StatusCode)
+	}
+	fmt.Fprintf(buf, "%s %s\r\n", protoOf(resp.Proto), status)
+	resp.Header.Write(buf)
+	buf.WriteString("\r\n")
+}
+
+func protoOf(proto string) string {
+	if proto == "" {
+		return "HTTP/1.1"
+	}
+	return proto
+}
+
+func sortedKeys(header map[string][]string) []string {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,79 @@
+package recording
+
+import (
+	"icapeg/logging"
+	"icapeg/readValues"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// the extensions of the files of a recorded transaction, the .icap file is replayable as it is
+const (
+	RequestExt  = ".icap"
+	ResponseExt = ".response"
+)
+
+// Config represents [app.recording] section configuration
+type Config struct {
+	Dir         string
+	MaxBodySize int
+}
+
+var cfg *Config
+
+// InitRecording reads the optional [app.recording] section, the transactions aren't recorded if it doesn't exist
+func InitRecording() {
+	if !readValues.IsSecExists("app.recording") || !readValues.ReadValuesBool("app.recording.enabled") {
+		return
+	}
+	c := &Config{
+		Dir:         readValues.ReadValuesString("app.recording.dir"),
+		MaxBodySize: readValues.ReadValuesInt("app.recording.max_body_size"),
+	}
+	if err := os.MkdirAll(c.Dir, os.ModePerm); err != nil {
+		logging.Logger.Error("couldn't create the recording directory, the transactions aren't recorded: " + err.Error())
+		return
+	}
+	logging.Logger.Warn("recording the ICAP transactions with their bodies in " + c.Dir + ", it's a debug mode")
+	cfg = c
+}
+
+// Enabled reports whether the ICAP transactions are recorded
+func Enabled() bool {
+	return cfg != nil
+}
+
+// MaxBodySize returns the number of body bytes which are recorded, zero means the whole body
+func MaxBodySize() int {
+	if cfg == nil {
+		return 0
+	}
+	return cfg.MaxBodySize
+}
+
+// CapBody returns the part of the body which is recorded, the body is cut at max_body_size if it's positive
+func CapBody(body []byte) []byte {
+	if cfg != nil && cfg.MaxBodySize > 0 && len(body) > cfg.MaxBodySize {
+		return body[:cfg.MaxBodySize]
+	}
+	return body
+}
+
+// Save writes the wire representations of the ICAP request and the ICAP response of a transaction,
+// the files are named after the time and the X-ICAP-Metadata of the transaction
+func Save(xICAPMetadata string, request, response []byte) {
+	if cfg == nil || request == nil {
+		return
+	}
+	name := filepath.Join(cfg.Dir, time.Now().UTC().Format("20060102T150405.000000000")+"-"+xICAPMetadata)
+	if err := os.WriteFile(name+RequestExt, request, 0600); err != nil {
+		logging.Logger.Error("couldn't record the ICAP request: " + err.Error())
+		return
+	}
+	if response != nil {
+		if err := os.WriteFile(name+ResponseExt, response, 0600); err != nil {
+			logging.Logger.Error("couldn't record the ICAP response: " + err.Error())
+		}
+	}
+}
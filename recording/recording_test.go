@@ -0,0 +1,51 @@
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"icapeg/icap"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"testing"
+)
+
+func TestDumpRequestIsReplayable(t *testing.T) {
+	httpReq := &http.Request{Method: http.MethodGet, URL: &url.URL{Scheme: "http", Host: "example.com", Path: "/file.pdf"},
+		RequestURI: "http://example.com/file.pdf", Proto: "HTTP/1.1", Host: "example.com", Header: http.Header{}}
+	httpResp := &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Proto: "HTTP/1.1",
+		Header: http.Header{"Content-Type": {"application/pdf"}}}
+	icapHeader := textproto.MIMEHeader{"Host": {"icapeg"}, "Preview": {"1024"}, "Allow": {"204"}}
+	wire := DumpRequest("RESPMOD", "icap://icapeg:1344/clamav", icapHeader, httpReq, httpResp, []byte("%PDF-1.4 body"))
+
+	rw := bufio.NewReadWriter(bufio.NewReader(bytes.NewReader(wire)), bufio.NewWriter(io.Discard))
+	req, err := icap.ReadRequest(rw)
+	if err != nil {
+		t.Fatalf("the recorded request should be parsed by the ICAP server: %v", err)
+	}
+	if req.Header.Get("Preview") != "" {
+		t.Fatalf("the recorded request shouldn't have a preview")
+	}
+	if req.Request.URL.String() != "http://example.com/file.pdf" || req.Response.Header.Get("Content-Type") != "application/pdf" {
+		t.Fatalf("unexpected HTTP messages %v %v", req.Request.URL, req.Response.Header)
+	}
+	if body, _ := io.ReadAll(req.Response.Body); string(body) != "%PDF-1.4 body" {
+		t.Fatalf("unexpected body %q", body)
+	}
+}
+
+func TestReadDumpedResponse(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusForbidden, Proto: "HTTP/1.1", Header: http.Header{}}
+	wire := DumpResponse(200, http.Header{"Istag": {"\"epoch\""}}, resp, []byte("<html>blocked</html>"))
+	status, raw, err := readResponse(bufio.NewReader(bytes.NewReader(append(wire, "trailing"...))))
+	if err != nil || status != 200 {
+		t.Fatalf("expected 200, got %d %v", status, err)
+	}
+	if !bytes.HasPrefix(raw, wire) {
+		t.Fatalf("the raw response should be the dumped response")
+	}
+	if status, _, err = readResponse(bufio.NewReader(bytes.NewReader(DumpResponse(204, http.Header{}, nil, nil)))); err != nil || status != 204 {
+		t.Fatalf("expected 204, got %d %v", status, err)
+	}
+}
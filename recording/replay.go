@@ -0,0 +1,187 @@
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Replay is the "icapeg replay" subcommand, it sends the recorded ICAP requests (.icap files or the directories
+// which have them) to an ICAP server and prints the status codes of the replayed and the recorded responses
+func Replay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	addr := flags.String("addr", "localhost:1344", "the address of the ICAP server")
+	timeout := flags.Duration("timeout", 30*time.Second, "the timeout of a replayed transaction")
+	out := flags.String("out", "", "the directory where the replayed responses are written, empty = not written")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: icapeg replay [-addr host:port] [-timeout 30s] [-out dir] <file.icap|dir>...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	files, err := recordedRequests(flags.Args())
+	if err != nil || len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "no recorded transactions to replay", err)
+		return 2
+	}
+	failed := 0
+	for _, file := range files {
+		status, response, err := ReplayFile(*addr, file, *timeout)
+		if err != nil {
+			failed++
+			fmt.Printf("%s: %s\n", file, err)
+			continue
+		}
+		line := fmt.Sprintf("%s: replayed %d", file, status)
+		if recorded, err := RecordedStatus(strings.TrimSuffix(file, RequestExt) + ResponseExt); err == nil {
+			line += fmt.Sprintf(", recorded %d", recorded)
+			if recorded != status {
+				line += " (differs)"
+			}
+		}
+		fmt.Println(line)
+		if *out != "" {
+			name := filepath.Join(*out, strings.TrimSuffix(filepath.Base(file), RequestExt)+ResponseExt)
+			if err = os.WriteFile(name, response, 0600); err != nil {
+				fmt.Fprintln(os.Stderr, "couldn't write the replayed response:", err)
+			}
+		}
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// recordedRequests returns the .icap files of the paths, the files of a directory are ordered by their names
+// which start with the time they were recorded
+func recordedRequests(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*"+RequestExt))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// ReplayFile sends the recorded ICAP request to the ICAP server and returns the status code and the wire
+// representation of the ICAP response
+func ReplayFile(addr, file string, timeout time.Duration) (int, []byte, error) {
+	request, err := os.ReadFile(file)
+	if err != nil {
+		return 0, nil, err
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err = conn.Write(request); err != nil {
+		return 0, nil, err
+	}
+	return readResponse(bufio.NewReader(conn))
+}
+
+// RecordedStatus returns the status code of a recorded ICAP response
+func RecordedStatus(file string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && line == "" {
+		return 0, err
+	}
+	return parseStatusLine(line)
+}
+
+// readResponse reads an ICAP response up to the end of its encapsulated body
+func readResponse(r *bufio.Reader) (int, []byte, error) {
+	var raw bytes.Buffer
+	tp := textproto.NewReader(bufio.NewReader(io.TeeReader(r, &raw)))
+	line, err := tp.ReadLine()
+	if err != nil {
+		return 0, nil, err
+	}
+	status, err := parseStatusLine(line)
+	if err != nil {
+		return 0, nil, err
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return status, raw.Bytes(), err
+	}
+	headersLen, hasBody := encapsulatedBody(header.Get("Encapsulated"))
+	if headersLen > 0 {
+		if _, err = io.ReadFull(tp.R, make([]byte, headersLen)); err != nil {
+			return status, raw.Bytes(), err
+		}
+	}
+	for hasBody {
+		sizeLine, err := tp.ReadLine()
+		if err != nil {
+			return status, raw.Bytes(), err
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(strings.SplitN(sizeLine, ";", 2)[0]), 16, 64)
+		if err != nil {
+			return status, raw.Bytes(), errors.New("malformed chunk size " + sizeLine)
+		}
+		if _, err = io.ReadFull(tp.R, make([]byte, size+2)); err != nil {
+			return status, raw.Bytes(), err
+		}
+		hasBody = size > 0
+	}
+	return status, raw.Bytes(), nil
+}
+
+// encapsulatedBody returns the length of the encapsulated HTTP headers and whether the ICAP message has a body
+func encapsulatedBody(encapsulated string) (int, bool) {
+	for _, item := range strings.Split(encapsulated, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(item), "=")
+		if !found {
+			continue
+		}
+		offset, _ := strconv.Atoi(value)
+		switch key {
+		case "null-body":
+			return offset, false
+		case "req-body", "res-body", "opt-body":
+			return offset, true
+		}
+	}
+	return 0, false
+}
+
+func parseStatusLine(line string) (int, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "ICAP/") {
+		return 0, errors.New("malformed ICAP status line " + strings.TrimSpace(line))
+	}
+	return strconv.Atoi(fields[1])
+}
@@ -3,7 +3,10 @@
 echo "building icapeg ..."
 export GO111MODULE=on
 go mod vendor
-CGO_ENABLED=0 GOFLAGS=-mod=vendor go build
+VERSION=$(git describe --tags --always 2>/dev/null || echo dev)
+COMMIT=$(git rev-parse --short HEAD 2>/dev/null || echo unknown)
+BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+CGO_ENABLED=0 GOFLAGS=-mod=vendor go build -ldflags "-X icapeg/version.Version=$VERSION -X icapeg/version.Commit=$COMMIT -X icapeg/version.BuildDate=$BUILD_DATE"
 
 
 ./icapeg
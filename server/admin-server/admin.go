@@ -0,0 +1,157 @@
+package admin_server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"icapeg/server/certificates"
+	http_server "icapeg/server/http-server"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// AdminConfig represents [app.admin] section configuration
+type AdminConfig struct {
+	Enabled bool
+	Port    int
+	Token   string
+	TLSCert string // the admin API is served over HTTPS if the certificate and the key aren't empty
+	TLSKey  string
+}
+
+var adminCfg AdminConfig
+
+// InitAdminConfig reads [app.admin] section, the admin API stays disabled if the section doesn't exist
+func InitAdminConfig() *AdminConfig {
+	if readValues.IsSecExists("app.admin") {
+		adminCfg = AdminConfig{
+			Enabled: readValues.ReadValuesBool("app.admin.enabled"),
+			Port:    readValues.ReadValuesInt("app.admin.port"),
+			Token:   readValues.ReadValuesString("app.admin.token"),
+		}
+		if readValues.IsSecExists("app.admin.tls_cert") {
+			adminCfg.TLSCert = readValues.ReadValuesString("app.admin.tls_cert")
+			adminCfg.TLSKey = readValues.ReadValuesString("app.admin.tls_key")
+		}
+	}
+	return &adminCfg
+}
+
+// NewAdminServeMux returns the handler of the admin API, every endpoint requires the admin token but the health
+// endpoints which the load balancers probe
+func NewAdminServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", http_server.Healthz)
+	mux.HandleFunc("/readyz", http_server.Readyz)
+	mux.HandleFunc("/cache/stats", authenticated(CacheStats))
+	mux.HandleFunc("/cache/verdicts", authenticated(CacheVerdicts))
+	mux.HandleFunc("/cache/flush", authenticated(CacheFlush))
+	mux.HandleFunc("/retry/stats", authenticated(RetryStats))
+	mux.HandleFunc("/bulkhead/stats", authenticated(BulkheadStats))
+	mux.HandleFunc("/hashlists/", authenticated(HashLists))
+	mux.HandleFunc("/stats/top-talkers", authenticated(TopTalkers))
+	mux.HandleFunc("/stats/export", authenticated(StatisticsExport))
+	mux.HandleFunc("/version", authenticated(Version))
+	mux.HandleFunc("/services", authenticated(Services))
+	mux.HandleFunc("/services/toggles", authenticated(ServiceToggles))
+	mux.HandleFunc("/services/vendor", authenticated(ServiceVendors))
+	mux.HandleFunc("/feeds", authenticated(Feeds))
+	mux.HandleFunc("/feeds/update", authenticated(FeedUpdate))
+	mux.HandleFunc("/rules", authenticated(Rules))
+	mux.HandleFunc("/rules/reload", authenticated(RulesReload))
+	mux.HandleFunc("/credentials", authenticated(Credentials))
+	mux.HandleFunc("/credentials/reload", authenticated(CredentialsReload))
+	mux.HandleFunc("/istag", authenticated(ISTags))
+	mux.HandleFunc("/istag/poll", authenticated(ISTagsPoll))
+	mux.HandleFunc("/events/subscribers", authenticated(EventSubscribers))
+	mux.HandleFunc("/shadow/report", authenticated(ShadowReport))
+	mux.HandleFunc("/config/reload", authenticated(ConfigReload))
+	return mux
+}
+
+// StartAdminServer starts the admin API on its own port
+func StartAdminServer() {
+	if !adminCfg.Enabled {
+		return
+	}
+	if adminCfg.Token == "" {
+		logging.Logger.Warn("the admin API is enabled without a token, anyone who can reach its port can use it")
+	}
+	srv := &http.Server{Addr: ":" + strconv.Itoa(adminCfg.Port), Handler: NewAdminServeMux()}
+	if adminCfg.TLSCert != "" || adminCfg.TLSKey != "" {
+		reloader, err := certificates.NewReloader("the admin API", adminCfg.TLSCert, adminCfg.TLSKey)
+		if err != nil {
+			logging.Logger.Error("admin API isn't started, its TLS certificate is not valid: " + err.Error())
+			return
+		}
+		srv.TLSConfig = reloader.TLSConfig()
+	}
+	go func() {
+		logging.Logger.Info("admin API is running on port: " + strconv.Itoa(adminCfg.Port))
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil {
+			logging.Logger.Error("admin API stopped: " + err.Error())
+		}
+	}()
+}
+
+// authenticated checks the "Authorization: Bearer <token>" header before calling the handler,
+// the requests which change something are recorded in the audit log
+func authenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			audited := &auditResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			defer audit(audited, r)
+			w = audited
+		}
+		if adminCfg.Token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(adminCfg.Token)) != 1 {
+				writeError(w, http.StatusUnauthorized, "invalid admin token")
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// auditResponseWriter records the status code of the admin API response for the audit log
+type auditResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (w *auditResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// audit records the admin API request in the audit log
+func audit(w *auditResponseWriter, r *http.Request) {
+	logging.AuditLogger.Info("admin_api",
+		zap.String("remote_addr", r.RemoteAddr),
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+		zap.String("query", r.URL.RawQuery),
+		zap.Int("status", w.statusCode),
+	)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,70 @@
+package admin_server
+
+import (
+	"icapeg/cache"
+	"icapeg/logging"
+	"net/http"
+)
+
+// CacheStats returns the statistics of the verdict cache and the names of the registered caches
+// GET /cache/stats
+func CacheStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	result := map[string]interface{}{"caches": cache.Names()}
+	if stats, enabled := cache.VerdictCacheStats(); enabled {
+		result[cache.VerdictCacheName] = stats
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// CacheVerdicts looks up or deletes the cached verdicts of a file hash
+// GET /cache/verdicts?hash=<sha256>
+// DELETE /cache/verdicts?hash=<sha256>[&service=<service name>]
+func CacheVerdicts(w http.ResponseWriter, r *http.Request) {
+	hash := r.URL.Query().Get("hash")
+	if hash == "" {
+		writeError(w, http.StatusBadRequest, "hash query parameter is required")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		entries := cache.LookupVerdicts(hash)
+		if len(entries) == 0 {
+			writeError(w, http.StatusNotFound, "no cached verdicts for this hash")
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+	case http.MethodDelete:
+		serviceName := r.URL.Query().Get("service")
+		deleted := cache.DeleteVerdicts(hash, serviceName)
+		logging.Logger.Info("admin API deleted cached verdicts of " + hash)
+		writeJSON(w, http.StatusOK, map[string]int{"deleted": deleted})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// CacheFlush removes all the entries of a cache or of all caches
+// POST /cache/flush[?cache=verdict|url|blocklist]
+func CacheFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name := r.URL.Query().Get("cache")
+	if name == "" || name == "all" {
+		logging.Logger.Info("admin API flushed all caches")
+		writeJSON(w, http.StatusOK, map[string]interface{}{"flushed": cache.FlushAll()})
+		return
+	}
+	flushed, err := cache.Flush(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	logging.Logger.Info("admin API flushed " + name + " cache")
+	writeJSON(w, http.StatusOK, map[string]interface{}{"flushed": map[string]int{name: flushed}})
+}
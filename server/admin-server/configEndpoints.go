@@ -0,0 +1,29 @@
+package admin_server
+
+import (
+	"icapeg/config"
+	"icapeg/events"
+	"icapeg/logging"
+	"icapeg/service"
+	"net/http"
+)
+
+// ConfigReload reads config.toml again and applies it without restarting ICAPeg, the running configuration is
+// kept if the file is invalid
+// POST /config/reload
+func ConfigReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	err := service.ReloadConfig()
+	events.ConfigReloaded("admin_api", []string{"config"}, err)
+	if err != nil {
+		logging.Logger.Error("admin API couldn't reload config.toml, the running configuration is kept: " + err.Error())
+		writeError(w, http.StatusUnprocessableEntity, "config.toml is invalid, the running configuration is kept: "+
+			err.Error())
+		return
+	}
+	logging.Logger.Info("admin API reloaded config.toml")
+	writeJSON(w, http.StatusOK, map[string]interface{}{"services": config.App().Services})
+}
@@ -0,0 +1,70 @@
+package admin_server
+
+import (
+	"bytes"
+	"icapeg/events"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/credentials"
+	"io"
+	"net/http"
+)
+
+// Credentials returns the state of the credential of every vendor of [app.vendor_credentials], without the
+// credentials, or replaces the credential of a vendor with the request body, so the body isn't in the audit log
+// GET /credentials
+// POST /credentials?vendor=<vendor>
+func Credentials(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, credentials.AllStatus())
+	case http.MethodPost:
+		vendor := r.URL.Query().Get("vendor")
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "couldn't read the credential: "+err.Error())
+			return
+		}
+		value := string(bytes.TrimSpace(body))
+		if value == "" {
+			writeError(w, http.StatusBadRequest, "the request body must be the credential")
+			return
+		}
+		if !credentials.Set(vendor, value) {
+			writeError(w, http.StatusNotFound, "vendor "+vendor+" has no credential in [app.vendor_credentials]")
+			return
+		}
+		writeJSON(w, http.StatusOK, credentials.AllStatus()[vendor])
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// CredentialsReload loads the credential of a vendor, or of all vendors, again from its source now
+// POST /credentials/reload?vendor=<vendor>
+func CredentialsReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	vendor := r.URL.Query().Get("vendor")
+	if vendor == "" {
+		credentials.ReloadAll()
+		logging.Logger.Info("admin API reloaded the vendor credentials")
+		events.ConfigReloaded("admin_api", []string{"vendor_credentials"}, nil)
+		writeJSON(w, http.StatusOK, credentials.AllStatus())
+		return
+	}
+	exists, err := credentials.Reload(vendor)
+	if !exists {
+		writeError(w, http.StatusNotFound, "vendor "+vendor+" has no credential in [app.vendor_credentials]")
+		return
+	}
+	logging.Logger.Info("admin API reloaded the credential of " + vendor + " vendor")
+	events.ConfigReloaded("admin_api", []string{"vendor_credentials." + vendor}, err)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"error": err.Error(),
+			"active": credentials.AllStatus()[vendor]})
+		return
+	}
+	writeJSON(w, http.StatusOK, credentials.AllStatus()[vendor])
+}
@@ -0,0 +1,20 @@
+package admin_server
+
+import (
+	"icapeg/events"
+	"net/http"
+)
+
+// EventSubscribers returns the types of events of every subscriber of the event bus, the events which it got
+// and the ones which were dropped because it couldn't keep up, and the services whose vendors are down
+// GET /events/subscribers
+func EventSubscribers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"subscribers":  events.Stats(),
+		"down_vendors": events.DownVendors(),
+	})
+}
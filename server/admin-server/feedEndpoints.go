@@ -0,0 +1,42 @@
+package admin_server
+
+import (
+	"icapeg/feeds"
+	"icapeg/logging"
+	"net/http"
+)
+
+// Feeds returns the state of every feed (the last check, the last update and the last error)
+// GET /feeds
+func Feeds(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !feeds.Enabled() {
+		writeError(w, http.StatusNotFound, "feeds aren't enabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, feeds.Statuses())
+}
+
+// FeedUpdate updates a feed now instead of waiting for its schedule
+// POST /feeds/update?feed=<feed name>
+func FeedUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name := r.URL.Query().Get("feed")
+	status, err := feeds.Update(name)
+	if status.Name == "" {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	logging.Logger.Info("admin API updated " + name + " feed")
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,71 @@
+package admin_server
+
+import (
+	"encoding/json"
+	"icapeg/cache"
+	"icapeg/logging"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// hashListRequest is the body of adding a file hash to a hash list, ttl is in seconds and zero means forever
+type hashListRequest struct {
+	Hash    string `json:"hash"`
+	Comment string `json:"comment"`
+	TTL     int    `json:"ttl"`
+}
+
+// HashLists lists, adds and removes the file hashes of the allowlist and the denylist
+// GET /hashlists/{allow|deny}
+// POST /hashlists/{allow|deny} {"hash": "<sha256>", "comment": "...", "ttl": <seconds>}
+// DELETE /hashlists/{allow|deny}?hash=<sha256>
+func HashLists(w http.ResponseWriter, r *http.Request) {
+	lists := cache.GetHashLists()
+	if lists == nil {
+		writeError(w, http.StatusNotFound, "the hash lists are disabled")
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/hashlists/")
+	if name != cache.AllowListName && name != cache.DenyListName {
+		writeError(w, http.StatusNotFound, "hash list "+name+" doesn't exist")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		entries, _ := lists.List(name)
+		writeJSON(w, http.StatusOK, entries)
+	case http.MethodPost:
+		var req hashListRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Hash == "" {
+			writeError(w, http.StatusBadRequest, "the body must be a JSON object with a hash")
+			return
+		}
+		entry, err := lists.Add(name, strings.ToLower(req.Hash), req.Comment, time.Duration(req.TTL)*time.Second)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		logging.Logger.Info("admin API added " + entry.Hash + " to the " + name + " list")
+		writeJSON(w, http.StatusCreated, entry)
+	case http.MethodDelete:
+		hash := strings.ToLower(r.URL.Query().Get("hash"))
+		if hash == "" {
+			writeError(w, http.StatusBadRequest, "hash query parameter is required")
+			return
+		}
+		removed, err := lists.Remove(name, hash)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !removed {
+			writeError(w, http.StatusNotFound, "the hash isn't in the "+name+" list")
+			return
+		}
+		logging.Logger.Info("admin API removed " + hash + " from the " + name + " list")
+		writeJSON(w, http.StatusOK, map[string]string{"removed": hash})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,92 @@
+package admin_server
+
+import (
+	"icapeg/config"
+	"icapeg/logging"
+	"icapeg/service"
+	"icapeg/service/services-utilities/hotswap"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// the time a swap waits for the in-flight scans of the old vendor by default
+const defaultDrainTimeout = 30 * time.Second
+
+// ServiceVendors lists the vendor which backs every service, or swaps the vendor of a service at runtime. The new
+// ICAP requests of the service are scanned by the new vendor at once, and the response waits until the scans of
+// the old vendor finished or drain_timeout seconds expired. The new vendor must be the vendor of a configured
+// service, an empty vendor restores the configured one. The swap is sent to the other instances in cluster mode
+// GET /services/vendor
+// POST /services/vendor?service=<service name>&vendor=<vendor>&drain_timeout=<seconds>
+func ServiceVendors(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		vendors := make(map[string]string)
+		for serviceName, serviceInstance := range config.App().ServicesInstances {
+			vendors[serviceName] = hotswap.Vendor(serviceName, serviceInstance.Vendor)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"vendors": vendors, "swapped": hotswap.Swapped()})
+	case http.MethodPost:
+		serviceName, vendor := r.URL.Query().Get("service"), r.URL.Query().Get("vendor")
+		serviceInstance, exists := config.App().ServicesInstances[serviceName]
+		if !exists {
+			writeError(w, http.StatusNotFound, "service "+serviceName+" doesn't exist")
+			return
+		}
+		drainTimeout := defaultDrainTimeout
+		if raw := r.URL.Query().Get("drain_timeout"); raw != "" {
+			seconds, err := strconv.Atoi(raw)
+			if err != nil || seconds < 0 {
+				writeError(w, http.StatusBadRequest, "drain_timeout must be a number of seconds")
+				return
+			}
+			drainTimeout = time.Duration(seconds) * time.Second
+		}
+		if vendor != "" && vendor != serviceInstance.Vendor {
+			standby := configuredBy(vendor)
+			if standby == "" {
+				writeError(w, http.StatusBadRequest, "vendor "+vendor+" isn't the vendor of a configured service")
+				return
+			}
+			// the vendor settings are read from the service which is configured with the vendor
+			service.InitServiceConfig(vendor, standby)
+		}
+		if vendor == serviceInstance.Vendor {
+			vendor = ""
+		}
+		previous := hotswap.Vendor(serviceName, serviceInstance.Vendor)
+		hotswap.Swap(serviceName, vendor)
+		current := hotswap.Vendor(serviceName, serviceInstance.Vendor)
+		logging.Logger.Info("admin API swapped the vendor of " + serviceName + " service from " + previous +
+			" to " + current)
+		inFlight := 0
+		if previous != current {
+			inFlight = hotswap.Drain(serviceName, previous, drainTimeout)
+		}
+		if inFlight != 0 {
+			logging.Logger.Warn(strconv.Itoa(inFlight) + " scans of " + serviceName + " service by " + previous +
+				" vendor were still in flight after the drain timeout")
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"service": serviceName, "vendor": current,
+			"previous_vendor": previous, "drained": inFlight == 0, "in_flight": inFlight})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// configuredBy returns the first service, by name, which is configured with the vendor, "" if there's none
+func configuredBy(vendor string) string {
+	var services []string
+	for serviceName, serviceInstance := range config.App().ServicesInstances {
+		if serviceInstance.Vendor == vendor {
+			services = append(services, serviceName)
+		}
+	}
+	if len(services) == 0 {
+		return ""
+	}
+	sort.Strings(services)
+	return services[0]
+}
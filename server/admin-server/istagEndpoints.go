@@ -0,0 +1,29 @@
+package admin_server
+
+import (
+	"icapeg/logging"
+	"icapeg/service/services-utilities/istag"
+	"net/http"
+)
+
+// ISTags returns the definition version and the ISTag of every vendor which the ISTag rotation polls
+// GET /istag
+func ISTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, istag.AllStatus())
+}
+
+// ISTagsPoll polls the definition versions of the vendors now, ex: after a signature update was pushed
+// POST /istag/poll
+func ISTagsPoll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	istag.PollAll()
+	logging.Logger.Info("admin API polled the definition versions of the vendors")
+	writeJSON(w, http.StatusOK, istag.AllStatus())
+}
@@ -0,0 +1,44 @@
+package admin_server
+
+import (
+	"icapeg/events"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/rules"
+	"net/http"
+)
+
+// Rules returns the state of the active rule set (its files, the number of rules and the last rejected compile)
+// GET /rules
+func Rules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	status, enabled := rules.CurrentStatus()
+	if !enabled {
+		writeError(w, http.StatusNotFound, "rule sets aren't enabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// RulesReload compiles the rule files now, the active rule set stays if they don't compile
+// POST /rules/reload
+func RulesReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !rules.Enabled() {
+		writeError(w, http.StatusNotFound, "rule sets aren't enabled")
+		return
+	}
+	status, err := rules.Reload()
+	logging.Logger.Info("admin API reloaded the rule sets")
+	events.ConfigReloaded("admin_api", []string{"rules"}, err)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"error": err.Error(), "active": status})
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
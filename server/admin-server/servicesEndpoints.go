@@ -0,0 +1,62 @@
+package admin_server
+
+import (
+	"icapeg/api"
+	"icapeg/config"
+	utils "icapeg/consts"
+	"icapeg/service/services-utilities/bulkhead"
+	"icapeg/service/services-utilities/hotswap"
+	"icapeg/service/services-utilities/retry"
+	"icapeg/service/services-utilities/toggles"
+	"net/http"
+	"sort"
+)
+
+// serviceState is the runtime state of a configured service
+type serviceState struct {
+	Name     string          `json:"name"`
+	Caption  string          `json:"caption"`
+	Vendor   string          `json:"vendor"` // the vendor which backs the service now, it may be swapped
+	Enabled  bool            `json:"enabled"`
+	Methods  []string        `json:"methods"` // the enabled methods, without the ones disabled at runtime
+	InFlight int64           `json:"in_flight"`
+	Bulkhead *bulkhead.Stats `json:"bulkhead,omitempty"`
+	Retry    *retry.Stats    `json:"retry,omitempty"`
+}
+
+// Services lists the configured services with their runtime state: the vendor which backs them, whether they or
+// their methods were disabled at runtime, their in-flight scans and the stats of their bulkheads and retries
+// GET /services
+func Services(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	inFlight, bulkheads, retries := api.InFlightScans(), bulkhead.AllStats(), retry.AllStats()
+	services := make([]serviceState, 0, len(config.App().ServicesInstances))
+	for serviceName, serviceInstance := range config.App().ServicesInstances {
+		state := serviceState{
+			Name:     serviceName,
+			Caption:  serviceInstance.ServiceCaption,
+			Vendor:   hotswap.Vendor(serviceName, serviceInstance.Vendor),
+			Enabled:  !toggles.IsDisabled(serviceName),
+			Methods:  []string{},
+			InFlight: inFlight[serviceName],
+		}
+		if serviceInstance.ReqMode && !toggles.IsMethodDisabled(serviceName, utils.ICAPModeReq) {
+			state.Methods = append(state.Methods, utils.ICAPModeReq)
+		}
+		if serviceInstance.RespMode && !toggles.IsMethodDisabled(serviceName, utils.ICAPModeResp) {
+			state.Methods = append(state.Methods, utils.ICAPModeResp)
+		}
+		if stats, exists := bulkheads[serviceName]; exists {
+			state.Bulkhead = &stats
+		}
+		if stats, exists := retries[serviceName]; exists {
+			state.Retry = &stats
+		}
+		services = append(services, state)
+	}
+	sort.Slice(services, func(a, b int) bool { return services[a].Name < services[b].Name })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"services": services})
+}
@@ -0,0 +1,23 @@
+package admin_server
+
+import (
+	"icapeg/logging"
+	"icapeg/service/services-utilities/shadow"
+	"net/http"
+)
+
+// ShadowReport returns the agreement reports of the services and their shadow services, or drops them
+// GET /shadow/report
+// DELETE /shadow/report
+func ShadowReport(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"pairs": shadow.Report()})
+	case http.MethodDelete:
+		shadow.Reset()
+		logging.Logger.Info("admin API reset the shadow comparison reports")
+		writeJSON(w, http.StatusOK, map[string]bool{"reset": true})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,109 @@
+package admin_server
+
+import (
+	"encoding/csv"
+	"errors"
+	"icapeg/service/services-utilities/statistics"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatisticsExport exports the statistics of the transactions as JSON or CSV, from and to are RFC 3339 times
+// or unix seconds (default: the retention up to now), interval is in seconds (default: one row per group)
+// GET /stats/export[?format=json|csv][&from=...][&to=...][&interval=3600][&group_by=service,vendor,verdict]
+func StatisticsExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !statistics.Enabled() {
+		writeError(w, http.StatusNotFound, "statistics aren't enabled")
+		return
+	}
+	query := r.URL.Query()
+	now := time.Now()
+	from, err := parseTime(query.Get("from"), now.Add(-statistics.Retention()))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "from: "+err.Error())
+		return
+	}
+	to, err := parseTime(query.Get("to"), now.Add(time.Second))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "to: "+err.Error())
+		return
+	}
+	var interval time.Duration
+	if i := query.Get("interval"); i != "" {
+		seconds, err := strconv.Atoi(i)
+		if err != nil || seconds < 0 {
+			writeError(w, http.StatusBadRequest, "interval must be a non-negative number of seconds")
+			return
+		}
+		interval = time.Duration(seconds) * time.Second
+	}
+	var groupBy []string
+	if g := query.Get("group_by"); g != "" {
+		for _, field := range strings.Split(g, ",") {
+			switch field = strings.TrimSpace(field); field {
+			case statistics.GroupService, statistics.GroupVendor, statistics.GroupVerdict:
+				groupBy = append(groupBy, field)
+			default:
+				writeError(w, http.StatusBadRequest, "group_by fields must be service, vendor or verdict")
+				return
+			}
+		}
+	}
+
+	rows := statistics.Query(from, to, interval, groupBy)
+	switch query.Get("format") {
+	case "", "json":
+		writeJSON(w, http.StatusOK, rows)
+	case "csv":
+		writeStatisticsCSV(w, rows, groupBy)
+	default:
+		writeError(w, http.StatusBadRequest, "format must be json or csv")
+	}
+}
+
+// writeStatisticsCSV writes the rows with a column for every group_by field
+func writeStatisticsCSV(w http.ResponseWriter, rows []statistics.Row, groupBy []string) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"icapeg-statistics.csv\"")
+	w.WriteHeader(http.StatusOK)
+	out := csv.NewWriter(w)
+	out.Write(append(append([]string{"start", "end"}, groupBy...), "requests", "bytes", "avg_duration_ms"))
+	for _, row := range rows {
+		record := []string{row.Start.UTC().Format(time.RFC3339), row.End.UTC().Format(time.RFC3339)}
+		for _, field := range groupBy {
+			switch field {
+			case statistics.GroupService:
+				record = append(record, row.Service)
+			case statistics.GroupVendor:
+				record = append(record, row.Vendor)
+			case statistics.GroupVerdict:
+				record = append(record, row.Verdict)
+			}
+		}
+		record = append(record, strconv.FormatUint(row.Requests, 10), strconv.FormatUint(row.Bytes, 10),
+			strconv.FormatUint(row.AvgDurationMs, 10))
+		out.Write(record)
+	}
+	out.Flush()
+}
+
+// parseTime parses an RFC 3339 time or unix seconds, the default is returned for an empty value
+func parseTime(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New("must be an RFC 3339 time or unix seconds")
+	}
+	return t, nil
+}
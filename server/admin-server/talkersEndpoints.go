@@ -0,0 +1,43 @@
+package admin_server
+
+import (
+	"icapeg/service/services-utilities/toptalkers"
+	"net/http"
+	"strconv"
+)
+
+// TopTalkers returns the HTTP clients with most requests, scanned bytes or blocks in the rolling window
+// GET /stats/top-talkers[?by=requests|bytes|blocks][&limit=10]
+func TopTalkers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !toptalkers.Enabled() {
+		writeError(w, http.StatusNotFound, "top talkers aren't enabled")
+		return
+	}
+	by := r.URL.Query().Get("by")
+	switch by {
+	case "":
+		by = toptalkers.ByRequests
+	case toptalkers.ByRequests, toptalkers.ByBytes, toptalkers.ByBlocks:
+	default:
+		writeError(w, http.StatusBadRequest, "by must be requests, bytes or blocks")
+		return
+	}
+	limit := 10
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a non-negative number")
+			return
+		}
+	}
+	top, window := toptalkers.Top(limit, by)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"window_seconds": int(window.Seconds()),
+		"by":             by,
+		"clients":        top,
+	})
+}
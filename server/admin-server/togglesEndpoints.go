@@ -0,0 +1,66 @@
+package admin_server
+
+import (
+	"icapeg/config"
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/toggles"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ServiceToggles lists or changes the services and the methods which are disabled at runtime, the ICAP requests
+// of a disabled service are answered without scanning and a disabled method isn't allowed like a method which
+// is disabled in config.toml, the change is sent to the other instances in cluster mode
+// GET /services/toggles
+// POST /services/toggles?service=<service name>[&method=REQMOD|RESPMOD]&enabled=true|false
+func ServiceToggles(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"disabled": toggles.Disabled(),
+			"disabled_methods": toggles.DisabledMethods()})
+	case http.MethodPost:
+		serviceName := r.URL.Query().Get("service")
+		if _, exists := config.App().ServicesInstances[serviceName]; !exists {
+			writeError(w, http.StatusNotFound, "service "+serviceName+" doesn't exist")
+			return
+		}
+		enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "enabled must be true or false")
+			return
+		}
+		method := strings.ToUpper(r.URL.Query().Get("method"))
+		if method != "" && method != utils.ICAPModeReq && method != utils.ICAPModeResp {
+			writeError(w, http.StatusBadRequest, "method must be "+utils.ICAPModeReq+" or "+utils.ICAPModeResp)
+			return
+		}
+		if method != "" && !enabled && !otherMethodEnabled(serviceName, method) {
+			writeError(w, http.StatusConflict, method+" is the only enabled method of "+serviceName+
+				" service, disable the service instead")
+			return
+		}
+		toggles.SetEnabled(serviceName, method, enabled)
+		if method == "" {
+			logging.Logger.Info("admin API set enabled of " + serviceName + " service to " + strconv.FormatBool(enabled))
+			writeJSON(w, http.StatusOK, map[string]interface{}{"service": serviceName, "enabled": enabled})
+			return
+		}
+		logging.Logger.Info("admin API set enabled of " + method + " of " + serviceName + " service to " +
+			strconv.FormatBool(enabled))
+		writeJSON(w, http.StatusOK, map[string]interface{}{"service": serviceName, "method": method, "enabled": enabled})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// otherMethodEnabled reports whether the service has an enabled method other than method, a service keeps at
+// least one method like in config.toml
+func otherMethodEnabled(serviceName, method string) bool {
+	serviceInstance := config.App().ServicesInstances[serviceName]
+	if method == utils.ICAPModeReq {
+		return serviceInstance.RespMode && !toggles.IsMethodDisabled(serviceName, utils.ICAPModeResp)
+	}
+	return serviceInstance.ReqMode && !toggles.IsMethodDisabled(serviceName, utils.ICAPModeReq)
+}
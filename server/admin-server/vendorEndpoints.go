@@ -0,0 +1,27 @@
+package admin_server
+
+import (
+	"icapeg/service/services-utilities/bulkhead"
+	"icapeg/service/services-utilities/retry"
+	"net/http"
+)
+
+// RetryStats returns the retry metrics of every service
+// GET /retry/stats
+func RetryStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, retry.AllStats())
+}
+
+// BulkheadStats returns the in-flight, waiting and rejected requests of every service which has a bulkhead
+// GET /bulkhead/stats
+func BulkheadStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, bulkhead.AllStats())
+}
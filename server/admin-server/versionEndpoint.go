@@ -0,0 +1,16 @@
+package admin_server
+
+import (
+	"icapeg/version"
+	"net/http"
+)
+
+// Version returns the version, the commit and the build date of the running ICAPeg
+// GET /version
+func Version(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, version.Info())
+}
@@ -0,0 +1,182 @@
+package certificates
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"icapeg/logging"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves a certificate and its key from disk to the TLS listeners, they're loaded again when their
+// files change or on SIGHUP, so short-lived certificates rotate without restarting ICAPeg
+type Reloader struct {
+	name     string // the listener of the certificate in the logs
+	certFile string
+	keyFile  string
+
+	// the CA bundle of the client certificates (mTLS), empty if the clients aren't verified
+	clientCAFile string
+	clientAuth   tls.ClientAuthType
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	clientCAs *x509.CertPool
+	caMod     time.Time
+}
+
+var (
+	reloadersMu sync.Mutex
+	reloaders   []*Reloader
+	watchOnce   sync.Once
+)
+
+// NewReloader loads the certificate and the key of the listener, it fails if they aren't a valid pair
+func NewReloader(name, certFile, keyFile string) (*Reloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("the certificate and the key of " + name + " are required")
+	}
+	r := &Reloader{name: name, certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	reloadersMu.Lock()
+	reloaders = append(reloaders, r)
+	reloadersMu.Unlock()
+	return r, nil
+}
+
+// VerifyClients makes the listener verify the certificates of the clients against the PEM CA bundle (mTLS),
+// the clients without a certificate are rejected if required is true. The bundle is reloaded with the certificate
+func (r *Reloader) VerifyClients(caFile string, required bool) error {
+	if caFile == "" {
+		return errors.New("the client CA bundle of " + r.name + " is required")
+	}
+	r.mu.Lock()
+	r.clientCAFile, r.clientAuth = caFile, tls.VerifyClientCertIfGiven
+	if required {
+		r.clientAuth = tls.RequireAndVerifyClientCert
+	}
+	r.mu.Unlock()
+	return r.Reload()
+}
+
+// TLSConfig returns a TLS configuration which always serves the last loaded certificate and verifies the
+// clients against the last loaded CA bundle
+func (r *Reloader) TLSConfig() *tls.Config {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: r.GetCertificate}
+	r.mu.RLock()
+	verifyClients := r.clientCAFile != ""
+	r.mu.RUnlock()
+	if verifyClients {
+		config.ClientAuth = r.clientAuth
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: r.GetCertificate,
+				ClientAuth: r.clientAuth, ClientCAs: r.clientCAs}, nil
+		}
+	}
+	return config
+}
+
+// GetCertificate returns the last loaded certificate, it's the GetCertificate of the TLS configuration
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Reload loads the certificate and the key again, the last loaded certificate is kept if they aren't a valid
+// pair (ex: the certificate was replaced before its key)
+func (r *Reloader) Reload() error {
+	certMod, keyMod := modTime(r.certFile), modTime(r.keyFile)
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	caFile := r.clientCAFile
+	r.mu.RUnlock()
+	var clientCAs *x509.CertPool
+	caMod := modTime(caFile)
+	if caFile != "" {
+		if clientCAs, err = loadCertPool(caFile); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	r.cert, r.certMod, r.keyMod = &cert, certMod, keyMod
+	r.clientCAs, r.caMod = clientCAs, caMod
+	r.mu.Unlock()
+	return nil
+}
+
+// loadCertPool loads the certificates of a PEM bundle
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New(file + " has no PEM certificate")
+	}
+	return pool, nil
+}
+
+// changed reports whether the certificate or the key file was modified since they were loaded
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return !modTime(r.certFile).Equal(r.certMod) || !modTime(r.keyFile).Equal(r.keyMod) ||
+		!modTime(r.clientCAFile).Equal(r.caMod)
+}
+
+// ReloadAll loads the certificates of all listeners again, the changed ones only if onlyChanged is true
+func ReloadAll(onlyChanged bool) {
+	reloadersMu.Lock()
+	current := append([]*Reloader(nil), reloaders...)
+	reloadersMu.Unlock()
+	for _, r := range current {
+		if onlyChanged && !r.changed() {
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			logging.Logger.Error("couldn't reload the TLS certificate of " + r.name +
+				", the last loaded one is still served: " + err.Error())
+			continue
+		}
+		logging.Logger.Info("the TLS certificate of " + r.name + " was reloaded from " + r.certFile)
+	}
+}
+
+// Watch checks the files of the certificates every interval and reloads the changed ones, an interval of
+// zero doesn't check them, so they're reloaded on SIGHUP only
+func Watch(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	watchOnce.Do(func() {
+		go func() {
+			for range time.Tick(interval) {
+				ReloadAll(true)
+			}
+		}()
+	})
+}
+
+func modTime(file string) time.Time {
+	if file == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
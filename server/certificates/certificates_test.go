@@ -0,0 +1,174 @@
+package certificates
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"icapeg/logging"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// writeCertificate writes a self-signed certificate of the serial and its key to the files
+func writeCertificate(t *testing.T, certFile, keyFile string, serial int64, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(serial), Subject: pkix.Name{CommonName: "icapeg"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	os.Chtimes(certFile, modTime, modTime)
+	os.Chtimes(keyFile, modTime, modTime)
+}
+
+func serialOf(t *testing.T, r *Reloader) int64 {
+	cert, _ := r.GetCertificate(nil)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+func TestReloadChangedCertificates(t *testing.T) {
+	logging.Logger = zap.NewNop()
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	now := time.Now()
+	writeCertificate(t, certFile, keyFile, 1, now.Add(-time.Minute))
+	r, err := NewReloader("test", certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeCertificate(t, certFile, keyFile, 2, now)
+	ReloadAll(true)
+	if serial := serialOf(t, r); serial != 2 {
+		t.Fatalf("the rotated certificate should be served, got serial %d", serial)
+	}
+
+	// a certificate without its key keeps the last loaded one
+	os.WriteFile(keyFile, []byte("not a key"), 0600)
+	ReloadAll(false)
+	if serial := serialOf(t, r); serial != 2 {
+		t.Fatalf("the last loaded certificate should be kept, got serial %d", serial)
+	}
+}
+
+// clientCertificate returns a client certificate signed by a new CA, and the PEM of the CA
+func clientCertificate(t *testing.T) (tls.Certificate, []byte) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{SerialNumber: big.NewInt(10), Subject: pkix.Name{CommonName: "proxies CA"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour), IsCA: true,
+		BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	caDer, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := &x509.Certificate{SerialNumber: big.NewInt(11), Subject: pkix.Name{CommonName: "squid"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	der, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer})
+}
+
+// handshake connects to the listener with the client certificates and returns the error of the handshake
+func handshake(l net.Listener, certificates []tls.Certificate) error {
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{InsecureSkipVerify: true, Certificates: certificates})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// the server verifies the client certificate after the client finished its side of the handshake, so
+	// its alert is read by the first read
+	conn.SetDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	return err
+}
+
+func TestVerifyClients(t *testing.T) {
+	logging.Logger = zap.NewNop()
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	writeCertificate(t, certFile, keyFile, 1, time.Now())
+	clientCert, caPEM := clientCertificate(t)
+	os.WriteFile(caFile, caPEM, 0600)
+	_, otherCAPEM := clientCertificate(t)
+
+	for _, required := range []bool{true, false} {
+		r, err := NewReloader("test", certFile, keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = r.VerifyClients(caFile, required); err != nil {
+			t.Fatal(err)
+		}
+		l, err := tls.Listen("tcp", "127.0.0.1:0", r.TLSConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					if conn.(*tls.Conn).Handshake() == nil {
+						conn.Write([]byte("k"))
+					}
+				}()
+			}
+		}()
+
+		if err = handshake(l, []tls.Certificate{clientCert}); err != nil {
+			t.Errorf("required=%v: a client with a certificate of the CA should be accepted: %v", required, err)
+		}
+		if err = handshake(l, nil); (err == nil) == required {
+			t.Errorf("required=%v: the handshake of a client without a certificate returned %v", required, err)
+		}
+
+		// a client certificate of a CA which isn't in the rotated bundle is rejected
+		os.WriteFile(caFile, otherCAPEM, 0600)
+		if err = r.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		if err = handshake(l, []tls.Certificate{clientCert}); err == nil {
+			t.Errorf("required=%v: a client certificate of another CA should be rejected", required)
+		}
+		os.WriteFile(caFile, caPEM, 0600)
+		l.Close()
+	}
+}
@@ -0,0 +1,46 @@
+package http_server
+
+import (
+	"encoding/json"
+	"icapeg/service/services-utilities/health"
+	"icapeg/version"
+	"net/http"
+)
+
+// healthResponse is the body of /healthz and /readyz
+type healthResponse struct {
+	Status   string           `json:"status"`
+	Version  string           `json:"version"`
+	Draining bool             `json:"draining"`
+	Backends []health.Backend `json:"backends"`
+}
+
+// Healthz tells that the process is up, it always answers 200 and reports the backends of the services as they
+// were probed last, so a liveness probe doesn't restart ICAPeg when a vendor is down
+// GET /healthz
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, "ok")
+}
+
+// Readyz tells whether ICAPeg takes new ICAP transactions, it answers 503 while the server is draining or once
+// the backend of an enabled service is down
+// GET /readyz
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	if !health.Ready() {
+		writeHealth(w, http.StatusServiceUnavailable, "not_ready")
+		return
+	}
+	writeHealth(w, http.StatusOK, "ready")
+}
+
+func writeHealth(w http.ResponseWriter, status int, state string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(healthResponse{
+		Status:   state,
+		Version:  version.Version,
+		Draining: health.Draining(),
+		Backends: health.Backends(),
+	})
+}
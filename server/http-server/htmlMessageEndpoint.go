@@ -9,14 +9,28 @@ import (
 	"net/http"
 )
 
+// HtmlMessage renders the block page of a REQMOD request, the JSON one if the Accept header of the HTTP client
+// prefers JSON, otherwise the html_template of the service or block-page.html
 func HtmlMessage(w http.ResponseWriter, r *http.Request) {
 
 	htmlTmpl, _ := template.ParseFiles(utils.BlockPagePath)
 	htmlErrPage := &bytes.Buffer{}
 	var errPageStruct general_functions.ErrorPage
 	_ = json.NewDecoder(r.Body).Decode(&errPageStruct)
-	if errPageStruct.ExceptionPage != "" {
-		tmpl, err := template.ParseFiles(utils.BlockPagePath)
+	if general_functions.WantsJSON(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(general_functions.JSONBlockPage(&errPageStruct).Bytes())
+		return
+	}
+	path := general_functions.BlockPageTemplate(utils.BlockPagePath, errPageStruct.ServiceName)
+	if path != utils.BlockPagePath {
+		tmpl, err := template.ParseFiles(path)
+		if err == nil {
+			htmlTmpl = tmpl
+		}
+	}
+	if errPageStruct.Language != "" {
+		tmpl, err := template.ParseFiles(general_functions.LocalizedPagePath(path, errPageStruct.Language))
 		if err == nil {
 			htmlTmpl = tmpl
 		}
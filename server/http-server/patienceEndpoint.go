@@ -0,0 +1,50 @@
+package http_server
+
+import (
+	"icapeg/service/services-utilities/patience"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PatienceDownload serves a download which was scanned behind a patience page, the patience page
+// is served again while the scan is still running
+// GET /patience/<download id>
+func PatienceDownload(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/patience/")
+	download, exists := patience.Get(id)
+	if !exists {
+		http.Error(w, "the download doesn't exist or has expired", http.StatusNotFound)
+		return
+	}
+	if download.Body != nil {
+		defer download.Body.Close()
+	}
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	if !download.Done {
+		page, err := patience.RenderPage(download.PagePath, &patience.Page{
+			FileName:       download.FileName,
+			ServiceName:    download.ServiceName,
+			DownloadURL:    r.URL.Path,
+			RefreshSeconds: int(download.Refresh.Seconds()),
+		})
+		if err != nil {
+			http.Error(w, "the download is being scanned", http.StatusAccepted)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page)
+		return
+	}
+	for key, values := range download.Header {
+		w.Header()[key] = values
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(download.Body.Size(), 10))
+	w.WriteHeader(download.StatusCode)
+	// the download is streamed from its spool, the connection is aborted if it can't be read so the browser
+	// doesn't keep a truncated download
+	if _, err := io.Copy(w, download.Body.Open()); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
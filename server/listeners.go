@@ -0,0 +1,58 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"icapeg/config"
+	"icapeg/server/certificates"
+	"net"
+	"os"
+)
+
+// listen opens the ICAP listener of the configuration, a stale unix socket of a previous run is removed first.
+// The connections of a TLS listener are served with the certificate of its reloader
+func listen(cfg *config.ListenerConfig) (net.Listener, error) {
+	if cfg.Network == "unix" {
+		if err := removeStaleSocket(cfg.Address); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen(cfg.Network, cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Network == "unix" && cfg.SocketMode != 0 {
+		if err = os.Chmod(cfg.Address, cfg.SocketMode); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if cfg.TLS == nil {
+		return l, nil
+	}
+	reloader, err := certificates.NewReloader("the "+cfg.Name+" ICAP listener", cfg.TLS.Cert, cfg.TLS.Key)
+	if err == nil && cfg.TLS.ClientCA != "" {
+		err = reloader.VerifyClients(cfg.TLS.ClientCA, cfg.TLS.ClientCertRequired)
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return tls.NewListener(l, reloader.TLSConfig()), nil
+}
+
+// removeStaleSocket removes the unix socket which a previous run left behind, the path is left as it is if it
+// isn't a socket
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return errors.New(path + " exists and isn't a unix socket")
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"icapeg/config"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "icapeg.sock")
+	// the socket of a previous run which wasn't removed
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := listen(&config.ListenerConfig{Name: "squid", Network: "unix", Address: path, SocketMode: 0660})
+	if err != nil {
+		t.Fatalf("the stale socket wasn't replaced: %v", err)
+	}
+	defer l.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0660 {
+		t.Errorf("the socket mode is %v, want 0660", info.Mode().Perm())
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("couldn't connect to the socket: %v", err)
+	}
+	conn.Close()
+}
+
+func TestListenDoesNotRemoveFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "icapeg.sock")
+	if err := os.WriteFile(path, []byte("not a socket"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen(&config.ListenerConfig{Name: "squid", Network: "unix", Address: path}); err == nil {
+		t.Fatal("a regular file was replaced by the socket")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("the regular file was removed: %v", err)
+	}
+}
@@ -2,18 +2,49 @@ package server
 
 import (
 	"fmt"
+	"icapeg/alerting"
+	"icapeg/cache"
 	"icapeg/logging"
+	"icapeg/recording"
+	admin_server "icapeg/server/admin-server"
+	"icapeg/server/certificates"
 	http_server "icapeg/server/http-server"
+	"icapeg/service/registry"
+	"icapeg/service/services-utilities/bulkhead"
+	"icapeg/service/services-utilities/capture"
+	"icapeg/service/services-utilities/credentials"
+	"icapeg/service/services-utilities/digests"
+	"icapeg/service/services-utilities/geoip"
+	"icapeg/service/services-utilities/health"
+	"icapeg/service/services-utilities/istag"
+	"icapeg/service/services-utilities/metrics"
+	"icapeg/service/services-utilities/proxy"
+	"icapeg/service/services-utilities/quarantine"
+	"icapeg/service/services-utilities/quotas"
+	"icapeg/service/services-utilities/ratelimit"
+	"icapeg/service/services-utilities/rpz"
+	"icapeg/service/services-utilities/rules"
+	"icapeg/service/services-utilities/spool"
+	"icapeg/service/services-utilities/statistics"
+	"icapeg/service/services-utilities/streaming"
+	"icapeg/service/services-utilities/throttle"
+	"icapeg/service/services-utilities/toptalkers"
+	"icapeg/service/services-utilities/tracing"
+	"icapeg/version"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
 	"syscall"
 	"time"
 
 	"icapeg/api"
+	"icapeg/cluster"
 	"icapeg/config"
+	"icapeg/events"
+	"icapeg/feeds"
 	"icapeg/icap"
+	"icapeg/jobs"
 )
 
 // https://github.com/k8-proxy/k8-rebuild-rest-api
@@ -24,27 +55,57 @@ func StartServer() error {
 	// and there, the request will be filtered to check if the service exists or not
 
 	config.Init()
+	InitComponents()
+
+	//admin API
+	admin_server.InitAdminConfig()
+	admin_server.StartAdminServer()
 
 	//HTTP server
 	htmlWebServer := http.NewServeMux()
 	htmlWebServer.HandleFunc("/service/message", http_server.HtmlMessage)
+	htmlWebServer.HandleFunc("/patience/", http_server.PatienceDownload)
+	htmlWebServer.HandleFunc("/healthz", http_server.Healthz)
+	htmlWebServer.HandleFunc("/readyz", http_server.Readyz)
 	go func() {
 		http.ListenAndServe(":8081", htmlWebServer)
 	}()
 
 	icap.HandleFunc("/", api.ToICAPEGServe)
+	dumpStatusOnSignal()
+	reloadOnSignal()
 
-	logging.Logger.Info("starting the ICAP server")
+	logging.Logger.Info("starting the ICAP server, " + version.String())
 
 	stop := make(chan os.Signal, 1)
 
-	signal.Notify(stop, syscall.SIGKILL, syscall.SIGINT, syscall.SIGQUIT)
-
-	go func() {
-		if err := icap.ListenAndServe(fmt.Sprintf(":%d", config.App().Port), nil); err != nil {
-			logging.Logger.Fatal(err.Error())
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+
+	timeouts := config.App().ConnectionTimeouts
+	srv := &icap.Server{
+		ReadTimeout:       timeouts.Read,
+		WriteTimeout:      timeouts.Write,
+		IdleTimeout:       timeouts.Idle,
+		ReadHeaderTimeout: timeouts.ReadHeader,
+		MaxConcurrency:    config.App().MaxConcurrency,
+		WorkerTimeout:     config.App().WorkerTimeout,
+	}
+	//every listener is served by the same server, so the shutdown drains the connections of all of them
+	for _, listenerCfg := range config.App().Listeners {
+		l, err := listen(listenerCfg)
+		if err != nil {
+			logging.Logger.Fatal("couldn't open the " + listenerCfg.Name + " ICAP listener on " +
+				listenerCfg.Address + ": " + err.Error())
 		}
-	}()
+		logging.Logger.Info(fmt.Sprintf("ICAP server is listening on %s %s (%s listener, TLS: %t)",
+			listenerCfg.Network, listenerCfg.Address, listenerCfg.Name, listenerCfg.TLS != nil))
+		go func(l net.Listener) {
+			if err := srv.Serve(l); err != nil && err != icap.ErrServerClosed {
+				logging.Logger.Fatal(err.Error())
+			}
+		}(l)
+	}
+	certificates.Watch(config.App().TLSReloadInterval)
 
 	ticker := time.NewTicker(10 * time.Second)
 	go func() {
@@ -55,14 +116,51 @@ func StartServer() error {
 		}
 	}()
 
-	time.Sleep(5 * time.Millisecond)
-	port := strconv.Itoa(config.App().Port)
-	logging.Logger.Info("ICAP server is running on localhost: " + port)
-
-	<-stop
+	sig := <-stop
 	ticker.Stop()
 
+	drain(srv, sig)
 	logging.Logger.Info("ICAP server gracefully shut down")
 
 	return nil
 }
+
+// InitComponents initializes the plugins and the components which the ICAP requests are processed with upon the
+// configuration which was loaded, the integration tests serve the requests with them without the listeners of
+// StartServer
+func InitComponents() {
+	//the vendors of the plugins are registered before a service uses them
+	registry.InitPlugins()
+
+	//the webhook of the event sinks posts through the outbound proxy
+	proxy.InitOutboundProxy()
+	alerting.InitAlerting()
+	events.InitEvents()
+	cache.InitVerdictCache(config.App().Services)
+	cache.InitHashLists()
+	digests.InitDigests()
+	geoip.InitGeoIP()
+	recording.InitRecording()
+	credentials.InitCredentials()
+	istag.InitISTag()
+	capture.InitCapture()
+	toptalkers.InitTopTalkers()
+	quotas.InitQuotas()
+	throttle.InitThrottling()
+	spool.InitBodySpooling()
+	streaming.InitStreamingMedia()
+	statistics.InitStatistics()
+	metrics.InitMetrics()
+	tracing.InitTracing()
+	cluster.InitCluster()
+	feeds.InitFeeds()
+	rules.InitRules()
+	jobs.InitJobs()
+	jobs.InitDeferredQueues()
+	bulkhead.InitBulkheads(config.App().Services)
+	ratelimit.InitRateLimits(config.App().Services)
+	rpz.InitDNSPolicies(config.App().Services)
+	quarantine.InitQuarantine(config.App().Services)
+	bulkhead.InitTenantBulkheads(config.App().Tenants)
+	health.InitHealth()
+}
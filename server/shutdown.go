@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"icapeg/api"
+	"icapeg/config"
+	"icapeg/icap"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/health"
+	"icapeg/service/services-utilities/tracing"
+	"os"
+	"time"
+)
+
+// drain stops accepting ICAP connections and waits up to the drain timeout for the requests which are being
+// processed, the ones of the shadow services and of the deferred scans included, so a rollout doesn't truncate
+// the responses of the scans which are in flight. /readyz answers 503 from then on
+func drain(srv *icap.Server, sig os.Signal) {
+	timeout := config.App().DrainTimeout
+	logging.Logger.Info(fmt.Sprintf("%v received, stopping the ICAP listener and draining the connections for up to %v",
+		sig, timeout))
+	start := time.Now()
+	health.SetDraining()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	if err == nil {
+		err = api.WaitBackground(ctx)
+	}
+	// the spans of the drained transactions are exported before exiting
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	tracing.Shutdown(flushCtx)
+	if err != nil {
+		logging.Logger.Warn(fmt.Sprintf("the drain timeout expired, exiting with %d open ICAP connections "+
+			"and the scans in flight %v", icap.ActiveConnections(), api.InFlightScans()))
+		return
+	}
+	logging.Logger.Info("every ICAP request was processed in " + time.Since(start).Round(time.Millisecond).String())
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"icapeg/events"
+	"icapeg/logging"
+	"icapeg/server/certificates"
+	"icapeg/service"
+	"icapeg/service/services-utilities/credentials"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reloadOnSignal reloads config.toml, the TLS certificates of the ICAP listener and the admin API, and the vendor
+// credentials, on every SIGHUP, so a rotated certificate or key is used without waiting for the next check
+func reloadOnSignal() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			logging.Logger.Info("SIGHUP received, reloading config.toml, the TLS certificates and the vendor credentials")
+			err := service.ReloadConfig()
+			if err != nil {
+				logging.Logger.Error("couldn't reload config.toml, the running configuration is kept: " + err.Error())
+			}
+			certificates.ReloadAll(false)
+			credentials.ReloadAll()
+			events.ConfigReloaded("sighup", []string{"config", "certificates", "vendor_credentials"}, err)
+		}
+	}()
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"icapeg/api"
+	"icapeg/cache"
+	"icapeg/icap"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/bulkhead"
+	"icapeg/service/services-utilities/retry"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// dumpStatusOnSignal logs a status snapshot on every SIGUSR1, it's a quick diagnostic when
+// the admin API is disabled or its port is unreachable
+func dumpStatusOnSignal() {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for range usr1 {
+			logging.Logger.Info(statusSnapshot())
+		}
+	}()
+}
+
+// statusSnapshot returns the human-readable state of the ICAP server
+func statusSnapshot() string {
+	var b strings.Builder
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	fmt.Fprintf(&b, "status snapshot at %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&b, "  active ICAP connections: %d\n", icap.ActiveConnections())
+	fmt.Fprintf(&b, "  ICAP requests waiting for a worker: %d\n", icap.WaitingRequests())
+	fmt.Fprintf(&b, "  goroutines: %d, heap: %d bytes\n", runtime.NumGoroutine(), mem.HeapAlloc)
+
+	b.WriteString("  in-flight scans:\n")
+	inFlight := api.InFlightScans()
+	var names []string
+	for name := range inFlight {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "    %s: %d\n", name, inFlight[name])
+	}
+
+	b.WriteString("  bulkhead queues:\n")
+	bulkheads := bulkhead.AllStats()
+	names = names[:0]
+	for name := range bulkheads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := bulkheads[name]
+		fmt.Fprintf(&b, "    %s: in flight %d/%d, waiting %d/%d, rejected %d\n", name, s.InFlight, s.MaxConcurrent,
+			s.Waiting, s.MaxQueue, s.Rejected)
+	}
+
+	b.WriteString("  caches:\n")
+	sizes := cache.Sizes()
+	names = names[:0]
+	for name := range sizes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "    %s: %d entries\n", name, sizes[name])
+	}
+
+	// the vendors don't have circuit breakers, their state is told by the retry metrics
+	b.WriteString("  vendors:\n")
+	retries := retry.AllStats()
+	names = names[:0]
+	for name := range retries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := retries[name]
+		fmt.Fprintf(&b, "    %s: calls %d, retries %d, recovered %d, failed %d, retry budget exhausted %d\n", name,
+			s.Calls, s.Retries, s.Recovered, s.Failed, s.BudgetExhausted)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,41 @@
+package registry
+
+import (
+	"icapeg/logging"
+	"icapeg/readValues"
+	"os"
+	"path/filepath"
+	"plugin"
+	"sort"
+	"strings"
+)
+
+// InitPlugins reads the optional [app.plugins] section and loads the Go plugins (.so files) of its dir, every
+// plugin registers its vendors with Register in its init func. A plugin must be built with the same Go version
+// and the same versions of the icapeg packages as the server (go build -buildmode=plugin)
+func InitPlugins() {
+	if !readValues.IsSecExists("app.plugins") || !readValues.ReadValuesBool("app.plugins.enabled") {
+		return
+	}
+	dir := readValues.ReadValuesString("app.plugins.dir")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logging.Logger.Error("couldn't read the plugins directory " + dir + ": " + err.Error())
+		return
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".so") {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		if _, err = plugin.Open(file); err != nil {
+			logging.Logger.Error("couldn't load the plugin " + file + ": " + err.Error())
+			continue
+		}
+		logging.Logger.Info("loaded the plugin " + file)
+	}
+	logging.Logger.Info("the registered vendors are " + strings.Join(Names(), ", "))
+}
@@ -0,0 +1,75 @@
+package registry
+
+import (
+	http_message "icapeg/http-message"
+	"net/textproto"
+	"sort"
+	"sync"
+)
+
+type (
+	// Service is the contract of a vendor integration, an instance processes one HTTP message
+	Service interface {
+		Processing(bool, textproto.MIMEHeader) (int, interface{}, map[string]string,
+			map[string]interface{}, map[string]interface{}, map[string]interface{})
+		ISTagValue() string
+	}
+
+	// OptionsHeaders is implemented by the services which add their own headers to the OPTIONS responses,
+	// ex: the Transfer-Preview of the file types they need
+	OptionsHeaders interface {
+		OptionsHeaders() map[string]string
+	}
+
+	// SignatureVersion is implemented by the services whose verdicts depend on a definition database, the ISTag
+	// of the service changes with the version so the ICAP clients drop the responses which they cached. It's
+	// called for every ICAP response so the version should be cached, empty if it's unknown
+	SignatureVersion interface {
+		SignatureVersion() string
+	}
+)
+
+// Vendor creates the services of a vendor and loads its configuration from the section of a service
+type Vendor struct {
+	// New creates the service which processes an HTTP message
+	New func(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) Service
+	// Init loads the configuration of the vendor from the section of the service, it's called for every
+	// request so it should load it once
+	Init func(serviceName string)
+	// Reload reads the configuration again, it returns the func which makes it the configuration of the next
+	// requests, nil if it wasn't loaded. It's optional, the configuration of the vendor isn't reloaded without it
+	Reload func() func()
+}
+
+var (
+	mu      sync.RWMutex
+	vendors = make(map[string]Vendor)
+)
+
+// Register adds a vendor which the services use with `vendor = "<name>"`, the vendors register themselves in
+// their init funcs, a vendor which is registered again with the same name replaces the previous one
+func Register(name string, vendor Vendor) {
+	mu.Lock()
+	defer mu.Unlock()
+	vendors[name] = vendor
+}
+
+// Lookup returns the vendor which was registered with the name
+func Lookup(name string) (Vendor, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	vendor, exists := vendors[name]
+	return vendor, exists
+}
+
+// Names returns the sorted names of the registered vendors
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(vendors))
+	for name := range vendors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
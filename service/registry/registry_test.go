@@ -0,0 +1,24 @@
+package registry
+
+import (
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	Register("sample", Vendor{Init: func(string) {}})
+	vendor, exists := Lookup("sample")
+	if !exists || vendor.Init == nil {
+		t.Fatal("the registered vendor should be found by its name")
+	}
+	// a vendor which is registered again replaces the previous one, ex: a plugin of a built-in vendor
+	Register("sample", Vendor{})
+	if vendor, _ = Lookup("sample"); vendor.Init != nil {
+		t.Fatal("the vendor should be replaced")
+	}
+	if _, exists = Lookup("missing"); exists {
+		t.Fatal("a vendor which wasn't registered shouldn't be found")
+	}
+	if names := Names(); len(names) != 1 || names[0] != "sample" {
+		t.Fatalf("Names() = %v", names)
+	}
+}
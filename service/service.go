@@ -1,12 +1,20 @@
 package service
 
 import (
+	"icapeg/config"
+	utils "icapeg/consts"
 	http_message "icapeg/http-message"
 	"icapeg/logging"
+	"icapeg/service/registry"
 	"icapeg/service/services/clamav"
 	"icapeg/service/services/clhashlookup"
+	"icapeg/service/services/dlp"
 	"icapeg/service/services/echo"
-	"net/textproto"
+	"icapeg/service/services/hashreputation"
+	"icapeg/service/services/multivendor"
+	"icapeg/service/services/remoteicap"
+	"icapeg/service/services/transform"
+	"icapeg/service/services/urlfilter"
 )
 
 // Vendors names
@@ -14,29 +22,94 @@ const (
 	VendorEcho       = "echo"
 	VendorClamav     = "clamav"
 	VendorHashlookup = "clhashlookup"
+	VendorRemoteICAP = "remote_icap"
+	VendorReputation = "hash_reputation"
+	VendorDLP        = "dlp"
+	VendorTransform  = "transform"
+	VendorURLFilter  = "url_filter"
 )
 
 type (
-	// Service holds the info to distinguish a service
-	Service interface {
-		Processing(bool, textproto.MIMEHeader) (int, interface{}, map[string]string,
-			map[string]interface{}, map[string]interface{}, map[string]interface{})
-		ISTagValue() string
-	}
+	// Service holds the info to distinguish a service, see registry.Service
+	Service = registry.Service
+	// OptionsHeaders is implemented by the services which add headers to the OPTIONS responses
+	OptionsHeaders = registry.OptionsHeaders
+	// SignatureVersion is implemented by the services whose ISTag changes with their definition databases
+	SignatureVersion = registry.SignatureVersion
 )
 
-// GetService returns a service based on the service name
-// change name to vendor and add parameter service name
-func GetService(vendor, serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) Service {
-	logging.Logger.Info("getting instance from " + serviceName + " struct")
-	switch vendor {
-	case VendorEcho:
-		return echo.NewEchoService(serviceName, methodName, httpMsg, xICAPMetadata)
-	case VendorHashlookup:
-		return clhashlookup.NewHashlookupService(serviceName, methodName, httpMsg, xICAPMetadata)
-	case VendorClamav:
-		return clamav.NewClamavService(serviceName, methodName, httpMsg, xICAPMetadata)
+// the vendors of ICAPeg, the other vendors are registered by their packages or their plugins
+func init() {
+	registry.Register(VendorEcho, registry.Vendor{
+		New: func(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) Service {
+			return echo.NewEchoService(serviceName, methodName, httpMsg, xICAPMetadata)
+		},
+		Init:   echo.InitEchoConfig,
+		Reload: echo.ReloadEchoConfig,
+	})
+	registry.Register(VendorHashlookup, registry.Vendor{
+		New: func(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) Service {
+			return clhashlookup.NewHashlookupService(serviceName, methodName, httpMsg, xICAPMetadata)
+		},
+		Init:   clhashlookup.InitHashlookupConfig,
+		Reload: clhashlookup.ReloadHashlookupConfig,
+	})
+	registry.Register(VendorClamav, registry.Vendor{
+		New: func(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) Service {
+			return clamav.NewClamavService(serviceName, methodName, httpMsg, xICAPMetadata)
+		},
+		Init:   clamav.InitClamavConfig,
+		Reload: clamav.ReloadClamavConfig,
+	})
+	registry.Register(VendorRemoteICAP, registry.Vendor{
+		New: func(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) Service {
+			return remoteicap.NewRemoteICAPService(serviceName, methodName, httpMsg, xICAPMetadata)
+		},
+		Init:   remoteicap.InitRemoteICAPConfig,
+		Reload: remoteicap.ReloadRemoteICAPConfig,
+	})
+	registry.Register(VendorReputation, registry.Vendor{
+		New: func(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) Service {
+			return hashreputation.NewHashReputationService(serviceName, methodName, httpMsg, xICAPMetadata)
+		},
+		Init:   hashreputation.InitHashReputationConfig,
+		Reload: hashreputation.ReloadHashReputationConfig,
+	})
+	registry.Register(VendorDLP, registry.Vendor{
+		New: func(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) Service {
+			return dlp.NewDLPService(serviceName, methodName, httpMsg, xICAPMetadata)
+		},
+		Init:   dlp.InitDLPConfig,
+		Reload: dlp.ReloadDLPConfig,
+	})
+	registry.Register(VendorTransform, registry.Vendor{
+		New: func(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) Service {
+			return transform.NewTransformService(serviceName, methodName, httpMsg, xICAPMetadata)
+		},
+		Init:   transform.InitTransformConfig,
+		Reload: transform.ReloadTransformConfig,
+	})
+	registry.Register(VendorURLFilter, registry.Vendor{
+		New: func(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) Service {
+			return urlfilter.NewURLFilterService(serviceName, methodName, httpMsg, xICAPMetadata)
+		},
+		Init:   urlfilter.InitURLFilterConfig,
+		Reload: urlfilter.ReloadURLFilterConfig,
+	})
+	// the services which list several vendors, their vendors reload their own configurations
+	registry.Register(utils.MultiVendor, registry.Vendor{
+		New: func(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) Service {
+			return multivendor.NewMultiVendorService(serviceName, methodName, httpMsg, xICAPMetadata)
+		},
+		Init: multivendor.InitMultiVendorConfig,
+	})
+}
 
+// GetService returns a service of the vendor which was registered with the name, nil if there's none
+func GetService(vendor, serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) Service {
+	logging.Logger.Info(utils.PrepareLogMsg(xICAPMetadata, "getting instance from "+serviceName+" struct"))
+	if v, exists := registry.Lookup(vendor); exists {
+		return v.New(serviceName, methodName, httpMsg, xICAPMetadata)
 	}
 	return nil
 }
@@ -44,12 +117,45 @@ func GetService(vendor, serviceName, methodName string, httpMsg *http_message.Ht
 // InitServiceConfig is used to load the services configuration
 func InitServiceConfig(vendor, serviceName string) {
 	logging.Logger.Info("loading all the services configuration")
-	switch vendor {
-	case VendorEcho:
-		echo.InitEchoConfig(serviceName)
-	case VendorHashlookup:
-		clhashlookup.InitHashlookupConfig(serviceName)
-	case VendorClamav:
-		clamav.InitClamavConfig(serviceName)
+	if v, exists := registry.Lookup(vendor); exists && v.Init != nil {
+		v.Init(serviceName)
+	}
+}
+
+// reloadServiceConfig reads the configuration of the vendor again, see echo.ReloadEchoConfig
+func reloadServiceConfig(vendor string) func() {
+	if v, exists := registry.Lookup(vendor); exists && v.Reload != nil {
+		return v.Reload()
 	}
+	return nil
+}
+
+// ReloadConfig reads config.toml again and swaps the configuration of ICAPeg and of the vendors at once, the
+// requests which are being processed keep the configuration which they started with. Nothing is changed if the
+// new configuration is invalid
+func ReloadConfig() error {
+	return config.Reload(func(app *config.AppConfig) error {
+		var commits []func()
+		reloaded := make(map[string]bool)
+		for _, serviceInstance := range app.ServicesInstances {
+			vendors := []string{serviceInstance.Vendor}
+			if serviceInstance.MultiVendor != nil {
+				vendors = serviceInstance.MultiVendor.Vendors
+			}
+			for _, vendor := range vendors {
+				if reloaded[vendor] {
+					continue
+				}
+				reloaded[vendor] = true
+				if commit := reloadServiceConfig(vendor); commit != nil {
+					commits = append(commits, commit)
+				}
+			}
+		}
+		// every vendor read its configuration, so none of them can fail anymore
+		for _, commit := range commits {
+			commit()
+		}
+		return nil
+	})
 }
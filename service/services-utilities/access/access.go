@@ -0,0 +1,103 @@
+package access
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net"
+	"strings"
+)
+
+// Client is an ICAP client (ex: a proxy) which may use some of the services, it's identified by the address
+// which it connects from and/or by the shared secret which it sends in the secret header
+type Client struct {
+	Name     string
+	Networks []*net.IPNet // the addresses which the client connects from, any address if it's empty
+	Secret   string       // the shared secret of the client, no secret if it's empty
+	Services []string     // the services which the client may use, every service if it's empty
+}
+
+// Authorize returns the client which the request is from (its address and its secret) and whether it may use
+// the service. A request may be from several clients, ex: a proxy in an allowed network which sends the secret of
+// another client, it may use the services of all of them. The client is nil if the request isn't from any client
+func Authorize(clients []*Client, address, secret, service string) (*Client, bool) {
+	var identified *Client
+	for _, client := range clients {
+		if !client.Matches(address, secret) {
+			continue
+		}
+		if client.Allows(service) {
+			return client, true
+		}
+		if identified == nil {
+			identified = client
+		}
+	}
+	return identified, false
+}
+
+// Matches reports whether the request is from the client, its address (an IP, with or without a port) is in the
+// networks of the client and its secret is the secret of the client. The requests of the unix socket listeners
+// have no IP (their address is "@" or empty), so they're from the clients without networks only, which match
+// them on their secret
+func (c *Client) Matches(address, secret string) bool {
+	if len(c.Networks) != 0 && !inNetworks(c.Networks, address) {
+		return false
+	}
+	// the secrets are compared in constant time, so the time of the answer doesn't leak them
+	return c.Secret == "" || subtle.ConstantTimeCompare([]byte(c.Secret), []byte(secret)) == 1
+}
+
+// Allows reports whether the client may use the service
+func (c *Client) Allows(service string) bool {
+	if len(c.Services) == 0 {
+		return true
+	}
+	for _, allowed := range c.Services {
+		if allowed == service {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNetworks parses the IPs and the CIDRs, an IP is a network of its own
+func ParseNetworks(entries []string) ([]*net.IPNet, error) {
+	result := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.New(entry + " isn't an IP or a CIDR")
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.New(entry + " isn't an IP or a CIDR")
+		}
+		result = append(result, network)
+	}
+	return result, nil
+}
+
+// inNetworks reports whether the address (an IP, with or without a port) is in one of the networks
+func inNetworks(networks []*net.IPNet, address string) bool {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	ip := net.ParseIP(strings.Trim(address, "[]"))
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package access
+
+import "testing"
+
+func TestAuthorize(t *testing.T) {
+	office, err := ParseNetworks([]string{"10.1.0.0/16", "192.168.1.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := []*Client{
+		{Name: "office", Networks: office, Services: []string{"echo"}},
+		{Name: "partner", Secret: "s3cret", Services: []string{"clamav"}},
+		{Name: "proxy", Networks: office, Secret: "proxy-secret"},
+	}
+	tests := []struct {
+		address string
+		secret  string
+		service string
+		client  string
+		allowed bool
+	}{
+		{"10.1.2.3:53211", "", "echo", "office", true},
+		{"10.1.2.3:53211", "", "clamav", "office", false},
+		{"192.168.1.5:1000", "s3cret", "clamav", "partner", true},
+		{"10.1.2.3:53211", "proxy-secret", "virustotal", "proxy", true},
+		{"[2001:db8::1]:1000", "s3cret", "clamav", "partner", true},
+		{"[2001:db8::1]:1000", "proxy-secret", "echo", "", false},
+		{"172.16.0.1:1000", "wrong", "clamav", "", false},
+		{"172.16.0.1:1000", "", "echo", "", false},
+		// the connections of the unix socket listeners match the clients without networks only
+		{"@", "s3cret", "clamav", "partner", true},
+		{"", "s3cret", "clamav", "partner", true},
+		{"@", "", "echo", "", false},
+		{"@", "proxy-secret", "echo", "", false},
+	}
+	for _, test := range tests {
+		client, allowed := Authorize(clients, test.address, test.secret, test.service)
+		name := ""
+		if client != nil {
+			name = client.Name
+		}
+		if name != test.client || allowed != test.allowed {
+			t.Errorf("%s with %q to %s should be %q (allowed: %t), got %q (allowed: %t)", test.address,
+				test.secret, test.service, test.client, test.allowed, name, allowed)
+		}
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks([]string{"10.0.0.1", "::1", "10.2.0.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 3 || networks[0].String() != "10.0.0.1/32" || networks[1].String() != "::1/128" {
+		t.Fatalf("unexpected networks %v", networks)
+	}
+	if _, err = ParseNetworks([]string{"proxy.example.com"}); err == nil {
+		t.Fatal("a host name isn't an IP or a CIDR")
+	}
+}
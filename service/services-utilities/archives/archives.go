@@ -0,0 +1,253 @@
+package archives
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/bodgit/sevenzip"
+)
+
+// Format is the format of an archive
+type Format int
+
+// the formats of the archives which are extracted
+const (
+	None Format = iota
+	Zip
+	Tar
+	Gzip
+	SevenZip
+)
+
+func (f Format) String() string {
+	switch f {
+	case Zip:
+		return "zip"
+	case Tar:
+		return "tar"
+	case Gzip:
+		return "gzip"
+	case SevenZip:
+		return "7z"
+	}
+	return "none"
+}
+
+var (
+	// ErrEncrypted is returned when a member of the archive is encrypted, so it can't be scanned
+	ErrEncrypted = errors.New("archives: the archive is encrypted")
+	// ErrBomb is returned when the archive exceeds the extraction limits, ex: a zip bomb
+	ErrBomb = errors.New("archives: the archive exceeds the extraction limits")
+)
+
+// Limits caps the extraction of an archive, a limit of zero is no limit
+type Limits struct {
+	MaxDepth      int   // the nested archives which are deeper are scanned as files, 0 = they're all scanned as files
+	MaxMembers    int   // the number of the files of the archive and its nested archives
+	MaxMemberSize int64 // the extracted size of a file
+	MaxTotalSize  int64 // the extracted size of the archive and its nested archives
+	MaxRatio      int   // the extracted size of the archive divided by its size
+}
+
+// Member is an extracted file of an archive
+type Member struct {
+	Name  string // the path of the file, the members of a nested archive are prefixed with its path
+	Data  []byte
+	Depth int // 1 for the files of the archive, 2 for the ones of its nested archives and so on
+}
+
+// Detect returns the format of the archive upon its magic bytes, None if it isn't an archive
+func Detect(data []byte) Format {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")), bytes.HasPrefix(data, []byte("PK\x05\x06")):
+		return Zip
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		return Gzip
+	case bytes.HasPrefix(data, []byte("7z\xbc\xaf\x27\x1c")):
+		return SevenZip
+	case len(data) >= 262 && string(data[257:262]) == "ustar":
+		return Tar
+	}
+	return None
+}
+
+// extraction holds the sizes which the limits apply to across the nested archives
+type extraction struct {
+	limits  Limits
+	size    int64
+	members int
+	total   int64
+	fn      func(Member) error
+}
+
+// DetectLen is the length of the head of a file which Detect needs
+const DetectLen = 262
+
+// Extract calls fn for every file of the archive, the nested archives are extracted up to the max depth of the
+// limits instead of being passed to fn. It returns ErrEncrypted, an error which wraps ErrBomb, the error of fn
+// which stops the extraction or the error of a corrupted archive
+func Extract(data []byte, limits Limits, fn func(Member) error) error {
+	return ExtractFrom(bytes.NewReader(data), int64(len(data)), limits, fn)
+}
+
+// ExtractFrom is Extract for an archive of size bytes which is read from r, ex: a spooled body, so the archive
+// itself isn't held in memory
+func ExtractFrom(r io.ReaderAt, size int64, limits Limits, fn func(Member) error) error {
+	e := &extraction{limits: limits, size: size, fn: fn}
+	return e.extract(r, size, "", 1)
+}
+
+func (e *extraction) extract(r io.ReaderAt, size int64, prefix string, depth int) error {
+	head := make([]byte, DetectLen)
+	n, _ := r.ReadAt(head, 0)
+	switch Detect(head[:n]) {
+	case Zip:
+		return e.extractZip(r, size, prefix, depth)
+	case Tar:
+		return e.extractTar(r, size, prefix, depth)
+	case Gzip:
+		return e.extractGzip(r, size, prefix, depth)
+	case SevenZip:
+		return e.extract7z(r, size, prefix, depth)
+	}
+	return fmt.Errorf("archives: %s isn't an archive", strings.TrimSuffix(prefix, "/"))
+}
+
+func (e *extraction) extractZip(r io.ReaderAt, size int64, prefix string, depth int) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		// the first bit of the general purpose flags is set for the encrypted files
+		if f.Flags&0x1 != 0 {
+			return ErrEncrypted
+		}
+		if e.limits.MaxMemberSize > 0 && f.UncompressedSize64 > uint64(e.limits.MaxMemberSize) {
+			return fmt.Errorf("%w, %s is larger than %d bytes", ErrBomb, prefix+f.Name, e.limits.MaxMemberSize)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = e.member(rc, prefix+f.Name, depth)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *extraction) extractTar(r io.ReaderAt, size int64, prefix string, depth int) error {
+	tr := tar.NewReader(io.NewSectionReader(r, 0, size))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err = e.member(tr, prefix+hdr.Name, depth); err != nil {
+			return err
+		}
+	}
+}
+
+func (e *extraction) extractGzip(r io.ReaderAt, size int64, prefix string, depth int) error {
+	zr, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	// a gzip file has one file whose name is optional
+	name := path.Base(zr.Name)
+	if zr.Name == "" {
+		name = "gunzipped"
+	}
+	return e.member(zr, prefix+name, depth)
+}
+
+func (e *extraction) extract7z(r io.ReaderAt, size int64, prefix string, depth int) error {
+	zr, err := sevenzip.NewReader(r, size)
+	if err != nil {
+		return sevenZipError(err)
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if e.limits.MaxMemberSize > 0 && f.UncompressedSize > uint64(e.limits.MaxMemberSize) {
+			return fmt.Errorf("%w, %s is larger than %d bytes", ErrBomb, prefix+f.Name, e.limits.MaxMemberSize)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return sevenZipError(err)
+		}
+		err = e.member(rc, prefix+f.Name, depth)
+		rc.Close()
+		if err != nil {
+			return sevenZipError(err)
+		}
+	}
+	return nil
+}
+
+// sevenZipError returns ErrEncrypted for the errors of the encrypted 7z archives, sevenzip doesn't export them
+func sevenZipError(err error) error {
+	if err != nil && strings.Contains(err.Error(), "aes7z") {
+		return ErrEncrypted
+	}
+	return err
+}
+
+// member reads a file of an archive up to the limits, it extracts the file if it's a nested archive which isn't
+// deeper than the max depth or passes it to fn
+func (e *extraction) member(r io.Reader, name string, depth int) error {
+	e.members++
+	if e.limits.MaxMembers > 0 && e.members > e.limits.MaxMembers {
+		return fmt.Errorf("%w, it has more than %d files", ErrBomb, e.limits.MaxMembers)
+	}
+	max := int64(-1)
+	if e.limits.MaxMemberSize > 0 {
+		max = e.limits.MaxMemberSize
+	}
+	if e.limits.MaxTotalSize > 0 && (max < 0 || e.limits.MaxTotalSize-e.total < max) {
+		max = e.limits.MaxTotalSize - e.total
+	}
+	if max >= 0 {
+		r = io.LimitReader(r, max+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	e.total += int64(len(data))
+	switch {
+	case e.limits.MaxMemberSize > 0 && int64(len(data)) > e.limits.MaxMemberSize:
+		return fmt.Errorf("%w, %s is larger than %d bytes", ErrBomb, name, e.limits.MaxMemberSize)
+	case e.limits.MaxTotalSize > 0 && e.total > e.limits.MaxTotalSize:
+		return fmt.Errorf("%w, its files are larger than %d bytes", ErrBomb, e.limits.MaxTotalSize)
+	case e.limits.MaxRatio > 0 && e.total > int64(e.limits.MaxRatio)*e.size:
+		return fmt.Errorf("%w, its files are more than %d times larger than it", ErrBomb, e.limits.MaxRatio)
+	}
+
+	if depth < e.limits.MaxDepth && Detect(data) != None {
+		return e.extract(bytes.NewReader(data), int64(len(data)), name+"/", depth+1)
+	}
+	return e.fn(Member{Name: name, Data: data, Depth: depth})
+}
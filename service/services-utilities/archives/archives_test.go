@@ -0,0 +1,148 @@
+package archives
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func zipFile(t *testing.T, files map[string][]byte, encrypted bool) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		hdr := &zip.FileHeader{Name: name, Method: zip.Deflate}
+		if encrypted {
+			hdr.Flags |= 0x1
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarGzFile(t *testing.T, name string, data []byte) []byte {
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+	tw.Write(data)
+	tw.Close()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Name = "files.tar"
+	zw.Write(tarBuf.Bytes())
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestDetect(t *testing.T) {
+	if f := Detect(zipFile(t, map[string][]byte{"a.txt": []byte("a")}, false)); f != Zip {
+		t.Fatalf("expected zip, got %s", f)
+	}
+	if f := Detect(tarGzFile(t, "a.txt", []byte("a"))); f != Gzip {
+		t.Fatalf("expected gzip, got %s", f)
+	}
+	if f := Detect([]byte("%PDF-1.7")); f != None {
+		t.Fatalf("expected none, got %s", f)
+	}
+}
+
+func TestExtractNestedArchives(t *testing.T) {
+	inner := tarGzFile(t, "eicar.com", []byte("malicious"))
+	archive := zipFile(t, map[string][]byte{"readme.txt": []byte("clean"), "inner.tar.gz": inner}, false)
+
+	members := map[string]int{}
+	err := Extract(archive, Limits{MaxDepth: 3}, func(m Member) error {
+		members[m.Name] = m.Depth
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if members["readme.txt"] != 1 || members["inner.tar.gz/files.tar/eicar.com"] != 3 || len(members) != 2 {
+		t.Fatalf("the nested archives should be extracted, got %v", members)
+	}
+
+	// the nested archives deeper than the max depth are scanned as files
+	members = map[string]int{}
+	Extract(archive, Limits{MaxDepth: 2}, func(m Member) error {
+		members[m.Name] = m.Depth
+		return nil
+	})
+	if _, scanned := members["inner.tar.gz/files.tar"]; !scanned || len(members) != 2 {
+		t.Fatalf("the tar file should be scanned as a file, got %v", members)
+	}
+}
+
+func TestExtractFromFile(t *testing.T) {
+	archive := tarGzFile(t, "eicar.com", []byte("malicious"))
+	f, err := os.CreateTemp(t.TempDir(), "archive-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write(archive)
+
+	var names []string
+	err = ExtractFrom(f, int64(len(archive)), Limits{MaxDepth: 2}, func(m Member) error {
+		names = append(names, m.Name)
+		return nil
+	})
+	if err != nil || len(names) != 1 || names[0] != "files.tar/eicar.com" {
+		t.Fatalf("the archive should be extracted from the file, got %v %v", names, err)
+	}
+}
+
+func TestExtractStopsOnTheErrorOfFn(t *testing.T) {
+	errFound := errors.New("found")
+	archive := zipFile(t, map[string][]byte{"a.txt": []byte("a"), "b.txt": []byte("b")}, false)
+	calls := 0
+	err := Extract(archive, Limits{}, func(m Member) error {
+		calls++
+		return errFound
+	})
+	if err != errFound || calls != 1 {
+		t.Fatalf("the extraction should stop, got %v after %d files", err, calls)
+	}
+}
+
+func TestExtractEncryptedArchive(t *testing.T) {
+	archive := zipFile(t, map[string][]byte{"a.txt": []byte("a")}, true)
+	if err := Extract(archive, Limits{}, func(Member) error { return nil }); err != ErrEncrypted {
+		t.Fatalf("expected ErrEncrypted, got %v", err)
+	}
+}
+
+func TestExtractBombs(t *testing.T) {
+	zeros := []byte(strings.Repeat("0", 1<<20))
+	archive := zipFile(t, map[string][]byte{"zeros.txt": zeros}, false)
+	tests := map[string]Limits{
+		"member size": {MaxMemberSize: 1 << 10},
+		"total size":  {MaxTotalSize: 1 << 10},
+		"ratio":       {MaxRatio: 100},
+	}
+	for name, limits := range tests {
+		err := Extract(archive, limits, func(Member) error { return nil })
+		if !errors.Is(err, ErrBomb) {
+			t.Fatalf("%s: expected ErrBomb, got %v", name, err)
+		}
+	}
+
+	many := map[string][]byte{}
+	for _, name := range []string{"a", "b", "c"} {
+		many[name] = []byte(name)
+	}
+	if err := Extract(zipFile(t, many, false), Limits{MaxMembers: 2}, func(Member) error { return nil }); !errors.Is(err, ErrBomb) {
+		t.Fatalf("max members: expected ErrBomb, got %v", err)
+	}
+}
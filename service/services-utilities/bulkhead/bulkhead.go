@@ -0,0 +1,175 @@
+package bulkhead
+
+import (
+	"icapeg/config"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bulkhead limits the number of in-flight requests of a service, the requests above the
+// limit wait in the bounded queue of the service so a slow vendor doesn't starve the other services
+type Bulkhead struct {
+	slots        chan struct{}
+	maxQueue     int64
+	queueTimeout time.Duration
+	retryAfter   time.Duration
+	waiting      int64
+	rejected     uint64
+}
+
+// Stats represents the state of a bulkhead
+type Stats struct {
+	MaxConcurrent int    `json:"max_concurrent"`
+	MaxQueue      int64  `json:"max_queue"`
+	InFlight      int    `json:"in_flight"`
+	Waiting       int64  `json:"waiting"`
+	Rejected      uint64 `json:"rejected"`
+}
+
+var (
+	bulkheadsMu sync.RWMutex
+	bulkheads   = make(map[string]*Bulkhead)
+)
+
+// InitBulkheads reads the optional [<service>.bulkhead] section of every service,
+// the in-flight requests of a service without that section aren't limited
+func InitBulkheads(services []string) {
+	for _, serviceName := range services {
+		if !readValues.IsSecExists(serviceName + ".bulkhead") {
+			continue
+		}
+		logging.Logger.Debug("loading " + serviceName + " bulkhead configurations")
+		maxConcurrent := readValues.ReadValuesInt(serviceName + ".bulkhead.max_concurrent")
+		if maxConcurrent <= 0 {
+			continue
+		}
+		b := New(maxConcurrent, readValues.ReadValuesInt(serviceName+".bulkhead.max_queue"),
+			readValues.ReadValuesDuration(serviceName+".bulkhead.queue_timeout")*time.Second)
+		b.retryAfter = readValues.ReadValuesDuration(serviceName+".bulkhead.retry_after") * time.Second
+		Register(serviceName, b)
+	}
+}
+
+// InitTenantBulkheads registers a bulkhead for every tenant which limits its in-flight requests
+func InitTenantBulkheads(tenants map[string]*config.TenantConfig) {
+	for tenant, tenantCfg := range tenants {
+		if tenantCfg.MaxConcurrent > 0 {
+			Register(TenantName(tenant), New(tenantCfg.MaxConcurrent, 0, tenantCfg.QueueTimeout))
+		}
+	}
+}
+
+// TenantName returns the bulkhead name of a tenant
+func TenantName(tenant string) string {
+	return "tenant:" + tenant
+}
+
+// New creates a bulkhead of max concurrent slots, a max queue of zero means an unbounded queue
+// and a queue timeout of zero makes the requests wait for a free slot as long as it takes
+func New(maxConcurrent, maxQueue int, queueTimeout time.Duration) *Bulkhead {
+	return &Bulkhead{
+		slots:        make(chan struct{}, maxConcurrent),
+		maxQueue:     int64(maxQueue),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// Register sets the bulkhead of a service
+func Register(serviceName string, b *Bulkhead) {
+	bulkheadsMu.Lock()
+	defer bulkheadsMu.Unlock()
+	bulkheads[serviceName] = b
+}
+
+// Acquire waits for a free slot of the service, it returns false if the queue is full or the queue timeout passed,
+// release must be called once the vendor finished processing the request
+func Acquire(serviceName string) (release func(), acquired bool) {
+	bulkheadsMu.RLock()
+	b, exists := bulkheads[serviceName]
+	bulkheadsMu.RUnlock()
+	if !exists {
+		return func() {}, true
+	}
+	if !b.Acquire() {
+		return nil, false
+	}
+	return b.Release, true
+}
+
+// MaxConcurrent returns the max in-flight requests of the service, zero means unlimited
+func MaxConcurrent(serviceName string) int {
+	bulkheadsMu.RLock()
+	defer bulkheadsMu.RUnlock()
+	if b, exists := bulkheads[serviceName]; exists {
+		return cap(b.slots)
+	}
+	return 0
+}
+
+// RetryAfter returns how long the ICAP client should wait before retrying a rejected request of the service
+func RetryAfter(serviceName string) time.Duration {
+	bulkheadsMu.RLock()
+	defer bulkheadsMu.RUnlock()
+	if b, exists := bulkheads[serviceName]; exists {
+		return b.retryAfter
+	}
+	return 0
+}
+
+// Acquire waits for a free slot, it returns false if the queue is full or the queue timeout passed
+func (b *Bulkhead) Acquire() bool {
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	default:
+	}
+	waiting := atomic.AddInt64(&b.waiting, 1)
+	defer atomic.AddInt64(&b.waiting, -1)
+	if b.maxQueue > 0 && waiting > b.maxQueue {
+		atomic.AddUint64(&b.rejected, 1)
+		return false
+	}
+	if b.queueTimeout <= 0 {
+		b.slots <- struct{}{}
+		return true
+	}
+	timer := time.NewTimer(b.queueTimeout)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		atomic.AddUint64(&b.rejected, 1)
+		return false
+	}
+}
+
+// Release frees the slot of a finished request
+func (b *Bulkhead) Release() {
+	<-b.slots
+}
+
+// Stats returns the state of the bulkhead
+func (b *Bulkhead) Stats() Stats {
+	return Stats{
+		MaxConcurrent: cap(b.slots),
+		MaxQueue:      b.maxQueue,
+		InFlight:      len(b.slots),
+		Waiting:       atomic.LoadInt64(&b.waiting),
+		Rejected:      atomic.LoadUint64(&b.rejected),
+	}
+}
+
+// AllStats returns the state of the bulkhead of every service
+func AllStats() map[string]Stats {
+	bulkheadsMu.RLock()
+	defer bulkheadsMu.RUnlock()
+	result := make(map[string]Stats, len(bulkheads))
+	for serviceName, b := range bulkheads {
+		result[serviceName] = b.Stats()
+	}
+	return result
+}
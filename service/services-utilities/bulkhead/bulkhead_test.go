@@ -0,0 +1,55 @@
+package bulkhead
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBulkheadQueueTimeout(t *testing.T) {
+	b := New(1, 0, 10*time.Millisecond)
+	if !b.Acquire() {
+		t.Fatalf("the first request should get a slot")
+	}
+	if b.Acquire() {
+		t.Fatalf("the second request should time out while the slot is busy")
+	}
+	b.Release()
+	if !b.Acquire() {
+		t.Fatalf("a released slot should be reused")
+	}
+	if stats := b.Stats(); stats.InFlight != 1 || stats.Rejected != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBulkheadsAreIndependent(t *testing.T) {
+	Register("slow", New(1, 0, 10*time.Millisecond))
+	release, acquired := Acquire("slow")
+	if !acquired {
+		t.Fatalf("the first request of the slow service should get a slot")
+	}
+	defer release()
+	if _, acquired = Acquire("slow"); acquired {
+		t.Fatalf("the slow service should be full")
+	}
+	if _, acquired = Acquire("fast"); !acquired {
+		t.Fatalf("a service without a bulkhead should never wait")
+	}
+}
+
+func TestBulkheadBoundedQueue(t *testing.T) {
+	b := New(1, 1, time.Second)
+	b.Acquire()
+	queued := make(chan bool)
+	go func() { queued <- b.Acquire() }()
+	for b.Stats().Waiting != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if b.Acquire() {
+		t.Fatalf("a request above the queue bound should be rejected")
+	}
+	b.Release()
+	if !<-queued {
+		t.Fatalf("the queued request should get the released slot")
+	}
+}
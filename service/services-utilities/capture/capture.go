@@ -0,0 +1,250 @@
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redacted replaces the values of the secret headers and query parameters in the captured exchanges
+const Redacted = "REDACTED"
+
+// the headers and the query parameters which are always redacted
+var defaultRedact = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key",
+	"Api-Key", "Apikey", "Key", "Token", "Access_token"}
+
+// Config represents [app.vendor_capture] section configuration
+type Config struct {
+	Dir           string
+	SamplePercent int      // the percent of the transactions whose vendor API calls are captured
+	MaxBodySize   int      // the captured part of every body, 0 = the whole body
+	Vendors       []string // the vendors whose API calls are captured, * = all vendors
+	Redact        map[string]bool
+}
+
+var cfg *Config
+
+// InitCapture reads the optional [app.vendor_capture] section, the vendor API calls aren't captured if it
+// doesn't exist
+func InitCapture() {
+	if !readValues.IsSecExists("app.vendor_capture") || !readValues.ReadValuesBool("app.vendor_capture.enabled") {
+		return
+	}
+	c := New(readValues.ReadValuesString("app.vendor_capture.dir"),
+		readValues.ReadValuesInt("app.vendor_capture.sample_percent"),
+		readValues.ReadValuesInt("app.vendor_capture.max_body_size"),
+		readValues.ReadValuesSlice("app.vendor_capture.vendors"),
+		readValues.ReadValuesSlice("app.vendor_capture.redact"))
+	if c.SamplePercent <= 0 || c.SamplePercent > 100 {
+		logging.Logger.Error("vendor_capture sample_percent must be from 1 to 100, the vendor API calls aren't captured")
+		return
+	}
+	if err := os.MkdirAll(c.Dir, os.ModePerm); err != nil {
+		logging.Logger.Error("couldn't create the vendor capture directory, the vendor API calls aren't captured: " +
+			err.Error())
+		return
+	}
+	logging.Logger.Warn("capturing the vendor API calls of " + fmt.Sprint(c.SamplePercent) + "% of the transactions in " +
+		c.Dir + ", it's a debug mode")
+	cfg = c
+}
+
+// New creates a capture configuration, the headers and the query parameters of redact are redacted with the
+// default secret ones
+func New(dir string, samplePercent, maxBodySize int, vendors, redact []string) *Config {
+	c := &Config{Dir: dir, SamplePercent: samplePercent, MaxBodySize: maxBodySize, Vendors: vendors,
+		Redact: make(map[string]bool)}
+	for _, name := range append(defaultRedact, redact...) {
+		c.Redact[strings.ToLower(name)] = true
+	}
+	return c
+}
+
+// Transport returns the transport of the API calls of the vendor in the transaction, it captures the calls
+// into the capture directory if the transaction is sampled, base is returned as it is otherwise
+func Transport(vendor, xICAPMetadata string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if cfg == nil || !cfg.sampled(vendor, xICAPMetadata) {
+		return base
+	}
+	return &transport{cfg: cfg, vendor: vendor, xICAPMetadata: xICAPMetadata, base: base}
+}
+
+// sampled reports whether the API calls of the vendor in the transaction are captured, all API calls of a
+// sampled transaction are captured
+func (c *Config) sampled(vendor, xICAPMetadata string) bool {
+	captured := false
+	for _, v := range c.Vendors {
+		if v == "*" || strings.EqualFold(v, vendor) {
+			captured = true
+			break
+		}
+	}
+	if !captured {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(xICAPMetadata))
+	return int(h.Sum32()%100) < c.SamplePercent
+}
+
+type transport struct {
+	cfg           *Config
+	vendor        string
+	xICAPMetadata string
+	base          http.RoundTripper
+	calls         int
+	mu            sync.Mutex
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	x := &exchange{cfg: t.cfg, xICAPMetadata: t.xICAPMetadata, start: time.Now(), name: t.name()}
+	if req.Body != nil && req.Body != http.NoBody {
+		captured := &cappedBuffer{max: t.cfg.MaxBodySize}
+		req = req.Clone(req.Context())
+		req.Body = &teeBody{Reader: io.TeeReader(req.Body, captured), Closer: req.Body}
+		x.reqBody = captured
+	}
+	x.writeRequest(req)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		x.save("error: " + err.Error())
+		return resp, err
+	}
+	x.writeResponse(resp)
+	respBody := &cappedBuffer{max: t.cfg.MaxBodySize}
+	resp.Body = &captureBody{teeBody: teeBody{Reader: io.TeeReader(resp.Body, respBody), Closer: resp.Body},
+		x: x, captured: respBody}
+	return resp, nil
+}
+
+// name returns the file name of the next API call of the transaction
+func (t *transport) name() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.calls++
+	return fmt.Sprintf("%s-%s-%s-%d.http", time.Now().UTC().Format("20060102T150405.000000000"), t.xICAPMetadata,
+		t.vendor, t.calls)
+}
+
+// exchange is an API call which is captured, it's written when the body of the response is closed
+type exchange struct {
+	cfg           *Config
+	xICAPMetadata string
+	start         time.Time
+	name          string
+	head          bytes.Buffer
+	reqBody       *cappedBuffer
+	resp          bytes.Buffer
+	once          sync.Once
+}
+
+func (x *exchange) writeRequest(req *http.Request) {
+	u := *req.URL
+	query := u.Query()
+	for key := range query {
+		if x.cfg.Redact[strings.ToLower(key)] {
+			query.Set(key, Redacted)
+		}
+	}
+	u.RawQuery = query.Encode()
+	fmt.Fprintf(&x.head, "%s %s %s\r\n", req.Method, u.String(), valueOrDefault(req.Proto, "HTTP/1.1"))
+	x.redactHeader(req.Header).Write(&x.head)
+	x.head.WriteString("\r\n")
+}
+
+func (x *exchange) writeResponse(resp *http.Response) {
+	fmt.Fprintf(&x.resp, "%s %s\r\n", valueOrDefault(resp.Proto, "HTTP/1.1"), resp.Status)
+	x.redactHeader(resp.Header).Write(&x.resp)
+	x.resp.WriteString("\r\n")
+}
+
+// redactHeader returns a copy of the header whose secret values are redacted
+func (x *exchange) redactHeader(header http.Header) http.Header {
+	redacted := header.Clone()
+	for key := range redacted {
+		if x.cfg.Redact[strings.ToLower(key)] {
+			redacted[key] = []string{Redacted}
+		}
+	}
+	return redacted
+}
+
+// save writes the captured API call once, with the body of the response if it was read
+func (x *exchange) save(result string) {
+	x.once.Do(func() {
+		file := &bytes.Buffer{}
+		fmt.Fprintf(file, "# %s in %v\r\n", result, time.Since(x.start).Round(time.Millisecond))
+		file.Write(x.head.Bytes())
+		if x.reqBody != nil {
+			x.reqBody.writeTo(file)
+		}
+		file.WriteString("\r\n")
+		file.Write(x.resp.Bytes())
+		if err := os.WriteFile(filepath.Join(x.cfg.Dir, x.name), file.Bytes(), 0600); err != nil {
+			logging.Logger.Error(utils.PrepareLogMsg(x.xICAPMetadata, "couldn't capture the vendor API call: "+err.Error()))
+		}
+	})
+}
+
+// cappedBuffer keeps the first max bytes which are written to it and counts the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	max     int
+	dropped int64
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	keep := len(p)
+	if b.max > 0 && b.Len()+keep > b.max {
+		keep = b.max - b.Len()
+	}
+	b.Buffer.Write(p[:keep])
+	b.dropped += int64(len(p) - keep)
+	return len(p), nil
+}
+
+func (b *cappedBuffer) writeTo(w io.Writer) {
+	w.Write(b.Bytes())
+	if b.dropped > 0 {
+		fmt.Fprintf(w, "\r\n# %d more bytes weren't captured\r\n", b.dropped)
+	}
+}
+
+type teeBody struct {
+	io.Reader
+	io.Closer
+}
+
+// captureBody saves the API call when the body of the response is closed
+type captureBody struct {
+	teeBody
+	x        *exchange
+	captured *cappedBuffer
+}
+
+func (b *captureBody) Close() error {
+	err := b.teeBody.Close()
+	b.captured.writeTo(&b.x.resp)
+	b.x.save("captured")
+	return err
+}
+
+func valueOrDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
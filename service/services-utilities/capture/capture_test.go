@@ -0,0 +1,54 @@
+package capture
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTransportCapturesRedactedExchanges(t *testing.T) {
+	vendor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{"KnownMalicious": "eicar", "padding": "` + strings.Repeat("x", 100) + `"}`))
+	}))
+	defer vendor.Close()
+	dir := t.TempDir()
+	cfg = New(dir, 100, 32, []string{"clhashlookup"}, []string{"X-Tenant-Secret"})
+	defer func() { cfg = nil }()
+
+	if Transport("clamav", "meta", nil) != http.DefaultTransport {
+		t.Fatal("the calls of a vendor which isn't captured shouldn't be wrapped")
+	}
+	client := &http.Client{Transport: Transport("clhashlookup", "meta", nil)}
+	req, _ := http.NewRequest(http.MethodPost, vendor.URL+"/lookup?apikey=s3cret&hash=abc", strings.NewReader("file"))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	req.Header.Set("X-Tenant-Secret", "s3cret")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "eicar") {
+		t.Fatalf("the vendor response should be returned as it is, got %q", body)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*-meta-clhashlookup-1.http"))
+	if len(files) != 1 {
+		t.Fatalf("expected a captured exchange, got %v", files)
+	}
+	captured, _ := os.ReadFile(files[0])
+	for _, expected := range []string{"apikey=" + Redacted, "hash=abc", "Authorization: " + Redacted,
+		"X-Tenant-Secret: " + Redacted, "\r\nfile\r\n", "HTTP/1.1 200 OK", "more bytes weren't captured"} {
+		if !strings.Contains(string(captured), expected) {
+			t.Errorf("the captured exchange should contain %q:\n%s", expected, captured)
+		}
+	}
+	if strings.Contains(string(captured), "s3cret") {
+		t.Fatalf("the secrets should be redacted:\n%s", captured)
+	}
+}
@@ -0,0 +1,316 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the sources of the vendor credentials
+const (
+	SourceStatic  = "static"  // the value of the config file, replaced through the admin API only
+	SourceFile    = "file"    // the content of a file, ex: a mounted Kubernetes secret or a file of Vault agent
+	SourceCommand = "command" // the output of a command, ex: the CLI of a secret manager
+	SourceOAuth2  = "oauth2"  // an access token of the OAuth 2.0 client credentials grant
+)
+
+// a credential which expires is refreshed this long before it expires
+const expiryMargin = time.Minute
+
+// ErrNoCredential is returned for a vendor whose credential couldn't be loaded yet
+var ErrNoCredential = errors.New("the vendor has no credential")
+
+// Status represents the state of the credential of a vendor, without the credential
+type Status struct {
+	Source    string     `json:"source"`
+	RotatedAt time.Time  `json:"rotated_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Rotations uint64     `json:"rotations"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// Credential is the API key or the token of a vendor, it's loaded again from its source when it changes, so
+// a rotated key is used by the next vendor call without restarting ICAPeg
+type Credential struct {
+	vendor string
+	source string
+	fetch  func() (string, time.Time, error) // nil for the static credentials
+
+	mu      sync.Mutex
+	value   string
+	fetched string // the last value of the source, a value set through the admin API stays until it changes
+	expiry  time.Time
+	stale   bool
+	status  Status
+	now     func() time.Time
+}
+
+var (
+	credentialsMu sync.RWMutex
+	credentials   = make(map[string]*Credential)
+	watchOnce     sync.Once
+)
+
+// InitCredentials reads the optional [app.vendor_credentials] section and its sub section of every vendor,
+// the vendors use the credentials of their own service sections if it doesn't exist
+func InitCredentials() {
+	if !readValues.IsSecExists("app.vendor_credentials") ||
+		!readValues.ReadValuesBool("app.vendor_credentials.enabled") {
+		return
+	}
+	for _, vendor := range readValues.ReadSubSections("app.vendor_credentials") {
+		c, err := read("app.vendor_credentials."+vendor, vendor)
+		if err != nil {
+			logging.Logger.Error("invalid vendor_credentials of " + vendor + " vendor: " + err.Error())
+			continue
+		}
+		Register(vendor, c)
+		if err := c.Refresh(); err != nil {
+			logging.Logger.Error("couldn't load the credential of " + vendor + " vendor: " + err.Error())
+		}
+	}
+	if readValues.IsSecExists("app.vendor_credentials.refresh_interval") {
+		Watch(readValues.ReadValuesDuration("app.vendor_credentials.refresh_interval") * time.Second)
+	}
+}
+
+// read reads the credential of a vendor section
+func read(sec, vendor string) (*Credential, error) {
+	switch source := readValues.ReadValuesString(sec + ".source"); source {
+	case SourceStatic:
+		return NewStatic(readValues.ReadValuesString(sec + ".value")), nil
+	case SourceFile:
+		return NewFile(readValues.ReadValuesString(sec + ".file")), nil
+	case SourceCommand:
+		command := readValues.ReadValuesSlice(sec + ".command")
+		if len(command) == 0 {
+			return nil, errors.New("the command is empty")
+		}
+		return NewCommand(command), nil
+	case SourceOAuth2:
+		cfg := OAuth2Config{
+			TokenURL:     readValues.ReadValuesString(sec + ".token_url"),
+			ClientID:     readValues.ReadValuesString(sec + ".client_id"),
+			ClientSecret: readValues.ReadValuesString(sec + ".client_secret"),
+		}
+		if readValues.IsSecExists(sec + ".scopes") {
+			cfg.Scopes = readValues.ReadValuesSlice(sec + ".scopes")
+		}
+		return NewOAuth2(vendor, cfg), nil
+	default:
+		return nil, errors.New("unknown source " + source + ", it must be static, file, command or oauth2")
+	}
+}
+
+// NewStatic creates a credential of a fixed value, it changes through the admin API only
+func NewStatic(value string) *Credential {
+	c := newCredential(SourceStatic, nil)
+	c.value, c.fetched = value, value
+	c.status.RotatedAt = c.now()
+	return c
+}
+
+// NewFile creates a credential which is the content of the file without the surrounding white spaces
+func NewFile(file string) *Credential {
+	return newCredential(SourceFile, func() (string, time.Time, error) {
+		b, err := os.ReadFile(file)
+		return string(bytes.TrimSpace(b)), time.Time{}, err
+	})
+}
+
+// NewCommand creates a credential which is the output of the command without the surrounding white spaces
+func NewCommand(command []string) *Credential {
+	return newCredential(SourceCommand, func() (string, time.Time, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		out, err := exec.CommandContext(ctx, command[0], command[1:]...).Output()
+		return string(bytes.TrimSpace(out)), time.Time{}, err
+	})
+}
+
+func newCredential(source string, fetch func() (string, time.Time, error)) *Credential {
+	return &Credential{source: source, fetch: fetch, now: time.Now, status: Status{Source: source}}
+}
+
+// Register makes c the credential of the vendor
+func Register(vendor string, c *Credential) {
+	c.mu.Lock()
+	c.vendor = vendor
+	c.mu.Unlock()
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+	credentials[strings.ToLower(vendor)] = c
+}
+
+func lookup(vendor string) (*Credential, bool) {
+	credentialsMu.RLock()
+	defer credentialsMu.RUnlock()
+	c, exists := credentials[strings.ToLower(vendor)]
+	return c, exists
+}
+
+// Get returns the current credential of the vendor, exists is false if it has no [app.vendor_credentials]
+// sub section, so the vendor uses the credential of its service section. An expired or invalidated credential
+// is loaded again from its source first
+func Get(vendor string) (value string, exists bool, err error) {
+	c, exists := lookup(vendor)
+	if !exists {
+		return "", false, nil
+	}
+	value, err = c.Get()
+	return value, true, err
+}
+
+// Invalidate makes the next Get of the vendor load its credential again, it's called after the vendor
+// rejected the credential (ex: a 401 response after the key was rotated at the vendor)
+func Invalidate(vendor string) {
+	if c, exists := lookup(vendor); exists {
+		c.mu.Lock()
+		c.stale = true
+		c.mu.Unlock()
+	}
+}
+
+// Set replaces the credential of the vendor, it's used by the admin API. The credential of a file or a command
+// is replaced again when the file or the output of the command changes
+func Set(vendor, value string) bool {
+	c, exists := lookup(vendor)
+	if !exists {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rotate(value, time.Time{})
+	c.stale = false
+	logging.Logger.Info("the credential of " + vendor + " vendor was replaced through the admin API")
+	return true
+}
+
+// Reload loads the credentials of the vendor again from their sources, it returns false if the vendor has none
+func Reload(vendor string) (bool, error) {
+	c, exists := lookup(vendor)
+	if !exists {
+		return false, nil
+	}
+	return true, c.Refresh()
+}
+
+// ReloadAll loads the credentials of all vendors again from their sources, ex: on SIGHUP
+func ReloadAll() {
+	for _, vendor := range vendors() {
+		if _, err := Reload(vendor); err != nil {
+			logging.Logger.Error("couldn't reload the credential of " + vendor +
+				" vendor, the last loaded one is still used: " + err.Error())
+		}
+	}
+}
+
+// Watch loads the credentials again every interval, an interval of zero doesn't, so they're loaded again on
+// SIGHUP, through the admin API or when they expire only
+func Watch(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	watchOnce.Do(func() {
+		go func() {
+			for range time.Tick(interval) {
+				ReloadAll()
+			}
+		}()
+	})
+}
+
+// AllStatus returns the state of the credential of every vendor
+func AllStatus() map[string]Status {
+	result := make(map[string]Status)
+	for _, vendor := range vendors() {
+		c, _ := lookup(vendor)
+		c.mu.Lock()
+		result[vendor] = c.status
+		c.mu.Unlock()
+	}
+	return result
+}
+
+func vendors() []string {
+	credentialsMu.RLock()
+	defer credentialsMu.RUnlock()
+	var result []string
+	for vendor := range credentials {
+		result = append(result, vendor)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Get returns the current credential, it's loaded again first if it expires or it was invalidated
+func (c *Credential) Get() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fetch != nil && (c.value == "" || c.stale ||
+		(!c.expiry.IsZero() && c.now().Add(expiryMargin).After(c.expiry))) {
+		if err := c.refreshLocked(); err != nil && (c.value == "" || c.expired()) {
+			return "", err
+		}
+	}
+	if c.value == "" {
+		return "", ErrNoCredential
+	}
+	return c.value, nil
+}
+
+// Refresh loads the credential again from its source, the last loaded one is kept if it fails
+func (c *Credential) Refresh() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.refreshLocked()
+}
+
+func (c *Credential) refreshLocked() error {
+	if c.fetch == nil {
+		return nil
+	}
+	value, expiry, err := c.fetch()
+	if err == nil && value == "" {
+		err = ErrNoCredential
+	}
+	if err != nil {
+		c.status.LastError = err.Error()
+		return err
+	}
+	c.status.LastError = ""
+	c.stale = false
+	if value != c.fetched || c.source == SourceOAuth2 {
+		if c.fetched != "" && c.source != SourceOAuth2 {
+			logging.Logger.Info("the credential of " + c.vendor + " vendor was rotated from its " + c.source)
+		}
+		c.fetched = value
+		c.rotate(value, expiry)
+	}
+	return nil
+}
+
+// rotate makes value the current credential
+func (c *Credential) rotate(value string, expiry time.Time) {
+	if c.value != "" {
+		c.status.Rotations++
+	}
+	c.value, c.expiry = value, expiry
+	c.status.RotatedAt = c.now()
+	c.status.ExpiresAt = nil
+	if !expiry.IsZero() {
+		c.status.ExpiresAt = &expiry
+	}
+}
+
+func (c *Credential) expired() bool {
+	return !c.expiry.IsZero() && !c.now().Before(c.expiry)
+}
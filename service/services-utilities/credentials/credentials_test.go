@@ -0,0 +1,90 @@
+package credentials
+
+import (
+	"icapeg/logging"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestFileCredentialRotation(t *testing.T) {
+	logging.Logger = zap.NewNop()
+	file := filepath.Join(t.TempDir(), "api-key")
+	os.WriteFile(file, []byte("key-1\n"), 0600)
+	Register("filevendor", NewFile(file))
+	if value, exists, err := Get("filevendor"); !exists || err != nil || value != "key-1" {
+		t.Fatalf("expected key-1, got %q %v %v", value, exists, err)
+	}
+
+	os.WriteFile(file, []byte("key-2\n"), 0600)
+	ReloadAll()
+	if value, _, _ := Get("filevendor"); value != "key-2" {
+		t.Fatalf("the rotated key should be used, got %q", value)
+	}
+
+	// the key of the admin API stays until the file changes, and a missing file keeps the last key
+	Set("filevendor", "key-admin")
+	ReloadAll()
+	if value, _, _ := Get("filevendor"); value != "key-admin" {
+		t.Fatalf("the key of the admin API should be kept, got %q", value)
+	}
+	os.Remove(file)
+	ReloadAll()
+	if value, _, _ := Get("filevendor"); value != "key-admin" {
+		t.Fatalf("the last key should be kept, got %q", value)
+	}
+	if status := AllStatus()["filevendor"]; status.Rotations != 2 || status.LastError == "" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if _, exists, _ := Get("othervendor"); exists {
+		t.Fatal("a vendor without a credential shouldn't have one")
+	}
+}
+
+func TestOAuth2CredentialRefresh(t *testing.T) {
+	logging.Logger = zap.NewNop()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "icapeg" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" ||
+			r.FormValue("scope") != "scan read" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		requests++
+		w.Write([]byte(`{"access_token":"token-` + strconv.Itoa(requests) + `","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	c := newOAuth2(OAuth2Config{TokenURL: server.URL, ClientID: "icapeg", ClientSecret: "s3cret",
+		Scopes: []string{"scan", "read"}}, server.Client())
+	c.now = func() time.Time { return now }
+	if value, err := c.Get(); err != nil || value != "token-1" {
+		t.Fatalf("expected token-1, got %q %v", value, err)
+	}
+	if value, _ := c.Get(); value != "token-1" {
+		t.Fatalf("the token should be reused until it expires, got %q", value)
+	}
+	now = now.Add(time.Hour - time.Second)
+	if value, _ := c.Get(); value != "token-2" {
+		t.Fatalf("the token should be requested again before it expires, got %q", value)
+	}
+	Register("oauthvendor", c)
+	Invalidate("oauthvendor")
+	if value, _, _ := Get("oauthvendor"); value != "token-3" {
+		t.Fatalf("an invalidated token should be requested again, got %q", value)
+	}
+
+	bad := newOAuth2(OAuth2Config{TokenURL: server.URL, ClientID: "other"}, server.Client())
+	if _, err := bad.Get(); err == nil {
+		t.Fatal("a rejected client shouldn't get a token")
+	}
+}
@@ -0,0 +1,76 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"icapeg/service/services-utilities/proxy"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OAuth2Config represents the OAuth 2.0 client of a vendor which needs access tokens
+type OAuth2Config struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+// NewOAuth2 creates a credential which is an access token of the client credentials grant, the token is
+// requested again before it expires, its requests go through the outbound proxy of the vendor
+func NewOAuth2(vendor string, cfg OAuth2Config) *Credential {
+	client := &http.Client{Transport: proxy.Transport(vendor), Timeout: 30 * time.Second}
+	return newOAuth2(cfg, client)
+}
+
+func newOAuth2(cfg OAuth2Config, client *http.Client) *Credential {
+	c := newCredential(SourceOAuth2, nil)
+	c.fetch = func() (string, time.Time, error) {
+		return requestToken(cfg, client, c.now())
+	}
+	return c
+}
+
+// requestToken requests an access token from the token endpoint, the client is authenticated with the basic
+// authentication of RFC 6749
+func requestToken(cfg OAuth2Config, client *http.Client, now time.Time) (string, time.Time, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(cfg.Scopes) != 0 {
+		form.Set("scope", strings.Join(cfg.Scopes, " "))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(cfg.ClientSecret))
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		Error       string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil && resp.StatusCode == http.StatusOK {
+		return "", time.Time{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, errors.New("the token endpoint responded with " + strconv.Itoa(resp.StatusCode) +
+			" " + token.Error)
+	}
+	var expiry time.Time
+	if token.ExpiresIn > 0 {
+		expiry = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return token.AccessToken, expiry, nil
+}
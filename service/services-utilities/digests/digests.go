@@ -0,0 +1,109 @@
+package digests
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"io"
+	"strings"
+
+	"github.com/glaslos/ssdeep"
+)
+
+// the digests which may be computed for the scanned files
+const (
+	MD5    = "md5"
+	SHA1   = "sha1"
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+	SSDEEP = "ssdeep"
+)
+
+// the SHA-256 digest is always computed since the verdict cache and the hash lists are keyed by it
+var algorithms = []string{SHA256}
+
+// InitDigests reads the optional [app.digests] section, only the SHA-256 digest is computed if it doesn't exist
+func InitDigests() {
+	if !readValues.IsSecExists("app.digests") || !readValues.ReadValuesBool("app.digests.enabled") {
+		return
+	}
+	selected := []string{SHA256}
+	for _, algorithm := range readValues.ReadValuesSlice("app.digests.algorithms") {
+		algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+		if newHash(algorithm) == nil {
+			logging.Logger.Error("digests algorithm " + algorithm + " is not supported, it isn't computed")
+			continue
+		}
+		if !contains(selected, algorithm) {
+			selected = append(selected, algorithm)
+		}
+	}
+	algorithms = selected
+}
+
+// Algorithms returns the digests which are computed for every scanned file
+func Algorithms() []string {
+	return algorithms
+}
+
+// Compute computes the configured digests of the stream in one pass over it
+func Compute(r io.Reader) (map[string]string, error) {
+	return ComputeWith(algorithms, r)
+}
+
+// ComputeWith computes the digests of the stream in one pass over it, the ssdeep digest is missing from the
+// result if the stream is too small to have a meaningful one
+func ComputeWith(algorithms []string, r io.Reader) (map[string]string, error) {
+	hashes := make(map[string]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, 0, len(algorithms))
+	for _, algorithm := range algorithms {
+		if h := newHash(algorithm); h != nil {
+			hashes[algorithm] = h
+			writers = append(writers, h)
+		}
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return nil, err
+	}
+	sums := make(map[string]string, len(hashes))
+	for algorithm, h := range hashes {
+		if algorithm == SSDEEP {
+			if sum := string(h.Sum(nil)); sum != "" {
+				sums[algorithm] = sum
+			}
+			continue
+		}
+		sums[algorithm] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums, nil
+}
+
+func newHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case MD5:
+		return md5.New()
+	case SHA1:
+		return sha1.New()
+	case SHA256:
+		return sha256.New()
+	case SHA512:
+		return sha512.New()
+	case SSDEEP:
+		return ssdeep.New()
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package digests
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestComputeWith(t *testing.T) {
+	sums, err := ComputeWith([]string{MD5, SHA1, SHA256, SHA512}, strings.NewReader("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		MD5:    "900150983cd24fb0d6963f7d28e17f72",
+		SHA1:   "a9993e364706816aba3e25717850c26c9cd0d89d",
+		SHA256: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		SHA512: "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a" +
+			"2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f",
+	}
+	for algorithm, sum := range expected {
+		if sums[algorithm] != sum {
+			t.Fatalf("unexpected %s digest %s", algorithm, sums[algorithm])
+		}
+	}
+}
+
+func TestComputeSSDEEP(t *testing.T) {
+	sums, err := ComputeWith([]string{SHA256, SSDEEP}, strings.NewReader("too small"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := sums[SSDEEP]; exists {
+		t.Fatal("a small file shouldn't have an ssdeep digest")
+	}
+	body := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog "), 500)
+	if sums, _ = ComputeWith([]string{SSDEEP}, bytes.NewReader(body)); !strings.Contains(sums[SSDEEP], ":") {
+		t.Fatalf("unexpected ssdeep digest %q", sums[SSDEEP])
+	}
+}
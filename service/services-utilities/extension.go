@@ -1,10 +1,15 @@
 package services_utilities
 
 import (
+	"icapeg/config"
 	"icapeg/consts"
 	"icapeg/logging"
+	"sync"
 )
 
+// the extensions arrays of the scan profiles in their checking order, they're prepared once per profile
+var profileExtArrs sync.Map
+
 // Extension struct is used for storing the name of the extension array (bypass, reject, process)
 // in Name field and the content of the array (for example ["pdf", "zip", "com"])
 // in Exts field
@@ -58,3 +63,13 @@ func InitExtsArr(processExts, rejectExts, bypassExts []string) []Extension {
 	}
 	return extArrs
 }
+
+// ProfileExtsArr returns the extensions arrays of the scan profile in the order of InitExtsArr
+func ProfileExtsArr(profile *config.ScanProfileConfig) []Extension {
+	if extArrs, exists := profileExtArrs.Load(profile); exists {
+		return extArrs.([]Extension)
+	}
+	extArrs := InitExtsArr(profile.ProcessExtensions, profile.RejectExtensions, profile.BypassExtensions)
+	profileExtArrs.Store(profile, extArrs)
+	return extArrs
+}
@@ -0,0 +1,94 @@
+package formdata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// ErrTooManyParts is returned when the form has more parts than the limit
+var ErrTooManyParts = errors.New("formdata: the form has too many parts")
+
+// Part is a part of a multipart/form-data body, the files are the parts which have a file name
+type Part struct {
+	Header   textproto.MIMEHeader
+	FormName string
+	FileName string // empty for the form fields which aren't files
+	Data     []byte
+}
+
+// IsFile reports whether the part is an uploaded file
+func (p *Part) IsFile() bool {
+	return p.FileName != ""
+}
+
+// Boundary returns the boundary of a multipart/form-data Content-Type, false if the Content-Type is another one
+func Boundary(contentType string) (string, bool) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.EqualFold(mediaType, "multipart/form-data") || params["boundary"] == "" {
+		return "", false
+	}
+	return params["boundary"], true
+}
+
+// Parse splits the body into its parts as they were sent, their Content-Transfer-Encoding isn't decoded so a
+// rebuilt body keeps them. A max parts of zero is no limit
+func Parse(body []byte, boundary string, maxParts int) ([]*Part, error) {
+	return ParseFrom(bytes.NewReader(body), boundary, maxParts)
+}
+
+// ParseFrom is Parse for a body which is streamed from r, ex: a spooled body
+func ParseFrom(r io.Reader, boundary string, maxParts int) ([]*Part, error) {
+	mr := multipart.NewReader(r, boundary)
+	var parts []*Part
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if maxParts > 0 && len(parts) == maxParts {
+			return nil, fmt.Errorf("%w, the limit is %d", ErrTooManyParts, maxParts)
+		}
+		data, err := io.ReadAll(p)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, &Part{
+			Header:   p.Header,
+			FormName: p.FormName(),
+			FileName: p.FileName(),
+			Data:     data,
+		})
+	}
+}
+
+// Build writes the parts into a multipart/form-data body with the boundary, so the Content-Type of the original
+// body still applies to it
+func Build(parts []*Part, boundary string) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return nil, err
+	}
+	for _, p := range parts {
+		w, err := mw.CreatePart(p.Header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(p.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,70 @@
+package formdata
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"testing"
+)
+
+func form(t *testing.T) ([]byte, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("title", "the report")
+	w, _ := mw.CreateFormFile("attachment", "eicar.com")
+	w.Write([]byte("malicious"))
+	w, _ = mw.CreateFormFile("attachment", "report.pdf")
+	w.Write([]byte("%PDF-1.7"))
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), mw.FormDataContentType()
+}
+
+func TestBoundary(t *testing.T) {
+	_, contentType := form(t)
+	if _, isForm := Boundary(contentType); !isForm {
+		t.Fatalf("%s should have a boundary", contentType)
+	}
+	for _, contentType := range []string{"application/json", "multipart/form-data", "multipart/mixed; boundary=b"} {
+		if _, isForm := Boundary(contentType); isForm {
+			t.Fatalf("%s shouldn't be a form", contentType)
+		}
+	}
+}
+
+func TestParseAndBuild(t *testing.T) {
+	body, contentType := form(t)
+	boundary, _ := Boundary(contentType)
+	parts, err := Parse(body, boundary, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 3 || parts[0].IsFile() || parts[1].FileName != "eicar.com" || string(parts[2].Data) != "%PDF-1.7" {
+		t.Fatalf("unexpected parts %+v", parts)
+	}
+
+	rebuilt, err := Build([]*Part{parts[0], parts[2]}, boundary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := multipart.NewReader(bytes.NewReader(rebuilt), boundary).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Value["title"][0] != "the report" || len(req.File["attachment"]) != 1 ||
+		req.File["attachment"][0].Filename != "report.pdf" {
+		t.Fatalf("the rebuilt form should keep the clean parts only, got %+v %+v", req.Value, req.File)
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	body, contentType := form(t)
+	boundary, _ := Boundary(contentType)
+	if _, err := Parse(body, boundary, 2); !errors.Is(err, ErrTooManyParts) {
+		t.Fatalf("expected ErrTooManyParts, got %v", err)
+	}
+	if _, err := Parse([]byte("not a form"), boundary, 0); err == nil {
+		t.Fatal("a body without the boundary should fail")
+	}
+}
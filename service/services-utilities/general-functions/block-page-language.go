@@ -0,0 +1,109 @@
+package general_functions
+
+import (
+	"icapeg/logging"
+	"icapeg/readValues"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	defaultLanguagesMu sync.RWMutex
+	defaultLanguages   = make(map[string]string)
+)
+
+// InitBlockPageLanguage reads the optional [<service>.block_page] section which has the language
+// of the block page if the Accept-Language of the HTTP client has no available language, and the
+// HTML and JSON templates of the block pages of the service
+func InitBlockPageLanguage(serviceName string) {
+	if !readValues.IsSecExists(serviceName + ".block_page") {
+		return
+	}
+	logging.Logger.Debug("loading " + serviceName + " block page configurations")
+	initBlockPageTemplates(serviceName)
+	defaultLanguagesMu.Lock()
+	defer defaultLanguagesMu.Unlock()
+	defaultLanguages[serviceName] = strings.ToLower(readValues.ReadValuesString(serviceName + ".block_page.default_language"))
+}
+
+// LocalizedPagePath returns the path of the template of the language, the template of a language
+// is next to the original one with the language before the extension (block-page.html -> block-page.ar.html),
+// the original path is returned if the language is empty or its template doesn't exist
+func LocalizedPagePath(path, language string) string {
+	if language == "" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	localized := strings.TrimSuffix(path, ext) + "." + language + ext
+	if _, err := os.Stat(localized); err != nil {
+		return path
+	}
+	return localized
+}
+
+// blockPageLanguage returns the first language of the Accept-Language header which has a template,
+// otherwise it returns the default language of the service
+func blockPageLanguage(path, serviceName, acceptLanguage string) string {
+	for _, language := range parseAcceptLanguage(acceptLanguage) {
+		if LocalizedPagePath(path, language) != path {
+			return language
+		}
+		if dash := strings.Index(language, "-"); dash != -1 && LocalizedPagePath(path, language[:dash]) != path {
+			return language[:dash]
+		}
+	}
+	defaultLanguagesMu.RLock()
+	defer defaultLanguagesMu.RUnlock()
+	return defaultLanguages[serviceName]
+}
+
+// parseAcceptLanguage returns the languages of the Accept-Language header ordered by their quality
+func parseAcceptLanguage(acceptLanguage string) []string {
+	type weighted struct {
+		language string
+		quality  float64
+	}
+	var languages []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		language := strings.ToLower(strings.TrimSpace(fields[0]))
+		if language == "" || language == "*" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			languages = append(languages, weighted{language, quality})
+		}
+	}
+	sort.SliceStable(languages, func(i, j int) bool { return languages[i].quality > languages[j].quality })
+	result := make([]string, len(languages))
+	for i, l := range languages {
+		result[i] = l.language
+	}
+	return result
+}
+
+// acceptLanguage returns the Accept-Language header of the encapsulated HTTP request
+func (f *GeneralFunc) acceptLanguage() string {
+	return f.requestHeader("Accept-Language")
+}
+
+// requestHeader returns a header of the encapsulated HTTP request
+func (f *GeneralFunc) requestHeader(key string) string {
+	if f.httpMsg == nil || f.httpMsg.Request == nil || f.httpMsg.Request.Header == nil {
+		return ""
+	}
+	return f.httpMsg.Request.Header.Get(key)
+}
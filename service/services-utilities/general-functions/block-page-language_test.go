@@ -0,0 +1,33 @@
+package general_functions
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	got := parseAcceptLanguage("fr-CH, fr;q=0.9, en;q=0.8, de;q=0, *;q=0.5")
+	if want := []string{"fr-ch", "fr", "en"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestBlockPageLanguage(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "block-page.html")
+	for _, name := range []string{"block-page.html", "block-page.pt.html", "block-page.en.html"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("{{.Language}}"), 0644)
+	}
+	defaultLanguages["test"] = "en"
+	if got := blockPageLanguage(path, "test", "pt-BR, ar;q=0.9"); got != "pt" {
+		t.Fatalf("pt-br should fall back to pt, got %q", got)
+	}
+	if got := blockPageLanguage(path, "test", "ar"); got != "en" {
+		t.Fatalf("the default language should be used, got %q", got)
+	}
+	if got := LocalizedPagePath(path, "ar"); got != path {
+		t.Fatalf("a missing template should fall back to the original one, got %q", got)
+	}
+}
@@ -0,0 +1,134 @@
+package general_functions
+
+import (
+	"bytes"
+	"encoding/json"
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"mime"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// the Content-Type of the JSON block pages
+const jsonContentType = "application/json"
+
+// the templates of the [<service>.block_page] sections
+type blockPageTemplates struct {
+	html string // replaces the block page template of the vendor and the one of block-page.html, "" = not replaced
+	json string // the text/template of the JSON block pages, "" = the built-in document
+}
+
+var (
+	blockTemplatesMu sync.RWMutex
+	blockTemplates   = make(map[string]blockPageTemplates)
+)
+
+// the funcs of the JSON templates, {{json .FileName}} is the value as a JSON string so it can't break the document
+var jsonTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// blockPageDocument is the built-in JSON block page
+type blockPageDocument struct {
+	Blocked      bool   `json:"blocked"`
+	Reason       string `json:"reason"`
+	ServiceName  string `json:"service_name"`
+	RequestedURL string `json:"requested_url"`
+	FileName     string `json:"file_name,omitempty"`
+	ThreatName   string `json:"threat_name,omitempty"`
+	IdentifierId string `json:"identifier_id"`
+	Size         string `json:"size"`
+}
+
+// initBlockPageTemplates reads the html_template and json_template keys of the [<service>.block_page] section
+func initBlockPageTemplates(serviceName string) {
+	section := serviceName + ".block_page"
+	var templates blockPageTemplates
+	if readValues.IsSecExists(section + ".html_template") {
+		templates.html = readValues.ReadValuesString(section + ".html_template")
+	}
+	if readValues.IsSecExists(section + ".json_template") {
+		templates.json = readValues.ReadValuesString(section + ".json_template")
+	}
+	blockTemplatesMu.Lock()
+	defer blockTemplatesMu.Unlock()
+	blockTemplates[serviceName] = templates
+}
+
+// BlockPageTemplate returns the HTML template of the block pages of the service, path if the block_page
+// section of the service doesn't replace it
+func BlockPageTemplate(path, serviceName string) string {
+	blockTemplatesMu.RLock()
+	defer blockTemplatesMu.RUnlock()
+	if templates := blockTemplates[serviceName]; templates.html != "" {
+		return templates.html
+	}
+	return path
+}
+
+// WantsJSON reports whether the Accept header of the HTTP client prefers application/json (or a +json type) to
+// HTML, */* and an empty header get the HTML block page
+func WantsJSON(accept string) bool {
+	jsonQuality, htmlQuality := 0.0, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			quality = q
+		}
+		switch {
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			if quality > jsonQuality {
+				jsonQuality = quality
+			}
+		case mediaType == utils.HTMLContentType || mediaType == "text/*" || mediaType == "*/*":
+			if quality > htmlQuality {
+				htmlQuality = quality
+			}
+		}
+	}
+	return jsonQuality > htmlQuality
+}
+
+// JSONBlockPage renders the JSON block page of the service with its json_template, or the built-in document
+// if the service has no JSON template or its template fails
+func JSONBlockPage(page *ErrorPage) *bytes.Buffer {
+	blockTemplatesMu.RLock()
+	path := blockTemplates[page.ServiceName].json
+	blockTemplatesMu.RUnlock()
+	jsonPage := &bytes.Buffer{}
+	if path != "" {
+		tmpl, err := template.New(filepath.Base(path)).Funcs(jsonTemplateFuncs).ParseFiles(path)
+		if err == nil {
+			err = tmpl.Execute(jsonPage, page)
+		}
+		if err == nil {
+			return jsonPage
+		}
+		logging.Logger.Error("the JSON block page template " + path + " of " + page.ServiceName +
+			" failed and was replaced with the default document: " + err.Error())
+		jsonPage.Reset()
+	}
+	json.NewEncoder(jsonPage).Encode(&blockPageDocument{
+		Blocked:      true,
+		Reason:       page.Reason,
+		ServiceName:  page.ServiceName,
+		RequestedURL: page.RequestedURL,
+		FileName:     page.FileName,
+		ThreatName:   page.ThreatName,
+		IdentifierId: page.IdentifierId,
+		Size:         page.Size,
+	})
+	return jsonPage
+}
@@ -0,0 +1,42 @@
+package general_functions
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWantsJSON(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":                                  false,
+		"*/*":                               false,
+		"application/json":                  true,
+		"application/problem+json":          true,
+		"text/html,application/json;q=0.9":  false,
+		"text/html;q=0.5, application/json": true,
+		"application/json;q=0.5, */*":       false,
+	} {
+		if got := WantsJSON(accept); got != want {
+			t.Errorf("WantsJSON(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
+
+func TestJSONBlockPage(t *testing.T) {
+	page := &ErrorPage{Reason: "fileIsNotSafe", ServiceName: "jsontest", FileName: `a"b.exe`, ThreatName: "Eicar"}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(JSONBlockPage(page).Bytes(), &doc); err != nil || doc["threat_name"] != "Eicar" ||
+		doc["blocked"] != true {
+		t.Fatalf("unexpected built-in document %v %v", doc, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "block.json")
+	os.WriteFile(path, []byte(`{"file": {{json .FileName}}, "service": {{json .ServiceName}}}`), 0644)
+	blockTemplates["jsontest"] = blockPageTemplates{json: path}
+	defer delete(blockTemplates, "jsontest")
+	doc = nil
+	if err := json.Unmarshal(JSONBlockPage(page).Bytes(), &doc); err != nil || doc["file"] != `a"b.exe` {
+		t.Fatalf("the template should escape the values, got %v %v", doc, err)
+	}
+}
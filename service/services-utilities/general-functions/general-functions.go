@@ -5,12 +5,16 @@ import (
 	"compress/gzip"
 	"encoding/json"
 	"html/template"
+	"icapeg/config"
 	utils "icapeg/consts"
 	http_message "icapeg/http-message"
+	"icapeg/icap"
 	"icapeg/logging"
 	"icapeg/readValues"
 	services_utilities "icapeg/service/services-utilities"
 	"icapeg/service/services-utilities/ContentTypes"
+	"icapeg/service/services-utilities/sniff"
+	"icapeg/service/services-utilities/spool"
 	"image"
 	"io"
 	"io/ioutil"
@@ -32,6 +36,9 @@ type (
 		ExceptionPage string `json:"exception_page"`
 		Size          string `json:"size"`
 		XICAPMetadata string `json:"X-ICAP-Metadata"`
+		Language      string `json:"language"`
+		FileName      string `json:"file_name"`
+		ThreatName    string `json:"threat_name"`
 	}
 )
 
@@ -39,6 +46,11 @@ type (
 type GeneralFunc struct {
 	httpMsg       *http_message.HttpMsg
 	xICAPMetadata string
+	threatName    string      // the threat of the block pages, set by the vendor which detected it
+	pageType      string      // the Content-Type of the last block page, "" = HTML
+	fileType      *sniff.Type // the type which GetMimeExtension detected from the magic bytes
+	fileName      string
+	mismatch      bool // the file type mismatch was logged
 }
 
 // NewGeneralFunc is used to create a new instance from the struct
@@ -50,6 +62,11 @@ func NewGeneralFunc(httpMsg *http_message.HttpMsg, xICAPMetadata string) *Genera
 	return GeneralFunc
 }
 
+// SetThreatName sets the name of the threat which the block pages generated after it show as {{.ThreatName}}
+func (f *GeneralFunc) SetThreatName(threatName string) {
+	f.threatName = threatName
+}
+
 // CopyingFileToTheBuffer is a func which used for extracting a file from the body of the http message
 func (f *GeneralFunc) CopyingFileToTheBuffer(methodName string) (*bytes.Buffer, ContentTypes.ContentType, error) {
 	logging.Logger.Info(utils.PrepareLogMsg(f.xICAPMetadata, "extracting the body of HTTP message"))
@@ -74,62 +91,184 @@ func (f *GeneralFunc) CopyingFileToTheBuffer(methodName string) (*bytes.Buffer,
 func (f *GeneralFunc) CheckTheExtension(fileExtension string, extArrs []services_utilities.Extension, processExts,
 	rejectExts, bypassExts []string, return400IfFileExtRejected, isGzip bool, serviceName, methodName, identifier,
 	requestURI string, reqContentType ContentTypes.ContentType, file *bytes.Buffer, BlockPagePath string, fileSize string) (bool, int, interface{}) {
+	switch f.ExtensionAction(fileExtension, extArrs, processExts, rejectExts, bypassExts, serviceName) {
+	case utils.RejectExts:
+		if return400IfFileExtRejected {
+			return false, utils.BadRequestStatusCodeStr, nil
+		}
+		if methodName == "RESPMOD" {
+			errPage := f.GenHtmlPage(BlockPagePath, utils.ErrPageReasonFileRejected, serviceName, identifier, requestURI, fileSize, f.xICAPMetadata)
+			f.httpMsg.Response = f.ErrPageResp(http.StatusForbidden, errPage.Len())
+			f.httpMsg.Response.Body = icap.NewBody(errPage.Bytes())
+			return false, utils.OkStatusCodeStr, f.httpMsg.Response
+		} else {
+			htmlPage, req, err := f.ReqModErrPage(utils.ErrPageReasonFileRejected, serviceName, "-", fileSize)
+			if err != nil {
+				return false, utils.InternalServerErrStatusCodeStr, nil
+			}
+			reqContentType = &ContentTypes.RegularFile{
+				Buf:     file,
+				Encoded: false,
+			}
+			fileAfterPrep := f.PreparingFileAfterScanning(htmlPage.Bytes(), reqContentType, methodName)
+			req.Body = icap.NewBody(fileAfterPrep)
+			return false, utils.OkStatusCodeStr, req
+		}
+	case utils.BypassExts:
+		fileAfterPrep, httpMsg := f.IfICAPStatusIs204(methodName, utils.NoModificationStatusCodeStr,
+			file, isGzip, reqContentType, f.httpMsg)
+		if fileAfterPrep == nil && httpMsg == nil {
+			return false, utils.InternalServerErrStatusCodeStr, nil
+		}
+
+		//returning the http message and the ICAP status code
+		switch msg := httpMsg.(type) {
+		case *http.Request:
+			msg.Body = icap.NewBody(fileAfterPrep)
+			return false, utils.NoModificationStatusCodeStr, msg
+		case *http.Response:
+			msg.Body = icap.NewBody(fileAfterPrep)
+			return false, utils.NoModificationStatusCodeStr, msg
+		}
+		return false, utils.NoModificationStatusCodeStr, nil
+	}
+	return true, 0, nil
+}
+
+// ExtensionAction returns the action of the file extension (utils.ProcessExts, utils.RejectExts or
+// utils.BypassExts) upon the extension arrays of the service in their priority order, it's process if the
+// extension isn't in any of them. The MIME type rules of the service apply to the type which GetMimeExtension
+// detected from the magic bytes of the file
+func (f *GeneralFunc) ExtensionAction(fileExtension string, extArrs []services_utilities.Extension, processExts,
+	rejectExts, bypassExts []string, serviceName string) string {
 	logging.Logger.Info(utils.PrepareLogMsg(f.xICAPMetadata,
 		"checking the extension (reject or bypass or process))"))
+	action := utils.ProcessExts
 	for i := 0; i < 3; i++ {
 		if extArrs[i].Name == utils.ProcessExts {
 			if f.ifFileExtIsX(fileExtension, processExts) {
 				logging.Logger.Debug(utils.PrepareLogMsg(f.xICAPMetadata, "extension is process"))
+				action = utils.ProcessExts
 				break
 			}
 		} else if extArrs[i].Name == utils.RejectExts {
 			if f.ifFileExtIsX(fileExtension, rejectExts) {
 				logging.Logger.Debug(utils.PrepareLogMsg(f.xICAPMetadata, "extension is reject"))
-				if return400IfFileExtRejected {
-					return false, utils.BadRequestStatusCodeStr, nil
-				}
-				if methodName == "RESPMOD" {
-					errPage := f.GenHtmlPage(BlockPagePath, utils.ErrPageReasonFileRejected, serviceName, identifier, requestURI, fileSize, f.xICAPMetadata)
-					f.httpMsg.Response = f.ErrPageResp(http.StatusForbidden, errPage.Len())
-					f.httpMsg.Response.Body = io.NopCloser(bytes.NewBuffer(errPage.Bytes()))
-					return false, utils.OkStatusCodeStr, f.httpMsg.Response
-				} else {
-					htmlPage, req, err := f.ReqModErrPage(utils.ErrPageReasonFileRejected, serviceName, "-", fileSize)
-					if err != nil {
-						return false, utils.InternalServerErrStatusCodeStr, nil
-					}
-					reqContentType = &ContentTypes.RegularFile{
-						Buf:     file,
-						Encoded: false,
-					}
-					fileAfterPrep := f.PreparingFileAfterScanning(htmlPage.Bytes(), reqContentType, methodName)
-					req.Body = io.NopCloser(bytes.NewBuffer(fileAfterPrep))
-					return false, utils.OkStatusCodeStr, req
-				}
+				action = utils.RejectExts
+				break
 			}
 		} else if extArrs[i].Name == utils.BypassExts {
 			if f.ifFileExtIsX(fileExtension, bypassExts) {
 				logging.Logger.Debug(utils.PrepareLogMsg(f.xICAPMetadata, "extension is bypass"))
-				fileAfterPrep, httpMsg := f.IfICAPStatusIs204(methodName, utils.NoModificationStatusCodeStr,
-					file, isGzip, reqContentType, f.httpMsg)
-				if fileAfterPrep == nil && httpMsg == nil {
-					return false, utils.InternalServerErrStatusCodeStr, nil
-				}
-
-				//returning the http message and the ICAP status code
-				switch msg := httpMsg.(type) {
-				case *http.Request:
-					msg.Body = io.NopCloser(bytes.NewBuffer(fileAfterPrep))
-					return false, utils.NoModificationStatusCodeStr, msg
-				case *http.Response:
-					msg.Body = io.NopCloser(bytes.NewBuffer(fileAfterPrep))
-					return false, utils.NoModificationStatusCodeStr, msg
-				}
-				return false, utils.NoModificationStatusCodeStr, nil
+				action = utils.BypassExts
+				break
 			}
 		}
 	}
-	return true, 0, nil
+	return f.fileTypeAction(action, serviceName)
+}
+
+// fileTypeAction applies the MIME type rules of the service to the action of the extension, a MIME type rule
+// replaces it since the magic bytes can't be renamed. With require_type_match a file is bypassed only if both
+// rules bypass it and its extension matches its magic bytes, and it's rejected if one of them rejects it
+func (f *GeneralFunc) fileTypeAction(extAction, serviceName string) string {
+	serviceInstance := config.App().ServicesInstances[serviceName]
+	if f.fileType == nil || serviceInstance == nil || serviceInstance.FileTypes == nil {
+		return extAction
+	}
+	rules := serviceInstance.FileTypes
+	// the most specific MIME type pattern wins, reject wins the ties and bypass loses them
+	mimeAction, best := "", 0
+	for _, rule := range []struct {
+		action    string
+		mimeTypes []string
+	}{{utils.RejectExts, rules.RejectMimeTypes}, {utils.ProcessExts, rules.ProcessMimeTypes},
+		{utils.BypassExts, rules.BypassMimeTypes}} {
+		if specificity := sniff.MatchMIME(f.fileType.MIME, rule.mimeTypes); specificity > best {
+			mimeAction, best = rule.action, specificity
+		}
+	}
+	agrees := f.fileType.Agrees(f.fileName)
+	if !agrees && !f.mismatch {
+		f.mismatch = true
+		logging.Logger.Warn(utils.PrepareEventLogMsg(f.xICAPMetadata, utils.EventFileTypeMismatch, map[string]interface{}{
+			"service":   serviceName,
+			"file_name": f.fileName,
+			"mime_type": f.fileType.MIME,
+		}))
+	}
+	logging.Logger.Debug(utils.PrepareLogMsg(f.xICAPMetadata, "the detected MIME type is "+f.fileType.MIME+
+		", its action is "+mimeAction))
+
+	if !rules.RequireTypeMatch {
+		if mimeAction != "" {
+			return mimeAction
+		}
+		return extAction
+	}
+	switch {
+	case extAction == utils.RejectExts || mimeAction == utils.RejectExts:
+		return utils.RejectExts
+	case extAction == utils.BypassExts && mimeAction == utils.BypassExts && agrees:
+		return utils.BypassExts
+	}
+	return utils.ProcessExts
+}
+
+// SpooledBody returns the spooled body of the HTTP response in RESPMOD if it wasn't read yet, the services which
+// stream the file to their vendor read it from the spool instead of copying it in memory with
+// CopyingFileToTheBuffer
+func (f *GeneralFunc) SpooledBody(methodName string) (*spool.Body, bool) {
+	if methodName != utils.ICAPModeResp || f.httpMsg.Response == nil {
+		return nil, false
+	}
+	return spool.Of(f.httpMsg.Response.Body)
+}
+
+// PreviewDecision decides from the preview of the body (RFC 3507 section 4.5) whether the service needs the rest of
+// it, the file type is sniffed from the preview bytes, the Content-Type and the file name. The bypassed files are
+// answered with 204 and the rejected files with their block page before the ICAP client sends the rest, it returns
+// utils.Continue for the files which must be processed and the multipart forms whose files the preview may cut
+func (f *GeneralFunc) PreviewDecision(extArrs []services_utilities.Extension, processExts, rejectExts,
+	bypassExts []string, return400IfFileExtRejected bool, serviceName, methodName, BlockPagePath string) (int, interface{}) {
+	var body io.Reader
+	var contentType string
+	if methodName == utils.ICAPModeReq {
+		body, contentType = f.httpMsg.Request.Body, f.httpMsg.Request.Header.Get("Content-Type")
+		if strings.HasPrefix(contentType, "multipart/") {
+			return utils.Continue, nil
+		}
+	} else if f.httpMsg.Response != nil {
+		body, contentType = f.httpMsg.Response.Body, f.httpMsg.Response.Header.Get("Content-Type")
+	}
+	preview, isSpooled := spool.Of(body)
+	if !isSpooled {
+		return utils.Continue, nil
+	}
+	fileExtension := f.GetMimeExtension(preview.Head(spool.SniffLen), contentType, f.GetFileName())
+	switch f.ExtensionAction(fileExtension, extArrs, processExts, rejectExts, bypassExts, serviceName) {
+	case utils.BypassExts:
+		logging.Logger.Info(utils.PrepareLogMsg(f.xICAPMetadata,
+			"the "+fileExtension+" file is bypassed upon its preview"))
+		return utils.NoModificationStatusCodeStr, nil
+	case utils.RejectExts:
+		logging.Logger.Info(utils.PrepareLogMsg(f.xICAPMetadata,
+			"the "+fileExtension+" file is rejected upon its preview"))
+		_, icapStatus, httpMsg := f.CheckTheExtension(fileExtension, extArrs, processExts, rejectExts, bypassExts,
+			return400IfFileExtRejected, false, serviceName, methodName, "-", f.httpMsg.Request.RequestURI, nil,
+			&bytes.Buffer{}, BlockPagePath, "-")
+		return icapStatus, httpMsg
+	}
+	return utils.Continue, nil
+}
+
+// ReturningHttpMessageWithSpool is a func used for returning the HTTP response of RESPMOD with its original
+// spooled body, the ICAP response writer streams the body from the spool
+func (f *GeneralFunc) ReturningHttpMessageWithSpool(body *spool.Body) *http.Response {
+	logging.Logger.Info(utils.PrepareLogMsg(f.xICAPMetadata,
+		"returning the HTTP message with its spooled body after processing by the service"))
+	f.httpMsg.Response.Body = body.Open()
+	return f.httpMsg.Response
 }
 
 // copyingFileToTheBufferResp is a utility function for CopyingFileToTheBuffer func
@@ -201,15 +340,22 @@ func (f *GeneralFunc) ReqModErrPage(reason, serviceName, IdentifierId string, fi
 	host := readValues.ReadValuesString("app.web_server_host")
 	endpoint := readValues.ReadValuesString("app.web_server_endpoint")
 	url := host + endpoint
+	language := blockPageLanguage(BlockPageTemplate(utils.BlockPagePath, serviceName), serviceName, f.acceptLanguage())
 	f.httpMsg.Request.URL.Scheme = ""
 	f.httpMsg.Request.URL.Opaque = url
 	f.httpMsg.Request.URL.Path = ""
 	f.httpMsg.Request.URL.Host = host
+	// the Accept header is kept, so the web server answers with the JSON block page if the client prefers it
+	accept := f.httpMsg.Request.Header.Get("Accept")
+	fileName := f.GetFileName()
 	for key, _ := range f.httpMsg.Request.Header {
 		f.httpMsg.Request.Header.Del(key)
 	}
 	reqUri := f.httpMsg.Request.RequestURI
 	f.httpMsg.Request.Header.Set("Host", host)
+	if accept != "" {
+		f.httpMsg.Request.Header.Set("Accept", accept)
+	}
 	f.httpMsg.Request.Method = http.MethodGet
 	f.httpMsg.Request.RequestURI = url
 	f.httpMsg.Request.Body = io.NopCloser(strings.NewReader(""))
@@ -219,6 +365,9 @@ func (f *GeneralFunc) ReqModErrPage(reason, serviceName, IdentifierId string, fi
 		RequestedURL: reqUri,
 		IdentifierId: IdentifierId,
 		Size:         fileSize,
+		Language:     language,
+		FileName:     fileName,
+		ThreatName:   f.threatName,
 	}
 	req := &http.Request{
 		URL:        f.httpMsg.Request.URL,
@@ -243,7 +392,7 @@ func (f *GeneralFunc) ReqModErrPage(reason, serviceName, IdentifierId string, fi
 func (f *GeneralFunc) IfMaxFileSizeExc(returnOrigIfMaxSizeExc bool, serviceName, methodName string,
 	file *bytes.Buffer, maxFileSize int, BlockPagePath string, fileSize string) (int, *bytes.Buffer, interface{}) {
 	logging.Logger.Debug(utils.PrepareLogMsg(f.xICAPMetadata, "HTTP message body size exceeds the limit"))
-	logging.Logger.Debug(utils.PrepareLogMsg(f.xICAPMetadata, "HTTP message body size: "+strconv.Itoa(file.Len())+
+	logging.Logger.Debug(utils.PrepareLogMsg(f.xICAPMetadata, "HTTP message body size: "+fileSize+
 		" MB, the allowed max file size: "+strconv.Itoa(maxFileSize)+" MB")) //check if returning the original file option is enabled in this case or not
 	//if yes, return no modification status code
 	//if not, return an error page
@@ -306,36 +455,56 @@ func (f *GeneralFunc) CompressFileGzip(scannedFile []byte) ([]byte, error) {
 	return newBuf.Bytes(), nil
 }
 
-// ErrPageResp is a func used for creating http response for returning an error page
+// ErrPageResp is a func used for creating http response for returning an error page, its Content-Type is
+// the one of the last page of GenHtmlPage
 func (f *GeneralFunc) ErrPageResp(status int, pageContentLength int) *http.Response {
 	logging.Logger.Info(utils.PrepareLogMsg(f.xICAPMetadata, "preparing http response with the block page"))
+	contentType := utils.HTMLContentType
+	if f.pageType != "" {
+		contentType = f.pageType
+	}
 	return &http.Response{
 		StatusCode: status,
 		Status:     strconv.Itoa(status) + " " + http.StatusText(status),
 		Header: http.Header{
-			utils.ContentType:   []string{utils.HTMLContentType},
+			utils.ContentType:   []string{contentType},
 			utils.ContentLength: []string{strconv.Itoa(pageContentLength)},
 		},
 	}
 }
 
-// GenHtmlPage is a func used for generating an error page
+// GenHtmlPage is a func used for generating an error page, the html_template of the service replaces path and
+// the JSON block page is generated instead if the Accept header of the HTTP client prefers JSON
 func (f *GeneralFunc) GenHtmlPage(path, reason, serviceName, identifierId, reqUrl string, fileSize string, xICAPMetadata string) *bytes.Buffer {
 	logging.Logger.Info(utils.PrepareLogMsg(f.xICAPMetadata, "preparing a block page"))
-	htmlTmpl, err := template.ParseFiles(path)
-	if err != nil {
-		logging.Logger.Error("exception page path not exist and replaced with default page")
-		htmlTmpl, _ = template.ParseFiles(utils.BlockPagePath)
-	}
-	htmlErrPage := &bytes.Buffer{}
-	htmlTmpl.Execute(htmlErrPage, &ErrorPage{
+	path = BlockPageTemplate(path, serviceName)
+	language := blockPageLanguage(path, serviceName, f.acceptLanguage())
+	page := &ErrorPage{
 		Reason:        reason,
 		ServiceName:   serviceName,
 		RequestedURL:  reqUrl,
 		IdentifierId:  identifierId,
 		Size:          fileSize,
 		XICAPMetadata: xICAPMetadata,
-	})
+		Language:      language,
+		ThreatName:    f.threatName,
+	}
+	if f.httpMsg != nil {
+		page.FileName = f.GetFileName()
+	}
+	if WantsJSON(f.requestHeader("Accept")) {
+		f.pageType = jsonContentType
+		return JSONBlockPage(page)
+	}
+	f.pageType = ""
+	htmlTmpl, err := template.ParseFiles(LocalizedPagePath(path, language))
+	if err != nil {
+		logging.Logger.Error(utils.PrepareLogMsg(f.xICAPMetadata,
+			"exception page path not exist and replaced with default page"))
+		htmlTmpl, _ = template.ParseFiles(utils.BlockPagePath)
+	}
+	htmlErrPage := &bytes.Buffer{}
+	htmlTmpl.Execute(htmlErrPage, page)
 	return htmlErrPage
 }
 
@@ -382,25 +551,87 @@ func (f *GeneralFunc) IfStatusIs204WithFile(methodName string, status int, file
 }
 
 // ReturningHttpMessageWithFile function to return the suitable http message (http request, http response)
+// the ICAP response writer sets the Content-Length from the file and drops the headers which don't describe it
 func (f *GeneralFunc) ReturningHttpMessageWithFile(methodName string, file []byte) interface{} {
 	logging.Logger.Info(utils.PrepareLogMsg(f.xICAPMetadata,
 		"returning the HTTP message after processing by the service"))
 	switch methodName {
 	case utils.ICAPModeReq:
-		f.httpMsg.Request.Header.Set(utils.ContentLength, strconv.Itoa(len(string(file))))
-		f.httpMsg.Request.Body = io.NopCloser(bytes.NewBuffer(file))
+		f.httpMsg.Request.Body = icap.NewBody(file)
 		if f.httpMsg.Request.URL.Scheme == "" {
 			f.httpMsg.Request.URL.Opaque = f.httpMsg.Request.URL.Host
 		}
 		return f.httpMsg.Request
 	case utils.ICAPModeResp:
-		f.httpMsg.Response.Header.Set(utils.ContentLength, strconv.Itoa(len(string(file))))
-		f.httpMsg.Response.Body = io.NopCloser(bytes.NewBuffer(file))
+		f.httpMsg.Response.Body = icap.NewBody(file)
 		return f.httpMsg.Response
 	}
 	return nil
 }
 
+// RewriteRequestBody is a func used for returning the HTTP request of REQMOD with a body which the service
+// rewrote (ex: a DLP redaction or stripped form fields), the ICAP response writer sets the Content-Length of
+// the request from the new body and drops its Transfer-Encoding. The Content-MD5 of the original body is
+// removed, and the Content-Type is replaced if contentType isn't empty (ex: a new multipart boundary)
+func (f *GeneralFunc) RewriteRequestBody(body []byte, contentType string) *http.Request {
+	logging.Logger.Info(utils.PrepareLogMsg(f.xICAPMetadata,
+		"returning the HTTP request with the body which was rewritten by the service"))
+	req := f.httpMsg.Request
+	req.Body = icap.NewBody(body)
+	req.ContentLength = int64(len(body))
+	req.TransferEncoding = nil
+	req.Header.Del("Content-MD5")
+	if contentType != "" {
+		req.Header.Set(utils.ContentType, contentType)
+	}
+	if req.URL.Scheme == "" {
+		req.URL.Opaque = req.URL.Host
+	}
+	return req
+}
+
+// ReturningHttpMessageWithStream is a func used for returning the HTTP message with a body which is still
+// being downloaded from the vendor (ex: the rebuilt file of a CDR vendor), the ICAP server streams the body
+// to the ICAP client as it arrives instead of buffering it, contentLength is -1 if the vendor didn't send it
+func (f *GeneralFunc) ReturningHttpMessageWithStream(methodName string, body io.ReadCloser, contentLength int64, contentType string) interface{} {
+	logging.Logger.Info(utils.PrepareLogMsg(f.xICAPMetadata,
+		"returning the HTTP message with the body which is streamed from the service"))
+	var header http.Header
+	switch methodName {
+	case utils.ICAPModeReq:
+		f.httpMsg.Request.Body = body
+		f.httpMsg.Request.ContentLength = contentLength
+		header = f.httpMsg.Request.Header
+	case utils.ICAPModeResp:
+		f.httpMsg.Response.Body = body
+		f.httpMsg.Response.ContentLength = contentLength
+		header = f.httpMsg.Response.Header
+	default:
+		return nil
+	}
+	// the original length and encoding don't describe the rebuilt file, the ICAP client frames the body
+	// with chunked encoding if the length is unknown
+	header.Del("Content-Encoding")
+	header.Del("Transfer-Encoding")
+	header.Del("Content-MD5")
+	header.Del("ETag")
+	if contentLength >= 0 {
+		header.Set(utils.ContentLength, strconv.FormatInt(contentLength, 10))
+	} else {
+		header.Del(utils.ContentLength)
+	}
+	if contentType != "" {
+		header.Set(utils.ContentType, contentType)
+	}
+	if methodName == utils.ICAPModeReq {
+		if f.httpMsg.Request.URL.Scheme == "" {
+			f.httpMsg.Request.URL.Opaque = f.httpMsg.Request.URL.Host
+		}
+		return f.httpMsg.Request
+	}
+	return f.httpMsg.Response
+}
+
 func (f *GeneralFunc) IfICAPStatusIs204(methodName string, status int, file *bytes.Buffer, isGzip bool,
 	reqContentType ContentTypes.ContentType, httpMessage interface{}) ([]byte,
 	interface{}) {
@@ -429,12 +660,10 @@ func (f *GeneralFunc) returningHttpMessage(methodName string, file []byte) inter
 	logging.Logger.Info(utils.PrepareLogMsg(f.xICAPMetadata, "returning the HTTP message after processing by the service"))
 	switch methodName {
 	case utils.ICAPModeReq:
-		f.httpMsg.Request.Header.Set(utils.ContentLength, strconv.Itoa(len(string(file))))
-		f.httpMsg.Request.Body = io.NopCloser(bytes.NewBuffer(file))
+		f.httpMsg.Request.Body = icap.NewBody(file)
 		return f.httpMsg.Request
 	case utils.ICAPModeResp:
-		f.httpMsg.Response.Header.Set(utils.ContentLength, strconv.Itoa(len(string(file))))
-		f.httpMsg.Response.Body = io.NopCloser(bytes.NewBuffer(file))
+		f.httpMsg.Response.Body = icap.NewBody(file)
 		return f.httpMsg.Response
 	}
 	return nil
@@ -459,6 +688,8 @@ func (f *GeneralFunc) InitSecure(VerifyServerCert bool) bool {
 func (f *GeneralFunc) GetMimeExtension(data []byte, contentType string, filename string) string {
 	logging.Logger.Info(utils.PrepareLogMsg(f.xICAPMetadata,
 		"getting the mime extension of the HTTP message body"))
+	fileType := sniff.Sniff(data, contentType)
+	f.fileType, f.fileName = &fileType, filename
 	kind, _ := filetype.Match(data)
 	exts := map[string]string{"application/xml": "xml", "application/html": "html", "text/html": "html", "text/json": "html", "application/json": "json", "text/plain": "txt"}
 	contentType = strings.Split(contentType, ";")[0]
@@ -497,7 +728,7 @@ func (f *GeneralFunc) LogHTTPMsgHeaders(methodName string) map[string]interface{
 					values += ", "
 				}
 			}
-			msgHeaders[key] = values
+			msgHeaders[key] = logging.RedactHeader(key, values)
 		}
 	} else {
 		for key, value := range f.httpMsg.Response.Header {
@@ -508,7 +739,7 @@ func (f *GeneralFunc) LogHTTPMsgHeaders(methodName string) map[string]interface{
 					values += ", "
 				}
 			}
-			msgHeaders[key] = values
+			msgHeaders[key] = logging.RedactHeader(key, values)
 		}
 	}
 	return msgHeaders
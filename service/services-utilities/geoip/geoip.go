@@ -0,0 +1,108 @@
+package geoip
+
+import (
+	"context"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// the time which resolving the host of the HTTP message may take
+const resolveTimeout = 2 * time.Second
+
+// record is the part of a GeoIP2/GeoLite2 country or city record which ICAPeg uses
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+var (
+	mu sync.RWMutex
+	db *maxminddb.Reader
+)
+
+// InitGeoIP reads the optional [app.geoip] section and opens the MaxMind database,
+// the GeoIP policies are ignored if the section doesn't exist
+func InitGeoIP() {
+	if !readValues.IsSecExists("app.geoip") || !readValues.ReadValuesBool("app.geoip.enabled") {
+		return
+	}
+	logging.Logger.Info("loading the GeoIP database")
+	if err := Reload(readValues.ReadValuesString("app.geoip.database")); err != nil {
+		logging.Logger.Error("couldn't open the GeoIP database, the GeoIP policies are ignored: " + err.Error())
+	}
+}
+
+// Reload opens the database and replaces the loaded one, the loaded one stays if the database can't be opened
+func Reload(path string) error {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if db != nil {
+		db.Close()
+	}
+	db = reader
+	return nil
+}
+
+// Enabled reports whether the GeoIP database is loaded
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return db != nil
+}
+
+// Country returns the ISO code of the country of the IP address, the registered country is used if the
+// database has no country for the address. It's empty if the country is unknown
+func Country(ip net.IP) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if db == nil || ip == nil {
+		return ""
+	}
+	var r record
+	if err := db.Lookup(ip, &r); err != nil {
+		return ""
+	}
+	if r.Country.ISOCode != "" {
+		return r.Country.ISOCode
+	}
+	return r.RegisteredCountry.ISOCode
+}
+
+// ServerIP returns the IP address of the server, serverIP is the address which the ICAP client sent (X-Server-IP)
+// and host is resolved if it's empty
+func ServerIP(serverIP, host string) net.IP {
+	if ip := net.ParseIP(strings.TrimSpace(serverIP)); ip != nil {
+		return ip
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if host == "" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return nil
+	}
+	return addrs[0].IP
+}
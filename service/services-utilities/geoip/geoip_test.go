@@ -0,0 +1,31 @@
+package geoip
+
+import (
+	"net"
+	"testing"
+)
+
+func TestServerIP(t *testing.T) {
+	tests := []struct {
+		serverIP string
+		host     string
+		expected string
+	}{
+		{"203.0.113.7", "example.com", "203.0.113.7"},
+		{"", "198.51.100.1:8080", "198.51.100.1"},
+		{"", "[2001:db8::1]:443", "2001:db8::1"},
+		{"not an ip", "192.0.2.10", "192.0.2.10"},
+		{"", "", "<nil>"},
+	}
+	for _, test := range tests {
+		if ip := ServerIP(test.serverIP, test.host); ip.String() != test.expected {
+			t.Errorf("ServerIP(%q, %q) = %v, expected %s", test.serverIP, test.host, ip, test.expected)
+		}
+	}
+}
+
+func TestCountryWithoutDatabase(t *testing.T) {
+	if country := Country(net.ParseIP("203.0.113.7")); country != "" {
+		t.Fatalf("expected no country without a database, got %q", country)
+	}
+}
@@ -0,0 +1,218 @@
+package health
+
+import (
+	"icapeg/config"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"icapeg/service/services-utilities/hotswap"
+	"icapeg/service/services-utilities/toggles"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the defaults of [app.health]
+const (
+	defaultProbeInterval = 10 * time.Second
+	defaultProbeTimeout  = 3 * time.Second
+)
+
+// the states of a backend
+const (
+	StatusUp       = "up"
+	StatusDown     = "down"
+	StatusUnprobed = "unprobed" // the vendor has no backend to probe, ex: echo
+	StatusDisabled = "disabled" // the service was disabled at runtime, it doesn't count for the readiness
+)
+
+// Probe checks that the backend of a service is reachable, ex: a clamd PING, it must return before the timeout
+type Probe func(serviceName string, timeout time.Duration) error
+
+// Backend is the state of the backend of a service as it was probed last
+type Backend struct {
+	Service     string     `json:"service"`
+	Vendor      string     `json:"vendor"`
+	Status      string     `json:"status"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastChecked *time.Time `json:"last_checked,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LatencyMs   int64      `json:"latency_ms"`
+}
+
+var (
+	mu          sync.Mutex
+	probes      = make(map[string]Probe)
+	backends    = make(map[string]*Backend)
+	interval    = defaultProbeInterval
+	timeout     = defaultProbeTimeout
+	icapService string
+	draining    int32
+	probeOnce   sync.Once
+)
+
+// Register makes the probe the health check of the backends of the vendor, the vendors register their probes in
+// their init funcs
+func Register(vendor string, probe Probe) {
+	mu.Lock()
+	defer mu.Unlock()
+	probes[strings.ToLower(vendor)] = probe
+}
+
+// Lookup returns the probe of the vendor, false if it registered none
+func Lookup(vendor string) (Probe, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	p, exists := probes[strings.ToLower(vendor)]
+	return p, exists
+}
+
+// InitHealth reads the optional [app.health] section, the backends of the services are probed every
+// probe_interval so /readyz tells whether they are reachable. Without the section /readyz only reports the
+// drain of the server
+func InitHealth() {
+	if !readValues.IsSecExists("app.health") || !readValues.ReadValuesBool("app.health.enabled") {
+		return
+	}
+	mu.Lock()
+	if readValues.IsSecExists("app.health.probe_interval") {
+		if i := readValues.ReadValuesDuration("app.health.probe_interval") * time.Second; i > 0 {
+			interval = i
+		}
+	}
+	if readValues.IsSecExists("app.health.probe_timeout") {
+		if t := readValues.ReadValuesDuration("app.health.probe_timeout") * time.Second; t > 0 {
+			timeout = t
+		}
+	}
+	if readValues.IsSecExists("app.health.icap_service") {
+		icapService = readValues.ReadValuesString("app.health.icap_service")
+	}
+	mu.Unlock()
+	ProbeAll()
+	probeOnce.Do(func() {
+		go func() {
+			for {
+				time.Sleep(probeInterval())
+				ProbeAll()
+			}
+		}()
+	})
+}
+
+func probeInterval() time.Duration {
+	mu.Lock()
+	defer mu.Unlock()
+	return interval
+}
+
+// ICAPService returns the reserved ICAP service whose OPTIONS requests are answered upon the readiness, "" if
+// there's none
+func ICAPService() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return icapService
+}
+
+// ProbeAll probes the backends of the configured services at once, the vendor which backs a service now is probed
+// if it was swapped at runtime
+func ProbeAll() {
+	services := config.App().ServicesInstances
+	var wg sync.WaitGroup
+	results := make(chan Backend, len(services))
+	for serviceName, serviceInstance := range services {
+		wg.Add(1)
+		go func(serviceName, vendor string) {
+			defer wg.Done()
+			results <- probe(serviceName, vendor)
+		}(serviceName, hotswap.Vendor(serviceName, serviceInstance.Vendor))
+	}
+	wg.Wait()
+	close(results)
+
+	mu.Lock()
+	defer mu.Unlock()
+	probed := make(map[string]*Backend, len(services))
+	for result := range results {
+		result := result
+		previous, exists := backends[result.Service]
+		if exists && result.Status == StatusDown {
+			result.LastSuccess = previous.LastSuccess
+		}
+		if result.Status == StatusDown && (!exists || previous.Status != StatusDown) {
+			logging.Logger.Warn("the backend of " + result.Service + " service is down: " + result.LastError)
+		} else if result.Status == StatusUp && exists && previous.Status == StatusDown {
+			logging.Logger.Info("the backend of " + result.Service + " service is up again")
+		}
+		probed[result.Service] = &result
+	}
+	// the services which were removed by a reload are forgotten
+	backends = probed
+}
+
+// probe checks the backend of one service with the probe of its vendor
+func probe(serviceName, vendor string) Backend {
+	backend := Backend{Service: serviceName, Vendor: vendor}
+	mu.Lock()
+	p, exists := probes[strings.ToLower(vendor)]
+	probeTimeout := timeout
+	mu.Unlock()
+	if toggles.IsDisabled(serviceName) {
+		backend.Status = StatusDisabled
+		return backend
+	}
+	if !exists {
+		backend.Status = StatusUnprobed
+		return backend
+	}
+	start := time.Now()
+	err := p(serviceName, probeTimeout)
+	backend.LatencyMs = time.Since(start).Milliseconds()
+	backend.LastChecked = &start
+	if err != nil {
+		backend.Status, backend.LastError = StatusDown, err.Error()
+		return backend
+	}
+	backend.Status, backend.LastSuccess = StatusUp, &start
+	return backend
+}
+
+// Backends returns the state of the backend of every service sorted by service, nothing if the probes are disabled
+func Backends() []Backend {
+	mu.Lock()
+	defer mu.Unlock()
+	result := make([]Backend, 0, len(backends))
+	for _, backend := range backends {
+		result = append(result, *backend)
+	}
+	sort.Slice(result, func(a, b int) bool { return result[a].Service < result[b].Service })
+	return result
+}
+
+// Ready reports whether the server takes new ICAP transactions: it isn't draining and none of the backends of
+// the enabled services is down
+func Ready() bool {
+	if Draining() {
+		return false
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, backend := range backends {
+		if backend.Status == StatusDown {
+			return false
+		}
+	}
+	return true
+}
+
+// SetDraining makes the server not ready, so the load balancers stop sending it new transactions while the
+// transactions in flight are drained
+func SetDraining() {
+	atomic.StoreInt32(&draining, 1)
+}
+
+// Draining reports whether the server is draining
+func Draining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
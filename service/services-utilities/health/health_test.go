@@ -0,0 +1,42 @@
+package health
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProbe(t *testing.T) {
+	Register("Failing", func(string, time.Duration) error { return errors.New("connection refused") })
+	Register("working", func(string, time.Duration) error { return nil })
+
+	down := probe("scan", "failing")
+	if down.Status != StatusDown || down.LastError != "connection refused" || down.LastSuccess != nil {
+		t.Fatalf("the failing backend should be down, got %+v", down)
+	}
+	if up := probe("scan", "working"); up.Status != StatusUp || up.LastSuccess == nil {
+		t.Fatalf("the working backend should be up, got %+v", up)
+	}
+	if unprobed := probe("scan", "echo"); unprobed.Status != StatusUnprobed {
+		t.Fatalf("a vendor without a probe shouldn't be probed, got %+v", unprobed)
+	}
+}
+
+func TestReady(t *testing.T) {
+	backends = map[string]*Backend{"echo": {Service: "echo", Status: StatusUnprobed}}
+	if !Ready() {
+		t.Fatal("the server should be ready without a backend which is down")
+	}
+	backends["clamav"] = &Backend{Service: "clamav", Status: StatusDown}
+	if Ready() {
+		t.Fatal("the server shouldn't be ready while a backend is down")
+	}
+	if list := Backends(); len(list) != 2 || list[0].Service != "clamav" {
+		t.Fatalf("the backends should be sorted by service, got %+v", list)
+	}
+	backends["clamav"].Status = StatusUp
+	SetDraining()
+	if Ready() {
+		t.Fatal("the server shouldn't be ready while it's draining")
+	}
+}
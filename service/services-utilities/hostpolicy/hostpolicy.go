@@ -0,0 +1,53 @@
+package hostpolicy
+
+import (
+	"net"
+	"strings"
+)
+
+// the port of a CONNECT authority which has no port
+const defaultTLSPort = "443"
+
+// SplitAuthority splits the authority of a CONNECT request (host:port) into its host and port,
+// the host is lower-cased without brackets and the trailing dot, and the port is 443 if it has no port
+func SplitAuthority(authority string) (string, string) {
+	host, port, err := net.SplitHostPort(authority)
+	if err != nil {
+		host, port = authority, defaultTLSPort
+	}
+	host = strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+	return host, port
+}
+
+// MatchHost reports whether the host matches the pattern, a domain matches itself and its subdomains
+// and a pattern which starts with "*." matches the subdomains only
+func MatchHost(pattern, host string) bool {
+	pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+// Blocked returns the first pattern which matches the host, it's empty if none of them matches
+func Blocked(patterns []string, host string) string {
+	for _, pattern := range patterns {
+		if pattern != "" && MatchHost(pattern, host) {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// PortAllowed reports whether the port is one of the allowed ports, every port is allowed if the list is empty
+func PortAllowed(ports []string, port string) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	for _, allowed := range ports {
+		if strings.TrimSpace(allowed) == port {
+			return true
+		}
+	}
+	return false
+}
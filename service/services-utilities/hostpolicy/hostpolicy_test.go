@@ -0,0 +1,38 @@
+package hostpolicy
+
+import "testing"
+
+func TestSplitAuthority(t *testing.T) {
+	cases := map[string][2]string{
+		"Example.COM:8443":  {"example.com", "8443"},
+		"example.com.":      {"example.com", "443"},
+		"[2001:db8::1]:443": {"2001:db8::1", "443"},
+	}
+	for authority, expected := range cases {
+		if host, port := SplitAuthority(authority); host != expected[0] || port != expected[1] {
+			t.Fatalf("%s: expected %v, got %s %s", authority, expected, host, port)
+		}
+	}
+}
+
+func TestBlocked(t *testing.T) {
+	patterns := []string{"bad.org", "*.tracker.net"}
+	if Blocked(patterns, "bad.org") != "bad.org" || Blocked(patterns, "cdn.bad.org") != "bad.org" {
+		t.Fatalf("a domain should match itself and its subdomains")
+	}
+	if Blocked(patterns, "tracker.net") != "" || Blocked(patterns, "a.tracker.net") != "*.tracker.net" {
+		t.Fatalf("a wildcard should match the subdomains only")
+	}
+	if Blocked(patterns, "notbad.org") != "" {
+		t.Fatalf("a domain shouldn't match another domain with the same suffix")
+	}
+}
+
+func TestPortAllowed(t *testing.T) {
+	if !PortAllowed(nil, "22") {
+		t.Fatalf("every port should be allowed without a list")
+	}
+	if PortAllowed([]string{"443"}, "22") || !PortAllowed([]string{"443", "8443"}, "8443") {
+		t.Fatalf("only the listed ports should be allowed")
+	}
+}
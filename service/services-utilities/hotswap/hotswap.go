@@ -0,0 +1,113 @@
+package hotswap
+
+import (
+	"sync"
+	"time"
+)
+
+// the vendors which replaced the configured vendors of the services at runtime, and the in-flight scans of
+// every vendor of a service, so the old vendor of a swap is drained before it's taken down
+var (
+	mu         sync.Mutex
+	swapped    = make(map[string]string)
+	inFlight   = make(map[key]int)
+	replicator func(service, vendor string)
+)
+
+type key struct {
+	service string
+	vendor  string
+}
+
+// how often Drain checks the in-flight scans of the old vendor
+const drainCheckInterval = 50 * time.Millisecond
+
+// SetReplicator sets the func which is told about the swaps which are made on this instance, ex: the cluster
+// mode sends them to the other gateway instances
+func SetReplicator(r func(service, vendor string)) {
+	mu.Lock()
+	defer mu.Unlock()
+	replicator = r
+}
+
+// Swap makes the vendor back the service at runtime and replicates the swap, an empty vendor restores the
+// configured vendor of the service. The scans which already started keep their vendor until they finish
+func Swap(service, vendor string) {
+	Apply(service, vendor)
+	mu.Lock()
+	r := replicator
+	mu.Unlock()
+	if r != nil {
+		r(service, vendor)
+	}
+}
+
+// Apply makes the vendor back the service without replicating the swap, ex: a swap of another instance
+func Apply(service, vendor string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if vendor == "" {
+		delete(swapped, service)
+	} else {
+		swapped[service] = vendor
+	}
+}
+
+// Vendor returns the vendor which backs the service, the configured one if it wasn't swapped at runtime
+func Vendor(service, configured string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	if vendor, exists := swapped[service]; exists {
+		return vendor
+	}
+	return configured
+}
+
+// Swapped returns the services which were swapped at runtime and their vendors
+func Swapped() map[string]string {
+	mu.Lock()
+	defer mu.Unlock()
+	result := make(map[string]string, len(swapped))
+	for service, vendor := range swapped {
+		result[service] = vendor
+	}
+	return result
+}
+
+// Begin counts a scan of the service by the vendor until the returned func is called
+func Begin(service, vendor string) func() {
+	k := key{service: service, vendor: vendor}
+	mu.Lock()
+	inFlight[k]++
+	mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if inFlight[k]--; inFlight[k] <= 0 {
+				delete(inFlight, k)
+			}
+		})
+	}
+}
+
+// InFlight returns the number of scans of the service by the vendor which didn't finish yet
+func InFlight(service, vendor string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return inFlight[key{service: service, vendor: vendor}]
+}
+
+// Drain waits until the scans of the service by the vendor finished or the timeout expired, it returns the
+// number of scans which are still in flight
+func Drain(service, vendor string, timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		n := InFlight(service, vendor)
+		if n == 0 || !time.Now().Before(deadline) {
+			return n
+		}
+		time.Sleep(drainCheckInterval)
+	}
+}
@@ -0,0 +1,30 @@
+package hotswap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSwapDrainsTheOldVendor(t *testing.T) {
+	done := Begin("av", "clamav")
+	Swap("av", "clhashlookup")
+	if vendor := Vendor("av", "clamav"); vendor != "clhashlookup" {
+		t.Fatalf("the swapped vendor should back the service, got %s", vendor)
+	}
+	if n := Drain("av", "clamav", 0); n != 1 {
+		t.Fatalf("the scan of the old vendor should be in flight, got %d", n)
+	}
+	go func() {
+		time.Sleep(2 * drainCheckInterval)
+		done()
+		done()
+	}()
+	if n := Drain("av", "clamav", time.Second); n != 0 {
+		t.Fatalf("the old vendor should be drained, got %d scans in flight", n)
+	}
+
+	Swap("av", "")
+	if vendor := Vendor("av", "clamav"); vendor != "clamav" || len(Swapped()) != 0 {
+		t.Fatalf("the configured vendor should be restored, got %s", vendor)
+	}
+}
@@ -0,0 +1,286 @@
+package istag
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"icapeg/service/services-utilities/proxy"
+	icapegVersion "icapeg/version"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the default interval between two polls of the definition versions
+const defaultPollInterval = 5 * time.Minute
+
+// the timeout of the version requests of the vendor APIs
+const versionRequestTimeout = 10 * time.Second
+
+// Source returns the definition version of a vendor, ex: the signature database version of an AV engine
+type Source func() (string, error)
+
+// Status represents the definition version of a vendor and the ISTag which it makes
+type Status struct {
+	Version   string    `json:"version"`
+	ISTag     string    `json:"istag"`
+	ChangedAt time.Time `json:"changed_at"`
+	PolledAt  time.Time `json:"polled_at"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+type vendorVersion struct {
+	source Source
+	status Status
+}
+
+var (
+	mu       sync.Mutex
+	enabled  bool
+	interval = defaultPollInterval
+	// the ISTags start with the start time so they change when ICAPeg restarts with another configuration
+	started  = time.Now()
+	versions = make(map[string]*vendorVersion)
+	pollOnce sync.Once
+	// the latest ISTag of every service, to log its rotations
+	serviceTags = make(map[string]string)
+)
+
+// InitISTag reads the optional [app.istag] section, the services of the vendors which expose their definition
+// versions get an ISTag which changes when the definitions change, so the caches of the ICAP clients are
+// invalidated. A vendor sub section polls the version from a URL of the vendor API
+func InitISTag() {
+	if !readValues.IsSecExists("app.istag") || !readValues.ReadValuesBool("app.istag.enabled") {
+		return
+	}
+	mu.Lock()
+	enabled = true
+	if readValues.IsSecExists("app.istag.poll_interval") {
+		if i := readValues.ReadValuesDuration("app.istag.poll_interval") * time.Second; i > 0 {
+			interval = i
+		}
+	}
+	mu.Unlock()
+	for _, vendor := range readValues.ReadSubSections("app.istag") {
+		sec := "app.istag." + vendor
+		versionURL := readValues.ReadValuesString(sec + ".version_url")
+		if versionURL == "" {
+			logging.Logger.Error("the istag version_url of " + vendor + " vendor is empty, its ISTag isn't rotated")
+			continue
+		}
+		var field string
+		if readValues.IsSecExists(sec + ".version_field") {
+			field = readValues.ReadValuesString(sec + ".version_field")
+		}
+		Register(vendor, HTTPSource(vendor, versionURL, field))
+	}
+	pollOnce.Do(func() {
+		go func() {
+			for {
+				time.Sleep(pollInterval())
+				PollAll()
+			}
+		}()
+	})
+}
+
+func pollInterval() time.Duration {
+	mu.Lock()
+	defer mu.Unlock()
+	return interval
+}
+
+// Register makes the source the definition version of the vendor and polls it, the vendors register their
+// sources when their configurations are loaded. It does nothing if the ISTag rotation is disabled
+func Register(vendor string, source Source) {
+	vendor = strings.ToLower(vendor)
+	mu.Lock()
+	if !enabled {
+		mu.Unlock()
+		return
+	}
+	if _, exists := versions[vendor]; exists {
+		mu.Unlock()
+		return
+	}
+	versions[vendor] = &vendorVersion{source: source}
+	mu.Unlock()
+	Poll(vendor)
+}
+
+// Poll gets the definition version of the vendor again, the ISTag of the vendor changes if the version changed
+// and it's kept if the vendor couldn't be reached
+func Poll(vendor string) {
+	vendor = strings.ToLower(vendor)
+	mu.Lock()
+	v, exists := versions[vendor]
+	mu.Unlock()
+	if !exists {
+		return
+	}
+	version, err := v.source()
+	if err == nil && version == "" {
+		err = errors.New("the version is empty")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	v.status.PolledAt = time.Now()
+	if err != nil {
+		v.status.LastError = err.Error()
+		logging.Logger.Warn("couldn't poll the definition version of " + vendor + " vendor, its ISTag is kept: " +
+			err.Error())
+		return
+	}
+	v.status.LastError = ""
+	if version == v.status.Version {
+		return
+	}
+	if v.status.Version != "" {
+		logging.Logger.Info("the definitions of " + vendor + " vendor changed from " + v.status.Version + " to " +
+			version + ", its ISTag is rotated so the ICAP clients revalidate their cached content")
+	}
+	v.status.Version = version
+	v.status.ISTag = tagOf(version)
+	v.status.ChangedAt = v.status.PolledAt
+}
+
+// PollAll polls the definition version of every vendor
+func PollAll() {
+	for _, vendor := range vendorNames() {
+		Poll(vendor)
+	}
+}
+
+// tagOf returns the ISTag of a definition version, it stays within the 32 bytes of RFC 3507
+func tagOf(version string) string {
+	sum := sha256.Sum256([]byte(version))
+	return "epoch-" + strconv.FormatInt(started.Unix(), 10) + "-" + hex.EncodeToString(sum[:4])
+}
+
+// Value returns the ISTag of the vendor, false if the vendor has no definition version yet
+func Value(vendor string) (string, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	v, exists := versions[strings.ToLower(vendor)]
+	if !exists || v.status.ISTag == "" {
+		return "", false
+	}
+	return v.status.ISTag, true
+}
+
+// Version returns the polled definition version of the vendor, false if the vendor has no definition version yet
+func Version(vendor string) (string, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	v, exists := versions[strings.ToLower(vendor)]
+	if !exists || v.status.Version == "" {
+		return "", false
+	}
+	return v.status.Version, true
+}
+
+// ServiceTag returns the ISTag of the service, it's the hash of the ICAPeg version, the configuration hash of the
+// service and the definition version of its vendor, so it changes when any of them changes and stays the same
+// across the restarts otherwise. It stays within the 32 bytes of RFC 3507
+func ServiceTag(serviceName, configHash, version string) string {
+	sum := sha256.Sum256([]byte(icapegVersion.Version + "/" + icapegVersion.Commit + "\n" + serviceName + "\n" + configHash + "\n" + version))
+	tag := "icapeg-" + hex.EncodeToString(sum[:8])
+	mu.Lock()
+	previous := serviceTags[serviceName]
+	serviceTags[serviceName] = tag
+	mu.Unlock()
+	if previous != "" && previous != tag {
+		logging.Logger.Info("the ISTag of " + serviceName + " service changed from " + previous + " to " + tag +
+			", its configuration or its definitions changed")
+	}
+	return tag
+}
+
+// AllStatus returns the definition version and the ISTag of every vendor
+func AllStatus() map[string]Status {
+	mu.Lock()
+	defer mu.Unlock()
+	result := make(map[string]Status, len(versions))
+	for vendor, v := range versions {
+		result[vendor] = v.status
+	}
+	return result
+}
+
+func vendorNames() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	var result []string
+	for vendor := range versions {
+		result = append(result, vendor)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// HTTPSource returns the source which gets the version from the URL of the vendor API through the outbound proxy
+// of the vendor, field is the path of the version in the JSON response (ex: "engine.definitions"), an empty
+// field takes the whole response body as the version
+func HTTPSource(vendor, versionURL, field string) Source {
+	client := &http.Client{Transport: proxy.Transport(vendor), Timeout: versionRequestTimeout}
+	return func() (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), versionRequestTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, versionURL, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", errors.New("the version URL responded with " + resp.Status)
+		}
+		var body interface{}
+		if field == "" {
+			var raw json.RawMessage
+			if err = json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+				return "", err
+			}
+			return string(raw), nil
+		}
+		decoder := json.NewDecoder(resp.Body)
+		// the numeric versions (ex: 20221012) are kept as they're written
+		decoder.UseNumber()
+		if err = decoder.Decode(&body); err != nil {
+			return "", err
+		}
+		return jsonField(body, field)
+	}
+}
+
+// jsonField returns the value of the dotted path in the decoded JSON document
+func jsonField(body interface{}, field string) (string, error) {
+	for _, key := range strings.Split(field, ".") {
+		object, isObject := body.(map[string]interface{})
+		if !isObject {
+			return "", errors.New("the version response has no " + field)
+		}
+		if body, isObject = object[key]; !isObject {
+			return "", errors.New("the version response has no " + field)
+		}
+	}
+	switch value := body.(type) {
+	case string:
+		return value, nil
+	case nil:
+		return "", errors.New("the " + field + " of the version response is null")
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
@@ -0,0 +1,80 @@
+package istag
+
+import (
+	"errors"
+	"icapeg/logging"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestRotation(t *testing.T) {
+	logging.Logger = zap.NewNop()
+	enabled = true
+	version, err := "ClamAV 0.103.2/26123", error(nil)
+	Register("ClamAV", func() (string, error) { return version, err })
+
+	tag, exists := Value("clamav")
+	if !exists || len(tag) > 32 {
+		t.Fatalf("expected an ISTag of at most 32 bytes, got %q", tag)
+	}
+	Poll("clamav")
+	if again, _ := Value("clamav"); again != tag {
+		t.Fatalf("the ISTag shouldn't change while the version is the same, got %q and %q", tag, again)
+	}
+
+	err = errors.New("clamd is down")
+	Poll("clamav")
+	if kept, _ := Value("clamav"); kept != tag || AllStatus()["clamav"].LastError == "" {
+		t.Fatalf("the ISTag should be kept when the version can't be polled, got %q", kept)
+	}
+
+	version, err = "ClamAV 0.103.2/26124", nil
+	PollAll()
+	if rotated, _ := Value("clamav"); rotated == tag {
+		t.Fatalf("the ISTag should rotate when the version changes")
+	}
+	if _, exists = Value("unknown"); exists {
+		t.Fatalf("a vendor without a source shouldn't have an ISTag")
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"engine": {"definitions": 20221012, "name": "abc"}}`))
+	}))
+	defer srv.Close()
+
+	if version, err := HTTPSource("abc", srv.URL, "engine.definitions")(); err != nil || version != "20221012" {
+		t.Fatalf("expected the definitions field, got %q %v", version, err)
+	}
+	if _, err := HTTPSource("abc", srv.URL, "engine.missing")(); err == nil {
+		t.Fatalf("a missing field should be an error")
+	}
+	if version, err := HTTPSource("abc", srv.URL, "")(); err != nil || version == "" {
+		t.Fatalf("an empty field should take the whole response, got %q %v", version, err)
+	}
+}
+
+func TestServiceTag(t *testing.T) {
+	logging.Logger = zap.NewNop()
+	tag := ServiceTag("clamav", "config-hash", "ClamAV 0.103.2/26123")
+	if len(tag) > 32 {
+		t.Fatalf("expected an ISTag of at most 32 bytes, got %q", tag)
+	}
+	if again := ServiceTag("clamav", "config-hash", "ClamAV 0.103.2/26123"); again != tag {
+		t.Fatalf("the ISTag shouldn't change while the configuration and the version are the same, got %q and %q",
+			tag, again)
+	}
+	if ServiceTag("clamav", "config-hash", "ClamAV 0.103.2/26124") == tag {
+		t.Fatal("the ISTag should change with the definition version")
+	}
+	if ServiceTag("clamav", "reloaded-config-hash", "ClamAV 0.103.2/26123") == tag {
+		t.Fatal("the ISTag should change with the configuration")
+	}
+	if ServiceTag("echo", "config-hash", "ClamAV 0.103.2/26123") == tag {
+		t.Fatal("the services should have different ISTags")
+	}
+}
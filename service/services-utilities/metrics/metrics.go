@@ -0,0 +1,337 @@
+package metrics
+
+import (
+	"bufio"
+	"icapeg/icap"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the default path of the metrics endpoint
+const defaultPath = "/metrics"
+
+// the upper bounds in seconds of the buckets of the duration histograms, from the cached verdicts to the
+// sandboxes of the vendors
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+type requestKey struct {
+	service string
+	method  string
+}
+
+type responseKey struct {
+	service string
+	method  string
+	status  string
+}
+
+type vendorKey struct {
+	service string
+	vendor  string
+}
+
+type shadowVerdictKey struct {
+	service string
+	vendor  string
+	verdict string
+}
+
+type comparisonKey struct {
+	primary string
+	shadow  string
+	result  string
+}
+
+type histogram struct {
+	counts []uint64 // by the buckets of durationBuckets, they aren't cumulative
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(seconds float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(durationBuckets))
+	}
+	for b, bound := range durationBuckets {
+		if seconds <= bound {
+			h.counts[b]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// Registry keeps the counters and the histograms of the ICAP traffic
+type Registry struct {
+	mu               sync.Mutex
+	requests         map[requestKey]uint64
+	responses        map[responseKey]uint64
+	requestDurations map[requestKey]*histogram
+	vendorDurations  map[vendorKey]*histogram
+	scannedBytes     map[vendorKey]uint64
+	shadowVerdicts   map[shadowVerdictKey]uint64
+	comparisons      map[comparisonKey]uint64
+}
+
+var registry *Registry
+
+// InitMetrics reads the optional [app.metrics] section and serves the metrics in the Prometheus text format
+// on their own port, so they can be scraped without the admin token
+func InitMetrics() {
+	if !readValues.IsSecExists("app.metrics") || !readValues.ReadValuesBool("app.metrics.enabled") {
+		return
+	}
+	port := readValues.ReadValuesInt("app.metrics.port")
+	path := defaultPath
+	if readValues.IsSecExists("app.metrics.path") {
+		if p := readValues.ReadValuesString("app.metrics.path"); p != "" {
+			path = "/" + strings.TrimPrefix(p, "/")
+		}
+	}
+	registry = New()
+	mux := http.NewServeMux()
+	mux.Handle(path, registry)
+	go func() {
+		logging.Logger.Info("metrics are served at " + path + " on port: " + strconv.Itoa(port))
+		if err := http.ListenAndServe(":"+strconv.Itoa(port), mux); err != nil {
+			logging.Logger.Error("metrics endpoint stopped: " + err.Error())
+		}
+	}()
+}
+
+// New creates an empty registry
+func New() *Registry {
+	return &Registry{
+		requests:         make(map[requestKey]uint64),
+		responses:        make(map[responseKey]uint64),
+		requestDurations: make(map[requestKey]*histogram),
+		vendorDurations:  make(map[vendorKey]*histogram),
+		scannedBytes:     make(map[vendorKey]uint64),
+		shadowVerdicts:   make(map[shadowVerdictKey]uint64),
+		comparisons:      make(map[comparisonKey]uint64),
+	}
+}
+
+// Enabled reports whether the metrics are kept
+func Enabled() bool {
+	return registry != nil
+}
+
+// RecordRequest counts an ICAP request of the service and its response, see Registry.RecordRequest
+func RecordRequest(service, method string, statusCode int, duration time.Duration) {
+	if registry != nil {
+		registry.RecordRequest(service, method, statusCode, duration)
+	}
+}
+
+// RecordVendor records a call of the vendor of the service, see Registry.RecordVendor
+func RecordVendor(service, vendor string, scannedBytes int, duration time.Duration) {
+	if registry != nil {
+		registry.RecordVendor(service, vendor, scannedBytes, duration)
+	}
+}
+
+// RecordShadowVerdict counts a verdict of a shadow service, see Registry.RecordShadowVerdict
+func RecordShadowVerdict(service, vendor, verdict string) {
+	if registry != nil {
+		registry.RecordShadowVerdict(service, vendor, verdict)
+	}
+}
+
+// RecordComparison counts a comparison of a primary service and its shadow service, see Registry.RecordComparison
+func RecordComparison(primary, shadow string, agree bool) {
+	if registry != nil {
+		registry.RecordComparison(primary, shadow, agree)
+	}
+}
+
+// RecordRequest counts an ICAP request of the service by its method and the status code of its response, a
+// status code of zero is a request which wasn't answered (ex: the connection was aborted)
+func (r *Registry) RecordRequest(service, method string, statusCode int, duration time.Duration) {
+	key := requestKey{service: service, method: method}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests[key]++
+	r.responses[responseKey{service: service, method: method, status: strconv.Itoa(statusCode)}]++
+	h := r.requestDurations[key]
+	if h == nil {
+		h = &histogram{}
+		r.requestDurations[key] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// RecordVendor records the latency of a call of the vendor of the service and the bytes which it scanned
+func (r *Registry) RecordVendor(service, vendor string, scannedBytes int, duration time.Duration) {
+	key := vendorKey{service: service, vendor: vendor}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scannedBytes[key] += uint64(scannedBytes)
+	h := r.vendorDurations[key]
+	if h == nil {
+		h = &histogram{}
+		r.vendorDurations[key] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// RecordShadowVerdict counts the verdict which a shadow service would have returned
+func (r *Registry) RecordShadowVerdict(service, vendor, verdict string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.shadowVerdicts[shadowVerdictKey{service: service, vendor: vendor, verdict: verdict}]++
+}
+
+// RecordComparison counts whether the verdicts of a primary service and of its shadow service agree
+func (r *Registry) RecordComparison(primary, shadow string, agree bool) {
+	result := "disagree"
+	if agree {
+		result = "agree"
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.comparisons[comparisonKey{primary: primary, shadow: shadow, result: result}]++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	r.Write(bw)
+	bw.Flush()
+}
+
+// Write writes the metrics in the Prometheus text exposition format, the series of a metric are sorted by
+// their labels
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var family []series
+	for key, n := range r.requests {
+		family = append(family, counterSeries("icapeg_requests_total", n, "service", key.service, "method",
+			key.method))
+	}
+	writeFamily(w, "icapeg_requests_total", "counter", "The ICAP requests by service and method.", family)
+
+	family = family[:0]
+	for key, n := range r.responses {
+		family = append(family, counterSeries("icapeg_responses_total", n, "service", key.service, "method",
+			key.method, "code", key.status))
+	}
+	writeFamily(w, "icapeg_responses_total", "counter",
+		"The ICAP responses by service, method and status code, 0 is a request which wasn't answered.", family)
+
+	family = family[:0]
+	for key, h := range r.requestDurations {
+		family = append(family, histogramSeries("icapeg_request_duration_seconds", h, "service", key.service,
+			"method", key.method))
+	}
+	writeFamily(w, "icapeg_request_duration_seconds", "histogram", "The duration of the ICAP transactions.", family)
+
+	family = family[:0]
+	for key, h := range r.vendorDurations {
+		family = append(family, histogramSeries("icapeg_vendor_duration_seconds", h, "service", key.service,
+			"vendor", key.vendor))
+	}
+	writeFamily(w, "icapeg_vendor_duration_seconds", "histogram",
+		"The latency of the vendor backends, from sending the file to getting its verdict.", family)
+
+	family = family[:0]
+	for key, n := range r.scannedBytes {
+		family = append(family, counterSeries("icapeg_scanned_bytes_total", n, "service", key.service, "vendor",
+			key.vendor))
+	}
+	writeFamily(w, "icapeg_scanned_bytes_total", "counter", "The bytes which were sent to the vendors.", family)
+
+	family = family[:0]
+	for key, n := range r.shadowVerdicts {
+		family = append(family, counterSeries("icapeg_shadow_verdicts_total", n, "service", key.service, "vendor",
+			key.vendor, "verdict", key.verdict))
+	}
+	writeFamily(w, "icapeg_shadow_verdicts_total", "counter",
+		"The verdicts which the shadow services would have returned.", family)
+
+	family = family[:0]
+	for key, n := range r.comparisons {
+		family = append(family, counterSeries("icapeg_shadow_comparisons_total", n, "primary", key.primary, "shadow",
+			key.shadow, "result", key.result))
+	}
+	writeFamily(w, "icapeg_shadow_comparisons_total", "counter",
+		"The verdicts of the primary services compared with the ones of their shadow services.", family)
+
+	writeFamily(w, "icapeg_active_connections", "gauge", "The open ICAP connections.", []series{{
+		lines: []string{"icapeg_active_connections " + strconv.FormatInt(icap.ActiveConnections(), 10)},
+	}})
+	writeFamily(w, "icapeg_waiting_requests", "gauge", "The ICAP requests which wait for a worker.", []series{{
+		lines: []string{"icapeg_waiting_requests " + strconv.FormatInt(icap.WaitingRequests(), 10)},
+	}})
+}
+
+// series are the lines of a metric with a label set
+type series struct {
+	labels string
+	lines  []string
+}
+
+func writeFamily(w io.Writer, name, metricType, help string, family []series) {
+	io.WriteString(w, "# HELP "+name+" "+help+"\n# TYPE "+name+" "+metricType+"\n")
+	sort.Slice(family, func(a, b int) bool { return family[a].labels < family[b].labels })
+	for _, s := range family {
+		for _, line := range s.lines {
+			io.WriteString(w, line+"\n")
+		}
+	}
+}
+
+func counterSeries(name string, n uint64, labelPairs ...string) series {
+	l := labels(labelPairs...)
+	return series{labels: l, lines: []string{name + l + " " + strconv.FormatUint(n, 10)}}
+}
+
+// histogramSeries returns the cumulative buckets, the sum and the count of the histogram
+func histogramSeries(name string, h *histogram, labelPairs ...string) series {
+	s := series{labels: labels(labelPairs...)}
+	bucket := func(le string, n uint64) string {
+		// the pairs are copied so the buckets don't share their le label
+		pairs := append(append(make([]string, 0, len(labelPairs)+2), labelPairs...), "le", le)
+		return name + "_bucket" + labels(pairs...) + " " + strconv.FormatUint(n, 10)
+	}
+	var cumulative uint64
+	for b, bound := range durationBuckets {
+		cumulative += h.counts[b]
+		s.lines = append(s.lines, bucket(strconv.FormatFloat(bound, 'g', -1, 64), cumulative))
+	}
+	s.lines = append(s.lines, bucket("+Inf", h.count),
+		name+"_sum"+s.labels+" "+strconv.FormatFloat(h.sum, 'g', -1, 64),
+		name+"_count"+s.labels+" "+strconv.FormatUint(h.count, 10))
+	return s
+}
+
+// labels returns the label set of the name and value pairs, the values are escaped
+func labels(pairs ...string) string {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for p := 0; p+1 < len(pairs); p += 2 {
+		if p > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(pairs[p] + `="` + escape(pairs[p+1]) + `"`)
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := New()
+	r.RecordRequest("echo", "RESPMOD", 204, 3*time.Millisecond)
+	r.RecordRequest("clamav", "RESPMOD", 200, 40*time.Millisecond)
+	r.RecordRequest("clamav", "RESPMOD", 200, 2*time.Second)
+	r.RecordVendor("clamav", "clamav", 1000, 30*time.Millisecond)
+	r.RecordVendor("clamav", "clamav", 24, 90*time.Second)
+
+	var sb strings.Builder
+	r.Write(&sb)
+	out := sb.String()
+	for _, expected := range []string{
+		"# TYPE icapeg_requests_total counter\n" +
+			`icapeg_requests_total{service="clamav",method="RESPMOD"} 2` + "\n" +
+			`icapeg_requests_total{service="echo",method="RESPMOD"} 1` + "\n",
+		`icapeg_responses_total{service="clamav",method="RESPMOD",code="200"} 2` + "\n",
+		`icapeg_request_duration_seconds_bucket{service="clamav",method="RESPMOD",le="0.05"} 1` + "\n",
+		`icapeg_request_duration_seconds_bucket{service="clamav",method="RESPMOD",le="2.5"} 2` + "\n",
+		`icapeg_vendor_duration_seconds_bucket{service="clamav",vendor="clamav",le="60"} 1` + "\n" +
+			`icapeg_vendor_duration_seconds_bucket{service="clamav",vendor="clamav",le="120"} 2` + "\n" +
+			`icapeg_vendor_duration_seconds_bucket{service="clamav",vendor="clamav",le="+Inf"} 2` + "\n" +
+			`icapeg_vendor_duration_seconds_sum{service="clamav",vendor="clamav"} 90.03` + "\n" +
+			`icapeg_vendor_duration_seconds_count{service="clamav",vendor="clamav"} 2` + "\n",
+		`icapeg_scanned_bytes_total{service="clamav",vendor="clamav"} 1024` + "\n",
+		"icapeg_active_connections 0\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("the metrics should contain:\n%s\ngot:\n%s", expected, out)
+		}
+	}
+}
+
+func TestLabelsAreEscaped(t *testing.T) {
+	if l := labels("service", `a"b\c`+"\n"); l != `{service="a\"b\\c\n"}` {
+		t.Fatalf("unexpected labels %s", l)
+	}
+}
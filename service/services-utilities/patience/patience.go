@@ -0,0 +1,149 @@
+package patience
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"icapeg/service/services-utilities/spool"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrTooManyDownloads is returned by Register when the service keeps its max downloads or max bytes already
+var ErrTooManyDownloads = errors.New("the service keeps too many patience page downloads")
+
+// Download is an HTTP response which is scanned behind a patience page, the HTTP server serves
+// the patience page while the download is pending and the scanned response once it's done
+type Download struct {
+	Done        bool
+	StatusCode  int
+	Header      http.Header
+	Body        *spool.Body // the scanned response, it stays in its spool until the download expires
+	Size        int64       // the bytes which the download keeps, the size of the original body while it's pending
+	FileName    string
+	ServiceName string
+	PagePath    string
+	Refresh     time.Duration
+	expiresAt   time.Time
+}
+
+// Limits are the downloads and the bytes which the patience pages of a service keep at once
+type Limits struct {
+	MaxDownloads int
+	MaxBytes     int64
+}
+
+// Page holds the variables which the patience page template can use
+type Page struct {
+	FileName       string
+	ServiceName    string
+	DownloadURL    string
+	RefreshSeconds int
+}
+
+var (
+	downloadsMu sync.Mutex
+	downloads   = make(map[string]*Download)
+)
+
+// Register stores a pending download which is kept for ttl and returns its ID, the ID is
+// unguessable because it's the only thing which authorizes fetching the download. It returns
+// ErrTooManyDownloads if the download would exceed the limits of its service
+func Register(download *Download, ttl time.Duration, limits Limits) (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(raw)
+	now := time.Now()
+	download.expiresAt = now.Add(ttl)
+
+	downloadsMu.Lock()
+	defer downloadsMu.Unlock()
+	count, size := 0, int64(0)
+	for key, d := range downloads {
+		if !now.Before(d.expiresAt) {
+			remove(key)
+			continue
+		}
+		if d.ServiceName == download.ServiceName {
+			count++
+			size += d.Size
+		}
+	}
+	if count >= limits.MaxDownloads || size+download.Size > limits.MaxBytes {
+		return "", ErrTooManyDownloads
+	}
+	downloads[id] = download
+	// the spool of the download is removed when it expires, even if no download is registered after it
+	time.AfterFunc(ttl, func() {
+		downloadsMu.Lock()
+		defer downloadsMu.Unlock()
+		remove(id)
+	})
+	return id, nil
+}
+
+// Complete stores the scanned response of a pending download, the download holds body until it expires.
+// body is closed at once if the download expired already
+func Complete(id string, statusCode int, header http.Header, body *spool.Body) {
+	downloadsMu.Lock()
+	defer downloadsMu.Unlock()
+	download, exists := downloads[id]
+	if !exists {
+		body.Close()
+		return
+	}
+	download.Done = true
+	download.StatusCode = statusCode
+	download.Header = header
+	download.Body = body
+	download.Size = body.Size()
+}
+
+// Get returns a copy of the download of the ID if it hasn't expired, the body of a done download is kept for
+// the caller until it closes it, so it can be read after the download expired
+func Get(id string) (Download, bool) {
+	downloadsMu.Lock()
+	defer downloadsMu.Unlock()
+	download, exists := downloads[id]
+	if !exists {
+		return Download{}, false
+	}
+	if !time.Now().Before(download.expiresAt) {
+		remove(id)
+		return Download{}, false
+	}
+	if download.Body != nil {
+		download.Body.Retain()
+	}
+	return *download, true
+}
+
+// remove deletes the download and releases its body, downloadsMu must be held
+func remove(id string) {
+	download, exists := downloads[id]
+	if !exists {
+		return
+	}
+	delete(downloads, id)
+	if download.Body != nil {
+		download.Body.Close()
+	}
+}
+
+// RenderPage executes the patience page template
+func RenderPage(pagePath string, page *Page) ([]byte, error) {
+	tmpl, err := template.ParseFiles(pagePath)
+	if err != nil {
+		return nil, err
+	}
+	result := &bytes.Buffer{}
+	if err = tmpl.Execute(result, page); err != nil {
+		return nil, err
+	}
+	return result.Bytes(), nil
+}
@@ -0,0 +1,133 @@
+package patience
+
+import (
+	"icapeg/service/services-utilities/spool"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var limits = Limits{MaxDownloads: 100, MaxBytes: 1 << 30}
+
+// spooled returns a body whose bytes after the first 4 are spooled to a file in dir
+func spooled(t *testing.T, content string) (*spool.Body, string) {
+	t.Helper()
+	dir := t.TempDir()
+	body, err := spool.New(strings.NewReader(content), 4, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body, dir
+}
+
+// released reports whether the spool file of the body in dir was removed
+func released(dir string) bool {
+	files, err := os.ReadDir(dir)
+	return err == nil && len(files) == 0
+}
+
+func TestRegisterAndComplete(t *testing.T) {
+	id, err := Register(&Download{FileName: "big.zip"}, time.Minute, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	download, exists := Get(id)
+	if !exists || download.Done {
+		t.Fatalf("expected a pending download, got exists=%v done=%v", exists, download.Done)
+	}
+	body, _ := spooled(t, "content")
+	Complete(id, http.StatusOK, http.Header{"Content-Type": {"application/zip"}}, body)
+	download, _ = Get(id)
+	defer download.Body.Close()
+	if !download.Done || download.StatusCode != http.StatusOK || download.Size != 7 {
+		t.Fatalf("unexpected download after completing it: %+v", download)
+	}
+	if data, _ := io.ReadAll(download.Body.Open()); string(data) != "content" {
+		t.Fatalf("unexpected body %q", data)
+	}
+}
+
+func TestExpiredDownload(t *testing.T) {
+	id, err := Register(&Download{}, -time.Second, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := Get(id); exists {
+		t.Fatal("expected the expired download to be gone")
+	}
+	if _, exists := Get("unknown"); exists {
+		t.Fatal("expected an unknown ID not to exist")
+	}
+	// the body of a download which expired before it was scanned is released at once
+	body, dir := spooled(t, "late content")
+	Complete(id, http.StatusOK, http.Header{}, body)
+	if !released(dir) {
+		t.Fatal("the body of the expired download wasn't released")
+	}
+}
+
+func TestExpiredDownloadReleasesItsBody(t *testing.T) {
+	id, err := Register(&Download{ServiceName: "expiring"}, 50*time.Millisecond, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, dir := spooled(t, "scanned content")
+	Complete(id, http.StatusOK, http.Header{}, body)
+	// the body which is being served stays readable after the download expired
+	download, _ := Get(id)
+	time.Sleep(100 * time.Millisecond)
+	if data, err := io.ReadAll(download.Body.Open()); err != nil || string(data) != "scanned content" {
+		t.Fatalf("the served body should be readable until it's closed, got %q (%v)", data, err)
+	}
+	download.Body.Close()
+	if !released(dir) {
+		t.Fatal("the body of the expired download wasn't released")
+	}
+}
+
+func TestRegisterLimits(t *testing.T) {
+	small := Limits{MaxDownloads: 2, MaxBytes: 100}
+	register := func(serviceName string, size int64) error {
+		id, err := Register(&Download{ServiceName: serviceName, Size: size}, time.Minute, small)
+		if err == nil {
+			t.Cleanup(func() {
+				downloadsMu.Lock()
+				defer downloadsMu.Unlock()
+				remove(id)
+			})
+		}
+		return err
+	}
+	if err := register("limited", 60); err != nil {
+		t.Fatal(err)
+	}
+	if err := register("limited", 60); err != ErrTooManyDownloads {
+		t.Fatalf("the download above the max bytes should be refused, got %v", err)
+	}
+	if err := register("limited", 40); err != nil {
+		t.Fatal(err)
+	}
+	if err := register("limited", 0); err != ErrTooManyDownloads {
+		t.Fatalf("the download above the max downloads should be refused, got %v", err)
+	}
+	// the limits are the ones of every service
+	if err := register("other", 60); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenderPage(t *testing.T) {
+	pagePath := filepath.Join(t.TempDir(), "patience-page.html")
+	os.WriteFile(pagePath, []byte(`<a href="{{.DownloadURL}}">{{.FileName}}</a> {{.RefreshSeconds}}`), 0600)
+	page, err := RenderPage(pagePath, &Page{FileName: "<big>.zip", DownloadURL: "/patience/abc", RefreshSeconds: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(page), `/patience/abc`) || !strings.Contains(string(page), "&lt;big&gt;.zip") {
+		t.Fatalf("unexpected page: %s", page)
+	}
+}
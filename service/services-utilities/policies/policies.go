@@ -0,0 +1,268 @@
+package policies
+
+import (
+	"errors"
+	"fmt"
+	"icapeg/service/services-utilities/hostpolicy"
+	"net"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// the actions of the policy rules
+const (
+	ActionBypass  = "bypass"  // the HTTP message is returned as it is, with 204 if the ICAP client allows it
+	ActionService = "service" // the HTTP message is processed by another service
+	ActionBlock   = "block"   // the HTTP message is answered with the block page
+	ActionShadow  = "shadow"  // the HTTP message is returned as it is and processed by the service in the background
+)
+
+// Rule is a policy rule, it matches a transaction if all its conditions match it, a rule without conditions
+// matches every transaction
+type Rule struct {
+	Name         string
+	Services     []string     // the ICAP services which the rule applies to, every service if it's empty
+	ICAPClients  []*net.IPNet // the addresses of the ICAP clients (ex: the proxies)
+	ClientIPs    []*net.IPNet // the addresses of the HTTP clients, from the X-Client-IP header
+	Hosts        []string     // the destination hosts, a domain matches its subdomains too
+	Methods      []string     // the methods of the HTTP requests
+	ContentTypes []string     // the content types of the HTTP messages, ex: image/* or application/pdf
+	UserAgent    *regexp.Regexp
+	MinSize      int64 // the bytes of the HTTP body, -1 if the rule has no size condition
+	MaxSize      int64
+	Action       string
+	Service      string // the service of the service action
+}
+
+// Transaction is what the rules match, the ICAP request and its encapsulated HTTP message
+type Transaction struct {
+	Service     string
+	ICAPClient  string
+	ClientIP    string
+	Host        string
+	Method      string
+	ContentType string
+	UserAgent   string
+	Size        int64 // the declared size of the HTTP body, -1 if it's unknown
+}
+
+// First returns the first rule which matches the transaction, nil if none of them does
+func First(rules []*Rule, t Transaction) *Rule {
+	for _, rule := range rules {
+		if rule.Matches(t) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Matches reports whether all the conditions of the rule match the transaction, a size condition doesn't match
+// a body whose size is unknown
+func (r *Rule) Matches(t Transaction) bool {
+	if len(r.Services) != 0 && !contains(r.Services, t.Service, false) {
+		return false
+	}
+	if len(r.ICAPClients) != 0 && !inNetworks(r.ICAPClients, t.ICAPClient) {
+		return false
+	}
+	if len(r.ClientIPs) != 0 && !inNetworks(r.ClientIPs, t.ClientIP) {
+		return false
+	}
+	if len(r.Hosts) != 0 && hostpolicy.Blocked(r.Hosts, strings.TrimSuffix(strings.ToLower(t.Host), ".")) == "" {
+		return false
+	}
+	if len(r.Methods) != 0 && !contains(r.Methods, t.Method, true) {
+		return false
+	}
+	if len(r.ContentTypes) != 0 && !matchContentType(r.ContentTypes, t.ContentType) {
+		return false
+	}
+	if r.UserAgent != nil && !r.UserAgent.MatchString(t.UserAgent) {
+		return false
+	}
+	if r.MinSize >= 0 && (t.Size < 0 || t.Size < r.MinSize) {
+		return false
+	}
+	if r.MaxSize >= 0 && (t.Size < 0 || t.Size > r.MaxSize) {
+		return false
+	}
+	return true
+}
+
+func contains(values []string, value string, foldCase bool) bool {
+	for _, v := range values {
+		if v == value || (foldCase && strings.EqualFold(v, value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// inNetworks reports whether the address (an IP, with or without a port) is in one of the networks
+func inNetworks(networks []*net.IPNet, address string) bool {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	ip := net.ParseIP(strings.Trim(address, "[]"))
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchContentType reports whether the media type of the content type (without its parameters) matches one of
+// the patterns, ex: image/* matches image/png
+func matchContentType(patterns []string, contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if mediaType == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), mediaType); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse creates the rule from the keys of its section as they're in the config file
+func Parse(name string, keys map[string]interface{}) (*Rule, error) {
+	rule := &Rule{Name: name, MinSize: -1, MaxSize: -1}
+	var err error
+	for key, value := range keys {
+		switch key {
+		case "services":
+			rule.Services, err = stringSlice(value)
+		case "icap_clients":
+			rule.ICAPClients, err = networks(value)
+		case "client_ips":
+			rule.ClientIPs, err = networks(value)
+		case "hosts":
+			rule.Hosts, err = stringSlice(value)
+		case "methods":
+			rule.Methods, err = stringSlice(value)
+		case "content_types":
+			rule.ContentTypes, err = stringSlice(value)
+		case "user_agent":
+			rule.UserAgent, err = regexp.Compile(fmt.Sprint(value))
+		case "min_size":
+			rule.MinSize, err = size(value)
+		case "max_size":
+			rule.MaxSize, err = size(value)
+		case "action":
+			rule.Action = strings.ToLower(fmt.Sprint(value))
+		case "service":
+			rule.Service = fmt.Sprint(value)
+		default:
+			err = errors.New("unknown key")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s policy: %s: %w", name, key, err)
+		}
+	}
+	switch rule.Action {
+	case ActionBypass, ActionBlock, ActionShadow:
+	case ActionService:
+		if rule.Service == "" {
+			return nil, errors.New(name + " policy: the service action needs a service")
+		}
+	default:
+		return nil, errors.New(name + " policy: the action must be bypass, service, block or shadow")
+	}
+	if rule.MinSize >= 0 && rule.MaxSize >= 0 && rule.MinSize > rule.MaxSize {
+		return nil, errors.New(name + " policy: min_size is greater than max_size")
+	}
+	return rule, nil
+}
+
+// ReadFile reads the rules of a policy file, every section of the file is a rule with the keys of the rules
+// of config.toml
+func ReadFile(file string) (map[string]map[string]interface{}, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	v.SetConfigType("toml")
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	sections := make(map[string]map[string]interface{})
+	for name, value := range v.AllSettings() {
+		keys, isTable := value.(map[string]interface{})
+		if !isTable {
+			return nil, errors.New("the key " + name + " of the policy file isn't in a rule section")
+		}
+		sections[name] = keys
+	}
+	return sections, nil
+}
+
+// Sort orders the rules by their names, the first rule which matches a transaction applies
+func Sort(rules []*Rule) {
+	sort.Slice(rules, func(a, b int) bool { return rules[a].Name < rules[b].Name })
+}
+
+func stringSlice(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, element := range v {
+			result = append(result, strings.TrimSpace(fmt.Sprint(element)))
+		}
+		return result, nil
+	case []string:
+		return v, nil
+	case string:
+		return strings.Fields(strings.ReplaceAll(v, ",", " ")), nil
+	}
+	return nil, errors.New("it must be an array")
+}
+
+// networks parses the IPs and the CIDRs, an IP is a network of its own
+func networks(value interface{}) ([]*net.IPNet, error) {
+	entries, err := stringSlice(value)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.New(entry + " isn't an IP or a CIDR")
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.New(entry + " isn't an IP or a CIDR")
+		}
+		result = append(result, network)
+	}
+	return result, nil
+}
+
+func size(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	}
+	return 0, errors.New("it must be a number of bytes")
+}
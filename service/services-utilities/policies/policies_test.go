@@ -0,0 +1,91 @@
+package policies
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRuleMatches(t *testing.T) {
+	rule, err := Parse("office", map[string]interface{}{
+		"icap_clients":  []interface{}{"10.1.0.0/16", "192.168.1.5"},
+		"hosts":         []interface{}{"example.com"},
+		"methods":       []interface{}{"GET"},
+		"content_types": []interface{}{"image/*"},
+		"max_size":      int64(1024),
+		"action":        "bypass",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	matching := Transaction{ICAPClient: "10.1.2.3:53211", Host: "cdn.example.com", Method: "get",
+		ContentType: "image/png; charset=binary", Size: 512}
+	if !rule.Matches(matching) {
+		t.Fatal("the transaction should match all the conditions")
+	}
+	tests := map[string]func(t *Transaction){
+		"another ICAP client": func(t *Transaction) { t.ICAPClient = "10.2.0.1:1000" },
+		"another host":        func(t *Transaction) { t.Host = "example.org" },
+		"another method":      func(t *Transaction) { t.Method = "POST" },
+		"another type":        func(t *Transaction) { t.ContentType = "application/pdf" },
+		"a larger body":       func(t *Transaction) { t.Size = 2048 },
+		"an unknown size":     func(t *Transaction) { t.Size = -1 },
+	}
+	for name, change := range tests {
+		transaction := matching
+		change(&transaction)
+		if rule.Matches(transaction) {
+			t.Errorf("%s shouldn't match", name)
+		}
+	}
+	single := matching
+	single.ICAPClient = "192.168.1.5"
+	if !rule.Matches(single) {
+		t.Error("an IP should be a network of its own")
+	}
+}
+
+func TestFirst(t *testing.T) {
+	scanners, _ := Parse("a-scanners", map[string]interface{}{"user_agent": "(?i)^curl", "action": "service",
+		"service": "strict"})
+	everything, _ := Parse("z-default", map[string]interface{}{"services": []interface{}{"clamav"}, "action": "shadow"})
+	rules := []*Rule{everything, scanners}
+	Sort(rules)
+	if rule := First(rules, Transaction{Service: "clamav", UserAgent: "curl/8.0"}); rule != scanners {
+		t.Fatalf("the first rule in the order of their names should apply, got %v", rule)
+	}
+	if rule := First(rules, Transaction{Service: "clamav", UserAgent: "Mozilla/5.0"}); rule != everything {
+		t.Fatalf("the rule without other conditions should match the service, got %v", rule)
+	}
+	if rule := First(rules, Transaction{Service: "echo"}); rule != nil {
+		t.Fatalf("no rule should match another service, got %v", rule)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	invalid := []map[string]interface{}{
+		{"action": "allow"},
+		{"action": "service"},
+		{"action": "block", "client_ips": []interface{}{"10.0.0.0/33"}},
+		{"action": "block", "min_size": int64(10), "max_size": int64(5)},
+		{"action": "block", "source": "10.0.0.1"},
+	}
+	for _, keys := range invalid {
+		if _, err := Parse("invalid", keys); err == nil {
+			t.Errorf("Parse(%v) should fail", keys)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policies.toml")
+	os.WriteFile(file, []byte("[updates]\nhosts = [\"windowsupdate.com\"]\naction = \"bypass\"\n"), 0644)
+	sections, err := ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, err := Parse("updates", sections["updates"])
+	if err != nil || rule.Action != ActionBypass || len(rule.Hosts) != 1 {
+		t.Fatalf("the rule of the policy file = %+v, %v", rule, err)
+	}
+}
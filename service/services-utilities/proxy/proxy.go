@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"errors"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Config represents the outbound proxy of the API calls of a vendor
+type Config struct {
+	URL       *url.URL // nil = the vendor API is reached directly
+	NoProxy   []string // the hosts, domains (.example.com), IP addresses and CIDR ranges which are reached directly
+	transport *http.Transport
+}
+
+var (
+	global  *Config
+	vendors = make(map[string]*Config)
+)
+
+// InitOutboundProxy reads the optional [app.outbound_proxy] section and its sub section of every vendor which
+// has its own proxy, the vendor API calls use the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables
+// if it doesn't exist
+func InitOutboundProxy() {
+	if !readValues.IsSecExists("app.outbound_proxy") || !readValues.ReadValuesBool("app.outbound_proxy.enabled") {
+		return
+	}
+	c, err := read("app.outbound_proxy")
+	if err != nil {
+		logging.Logger.Error("invalid outbound_proxy, the vendor API calls use the proxy environment variables: " +
+			err.Error())
+		return
+	}
+	global = c
+	logging.Logger.Debug("the vendor APIs are reached " + c.via())
+	for _, vendor := range readValues.ReadSubSections("app.outbound_proxy") {
+		c, err := read("app.outbound_proxy." + vendor)
+		if err != nil {
+			logging.Logger.Error("invalid outbound_proxy of " + vendor + " vendor, its API calls use the global one: " +
+				err.Error())
+			continue
+		}
+		logging.Logger.Debug("the API of " + vendor + " vendor is reached " + c.via())
+		vendors[strings.ToLower(vendor)] = c
+	}
+}
+
+// read reads the proxy of a section, the url is required and an empty url means direct connections
+func read(sec string) (*Config, error) {
+	var username, password string
+	var noProxy []string
+	if readValues.IsSecExists(sec + ".username") {
+		username = readValues.ReadValuesString(sec + ".username")
+	}
+	if readValues.IsSecExists(sec + ".password") {
+		password = readValues.ReadValuesString(sec + ".password")
+	}
+	if readValues.IsSecExists(sec + ".no_proxy") {
+		noProxy = readValues.ReadValuesSlice(sec + ".no_proxy")
+	}
+	return New(readValues.ReadValuesString(sec+".url"), username, password, noProxy)
+}
+
+// New creates the proxy configuration of rawURL (http, https or socks5), the username and the password
+// authenticate to the proxy and override the ones of the URL. An empty rawURL creates a configuration of
+// direct connections
+func New(rawURL, username, password string, noProxy []string) (*Config, error) {
+	c := &Config{NoProxy: noProxy}
+	if rawURL != "" {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, errors.New("the scheme of the proxy " + u.Redacted() + " must be http, https or socks5")
+		}
+		if u.Host == "" {
+			return nil, errors.New("the proxy " + u.Redacted() + " has no host")
+		}
+		if username != "" {
+			u.User = url.UserPassword(username, password)
+		}
+		c.URL = u
+	}
+	c.transport = http.DefaultTransport.(*http.Transport).Clone()
+	c.transport.Proxy = c.Proxy
+	return c, nil
+}
+
+// Transport returns the transport of the API calls of the vendor, it uses the proxy of the vendor or the global
+// one, and the proxy environment variables if there's none
+func Transport(vendor string) http.RoundTripper {
+	if c, exists := vendors[strings.ToLower(vendor)]; exists {
+		return c.transport
+	}
+	if global != nil {
+		return global.transport
+	}
+	return http.DefaultTransport
+}
+
+// Proxy returns the proxy of the request, nil if its host is reached directly, it's the Proxy of the transport
+func (c *Config) Proxy(req *http.Request) (*url.URL, error) {
+	if c.URL == nil || c.bypassed(req.URL) {
+		return nil, nil
+	}
+	return c.URL, nil
+}
+
+// via describes the connections of the configuration in the logs, without the password of the proxy
+func (c *Config) via() string {
+	if c.URL == nil {
+		return "directly"
+	}
+	return "through " + c.URL.Redacted()
+}
+
+// bypassed reports whether the URL matches an entry of NoProxy, a domain matches its sub domains too, and a
+// domain which starts with a dot matches its sub domains only. An entry with a port matches that port only
+func (c *Config) bypassed(u *url.URL) bool {
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	ip := net.ParseIP(host)
+	for _, entry := range c.NoProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if entryHost, entryPort, err := net.SplitHostPort(entry); err == nil {
+			if entryPort != port {
+				continue
+			}
+			entry = entryHost
+		}
+		entry = strings.Trim(entry, "[]")
+		if entryIP := net.ParseIP(entry); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		if strings.HasPrefix(entry, ".") {
+			if strings.HasSuffix(host, entry) {
+				return true
+			}
+			continue
+		}
+		if entry != "" && (host == entry || strings.HasSuffix(host, "."+entry)) {
+			return true
+		}
+	}
+	return false
+}
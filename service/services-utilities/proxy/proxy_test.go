@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestProxyNoProxy(t *testing.T) {
+	c, err := New("http://proxy.internal:3128", "icapeg", "secret",
+		[]string{"localhost", ".corp.example", "example.org", "10.0.0.0/8", "::1", "api.vendor.com:8443"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.URL.User.Username() != "icapeg" {
+		t.Fatalf("the proxy credentials should be set, got %v", c.URL.User)
+	}
+	tests := map[string]bool{
+		"https://hashlookup.circl.lu/lookup": false,
+		"http://localhost:8080/scan":         true,
+		"https://scan.corp.example/":         true,
+		"https://corp.example/":              false,
+		"https://example.org/":               true,
+		"https://api.example.org/":           true,
+		"http://10.1.2.3/":                   true,
+		"http://[::1]:9000/":                 true,
+		"https://api.vendor.com:8443/":       true,
+		"https://api.vendor.com/":            false,
+	}
+	for rawURL, direct := range tests {
+		req, _ := http.NewRequest(http.MethodGet, rawURL, nil)
+		proxyURL, _ := c.Proxy(req)
+		if (proxyURL == nil) != direct {
+			t.Errorf("%s: expected direct=%v, got proxy %v", rawURL, direct, proxyURL)
+		}
+	}
+	if _, err := New("ftp://proxy.internal", "", "", nil); err == nil {
+		t.Fatal("a proxy of an unsupported scheme should be rejected")
+	}
+}
@@ -0,0 +1,85 @@
+package quarantine
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// localBackend stores the objects in a directory, the slashes of their keys are subdirectories
+type localBackend struct {
+	dir string
+}
+
+func newLocalBackend(dir string) (*localBackend, error) {
+	if dir == "" {
+		return nil, errors.New("the local backend needs a dir")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &localBackend{dir: dir}, nil
+}
+
+// Put writes the object to a temporary file which is renamed, so a sweep never sees a partial object
+func (b *localBackend) Put(key string, body io.Reader, size int64, contentType string) error {
+	name := filepath.Join(b.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(name), ".partial-")
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, body); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), name)
+}
+
+func (b *localBackend) List(prefix string) ([]Object, error) {
+	var objects []Object
+	err := filepath.WalkDir(b.dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || strings.HasPrefix(entry.Name(), ".partial-") {
+			return err
+		}
+		rel, err := filepath.Rel(b.dir, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		objects = append(objects, Object{Key: key, Size: info.Size(), Modified: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+// Delete removes the object and the day directories which it leaves empty
+func (b *localBackend) Delete(key string) error {
+	name := filepath.Join(b.dir, filepath.FromSlash(key))
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := filepath.Dir(name); dir != b.dir && strings.HasPrefix(dir, b.dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package quarantine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the backends of the quarantines
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+	BackendMinIO = "minio" // S3 with path-style URLs, for MinIO and the other S3 compatible stores
+)
+
+// the extensions of the objects of a quarantined sample, the metadata is stored even if the payload isn't
+const (
+	PayloadExt  = ".bin"
+	MetadataExt = ".json"
+)
+
+// the sweep interval of the retention and the total size of the quarantines, and the timeout of the S3 calls
+// of a quarantine which has none
+const (
+	sweepInterval  = time.Hour
+	defaultTimeout = 30 * time.Second
+)
+
+// Config represents [<service>.quarantine] section configuration
+type Config struct {
+	Backend      string
+	Dir          string // of the local backend
+	Endpoint     string // of the S3 and MinIO backends, the regional AWS endpoint if it's empty
+	Bucket       string
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	Prefix       string        // the prefix of the object keys, ex: "icapeg/"
+	Retention    time.Duration // the samples which are older are deleted, 0 = they're kept
+	MaxFileSize  int64         // the larger payloads aren't stored, only their metadata, 0 = unlimited
+	MaxTotalSize int64         // the oldest samples are deleted above it, 0 = unlimited
+	Timeout      time.Duration
+}
+
+// Sample is the metadata of a quarantined file, it's stored next to its payload
+type Sample struct {
+	Time          time.Time `json:"time"`
+	XICAPMetadata string    `json:"x_icap_metadata"`
+	ServiceName   string    `json:"service"`
+	Vendor        string    `json:"vendor"`
+	Method        string    `json:"method"`
+	Verdict       string    `json:"verdict"`
+	Threat        string    `json:"threat,omitempty"`
+	ClientIP      string    `json:"client_ip,omitempty"`
+	Username      string    `json:"username,omitempty"`
+	RequestedURL  string    `json:"url,omitempty"`
+	FileName      string    `json:"file_name,omitempty"`
+	SHA256        string    `json:"sha256,omitempty"`
+	FileSize      int64     `json:"file_size"`
+	Payload       string    `json:"payload,omitempty"`     // the key of the stored payload
+	SkipReason    string    `json:"skip_reason,omitempty"` // why the payload wasn't stored
+}
+
+// Object is a stored object of a backend
+type Object struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// Backend stores the objects of the quarantine of a service
+type Backend interface {
+	Put(key string, body io.Reader, size int64, contentType string) error
+	// List returns the objects whose keys start with the prefix
+	List(prefix string) ([]Object, error)
+	Delete(key string) error
+}
+
+// Quarantine stores the blocked files of a service with their metadata
+type Quarantine struct {
+	cfg     *Config
+	backend Backend
+	sweepMu sync.Mutex
+}
+
+var (
+	mu          sync.RWMutex
+	quarantines = make(map[string]*Quarantine)
+)
+
+// InitQuarantine reads the optional [<service>.quarantine] section of every service, the blocked files of a
+// service without that section are discarded
+func InitQuarantine(services []string) {
+	for _, serviceName := range services {
+		section := serviceName + ".quarantine"
+		if !readValues.IsSecExists(section) || !readValues.ReadValuesBool(section+".enabled") {
+			continue
+		}
+		logging.Logger.Debug("loading " + serviceName + " quarantine configurations")
+		cfg := &Config{Backend: readValues.ReadValuesString(section + ".backend"), Timeout: defaultTimeout}
+		optionalString := func(key string) string {
+			if readValues.IsSecExists(section + "." + key) {
+				return readValues.ReadValuesString(section + "." + key)
+			}
+			return ""
+		}
+		cfg.Dir, cfg.Endpoint, cfg.Bucket = optionalString("dir"), optionalString("endpoint"), optionalString("bucket")
+		cfg.Region, cfg.Prefix = optionalString("region"), optionalString("prefix")
+		cfg.AccessKey, cfg.SecretKey = optionalString("access_key"), optionalString("secret_key")
+		if readValues.IsSecExists(section + ".retention") {
+			cfg.Retention = readValues.ReadValuesDuration(section+".retention") * time.Second
+		}
+		if readValues.IsSecExists(section + ".max_filesize") {
+			cfg.MaxFileSize = int64(readValues.ReadValuesInt(section + ".max_filesize"))
+		}
+		if readValues.IsSecExists(section + ".max_total_size") {
+			cfg.MaxTotalSize = int64(readValues.ReadValuesInt(section + ".max_total_size"))
+		}
+		if readValues.IsSecExists(section + ".timeout") {
+			if timeout := readValues.ReadValuesDuration(section+".timeout") * time.Second; timeout > 0 {
+				cfg.Timeout = timeout
+			}
+		}
+		q, err := New(cfg)
+		if err != nil {
+			logging.Logger.Error("the quarantine of " + serviceName + " service is disabled: " + err.Error())
+			continue
+		}
+		Register(serviceName, q)
+		go func() {
+			for {
+				q.Sweep()
+				time.Sleep(sweepInterval)
+			}
+		}()
+	}
+}
+
+// New creates the quarantine of the backend of the configuration
+func New(cfg *Config) (*Quarantine, error) {
+	var backend Backend
+	var err error
+	switch cfg.Backend {
+	case BackendLocal:
+		backend, err = newLocalBackend(cfg.Dir)
+	case BackendS3, BackendMinIO:
+		backend, err = newS3Backend(cfg)
+	default:
+		return nil, fmt.Errorf("the backend must be %s, %s or %s", BackendLocal, BackendS3, BackendMinIO)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Quarantine{cfg: cfg, backend: backend}, nil
+}
+
+// Register makes the quarantine the one of the service
+func Register(serviceName string, q *Quarantine) {
+	mu.Lock()
+	defer mu.Unlock()
+	quarantines[serviceName] = q
+}
+
+// Of returns the quarantine of the service, nil if it has none
+func Of(serviceName string) *Quarantine {
+	mu.RLock()
+	defer mu.RUnlock()
+	return quarantines[serviceName]
+}
+
+// Store writes the payload and the metadata of a blocked file, the payload is opened from its start and it
+// isn't stored if it's larger than max_filesize. The objects are named after the time and the X-ICAP-Metadata
+// of the transaction, ex: 2024/05/17/20240517T101500.123456789-<X-ICAP-Metadata>.bin
+func (q *Quarantine) Store(sample *Sample, open func() io.ReadCloser) error {
+	if sample.Time.IsZero() {
+		sample.Time = time.Now()
+	}
+	at := sample.Time.UTC()
+	key := q.cfg.Prefix + at.Format("2006/01/02/20060102T150405.000000000") + "-" + sample.XICAPMetadata
+	switch {
+	case open == nil:
+		sample.SkipReason = "the HTTP message has no body"
+	case q.cfg.MaxFileSize > 0 && sample.FileSize > q.cfg.MaxFileSize:
+		sample.SkipReason = "the file is larger than max_filesize"
+	default:
+		payload := open()
+		err := q.backend.Put(key+PayloadExt, payload, sample.FileSize, "application/octet-stream")
+		payload.Close()
+		if err != nil {
+			return err
+		}
+		sample.Payload = key + PayloadExt
+	}
+	metadata, err := json.MarshalIndent(sample, "", "  ")
+	if err != nil {
+		return err
+	}
+	return q.backend.Put(key+MetadataExt, bytes.NewReader(metadata), int64(len(metadata)), "application/json")
+}
+
+// Sweep deletes the samples which are older than the retention, then the oldest samples while the quarantine is
+// larger than max_total_size
+func (q *Quarantine) Sweep() {
+	if q.cfg.Retention <= 0 && q.cfg.MaxTotalSize <= 0 {
+		return
+	}
+	q.sweepMu.Lock()
+	defer q.sweepMu.Unlock()
+	objects, err := q.backend.List(q.cfg.Prefix)
+	if err != nil {
+		logging.Logger.Error("couldn't list the quarantined files: " + err.Error())
+		return
+	}
+	// the keys start with the time of their samples
+	sort.Slice(objects, func(a, b int) bool { return objects[a].Key < objects[b].Key })
+	var total int64
+	for _, object := range objects {
+		total += object.Size
+	}
+	deleted := 0
+	for _, object := range objects {
+		expired := q.cfg.Retention > 0 && time.Since(object.Modified) > q.cfg.Retention
+		if !expired && (q.cfg.MaxTotalSize <= 0 || total <= q.cfg.MaxTotalSize) {
+			break
+		}
+		if err = q.backend.Delete(object.Key); err != nil {
+			logging.Logger.Error("couldn't delete the quarantined file " + object.Key + ": " + err.Error())
+			return
+		}
+		total -= object.Size
+		deleted++
+	}
+	if deleted != 0 {
+		logging.Logger.Info("deleted " + strconv.Itoa(deleted) + " quarantined objects upon the retention " +
+			"and the max total size")
+	}
+}
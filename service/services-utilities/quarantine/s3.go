@@ -0,0 +1,247 @@
+package quarantine
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"icapeg/service/services-utilities/proxy"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the name of the outbound proxy of the S3 calls, a [app.outbound_proxy.quarantine] section gives them their own
+const proxyName = "quarantine"
+
+// the region of the S3 backends which have none, and the payload hash of the uploads which are streamed
+const (
+	defaultRegion   = "us-east-1"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	emptyPayload    = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// s3Backend stores the objects in a bucket of S3 or of an S3 compatible store, the requests are signed with
+// AWS Signature Version 4
+type s3Backend struct {
+	endpoint  *url.URL
+	bucket    string
+	pathStyle bool // the bucket is in the path instead of the host
+	region    string
+	accessKey string
+	secretKey string
+	timeout   time.Duration
+	now       func() time.Time
+}
+
+func newS3Backend(cfg *Config) (*s3Backend, error) {
+	b := &s3Backend{bucket: cfg.Bucket, pathStyle: cfg.Backend == BackendMinIO, region: cfg.Region,
+		accessKey: cfg.AccessKey, secretKey: cfg.SecretKey, timeout: cfg.Timeout, now: time.Now}
+	if b.bucket == "" || b.accessKey == "" || b.secretKey == "" {
+		return nil, errors.New("the " + cfg.Backend + " backend needs a bucket, an access_key and a secret_key")
+	}
+	if b.region == "" {
+		b.region = defaultRegion
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		if b.pathStyle {
+			return nil, errors.New("the minio backend needs an endpoint")
+		}
+		endpoint = "https://s3." + b.region + ".amazonaws.com"
+	}
+	var err error
+	if b.endpoint, err = url.Parse(strings.TrimSuffix(endpoint, "/")); err != nil {
+		return nil, err
+	}
+	if b.endpoint.Scheme != "http" && b.endpoint.Scheme != "https" {
+		return nil, errors.New("the endpoint must be an http or https URL")
+	}
+	return b, nil
+}
+
+// objectURL returns the URL of the object, the URL of the bucket if the key is empty
+func (b *s3Backend) objectURL(key string) *url.URL {
+	u := *b.endpoint
+	if b.pathStyle {
+		u.Path += "/" + b.bucket
+	} else {
+		u.Host = b.bucket + "." + u.Host
+	}
+	u.Path += "/" + key
+	u.RawPath = ""
+	return &u
+}
+
+func (b *s3Backend) Put(key string, body io.Reader, size int64, contentType string) error {
+	req, err := http.NewRequest(http.MethodPut, b.objectURL(key).String(), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	_, err = b.do(req, unsignedPayload)
+	return err
+}
+
+func (b *s3Backend) Delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, b.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	_, err = b.do(req, emptyPayload)
+	return err
+}
+
+// listResult is the part of the ListObjectsV2 response which is read
+type listResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List lists the objects with ListObjectsV2, page after page
+func (b *s3Backend) List(prefix string) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		u := b.objectURL("")
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = query.Encode()
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := b.do(req, emptyPayload)
+		if err != nil {
+			return nil, err
+		}
+		var result listResult
+		if err = xml.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+		for _, content := range result.Contents {
+			objects = append(objects, Object{Key: content.Key, Size: content.Size, Modified: content.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// do signs and sends the request, it returns the body of a 2xx response
+func (b *s3Backend) do(req *http.Request, payloadHash string) ([]byte, error) {
+	b.sign(req, payloadHash)
+	client := &http.Client{Transport: proxy.Transport(proxyName), Timeout: b.timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		var s3Err struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		if xml.Unmarshal(body, &s3Err) == nil && s3Err.Code != "" {
+			return nil, errors.New(req.Method + " " + req.URL.Path + ": " + resp.Status + ": " + s3Err.Code + ": " + s3Err.Message)
+		}
+		return nil, errors.New(req.Method + " " + req.URL.Path + ": " + resp.Status)
+	}
+	return body, nil
+}
+
+// sign adds the Authorization header of AWS Signature Version 4 to the request, every header of the request
+// is signed with the host
+func (b *s3Backend) sign(req *http.Request, payloadHash string) {
+	now := b.now().UTC()
+	amzDate, date := now.Format("20060102T150405Z"), now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{req.Method, uriEncode(req.URL.Path, false), canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+	scope := date + "/" + b.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256(canonicalRequest)
+	key := hmacSHA256([]byte("AWS4"+b.secretKey), date)
+	for _, part := range []string{b.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+b.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode encodes every byte except the unreserved characters, the slashes are kept unless encodeSlash
+func uriEncode(s string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			encoded.WriteByte(c)
+			continue
+		}
+		encoded.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+	}
+	return encoded.String()
+}
+
+func hexSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
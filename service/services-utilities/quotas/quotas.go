@@ -0,0 +1,176 @@
+package quotas
+
+import (
+	"icapeg/logging"
+	"icapeg/readValues"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the number of buckets of the rolling window, the bytes of a bucket are dropped at once when it leaves the window
+const bucketCount = 60
+
+// the keys which the scanned bytes are counted by
+const (
+	PerClient = "client"
+	PerTenant = "tenant"
+)
+
+// the answers to the requests of the keys which exceeded their quotas
+const (
+	ActionBypass = "bypass"
+	ActionBlock  = "block"
+)
+
+type bucket struct {
+	start int64 // the index of the bucket since the epoch
+	bytes map[string]uint64
+}
+
+// Window counts the scanned bytes of the keys in a rolling window
+type Window struct {
+	mu         sync.Mutex
+	bucketSize time.Duration
+	buckets    [bucketCount]bucket
+	now        func() time.Time
+}
+
+// Quotas represents [app.data_quotas] section configuration and the bytes which were scanned for every key
+type Quotas struct {
+	Per      string
+	Action   string
+	MaxBytes uint64            // 0 = unlimited
+	Limits   map[string]uint64 // the quotas of single clients or tenants instead of max_bytes, 0 = unlimited
+	window   *Window
+}
+
+var quotas *Quotas
+
+// InitQuotas reads the optional [app.data_quotas] section, the scanned bytes aren't limited if it doesn't exist
+func InitQuotas() {
+	if !readValues.IsSecExists("app.data_quotas") || !readValues.ReadValuesBool("app.data_quotas.enabled") {
+		return
+	}
+	size := readValues.ReadValuesDuration("app.data_quotas.window") * time.Second
+	if size <= 0 {
+		logging.Logger.Error("data_quotas window must be positive, the scanned bytes aren't limited")
+		return
+	}
+	per := strings.ToLower(readValues.ReadValuesString("app.data_quotas.per"))
+	if per != PerClient && per != PerTenant {
+		logging.Logger.Error("data_quotas per must be " + PerClient + " or " + PerTenant +
+			", the scanned bytes aren't limited")
+		return
+	}
+	action := strings.ToLower(readValues.ReadValuesString("app.data_quotas.action"))
+	if action != ActionBypass && action != ActionBlock {
+		logging.Logger.Error("data_quotas action must be " + ActionBypass + " or " + ActionBlock +
+			", the scanned bytes aren't limited")
+		return
+	}
+	maxBytes := readValues.ReadValuesInt("app.data_quotas.max_bytes")
+	if maxBytes < 0 {
+		logging.Logger.Error("data_quotas max_bytes must not be negative, the scanned bytes aren't limited")
+		return
+	}
+
+	limits := make(map[string]uint64)
+	if readValues.IsSecExists("app.data_quotas.limits") {
+		for key, value := range readValues.ReadValuesMap("app.data_quotas.limits") {
+			limit, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				logging.Logger.Error("data_quotas limit of " + key + " is not valid")
+				continue
+			}
+			limits[key] = limit
+		}
+	}
+	quotas = New(per, action, uint64(maxBytes), limits, size)
+}
+
+// New creates the quotas of the keys over a rolling window of the size
+func New(per, action string, maxBytes uint64, limits map[string]uint64, size time.Duration) *Quotas {
+	return &Quotas{Per: per, Action: action, MaxBytes: maxBytes, Limits: limits, window: NewWindow(size)}
+}
+
+// NewWindow creates a rolling window of the size
+func NewWindow(size time.Duration) *Window {
+	bucketSize := size / bucketCount
+	if bucketSize <= 0 {
+		bucketSize = 1
+	}
+	return &Window{bucketSize: bucketSize, now: time.Now}
+}
+
+// Current returns the quotas, it returns nil if the scanned bytes aren't limited
+func Current() *Quotas {
+	return quotas
+}
+
+// Limit returns the quota of the key, 0 = unlimited
+func (q *Quotas) Limit(key string) uint64 {
+	if limit, exists := q.Limits[strings.ToLower(key)]; exists {
+		return limit
+	}
+	return q.MaxBytes
+}
+
+// Exceeded reports whether the bytes which were scanned for the key in the window reached its quota, with
+// the bytes and the quota
+func (q *Quotas) Exceeded(key string) (bool, uint64, uint64) {
+	limit := q.Limit(key)
+	if key == "" || limit == 0 {
+		return false, 0, limit
+	}
+	used := q.window.Used(key)
+	return used >= limit, used, limit
+}
+
+// Add counts the bytes which were scanned for the key
+func (q *Quotas) Add(key string, bytesScanned int) {
+	if bytesScanned > 0 {
+		q.window.Add(key, uint64(bytesScanned))
+	}
+}
+
+// Window returns the size of the rolling window
+func (q *Quotas) Window() time.Duration {
+	return q.window.bucketSize * bucketCount
+}
+
+// Add adds the bytes of the key to the current bucket
+func (w *Window) Add(key string, bytesScanned uint64) {
+	if key == "" {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.current().bytes[key] += bytesScanned
+}
+
+// current returns the bucket of the current time, it's cleared if it belonged to an earlier round of the window
+func (w *Window) current() *bucket {
+	start := w.now().UnixNano() / int64(w.bucketSize)
+	b := &w.buckets[start%bucketCount]
+	if b.start != start || b.bytes == nil {
+		b.start = start
+		b.bytes = make(map[string]uint64)
+	}
+	return b
+}
+
+// Used returns the bytes of the key in the window
+func (w *Window) Used(key string) uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	oldest := w.now().UnixNano()/int64(w.bucketSize) - bucketCount + 1
+	var used uint64
+	for i := range w.buckets {
+		if b := &w.buckets[i]; b.start >= oldest {
+			used += b.bytes[key]
+		}
+	}
+	return used
+}
@@ -0,0 +1,38 @@
+package quotas
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuotaExceeded(t *testing.T) {
+	q := New(PerClient, ActionBlock, 1000, map[string]uint64{"10.0.0.2": 0}, time.Minute)
+	q.Add("10.0.0.1", 600)
+	if exceeded, used, _ := q.Exceeded("10.0.0.1"); exceeded || used != 600 {
+		t.Fatalf("the quota shouldn't be exceeded yet, used %d", used)
+	}
+	q.Add("10.0.0.1", 400)
+	if exceeded, used, limit := q.Exceeded("10.0.0.1"); !exceeded || used != 1000 || limit != 1000 {
+		t.Fatalf("the quota should be exceeded, used %d of %d", used, limit)
+	}
+	q.Add("10.0.0.2", 5000)
+	if exceeded, _, _ := q.Exceeded("10.0.0.2"); exceeded {
+		t.Fatal("a limit of zero should be unlimited")
+	}
+}
+
+func TestQuotaRollingWindow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	q := New(PerTenant, ActionBypass, 100, nil, time.Minute)
+	q.window.now = func() time.Time { return now }
+	q.Add("acme", 100)
+	if exceeded, _, _ := q.Exceeded("acme"); !exceeded {
+		t.Fatal("the quota should be exceeded")
+	}
+	now = now.Add(30 * time.Second)
+	q.Add("acme", 10)
+	now = now.Add(45 * time.Second)
+	if exceeded, used, _ := q.Exceeded("acme"); exceeded || used != 10 {
+		t.Fatalf("the first bytes should have left the window, used %d", used)
+	}
+}
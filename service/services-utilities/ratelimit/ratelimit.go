@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"icapeg/logging"
+	"icapeg/readValues"
+	"math"
+	"sync"
+	"time"
+)
+
+// the idle buckets are removed every sweepInterval, a bucket which refilled up to its burst is idle
+const sweepInterval = time.Minute
+
+// Limiter limits the requests per second of every ICAP client of a service, every client has its own
+// token bucket which is refilled with rate requests per second up to burst requests
+type Limiter struct {
+	mu         sync.Mutex
+	rate       float64
+	burst      float64
+	retryAfter time.Duration
+	buckets    map[string]*bucket
+	swept      time.Time
+	now        func() time.Time
+}
+
+type bucket struct {
+	tokens   float64
+	refilled time.Time
+}
+
+var (
+	limitersMu sync.RWMutex
+	limiters   = make(map[string]*Limiter)
+)
+
+// InitRateLimits reads the optional [<service>.rate_limit] section of every service, the requests of a service
+// without that section aren't limited
+func InitRateLimits(services []string) {
+	for _, serviceName := range services {
+		sec := serviceName + ".rate_limit"
+		if !readValues.IsSecExists(sec) || !readValues.ReadValuesBool(sec+".enabled") {
+			continue
+		}
+		rate := readValues.ReadValuesInt(sec + ".requests_per_second")
+		burst := readValues.ReadValuesInt(sec + ".burst")
+		if rate <= 0 {
+			logging.Logger.Error("rate_limit requests_per_second of " + serviceName +
+				" service must be positive, its requests aren't limited")
+			continue
+		}
+		logging.Logger.Debug("loading " + serviceName + " rate limit configurations")
+		l := New(rate, burst)
+		l.retryAfter = readValues.ReadValuesDuration(sec+".retry_after") * time.Second
+		Register(serviceName, l)
+	}
+}
+
+// New creates a limiter of requests per second per client, a burst below the rate is the rate
+func New(requestsPerSecond, burst int) *Limiter {
+	if burst < requestsPerSecond {
+		burst = requestsPerSecond
+	}
+	return &Limiter{rate: float64(requestsPerSecond), burst: float64(burst), buckets: make(map[string]*bucket),
+		swept: time.Now(), now: time.Now}
+}
+
+// Register sets the limiter of a service
+func Register(serviceName string, l *Limiter) {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	limiters[serviceName] = l
+}
+
+// Allow takes a request of the client from the limiter of the service, it returns false and how long the client
+// should wait before retrying if the client exceeded its rate
+func Allow(serviceName, client string) (bool, time.Duration) {
+	limitersMu.RLock()
+	l, exists := limiters[serviceName]
+	limitersMu.RUnlock()
+	if !exists {
+		return true, 0
+	}
+	return l.Allow(client)
+}
+
+// Allow takes a request of the client from its bucket, it returns false and the Retry-After of the limiter if
+// the bucket is empty, or the time until the bucket has a request again if the limiter doesn't have one
+func (l *Limiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.swept) >= sweepInterval {
+		l.sweep(now)
+	}
+	b, exists := l.buckets[client]
+	if !exists {
+		b = &bucket{tokens: l.burst, refilled: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.refilled).Seconds()*l.rate)
+	b.refilled = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if l.retryAfter > 0 {
+		return false, l.retryAfter
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep removes the buckets of the clients which would be full by now, they're created full again if the
+// clients come back
+func (l *Limiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.refilled).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.swept = now
+}
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterPerClient(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(2, 4)
+	l.now = func() time.Time { return now }
+	for i := 0; i < 4; i++ {
+		if allowed, _ := l.Allow("10.0.0.1"); !allowed {
+			t.Fatalf("the request %d should be in the burst", i)
+		}
+	}
+	allowed, retryAfter := l.Allow("10.0.0.1")
+	if allowed || retryAfter != 500*time.Millisecond {
+		t.Fatalf("the client should wait for the next token, got %v after %v", allowed, retryAfter)
+	}
+	if allowed, _ = l.Allow("10.0.0.2"); !allowed {
+		t.Fatal("another client should have its own bucket")
+	}
+	now = now.Add(time.Second)
+	if allowed, _ = l.Allow("10.0.0.1"); !allowed {
+		t.Fatal("the bucket should be refilled")
+	}
+}
+
+func TestLimiterSweepsIdleClients(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(1, 1)
+	l.now = func() time.Time { return now }
+	l.swept = now
+	l.Allow("10.0.0.1")
+	now = now.Add(sweepInterval)
+	l.Allow("10.0.0.2")
+	if _, exists := l.buckets["10.0.0.1"]; exists || len(l.buckets) != 1 {
+		t.Fatalf("the idle client should be removed, got %d buckets", len(l.buckets))
+	}
+}
+
+func TestLimiterRetryAfter(t *testing.T) {
+	Register("limited", New(1, 1))
+	limiters["limited"].retryAfter = 3 * time.Second
+	Allow("limited", "10.0.0.1")
+	if allowed, retryAfter := Allow("limited", "10.0.0.1"); allowed || retryAfter != 3*time.Second {
+		t.Fatalf("the Retry-After of the service should be returned, got %v", retryAfter)
+	}
+	if allowed, _ := Allow("unlimited", "10.0.0.1"); !allowed {
+		t.Fatal("a service without a rate limit should never be limited")
+	}
+}
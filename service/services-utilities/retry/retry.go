@@ -0,0 +1,259 @@
+package retry
+
+import (
+	"errors"
+	"icapeg/config"
+	"icapeg/events"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Policy represents the retry configuration of a service
+type Policy struct {
+	MaxAttempts     int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	BudgetPerMinute int
+}
+
+// Stats represents the retry metrics of a service
+type Stats struct {
+	Calls           uint64 `json:"calls"`
+	Retries         uint64 `json:"retries"`
+	Recovered       uint64 `json:"recovered"`
+	Failed          uint64 `json:"failed"`
+	BudgetExhausted uint64 `json:"budget_exhausted"`
+}
+
+// StatusError is returned for a vendor HTTP response which is worth retrying (5xx and 429)
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return "vendor responded with " + strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode)
+}
+
+// Retrier retries the vendor calls of a service with exponential backoff and full jitter,
+// the retries are limited by a budget of retries per minute shared by all requests of the service
+type Retrier struct {
+	mu       sync.Mutex
+	policy   Policy
+	tokens   float64
+	refilled time.Time
+	stats    Stats
+	now      func() time.Time
+	sleep    func(time.Duration)
+	random   func(int64) int64
+}
+
+var (
+	retriersMu sync.RWMutex
+	retriers   = make(map[string]*Retrier)
+)
+
+// InitRetryConfig reads the optional [<service>.retry] section, or the retry_count and retry_backoff keys of the
+// service section if it doesn't have one. The vendor calls of the service aren't retried if it has neither
+func InitRetryConfig(serviceName string) {
+	policy := Policy{MaxAttempts: 1}
+	if keys := config.Service(serviceName); keys.Has("retry_count") {
+		// the retries of the keys are spaced by the backoff doubled on every retry, without a retry budget
+		policy = Policy{
+			MaxAttempts: keys.Int("retry_count") + 1,
+			BaseDelay:   keys.Duration("retry_backoff") * time.Millisecond,
+		}
+	}
+	if readValues.IsSecExists(serviceName + ".retry") {
+		logging.Logger.Debug("loading " + serviceName + " retry configurations")
+		policy = Policy{
+			MaxAttempts:     readValues.ReadValuesInt(serviceName + ".retry.max_attempts"),
+			BaseDelay:       readValues.ReadValuesDuration(serviceName+".retry.base_delay") * time.Millisecond,
+			MaxDelay:        readValues.ReadValuesDuration(serviceName+".retry.max_delay") * time.Millisecond,
+			BudgetPerMinute: readValues.ReadValuesInt(serviceName + ".retry.budget_per_minute"),
+		}
+	}
+	Register(serviceName, NewRetrier(policy))
+}
+
+// NewRetrier creates a retrier, max attempts includes the first call and a budget of zero means unlimited retries
+func NewRetrier(policy Policy) *Retrier {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	return &Retrier{
+		policy:   policy,
+		tokens:   float64(policy.BudgetPerMinute),
+		refilled: time.Now(),
+		now:      time.Now,
+		sleep:    time.Sleep,
+		random:   rand.Int63n,
+	}
+}
+
+// Register sets the retrier of a service
+func Register(serviceName string, retrier *Retrier) {
+	retriersMu.Lock()
+	defer retriersMu.Unlock()
+	retriers[serviceName] = retrier
+}
+
+// Do calls fn of the service and retries it while it returns a transient error, a service
+// without a registered retrier calls fn once
+func Do(serviceName string, fn func() error) error {
+	retriersMu.RLock()
+	retrier, exists := retriers[serviceName]
+	retriersMu.RUnlock()
+	var err error
+	if !exists {
+		err = fn()
+	} else {
+		err = retrier.Do(serviceName, fn)
+	}
+	if err == nil {
+		// the vendor of the service is up again if an earlier call couldn't reach it
+		events.VendorReached(serviceName)
+	}
+	return err
+}
+
+// Do calls fn and retries it while it returns a transient error
+func (r *Retrier) Do(serviceName string, fn func() error) error {
+	r.mu.Lock()
+	r.stats.Calls++
+	r.mu.Unlock()
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if err == nil {
+			if attempt > 0 {
+				r.count(func(s *Stats) { s.Recovered++ })
+			}
+			return nil
+		}
+		if !IsTransient(err) || attempt+1 >= r.policy.MaxAttempts {
+			break
+		}
+		if !r.takeToken() {
+			logging.Logger.Warn(serviceName + " retry budget is exhausted, giving up: " + err.Error())
+			r.count(func(s *Stats) { s.BudgetExhausted++ })
+			break
+		}
+		delay := r.backoff(attempt, err)
+		logging.Logger.Debug(serviceName + " transient error, retrying in " + delay.String() + ": " + err.Error())
+		r.count(func(s *Stats) { s.Retries++ })
+		r.sleep(delay)
+	}
+	r.count(func(s *Stats) { s.Failed++ })
+	return err
+}
+
+// backoff returns a random delay between zero and the exponential delay of the attempt,
+// a Retry-After of the vendor is respected up to the max delay
+func (r *Retrier) backoff(attempt int, err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		if r.policy.MaxDelay > 0 && statusErr.RetryAfter > r.policy.MaxDelay {
+			return r.policy.MaxDelay
+		}
+		return statusErr.RetryAfter
+	}
+	delay := r.policy.BaseDelay << uint(attempt)
+	if delay <= 0 || (r.policy.MaxDelay > 0 && delay > r.policy.MaxDelay) {
+		delay = r.policy.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(r.random(int64(delay) + 1))
+}
+
+// takeToken refills the budget according to the elapsed time and consumes a retry from it
+func (r *Retrier) takeToken() bool {
+	if r.policy.BudgetPerMinute <= 0 {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	budget := float64(r.policy.BudgetPerMinute)
+	r.tokens += now.Sub(r.refilled).Minutes() * budget
+	if r.tokens > budget {
+		r.tokens = budget
+	}
+	r.refilled = now
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+func (r *Retrier) count(update func(s *Stats)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	update(&r.stats)
+}
+
+// Stats returns the retry metrics of the retrier
+func (r *Retrier) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// AllStats returns the retry metrics of every service
+func AllStats() map[string]Stats {
+	retriersMu.RLock()
+	defer retriersMu.RUnlock()
+	result := make(map[string]Stats, len(retriers))
+	for serviceName, retrier := range retriers {
+		result[serviceName] = retrier.Stats()
+	}
+	return result
+}
+
+// IsTransient reports whether the error is worth retrying: 5xx and 429 vendor responses and reset connections
+func IsTransient(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// CheckResponse returns a StatusError for 5xx and 429 responses, the body of such
+// response is drained and closed so the connection can be reused
+func CheckResponse(resp *http.Response) error {
+	if resp.StatusCode < http.StatusInternalServerError && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return &StatusError{StatusCode: resp.StatusCode, RetryAfter: ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+}
+
+// ParseRetryAfter parses the Retry-After header which is either a number of seconds or an HTTP date
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
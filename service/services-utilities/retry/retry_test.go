@@ -0,0 +1,82 @@
+package retry
+
+import (
+	"errors"
+	"icapeg/logging"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func newTestRetrier(policy Policy) (*Retrier, *[]time.Duration) {
+	logging.Logger = zap.NewNop()
+	r := NewRetrier(policy)
+	var delays []time.Duration
+	r.sleep = func(d time.Duration) { delays = append(delays, d) }
+	r.random = func(n int64) int64 { return n - 1 }
+	return r, &delays
+}
+
+func TestRetrierRecovers(t *testing.T) {
+	r, delays := newTestRetrier(Policy{MaxAttempts: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second})
+	calls := 0
+	err := r.Do("test", func() error {
+		calls++
+		if calls < 3 {
+			return syscall.ECONNRESET
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("expected recovery on the third call, got %v after %d calls", err, calls)
+	}
+	if len(*delays) != 2 || (*delays)[0] != 100*time.Millisecond || (*delays)[1] != 200*time.Millisecond {
+		t.Fatalf("unexpected backoff delays %v", *delays)
+	}
+	if stats := r.Stats(); stats.Retries != 2 || stats.Recovered != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRetrierPermanentErrorAndRetryAfter(t *testing.T) {
+	r, delays := newTestRetrier(Policy{MaxAttempts: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second})
+	calls := 0
+	r.Do("test", func() error { calls++; return errors.New("bad request") })
+	if calls != 1 {
+		t.Fatalf("permanent errors should not be retried, got %d calls", calls)
+	}
+	r.Do("test", func() error { return &StatusError{StatusCode: 429, RetryAfter: 5 * time.Second} })
+	if len(*delays) != 2 || (*delays)[0] != time.Second {
+		t.Fatalf("Retry-After should be capped to the max delay, got %v", *delays)
+	}
+}
+
+func TestRetrierBudget(t *testing.T) {
+	r, _ := newTestRetrier(Policy{MaxAttempts: 5, BudgetPerMinute: 2})
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	r.refilled = now
+	calls := 0
+	r.Do("test", func() error { calls++; return syscall.ECONNRESET })
+	if calls != 3 {
+		t.Fatalf("expected 2 retries within the budget, got %d calls", calls)
+	}
+	if stats := r.Stats(); stats.BudgetExhausted != 1 || stats.Failed != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	if d := ParseRetryAfter("120", now); d != 2*time.Minute {
+		t.Fatalf("got %v", d)
+	}
+	if d := ParseRetryAfter("Sat, 01 Jan 2022 00:00:30 GMT", now); d != 30*time.Second {
+		t.Fatalf("got %v", d)
+	}
+	if d := ParseRetryAfter("soon", now); d != 0 {
+		t.Fatalf("got %v", d)
+	}
+}
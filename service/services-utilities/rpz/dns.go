@@ -0,0 +1,108 @@
+package rpz
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// the max size of the DNS responses over UDP, the truncated ones are queried again over TCP
+const maxUDPSize = 1232
+
+// answer is the answer of a DNS query which the policy cares about
+type answer struct {
+	rcode   dnsmessage.RCode
+	cnames  []string // the CNAME targets without the trailing dot, "" for the root (the NXDOMAIN action)
+	ips     []string
+	records int
+}
+
+// query sends a DNS query of the name to the server (host:port) over UDP, and over TCP if the response is truncated
+func query(server, name string, qtype dnsmessage.Type, timeout time.Duration) (*answer, error) {
+	fqdn, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, err
+	}
+	id := uint16(rand.Intn(1 << 16))
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: fqdn, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := exchange("udp", server, packed, timeout)
+	if err == nil && resp.Header.Truncated {
+		resp, err = exchange("tcp", server, packed, timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.ID != id {
+		return nil, errors.New("the DNS response doesn't match the query")
+	}
+	return parse(resp), nil
+}
+
+func exchange(network, server string, packed []byte, timeout time.Duration) (*dnsmessage.Message, error) {
+	conn, err := net.DialTimeout(network, server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	var b []byte
+	if network == "tcp" {
+		prefixed := make([]byte, 2+len(packed))
+		binary.BigEndian.PutUint16(prefixed, uint16(len(packed)))
+		copy(prefixed[2:], packed)
+		if _, err = conn.Write(prefixed); err != nil {
+			return nil, err
+		}
+		length := make([]byte, 2)
+		if _, err = io.ReadFull(conn, length); err != nil {
+			return nil, err
+		}
+		b = make([]byte, binary.BigEndian.Uint16(length))
+		if _, err = io.ReadFull(conn, b); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err = conn.Write(packed); err != nil {
+			return nil, err
+		}
+		b = make([]byte, maxUDPSize)
+		n, err := conn.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		b = b[:n]
+	}
+	resp := &dnsmessage.Message{}
+	if err = resp.Unpack(b); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func parse(resp *dnsmessage.Message) *answer {
+	a := &answer{rcode: resp.Header.RCode, records: len(resp.Answers)}
+	for _, rr := range resp.Answers {
+		switch body := rr.Body.(type) {
+		case *dnsmessage.CNAMEResource:
+			a.cnames = append(a.cnames, strings.TrimSuffix(strings.ToLower(body.CNAME.String()), "."))
+		case *dnsmessage.AResource:
+			a.ips = append(a.ips, net.IP(body.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			a.ips = append(a.ips, net.IP(body.AAAA[:]).String())
+		}
+	}
+	return a
+}
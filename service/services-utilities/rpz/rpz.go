@@ -0,0 +1,271 @@
+package rpz
+
+import (
+	"errors"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// the policy actions of the RPZ rules (RFC draft-vixie-dnsop-dns-rpz), a rule is a CNAME whose target is the action
+const (
+	ActionNXDomain     = "nxdomain"      // CNAME . or the NXDOMAIN answer of the policy resolver
+	ActionNoData       = "nodata"        // CNAME *. or the empty answer of the policy resolver
+	ActionDrop         = "drop"          // CNAME rpz-drop.
+	ActionPassthru     = "passthru"      // CNAME rpz-passthru., the host is allowed whatever the other zones say
+	ActionTCPOnly      = "tcp-only"      // CNAME rpz-tcp-only., the host is allowed
+	ActionWalledGarden = "walled-garden" // CNAME to another domain or the local data of the rule
+	ActionSinkhole     = "sinkhole"      // the policy resolver answered a sinkhole IP
+	ActionFailClosed   = "fail-closed"   // the resolver failed and the policy doesn't fail open
+)
+
+// the default timeout of the DNS queries
+const defaultTimeout = 2 * time.Second
+
+// Config represents [<service>.dns_policy] section configuration
+type Config struct {
+	Resolver       string        // host:port of the policy resolver
+	Zones          []string      // the RPZ zones which are queried as <host>.<zone>, empty = the answer of the host decides
+	SinkholeIPs    []string      // the answers of the policy resolver which are its walled garden
+	VerifyResolver string        // a resolver without the policy, it tells the policy NXDOMAIN from the ones of the missing hosts
+	Timeout        time.Duration // of every DNS query
+	CacheTTL       time.Duration // how long the verdict of a host is reused, 0 = not cached
+	FailOpen       bool          // the hosts are allowed if the resolver fails, else they're blocked
+}
+
+// Verdict is the policy of the DNS firewall for a host
+type Verdict struct {
+	Blocked bool
+	Action  string // one of the actions, empty if no rule matched the host
+	Zone    string // the RPZ zone of the rule, empty in the resolver mode
+	Target  string // the CNAME target or the sinkhole IP of the rule
+}
+
+// Checker checks the hosts against the DNS firewall of a service
+type Checker struct {
+	cfg   Config
+	query func(server, name string, qtype dnsmessage.Type, timeout time.Duration) (*answer, error)
+	now   func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cachedVerdict
+}
+
+type cachedVerdict struct {
+	verdict Verdict
+	expiry  time.Time
+}
+
+var (
+	checkersMu sync.RWMutex
+	checkers   = make(map[string]*Checker)
+)
+
+// InitDNSPolicies reads the optional [<service>.dns_policy] section of every service, the REQMOD requests of a
+// service without that section aren't checked
+func InitDNSPolicies(services []string) {
+	for _, serviceName := range services {
+		sec := serviceName + ".dns_policy"
+		if !readValues.IsSecExists(sec) || !readValues.ReadValuesBool(sec+".enabled") {
+			continue
+		}
+		cfg := Config{
+			Resolver:    readValues.ReadValuesString(sec + ".resolver"),
+			Zones:       readValues.ReadValuesSlice(sec + ".zones"),
+			SinkholeIPs: readValues.ReadValuesSlice(sec + ".sinkhole_ips"),
+			Timeout:     readValues.ReadValuesDuration(sec+".timeout") * time.Second,
+			CacheTTL:    readValues.ReadValuesDuration(sec+".cache_ttl") * time.Second,
+			FailOpen:    readValues.ReadValuesBool(sec + ".fail_open"),
+		}
+		if readValues.IsSecExists(sec + ".verify_resolver") {
+			cfg.VerifyResolver = readValues.ReadValuesString(sec + ".verify_resolver")
+		}
+		c, err := New(cfg)
+		if err != nil {
+			logging.Logger.Error("invalid dns_policy of " + serviceName + " service, its hosts aren't checked: " + err.Error())
+			continue
+		}
+		logging.Logger.Debug("loading " + serviceName + " dns_policy configurations")
+		Register(serviceName, c)
+	}
+}
+
+// New creates a checker of the config
+func New(cfg Config) (*Checker, error) {
+	var err error
+	if cfg.Resolver, err = withPort(cfg.Resolver); err != nil {
+		return nil, errors.New("resolver: " + err.Error())
+	}
+	if cfg.VerifyResolver != "" {
+		if cfg.VerifyResolver, err = withPort(cfg.VerifyResolver); err != nil {
+			return nil, errors.New("verify_resolver: " + err.Error())
+		}
+	}
+	for i, ip := range cfg.SinkholeIPs {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return nil, errors.New(ip + " of sinkhole_ips isn't an IP address")
+		}
+		cfg.SinkholeIPs[i] = parsed.String()
+	}
+	for i, zone := range cfg.Zones {
+		cfg.Zones[i] = strings.Trim(strings.ToLower(zone), ".")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	return &Checker{cfg: cfg, query: query, now: time.Now, cache: make(map[string]cachedVerdict)}, nil
+}
+
+// withPort adds the DNS port to a resolver address which has none
+func withPort(resolver string) (string, error) {
+	if resolver == "" {
+		return "", errors.New("it's empty")
+	}
+	if _, _, err := net.SplitHostPort(resolver); err == nil {
+		return resolver, nil
+	}
+	return net.JoinHostPort(strings.Trim(resolver, "[]"), "53"), nil
+}
+
+// Register sets the checker of a service
+func Register(serviceName string, c *Checker) {
+	checkersMu.Lock()
+	defer checkersMu.Unlock()
+	checkers[serviceName] = c
+}
+
+// Get returns the checker of the service, false if the service has no DNS policy
+func Get(serviceName string) (*Checker, bool) {
+	checkersMu.RLock()
+	defer checkersMu.RUnlock()
+	c, exists := checkers[serviceName]
+	return c, exists
+}
+
+// FailOpen reports whether the hosts are allowed when the resolver fails
+func (c *Checker) FailOpen() bool {
+	return c.cfg.FailOpen
+}
+
+// Check returns the policy of the host, the IP addresses aren't checked
+func (c *Checker) Check(host string) (Verdict, error) {
+	host = strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+	if host == "" || net.ParseIP(host) != nil {
+		return Verdict{}, nil
+	}
+	c.mu.Lock()
+	cached, exists := c.cache[host]
+	c.mu.Unlock()
+	if exists && c.now().Before(cached.expiry) {
+		return cached.verdict, nil
+	}
+	var verdict Verdict
+	var err error
+	if len(c.cfg.Zones) != 0 {
+		verdict, err = c.checkZones(host)
+	} else {
+		verdict, err = c.checkResolver(host)
+	}
+	if err != nil {
+		return Verdict{}, err
+	}
+	if c.cfg.CacheTTL > 0 {
+		c.mu.Lock()
+		c.cache[host] = cachedVerdict{verdict: verdict, expiry: c.now().Add(c.cfg.CacheTTL)}
+		c.mu.Unlock()
+	}
+	return verdict, nil
+}
+
+// checkZones queries <host>.<zone> of every RPZ zone in their order, the first rule wins like the RPZ resolvers
+// do, so an earlier zone passes a host through which a later one blocks. The wildcard rules of the zones
+// (*.example.com) are applied by the resolver
+func (c *Checker) checkZones(host string) (Verdict, error) {
+	for _, zone := range c.cfg.Zones {
+		a, err := c.query(c.cfg.Resolver, host+"."+zone, dnsmessage.TypeA, c.cfg.Timeout)
+		if err != nil {
+			return Verdict{}, err
+		}
+		if a.rcode == dnsmessage.RCodeNameError || (a.rcode == dnsmessage.RCodeSuccess && a.records == 0) {
+			continue
+		}
+		if a.rcode != dnsmessage.RCodeSuccess {
+			return Verdict{}, errors.New("the resolver answered " + a.rcode.String() + " for " + host + "." + zone)
+		}
+		verdict := ruleVerdict(a, zone)
+		logging.Logger.Debug(host + " matches a rule of " + zone + " RPZ zone, its action is " + verdict.Action)
+		return verdict, nil
+	}
+	return Verdict{}, nil
+}
+
+// ruleVerdict returns the verdict of the answer of a matching RPZ rule
+func ruleVerdict(a *answer, zone string) Verdict {
+	verdict := Verdict{Blocked: true, Action: ActionWalledGarden, Zone: zone}
+	if len(a.cnames) == 0 {
+		if len(a.ips) != 0 {
+			verdict.Target = a.ips[0]
+		}
+		return verdict
+	}
+	verdict.Target = a.cnames[0]
+	switch verdict.Target {
+	case "":
+		verdict.Action = ActionNXDomain
+	case "*":
+		verdict.Action = ActionNoData
+	case "rpz-drop":
+		verdict.Action = ActionDrop
+	case "rpz-passthru":
+		verdict.Action, verdict.Blocked = ActionPassthru, false
+	case "rpz-tcp-only":
+		verdict.Action, verdict.Blocked = ActionTCPOnly, false
+	}
+	return verdict
+}
+
+// checkResolver resolves the host with the policy resolver, an NXDOMAIN or a sinkhole IP is the policy. With a
+// verify resolver, an NXDOMAIN or an empty answer is the policy only if the verify resolver resolves the host
+func (c *Checker) checkResolver(host string) (Verdict, error) {
+	a, err := c.query(c.cfg.Resolver, host, dnsmessage.TypeA, c.cfg.Timeout)
+	if err != nil {
+		return Verdict{}, err
+	}
+	switch {
+	case a.rcode == dnsmessage.RCodeSuccess && len(a.ips) != 0:
+		for _, ip := range a.ips {
+			for _, sinkhole := range c.cfg.SinkholeIPs {
+				if ip == sinkhole {
+					return Verdict{Blocked: true, Action: ActionSinkhole, Target: ip}, nil
+				}
+			}
+		}
+		return Verdict{}, nil
+	case a.rcode == dnsmessage.RCodeNameError || (a.rcode == dnsmessage.RCodeSuccess && a.records == 0):
+		action := ActionNXDomain
+		if a.rcode == dnsmessage.RCodeSuccess {
+			action = ActionNoData
+		}
+		if c.cfg.VerifyResolver == "" {
+			// without a verify resolver an empty answer can't be told from a host which has IPv6 addresses only
+			return Verdict{Blocked: action == ActionNXDomain, Action: action}, nil
+		}
+		verified, err := c.query(c.cfg.VerifyResolver, host, dnsmessage.TypeA, c.cfg.Timeout)
+		if err != nil {
+			return Verdict{}, err
+		}
+		if verified.rcode == dnsmessage.RCodeSuccess && verified.records != 0 {
+			return Verdict{Blocked: true, Action: action}, nil
+		}
+		return Verdict{}, nil
+	case a.rcode == dnsmessage.RCodeSuccess:
+		return Verdict{}, nil
+	}
+	return Verdict{}, errors.New("the resolver answered " + a.rcode.String() + " for " + host)
+}
@@ -0,0 +1,137 @@
+package rpz
+
+import (
+	"icapeg/logging"
+	"net"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeResolver answers the queries of the names from its records, the missing names are NXDOMAIN
+type fakeResolver map[string]*answer
+
+func (f fakeResolver) query(server, name string, qtype dnsmessage.Type, timeout time.Duration) (*answer, error) {
+	if a, exists := f[server+" "+name]; exists {
+		return a, nil
+	}
+	return &answer{rcode: dnsmessage.RCodeNameError}, nil
+}
+
+func newChecker(t *testing.T, cfg Config, resolver fakeResolver) *Checker {
+	t.Helper()
+	logging.Logger = zap.NewNop()
+	c, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.query = resolver.query
+	return c
+}
+
+func TestCheckZones(t *testing.T) {
+	c := newChecker(t, Config{Resolver: "10.0.0.53", Zones: []string{"allow.rpz", "block.rpz."}}, fakeResolver{
+		"10.0.0.53:53 bad.com.block.rpz":       {rcode: dnsmessage.RCodeSuccess, cnames: []string{""}, records: 1},
+		"10.0.0.53:53 garden.com.block.rpz":    {rcode: dnsmessage.RCodeSuccess, cnames: []string{"walled.example"}, records: 2},
+		"10.0.0.53:53 partner.com.allow.rpz":   {rcode: dnsmessage.RCodeSuccess, cnames: []string{"rpz-passthru"}, records: 1},
+		"10.0.0.53:53 partner.com.block.rpz":   {rcode: dnsmessage.RCodeSuccess, cnames: []string{""}, records: 1},
+		"10.0.0.53:53 dropped.com.block.rpz":   {rcode: dnsmessage.RCodeSuccess, cnames: []string{"rpz-drop"}, records: 1},
+		"10.0.0.53:53 localdata.com.block.rpz": {rcode: dnsmessage.RCodeSuccess, ips: []string{"10.9.9.9"}, records: 1},
+	})
+	cases := map[string]Verdict{
+		"BAD.com.":      {Blocked: true, Action: ActionNXDomain, Zone: "block.rpz"},
+		"garden.com":    {Blocked: true, Action: ActionWalledGarden, Zone: "block.rpz", Target: "walled.example"},
+		"partner.com":   {Action: ActionPassthru, Zone: "allow.rpz", Target: "rpz-passthru"},
+		"dropped.com":   {Blocked: true, Action: ActionDrop, Zone: "block.rpz", Target: "rpz-drop"},
+		"localdata.com": {Blocked: true, Action: ActionWalledGarden, Zone: "block.rpz", Target: "10.9.9.9"},
+		"good.com":      {},
+		"192.0.2.1":     {},
+	}
+	for host, expected := range cases {
+		verdict, err := c.Check(host)
+		if err != nil || verdict != expected {
+			t.Fatalf("%s: expected %+v, got %+v %v", host, expected, verdict, err)
+		}
+	}
+}
+
+func TestCheckResolver(t *testing.T) {
+	resolver := fakeResolver{
+		"10.0.0.53:53 good.com":      {rcode: dnsmessage.RCodeSuccess, ips: []string{"192.0.2.10"}, records: 1},
+		"10.0.0.53:53 sinkholed.com": {rcode: dnsmessage.RCodeSuccess, ips: []string{"10.0.0.1"}, records: 1},
+		"8.8.8.8:53 blocked.com":     {rcode: dnsmessage.RCodeSuccess, ips: []string{"192.0.2.20"}, records: 1},
+	}
+	c := newChecker(t, Config{Resolver: "10.0.0.53", SinkholeIPs: []string{"10.0.0.1"}}, resolver)
+	if verdict, _ := c.Check("sinkholed.com"); !verdict.Blocked || verdict.Action != ActionSinkhole {
+		t.Fatalf("a sinkhole IP should block the host, got %+v", verdict)
+	}
+	if verdict, _ := c.Check("missing.com"); !verdict.Blocked || verdict.Action != ActionNXDomain {
+		t.Fatalf("an NXDOMAIN should block the host without a verify resolver, got %+v", verdict)
+	}
+	if verdict, _ := c.Check("good.com"); verdict.Blocked {
+		t.Fatalf("a resolved host should be allowed, got %+v", verdict)
+	}
+
+	c = newChecker(t, Config{Resolver: "10.0.0.53", VerifyResolver: "8.8.8.8:53"}, resolver)
+	if verdict, _ := c.Check("blocked.com"); !verdict.Blocked || verdict.Action != ActionNXDomain {
+		t.Fatalf("an NXDOMAIN of a host which exists should be the policy, got %+v", verdict)
+	}
+	if verdict, _ := c.Check("missing.com"); verdict.Blocked {
+		t.Fatalf("an NXDOMAIN of a host which doesn't exist isn't the policy, got %+v", verdict)
+	}
+}
+
+func TestCheckCache(t *testing.T) {
+	resolver := fakeResolver{"10.0.0.53:53 bad.com.rpz": {rcode: dnsmessage.RCodeSuccess, cnames: []string{""}, records: 1}}
+	c := newChecker(t, Config{Resolver: "10.0.0.53", Zones: []string{"rpz"}, CacheTTL: time.Minute}, resolver)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	c.Check("bad.com")
+	delete(resolver, "10.0.0.53:53 bad.com.rpz")
+	if verdict, _ := c.Check("bad.com"); !verdict.Blocked {
+		t.Fatalf("the verdict should be cached")
+	}
+	now = now.Add(2 * time.Minute)
+	if verdict, _ := c.Check("bad.com"); verdict.Blocked {
+		t.Fatalf("the cached verdict should expire")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		b := make([]byte, 512)
+		n, addr, err := conn.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		var req dnsmessage.Message
+		if req.Unpack(b[:n]) != nil {
+			return
+		}
+		root, _ := dnsmessage.NewName(".")
+		resp := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: req.Header.ID, Response: true},
+			Questions: req.Questions,
+			Answers: []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: req.Questions[0].Name, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET},
+				Body:   &dnsmessage.CNAMEResource{CNAME: root},
+			}},
+		}
+		packed, _ := resp.Pack()
+		conn.WriteTo(packed, addr)
+	}()
+	a, err := query(conn.LocalAddr().String(), "bad.com.rpz", dnsmessage.TypeA, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.rcode != dnsmessage.RCodeSuccess || len(a.cnames) != 1 || a.cnames[0] != "" {
+		t.Fatalf("expected the NXDOMAIN rule, got %+v", a)
+	}
+}
@@ -0,0 +1,52 @@
+package rules
+
+import (
+	"errors"
+	"icapeg/readValues"
+	"time"
+)
+
+var ruleSet *RuleSet
+
+// InitRules reads the optional [app.rules] section, compiles the rule files of its directory and watches
+// the directory for changes, the rule sets aren't loaded if it doesn't exist
+func InitRules() {
+	if !readValues.IsSecExists("app.rules") || !readValues.ReadValuesBool("app.rules.enabled") {
+		return
+	}
+	ruleSet = New(readValues.ReadValuesString("app.rules.dir"))
+	ruleSet.Reload(true)
+	if interval := readValues.ReadValuesDuration("app.rules.reload_interval") * time.Second; interval > 0 {
+		go ruleSet.watch(interval)
+	}
+}
+
+// Enabled reports whether the rule sets are loaded
+func Enabled() bool {
+	return ruleSet != nil
+}
+
+// Match returns the names of the rules which match the content, it's empty if the rule sets aren't loaded
+func Match(content []byte) []string {
+	if ruleSet == nil {
+		return nil
+	}
+	return ruleSet.Match(content)
+}
+
+// Reload compiles the rule files now even if they didn't change
+func Reload() (Status, error) {
+	if ruleSet == nil {
+		return Status{}, errors.New("the rule sets aren't enabled")
+	}
+	_, err := ruleSet.Reload(true)
+	return ruleSet.Status(), err
+}
+
+// CurrentStatus returns the state of the active rule set
+func CurrentStatus() (Status, bool) {
+	if ruleSet == nil {
+		return Status{}, false
+	}
+	return ruleSet.Status(), true
+}
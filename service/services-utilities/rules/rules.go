@@ -0,0 +1,182 @@
+package rules
+
+import (
+	"errors"
+	"icapeg/logging"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule is a compiled rule of a rule set
+type Rule interface {
+	Name() string
+	Match(content []byte) bool
+}
+
+// Compiler compiles the source of a rule file, file is the name of the file in the errors
+type Compiler func(file string, src []byte) ([]Rule, error)
+
+// Status is the state of the active rule set which the admin API returns
+type Status struct {
+	Dir        string     `json:"dir"`
+	Files      []string   `json:"files"`
+	Rules      int        `json:"rules"`
+	Reloads    int        `json:"reloads"`
+	LastReload *time.Time `json:"last_reload,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	LastFailed *time.Time `json:"last_failed,omitempty"`
+}
+
+// RuleSet is the rule set of a directory, it's compiled again when the files of the directory change and the
+// compiled rule set is replaced at once, a broken rule set never replaces the last good one
+type RuleSet struct {
+	dir string
+
+	reloading   sync.Mutex // one compile at a time, the scheduled one or the one of the admin API
+	mu          sync.RWMutex
+	rules       []Rule
+	fingerprint string
+	failed      string // the fingerprint of the last rejected rule set, it isn't compiled again until it changes
+	status      Status
+}
+
+// the compilers of the rule files by their extensions
+var (
+	compilersMu sync.RWMutex
+	compilers   = map[string]Compiler{
+		".yar":  CompileYARA,
+		".yara": CompileYARA,
+	}
+)
+
+// RegisterCompiler adds a kind of rule files, the files which have the extension are compiled by compile
+func RegisterCompiler(ext string, compile Compiler) {
+	compilersMu.Lock()
+	defer compilersMu.Unlock()
+	compilers[strings.ToLower(ext)] = compile
+}
+
+// New creates the rule set of the directory, it's empty until it's loaded
+func New(dir string) *RuleSet {
+	return &RuleSet{dir: dir, status: Status{Dir: dir, Files: []string{}}}
+}
+
+// Reload compiles the rule files of the directory if they changed since the last compile or if force is set,
+// it returns whether the active rule set was replaced. The active rule set stays if a file doesn't compile
+func (s *RuleSet) Reload(force bool) (bool, error) {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+	files, fingerprint, err := s.files()
+	if err == nil && !force && (fingerprint == s.fingerprint || fingerprint == s.failed) {
+		return false, nil
+	}
+	var compiled []Rule
+	if err == nil {
+		compiled, err = compile(s.dir, files)
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.failed = fingerprint
+		s.status.LastError, s.status.LastFailed = err.Error(), &now
+		logging.Logger.Error("the rule set of " + s.dir + " is rejected, the last good one stays active: " +
+			err.Error())
+		return false, err
+	}
+	s.rules, s.fingerprint = compiled, fingerprint
+	s.status.Files, s.status.Rules, s.status.LastReload, s.status.LastError, s.status.LastFailed =
+		files, len(compiled), &now, "", nil
+	s.status.Reloads++
+	logging.Logger.Info("the rule set of " + s.dir + " is compiled, " + strconv.Itoa(len(compiled)) + " rules")
+	return true, nil
+}
+
+// files returns the rule files of the directory which have a compiler and their fingerprint, the names,
+// sizes and modification times of the files
+func (s *RuleSet) files() ([]string, string, error) {
+	compilersMu.RLock()
+	defer compilersMu.RUnlock()
+	files := []string{}
+	var fingerprint strings.Builder
+	err := filepath.WalkDir(s.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || compilers[strings.ToLower(filepath.Ext(path))] == nil {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(s.dir, path)
+		files = append(files, rel)
+		fingerprint.WriteString(rel + ":" + strconv.FormatInt(info.Size(), 10) + ":" +
+			strconv.FormatInt(info.ModTime().UnixNano(), 10) + "\n")
+		return nil
+	})
+	sort.Strings(files)
+	return files, fingerprint.String(), err
+}
+
+func compile(dir string, files []string) ([]Rule, error) {
+	compilersMu.RLock()
+	defer compilersMu.RUnlock()
+	var compiled []Rule
+	names := make(map[string]string)
+	for _, file := range files {
+		src, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			return nil, err
+		}
+		fileRules, err := compilers[strings.ToLower(filepath.Ext(file))](file, src)
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range fileRules {
+			if other, exists := names[rule.Name()]; exists {
+				return nil, errors.New(file + ": rule " + rule.Name() + " is already defined in " + other)
+			}
+			names[rule.Name()] = file
+		}
+		compiled = append(compiled, fileRules...)
+	}
+	return compiled, nil
+}
+
+// Match returns the names of the rules of the active rule set which match the content
+func (s *RuleSet) Match(content []byte) []string {
+	s.mu.RLock()
+	active := s.rules
+	s.mu.RUnlock()
+	var matched []string
+	for _, rule := range active {
+		if rule.Match(content) {
+			matched = append(matched, rule.Name())
+		}
+	}
+	return matched
+}
+
+// Status returns the state of the active rule set
+func (s *RuleSet) Status() Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.status
+}
+
+func (s *RuleSet) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.Reload(false)
+	}
+}
@@ -0,0 +1,87 @@
+package rules
+
+import (
+	"icapeg/logging"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const eicarRule = `
+rule eicar : test {
+	meta:
+		author = "icapeg"
+	strings:
+		$text = "EICAR-STANDARD-ANTIVIRUS-TEST-FILE" nocase
+		$hex = { 58 35 4F ?? 50 }
+	condition:
+		all of them
+}
+
+/* a PE file which has a suspicious string */
+rule suspicious_pe {
+	strings:
+		$mz = { 4D 5A }
+		$a1 = "powershell" nocase wide ascii
+		$a2 = /cmd\.exe \/c/i
+	condition:
+		$mz and any of ($a*)
+}
+`
+
+func TestCompileYARA(t *testing.T) {
+	compiled, err := CompileYARA("test.yar", []byte(eicarRule))
+	if err != nil || len(compiled) != 2 {
+		t.Fatalf("expected 2 rules, got %d %v", len(compiled), err)
+	}
+	set := &RuleSet{rules: compiled}
+	if matched := set.Match([]byte(`X5O!P%@AP[4\PZX54(P^)7CC)7}$eicar-standard-antivirus-test-file!$H+H*`)); len(matched) != 1 ||
+		matched[0] != "eicar" {
+		t.Fatalf("expected eicar to match, got %v", matched)
+	}
+	if matched := set.Match([]byte("MZ\x90\x00P\x00o\x00W\x00e\x00r\x00S\x00h\x00e\x00l\x00l\x00")); len(matched) != 1 ||
+		matched[0] != "suspicious_pe" {
+		t.Fatalf("expected the wide string to match, got %v", matched)
+	}
+	if matched := set.Match([]byte("powershell alone")); len(matched) != 0 {
+		t.Fatalf("expected no match, got %v", matched)
+	}
+
+	for _, broken := range []string{
+		`import "pe" rule a { condition: true }`,
+		`rule a { strings: $a = "x" condition: $b }`,
+		`rule a { strings: $a = "x" condition: filesize < 10 }`,
+		`rule a { strings: $a = { 4D [2-4] 5A } condition: $a }`,
+		`rule a { condition: true`,
+	} {
+		if _, err = CompileYARA("broken.yar", []byte(broken)); err == nil {
+			t.Errorf("expected %q to fail the compile", broken)
+		}
+	}
+}
+
+func TestReloadKeepsTheLastGoodRuleSet(t *testing.T) {
+	logging.Logger = zap.NewNop()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "rules.yar")
+	os.WriteFile(file, []byte(`rule a { strings: $a = "malware" condition: $a }`), 0600)
+	set := New(dir)
+	if replaced, err := set.Reload(false); !replaced || err != nil {
+		t.Fatalf("expected the rule set to be compiled, %v", err)
+	}
+	if replaced, _ := set.Reload(false); replaced {
+		t.Fatalf("an unchanged rule set shouldn't be compiled again")
+	}
+
+	os.WriteFile(file, []byte(`rule a { strings: $a = "malware" condition: $a and`), 0600)
+	os.Chtimes(file, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	if _, err := set.Reload(false); err == nil || set.Status().LastError == "" {
+		t.Fatalf("a broken rule set should be rejected")
+	}
+	if matched := set.Match([]byte("some malware")); len(matched) != 1 {
+		t.Fatalf("the last good rule set should stay active, got %v", matched)
+	}
+}
@@ -0,0 +1,631 @@
+package rules
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CompileYARA compiles the YARA rules of a file, the subset which ICAPeg supports is:
+//   - text strings with the nocase, ascii and wide modifiers, hex strings with ?? wildcards and regular
+//     expressions with the i and s flags
+//   - conditions of string references, "any/all/none/N of them" or "of ($a, $b*)", and, or, not and parentheses
+//
+// The other YARA features (imports, includes, filesize, offsets, counts...) fail the compile, so a rule is
+// never silently weakened
+func CompileYARA(file string, src []byte) ([]Rule, error) {
+	p := &yaraParser{file: file, src: src, line: 1}
+	var compiled []Rule
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return compiled, nil
+		}
+		rule, err := p.rule()
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, rule)
+	}
+}
+
+type yaraRule struct {
+	name      string
+	strings   map[string]matcher
+	order     []string
+	condition condition
+}
+
+func (r *yaraRule) Name() string {
+	return r.name
+}
+
+func (r *yaraRule) Match(content []byte) bool {
+	results := make(map[string]bool, len(r.strings))
+	return r.condition(func(id string) bool {
+		matched, evaluated := results[id]
+		if !evaluated {
+			matched = r.strings[id](content)
+			results[id] = matched
+		}
+		return matched
+	})
+}
+
+type matcher func(content []byte) bool
+
+// condition evaluates a rule condition, matched tells whether a string of the rule is found in the content
+type condition func(matched func(id string) bool) bool
+
+type yaraParser struct {
+	file string
+	src  []byte
+	pos  int
+	line int
+}
+
+func (p *yaraParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.file, p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *yaraParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *yaraParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *yaraParser) next() byte {
+	c := p.src[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+// skipSpace skips the white spaces and the comments
+func (p *yaraParser) skipSpace() {
+	for !p.eof() {
+		switch {
+		case p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\r' || p.peek() == '\n':
+			p.next()
+		case bytes.HasPrefix(p.src[p.pos:], []byte("//")):
+			for !p.eof() && p.peek() != '\n' {
+				p.next()
+			}
+		case bytes.HasPrefix(p.src[p.pos:], []byte("/*")):
+			p.pos += 2
+			for !p.eof() && !bytes.HasPrefix(p.src[p.pos:], []byte("*/")) {
+				p.next()
+			}
+			p.pos += 2
+		default:
+			return
+		}
+	}
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// word returns the identifier, keyword or number at the position
+func (p *yaraParser) word() string {
+	p.skipSpace()
+	start := p.pos
+	for !p.eof() && isIdentChar(p.peek()) {
+		p.next()
+	}
+	return string(p.src[start:p.pos])
+}
+
+func (p *yaraParser) expect(c byte) error {
+	p.skipSpace()
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.next()
+	return nil
+}
+
+func (p *yaraParser) rule() (Rule, error) {
+	keyword := p.word()
+	for keyword == "private" || keyword == "global" {
+		keyword = p.word()
+	}
+	switch keyword {
+	case "rule":
+	case "import", "include":
+		return nil, p.errorf("%s isn't supported", keyword)
+	default:
+		return nil, p.errorf("expected rule, got %q", keyword)
+	}
+	rule := &yaraRule{name: p.word(), strings: make(map[string]matcher)}
+	if rule.name == "" {
+		return nil, p.errorf("expected the name of the rule")
+	}
+	p.skipSpace()
+	if p.peek() == ':' {
+		// the tags of the rule
+		p.next()
+		for p.skipSpace(); isIdentChar(p.peek()); p.skipSpace() {
+			p.word()
+		}
+	}
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+	section := p.word()
+	if section == "meta" {
+		if err := p.meta(); err != nil {
+			return nil, err
+		}
+		section = p.word()
+	}
+	if section == "strings" {
+		if err := p.strings(rule); err != nil {
+			return nil, err
+		}
+		section = p.word()
+	}
+	if section != "condition" {
+		return nil, p.errorf("expected the condition of rule %s", rule.name)
+	}
+	if err := p.expect(':'); err != nil {
+		return nil, err
+	}
+	cond, err := p.condition(rule)
+	if err != nil {
+		return nil, err
+	}
+	rule.condition = cond
+	if err = p.expect('}'); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// meta skips the meta section, ICAPeg doesn't use the metadata of the rules
+func (p *yaraParser) meta() error {
+	if err := p.expect(':'); err != nil {
+		return err
+	}
+	for {
+		p.skipSpace()
+		start, line := p.pos, p.line
+		key := p.word()
+		if key == "" || key == "strings" || key == "condition" {
+			p.pos, p.line = start, line
+			return nil
+		}
+		if err := p.expect('='); err != nil {
+			return err
+		}
+		p.skipSpace()
+		if p.peek() == '"' {
+			if _, err := p.text(); err != nil {
+				return err
+			}
+		} else if p.word() == "" {
+			return p.errorf("expected the value of %s", key)
+		}
+	}
+}
+
+func (p *yaraParser) strings(rule *yaraRule) error {
+	if err := p.expect(':'); err != nil {
+		return err
+	}
+	for {
+		p.skipSpace()
+		if p.peek() != '$' {
+			return nil
+		}
+		p.next()
+		id := "$" + p.word()
+		if id == "$" {
+			id = "$" + strconv.Itoa(len(rule.order)) + "anonymous"
+		} else if _, exists := rule.strings[id]; exists {
+			return p.errorf("string %s is already defined", id)
+		}
+		if err := p.expect('='); err != nil {
+			return err
+		}
+		m, err := p.stringValue()
+		if err != nil {
+			return err
+		}
+		rule.strings[id] = m
+		rule.order = append(rule.order, id)
+	}
+}
+
+func (p *yaraParser) stringValue() (matcher, error) {
+	p.skipSpace()
+	switch p.peek() {
+	case '"':
+		text, err := p.text()
+		if err != nil {
+			return nil, err
+		}
+		nocase, ascii, wide := false, false, false
+		for {
+			p.skipSpace()
+			start, line := p.pos, p.line
+			switch modifier := p.word(); modifier {
+			case "nocase":
+				nocase = true
+			case "ascii":
+				ascii = true
+			case "wide":
+				wide = true
+			case "fullword", "private", "xor", "base64", "base64wide":
+				return nil, p.errorf("the %s modifier isn't supported", modifier)
+			default:
+				p.pos, p.line = start, line
+				return textMatcher(text, nocase, ascii || !wide, wide), nil
+			}
+		}
+	case '{':
+		return p.hex()
+	case '/':
+		return p.regex()
+	}
+	return nil, p.errorf("expected a text, hex or regular expression string")
+}
+
+// text reads a quoted text string with its escapes
+func (p *yaraParser) text() ([]byte, error) {
+	p.next()
+	var text []byte
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return nil, p.errorf("unterminated string")
+		}
+		c := p.next()
+		switch c {
+		case '"':
+			return text, nil
+		case '\\':
+			if p.eof() {
+				return nil, p.errorf("unterminated string")
+			}
+			switch escaped := p.next(); escaped {
+			case 'n':
+				text = append(text, '\n')
+			case 't':
+				text = append(text, '\t')
+			case 'r':
+				text = append(text, '\r')
+			case '"', '\\':
+				text = append(text, escaped)
+			case 'x':
+				if p.pos+2 > len(p.src) {
+					return nil, p.errorf("malformed \\x escape")
+				}
+				value, err := strconv.ParseUint(string(p.src[p.pos:p.pos+2]), 16, 8)
+				if err != nil {
+					return nil, p.errorf("malformed \\x escape")
+				}
+				p.pos += 2
+				text = append(text, byte(value))
+			default:
+				return nil, p.errorf("unknown escape \\%c", escaped)
+			}
+		default:
+			text = append(text, c)
+		}
+	}
+}
+
+func textMatcher(text []byte, nocase, ascii, wide bool) matcher {
+	var patterns [][]byte
+	if ascii {
+		patterns = append(patterns, text)
+	}
+	if wide {
+		widened := make([]byte, 0, 2*len(text))
+		for _, c := range text {
+			widened = append(widened, c, 0)
+		}
+		patterns = append(patterns, widened)
+	}
+	if nocase {
+		for i := range patterns {
+			patterns[i] = asciiLower(patterns[i])
+		}
+	}
+	return func(content []byte) bool {
+		if nocase {
+			content = asciiLower(content)
+		}
+		for _, pattern := range patterns {
+			if bytes.Contains(content, pattern) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func asciiLower(b []byte) []byte {
+	lower := make([]byte, len(b))
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		lower[i] = c
+	}
+	return lower
+}
+
+// hex reads a hex string, every byte is two hex digits or ?? which matches any byte
+func (p *yaraParser) hex() (matcher, error) {
+	p.next()
+	var pattern []int // -1 is a wildcard
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return nil, p.errorf("unterminated hex string")
+		}
+		if p.peek() == '}' {
+			p.next()
+			break
+		}
+		if p.pos+2 > len(p.src) {
+			return nil, p.errorf("malformed hex string")
+		}
+		pair := string(p.src[p.pos : p.pos+2])
+		p.pos += 2
+		if pair == "??" {
+			pattern = append(pattern, -1)
+			continue
+		}
+		value, err := strconv.ParseUint(pair, 16, 8)
+		if err != nil {
+			return nil, p.errorf("malformed or unsupported hex byte %q, jumps, alternatives and nibble "+
+				"wildcards aren't supported", pair)
+		}
+		pattern = append(pattern, int(value))
+	}
+	if len(pattern) == 0 || pattern[0] == -1 {
+		return nil, p.errorf("a hex string must start with a byte")
+	}
+	return func(content []byte) bool {
+		for start := 0; start+len(pattern) <= len(content); start++ {
+			if content[start] != byte(pattern[0]) {
+				continue
+			}
+			matched := true
+			for i := 1; i < len(pattern); i++ {
+				if pattern[i] != -1 && content[start+i] != byte(pattern[i]) {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// regex reads a regular expression string, it's matched with the regexp package of Go
+func (p *yaraParser) regex() (matcher, error) {
+	p.next()
+	var expr strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return nil, p.errorf("unterminated regular expression")
+		}
+		c := p.next()
+		if c == '/' {
+			break
+		}
+		expr.WriteByte(c)
+		if c == '\\' && !p.eof() {
+			expr.WriteByte(p.next())
+		}
+	}
+	flags := ""
+	for p.peek() == 'i' || p.peek() == 's' {
+		flags += string(p.next())
+	}
+	source := expr.String()
+	if flags != "" {
+		source = "(?" + flags + ")" + source
+	}
+	re, err := regexp.Compile(source)
+	if err != nil {
+		return nil, p.errorf("malformed regular expression: %v", err)
+	}
+	return re.Match, nil
+}
+
+// condition parses the condition of the rule up to the closing brace of the rule
+func (p *yaraParser) condition(rule *yaraRule) (condition, error) {
+	cond, err := p.or(rule)
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.peek() != '}' {
+		return nil, p.errorf("unsupported condition near %q", p.rest())
+	}
+	return cond, nil
+}
+
+func (p *yaraParser) rest() string {
+	end := bytes.IndexAny(p.src[p.pos:], "\r\n")
+	if end < 0 {
+		end = len(p.src) - p.pos
+	}
+	return string(p.src[p.pos : p.pos+end])
+}
+
+// keyword consumes the keyword if it's the next word
+func (p *yaraParser) keyword(keyword string) bool {
+	p.skipSpace()
+	start, line := p.pos, p.line
+	if p.word() == keyword {
+		return true
+	}
+	p.pos, p.line = start, line
+	return false
+}
+
+func (p *yaraParser) or(rule *yaraRule) (condition, error) {
+	left, err := p.and(rule)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.and(rule)
+		if err != nil {
+			return nil, err
+		}
+		a, b := left, right
+		left = func(matched func(string) bool) bool { return a(matched) || b(matched) }
+	}
+	return left, nil
+}
+
+func (p *yaraParser) and(rule *yaraRule) (condition, error) {
+	left, err := p.not(rule)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.not(rule)
+		if err != nil {
+			return nil, err
+		}
+		a, b := left, right
+		left = func(matched func(string) bool) bool { return a(matched) && b(matched) }
+	}
+	return left, nil
+}
+
+func (p *yaraParser) not(rule *yaraRule) (condition, error) {
+	if p.keyword("not") {
+		operand, err := p.not(rule)
+		if err != nil {
+			return nil, err
+		}
+		return func(matched func(string) bool) bool { return !operand(matched) }, nil
+	}
+	return p.primary(rule)
+}
+
+func (p *yaraParser) primary(rule *yaraRule) (condition, error) {
+	p.skipSpace()
+	switch c := p.peek(); {
+	case c == '(':
+		p.next()
+		cond, err := p.or(rule)
+		if err != nil {
+			return nil, err
+		}
+		return cond, p.expect(')')
+	case c == '$':
+		p.next()
+		id := "$" + p.word()
+		if _, exists := rule.strings[id]; !exists {
+			return nil, p.errorf("undefined string %s", id)
+		}
+		return func(matched func(string) bool) bool { return matched(id) }, nil
+	}
+	start, line := p.pos, p.line
+	switch word := p.word(); word {
+	case "true":
+		return func(func(string) bool) bool { return true }, nil
+	case "false":
+		return func(func(string) bool) bool { return false }, nil
+	case "any", "all", "none":
+		return p.of(rule, word)
+	default:
+		if _, err := strconv.Atoi(word); err == nil && word != "" {
+			return p.of(rule, word)
+		}
+	}
+	p.pos, p.line = start, line
+	return nil, p.errorf("unsupported condition near %q", p.rest())
+}
+
+// of parses "<quantifier> of them" and "<quantifier> of ($a, $b*)"
+func (p *yaraParser) of(rule *yaraRule, quantifier string) (condition, error) {
+	if !p.keyword("of") {
+		return nil, p.errorf("unsupported condition near %q", p.rest())
+	}
+	var ids []string
+	if p.keyword("them") {
+		ids = rule.order
+	} else {
+		if err := p.expect('('); err != nil {
+			return nil, err
+		}
+		for {
+			if err := p.expect('$'); err != nil {
+				return nil, err
+			}
+			name := "$" + p.word()
+			if p.peek() == '*' {
+				p.next()
+				for _, id := range rule.order {
+					if strings.HasPrefix(id, name) {
+						ids = append(ids, id)
+					}
+				}
+			} else if _, exists := rule.strings[name]; exists {
+				ids = append(ids, name)
+			} else {
+				return nil, p.errorf("undefined string %s", name)
+			}
+			p.skipSpace()
+			if p.peek() != ',' {
+				break
+			}
+			p.next()
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+	}
+	if len(ids) == 0 {
+		return nil, p.errorf("the string set of the condition is empty")
+	}
+	needed := 0
+	switch quantifier {
+	case "any":
+		needed = 1
+	case "all":
+		needed = len(ids)
+	case "none":
+		needed = 0
+	default:
+		needed, _ = strconv.Atoi(quantifier)
+	}
+	return func(matched func(string) bool) bool {
+		count := 0
+		for _, id := range ids {
+			if matched(id) {
+				count++
+				if quantifier != "none" && count >= needed {
+					return true
+				}
+			}
+		}
+		if quantifier == "none" {
+			return count == 0
+		}
+		return count >= needed
+	}, nil
+}
@@ -0,0 +1,152 @@
+package shadow
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultMaxSamples is the number of the latest disagreements which are kept for a pair of services which doesn't
+// set it
+const DefaultMaxSamples = 50
+
+// Comparison is the verdicts of the primary service and of its shadow service for the same HTTP message
+type Comparison struct {
+	Time             time.Time `json:"time"`
+	XICAPMetadata    string    `json:"x_icap_metadata"`
+	Primary          string    `json:"primary"`
+	Shadow           string    `json:"shadow"`
+	Method           string    `json:"method"`
+	RequestedURL     string    `json:"requested_url,omitempty"`
+	PrimaryVerdict   string    `json:"primary_verdict"`
+	ShadowVerdict    string    `json:"shadow_verdict"`
+	PrimaryThreat    string    `json:"primary_threat,omitempty"`
+	ShadowThreat     string    `json:"shadow_threat,omitempty"`
+	PrimaryLatencyMs int64     `json:"primary_latency_ms"`
+	ShadowLatencyMs  int64     `json:"shadow_latency_ms"`
+}
+
+// Agree reports whether both services returned the same verdict
+func (c *Comparison) Agree() bool {
+	return c.PrimaryVerdict == c.ShadowVerdict
+}
+
+// PairReport is the agreement report of a primary service and its shadow service
+type PairReport struct {
+	Primary       string            `json:"primary"`
+	Shadow        string            `json:"shadow"`
+	Transactions  uint64            `json:"transactions"`
+	Agreements    uint64            `json:"agreements"`
+	Disagreements uint64            `json:"disagreements"`
+	AgreementRate float64           `json:"agreement_rate"`
+	Verdicts      map[string]uint64 `json:"verdicts"` // by "<primary verdict>/<shadow verdict>"
+	// the average latencies of the services for the compared HTTP messages
+	PrimaryLatencyMs float64      `json:"primary_avg_latency_ms"`
+	ShadowLatencyMs  float64      `json:"shadow_avg_latency_ms"`
+	Disagreeing      []Comparison `json:"latest_disagreements"`
+}
+
+type pairKey struct {
+	primary string
+	shadow  string
+}
+
+type pairStats struct {
+	report         PairReport
+	primaryLatency int64
+	shadowLatency  int64
+}
+
+// Reporter compares the verdicts of the pairs of services, it keeps the counters of every pair and its latest
+// disagreements
+type Reporter struct {
+	mu    sync.Mutex
+	pairs map[pairKey]*pairStats
+}
+
+var reporter = NewReporter()
+
+// NewReporter creates an empty reporter
+func NewReporter() *Reporter {
+	return &Reporter{pairs: make(map[pairKey]*pairStats)}
+}
+
+// Record adds the comparison to the report of its pair, see Reporter.Record
+func Record(c Comparison, maxSamples int) {
+	reporter.Record(c, maxSamples)
+}
+
+// Report returns the reports of every pair, see Reporter.Report
+func Report() []PairReport {
+	return reporter.Report()
+}
+
+// Reset drops the reports of every pair, see Reporter.Reset
+func Reset() {
+	reporter.Reset()
+}
+
+// Record adds the comparison to the report of its pair, only the latest max samples disagreements are kept, a max
+// samples of zero means DefaultMaxSamples
+func (r *Reporter) Record(c Comparison, maxSamples int) {
+	if maxSamples <= 0 {
+		maxSamples = DefaultMaxSamples
+	}
+	key := pairKey{primary: c.Primary, shadow: c.Shadow}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.pairs[key]
+	if stats == nil {
+		stats = &pairStats{report: PairReport{Primary: c.Primary, Shadow: c.Shadow, Verdicts: make(map[string]uint64)}}
+		r.pairs[key] = stats
+	}
+	report := &stats.report
+	report.Transactions++
+	report.Verdicts[c.PrimaryVerdict+"/"+c.ShadowVerdict]++
+	stats.primaryLatency += c.PrimaryLatencyMs
+	stats.shadowLatency += c.ShadowLatencyMs
+	if c.Agree() {
+		report.Agreements++
+		return
+	}
+	report.Disagreements++
+	report.Disagreeing = append(report.Disagreeing, c)
+	if len(report.Disagreeing) > maxSamples {
+		report.Disagreeing = report.Disagreeing[len(report.Disagreeing)-maxSamples:]
+	}
+}
+
+// Report returns a copy of the reports of every pair, sorted by their primary and shadow services
+func (r *Reporter) Report() []PairReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reports := make([]PairReport, 0, len(r.pairs))
+	for _, stats := range r.pairs {
+		report := stats.report
+		report.Verdicts = make(map[string]uint64, len(stats.report.Verdicts))
+		for verdicts, n := range stats.report.Verdicts {
+			report.Verdicts[verdicts] = n
+		}
+		report.Disagreeing = append([]Comparison(nil), stats.report.Disagreeing...)
+		if report.Transactions > 0 {
+			report.AgreementRate = float64(report.Agreements) / float64(report.Transactions)
+			report.PrimaryLatencyMs = float64(stats.primaryLatency) / float64(report.Transactions)
+			report.ShadowLatencyMs = float64(stats.shadowLatency) / float64(report.Transactions)
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(a, b int) bool {
+		if reports[a].Primary != reports[b].Primary {
+			return reports[a].Primary < reports[b].Primary
+		}
+		return reports[a].Shadow < reports[b].Shadow
+	})
+	return reports
+}
+
+// Reset drops the reports of every pair, ex: before evaluating another version of a vendor
+func (r *Reporter) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pairs = make(map[pairKey]*pairStats)
+}
@@ -0,0 +1,53 @@
+package shadow
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestReporterAgreement(t *testing.T) {
+	r := NewReporter()
+	for n, verdicts := range [][2]string{{"clean", "clean"}, {"malicious", "malicious"}, {"clean", "malicious"},
+		{"clean", "clean"}} {
+		r.Record(Comparison{XICAPMetadata: strconv.Itoa(n), Primary: "clamav", Shadow: "clamav_next",
+			PrimaryVerdict: verdicts[0], ShadowVerdict: verdicts[1], PrimaryLatencyMs: 10, ShadowLatencyMs: 30}, 0)
+	}
+	reports := r.Report()
+	if len(reports) != 1 {
+		t.Fatalf("expected the report of one pair, got %d", len(reports))
+	}
+	report := reports[0]
+	if report.Transactions != 4 || report.Agreements != 3 || report.Disagreements != 1 || report.AgreementRate != 0.75 {
+		t.Fatalf("unexpected counters %+v", report)
+	}
+	if report.Verdicts["clean/clean"] != 2 || report.Verdicts["clean/malicious"] != 1 {
+		t.Fatalf("unexpected verdicts %v", report.Verdicts)
+	}
+	if report.PrimaryLatencyMs != 10 || report.ShadowLatencyMs != 30 {
+		t.Fatalf("unexpected latencies %v %v", report.PrimaryLatencyMs, report.ShadowLatencyMs)
+	}
+	if len(report.Disagreeing) != 1 || report.Disagreeing[0].XICAPMetadata != "2" {
+		t.Fatalf("the disagreement should be kept, got %+v", report.Disagreeing)
+	}
+}
+
+func TestReporterKeepsLatestDisagreements(t *testing.T) {
+	r := NewReporter()
+	for n := 0; n < 5; n++ {
+		r.Record(Comparison{XICAPMetadata: strconv.Itoa(n), Primary: "a", Shadow: "b", PrimaryVerdict: "clean",
+			ShadowVerdict: "error"}, 2)
+	}
+	r.Record(Comparison{Primary: "a", Shadow: "c", PrimaryVerdict: "clean", ShadowVerdict: "clean"}, 2)
+	reports := r.Report()
+	if len(reports) != 2 || reports[0].Shadow != "b" || reports[1].Shadow != "c" {
+		t.Fatalf("the reports should be sorted by their pairs, got %+v", reports)
+	}
+	if samples := reports[0].Disagreeing; len(samples) != 2 || samples[0].XICAPMetadata != "3" ||
+		samples[1].XICAPMetadata != "4" {
+		t.Fatalf("only the latest disagreements should be kept, got %+v", samples)
+	}
+	r.Reset()
+	if len(r.Report()) != 0 {
+		t.Fatal("the reports should be dropped")
+	}
+}
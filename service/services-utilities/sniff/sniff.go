@@ -0,0 +1,203 @@
+package sniff
+
+import (
+	"bytes"
+	"mime"
+	"path"
+	"strings"
+)
+
+// Octet is the MIME type of the files whose type couldn't be detected
+const Octet = "application/octet-stream"
+
+// the sources of the detected types
+const (
+	SourceMagic       = "magic"
+	SourceContentType = "content-type"
+	SourceNone        = "none"
+)
+
+// Type is the detected type of a file
+type Type struct {
+	MIME       string
+	Extensions []string // the extensions of the type, the first one is its usual extension
+	Source     string   // SourceMagic, SourceContentType or SourceNone
+	// the text types (ex: HTML, scripts) are served with many extensions (ex: .php), so their extensions don't
+	// contradict them
+	text bool
+}
+
+// signature is the magic bytes of a type at an offset of the file
+type signature struct {
+	offset int
+	magic  string
+	mime   string
+	exts   []string
+	text   bool
+	// match refines the type after the magic bytes matched, ex: the zip based formats, it returns "" to keep it
+	match func(head []byte) (string, []string)
+}
+
+// the signatures in their checking order, the longer magic bytes come before the shorter ones which they start with
+var signatures = []signature{
+	{magic: "MZ", mime: "application/vnd.microsoft.portable-executable", exts: []string{"exe", "dll", "sys", "scr", "cpl", "ocx", "com"}},
+	{magic: "\x7fELF", mime: "application/x-elf", exts: []string{"elf", "so", "bin", "o"}},
+	{magic: "\xfe\xed\xfa\xce", mime: "application/x-mach-binary", exts: []string{"macho", "dylib"}},
+	{magic: "\xfe\xed\xfa\xcf", mime: "application/x-mach-binary", exts: []string{"macho", "dylib"}},
+	{magic: "\xce\xfa\xed\xfe", mime: "application/x-mach-binary", exts: []string{"macho", "dylib"}},
+	{magic: "\xcf\xfa\xed\xfe", mime: "application/x-mach-binary", exts: []string{"macho", "dylib"}},
+	{magic: "\xca\xfe\xba\xbe", mime: "application/java-vm", exts: []string{"class"}},
+	{magic: "\x00asm", mime: "application/wasm", exts: []string{"wasm"}},
+	{magic: "%PDF-", mime: "application/pdf", exts: []string{"pdf"}},
+	{magic: "PK\x03\x04", mime: "application/zip", exts: []string{"zip"}, match: zipBased},
+	{magic: "PK\x05\x06", mime: "application/zip", exts: []string{"zip"}},
+	{magic: "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1", mime: "application/x-ole-storage", exts: []string{"doc", "xls", "ppt", "msi", "msg", "vsd"}},
+	{magic: "Rar!\x1a\x07", mime: "application/vnd.rar", exts: []string{"rar"}},
+	{magic: "7z\xbc\xaf\x27\x1c", mime: "application/x-7z-compressed", exts: []string{"7z"}},
+	{magic: "\x1f\x8b", mime: "application/gzip", exts: []string{"gz", "tgz", "gzip"}},
+	{magic: "BZh", mime: "application/x-bzip2", exts: []string{"bz2", "tbz2"}},
+	{magic: "\xfd7zXZ\x00", mime: "application/x-xz", exts: []string{"xz", "txz"}},
+	{magic: "\x28\xb5\x2f\xfd", mime: "application/zstd", exts: []string{"zst"}},
+	{magic: "MSCF", mime: "application/vnd.ms-cab-compressed", exts: []string{"cab"}},
+	{offset: 257, magic: "ustar", mime: "application/x-tar", exts: []string{"tar"}},
+	{magic: "SQLite format 3\x00", mime: "application/vnd.sqlite3", exts: []string{"sqlite", "db"}},
+	{magic: "{\\rtf", mime: "application/rtf", exts: []string{"rtf", "doc"}},
+	{magic: "%!PS", mime: "application/postscript", exts: []string{"ps", "eps", "ai"}},
+	{magic: "\x89PNG\r\n\x1a\n", mime: "image/png", exts: []string{"png"}},
+	{magic: "\xff\xd8\xff", mime: "image/jpeg", exts: []string{"jpg", "jpeg", "jpe", "jfif"}},
+	{magic: "GIF87a", mime: "image/gif", exts: []string{"gif"}},
+	{magic: "GIF89a", mime: "image/gif", exts: []string{"gif"}},
+	{magic: "II*\x00", mime: "image/tiff", exts: []string{"tif", "tiff"}},
+	{magic: "MM\x00*", mime: "image/tiff", exts: []string{"tif", "tiff"}},
+	{magic: "\x00\x00\x01\x00", mime: "image/x-icon", exts: []string{"ico"}},
+	{magic: "RIFF", mime: "application/x-riff", exts: []string{"riff"}, match: riffBased},
+	{offset: 4, magic: "ftyp", mime: "video/mp4", exts: []string{"mp4", "m4v", "m4a", "mov", "3gp", "heic", "avif"}},
+	{magic: "ID3", mime: "audio/mpeg", exts: []string{"mp3"}},
+	{magic: "OggS", mime: "audio/ogg", exts: []string{"ogg", "oga", "ogv", "opus"}},
+	{magic: "fLaC", mime: "audio/flac", exts: []string{"flac"}},
+	{magic: "\x1a\x45\xdf\xa3", mime: "video/x-matroska", exts: []string{"mkv", "webm", "mka"}},
+	{magic: "#!", mime: "text/x-script", exts: []string{"sh", "bash", "py", "pl", "rb"}, text: true},
+}
+
+// zipBased detects the formats which are zip files, the names of their first files are in the head of the file
+func zipBased(head []byte) (string, []string) {
+	has := func(s string) bool { return bytes.Contains(head, []byte(s)) }
+	switch {
+	case has("mimetypeapplication/epub+zip"):
+		return "application/epub+zip", []string{"epub"}
+	case has("mimetypeapplication/vnd.oasis.opendocument."):
+		return "application/vnd.oasis.opendocument", []string{"odt", "ods", "odp", "odg"}
+	case has("word/"):
+		return "application/vnd.openxmlformats-officedocument.wordprocessingml.document", []string{"docx", "docm", "dotx"}
+	case has("xl/"):
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", []string{"xlsx", "xlsm", "xltx"}
+	case has("ppt/"):
+		return "application/vnd.openxmlformats-officedocument.presentationml.presentation", []string{"pptx", "pptm", "potx"}
+	case has("AndroidManifest.xml") || has("classes.dex"):
+		return "application/vnd.android.package-archive", []string{"apk"}
+	case has("META-INF/"):
+		return "application/java-archive", []string{"jar", "war", "ear"}
+	}
+	return "", nil
+}
+
+// riffBased detects the RIFF formats upon their form type
+func riffBased(head []byte) (string, []string) {
+	if len(head) < 12 {
+		return "", nil
+	}
+	switch string(head[8:12]) {
+	case "WEBP":
+		return "image/webp", []string{"webp"}
+	case "WAVE":
+		return "audio/wav", []string{"wav"}
+	case "AVI ":
+		return "video/x-msvideo", []string{"avi"}
+	}
+	return "", nil
+}
+
+// the text formats which are detected by their first tag after the white spaces
+var markups = []struct {
+	prefix string
+	mime   string
+	exts   []string
+}{
+	{"<!doctype html", "text/html", []string{"html", "htm", "xhtml"}},
+	{"<html", "text/html", []string{"html", "htm", "xhtml"}},
+	{"<svg", "image/svg+xml", []string{"svg"}},
+	{"<?xml", "application/xml", []string{"xml", "xsl", "rss", "svg"}},
+}
+
+// Sniff detects the type of the file from its first bytes like libmagic does, the Content-Type of the HTTP
+// message is the type of the files which don't have known magic bytes
+func Sniff(head []byte, contentType string) Type {
+	for _, sig := range signatures {
+		if len(head) < sig.offset+len(sig.magic) || string(head[sig.offset:sig.offset+len(sig.magic)]) != sig.magic {
+			continue
+		}
+		t := Type{MIME: sig.mime, Extensions: sig.exts, Source: SourceMagic, text: sig.text}
+		if sig.match != nil {
+			if mimeType, exts := sig.match(head); mimeType != "" {
+				t.MIME, t.Extensions = mimeType, exts
+			}
+		}
+		return t
+	}
+	trimmed := strings.ToLower(string(bytes.TrimLeft(head, " \t\r\n\xef\xbb\xbf")))
+	for _, markup := range markups {
+		if strings.HasPrefix(trimmed, markup.prefix) {
+			return Type{MIME: markup.mime, Extensions: markup.exts, Source: SourceMagic, text: true}
+		}
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == Octet {
+		return Type{MIME: Octet, Source: SourceNone}
+	}
+	t := Type{MIME: mediaType, Source: SourceContentType}
+	exts, _ := mime.ExtensionsByType(mediaType)
+	for _, ext := range exts {
+		t.Extensions = append(t.Extensions, strings.TrimPrefix(ext, "."))
+	}
+	return t
+}
+
+// Agrees reports whether the extension of the file name doesn't contradict the magic bytes of the file, ex: an
+// executable which was renamed to .pdf. The file names without an extension and the types which weren't detected
+// from the magic bytes agree with everything
+func (t Type) Agrees(fileName string) bool {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(fileName), "."))
+	if ext == "" || t.Source != SourceMagic || t.text {
+		return true
+	}
+	for _, e := range t.Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchMIME returns how specific the pattern which matches the MIME type best is, 0 if none matches it. A pattern
+// is a MIME type (3), a type with any subtype like "image/*" (2) or "*" (1)
+func MatchMIME(mimeType string, patterns []string) int {
+	mimeType = strings.ToLower(mimeType)
+	best := 0
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		specificity := 0
+		switch {
+		case pattern == mimeType:
+			specificity = 3
+		case strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(pattern, "*")):
+			specificity = 2
+		case pattern == "*":
+			specificity = 1
+		}
+		if specificity > best {
+			best = specificity
+		}
+	}
+	return best
+}
@@ -0,0 +1,63 @@
+package sniff
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+)
+
+func TestSniff(t *testing.T) {
+	var docx bytes.Buffer
+	zw := zip.NewWriter(&docx)
+	w, _ := zw.Create("word/document.xml")
+	w.Write([]byte("<w:document/>"))
+	zw.Close()
+
+	tests := []struct {
+		name        string
+		head        []byte
+		contentType string
+		mime        string
+		source      string
+	}{
+		{"executable", []byte("MZ\x90\x00\x03\x00"), "application/pdf", "application/vnd.microsoft.portable-executable", SourceMagic},
+		{"pdf", []byte("%PDF-1.7\n"), "", "application/pdf", SourceMagic},
+		{"docx", docx.Bytes(), "application/zip", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", SourceMagic},
+		{"webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), "", "image/webp", SourceMagic},
+		{"html", []byte("\n  <!DOCTYPE html><html>"), "text/plain", "text/html", SourceMagic},
+		{"content type", []byte("a,b,c\n"), "text/csv; charset=utf-8", "text/csv", SourceContentType},
+		{"unknown", []byte("a,b,c\n"), "application/octet-stream", Octet, SourceNone},
+	}
+	for _, test := range tests {
+		got := Sniff(test.head, test.contentType)
+		if got.MIME != test.mime || got.Source != test.source {
+			t.Errorf("%s: expected %s from %s, got %s from %s", test.name, test.mime, test.source, got.MIME, got.Source)
+		}
+	}
+}
+
+func TestAgrees(t *testing.T) {
+	exe := Sniff([]byte("MZ\x90\x00"), "")
+	if exe.Agrees("invoice.pdf") {
+		t.Fatal("an executable which was renamed to .pdf shouldn't agree with its name")
+	}
+	if !exe.Agrees("setup.EXE") || !exe.Agrees("download") {
+		t.Fatal("an executable should agree with .exe and with the names without an extension")
+	}
+	html := Sniff([]byte("<html>"), "")
+	if !html.Agrees("index.php") {
+		t.Fatal("the text types shouldn't contradict their extensions")
+	}
+}
+
+func TestMatchMIME(t *testing.T) {
+	if MatchMIME("image/png", []string{"application/pdf", "image/*"}) != 2 {
+		t.Fatal("image/* should match image/png")
+	}
+	if MatchMIME("application/pdf", []string{"image/*", "application/zip"}) != 0 {
+		t.Fatal("application/pdf shouldn't match")
+	}
+	if MatchMIME("Application/PDF", []string{"*", "application/pdf"}) != 3 {
+		t.Fatal("the MIME type should match better than *")
+	}
+}
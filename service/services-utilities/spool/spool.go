@@ -0,0 +1,224 @@
+package spool
+
+import (
+	"bytes"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// SniffLen is the length of the head of a body which is enough to sniff its file type
+const SniffLen = 8 * 1024
+
+// the default memory limit of the bodies if [app.body_spooling] doesn't set memory_limit
+const defaultMemoryLimit = 1024 * 1024
+
+// the largest memory buffer of a body which is reused by the next bodies, the larger ones are left to the GC
+// so a single large body doesn't stay in memory
+const maxPooledBufferSize = defaultMemoryLimit
+
+var (
+	// the bytes of a body which are kept in memory, the rest is written to a temporary file, 0 = no limit
+	memoryLimit int64
+	tempDir     string
+	// the memory buffers of the bodies which were closed, they're reused by the next bodies
+	bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+)
+
+// InitBodySpooling reads the optional [app.body_spooling] section, the bodies of the HTTP messages are kept in
+// memory as they were if it doesn't exist
+func InitBodySpooling() {
+	if !readValues.IsSecExists("app.body_spooling") || !readValues.ReadValuesBool("app.body_spooling.enabled") {
+		return
+	}
+	memoryLimit = defaultMemoryLimit
+	if readValues.IsSecExists("app.body_spooling.memory_limit") {
+		if limit := readValues.ReadValuesInt("app.body_spooling.memory_limit"); limit > 0 {
+			memoryLimit = int64(limit)
+		}
+	}
+	if readValues.IsSecExists("app.body_spooling.temp_dir") {
+		tempDir = readValues.ReadValuesString("app.body_spooling.temp_dir")
+	}
+	logging.Logger.Debug("the bodies larger than " + strconv.FormatInt(memoryLimit, 10) +
+		" bytes are spooled to temporary files")
+}
+
+// Body is the body of an HTTP message which was read once from the ICAP client, every step of the transaction
+// reads it again from its start with Open. The first bytes up to the memory limit are kept in memory and the
+// rest is written to a temporary file, which is removed when the body is closed by all its holders. The memory
+// buffer is reused by the next bodies then, so the bytes of the body (ex: the ones of Head) mustn't be used after
+type Body struct {
+	head []byte
+	buf  *bytes.Buffer // the buffer of head, it's returned to the pool when the body is closed
+	file *os.File      // nil if the whole body is in memory
+	size int64
+	refs int32
+}
+
+// Read reads r to its end as a body with the memory limit of [app.body_spooling]
+func Read(r io.Reader) (*Body, error) {
+	return New(r, memoryLimit, tempDir)
+}
+
+// New reads r to its end, the bytes after the first memoryLimit bytes are written to a temporary file in dir,
+// the whole body is kept in memory if memoryLimit is 0. The size of the body is counted as it's read, so the
+// size limits are checked without holding the body in memory
+func New(r io.Reader, memoryLimit int64, dir string) (*Body, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	b := &Body{refs: 1, buf: buf}
+	if memoryLimit <= 0 {
+		n, err := buf.ReadFrom(r)
+		b.head, b.size = buf.Bytes(), n
+		return b, err
+	}
+	n, err := io.CopyN(buf, r, memoryLimit)
+	b.head, b.size = buf.Bytes(), n
+	if err == io.EOF {
+		return b, nil
+	}
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	// the body may end exactly at the memory limit, the file is created for a body which is larger only
+	var next [1]byte
+	if m, err := io.ReadFull(r, next[:]); m == 0 {
+		if err == io.EOF {
+			return b, nil
+		}
+		b.Close()
+		return nil, err
+	}
+	b.file, err = os.CreateTemp(dir, "icapeg-body-")
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	if _, err = b.file.Write(next[:]); err == nil {
+		n, err = io.Copy(b.file, r)
+	}
+	b.size += 1 + n
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// Size returns the size of the body
+func (b *Body) Size() int64 {
+	return b.size
+}
+
+// InMemory reports whether the whole body is in memory
+func (b *Body) InMemory() bool {
+	return b.file == nil
+}
+
+// Head returns the first n bytes of the body at most, ex: to sniff its file type
+func (b *Body) Head(n int) []byte {
+	if n <= len(b.head) {
+		return b.head[:n]
+	}
+	if int64(n) > b.size {
+		n = int(b.size)
+	}
+	head := make([]byte, n)
+	m, _ := b.ReadAt(head, 0)
+	return head[:m]
+}
+
+// ReadAt reads the body at the offset off
+func (b *Body) ReadAt(p []byte, off int64) (int, error) {
+	if off >= b.size {
+		return 0, io.EOF
+	}
+	n := 0
+	if off < int64(len(b.head)) {
+		n = copy(p, b.head[off:])
+	}
+	if n == len(p) {
+		return n, nil
+	}
+	if b.file == nil {
+		return n, io.EOF
+	}
+	m, err := b.file.ReadAt(p[n:], off+int64(n)-int64(len(b.head)))
+	return n + m, err
+}
+
+// Open returns a reader of the body from its start, readers can be opened while other readers of the body are
+// being read
+func (b *Body) Open() *Reader {
+	return &Reader{body: b}
+}
+
+// Retain adds a holder of the body, which must close it when it doesn't read it anymore, ex: a goroutine which
+// may outlive the transaction
+func (b *Body) Retain() {
+	atomic.AddInt32(&b.refs, 1)
+}
+
+// Close releases the body for its holder, the memory buffer is reused and the temporary file is removed when
+// the last holder closed it
+func (b *Body) Close() error {
+	if atomic.AddInt32(&b.refs, -1) != 0 {
+		return nil
+	}
+	buf := b.buf
+	b.head, b.buf = nil, nil
+	if buf.Cap() <= maxPooledBufferSize {
+		buf.Reset()
+		bufferPool.Put(buf)
+	}
+	if b.file == nil {
+		return nil
+	}
+	b.file.Close()
+	return os.Remove(b.file.Name())
+}
+
+// Reader reads a spooled body, it has the Len of the unread bytes, so the Content-Length of the HTTP message
+// is set from it when it's sent to the ICAP client
+type Reader struct {
+	body *Body
+	off  int64
+}
+
+// Read reads the next bytes of the body
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.off >= r.body.size {
+		return 0, io.EOF
+	}
+	n, err := r.body.ReadAt(p, r.off)
+	r.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Len returns the number of the unread bytes of the body
+func (r *Reader) Len() int {
+	return int(r.body.size - r.off)
+}
+
+// Close doesn't release the body, the body is closed when its transaction ends because the other steps of
+// the transaction may still read it
+func (r *Reader) Close() error {
+	return nil
+}
+
+// Of returns the spooled body of an HTTP message body which is a reader of a spooled body which wasn't read yet
+func Of(body io.Reader) (*Body, bool) {
+	r, spooled := body.(*Reader)
+	if !spooled || r.off != 0 {
+		return nil, false
+	}
+	return r.body, true
+}
@@ -0,0 +1,109 @@
+package spool
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestSpoolToFile(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	b, err := New(bytes.NewReader(data), 64, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.InMemory() || b.Size() != int64(len(data)) {
+		t.Fatalf("InMemory() = %v, Size() = %d", b.InMemory(), b.Size())
+	}
+	// every reader reads the whole body from its start
+	for n := 0; n < 2; n++ {
+		r := b.Open()
+		if r.Len() != len(data) {
+			t.Fatalf("Len() = %d", r.Len())
+		}
+		read, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(read, data) {
+			t.Fatalf("reader %d read %d bytes, %v", n, len(read), err)
+		}
+	}
+	if head := b.Head(100); !bytes.Equal(head, data[:100]) {
+		t.Fatalf("Head(100) = %q", head)
+	}
+	b.Retain()
+	b.Close()
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Fatal("the temporary file was removed while the body is retained")
+	}
+	b.Close()
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Fatal("the temporary file wasn't removed")
+	}
+}
+
+func TestSpoolInMemory(t *testing.T) {
+	dir := t.TempDir()
+	for _, limit := range []int64{0, 10, 64} {
+		b, err := New(bytes.NewReader([]byte("0123456789")), limit, dir)
+		if err != nil || !b.InMemory() || b.Size() != 10 {
+			t.Fatalf("limit %d: InMemory() = %v, Size() = %d, %v", limit, b.InMemory(), b.Size(), err)
+		}
+		if read, _ := io.ReadAll(b.Open()); string(read) != "0123456789" {
+			t.Fatalf("limit %d: read %q", limit, read)
+		}
+		b.Close()
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Fatal("a temporary file was created for a body which fits in memory")
+	}
+}
+
+func TestOf(t *testing.T) {
+	b, _ := New(bytes.NewReader([]byte("body")), 0, "")
+	r := b.Open()
+	if spooled, isSpooled := Of(r); !isSpooled || spooled != b {
+		t.Fatal("Of() didn't return the spooled body")
+	}
+	r.Read(make([]byte, 1))
+	if _, isSpooled := Of(r); isSpooled {
+		t.Fatal("Of() returned the body of a reader which was read")
+	}
+	if _, isSpooled := Of(io.NopCloser(bytes.NewReader(nil))); isSpooled {
+		t.Fatal("Of() returned a body of a reader which isn't spooled")
+	}
+}
+
+func TestCloseReleasesTheBuffer(t *testing.T) {
+	b, err := New(bytes.NewReader([]byte("0123456789")), 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Retain()
+	b.Close()
+	if head := b.Head(4); string(head) != "0123" {
+		t.Fatalf("the buffer was released while the body is retained, Head(4) = %q", head)
+	}
+	b.Close()
+	if b.buf != nil || b.head != nil {
+		t.Fatal("the buffer wasn't released by the last holder")
+	}
+	// the next body gets a buffer which is empty
+	next, _ := New(bytes.NewReader([]byte("abc")), 64, "")
+	defer next.Close()
+	if read, _ := io.ReadAll(next.Open()); string(read) != "abc" {
+		t.Fatalf("read %q from the next body", read)
+	}
+}
+
+func BenchmarkNewInMemory(b *testing.B) {
+	data := bytes.Repeat([]byte("0123456789"), 6400)
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		body, err := New(bytes.NewReader(data), 0, "")
+		if err != nil {
+			b.Fatal(err)
+		}
+		body.Close()
+	}
+}
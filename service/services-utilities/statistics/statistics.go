@@ -0,0 +1,217 @@
+package statistics
+
+import (
+	"icapeg/logging"
+	"icapeg/readValues"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the fields which the statistics are grouped by
+const (
+	GroupService = "service"
+	GroupVendor  = "vendor"
+	GroupVerdict = "verdict"
+)
+
+// the verdicts of the transactions
+const (
+	VerdictClean     = "clean"
+	VerdictMalicious = "malicious"
+	VerdictError     = "error"
+	VerdictPending   = "pending"  // the file was delivered before the verdict
+	VerdictNone      = "none"     // the file wasn't scanned
+	VerdictOversize  = "oversize" // the body exceeded the max_size of [app.body_limit] or the max_filesize of a blocking service
+)
+
+// Key identifies the counters of a bucket
+type Key struct {
+	Service string
+	Vendor  string
+	Verdict string
+}
+
+type counters struct {
+	requests   uint64
+	bytes      uint64
+	durationMs uint64
+}
+
+type bucket struct {
+	start    time.Time
+	counters map[Key]*counters
+}
+
+// Row represents the statistics of a group in a period
+type Row struct {
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Service       string    `json:"service,omitempty"`
+	Vendor        string    `json:"vendor,omitempty"`
+	Verdict       string    `json:"verdict,omitempty"`
+	Requests      uint64    `json:"requests"`
+	Bytes         uint64    `json:"bytes"`
+	AvgDurationMs uint64    `json:"avg_duration_ms"`
+}
+
+// Store keeps the statistics of the transactions in buckets of bucketSize up to the retention
+type Store struct {
+	mu         sync.Mutex
+	bucketSize time.Duration
+	retention  time.Duration
+	buckets    []*bucket // ordered by their start
+	now        func() time.Time
+}
+
+var store *Store
+
+// InitStatistics reads the optional [app.statistics] section, the statistics aren't kept if it doesn't exist
+func InitStatistics() {
+	if !readValues.IsSecExists("app.statistics") || !readValues.ReadValuesBool("app.statistics.enabled") {
+		return
+	}
+	bucketSize := readValues.ReadValuesDuration("app.statistics.bucket") * time.Second
+	retention := readValues.ReadValuesDuration("app.statistics.retention") * time.Second
+	if bucketSize <= 0 || retention < bucketSize {
+		logging.Logger.Error("statistics bucket must be positive and retention can't be shorter than it, " +
+			"the statistics aren't kept")
+		return
+	}
+	store = New(bucketSize, retention)
+}
+
+// New creates a store of the statistics
+func New(bucketSize, retention time.Duration) *Store {
+	return &Store{bucketSize: bucketSize, retention: retention, now: time.Now}
+}
+
+// Enabled reports whether the statistics are kept
+func Enabled() bool {
+	return store != nil
+}
+
+// Record counts a transaction in the statistics
+func Record(key Key, bytes int, duration time.Duration) {
+	if store != nil {
+		store.Record(key, bytes, duration)
+	}
+}
+
+// Query returns the statistics of the store, see Store.Query
+func Query(from, to time.Time, interval time.Duration, groupBy []string) []Row {
+	if store == nil {
+		return nil
+	}
+	return store.Query(from, to, interval, groupBy)
+}
+
+// Retention returns the period which the statistics are kept for
+func Retention() time.Duration {
+	if store == nil {
+		return 0
+	}
+	return store.retention
+}
+
+// Record counts a transaction in the current bucket, the buckets older than the retention are dropped
+func (s *Store) Record(key Key, bytes int, duration time.Duration) {
+	now := s.now()
+	start := now.Truncate(s.bucketSize)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b *bucket
+	if n := len(s.buckets); n > 0 && s.buckets[n-1].start.Equal(start) {
+		b = s.buckets[n-1]
+	} else {
+		b = &bucket{start: start, counters: make(map[Key]*counters)}
+		s.buckets = append(s.buckets, b)
+		oldest := now.Add(-s.retention)
+		for len(s.buckets) > 0 && s.buckets[0].start.Add(s.bucketSize).Before(oldest) {
+			s.buckets = s.buckets[1:]
+		}
+	}
+	c := b.counters[key]
+	if c == nil {
+		c = &counters{}
+		b.counters[key] = c
+	}
+	c.requests++
+	c.bytes += uint64(bytes)
+	c.durationMs += uint64(duration.Milliseconds())
+}
+
+// Query returns the statistics of the buckets which start in [from, to) grouped by the fields of groupBy,
+// the other fields are left empty. An interval of zero returns one row per group for the whole range,
+// otherwise a row per group for every interval (rounded up to a multiple of the bucket size) since the epoch
+func (s *Store) Query(from, to time.Time, interval time.Duration, groupBy []string) []Row {
+	if interval > 0 && interval%s.bucketSize != 0 {
+		interval = (interval/s.bucketSize + 1) * s.bucketSize
+	}
+	group := make(map[string]bool)
+	for _, field := range groupBy {
+		group[strings.ToLower(strings.TrimSpace(field))] = true
+	}
+
+	type rowKey struct {
+		start time.Time
+		key   Key
+	}
+	rows := make(map[rowKey]*Row)
+	s.mu.Lock()
+	for _, b := range s.buckets {
+		if b.start.Before(from) || !b.start.Before(to) {
+			continue
+		}
+		start, end := from, to
+		if interval > 0 {
+			start = b.start.Truncate(interval)
+			end = start.Add(interval)
+		}
+		for key, c := range b.counters {
+			k := rowKey{start: start}
+			if group[GroupService] {
+				k.key.Service = key.Service
+			}
+			if group[GroupVendor] {
+				k.key.Vendor = key.Vendor
+			}
+			if group[GroupVerdict] {
+				k.key.Verdict = key.Verdict
+			}
+			r := rows[k]
+			if r == nil {
+				r = &Row{Start: start, End: end, Service: k.key.Service, Vendor: k.key.Vendor, Verdict: k.key.Verdict}
+				rows[k] = r
+			}
+			r.Requests += c.requests
+			r.Bytes += c.bytes
+			// the total duration is kept in AvgDurationMs until the rows are complete
+			r.AvgDurationMs += c.durationMs
+		}
+	}
+	s.mu.Unlock()
+
+	result := make([]Row, 0, len(rows))
+	for _, r := range rows {
+		if r.Requests > 0 {
+			r.AvgDurationMs /= r.Requests
+		}
+		result = append(result, *r)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		if a.Vendor != b.Vendor {
+			return a.Vendor < b.Vendor
+		}
+		return a.Verdict < b.Verdict
+	})
+	return result
+}
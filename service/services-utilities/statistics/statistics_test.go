@@ -0,0 +1,50 @@
+package statistics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatisticsGrouping(t *testing.T) {
+	now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	s := New(time.Minute, time.Hour)
+	s.now = func() time.Time { return now }
+	s.Record(Key{Service: "clamav", Vendor: "clamav", Verdict: VerdictClean}, 100, 10*time.Millisecond)
+	s.Record(Key{Service: "clamav", Vendor: "clamav", Verdict: VerdictMalicious}, 300, 30*time.Millisecond)
+	now = now.Add(2 * time.Minute)
+	s.Record(Key{Service: "echo", Vendor: "echo", Verdict: VerdictNone}, 50, 0)
+
+	from, to := now.Add(-time.Hour), now.Add(time.Minute)
+	rows := s.Query(from, to, 0, []string{GroupService})
+	if len(rows) != 2 || rows[0].Service != "clamav" || rows[0].Requests != 2 || rows[0].Bytes != 400 ||
+		rows[0].AvgDurationMs != 20 || rows[0].Verdict != "" {
+		t.Fatalf("unexpected rows by service %+v", rows)
+	}
+	if rows = s.Query(from, to, 0, []string{GroupVerdict}); len(rows) != 3 {
+		t.Fatalf("unexpected rows by verdict %+v", rows)
+	}
+	if rows = s.Query(from, to, 0, nil); len(rows) != 1 || rows[0].Requests != 3 {
+		t.Fatalf("unexpected totals %+v", rows)
+	}
+}
+
+func TestStatisticsIntervalsAndRetention(t *testing.T) {
+	now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	s := New(time.Minute, 10*time.Minute)
+	s.now = func() time.Time { return now }
+	for i := 0; i < 15; i++ {
+		s.Record(Key{Service: "clamav"}, 1, 0)
+		now = now.Add(time.Minute)
+	}
+	rows := s.Query(now.Add(-20*time.Minute), now, 5*time.Minute, nil)
+	var total uint64
+	for _, r := range rows {
+		if r.End.Sub(r.Start) != 5*time.Minute {
+			t.Fatalf("unexpected interval %+v", r)
+		}
+		total += r.Requests
+	}
+	if total != 12 {
+		t.Fatalf("the buckets older than the retention should be dropped, got %d requests", total)
+	}
+}
@@ -0,0 +1,72 @@
+package streaming
+
+import (
+	"icapeg/logging"
+	"icapeg/readValues"
+	"mime"
+	"strings"
+)
+
+// the answers to the HTTP responses of streaming media
+const (
+	ActionBypass   = "bypass"
+	ActionHashOnly = "hash_only"
+)
+
+// Policy represents [app.streaming_media] section configuration, the HTTP responses whose Content-Type is
+// streaming media aren't sent to the vendors whatever their extension is, since a live stream never ends
+// and buffering it breaks the playback
+type Policy struct {
+	Action       string
+	ContentTypes []string // the media types, a type ending with /* matches all its subtypes (ex: video/*)
+}
+
+var policy *Policy
+
+// InitStreamingMedia reads the optional [app.streaming_media] section, the streaming media are scanned like
+// other files if it doesn't exist
+func InitStreamingMedia() {
+	if !readValues.IsSecExists("app.streaming_media") || !readValues.ReadValuesBool("app.streaming_media.enabled") {
+		return
+	}
+	action := strings.ToLower(readValues.ReadValuesString("app.streaming_media.action"))
+	if action != ActionBypass && action != ActionHashOnly {
+		logging.Logger.Error("streaming_media action must be " + ActionBypass + " or " + ActionHashOnly +
+			", the streaming media are scanned")
+		return
+	}
+	policy = New(action, readValues.ReadValuesSlice("app.streaming_media.content_types"))
+	logging.Logger.Info("the streaming media are answered with " + action + " policy")
+}
+
+// New creates a streaming media policy
+func New(action string, contentTypes []string) *Policy {
+	p := &Policy{Action: action}
+	for _, contentType := range contentTypes {
+		p.ContentTypes = append(p.ContentTypes, strings.ToLower(strings.TrimSpace(contentType)))
+	}
+	return p
+}
+
+// Current returns the streaming media policy, it returns nil if it's disabled
+func Current() *Policy {
+	return policy
+}
+
+// Matches reports whether the Content-Type header is one of the streaming media types of the policy
+func (p *Policy) Matches(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, streamingType := range p.ContentTypes {
+		if prefix := strings.TrimSuffix(streamingType, "*"); prefix != streamingType {
+			if strings.HasPrefix(mediaType, prefix) {
+				return true
+			}
+		} else if mediaType == streamingType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+package streaming
+
+import "testing"
+
+func TestMatches(t *testing.T) {
+	p := New(ActionBypass, []string{"video/*", "audio/*", "application/x-mpegURL", "application/dash+xml"})
+	tests := map[string]bool{
+		"video/mp4":                            true,
+		"audio/mpeg":                           true,
+		"application/x-mpegurl; charset=UTF-8": true,
+		"Application/Dash+XML":                 true,
+		"application/octet-stream":             false,
+		"videos/mp4":                           false,
+		"":                                     false,
+	}
+	for contentType, expected := range tests {
+		if p.Matches(contentType) != expected {
+			t.Errorf("%q: expected %v", contentType, expected)
+		}
+	}
+}
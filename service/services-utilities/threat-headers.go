@@ -0,0 +1,84 @@
+package services_utilities
+
+import (
+	"strconv"
+	"strings"
+)
+
+// the ICAP headers which the ICAP clients (ex: Squid, Blue Coat) log and display the threats of the blocked
+// HTTP messages from
+const (
+	InfectionFoundHeader  = "X-Infection-Found"
+	ViolationsFoundHeader = "X-Violations-Found"
+	ResponseInfoHeader    = "X-Response-Info"
+	ResponseDescHeader    = "X-Response-Desc"
+)
+
+// the types of the threats of X-Infection-Found
+const (
+	ThreatTypeInfection = 0 // a virus or a malware
+	ThreatTypePolicy    = 1 // a policy violation, ex: a rejected file type
+	ThreatTypeContainer = 2 // a container which couldn't be scanned, ex: an encrypted archive
+)
+
+// the resolutions of X-Infection-Found and X-Violations-Found
+const (
+	ResolutionNotRepaired = 0
+	ResolutionRepaired    = 1
+	ResolutionBlocked     = 2
+)
+
+// Violation is a threat which a vendor found in an HTTP message
+type Violation struct {
+	FileName   string
+	Threat     string
+	Type       int
+	Resolution int
+	ProblemID  int // the vendor code of the threat, 0 if the vendor doesn't have one
+}
+
+// AddThreatHeaders adds the headers of the violation to the headers of the ICAP response, so every vendor fills
+// them the same way:
+//
+//	X-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Signature;
+//	X-Violations-Found: 1
+//		eicar.com
+//		Eicar-Signature
+//		0
+//		2
+//	X-Response-Info: Blocked
+//	X-Response-Desc: Eicar-Signature found in eicar.com
+func AddThreatHeaders(serviceHeaders map[string]string, v Violation) {
+	threat := headerSafe(v.Threat)
+	if threat == "" {
+		threat = "Unknown"
+	}
+	fileName := headerSafe(v.FileName)
+	if fileName == "" {
+		fileName = "-"
+	}
+	serviceHeaders[InfectionFoundHeader] = "Type=" + strconv.Itoa(v.Type) + "; Resolution=" +
+		strconv.Itoa(v.Resolution) + "; Threat=" + threat + ";"
+	// the lines of the violation are folded, the ICAP response writer keeps the folds
+	serviceHeaders[ViolationsFoundHeader] = strings.Join([]string{"1", fileName, threat,
+		strconv.Itoa(v.ProblemID), strconv.Itoa(v.Resolution)}, "\r\n\t")
+	switch v.Resolution {
+	case ResolutionRepaired:
+		serviceHeaders[ResponseInfoHeader] = "Repaired"
+	case ResolutionNotRepaired:
+		serviceHeaders[ResponseInfoHeader] = "Passed"
+	default:
+		serviceHeaders[ResponseInfoHeader] = "Blocked"
+	}
+	serviceHeaders[ResponseDescHeader] = threat + " found in " + fileName
+}
+
+// headerSafe removes the characters which would break the headers, ex: the ";" of X-Infection-Found
+func headerSafe(value string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r == ';' || r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, value))
+}
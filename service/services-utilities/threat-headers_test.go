@@ -0,0 +1,25 @@
+package services_utilities
+
+import "testing"
+
+func TestAddThreatHeaders(t *testing.T) {
+	headers := make(map[string]string)
+	AddThreatHeaders(headers, Violation{FileName: "eicar.com", Threat: "Eicar;Signature", Resolution: ResolutionBlocked})
+	expected := map[string]string{
+		InfectionFoundHeader:  "Type=0; Resolution=2; Threat=Eicar Signature;",
+		ViolationsFoundHeader: "1\r\n\teicar.com\r\n\tEicar Signature\r\n\t0\r\n\t2",
+		ResponseInfoHeader:    "Blocked",
+		ResponseDescHeader:    "Eicar Signature found in eicar.com",
+	}
+	for key, value := range expected {
+		if headers[key] != value {
+			t.Errorf("%s: expected %q, got %q", key, value, headers[key])
+		}
+	}
+
+	AddThreatHeaders(headers, Violation{Type: ThreatTypeContainer, Resolution: ResolutionBlocked})
+	if headers[InfectionFoundHeader] != "Type=2; Resolution=2; Threat=Unknown;" || headers[ResponseDescHeader] != "Unknown found in -" {
+		t.Fatalf("a violation without a threat and a file name got %q, %q", headers[InfectionFoundHeader],
+			headers[ResponseDescHeader])
+	}
+}
@@ -0,0 +1,98 @@
+package throttle
+
+import (
+	"icapeg/logging"
+	"icapeg/readValues"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket of bytes which is shared by all uploads to a vendor, it's refilled with rate bytes
+// per second up to burst bytes
+type Bucket struct {
+	mu       sync.Mutex
+	rate     float64
+	burst    int
+	tokens   float64
+	refilled time.Time
+	now      func() time.Time
+	sleep    func(time.Duration)
+}
+
+var buckets = make(map[string]*Bucket)
+
+// InitThrottling reads the optional [app.upload_throttling] section, the uploads to the vendors aren't
+// throttled if it doesn't exist, every [app.upload_throttling.<vendor>] sub section caps the uploads to a vendor
+func InitThrottling() {
+	if !readValues.IsSecExists("app.upload_throttling") || !readValues.ReadValuesBool("app.upload_throttling.enabled") {
+		return
+	}
+	for _, vendor := range readValues.ReadSubSections("app.upload_throttling") {
+		sec := "app.upload_throttling." + vendor
+		rate := readValues.ReadValuesInt(sec + ".bytes_per_second")
+		burst := readValues.ReadValuesInt(sec + ".burst")
+		if rate <= 0 || burst <= 0 {
+			logging.Logger.Error("upload_throttling bytes_per_second and burst of " + vendor +
+				" vendor must be positive, the uploads to it aren't throttled")
+			continue
+		}
+		logging.Logger.Debug("the uploads to " + vendor + " vendor are throttled")
+		buckets[strings.ToLower(vendor)] = NewBucket(rate, burst)
+	}
+}
+
+// NewBucket creates a full token bucket
+func NewBucket(bytesPerSecond, burst int) *Bucket {
+	return &Bucket{rate: float64(bytesPerSecond), burst: burst, tokens: float64(burst), refilled: time.Now(),
+		now: time.Now, sleep: time.Sleep}
+}
+
+// Reader returns the upload stream to the vendor, throttled if the uploads to the vendor are capped
+func Reader(vendor string, r io.Reader) io.Reader {
+	b, exists := buckets[vendor]
+	if !exists {
+		return r
+	}
+	return b.Reader(r)
+}
+
+// Reader returns r throttled by the bucket
+func (b *Bucket) Reader(r io.Reader) io.Reader {
+	return &reader{r: r, bucket: b}
+}
+
+// Wait blocks until n bytes, which are at most the burst, may be uploaded and takes them from the bucket
+func (b *Bucket) Wait(n int) {
+	b.mu.Lock()
+	now := b.now()
+	b.tokens += now.Sub(b.refilled).Seconds() * b.rate
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+	b.refilled = now
+	// the bytes are taken at once, so the uploads which wait after this one wait for its deficit too
+	b.tokens -= float64(n)
+	deficit := -b.tokens
+	b.mu.Unlock()
+	if deficit > 0 {
+		b.sleep(time.Duration(deficit / b.rate * float64(time.Second)))
+	}
+}
+
+type reader struct {
+	r      io.Reader
+	bucket *Bucket
+}
+
+func (t *reader) Read(p []byte) (int, error) {
+	if len(p) > t.bucket.burst {
+		p = p[:t.bucket.burst]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.bucket.Wait(n)
+	}
+	return n, err
+}
@@ -0,0 +1,43 @@
+package throttle
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBucketThrottlesUploads(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var slept time.Duration
+	b := NewBucket(100, 50)
+	b.refilled = now
+	b.now = func() time.Time { return now }
+	b.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+	body, err := io.ReadAll(b.Reader(bytes.NewReader(make([]byte, 250))))
+	if err != nil || len(body) != 250 {
+		t.Fatalf("the whole stream should be read, got %d bytes, %v", len(body), err)
+	}
+	// the burst is uploaded at once and the other 200 bytes at 100 bytes per second
+	if slept != 2*time.Second {
+		t.Fatalf("expected to wait 2s, waited %s", slept)
+	}
+}
+
+func TestBucketRefill(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var slept time.Duration
+	b := NewBucket(100, 100)
+	b.refilled = now
+	b.now = func() time.Time { return now }
+	b.sleep = func(d time.Duration) { slept += d }
+	b.Wait(100)
+	now = now.Add(time.Hour)
+	b.Wait(100)
+	if slept != 0 {
+		t.Fatalf("the bucket should be refilled up to the burst only, waited %s", slept)
+	}
+}
@@ -0,0 +1,102 @@
+package toggles
+
+import (
+	"sort"
+	"sync"
+)
+
+// the services which were disabled at runtime, their ICAP requests are answered without scanning, and the
+// methods (REQMOD, RESPMOD) which were disabled at runtime, they aren't allowed like the methods which are
+// disabled by req_mode and resp_mode
+var (
+	mu              sync.RWMutex
+	disabled        = make(map[string]bool)
+	disabledMethods = make(map[string]map[string]bool)
+	replicator      func(service, method string, enabled bool)
+)
+
+// SetReplicator sets the func which is told about the toggles which are changed on this instance, ex: the
+// cluster mode sends them to the other gateway instances
+func SetReplicator(r func(service, method string, enabled bool)) {
+	mu.Lock()
+	defer mu.Unlock()
+	replicator = r
+}
+
+// SetEnabled enables or disables the service at runtime, or one of its methods if the method isn't empty, and
+// replicates the change
+func SetEnabled(service, method string, enabled bool) {
+	Apply(service, method, enabled)
+	mu.RLock()
+	r := replicator
+	mu.RUnlock()
+	if r != nil {
+		r(service, method, enabled)
+	}
+}
+
+// Apply enables or disables the service or its method without replicating the change, ex: a change of another
+// instance
+func Apply(service, method string, enabled bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	if method != "" {
+		if enabled {
+			delete(disabledMethods[service], method)
+			if len(disabledMethods[service]) == 0 {
+				delete(disabledMethods, service)
+			}
+		} else {
+			if disabledMethods[service] == nil {
+				disabledMethods[service] = make(map[string]bool)
+			}
+			disabledMethods[service][method] = true
+		}
+		return
+	}
+	if enabled {
+		delete(disabled, service)
+	} else {
+		disabled[service] = true
+	}
+}
+
+// IsDisabled reports whether the service was disabled at runtime
+func IsDisabled(service string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return disabled[service]
+}
+
+// IsMethodDisabled reports whether the method of the service was disabled at runtime
+func IsMethodDisabled(service, method string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return disabledMethods[service][method]
+}
+
+// Disabled returns the sorted names of the services which were disabled at runtime
+func Disabled() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	services := make([]string, 0, len(disabled))
+	for service := range disabled {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// DisabledMethods returns the sorted methods which were disabled at runtime by service
+func DisabledMethods() map[string][]string {
+	mu.RLock()
+	defer mu.RUnlock()
+	result := make(map[string][]string, len(disabledMethods))
+	for service, methods := range disabledMethods {
+		for method := range methods {
+			result[service] = append(result[service], method)
+		}
+		sort.Strings(result[service])
+	}
+	return result
+}
@@ -0,0 +1,180 @@
+package toptalkers
+
+import (
+	"icapeg/logging"
+	"icapeg/readValues"
+	"sort"
+	"sync"
+	"time"
+)
+
+// the number of buckets of the rolling window, the counters of a bucket are dropped at once when it leaves the window
+const bucketCount = 60
+
+// OtherClients is the client of the counters of the clients which exceed max_clients in a bucket
+const OtherClients = "other"
+
+// the orders of the top talkers
+const (
+	ByRequests = "requests"
+	ByBytes    = "bytes"
+	ByBlocks   = "blocks"
+)
+
+// Counters represents the traffic of a client in the rolling window
+type Counters struct {
+	ClientIP     string `json:"client_ip"`
+	Requests     uint64 `json:"requests"`
+	BytesScanned uint64 `json:"bytes_scanned"`
+	Blocks       uint64 `json:"blocks"`
+}
+
+type bucket struct {
+	start   int64 // the index of the bucket since the epoch
+	clients map[string]*Counters
+}
+
+// Window counts the traffic of the clients in a rolling window
+type Window struct {
+	mu         sync.Mutex
+	bucketSize time.Duration
+	maxClients int
+	buckets    [bucketCount]bucket
+	now        func() time.Time
+}
+
+var window *Window
+
+// InitTopTalkers reads the optional [app.top_talkers] section, the traffic of the clients isn't counted
+// if it doesn't exist
+func InitTopTalkers() {
+	if !readValues.IsSecExists("app.top_talkers") || !readValues.ReadValuesBool("app.top_talkers.enabled") {
+		return
+	}
+	size := readValues.ReadValuesDuration("app.top_talkers.window") * time.Second
+	if size <= 0 {
+		logging.Logger.Error("top_talkers window must be positive, the traffic of the clients isn't counted")
+		return
+	}
+	window = New(size, readValues.ReadValuesInt("app.top_talkers.max_clients"))
+}
+
+// New creates a rolling window of the size, a max clients of zero counts every client separately
+func New(size time.Duration, maxClients int) *Window {
+	bucketSize := size / bucketCount
+	if bucketSize <= 0 {
+		bucketSize = 1
+	}
+	return &Window{bucketSize: bucketSize, maxClients: maxClients, now: time.Now}
+}
+
+// Enabled reports whether the traffic of the clients is counted
+func Enabled() bool {
+	return window != nil
+}
+
+// Request counts a request of the client and the bytes which were scanned for it
+func Request(clientIP string, bytesScanned int) {
+	if window != nil {
+		window.Add(clientIP, 1, uint64(bytesScanned), 0)
+	}
+}
+
+// Block counts a blocked request of the client
+func Block(clientIP string) {
+	if window != nil {
+		window.Add(clientIP, 0, 0, 1)
+	}
+}
+
+// Top returns the top talkers of the window ordered by requests, bytes or blocks
+func Top(n int, by string) ([]Counters, time.Duration) {
+	if window == nil {
+		return nil, 0
+	}
+	return window.Top(n, by), window.bucketSize * bucketCount
+}
+
+// Add adds to the counters of the client in the current bucket
+func (w *Window) Add(clientIP string, requests, bytesScanned, blocks uint64) {
+	if clientIP == "" {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b := w.current()
+	c, exists := b.clients[clientIP]
+	if !exists {
+		if w.maxClients > 0 && len(b.clients) >= w.maxClients {
+			clientIP = OtherClients
+		}
+		if c = b.clients[clientIP]; c == nil {
+			c = &Counters{ClientIP: clientIP}
+			b.clients[clientIP] = c
+		}
+	}
+	c.Requests += requests
+	c.BytesScanned += bytesScanned
+	c.Blocks += blocks
+}
+
+// current returns the bucket of the current time, it's cleared if it belonged to an earlier round of the window
+func (w *Window) current() *bucket {
+	start := w.now().UnixNano() / int64(w.bucketSize)
+	b := &w.buckets[start%bucketCount]
+	if b.start != start || b.clients == nil {
+		b.start = start
+		b.clients = make(map[string]*Counters)
+	}
+	return b
+}
+
+// Top returns the n clients with most requests, bytes or blocks in the window, n of zero returns all clients
+func (w *Window) Top(n int, by string) []Counters {
+	w.mu.Lock()
+	oldest := w.now().UnixNano()/int64(w.bucketSize) - bucketCount + 1
+	totals := make(map[string]*Counters)
+	for i := range w.buckets {
+		b := &w.buckets[i]
+		if b.start < oldest {
+			continue
+		}
+		for clientIP, c := range b.clients {
+			t := totals[clientIP]
+			if t == nil {
+				t = &Counters{ClientIP: clientIP}
+				totals[clientIP] = t
+			}
+			t.Requests += c.Requests
+			t.BytesScanned += c.BytesScanned
+			t.Blocks += c.Blocks
+		}
+	}
+	w.mu.Unlock()
+
+	top := make([]Counters, 0, len(totals))
+	for _, t := range totals {
+		top = append(top, *t)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		a, b := value(top[i], by), value(top[j], by)
+		if a != b {
+			return a > b
+		}
+		return top[i].ClientIP < top[j].ClientIP
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+func value(c Counters, by string) uint64 {
+	switch by {
+	case ByBytes:
+		return c.BytesScanned
+	case ByBlocks:
+		return c.Blocks
+	}
+	return c.Requests
+}
@@ -0,0 +1,46 @@
+package toptalkers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTopTalkersOrder(t *testing.T) {
+	w := New(time.Minute, 0)
+	w.Add("10.0.0.1", 1, 100, 0)
+	w.Add("10.0.0.2", 1, 5000, 1)
+	w.Add("10.0.0.1", 1, 100, 0)
+	if top := w.Top(1, ByRequests); len(top) != 1 || top[0].ClientIP != "10.0.0.1" || top[0].Requests != 2 {
+		t.Fatalf("unexpected top talkers by requests %+v", top)
+	}
+	if top := w.Top(0, ByBytes); len(top) != 2 || top[0].ClientIP != "10.0.0.2" || top[1].BytesScanned != 200 {
+		t.Fatalf("unexpected top talkers by bytes %+v", top)
+	}
+}
+
+func TestTopTalkersRollingWindow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	w := New(time.Minute, 0)
+	w.now = func() time.Time { return now }
+	w.Add("10.0.0.1", 1, 0, 0)
+	now = now.Add(30 * time.Second)
+	w.Add("10.0.0.2", 1, 0, 0)
+	if top := w.Top(0, ByRequests); len(top) != 2 {
+		t.Fatalf("both clients should be in the window %+v", top)
+	}
+	now = now.Add(45 * time.Second)
+	if top := w.Top(0, ByRequests); len(top) != 1 || top[0].ClientIP != "10.0.0.2" {
+		t.Fatalf("the first client should have left the window %+v", top)
+	}
+}
+
+func TestTopTalkersMaxClients(t *testing.T) {
+	w := New(time.Minute, 1)
+	w.Add("10.0.0.1", 1, 0, 0)
+	w.Add("10.0.0.2", 1, 0, 0)
+	w.Add("10.0.0.3", 1, 0, 0)
+	top := w.Top(0, ByRequests)
+	if len(top) != 2 || top[0].ClientIP != OtherClients || top[0].Requests != 2 {
+		t.Fatalf("the clients above max_clients should be counted together %+v", top)
+	}
+}
@@ -0,0 +1,192 @@
+package tracing
+
+import (
+	"context"
+	"icapeg/icap"
+	"icapeg/logging"
+	"icapeg/readValues"
+	"icapeg/version"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// the name of the tracer of the spans of ICAPeg
+const tracerName = "icapeg"
+
+var (
+	provider *sdktrace.TracerProvider // nil if the tracing isn't enabled
+	tracer   = otel.Tracer(tracerName)
+	// the trace context of W3C (traceparent and tracestate) and the baggage
+	propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+	// the contexts of the transactions whose vendors are being called, by their X-ICAP-Metadata
+	transactions sync.Map
+)
+
+// InitTracing reads the optional [app.tracing] section and exports the spans of the ICAP transactions and of their
+// vendor calls with OTLP over HTTP, so a slow download at the proxy can be correlated with a slow vendor API call
+func InitTracing() {
+	if !readValues.IsSecExists("app.tracing") || !readValues.ReadValuesBool("app.tracing.enabled") {
+		return
+	}
+	// the keys which aren't in the section are the OTEL_EXPORTER_OTLP_* environment variables or their defaults
+	var opts []otlptracehttp.Option
+	endpoint := "the OTEL_EXPORTER_OTLP_ENDPOINT"
+	if readValues.IsSecExists("app.tracing.endpoint") {
+		endpoint = readValues.ReadValuesString("app.tracing.endpoint")
+		opts = append(opts, otlptracehttp.WithEndpoint(endpoint))
+	}
+	if readValues.IsSecExists("app.tracing.url_path") {
+		opts = append(opts, otlptracehttp.WithURLPath(readValues.ReadValuesString("app.tracing.url_path")))
+	}
+	if readValues.IsSecExists("app.tracing.insecure") && readValues.ReadValuesBool("app.tracing.insecure") {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if readValues.IsSecExists("app.tracing.headers") {
+		opts = append(opts, otlptracehttp.WithHeaders(readValues.ReadValuesMap("app.tracing.headers")))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		logging.Logger.Error("couldn't create the OTLP exporter of the traces, the tracing is disabled: " + err.Error())
+		return
+	}
+	serviceName := "icapeg"
+	if readValues.IsSecExists("app.tracing.service_name") {
+		serviceName = readValues.ReadValuesString("app.tracing.service_name")
+	}
+	ratio := 1.0
+	if readValues.IsSecExists("app.tracing.sample_percent") {
+		ratio = float64(readValues.ReadValuesInt("app.tracing.sample_percent")) / 100
+	}
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", serviceName),
+			attribute.String("service.version", version.Version),
+		)),
+		// the transactions whose proxy sampled the download are sampled too, whatever the percent is
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	tracer = provider.Tracer(tracerName)
+	logging.Logger.Info("the traces are exported to " + endpoint + " with OTLP, " + strconv.Itoa(int(ratio*100)) + "% of the transactions are sampled")
+}
+
+// Enabled reports whether the spans are exported
+func Enabled() bool {
+	return provider != nil
+}
+
+// Shutdown exports the spans which haven't been exported yet, it's called before ICAPeg exits
+func Shutdown(ctx context.Context) {
+	if provider == nil {
+		return
+	}
+	if err := provider.Shutdown(ctx); err != nil {
+		logging.Logger.Warn("couldn't export the last spans: " + err.Error())
+	}
+}
+
+// carrier reads the trace context of an ICAP request, from its X-ICAP-Traceparent (or Traceparent) ICAP header
+// first, then from the encapsulated HTTP request, so a proxy can pass it either way
+type carrier struct {
+	icapHeader textproto.MIMEHeader
+	httpHeader http.Header
+}
+
+func (c carrier) Get(key string) string {
+	if value := c.icapHeader.Get("X-ICAP-" + key); value != "" {
+		return value
+	}
+	if value := c.icapHeader.Get(key); value != "" {
+		return value
+	}
+	return c.httpHeader.Get(key)
+}
+
+func (c carrier) Set(key, value string) {}
+
+func (c carrier) Keys() []string {
+	return nil
+}
+
+// StartTransaction starts the root span of an ICAP transaction, it's the child of the trace context which the
+// ICAP client sent. The span is a no-op span if the tracing isn't enabled
+func StartTransaction(req *icap.Request) (context.Context, trace.Span) {
+	c := carrier{icapHeader: req.Header}
+	if req.Request != nil {
+		c.httpHeader = req.Request.Header
+	}
+	ctx := propagator.Extract(context.Background(), c)
+	attrs := []attribute.KeyValue{attribute.String("icap.method", req.Method)}
+	if req.URL != nil {
+		attrs = append(attrs, attribute.String("icap.url", req.URL.String()))
+	}
+	if clientIP := req.Header.Get("X-Client-IP"); clientIP != "" {
+		attrs = append(attrs, attribute.String("client.address", clientIP))
+	}
+	if req.Request != nil && req.Request.URL != nil {
+		attrs = append(attrs, attribute.String("http.url", req.Request.URL.String()))
+	}
+	return tracer.Start(ctx, "ICAP "+req.Method, trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...))
+}
+
+// Start starts a span which is the child of the span of ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Bind makes ctx the context of the vendor calls of the transaction until Forget is called, the vendors only
+// know the X-ICAP-Metadata of the transaction
+func Bind(xICAPMetadata string, ctx context.Context) {
+	if provider == nil {
+		return
+	}
+	transactions.Store(xICAPMetadata, ctx)
+}
+
+// Forget removes the context of the transaction
+func Forget(xICAPMetadata string) {
+	transactions.Delete(xICAPMetadata)
+}
+
+// Context returns the context which was bound to the transaction, a background context if none was bound
+func Context(xICAPMetadata string) context.Context {
+	if ctx, bound := transactions.Load(xICAPMetadata); bound {
+		return ctx.(context.Context)
+	}
+	return context.Background()
+}
+
+// StartVendorCall starts the span of a call of a vendor backend (an API request, a clamd scan...) as the child of
+// the context of the transaction
+func StartVendorCall(xICAPMetadata, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(Context(xICAPMetadata), name, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+}
+
+// Inject adds the trace context of ctx to the headers of an outgoing request, so the vendors which trace their
+// own APIs continue the trace
+func Inject(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// End ends the span, an error marks the span as failed
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"icapeg/icap"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTransactionJoinsTheTraceOfTheClient(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer = provider.Tracer(tracerName)
+	defer func() { provider = nil }()
+
+	req := &icap.Request{Method: "RESPMOD", URL: &url.URL{Scheme: "icap", Host: "127.0.0.1", Path: "/clamav"},
+		Header: textproto.MIMEHeader{}, Request: &http.Request{Header: http.Header{}}}
+	// the ICAP header is used before the one of the encapsulated HTTP request
+	req.Header.Set("X-ICAP-Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Request.Header.Set("Traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	ctx, root := StartTransaction(req)
+	Bind("txn", ctx)
+	_, call := StartVendorCall("txn", "GET hashlookup")
+	header := http.Header{}
+	Inject(Context("txn"), header)
+	Forget("txn")
+	End(call, nil)
+	root.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	call0, root0 := spans[0], spans[1]
+	if got := root0.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("the transaction should join the trace of X-ICAP-Traceparent, got %s", got)
+	}
+	if call0.Parent.SpanID() != root0.SpanContext.SpanID() {
+		t.Fatal("the vendor call should be the child of the transaction")
+	}
+	if traceparent := header.Get("Traceparent"); traceparent == "" || traceparent[3:35] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("the trace context should be injected, got %q", traceparent)
+	}
+	if trace.SpanFromContext(Context("txn")).SpanContext().IsValid() {
+		t.Fatal("a forgotten transaction shouldn't have a context anymore")
+	}
+}
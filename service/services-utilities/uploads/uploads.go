@@ -0,0 +1,99 @@
+package uploads
+
+import (
+	"bufio"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/retry"
+	"io"
+	"strconv"
+)
+
+// DefaultChunkSize is the size of the chunks of an uploader which doesn't set it
+const DefaultChunkSize = 8 << 20
+
+// Session is a resumable upload of a file to the API of a vendor, the vendor starts it (ex: by creating a
+// multipart upload) before the file is uploaded in chunks
+type Session interface {
+	// UploadChunk sends the chunk of the file which starts at the offset, last is true for the last chunk
+	// so the vendor can complete the upload
+	UploadChunk(chunk []byte, offset int64, last bool) error
+}
+
+// Resumer is implemented by the sessions whose vendor can tell how many bytes of the file it received, a
+// failed chunk is resumed from there instead of being sent again as a whole
+type Resumer interface {
+	Received() (int64, error)
+}
+
+// Uploader uploads the files of a service in chunks, every failed chunk is retried alone with the retry
+// policy of the service, so a transient network error doesn't send the whole file again
+type Uploader struct {
+	serviceName string
+	chunkSize   int
+}
+
+// New creates an uploader of the service, a chunk size of zero means DefaultChunkSize
+func New(serviceName string, chunkSize int) *Uploader {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return &Uploader{serviceName: serviceName, chunkSize: chunkSize}
+}
+
+// Upload reads the file from r and uploads it to the session chunk by chunk, only the current chunk is kept
+// in memory. It returns the number of bytes which were uploaded
+func (u *Uploader) Upload(s Session, r io.Reader) (int64, error) {
+	br := bufio.NewReader(r)
+	buf := make([]byte, u.chunkSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(br, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err == nil {
+			// a full chunk is the last one if nothing follows it
+			if _, err = br.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return offset, err
+			}
+		} else if !last {
+			return offset, err
+		}
+		if err = u.uploadChunk(s, buf[:n], offset, last); err != nil {
+			return offset, err
+		}
+		offset += int64(n)
+		if last {
+			return offset, nil
+		}
+	}
+}
+
+// uploadChunk sends the chunk and retries it while it fails with a transient error, the part of the chunk
+// which the vendor already received isn't sent again if the session is a Resumer
+func (u *Uploader) uploadChunk(s Session, chunk []byte, offset int64, last bool) error {
+	sent := 0
+	return retry.Do(u.serviceName, func() error {
+		err := s.UploadChunk(chunk[sent:], offset+int64(sent), last)
+		if err == nil {
+			return nil
+		}
+		resumer, isResumer := s.(Resumer)
+		if !isResumer {
+			return err
+		}
+		received, rerr := resumer.Received()
+		if rerr != nil || received <= offset+int64(sent) || received > offset+int64(len(chunk)) {
+			return err
+		}
+		sent = int(received - offset)
+		logging.Logger.Debug(u.serviceName + " upload resumes from byte " + strconv.FormatInt(received, 10) +
+			" which the vendor received: " + err.Error())
+		// the vendor received the whole chunk before the error, only the last one has to be sent again
+		// to complete the upload
+		if sent == len(chunk) && !last {
+			return nil
+		}
+		return err
+	})
+}
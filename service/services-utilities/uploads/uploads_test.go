@@ -0,0 +1,61 @@
+package uploads
+
+import (
+	"bytes"
+	"icapeg/logging"
+	"icapeg/service/services-utilities/retry"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// flakySession receives the bytes of the file, it fails after receiving half of the chunks which start at
+// the failing offsets
+type flakySession struct {
+	received bytes.Buffer
+	failAt   map[int64]bool
+	calls    int
+	last     bool
+}
+
+func (s *flakySession) UploadChunk(chunk []byte, offset int64, last bool) error {
+	s.calls++
+	if s.failAt[offset] {
+		delete(s.failAt, offset)
+		s.received.Write(chunk[:len(chunk)/2])
+		return &retry.StatusError{StatusCode: 503}
+	}
+	s.received.Write(chunk)
+	s.last = last
+	return nil
+}
+
+func (s *flakySession) Received() (int64, error) {
+	return int64(s.received.Len()), nil
+}
+
+func TestUploadResumesFailedChunks(t *testing.T) {
+	logging.Logger = zap.NewNop()
+	retry.Register("uploads-test", retry.NewRetrier(retry.Policy{MaxAttempts: 3}))
+	file := strings.Repeat("0123456789", 10)
+	s := &flakySession{failAt: map[int64]bool{30: true, 90: true}}
+	n, err := New("uploads-test", 30).Upload(s, strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(file)) || s.received.String() != file || !s.last {
+		t.Fatalf("the vendor should receive the whole file once, got %d bytes %q", n, s.received.String())
+	}
+	// 4 chunks and a resumed half of the 2 failed ones
+	if s.calls != 6 {
+		t.Fatalf("only the failed chunks should be sent again, got %d calls", s.calls)
+	}
+}
+
+func TestUploadEmptyFile(t *testing.T) {
+	s := &flakySession{}
+	if n, err := New("uploads-test", 0).Upload(s, strings.NewReader("")); err != nil || n != 0 || !s.last {
+		t.Fatalf("an empty file should be uploaded as a last empty chunk, got %d %v", n, err)
+	}
+}
@@ -2,19 +2,29 @@ package clamav
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
 	"fmt"
+	"icapeg/cache"
 	utils "icapeg/consts"
+	"icapeg/icap"
 	"icapeg/logging"
+	services_utilities "icapeg/service/services-utilities"
+	"icapeg/service/services-utilities/ContentTypes"
+	"icapeg/service/services-utilities/digests"
+	"icapeg/service/services-utilities/istag"
+	"icapeg/service/services-utilities/retry"
+	"icapeg/service/services-utilities/spool"
+	"icapeg/service/services-utilities/throttle"
+	"icapeg/service/services-utilities/tracing"
 	"io"
+	"net"
 	"net/http"
 	"net/textproto"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/dutchcoders/go-clamd"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func (c *Clamav) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int, interface{}, map[string]string, map[string]interface{},
@@ -27,10 +37,22 @@ func (c *Clamav) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int,
 	vendorMsgs := make(map[string]interface{})
 	c.IcapHeaders = IcapHeader
 	c.IcapHeaders.Add("X-ICAP-Metadata", c.xICAPMetadata)
-	// no need to scan part of the file, this service needs all the file at ine time
+	// no need to scan part of the file, this service needs all the file at ine time, the files which are bypassed
+	// or rejected upon their types are answered from the preview without the rest of the body
 	if partial {
+		ExceptionPagePath := utils.BlockPagePath
+		if c.ExceptionPage != "" {
+			ExceptionPagePath = c.ExceptionPage
+		}
+		icapStatus, httpMsg := c.generalFunc.PreviewDecision(c.extArrs, c.processExts, c.rejectExts, c.bypassExts,
+			c.return400IfFileExtRejected, c.serviceName, c.methodName, ExceptionPagePath)
 		logging.Logger.Info(utils.PrepareLogMsg(c.xICAPMetadata,
 			c.serviceName+" service has stopped processing partially"))
+		if icapStatus != utils.Continue {
+			msgHeadersAfterProcessing = c.generalFunc.LogHTTPMsgHeaders(c.methodName)
+			return icapStatus, httpMsg, serviceHeaders,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
 		return utils.Continue, nil, nil,
 			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
 	}
@@ -49,14 +71,24 @@ func (c *Clamav) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int,
 	if c.ExceptionPage != "" {
 		ExceptionPagePath = c.ExceptionPage
 	}
-	//extracting the file from http message
-	file, reqContentType, err := c.generalFunc.CopyingFileToTheBuffer(c.methodName)
-
-	if err != nil {
-		logging.Logger.Error(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" error: "+err.Error()))
-		logging.Logger.Info(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" service has stopped processing"))
-		return utils.InternalServerErrStatusCodeStr, nil, serviceHeaders,
-			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	//extracting the file from http message, a spooled HTTP response body is streamed to clamd from the spool
+	//instead of being copied in memory
+	body, streaming := c.generalFunc.SpooledBody(c.methodName)
+	file, reqContentType := &bytes.Buffer{}, ContentTypes.ContentType(nil)
+	var err error
+	if !streaming {
+		file, reqContentType, err = c.generalFunc.CopyingFileToTheBuffer(c.methodName)
+		if err != nil {
+			logging.Logger.Error(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" error: "+err.Error()))
+			logging.Logger.Info(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" service has stopped processing"))
+			return utils.InternalServerErrStatusCodeStr, nil, serviceHeaders,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
+	}
+	// the file which is sniffed, hashed and scanned from a new reader every time
+	head, size, fileReader := file.Bytes(), int64(file.Len()), func() io.Reader { return bytes.NewReader(file.Bytes()) }
+	if streaming {
+		head, size, fileReader = body.Head(spool.SniffLen), body.Size(), func() io.Reader { return body.Open() }
 	}
 
 	//if the http method is Connect, return the request as it is because it has no body
@@ -84,19 +116,24 @@ func (c *Clamav) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int,
 
 	logging.Logger.Info(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" file name : "+fileName))
 
-	fileExtension := c.generalFunc.GetMimeExtension(file.Bytes(), contentType[0], fileName)
+	fileExtension := c.generalFunc.GetMimeExtension(head, contentType[0], fileName)
 
 	//check if the file extension is a bypass extension
 	//if yes we will not modify the file, and we will return 204 No modifications
-	hash := sha256.New()
-	f := file
-	_, err = hash.Write(f.Bytes())
+	fileDigests, err := digests.Compute(fileReader())
 	if err != nil {
 		fmt.Println(err.Error())
 	}
-	fileSize := fmt.Sprintf("%v", file.Len())
-	fileHash := hex.EncodeToString(hash.Sum([]byte(nil)))
+	fileSize := fmt.Sprintf("%v", size)
+	fileHash := fileDigests[digests.SHA256]
 	logging.Logger.Info(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" file hash : "+fileHash))
+	if streaming && c.generalFunc.ExtensionAction(fileExtension, c.extArrs, c.processExts, c.rejectExts,
+		c.bypassExts, c.serviceName) == utils.BypassExts {
+		logging.Logger.Info(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" service has stopped processing"))
+		msgHeadersAfterProcessing = c.generalFunc.LogHTTPMsgHeaders(c.methodName)
+		return utils.NoModificationStatusCodeStr, c.generalFunc.ReturningHttpMessageWithSpool(body), serviceHeaders,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
 	isProcess, icapStatus, httpMsg := c.generalFunc.CheckTheExtension(fileExtension, c.extArrs,
 		c.processExts, c.rejectExts, c.bypassExts, c.return400IfFileExtRejected, isGzip,
 		c.serviceName, c.methodName, fileHash, c.httpMsg.Request.RequestURI, reqContentType, file, ExceptionPagePath, fileSize)
@@ -109,8 +146,15 @@ func (c *Clamav) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int,
 
 	//check if the file size is greater than max file size of the service
 	//if yes we will return 200 ok or 204 no modification, it depends on the configuration of the service
-	if c.maxFileSize != 0 && c.maxFileSize < file.Len() {
-		status, file, httpMsg := c.generalFunc.IfMaxFileSizeExc(c.returnOrigIfMaxSizeExc, c.serviceName, c.methodName, file, c.maxFileSize, ExceptionPagePath, fileSize)
+	//the files which are larger than the max stream size can't be streamed to clamd, so they're handled like them
+	if maxSize := c.maxScanSize(); maxSize != 0 && int64(maxSize) < size {
+		if streaming && c.returnOrigIfMaxSizeExc {
+			logging.Logger.Info(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" service has stopped processing"))
+			msgHeadersAfterProcessing = c.generalFunc.LogHTTPMsgHeaders(c.methodName)
+			return utils.NoModificationStatusCodeStr, c.generalFunc.ReturningHttpMessageWithSpool(body), nil,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
+		status, file, httpMsg := c.generalFunc.IfMaxFileSizeExc(c.returnOrigIfMaxSizeExc, c.serviceName, c.methodName, file, maxSize, ExceptionPagePath, fileSize)
 		fileAfterPrep, httpMsg := c.generalFunc.IfStatusIs204WithFile(c.methodName, status, file, isGzip, reqContentType, httpMsg, true)
 		if fileAfterPrep == nil && httpMsg == nil {
 			logging.Logger.Info(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" service has stopped processing"))
@@ -119,13 +163,13 @@ func (c *Clamav) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int,
 		}
 		switch msg := httpMsg.(type) {
 		case *http.Request:
-			msg.Body = io.NopCloser(bytes.NewBuffer(fileAfterPrep))
+			msg.Body = icap.NewBody(fileAfterPrep)
 			logging.Logger.Info(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" service has stopped processing"))
 			msgHeadersAfterProcessing = c.generalFunc.LogHTTPMsgHeaders(c.methodName)
 			return status, msg, nil,
 				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
 		case *http.Response:
-			msg.Body = io.NopCloser(bytes.NewBuffer(fileAfterPrep))
+			msg.Body = icap.NewBody(fileAfterPrep)
 			msgHeadersAfterProcessing = c.generalFunc.LogHTTPMsgHeaders(c.methodName)
 			logging.Logger.Info(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" service has stopped processing"))
 			return status, msg, nil,
@@ -135,51 +179,70 @@ func (c *Clamav) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int,
 		return status, nil, nil,
 			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
 	}
-	clmd := clamd.NewClamd(c.SocketPath)
-	logging.Logger.Debug(utils.PrepareLogMsg(c.xICAPMetadata,
-		"sending the HTTP msg body to the ClamAV through antivirus socket"))
-	response, err := clmd.ScanStream(bytes.NewReader(file.Bytes()), make(chan bool))
-	if err != nil {
-		logging.Logger.Error(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" error: "+err.Error()))
-		logging.Logger.Info(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" service has stopped processing"))
-		return utils.InternalServerErrStatusCodeStr, nil, serviceHeaders, msgHeadersBeforeProcessing,
-			msgHeadersAfterProcessing, vendorMsgs
-	}
-
-	result := &clamd.ScanResult{}
-	scanFinished := false
-
-	go func() {
-		for s := range response {
-			result = s
+	signatureVersion := c.SignatureVersion()
+	result := &scanResult{}
+	if list, entry, found := cache.LookupHashList(fileHash); found {
+		logging.Logger.Debug(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" file hash is in the "+list+" list"))
+		vendorMsgs["hash_list"] = list
+		if list == cache.DenyListName {
+			result.Status = ClamavMalStatus
+			result.Description = strings.TrimSuffix("Denylisted: "+entry.Comment, ": ")
 		}
-		scanFinished = true
-	}()
-
-	time.Sleep(5 * time.Second)
-
-	if !scanFinished {
-		logging.Logger.Error(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" error: "+err.Error()))
-		if strings.Contains(err.Error(), "context deadline exceeded") {
+	} else if verdict, found := cache.GetVerdict(c.serviceName, signatureVersion, fileHash); found {
+		logging.Logger.Debug(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" verdict was found in the verdict cache"))
+		vendorMsgs["verdict_cache"] = "hit"
+		result.Description = verdict.Threat
+		if verdict.Malicious {
+			result.Status = ClamavMalStatus
+		}
+	} else {
+		logging.Logger.Debug(utils.PrepareLogMsg(c.xICAPMetadata,
+			"sending the HTTP msg body to the ClamAV through antivirus socket"))
+		err := retry.Do(c.serviceName, func() error {
+			_, span := tracing.StartVendorCall(c.xICAPMetadata, "clamd INSTREAM",
+				attribute.String("clamav.socket", c.SocketPath))
+			var err error
+			result, err = scanStream(c.SocketPath, throttle.Reader(ClamavVendor, fileReader()), c.Timeout)
+			tracing.End(span, err)
+			return err
+		})
+		if err != nil {
+			logging.Logger.Error(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" error: "+err.Error()))
+			vendorMsgs[utils.VendorMsgError] = err.Error()
 			logging.Logger.Info(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" service has stopped processing"))
-			return utils.RequestTimeOutStatusCodeStr, nil, nil,
-				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return utils.RequestTimeOutStatusCodeStr, nil, serviceHeaders, msgHeadersBeforeProcessing,
+					msgHeadersAfterProcessing, vendorMsgs
+			}
+			return utils.InternalServerErrStatusCodeStr, nil, serviceHeaders, msgHeadersBeforeProcessing,
+				msgHeadersAfterProcessing, vendorMsgs
+		}
+		if result.Status == ClamavMalStatus || result.Status == clamdOKStatus {
+			cache.SetVerdict(c.serviceName, signatureVersion, fileHash, result.Status == ClamavMalStatus, result.Description)
 		}
-		logging.Logger.Info(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" service has stopped processing"))
-		return utils.BadRequestStatusCodeStr, nil, nil,
-			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
 	}
 	if result.Status == ClamavMalStatus {
 		logging.Logger.Debug(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+"File is not safe"))
+		vendorMsgs[utils.VendorMsgVerdict] = utils.SampleSeverityMalicious
+		vendorMsgs[utils.VendorMsgThreat] = result.Description
+		c.generalFunc.SetThreatName(result.Description)
+		vendorMsgs[utils.VendorMsgFileName] = fileName
+		vendorMsgs[utils.VendorMsgFileHash] = fileHash
+		vendorMsgs[utils.VendorMsgFileDigests] = fileDigests
+		vendorMsgs[utils.VendorMsgFileSize] = fileSize
+		services_utilities.AddThreatHeaders(serviceHeaders, services_utilities.Violation{FileName: fileName,
+			Threat: result.Description, Type: services_utilities.ThreatTypeInfection,
+			Resolution: services_utilities.ResolutionBlocked})
 		if c.methodName == utils.ICAPModeResp {
 			errPage := c.generalFunc.GenHtmlPage(ExceptionPagePath, utils.ErrPageReasonFileIsNotSafe, c.serviceName, c.FileHash, c.httpMsg.Request.RequestURI, fileSize, c.xICAPMetadata)
 
 			c.httpMsg.Response = c.generalFunc.ErrPageResp(c.CaseBlockHttpResponseCode, errPage.Len())
 			if c.CaseBlockHttpBody {
-				c.httpMsg.Response.Body = io.NopCloser(bytes.NewBuffer(errPage.Bytes()))
+				c.httpMsg.Response.Body = icap.NewBody(errPage.Bytes())
 			} else {
 				var r []byte
-				c.httpMsg.Response.Body = io.NopCloser(bytes.NewBuffer(r))
+				c.httpMsg.Response.Body = icap.NewBody(r)
 				delete(c.httpMsg.Response.Header, "Content-Type")
 				delete(c.httpMsg.Response.Header, "Content-Length")
 			}
@@ -203,6 +266,12 @@ func (c *Clamav) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int,
 		}
 	}
 	//returning the scanned file if everything is ok
+	if streaming {
+		logging.Logger.Info(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" service has stopped processing"))
+		msgHeadersAfterProcessing = c.generalFunc.LogHTTPMsgHeaders(c.methodName)
+		return utils.NoModificationStatusCodeStr, c.generalFunc.ReturningHttpMessageWithSpool(body), serviceHeaders,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
 	fileAfterPrep, httpMsg := c.generalFunc.IfICAPStatusIs204(c.methodName, utils.NoModificationStatusCodeStr,
 		file, false, reqContentType, c.httpMsg)
 	if fileAfterPrep == nil && httpMsg == nil {
@@ -214,13 +283,13 @@ func (c *Clamav) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int,
 	//returning the http message and the ICAP status code
 	switch msg := httpMsg.(type) {
 	case *http.Request:
-		msg.Body = io.NopCloser(bytes.NewBuffer(fileAfterPrep))
+		msg.Body = icap.NewBody(fileAfterPrep)
 		logging.Logger.Info(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" service has stopped processing"))
 		msgHeadersAfterProcessing = c.generalFunc.LogHTTPMsgHeaders(c.methodName)
 		return utils.NoModificationStatusCodeStr, msg, serviceHeaders, msgHeadersBeforeProcessing,
 			msgHeadersAfterProcessing, vendorMsgs
 	case *http.Response:
-		msg.Body = io.NopCloser(bytes.NewBuffer(fileAfterPrep))
+		msg.Body = icap.NewBody(fileAfterPrep)
 		logging.Logger.Info(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" service has stopped processing"))
 		msgHeadersAfterProcessing = c.generalFunc.LogHTTPMsgHeaders(c.methodName)
 		return utils.NoModificationStatusCodeStr, msg, serviceHeaders, msgHeadersBeforeProcessing,
@@ -233,7 +302,51 @@ func (c *Clamav) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int,
 		msgHeadersAfterProcessing, vendorMsgs
 }
 
+// maxScanSize returns the smallest of the max file size and the max stream size, 0 = unlimited
+func (c *Clamav) maxScanSize() int {
+	if c.maxStreamSize != 0 && (c.maxFileSize == 0 || c.maxStreamSize < c.maxFileSize) {
+		return c.maxStreamSize
+	}
+	return c.maxFileSize
+}
+
+// SignatureVersion returns the ClamAV engine and signature database version, the version
+// is queried from clamd once every signatureVersionTTL
+func (c *Clamav) SignatureVersion() string {
+	signatureMu.Lock()
+	defer signatureMu.Unlock()
+	if time.Since(signatureCheckedAt) < signatureVersionTTL {
+		return signatureVer
+	}
+	signatureCheckedAt = time.Now()
+	version, err := clamdVersion(c.SocketPath)
+	if err != nil {
+		logging.Logger.Error(utils.PrepareLogMsg(c.xICAPMetadata, c.serviceName+" couldn't get clamd version: "+err.Error()))
+		return signatureVer
+	}
+	signatureVer = version
+	return signatureVer
+}
+
+// clamdVersion queries the engine and signature database version from clamd
+func clamdVersion(socketPath string) (string, error) {
+	response, err := clamdCommand(socketPath, "VERSION", clamdCommandTimeout)
+	if err != nil {
+		return "", err
+	}
+	// the version looks like "ClamAV 0.103.2/26123/Mon Apr 11 07:53:21 2022"
+	fields := strings.Split(response, "/")
+	if len(fields) > 1 {
+		return fields[0] + "/" + fields[1], nil
+	}
+	return response, nil
+}
+
+// ISTagValue returns the ISTag which changes with the signature database version if the ISTag rotation is enabled
 func (c *Clamav) ISTagValue() string {
+	if tag, exists := istag.Value(ClamavVendor); exists {
+		return tag
+	}
 	epochTime := strconv.FormatInt(time.Now().Unix(), 10)
 	return "epoch-" + epochTime
 }
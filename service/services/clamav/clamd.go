@@ -0,0 +1,167 @@
+package clamav
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"icapeg/config"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// the size of the INSTREAM chunks, clamd reads them into a buffer of StreamMaxLength at most
+const clamdChunkSize = 32 * 1024
+
+// the timeout of the clamd commands which don't scan, ex: VERSION
+const clamdCommandTimeout = 10 * time.Second
+
+// the status of a clean stream in the INSTREAM responses
+const clamdOKStatus = "OK"
+
+// errStreamSizeLimit is returned when the stream is larger than the StreamMaxLength of clamd
+var errStreamSizeLimit = errors.New("the file is larger than the StreamMaxLength of clamd")
+
+// scanResult represents the verdict of clamd for a stream
+type scanResult struct {
+	Status      string // FOUND or OK
+	Description string // the signature which was found
+}
+
+// clamdAddress returns the network and the address of the socket_path of the service, a unix socket is a path
+// or a unix:// URL, a TCP socket is a tcp:// URL or a host:port
+func clamdAddress(socketPath string) (string, string) {
+	switch {
+	case strings.HasPrefix(socketPath, "tcp://"):
+		return "tcp", strings.TrimPrefix(socketPath, "tcp://")
+	case strings.HasPrefix(socketPath, "unix://"):
+		return "unix", strings.TrimPrefix(socketPath, "unix://")
+	case !strings.HasPrefix(socketPath, "/") && !strings.HasPrefix(socketPath, "."):
+		if _, _, err := net.SplitHostPort(socketPath); err == nil {
+			return "tcp", socketPath
+		}
+	}
+	return "unix", socketPath
+}
+
+// dialClamd connects to clamd, the whole command must be done before the timeout, 0 = no timeout
+func dialClamd(socketPath string, timeout time.Duration) (net.Conn, error) {
+	network, address := clamdAddress(socketPath)
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	return conn, nil
+}
+
+// clamdCommand sends a command which doesn't scan to clamd and returns its response, the commands are sent
+// with the z prefix so the response is terminated by a null byte
+func clamdCommand(socketPath, command string, timeout time.Duration) (string, error) {
+	conn, err := dialClamd(socketPath, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if _, err = io.WriteString(conn, "z"+command+"\x00"); err != nil {
+		return "", err
+	}
+	return readClamdResponse(bufio.NewReader(conn))
+}
+
+// probeClamd is the health probe of the clamd of a service, clamd answers PING with PONG
+func probeClamd(serviceName string, timeout time.Duration) error {
+	keys := config.Service(serviceName)
+	if keys == nil {
+		return errors.New("the service isn't configured")
+	}
+	response, err := clamdCommand(keys.String("socket_path"), "PING", timeout)
+	if err != nil {
+		return err
+	}
+	if response != "PONG" {
+		return errors.New("clamd answered PING with " + response)
+	}
+	return nil
+}
+
+// scanStream sends the file to clamd with the INSTREAM command in chunks, the file is never written to the disk
+// of clamd
+func scanStream(socketPath string, file io.Reader, timeout time.Duration) (*scanResult, error) {
+	conn, err := dialClamd(socketPath, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	writeErr := writeStream(conn, file)
+	// clamd answers and closes the connection when the stream exceeds its StreamMaxLength, so its response is
+	// read even if the stream couldn't be written completely
+	response, err := readClamdResponse(bufio.NewReader(conn))
+	if err != nil {
+		if writeErr != nil {
+			return nil, writeErr
+		}
+		return nil, err
+	}
+	return parseScanResponse(response)
+}
+
+// writeStream writes the INSTREAM command, the chunks of the file prefixed by their size in network byte order,
+// and the zero sized chunk which ends the stream
+func writeStream(w io.Writer, file io.Reader) error {
+	bw := bufio.NewWriterSize(w, clamdChunkSize+4)
+	if _, err := bw.WriteString("zINSTREAM\x00"); err != nil {
+		return err
+	}
+	chunk := make([]byte, clamdChunkSize)
+	var size [4]byte
+	for {
+		n, err := io.ReadFull(file, chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			bw.Write(size[:])
+			if _, werr := bw.Write(chunk[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	bw.Write(size[:])
+	return bw.Flush()
+}
+
+func readClamdResponse(r *bufio.Reader) (string, error) {
+	response, err := r.ReadString(0)
+	if err != nil && (err != io.EOF || response == "") {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimSuffix(response, "\x00")), nil
+}
+
+// parseScanResponse parses the INSTREAM responses, ex: "stream: OK", "stream: Eicar-Signature FOUND" and
+// "INSTREAM size limit exceeded. ERROR"
+func parseScanResponse(response string) (*scanResult, error) {
+	if strings.HasSuffix(response, " ERROR") {
+		if strings.Contains(response, "size limit exceeded") {
+			return nil, errStreamSizeLimit
+		}
+		return nil, errors.New("clamd error: " + strings.TrimSuffix(response, " ERROR"))
+	}
+	verdict := strings.TrimPrefix(response, "stream: ")
+	switch {
+	case verdict == clamdOKStatus:
+		return &scanResult{Status: clamdOKStatus}, nil
+	case strings.HasSuffix(verdict, " "+ClamavMalStatus):
+		return &scanResult{Status: ClamavMalStatus, Description: strings.TrimSuffix(verdict, " "+ClamavMalStatus)}, nil
+	}
+	return nil, errors.New("unexpected clamd response: " + response)
+}
@@ -0,0 +1,149 @@
+package clamav
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd answers the INSTREAM commands like clamd, a stream which contains EICAR is infected and a stream
+// larger than maxLength exceeds the size limit
+func fakeClamd(t *testing.T, network, address string, maxLength int) string {
+	t.Helper()
+	l, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveClamd(conn, maxLength)
+		}
+	}()
+	if network == "tcp" {
+		return "tcp://" + l.Addr().String()
+	}
+	return address
+}
+
+func serveClamd(conn net.Conn, maxLength int) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	command, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	switch command {
+	case "zVERSION\x00":
+		io.WriteString(conn, "ClamAV 0.103.8/26800/Tue Feb 14 09:20:26 2023\x00")
+		return
+	case "zINSTREAM\x00":
+	default:
+		io.WriteString(conn, "UNKNOWN COMMAND\x00")
+		return
+	}
+	var stream bytes.Buffer
+	var size [4]byte
+	for {
+		if _, err = io.ReadFull(r, size[:]); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(size[:])
+		if n == 0 {
+			break
+		}
+		if stream.Len()+int(n) > maxLength {
+			io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+			return
+		}
+		if _, err = io.CopyN(&stream, r, int64(n)); err != nil {
+			return
+		}
+	}
+	if strings.Contains(stream.String(), "EICAR") {
+		io.WriteString(conn, "stream: Eicar-Signature FOUND\x00")
+		return
+	}
+	io.WriteString(conn, "stream: OK\x00")
+}
+
+func TestScanStream(t *testing.T) {
+	sockets := map[string]string{
+		"unix": fakeClamd(t, "unix", filepath.Join(t.TempDir(), "clamd.ctl"), 1<<20),
+		"tcp":  fakeClamd(t, "tcp", "127.0.0.1:0", 1<<20),
+	}
+	for network, socketPath := range sockets {
+		clean := bytes.Repeat([]byte("clean "), 20000) // several chunks
+		result, err := scanStream(socketPath, bytes.NewReader(clean), time.Second)
+		if err != nil || result.Status != clamdOKStatus {
+			t.Fatalf("%s: clean file = %+v, %v", network, result, err)
+		}
+		result, err = scanStream(socketPath, strings.NewReader("X5O!P%@AP EICAR-STANDARD-ANTIVIRUS-TEST-FILE"),
+			time.Second)
+		if err != nil || result.Status != ClamavMalStatus || result.Description != "Eicar-Signature" {
+			t.Fatalf("%s: infected file = %+v, %v", network, result, err)
+		}
+	}
+}
+
+func TestScanStreamSizeLimit(t *testing.T) {
+	socketPath := fakeClamd(t, "unix", filepath.Join(t.TempDir(), "clamd.ctl"), 64*1024)
+	_, err := scanStream(socketPath, bytes.NewReader(make([]byte, 1<<20)), time.Second)
+	if err != errStreamSizeLimit {
+		t.Fatalf("scanStream() error = %v, want the size limit error", err)
+	}
+}
+
+func TestScanStreamTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// a clamd which never answers
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+	_, err = scanStream(l.Addr().String(), strings.NewReader("file"), 100*time.Millisecond)
+	if netErr, isNetErr := err.(net.Error); !isNetErr || !netErr.Timeout() {
+		t.Fatalf("scanStream() error = %v, want a timeout", err)
+	}
+}
+
+func TestClamdVersion(t *testing.T) {
+	socketPath := fakeClamd(t, "unix", filepath.Join(t.TempDir(), "clamd.ctl"), 1<<20)
+	version, err := clamdVersion(socketPath)
+	if err != nil || version != "ClamAV 0.103.8/26800" {
+		t.Fatalf("clamdVersion() = %q, %v", version, err)
+	}
+}
+
+func TestClamdAddress(t *testing.T) {
+	for socketPath, want := range map[string]string{
+		"/var/run/clamav/clamd.ctl": "unix /var/run/clamav/clamd.ctl",
+		"unix:///tmp/clamd.sock":    "unix /tmp/clamd.sock",
+		"tcp://clamd.internal:3310": "tcp clamd.internal:3310",
+		"127.0.0.1:3310":            "tcp 127.0.0.1:3310",
+		"./clamd.sock":              "unix ./clamd.sock",
+		"clamd.sock":                "unix clamd.sock",
+	} {
+		network, address := clamdAddress(socketPath)
+		if got := network + " " + address; got != want {
+			t.Errorf("clamdAddress(%q) = %q, want %q", socketPath, got, want)
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package clamav
 
 import (
+	"icapeg/config"
 	http_message "icapeg/http-message"
 	"icapeg/logging"
-	"icapeg/readValues"
 	services_utilities "icapeg/service/services-utilities"
 	general_functions "icapeg/service/services-utilities/general-functions"
+	"icapeg/service/services-utilities/health"
+	"icapeg/service/services-utilities/istag"
+	"icapeg/service/services-utilities/retry"
 	"net/textproto"
 	"sync"
 	"time"
@@ -15,10 +18,42 @@ import (
 const (
 	ClamavMalStatus  = "FOUND"
 	ClamavIdentifier = "CLAMAV ID"
+	ClamavVendor     = "clamav"
 )
 
+// the keys of the services of the vendor in addition to the ones of every service
+func init() {
+	config.RegisterVendorKeys(ClamavVendor, map[string]config.KeySpec{
+		"socket_path":     {Kind: config.StringKey, Mandatory: true},
+		"timeout":         {Kind: config.DurationKey, Mandatory: true},
+		"max_stream_size": {Kind: config.IntKey},
+		"return_original_if_max_file_size_exceeded": {Kind: config.BoolKey, Mandatory: true},
+		"return_400_if_file_ext_rejected":           {Kind: config.BoolKey, Mandatory: true},
+		"bypass_on_api_error":                       {Kind: config.BoolKey},
+		"verify_server_cert":                        {Kind: config.BoolKey, Mandatory: true},
+		"http_exception_response_code":              {Kind: config.IntKey, Mandatory: true},
+		"http_exception_has_body":                   {Kind: config.BoolKey, Mandatory: true},
+		"exception_page":                            {Kind: config.StringKey, Mandatory: true},
+	})
+	health.Register(ClamavVendor, probeClamd)
+}
+
 var doOnce sync.Once
-var clamavConfig *Clamav
+var (
+	configMu sync.RWMutex
+	// the service whose section the configuration was read from, the services of the vendor share it
+	configService string
+	clamavConfig  *Clamav
+)
+
+// the clamd signature version is shared between all requests and refreshed once every signatureVersionTTL
+const signatureVersionTTL = 5 * time.Minute
+
+var (
+	signatureMu        sync.Mutex
+	signatureVer       string
+	signatureCheckedAt time.Time
+)
 
 // Clamav represents the information regarding the clamav service
 type Clamav struct {
@@ -28,12 +63,14 @@ type Clamav struct {
 	serviceName string
 	methodName  string
 	maxFileSize int
-	bypassExts  []string
-	processExts []string
-	rejectExts  []string
-	extArrs     []services_utilities.Extension
-	SocketPath  string
-	Timeout     time.Duration
+	// the max size of the INSTREAM streams, like the StreamMaxLength of clamd, 0 = unlimited
+	maxStreamSize int
+	bypassExts    []string
+	processExts   []string
+	rejectExts    []string
+	extArrs       []services_utilities.Extension
+	SocketPath    string
+	Timeout       time.Duration
 	//badFileStatus              []string
 	//okFileStatus               []string
 	returnOrigIfMaxSizeExc     bool
@@ -51,46 +88,104 @@ type Clamav struct {
 func InitClamavConfig(serviceName string) {
 	logging.Logger.Debug("loading " + serviceName + " service configurations")
 	doOnce.Do(func() {
-		clamavConfig = &Clamav{
-			maxFileSize:                readValues.ReadValuesInt(serviceName + ".max_filesize"),
-			bypassExts:                 readValues.ReadValuesSlice(serviceName + ".bypass_extensions"),
-			processExts:                readValues.ReadValuesSlice(serviceName + ".process_extensions"),
-			rejectExts:                 readValues.ReadValuesSlice(serviceName + ".reject_extensions"),
-			returnOrigIfMaxSizeExc:     readValues.ReadValuesBool(serviceName + ".return_original_if_max_file_size_exceeded"),
-			SocketPath:                 readValues.ReadValuesString(serviceName + ".socket_path"),
-			Timeout:                    readValues.ReadValuesDuration(serviceName+".timeout") * time.Second,
-			return400IfFileExtRejected: readValues.ReadValuesBool(serviceName + ".return_400_if_file_ext_rejected"),
-			BypassOnApiError:           readValues.ReadBoolFromEnv(serviceName + ".bypass_on_api_error"),
-			verifyServerCert:           readValues.ReadValuesBool(serviceName + ".verify_server_cert"),
-			CaseBlockHttpResponseCode:  readValues.ReadValuesInt(serviceName + ".http_exception_response_code"),
-			CaseBlockHttpBody:          readValues.ReadValuesBool(serviceName + ".http_exception_has_body"),
-			ExceptionPage:              readValues.ReadValuesString(serviceName + ".exception_page"),
-		}
-
-		clamavConfig.extArrs = services_utilities.InitExtsArr(clamavConfig.processExts, clamavConfig.rejectExts, clamavConfig.bypassExts)
+		configMu.Lock()
+		clamavConfig = readClamavConfig(serviceName)
+		configService = serviceName
+		configMu.Unlock()
+		general_functions.InitBlockPageLanguage(serviceName)
+		retry.InitRetryConfig(serviceName)
+		istag.Register(ClamavVendor, func() (string, error) {
+			return clamdVersion(currentConfig().SocketPath)
+		})
 	})
 }
 
+// readClamavConfig reads the configuration of the service
+func readClamavConfig(serviceName string) *Clamav {
+	keys := config.Service(serviceName)
+	cfg := &Clamav{
+		maxFileSize:                keys.Int("max_filesize"),
+		bypassExts:                 keys.Slice("bypass_extensions"),
+		processExts:                keys.Slice("process_extensions"),
+		rejectExts:                 keys.Slice("reject_extensions"),
+		returnOrigIfMaxSizeExc:     keys.Bool("return_original_if_max_file_size_exceeded"),
+		SocketPath:                 keys.String("socket_path"),
+		Timeout:                    keys.Duration("timeout") * time.Second,
+		return400IfFileExtRejected: keys.Bool("return_400_if_file_ext_rejected"),
+		BypassOnApiError:           keys.Bool("bypass_on_api_error"),
+		verifyServerCert:           keys.Bool("verify_server_cert"),
+		CaseBlockHttpResponseCode:  keys.Int("http_exception_response_code"),
+		CaseBlockHttpBody:          keys.Bool("http_exception_has_body"),
+		ExceptionPage:              keys.String("exception_page"),
+	}
+	if keys.Has("max_stream_size") {
+		cfg.maxStreamSize = keys.Int("max_stream_size")
+	}
+	cfg.extArrs = services_utilities.InitExtsArr(cfg.processExts, cfg.rejectExts, cfg.bypassExts)
+	return cfg
+}
+
+// ReloadClamavConfig reads the configuration of the service which loaded it again, it returns the func which
+// makes it the configuration of the next requests, nil if it wasn't loaded yet or its section was removed
+func ReloadClamavConfig() func() {
+	configMu.RLock()
+	serviceName := configService
+	configMu.RUnlock()
+	if serviceName == "" || config.Service(serviceName) == nil {
+		return nil
+	}
+	cfg := readClamavConfig(serviceName)
+	return func() {
+		configMu.Lock()
+		defer configMu.Unlock()
+		clamavConfig = cfg
+	}
+}
+
+func currentConfig() *Clamav {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return clamavConfig
+}
+
 func NewClamavService(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) *Clamav {
-	return &Clamav{
+	cfg := currentConfig()
+	c := &Clamav{
 		xICAPMetadata:              xICAPMetadata,
 		httpMsg:                    httpMsg,
 		serviceName:                serviceName,
 		methodName:                 methodName,
 		generalFunc:                general_functions.NewGeneralFunc(httpMsg, xICAPMetadata),
-		maxFileSize:                clamavConfig.maxFileSize,
-		bypassExts:                 clamavConfig.bypassExts,
-		processExts:                clamavConfig.processExts,
-		rejectExts:                 clamavConfig.rejectExts,
-		extArrs:                    clamavConfig.extArrs,
-		Timeout:                    clamavConfig.Timeout * time.Second,
-		SocketPath:                 clamavConfig.SocketPath,
-		returnOrigIfMaxSizeExc:     clamavConfig.returnOrigIfMaxSizeExc,
-		return400IfFileExtRejected: clamavConfig.return400IfFileExtRejected,
-		verifyServerCert:           clamavConfig.verifyServerCert,
-		BypassOnApiError:           clamavConfig.BypassOnApiError,
-		CaseBlockHttpResponseCode:  clamavConfig.CaseBlockHttpResponseCode,
-		CaseBlockHttpBody:          clamavConfig.CaseBlockHttpBody,
-		ExceptionPage:              clamavConfig.ExceptionPage,
+		maxFileSize:                cfg.maxFileSize,
+		bypassExts:                 cfg.bypassExts,
+		processExts:                cfg.processExts,
+		rejectExts:                 cfg.rejectExts,
+		extArrs:                    cfg.extArrs,
+		maxStreamSize:              cfg.maxStreamSize,
+		Timeout:                    cfg.Timeout,
+		SocketPath:                 cfg.SocketPath,
+		returnOrigIfMaxSizeExc:     cfg.returnOrigIfMaxSizeExc,
+		return400IfFileExtRejected: cfg.return400IfFileExtRejected,
+		verifyServerCert:           cfg.verifyServerCert,
+		BypassOnApiError:           cfg.BypassOnApiError,
+		CaseBlockHttpResponseCode:  cfg.CaseBlockHttpResponseCode,
+		CaseBlockHttpBody:          cfg.CaseBlockHttpBody,
+		ExceptionPage:              cfg.ExceptionPage,
+	}
+	c.applyScanProfile()
+	return c
+}
+
+// applyScanProfile replaces the keys of the service with the ones of the scan profile which the transaction
+// selected, if it selected one
+func (c *Clamav) applyScanProfile() {
+	profile := config.ScanProfile(c.serviceName, c.xICAPMetadata)
+	if profile == nil {
+		return
 	}
+	c.maxFileSize = profile.MaxFileSize
+	c.bypassExts, c.processExts, c.rejectExts = profile.BypassExtensions, profile.ProcessExtensions, profile.RejectExtensions
+	c.extArrs = services_utilities.ProfileExtsArr(profile)
+	c.returnOrigIfMaxSizeExc = profile.ReturnOrigIfMaxSizeExc
+	c.BypassOnApiError = profile.BypassOnApiError
 }
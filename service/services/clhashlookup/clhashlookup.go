@@ -3,18 +3,32 @@ package clhashlookup
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"icapeg/cache"
+	"icapeg/config"
 	utils "icapeg/consts"
+	"icapeg/icap"
 	"icapeg/logging"
+	services_utilities "icapeg/service/services-utilities"
+	"icapeg/service/services-utilities/ContentTypes"
+	"icapeg/service/services-utilities/capture"
+	"icapeg/service/services-utilities/digests"
+	"icapeg/service/services-utilities/istag"
+	"icapeg/service/services-utilities/proxy"
+	"icapeg/service/services-utilities/retry"
+	"icapeg/service/services-utilities/spool"
+	"icapeg/service/services-utilities/tracing"
 	"io"
 	"net/http"
 	"net/textproto"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Processing is a func used for to processing the http message
@@ -28,10 +42,22 @@ func (h *Hashlookup) Processing(partial bool, IcapHeader textproto.MIMEHeader) (
 	vendorMsgs := make(map[string]interface{})
 	h.IcapHeaders = IcapHeader
 	h.IcapHeaders.Add("X-ICAP-Metadata", h.xICAPMetadata)
-	// no need to scan part of the file, this service needs all the file at ine time
+	// no need to scan part of the file, this service needs all the file at ine time, the files which are bypassed
+	// or rejected upon their types are answered from the preview without the rest of the body
 	if partial {
+		ExceptionPagePath := utils.BlockPagePath
+		if h.ExceptionPage != "" {
+			ExceptionPagePath = h.ExceptionPage
+		}
+		icapStatus, httpMsg := h.generalFunc.PreviewDecision(h.extArrs, h.processExts, h.rejectExts, h.bypassExts,
+			h.return400IfFileExtRejected, h.serviceName, h.methodName, ExceptionPagePath)
 		logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata,
 			h.serviceName+" service has stopped processing partially"))
+		if icapStatus != utils.Continue {
+			msgHeadersAfterProcessing = h.generalFunc.LogHTTPMsgHeaders(h.methodName)
+			return icapStatus, httpMsg, serviceHeaders,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
 		return utils.Continue, nil, nil,
 			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
 	}
@@ -50,15 +76,23 @@ func (h *Hashlookup) Processing(partial bool, IcapHeader textproto.MIMEHeader) (
 	if h.ExceptionPage != "" {
 		ExceptionPagePath = h.ExceptionPage
 	}
-	//extracting the file from http message
-
-	file, reqContentType, err := h.generalFunc.CopyingFileToTheBuffer(h.methodName)
-
-	if err != nil {
-		logging.Logger.Error(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" error: "+err.Error()))
-		logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has stopped processing"))
-		return utils.InternalServerErrStatusCodeStr, nil, serviceHeaders,
-			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	//extracting the file from http message, only the hash of a spooled HTTP response body is looked up, so
+	//the body is hashed from the spool instead of being copied in memory
+	body, streaming := h.generalFunc.SpooledBody(h.methodName)
+	file, reqContentType := &bytes.Buffer{}, ContentTypes.ContentType(nil)
+	var err error
+	if !streaming {
+		file, reqContentType, err = h.generalFunc.CopyingFileToTheBuffer(h.methodName)
+		if err != nil {
+			logging.Logger.Error(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" error: "+err.Error()))
+			logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has stopped processing"))
+			return utils.InternalServerErrStatusCodeStr, nil, serviceHeaders,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
+	}
+	head, size, fileReader := file.Bytes(), int64(file.Len()), io.Reader(bytes.NewReader(file.Bytes()))
+	if streaming {
+		head, size, fileReader = body.Head(spool.SniffLen), body.Size(), body.Open()
 	}
 
 	//if the http method is Connect, return the request as it is because it has no body
@@ -86,19 +120,24 @@ func (h *Hashlookup) Processing(partial bool, IcapHeader textproto.MIMEHeader) (
 
 	logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" file name : "+fileName))
 
-	fileExtension := h.generalFunc.GetMimeExtension(file.Bytes(), contentType[0], fileName)
+	fileExtension := h.generalFunc.GetMimeExtension(head, contentType[0], fileName)
 	//check if the file extension is a bypass extension
 	//if yes we will not modify the file, and we will return 204 No modifications
 
-	hash := sha256.New()
-	f := file
-	_, err = hash.Write(f.Bytes())
+	fileDigests, err := digests.Compute(fileReader)
 	if err != nil {
 		fmt.Println(err.Error())
 	}
-	fileSize := fmt.Sprintf("%v", file.Len())
-	fileHash := hex.EncodeToString(hash.Sum([]byte(nil)))
+	fileSize := fmt.Sprintf("%v", size)
+	fileHash := fileDigests[digests.SHA256]
 	logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" file hash : "+fileHash))
+	if streaming && h.generalFunc.ExtensionAction(fileExtension, h.extArrs, h.processExts, h.rejectExts,
+		h.bypassExts, h.serviceName) == utils.BypassExts {
+		logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has stopped processing"))
+		msgHeadersAfterProcessing = h.generalFunc.LogHTTPMsgHeaders(h.methodName)
+		return utils.NoModificationStatusCodeStr, h.generalFunc.ReturningHttpMessageWithSpool(body), serviceHeaders,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
 
 	//check if the file extension is a bypass extension
 	//if yes we will not modify the file, and we will return 204 No modifications
@@ -113,7 +152,13 @@ func (h *Hashlookup) Processing(partial bool, IcapHeader textproto.MIMEHeader) (
 	}
 	//check if the file size is greater than max file size of the service
 	//if yes we will return 200 ok or 204 no modification, it depends on the configuration of the service
-	if h.maxFileSize != 0 && h.maxFileSize < file.Len() {
+	if h.maxFileSize != 0 && int64(h.maxFileSize) < size {
+		if streaming && h.returnOrigIfMaxSizeExc {
+			logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has stopped processing"))
+			msgHeadersAfterProcessing = h.generalFunc.LogHTTPMsgHeaders(h.methodName)
+			return utils.NoModificationStatusCodeStr, h.generalFunc.ReturningHttpMessageWithSpool(body), nil,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
 		status, file, httpMsg := h.generalFunc.IfMaxFileSizeExc(h.returnOrigIfMaxSizeExc, h.serviceName, h.methodName, file, h.maxFileSize, ExceptionPagePath, fileSize)
 		fileAfterPrep, httpMsg := h.generalFunc.IfStatusIs204WithFile(h.methodName, status, file, isGzip, reqContentType, httpMsg, true)
 		if fileAfterPrep == nil && httpMsg == nil {
@@ -123,13 +168,13 @@ func (h *Hashlookup) Processing(partial bool, IcapHeader textproto.MIMEHeader) (
 		}
 		switch msg := httpMsg.(type) {
 		case *http.Request:
-			msg.Body = io.NopCloser(bytes.NewBuffer(fileAfterPrep))
+			msg.Body = icap.NewBody(fileAfterPrep)
 			logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has stopped processing"))
 			msgHeadersAfterProcessing = h.generalFunc.LogHTTPMsgHeaders(h.methodName)
 			return status, msg, nil,
 				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
 		case *http.Response:
-			msg.Body = io.NopCloser(bytes.NewBuffer(fileAfterPrep))
+			msg.Body = icap.NewBody(fileAfterPrep)
 			msgHeadersAfterProcessing = h.generalFunc.LogHTTPMsgHeaders(h.methodName)
 			logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has stopped processing"))
 			return status, msg, nil,
@@ -141,7 +186,26 @@ func (h *Hashlookup) Processing(partial bool, IcapHeader textproto.MIMEHeader) (
 	}
 
 	scannedFile := file.Bytes()
-	isMal, err := h.sendFileToScan(file)
+	var isMal bool
+	if list, _, found := cache.LookupHashList(fileHash); found {
+		logging.Logger.Debug(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" file hash is in the "+list+" list"))
+		vendorMsgs["hash_list"] = list
+		h.FileHash = fileHash
+		isMal = list == cache.DenyListName
+	} else if verdict, found := cache.GetVerdict(h.serviceName, "", fileHash); found {
+		logging.Logger.Debug(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" verdict was found in the verdict cache"))
+		vendorMsgs["verdict_cache"] = "hit"
+		h.FileHash = fileHash
+		isMal = verdict.Malicious
+	} else {
+		isMal, err = h.sendFileToScan(fileHash)
+		if err == nil {
+			cache.SetVerdict(h.serviceName, "", fileHash, isMal, "KnownMalicious")
+		}
+	}
+	if err != nil {
+		vendorMsgs[utils.VendorMsgError] = err.Error()
+	}
 	if err != nil && !h.BypassOnApiError {
 		logging.Logger.Error(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" error: "+err.Error()))
 		if strings.Contains(err.Error(), "context deadline exceeded") {
@@ -157,16 +221,26 @@ func (h *Hashlookup) Processing(partial bool, IcapHeader textproto.MIMEHeader) (
 
 	if isMal {
 		logging.Logger.Debug(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+": file is not safe"))
+		vendorMsgs[utils.VendorMsgVerdict] = utils.SampleSeverityMalicious
+		vendorMsgs[utils.VendorMsgThreat] = "KnownMalicious"
+		h.generalFunc.SetThreatName("KnownMalicious")
+		vendorMsgs[utils.VendorMsgFileName] = fileName
+		vendorMsgs[utils.VendorMsgFileHash] = fileHash
+		vendorMsgs[utils.VendorMsgFileDigests] = fileDigests
+		vendorMsgs[utils.VendorMsgFileSize] = fileSize
+		services_utilities.AddThreatHeaders(serviceHeaders, services_utilities.Violation{FileName: fileName,
+			Threat: "KnownMalicious", Type: services_utilities.ThreatTypeInfection,
+			Resolution: services_utilities.ResolutionBlocked})
 		if h.methodName == utils.ICAPModeResp {
 
 			errPage := h.generalFunc.GenHtmlPage(ExceptionPagePath, utils.ErrPageReasonFileIsNotSafe, h.serviceName, h.FileHash, h.httpMsg.Request.RequestURI, fileSize, h.xICAPMetadata)
 
 			h.httpMsg.Response = h.generalFunc.ErrPageResp(h.CaseBlockHttpResponseCode, errPage.Len())
 			if h.CaseBlockHttpBody {
-				h.httpMsg.Response.Body = io.NopCloser(bytes.NewBuffer(errPage.Bytes()))
+				h.httpMsg.Response.Body = icap.NewBody(errPage.Bytes())
 			} else {
 				var r []byte
-				h.httpMsg.Response.Body = io.NopCloser(bytes.NewBuffer(r))
+				h.httpMsg.Response.Body = icap.NewBody(r)
 				delete(h.httpMsg.Response.Header, "Content-Type")
 				delete(h.httpMsg.Response.Header, "Content-Length")
 			}
@@ -204,6 +278,10 @@ func (h *Hashlookup) Processing(partial bool, IcapHeader textproto.MIMEHeader) (
 	/*return utils.NoModificationStatusCodeStr,
 	h.ReturningHttpMessageWithFile(h.methodName, scannedFile, h.OriginalMsg), serviceHeaders,
 	msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs*/
+	if streaming {
+		return utils.NoModificationStatusCodeStr, h.generalFunc.ReturningHttpMessageWithSpool(body),
+			serviceHeaders, msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
 	scannedFile = h.generalFunc.PreparingFileAfterScanning(scannedFile, reqContentType, h.methodName)
 
 	return utils.NoModificationStatusCodeStr, h.generalFunc.ReturningHttpMessageWithFile(h.methodName, scannedFile),
@@ -211,19 +289,32 @@ func (h *Hashlookup) Processing(partial bool, IcapHeader textproto.MIMEHeader) (
 
 }
 
-// SendFileToScan is a function to send the file to API
-func (h *Hashlookup) sendFileToScan(f *bytes.Buffer) (bool, error) {
-	hash := sha256.New()
-	_, _ = io.Copy(hash, f)
-	fileHash := hex.EncodeToString(hash.Sum([]byte(nil)))
+// SendFileToScan is a function to look up the SHA-256 of the file in the API, the file itself isn't sent
+func (h *Hashlookup) sendFileToScan(fileHash string) (bool, error) {
 	h.FileHash = fileHash
 	//var jsonStr = []byte(`{"hash":"` + fileHash + `"}`)
-	req, err := http.NewRequest("GET", h.ScanUrl+fileHash, nil)
-	client := &http.Client{}
-	ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
-	defer cancel()
-	req = req.WithContext(ctx)
-	resp, err := client.Do(req)
+	client := &http.Client{Transport: capture.Transport(HashlookupVendor, h.xICAPMetadata,
+		proxy.Transport(HashlookupVendor))}
+	var resp *http.Response
+	err := retry.Do(h.serviceName, func() (err error) {
+		req, err := http.NewRequest("GET", h.ScanUrl+fileHash, nil)
+		if err != nil {
+			return err
+		}
+		spanCtx, span := tracing.StartVendorCall(h.xICAPMetadata, "GET hashlookup",
+			attribute.String("http.url", req.URL.String()))
+		defer func() { tracing.End(span, err) }()
+		tracing.Inject(spanCtx, req.Header)
+		ctx, cancel := context.WithTimeout(spanCtx, h.Timeout)
+		resp, err = client.Do(req.WithContext(ctx))
+		if err != nil {
+			cancel()
+			return err
+		}
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+		resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+		return retry.CheckResponse(resp)
+	})
 	if err != nil {
 		return false, err
 	}
@@ -240,7 +331,47 @@ func (h *Hashlookup) sendFileToScan(f *bytes.Buffer) (bool, error) {
 
 }
 
+// cancelBody cancels the context of the request when the response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// ISTagValue returns the ISTag which changes with the definition version of the vendor if the ISTag rotation
+// polls it
 func (e *Hashlookup) ISTagValue() string {
+	if tag, exists := istag.Value(HashlookupVendor); exists {
+		return tag
+	}
 	epochTime := strconv.FormatInt(time.Now().Unix(), 10)
 	return "epoch-" + epochTime
 }
+
+// probeScanURL is the health probe of the API of a service, the API is reachable if its host answers a HEAD
+// request without a server error, the lookups aren't counted by the API
+func probeScanURL(serviceName string, timeout time.Duration) error {
+	keys := config.Service(serviceName)
+	if keys == nil {
+		return errors.New("the service isn't configured")
+	}
+	scanURL, err := url.Parse(keys.String("scan_url"))
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: proxy.Transport(HashlookupVendor), Timeout: timeout}
+	resp, err := client.Head(scanURL.Scheme + "://" + scanURL.Host + "/")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return errors.New("the API answered " + resp.Status)
+	}
+	return nil
+}
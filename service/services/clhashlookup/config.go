@@ -1,18 +1,44 @@
 package clhashlookup
 
 import (
+	"icapeg/config"
 	http_message "icapeg/http-message"
 	"icapeg/logging"
-	"icapeg/readValues"
 	services_utilities "icapeg/service/services-utilities"
 	general_functions "icapeg/service/services-utilities/general-functions"
+	"icapeg/service/services-utilities/health"
+	"icapeg/service/services-utilities/retry"
 	"net/textproto"
 	"sync"
 	"time"
 )
 
+// HashlookupVendor is the vendor of the hash lookup service
+const HashlookupVendor = "clhashlookup"
+
+// the keys of the services of the vendor in addition to the ones of every service
+func init() {
+	config.RegisterVendorKeys(HashlookupVendor, map[string]config.KeySpec{
+		"scan_url": {Kind: config.StringKey, Mandatory: true},
+		"timeout":  {Kind: config.DurationKey, Mandatory: true},
+		"return_original_if_max_file_size_exceeded": {Kind: config.BoolKey, Mandatory: true},
+		"return_400_if_file_ext_rejected":           {Kind: config.BoolKey, Mandatory: true},
+		"bypass_on_api_error":                       {Kind: config.BoolKey},
+		"verify_server_cert":                        {Kind: config.BoolKey, Mandatory: true},
+		"http_exception_response_code":              {Kind: config.IntKey, Mandatory: true},
+		"http_exception_has_body":                   {Kind: config.BoolKey, Mandatory: true},
+		"exception_page":                            {Kind: config.StringKey, Mandatory: true},
+	})
+	health.Register(HashlookupVendor, probeScanURL)
+}
+
 var doOnce sync.Once
-var HashLookupConfig *Hashlookup
+var (
+	configMu sync.RWMutex
+	// the service whose section the configuration was read from, the services of the vendor share it
+	configService    string
+	HashLookupConfig *Hashlookup
+)
 
 // Hashlookup represents the information regarding the Hashlookup service
 type Hashlookup struct {
@@ -42,46 +68,98 @@ type Hashlookup struct {
 func InitHashlookupConfig(serviceName string) {
 	logging.Logger.Debug("loading " + serviceName + " service configurations")
 	doOnce.Do(func() {
-		HashLookupConfig = &Hashlookup{
-			maxFileSize:                readValues.ReadValuesInt(serviceName + ".max_filesize"),
-			bypassExts:                 readValues.ReadValuesSlice(serviceName + ".bypass_extensions"),
-			processExts:                readValues.ReadValuesSlice(serviceName + ".process_extensions"),
-			rejectExts:                 readValues.ReadValuesSlice(serviceName + ".reject_extensions"),
-			ScanUrl:                    readValues.ReadValuesString(serviceName + ".scan_url"),
-			Timeout:                    readValues.ReadValuesDuration(serviceName + ".timeout"),
-			returnOrigIfMaxSizeExc:     readValues.ReadValuesBool(serviceName + ".return_original_if_max_file_size_exceeded"),
-			return400IfFileExtRejected: readValues.ReadValuesBool(serviceName + ".return_400_if_file_ext_rejected"),
-			BypassOnApiError:           readValues.ReadBoolFromEnv(serviceName + ".bypass_on_api_error"),
-			verifyServerCert:           readValues.ReadValuesBool(serviceName + ".verify_server_cert"),
-			CaseBlockHttpResponseCode:  readValues.ReadValuesInt(serviceName + ".http_exception_response_code"),
-			CaseBlockHttpBody:          readValues.ReadValuesBool(serviceName + ".http_exception_has_body"),
-			ExceptionPage:              readValues.ReadValuesString(serviceName + ".exception_page"),
-		}
-		HashLookupConfig.extArrs = services_utilities.InitExtsArr(HashLookupConfig.processExts, HashLookupConfig.rejectExts, HashLookupConfig.bypassExts)
+		configMu.Lock()
+		HashLookupConfig = readHashlookupConfig(serviceName)
+		configService = serviceName
+		configMu.Unlock()
+		general_functions.InitBlockPageLanguage(serviceName)
+		retry.InitRetryConfig(serviceName)
 	})
 }
 
+// readHashlookupConfig reads the configuration of the service
+func readHashlookupConfig(serviceName string) *Hashlookup {
+	keys := config.Service(serviceName)
+	cfg := &Hashlookup{
+		maxFileSize:                keys.Int("max_filesize"),
+		bypassExts:                 keys.Slice("bypass_extensions"),
+		processExts:                keys.Slice("process_extensions"),
+		rejectExts:                 keys.Slice("reject_extensions"),
+		ScanUrl:                    keys.String("scan_url"),
+		Timeout:                    keys.Duration("timeout"),
+		returnOrigIfMaxSizeExc:     keys.Bool("return_original_if_max_file_size_exceeded"),
+		return400IfFileExtRejected: keys.Bool("return_400_if_file_ext_rejected"),
+		BypassOnApiError:           keys.Bool("bypass_on_api_error"),
+		verifyServerCert:           keys.Bool("verify_server_cert"),
+		CaseBlockHttpResponseCode:  keys.Int("http_exception_response_code"),
+		CaseBlockHttpBody:          keys.Bool("http_exception_has_body"),
+		ExceptionPage:              keys.String("exception_page"),
+	}
+	cfg.extArrs = services_utilities.InitExtsArr(cfg.processExts, cfg.rejectExts, cfg.bypassExts)
+	return cfg
+}
+
+// ReloadHashlookupConfig reads the configuration of the service which loaded it again, it returns the func which
+// makes it the configuration of the next requests, nil if it wasn't loaded yet or its section was removed
+func ReloadHashlookupConfig() func() {
+	configMu.RLock()
+	serviceName := configService
+	configMu.RUnlock()
+	if serviceName == "" || config.Service(serviceName) == nil {
+		return nil
+	}
+	cfg := readHashlookupConfig(serviceName)
+	return func() {
+		configMu.Lock()
+		defer configMu.Unlock()
+		HashLookupConfig = cfg
+	}
+}
+
+func currentConfig() *Hashlookup {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return HashLookupConfig
+}
+
 // NewHashlookupService returns a new populated instance of the Hashlookup service
 func NewHashlookupService(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) *Hashlookup {
-	return &Hashlookup{
+	cfg := currentConfig()
+	h := &Hashlookup{
 		xICAPMetadata:              xICAPMetadata,
 		httpMsg:                    httpMsg,
 		serviceName:                serviceName,
 		methodName:                 methodName,
-		maxFileSize:                HashLookupConfig.maxFileSize,
-		bypassExts:                 HashLookupConfig.bypassExts,
-		processExts:                HashLookupConfig.processExts,
-		rejectExts:                 HashLookupConfig.rejectExts,
-		extArrs:                    HashLookupConfig.extArrs,
-		ScanUrl:                    HashLookupConfig.ScanUrl,
-		Timeout:                    HashLookupConfig.Timeout * time.Second,
-		returnOrigIfMaxSizeExc:     HashLookupConfig.returnOrigIfMaxSizeExc,
-		return400IfFileExtRejected: HashLookupConfig.return400IfFileExtRejected,
+		maxFileSize:                cfg.maxFileSize,
+		bypassExts:                 cfg.bypassExts,
+		processExts:                cfg.processExts,
+		rejectExts:                 cfg.rejectExts,
+		extArrs:                    cfg.extArrs,
+		ScanUrl:                    cfg.ScanUrl,
+		Timeout:                    cfg.Timeout * time.Second,
+		returnOrigIfMaxSizeExc:     cfg.returnOrigIfMaxSizeExc,
+		return400IfFileExtRejected: cfg.return400IfFileExtRejected,
 		generalFunc:                general_functions.NewGeneralFunc(httpMsg, xICAPMetadata),
-		verifyServerCert:           HashLookupConfig.verifyServerCert,
-		BypassOnApiError:           HashLookupConfig.BypassOnApiError,
-		CaseBlockHttpResponseCode:  HashLookupConfig.CaseBlockHttpResponseCode,
-		CaseBlockHttpBody:          HashLookupConfig.CaseBlockHttpBody,
-		ExceptionPage:              HashLookupConfig.ExceptionPage,
+		verifyServerCert:           cfg.verifyServerCert,
+		BypassOnApiError:           cfg.BypassOnApiError,
+		CaseBlockHttpResponseCode:  cfg.CaseBlockHttpResponseCode,
+		CaseBlockHttpBody:          cfg.CaseBlockHttpBody,
+		ExceptionPage:              cfg.ExceptionPage,
+	}
+	h.applyScanProfile()
+	return h
+}
+
+// applyScanProfile replaces the keys of the service with the ones of the scan profile which the transaction
+// selected, if it selected one
+func (h *Hashlookup) applyScanProfile() {
+	profile := config.ScanProfile(h.serviceName, h.xICAPMetadata)
+	if profile == nil {
+		return
 	}
+	h.maxFileSize = profile.MaxFileSize
+	h.bypassExts, h.processExts, h.rejectExts = profile.BypassExtensions, profile.ProcessExtensions, profile.RejectExtensions
+	h.extArrs = services_utilities.ProfileExtsArr(profile)
+	h.returnOrigIfMaxSizeExc = profile.ReturnOrigIfMaxSizeExc
+	h.BypassOnApiError = profile.BypassOnApiError
 }
@@ -0,0 +1,167 @@
+package dlp
+
+import (
+	"icapeg/config"
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	services_utilities "icapeg/service/services-utilities"
+	general_functions "icapeg/service/services-utilities/general-functions"
+	"net/textproto"
+	"sync"
+)
+
+// DLPVendor is the vendor of the services which inspect the bodies and the headers of the outgoing HTTP requests
+// for sensitive data
+const DLPVendor = "dlp"
+
+// the default mask of the redacted data
+const defaultRedactMask = '*'
+
+// the keys of the services of the vendor in addition to the ones of every service
+func init() {
+	config.RegisterVendorKeys(DLPVendor, map[string]config.KeySpec{
+		"detectors":       {Kind: config.SliceKey},
+		"keywords":        {Kind: config.SliceKey},
+		"rules_file":      {Kind: config.StringKey},
+		"inspect_headers": {Kind: config.SliceKey},
+		"action":          {Kind: config.StringKey, Mandatory: true, Values: []string{utils.DLPActionBlock, utils.DLPActionRedact}},
+		"match_threshold": {Kind: config.IntKey},
+		"redact_mask":     {Kind: config.StringKey},
+	})
+}
+
+var doOnce sync.Once
+var (
+	configMu sync.RWMutex
+	// the service whose section the configuration was read from, the services of the vendor share it
+	configService string
+	dlpConfig     *DLP
+)
+
+// DLP represents the information regarding the DLP service
+type DLP struct {
+	xICAPMetadata  string
+	httpMsg        *http_message.HttpMsg
+	serviceName    string
+	methodName     string
+	maxFileSize    int
+	bypassExts     []string
+	processExts    []string
+	rejectExts     []string
+	extArrs        []services_utilities.Extension
+	detectors      []*Detector
+	rulesVersion   string
+	inspectHeaders []string
+	action         string
+	matchThreshold int
+	redactMask     byte
+	generalFunc    *general_functions.GeneralFunc
+}
+
+func InitDLPConfig(serviceName string) {
+	logging.Logger.Debug("loading " + serviceName + " service configurations")
+	doOnce.Do(func() {
+		configMu.Lock()
+		dlpConfig = readDLPConfig(serviceName)
+		configService = serviceName
+		configMu.Unlock()
+		general_functions.InitBlockPageLanguage(serviceName)
+	})
+}
+
+// readDLPConfig reads the configuration of the service and compiles its detectors: the built-in ones of the
+// detectors key, one for every keyword and the custom ones of the rules file. The detectors which can't be
+// loaded are logged and left out
+func readDLPConfig(serviceName string) *DLP {
+	keys := config.Service(serviceName)
+	cfg := &DLP{
+		maxFileSize:    keys.Int("max_filesize"),
+		bypassExts:     keys.Slice("bypass_extensions"),
+		processExts:    keys.Slice("process_extensions"),
+		rejectExts:     keys.Slice("reject_extensions"),
+		action:         keys.String("action"),
+		matchThreshold: keys.Int("match_threshold"),
+		redactMask:     defaultRedactMask,
+	}
+	cfg.extArrs = services_utilities.InitExtsArr(cfg.processExts, cfg.rejectExts, cfg.bypassExts)
+	if cfg.matchThreshold <= 0 {
+		cfg.matchThreshold = 1
+	}
+	if mask := keys.String("redact_mask"); mask != "" {
+		cfg.redactMask = mask[0]
+	}
+	for _, header := range keys.Slice("inspect_headers") {
+		cfg.inspectHeaders = append(cfg.inspectHeaders, textproto.CanonicalMIMEHeaderKey(header))
+	}
+	for _, name := range keys.Slice("detectors") {
+		detector, exists := BuiltInDetector(name)
+		if !exists {
+			logging.Logger.Error(serviceName + " service: unknown DLP detector " + name + ", it's left out")
+			continue
+		}
+		cfg.detectors = append(cfg.detectors, detector)
+	}
+	for _, keyword := range keys.Slice("keywords") {
+		if keyword != "" {
+			cfg.detectors = append(cfg.detectors, KeywordDetector(keyword))
+		}
+	}
+	if rulesFile := keys.String("rules_file"); rulesFile != "" {
+		rules, version, err := LoadRules(rulesFile)
+		if err != nil {
+			logging.Logger.Error(serviceName + " service: couldn't load the DLP rules file, its rules are left out: " +
+				err.Error())
+		}
+		cfg.detectors = append(cfg.detectors, rules...)
+		cfg.rulesVersion = version
+	}
+	return cfg
+}
+
+// ReloadDLPConfig reads the configuration of the service which loaded it again with its rules file, it returns
+// the func which makes it the configuration of the next requests, nil if it wasn't loaded yet or its section was
+// removed
+func ReloadDLPConfig() func() {
+	configMu.RLock()
+	serviceName := configService
+	configMu.RUnlock()
+	if serviceName == "" || config.Service(serviceName) == nil {
+		return nil
+	}
+	cfg := readDLPConfig(serviceName)
+	return func() {
+		configMu.Lock()
+		defer configMu.Unlock()
+		dlpConfig = cfg
+	}
+}
+
+func currentConfig() *DLP {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return dlpConfig
+}
+
+// NewDLPService returns a new populated instance of the DLP service
+func NewDLPService(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) *DLP {
+	cfg := currentConfig()
+	return &DLP{
+		xICAPMetadata:  xICAPMetadata,
+		httpMsg:        httpMsg,
+		serviceName:    serviceName,
+		methodName:     methodName,
+		generalFunc:    general_functions.NewGeneralFunc(httpMsg, xICAPMetadata),
+		maxFileSize:    cfg.maxFileSize,
+		bypassExts:     cfg.bypassExts,
+		processExts:    cfg.processExts,
+		rejectExts:     cfg.rejectExts,
+		extArrs:        cfg.extArrs,
+		detectors:      cfg.detectors,
+		rulesVersion:   cfg.rulesVersion,
+		inspectHeaders: cfg.inspectHeaders,
+		action:         cfg.action,
+		matchThreshold: cfg.matchThreshold,
+		redactMask:     cfg.redactMask,
+	}
+}
@@ -0,0 +1,193 @@
+package dlp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the built-in detectors of the detectors key
+const (
+	DetectorCreditCard = "credit_card"
+	DetectorUSSSN      = "us_ssn"
+	DetectorIBAN       = "iban"
+)
+
+// the prefixes of the names of the detectors which aren't built-in
+const (
+	keywordPrefix = "keyword:"
+	rulePrefix    = "rule:"
+)
+
+// Detector finds one kind of sensitive data, the matches of its pattern which fail its check are dropped
+type Detector struct {
+	Name    string
+	pattern *regexp.Regexp
+	check   func(match []byte) bool
+}
+
+// Match is a piece of sensitive data which a detector found in the content
+type Match struct {
+	Detector   string
+	Start, End int
+}
+
+var builtInDetectors = map[string]*Detector{
+	DetectorCreditCard: {
+		Name:    DetectorCreditCard,
+		pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		check:   validCardNumber,
+	},
+	DetectorUSSSN: {
+		Name:    DetectorUSSSN,
+		pattern: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`),
+		check:   validSSN,
+	},
+	DetectorIBAN: {
+		Name:    DetectorIBAN,
+		pattern: regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`),
+		check:   validIBAN,
+	},
+}
+
+// BuiltInDetector returns the built-in detector of the name, false if there's none
+func BuiltInDetector(name string) (*Detector, bool) {
+	d, exists := builtInDetectors[name]
+	return d, exists
+}
+
+// KeywordDetector returns the detector of a keyword, it's case-insensitive
+func KeywordDetector(keyword string) *Detector {
+	return &Detector{Name: keywordPrefix + keyword, pattern: regexp.MustCompile(`(?i)` + regexp.QuoteMeta(keyword))}
+}
+
+// LoadRules reads the custom detectors of a rules file, every line is `<name> = <regular expression>` and the
+// lines which start with # are comments. It returns the digest of the file with them, so the ISTag of the
+// services changes when the rules change
+func LoadRules(path string) ([]*Detector, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var detectors []*Detector
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, expr, found := strings.Cut(line, "=")
+		name, expr = strings.TrimSpace(name), strings.TrimSpace(expr)
+		if !found || name == "" || expr == "" {
+			return nil, "", errors.New(path + ":" + strconv.Itoa(n) + ": expected <name> = <regular expression>")
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, "", errors.New(path + ":" + strconv.Itoa(n) + ": " + err.Error())
+		}
+		detectors = append(detectors, &Detector{Name: rulePrefix + name, pattern: pattern})
+	}
+	sum := sha256.Sum256(data)
+	return detectors, hex.EncodeToString(sum[:8]), nil
+}
+
+// Scan returns the matches of the detectors in the content, sorted by their positions, the matches which overlap
+// an earlier one are dropped
+func Scan(content []byte, detectors []*Detector) []Match {
+	var matches []Match
+	for _, d := range detectors {
+		for _, loc := range d.pattern.FindAllIndex(content, -1) {
+			if d.check != nil && !d.check(content[loc[0]:loc[1]]) {
+				continue
+			}
+			matches = append(matches, Match{Detector: d.Name, Start: loc[0], End: loc[1]})
+		}
+	}
+	sort.SliceStable(matches, func(a, b int) bool { return matches[a].Start < matches[b].Start })
+	kept := matches[:0]
+	for _, m := range matches {
+		if len(kept) != 0 && m.Start < kept[len(kept)-1].End {
+			continue
+		}
+		kept = append(kept, m)
+	}
+	return kept
+}
+
+// Redact returns a copy of the content whose matches are replaced with the mask, every byte of a match is
+// masked so the content keeps its length
+func Redact(content []byte, matches []Match, mask byte) []byte {
+	redacted := append([]byte(nil), content...)
+	for _, m := range matches {
+		for i := m.Start; i < m.End; i++ {
+			redacted[i] = mask
+		}
+	}
+	return redacted
+}
+
+// Counts returns the number of the matches of every detector
+func Counts(matches []Match) map[string]int {
+	counts := make(map[string]int)
+	for _, m := range matches {
+		counts[m.Detector]++
+	}
+	return counts
+}
+
+// validCardNumber reports whether the digits of the match are a card number with a valid Luhn checksum
+func validCardNumber(match []byte) bool {
+	var digits []byte
+	for _, c := range match {
+		if c >= '0' && c <= '9' {
+			digits = append(digits, c-'0')
+		}
+	}
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := range digits {
+		d := int(digits[len(digits)-1-i])
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// validSSN reports whether the match is a US social security number which can be issued: the area isn't 000,
+// 666 or 9xx, the group isn't 00 and the serial isn't 0000
+func validSSN(match []byte) bool {
+	area, group, serial := string(match[0:3]), string(match[4:6]), string(match[7:11])
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// validIBAN reports whether the match is an IBAN whose check digits are valid (ISO 13616, mod 97)
+func validIBAN(match []byte) bool {
+	iban := strings.ReplaceAll(string(match), " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+	var numeric strings.Builder
+	for _, c := range iban[4:] + iban[:4] {
+		if c >= 'A' && c <= 'Z' {
+			numeric.WriteString(strconv.Itoa(int(c-'A') + 10))
+		} else {
+			numeric.WriteRune(c)
+		}
+	}
+	n, ok := new(big.Int).SetString(numeric.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
@@ -0,0 +1,59 @@
+package dlp
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func detectors(names ...string) []*Detector {
+	var result []*Detector
+	for _, name := range names {
+		d, _ := BuiltInDetector(name)
+		result = append(result, d)
+	}
+	return result
+}
+
+func TestScanBuiltInDetectors(t *testing.T) {
+	content := []byte("card 4111 1111 1111 1111, not a card 4111 1111 1111 1112, ssn 123-45-6789, " +
+		"not an ssn 666-45-6789, iban DE89 3704 0044 0532 0130 00, not an iban DE00 3704 0044 0532 0130 00")
+	counts := Counts(Scan(content, detectors(DetectorCreditCard, DetectorUSSSN, DetectorIBAN)))
+	expected := map[string]int{DetectorCreditCard: 1, DetectorUSSSN: 1, DetectorIBAN: 1}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Fatalf("expected %v, got %v", expected, counts)
+	}
+}
+
+func TestKeywordsAndRedaction(t *testing.T) {
+	content := []byte("This is CONFIDENTIAL, pay 5500-0000-0000-0004")
+	matches := Scan(content, append(detectors(DetectorCreditCard), KeywordDetector("confidential")))
+	if len(matches) != 2 || matches[0].Detector != "keyword:confidential" || matches[1].Detector != DetectorCreditCard {
+		t.Fatalf("expected the keyword then the card, got %+v", matches)
+	}
+	if redacted := string(Redact(content, matches, '*')); redacted != "This is ************, pay *******************" {
+		t.Fatalf("unexpected redaction %q", redacted)
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dlp.rules")
+	if err := os.WriteFile(path, []byte("# employee ids\nemployee_id = EMP-\\d{6}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rules, version, err := LoadRules(path)
+	if err != nil || len(rules) != 1 || version == "" {
+		t.Fatalf("expected one rule with a version, got %v %q %v", rules, version, err)
+	}
+	if matches := Scan([]byte("owner EMP-004211"), rules); len(matches) != 1 || matches[0].Detector != "rule:employee_id" {
+		t.Fatalf("expected the rule to match, got %+v", matches)
+	}
+
+	if err = os.WriteFile(path, []byte("employee_id = EMP-(\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = LoadRules(path); err == nil {
+		t.Fatal("an invalid regular expression should fail the rules file")
+	}
+}
@@ -0,0 +1,187 @@
+package dlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	utils "icapeg/consts"
+	"icapeg/logging"
+	services_utilities "icapeg/service/services-utilities"
+	"io"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Processing is a func used for to processing the http message, the body and the inspect_headers of the HTTP
+// request are inspected by the detectors of the service and the request is blocked or its sensitive data are
+// redacted. The HTTP responses are returned as they are
+func (d *DLP) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int, interface{}, map[string]string, map[string]interface{},
+	map[string]interface{}, map[string]interface{}) {
+	serviceHeaders := make(map[string]string)
+	serviceHeaders["X-ICAP-Metadata"] = d.xICAPMetadata
+	logging.Logger.Info(utils.PrepareLogMsg(d.xICAPMetadata, d.serviceName+" service has started processing"))
+	msgHeadersBeforeProcessing := d.generalFunc.LogHTTPMsgHeaders(d.methodName)
+	msgHeadersAfterProcessing := make(map[string]interface{})
+	vendorMsgs := make(map[string]interface{})
+
+	// the whole body is inspected, the sensitive data may be anywhere in it
+	if partial {
+		logging.Logger.Info(utils.PrepareLogMsg(d.xICAPMetadata,
+			d.serviceName+" service has stopped processing partially"))
+		return utils.Continue, nil, nil, msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+	if d.methodName != utils.ICAPModeReq || d.httpMsg.Request == nil {
+		logging.Logger.Info(utils.PrepareLogMsg(d.xICAPMetadata, d.serviceName+" service has stopped processing, "+
+			"it inspects the HTTP requests only"))
+		return utils.NoModificationStatusCodeStr, d.httpMsg.Response, serviceHeaders,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+	req := d.httpMsg.Request
+
+	var raw []byte
+	if req.Body != nil {
+		var err error
+		if raw, err = io.ReadAll(req.Body); err != nil {
+			logging.Logger.Error(utils.PrepareLogMsg(d.xICAPMetadata, d.serviceName+" error: "+err.Error()))
+			logging.Logger.Info(utils.PrepareLogMsg(d.xICAPMetadata, d.serviceName+" service has stopped processing"))
+			return utils.InternalServerErrStatusCodeStr, nil, serviceHeaders,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
+	}
+	req.Body = io.NopCloser(bytes.NewReader(raw))
+	body, gzipped := raw, false
+	if len(raw) != 0 && d.generalFunc.IsBodyGzipCompressed(d.methodName) {
+		if decompressed, err := gunzip(raw); err == nil {
+			body, gzipped = decompressed, true
+		} else {
+			logging.Logger.Debug(utils.PrepareLogMsg(d.xICAPMetadata, d.serviceName+
+				" inspects the body as it is, couldn't decompress it: "+err.Error()))
+		}
+	}
+	fileSize := fmt.Sprintf("%v", len(body))
+
+	//the bypassed file types and the bodies which exceed the max file size aren't inspected, the headers are
+	inspectBody := len(body) != 0
+	if inspectBody {
+		fileExtension := d.generalFunc.GetMimeExtension(body, req.Header.Get(utils.ContentType), d.generalFunc.GetFileName())
+		if d.generalFunc.ExtensionAction(fileExtension, d.extArrs, d.processExts, d.rejectExts, d.bypassExts,
+			d.serviceName) == utils.BypassExts {
+			inspectBody = false
+		} else if d.maxFileSize != 0 && d.maxFileSize < len(body) {
+			logging.Logger.Debug(utils.PrepareLogMsg(d.xICAPMetadata, d.serviceName+
+				" doesn't inspect the body, it exceeds the max file size"))
+			inspectBody = false
+		}
+	}
+
+	var bodyMatches []Match
+	if inspectBody {
+		bodyMatches = Scan(body, d.detectors)
+	}
+	headerMatches := make(map[string][][]Match)
+	all := append([]Match(nil), bodyMatches...)
+	for _, header := range d.inspectHeaders {
+		for n, value := range req.Header.Values(header) {
+			if matches := Scan([]byte(value), d.detectors); len(matches) != 0 {
+				if headerMatches[header] == nil {
+					headerMatches[header] = make([][]Match, len(req.Header.Values(header)))
+				}
+				headerMatches[header][n] = matches
+				all = append(all, matches...)
+			}
+		}
+	}
+	if len(all) < d.matchThreshold {
+		logging.Logger.Info(utils.PrepareLogMsg(d.xICAPMetadata, d.serviceName+" service has stopped processing"))
+		msgHeadersAfterProcessing = d.generalFunc.LogHTTPMsgHeaders(d.methodName)
+		return utils.NoModificationStatusCodeStr, req, serviceHeaders,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+
+	// the matched data aren't logged, only the detectors which found them
+	counts := Counts(all)
+	threat := threatOf(counts)
+	vendorMsgs[utils.VendorMsgDLPMatches] = counts
+	vendorMsgs[utils.VendorMsgDLPAction] = d.action
+	vendorMsgs[utils.VendorMsgThreat] = threat
+	logging.Logger.Info(utils.PrepareEventLogMsg(d.xICAPMetadata, utils.EventDLPMatch, map[string]interface{}{
+		"service":       d.serviceName,
+		"action":        d.action,
+		"matches":       counts,
+		"requested_url": req.RequestURI,
+	}))
+
+	if d.action == utils.DLPActionRedact {
+		for header, values := range headerMatches {
+			for n, value := range req.Header.Values(header) {
+				if n < len(values) && values[n] != nil {
+					req.Header[header][n] = string(Redact([]byte(value), values[n], d.redactMask))
+				}
+			}
+		}
+		redacted := raw
+		if len(bodyMatches) != 0 {
+			// the redacted body is sent decompressed
+			redacted = Redact(body, bodyMatches, d.redactMask)
+			if gzipped {
+				req.Header.Del("Content-Encoding")
+			}
+		}
+		services_utilities.AddThreatHeaders(serviceHeaders, services_utilities.Violation{FileName: "-",
+			Threat: threat, Type: services_utilities.ThreatTypePolicy,
+			Resolution: services_utilities.ResolutionRepaired})
+		logging.Logger.Info(utils.PrepareLogMsg(d.xICAPMetadata, d.serviceName+" service has stopped processing"))
+		msgHeadersAfterProcessing = d.generalFunc.LogHTTPMsgHeaders(d.methodName)
+		return utils.OkStatusCodeStr, d.generalFunc.RewriteRequestBody(redacted, ""), serviceHeaders,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+
+	vendorMsgs[utils.VendorMsgVerdict] = utils.SampleSeverityMalicious
+	d.generalFunc.SetThreatName(threat)
+	services_utilities.AddThreatHeaders(serviceHeaders, services_utilities.Violation{FileName: "-",
+		Threat: threat, Type: services_utilities.ThreatTypePolicy, Resolution: services_utilities.ResolutionBlocked})
+	htmlPage, blockReq, err := d.generalFunc.ReqModErrPage(utils.ErrPageReasonSensitiveData, d.serviceName, "-", fileSize)
+	if err != nil {
+		logging.Logger.Error(utils.PrepareLogMsg(d.xICAPMetadata, d.serviceName+" error: "+err.Error()))
+		return utils.InternalServerErrStatusCodeStr, nil, nil,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+	blockReq.Body = io.NopCloser(htmlPage)
+	logging.Logger.Info(utils.PrepareLogMsg(d.xICAPMetadata, d.serviceName+" service has stopped processing"))
+	msgHeadersAfterProcessing = d.generalFunc.LogHTTPMsgHeaders(d.methodName)
+	return utils.OkStatusCodeStr, blockReq, serviceHeaders,
+		msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+}
+
+// threatOf returns the threat name of the matches, ex: "DLP.credit_card,keyword:confidential"
+func threatOf(counts map[string]int) string {
+	detectors := make([]string, 0, len(counts))
+	for detector := range counts {
+		detectors = append(detectors, detector)
+	}
+	sort.Strings(detectors)
+	return "DLP." + strings.Join(detectors, ",")
+}
+
+// gunzip returns the decompressed gzip body
+func gunzip(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// SignatureVersion returns the digest of the rules file of the service, so its ISTag changes with the rules
+func (d *DLP) SignatureVersion() string {
+	return d.rulesVersion
+}
+
+func (d *DLP) ISTagValue() string {
+	epochTime := strconv.FormatInt(time.Now().Unix(), 10)
+	return "epoch-" + epochTime
+}
@@ -1,17 +1,33 @@
 package echo
 
 import (
+	"icapeg/config"
 	http_message "icapeg/http-message"
 	"icapeg/logging"
-	"icapeg/readValues"
 	services_utilities "icapeg/service/services-utilities"
 	general_functions "icapeg/service/services-utilities/general-functions"
 	"sync"
 	"time"
 )
 
+// EchoVendor is the vendor of the echo service
+const EchoVendor = "echo"
+
+// the keys of the services of the vendor in addition to the ones of every service
+func init() {
+	config.RegisterVendorKeys(EchoVendor, map[string]config.KeySpec{
+		"return_original_if_max_file_size_exceeded": {Kind: config.BoolKey, Mandatory: true},
+		"return_400_if_file_ext_rejected":           {Kind: config.BoolKey, Mandatory: true},
+	})
+}
+
 var doOnce sync.Once
-var echoConfig *Echo
+var (
+	configMu sync.RWMutex
+	// the service whose section the configuration was read from, the services of the vendor share it
+	configService string
+	echoConfig    *Echo
+)
 
 const EchoIdentifier = "ECHO ID"
 
@@ -35,32 +51,82 @@ type Echo struct {
 func InitEchoConfig(serviceName string) {
 	logging.Logger.Debug("loading " + serviceName + " service configurations")
 	doOnce.Do(func() {
-		echoConfig = &Echo{
-			maxFileSize:                readValues.ReadValuesInt(serviceName + ".max_filesize"),
-			bypassExts:                 readValues.ReadValuesSlice(serviceName + ".bypass_extensions"),
-			processExts:                readValues.ReadValuesSlice(serviceName + ".process_extensions"),
-			rejectExts:                 readValues.ReadValuesSlice(serviceName + ".reject_extensions"),
-			returnOrigIfMaxSizeExc:     readValues.ReadValuesBool(serviceName + ".return_original_if_max_file_size_exceeded"),
-			return400IfFileExtRejected: readValues.ReadValuesBool(serviceName + ".return_400_if_file_ext_rejected"),
-		}
-		echoConfig.extArrs = services_utilities.InitExtsArr(echoConfig.processExts, echoConfig.rejectExts, echoConfig.bypassExts)
+		configMu.Lock()
+		echoConfig = readEchoConfig(serviceName)
+		configService = serviceName
+		configMu.Unlock()
+		general_functions.InitBlockPageLanguage(serviceName)
 	})
 }
 
+// readEchoConfig reads the configuration of the service
+func readEchoConfig(serviceName string) *Echo {
+	keys := config.Service(serviceName)
+	cfg := &Echo{
+		maxFileSize:                keys.Int("max_filesize"),
+		bypassExts:                 keys.Slice("bypass_extensions"),
+		processExts:                keys.Slice("process_extensions"),
+		rejectExts:                 keys.Slice("reject_extensions"),
+		returnOrigIfMaxSizeExc:     keys.Bool("return_original_if_max_file_size_exceeded"),
+		return400IfFileExtRejected: keys.Bool("return_400_if_file_ext_rejected"),
+	}
+	cfg.extArrs = services_utilities.InitExtsArr(cfg.processExts, cfg.rejectExts, cfg.bypassExts)
+	return cfg
+}
+
+// ReloadEchoConfig reads the configuration of the service which loaded it again, it returns the func which
+// makes it the configuration of the next requests, nil if it wasn't loaded yet or its section was removed
+func ReloadEchoConfig() func() {
+	configMu.RLock()
+	serviceName := configService
+	configMu.RUnlock()
+	if serviceName == "" || config.Service(serviceName) == nil {
+		return nil
+	}
+	cfg := readEchoConfig(serviceName)
+	return func() {
+		configMu.Lock()
+		defer configMu.Unlock()
+		echoConfig = cfg
+	}
+}
+
+func currentConfig() *Echo {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return echoConfig
+}
+
 // NewEchoService returns a new populated instance of the Echo service
 func NewEchoService(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) *Echo {
-	return &Echo{
+	cfg := currentConfig()
+	e := &Echo{
 		xICAPMetadata:              xICAPMetadata,
 		httpMsg:                    httpMsg,
 		serviceName:                serviceName,
 		methodName:                 methodName,
 		generalFunc:                general_functions.NewGeneralFunc(httpMsg, xICAPMetadata),
-		maxFileSize:                echoConfig.maxFileSize,
-		bypassExts:                 echoConfig.bypassExts,
-		processExts:                echoConfig.processExts,
-		rejectExts:                 echoConfig.rejectExts,
-		extArrs:                    echoConfig.extArrs,
-		returnOrigIfMaxSizeExc:     echoConfig.returnOrigIfMaxSizeExc,
-		return400IfFileExtRejected: echoConfig.return400IfFileExtRejected,
+		maxFileSize:                cfg.maxFileSize,
+		bypassExts:                 cfg.bypassExts,
+		processExts:                cfg.processExts,
+		rejectExts:                 cfg.rejectExts,
+		extArrs:                    cfg.extArrs,
+		returnOrigIfMaxSizeExc:     cfg.returnOrigIfMaxSizeExc,
+		return400IfFileExtRejected: cfg.return400IfFileExtRejected,
+	}
+	e.applyScanProfile()
+	return e
+}
+
+// applyScanProfile replaces the keys of the service with the ones of the scan profile which the transaction
+// selected, if it selected one
+func (e *Echo) applyScanProfile() {
+	profile := config.ScanProfile(e.serviceName, e.xICAPMetadata)
+	if profile == nil {
+		return
 	}
+	e.maxFileSize = profile.MaxFileSize
+	e.bypassExts, e.processExts, e.rejectExts = profile.BypassExtensions, profile.ProcessExtensions, profile.RejectExtensions
+	e.extArrs = services_utilities.ProfileExtsArr(profile)
+	e.returnOrigIfMaxSizeExc = profile.ReturnOrigIfMaxSizeExc
 }
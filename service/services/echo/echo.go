@@ -1,11 +1,10 @@
 package echo
 
 import (
-	"bytes"
 	"fmt"
 	utils "icapeg/consts"
+	"icapeg/icap"
 	"icapeg/logging"
-	"io"
 	"net/http"
 	"net/textproto"
 	"strconv"
@@ -89,12 +88,12 @@ func (e *Echo) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int, i
 		switch msg := httpMsgAfter.(type) {
 
 		case *http.Request:
-			msg.Body = io.NopCloser(bytes.NewBuffer(fileAfterPrep))
+			msg.Body = icap.NewBody(fileAfterPrep)
 			logging.Logger.Info(utils.PrepareLogMsg(e.xICAPMetadata, e.serviceName+" service has stopped processing"))
 			msgHeadersAfterProcessing = e.generalFunc.LogHTTPMsgHeaders(e.methodName)
 			return status, msg, nil, msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
 		case *http.Response:
-			msg.Body = io.NopCloser(bytes.NewBuffer(fileAfterPrep))
+			msg.Body = icap.NewBody(fileAfterPrep)
 			logging.Logger.Info(utils.PrepareLogMsg(e.xICAPMetadata, e.serviceName+" service has stopped processing"))
 			msgHeadersAfterProcessing = e.generalFunc.LogHTTPMsgHeaders(e.methodName)
 			return status, msg, nil, msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
@@ -0,0 +1,187 @@
+package hashreputation
+
+import (
+	"icapeg/config"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	services_utilities "icapeg/service/services-utilities"
+	general_functions "icapeg/service/services-utilities/general-functions"
+	"icapeg/service/services-utilities/health"
+	"icapeg/service/services-utilities/retry"
+	"sync"
+	"time"
+)
+
+// HashReputationVendor is the vendor of the services which look the SHA-256 of the files up in the reputation
+// API of VirusTotal or MetaDefender without uploading them
+const HashReputationVendor = "hash_reputation"
+
+// the keys of the services of the vendor in addition to the ones of every service
+func init() {
+	config.RegisterVendorKeys(HashReputationVendor, map[string]config.KeySpec{
+		"provider":            {Kind: config.StringKey, Mandatory: true, Values: []string{ProviderVirusTotal, ProviderMetaDefender}},
+		"scan_url":            {Kind: config.StringKey},
+		"api_key":             {Kind: config.StringKey},
+		"timeout":             {Kind: config.DurationKey, Mandatory: true},
+		"malicious_threshold": {Kind: config.IntKey},
+		"fallback_service":    {Kind: config.StringKey},
+		"return_original_if_max_file_size_exceeded": {Kind: config.BoolKey, Mandatory: true},
+		"return_400_if_file_ext_rejected":           {Kind: config.BoolKey, Mandatory: true},
+		"bypass_on_api_error":                       {Kind: config.BoolKey},
+		"verify_server_cert":                        {Kind: config.BoolKey, Mandatory: true},
+		"http_exception_response_code":              {Kind: config.IntKey, Mandatory: true},
+		"http_exception_has_body":                   {Kind: config.BoolKey, Mandatory: true},
+		"exception_page":                            {Kind: config.StringKey, Mandatory: true},
+	})
+	health.Register(HashReputationVendor, probeScanURL)
+}
+
+var doOnce sync.Once
+var (
+	configMu sync.RWMutex
+	// the service whose section the configuration was read from, the services of the vendor share it
+	configService        string
+	HashReputationConfig *HashReputation
+)
+
+// HashReputation represents the information regarding the hash reputation service
+type HashReputation struct {
+	xICAPMetadata              string
+	httpMsg                    *http_message.HttpMsg
+	serviceName                string
+	methodName                 string
+	maxFileSize                int
+	bypassExts                 []string
+	processExts                []string
+	rejectExts                 []string
+	extArrs                    []services_utilities.Extension
+	Provider                   string
+	ScanUrl                    string
+	APIKey                     string
+	Timeout                    time.Duration
+	MaliciousThreshold         int
+	FallbackService            string
+	returnOrigIfMaxSizeExc     bool
+	return400IfFileExtRejected bool
+	generalFunc                *general_functions.GeneralFunc
+	BypassOnApiError           bool
+	verifyServerCert           bool
+	FileHash                   string
+	CaseBlockHttpResponseCode  int
+	CaseBlockHttpBody          bool
+	ExceptionPage              string
+}
+
+func InitHashReputationConfig(serviceName string) {
+	logging.Logger.Debug("loading " + serviceName + " service configurations")
+	doOnce.Do(func() {
+		configMu.Lock()
+		HashReputationConfig = readHashReputationConfig(serviceName)
+		configService = serviceName
+		configMu.Unlock()
+		general_functions.InitBlockPageLanguage(serviceName)
+		retry.InitRetryConfig(serviceName)
+	})
+}
+
+// readHashReputationConfig reads the configuration of the service, the scan_url of the provider is used if the
+// section has none, and a file is malicious from one detection if the section has no malicious_threshold
+func readHashReputationConfig(serviceName string) *HashReputation {
+	keys := config.Service(serviceName)
+	cfg := &HashReputation{
+		maxFileSize:                keys.Int("max_filesize"),
+		bypassExts:                 keys.Slice("bypass_extensions"),
+		processExts:                keys.Slice("process_extensions"),
+		rejectExts:                 keys.Slice("reject_extensions"),
+		Provider:                   keys.String("provider"),
+		ScanUrl:                    keys.String("scan_url"),
+		APIKey:                     keys.String("api_key"),
+		Timeout:                    keys.Duration("timeout"),
+		MaliciousThreshold:         keys.Int("malicious_threshold"),
+		FallbackService:            keys.String("fallback_service"),
+		returnOrigIfMaxSizeExc:     keys.Bool("return_original_if_max_file_size_exceeded"),
+		return400IfFileExtRejected: keys.Bool("return_400_if_file_ext_rejected"),
+		BypassOnApiError:           keys.Bool("bypass_on_api_error"),
+		verifyServerCert:           keys.Bool("verify_server_cert"),
+		CaseBlockHttpResponseCode:  keys.Int("http_exception_response_code"),
+		CaseBlockHttpBody:          keys.Bool("http_exception_has_body"),
+		ExceptionPage:              keys.String("exception_page"),
+	}
+	if cfg.ScanUrl == "" {
+		cfg.ScanUrl = providers[cfg.Provider].scanURL
+	}
+	if cfg.MaliciousThreshold <= 0 {
+		cfg.MaliciousThreshold = 1
+	}
+	cfg.extArrs = services_utilities.InitExtsArr(cfg.processExts, cfg.rejectExts, cfg.bypassExts)
+	return cfg
+}
+
+// ReloadHashReputationConfig reads the configuration of the service which loaded it again, it returns the func
+// which makes it the configuration of the next requests, nil if it wasn't loaded yet or its section was removed
+func ReloadHashReputationConfig() func() {
+	configMu.RLock()
+	serviceName := configService
+	configMu.RUnlock()
+	if serviceName == "" || config.Service(serviceName) == nil {
+		return nil
+	}
+	cfg := readHashReputationConfig(serviceName)
+	return func() {
+		configMu.Lock()
+		defer configMu.Unlock()
+		HashReputationConfig = cfg
+	}
+}
+
+func currentConfig() *HashReputation {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return HashReputationConfig
+}
+
+// NewHashReputationService returns a new populated instance of the hash reputation service
+func NewHashReputationService(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) *HashReputation {
+	cfg := currentConfig()
+	h := &HashReputation{
+		xICAPMetadata:              xICAPMetadata,
+		httpMsg:                    httpMsg,
+		serviceName:                serviceName,
+		methodName:                 methodName,
+		maxFileSize:                cfg.maxFileSize,
+		bypassExts:                 cfg.bypassExts,
+		processExts:                cfg.processExts,
+		rejectExts:                 cfg.rejectExts,
+		extArrs:                    cfg.extArrs,
+		Provider:                   cfg.Provider,
+		ScanUrl:                    cfg.ScanUrl,
+		APIKey:                     cfg.APIKey,
+		Timeout:                    cfg.Timeout * time.Second,
+		MaliciousThreshold:         cfg.MaliciousThreshold,
+		FallbackService:            cfg.FallbackService,
+		returnOrigIfMaxSizeExc:     cfg.returnOrigIfMaxSizeExc,
+		return400IfFileExtRejected: cfg.return400IfFileExtRejected,
+		generalFunc:                general_functions.NewGeneralFunc(httpMsg, xICAPMetadata),
+		verifyServerCert:           cfg.verifyServerCert,
+		BypassOnApiError:           cfg.BypassOnApiError,
+		CaseBlockHttpResponseCode:  cfg.CaseBlockHttpResponseCode,
+		CaseBlockHttpBody:          cfg.CaseBlockHttpBody,
+		ExceptionPage:              cfg.ExceptionPage,
+	}
+	h.applyScanProfile()
+	return h
+}
+
+// applyScanProfile replaces the keys of the service with the ones of the scan profile which the transaction
+// selected, if it selected one
+func (h *HashReputation) applyScanProfile() {
+	profile := config.ScanProfile(h.serviceName, h.xICAPMetadata)
+	if profile == nil {
+		return
+	}
+	h.maxFileSize = profile.MaxFileSize
+	h.bypassExts, h.processExts, h.rejectExts = profile.BypassExtensions, profile.ProcessExtensions, profile.RejectExtensions
+	h.extArrs = services_utilities.ProfileExtsArr(profile)
+	h.returnOrigIfMaxSizeExc = profile.ReturnOrigIfMaxSizeExc
+	h.BypassOnApiError = profile.BypassOnApiError
+}
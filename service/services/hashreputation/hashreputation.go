@@ -0,0 +1,470 @@
+package hashreputation
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"icapeg/cache"
+	"icapeg/config"
+	utils "icapeg/consts"
+	"icapeg/icap"
+	"icapeg/logging"
+	"icapeg/service/registry"
+	services_utilities "icapeg/service/services-utilities"
+	"icapeg/service/services-utilities/ContentTypes"
+	"icapeg/service/services-utilities/capture"
+	"icapeg/service/services-utilities/credentials"
+	"icapeg/service/services-utilities/digests"
+	"icapeg/service/services-utilities/hotswap"
+	"icapeg/service/services-utilities/istag"
+	"icapeg/service/services-utilities/proxy"
+	"icapeg/service/services-utilities/retry"
+	"icapeg/service/services-utilities/spool"
+	"icapeg/service/services-utilities/toggles"
+	"icapeg/service/services-utilities/tracing"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// the vendor messages of the lookups, the reputation is known_good, known_bad or unknown
+const (
+	vendorMsgReputation      = "hash_reputation"
+	vendorMsgDetections      = "detections"
+	vendorMsgFallbackService = "fallback_service"
+	reputationKnownGood      = "known_good"
+	reputationKnownBad       = "known_bad"
+	reputationUnknown        = "unknown"
+)
+
+// Processing is a func used for to processing the http message
+func (h *HashReputation) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int, interface{}, map[string]string, map[string]interface{},
+	map[string]interface{}, map[string]interface{}) {
+	serviceHeaders := make(map[string]string)
+	serviceHeaders["X-ICAP-Metadata"] = h.xICAPMetadata
+	logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has started processing"))
+	msgHeadersBeforeProcessing := h.generalFunc.LogHTTPMsgHeaders(h.methodName)
+	msgHeadersAfterProcessing := make(map[string]interface{})
+	vendorMsgs := make(map[string]interface{})
+	// no need to look up part of the file, the hash is computed from the whole file, the files which are bypassed
+	// or rejected upon their types are answered from the preview without the rest of the body
+	if partial {
+		ExceptionPagePath := utils.BlockPagePath
+		if h.ExceptionPage != "" {
+			ExceptionPagePath = h.ExceptionPage
+		}
+		icapStatus, httpMsg := h.generalFunc.PreviewDecision(h.extArrs, h.processExts, h.rejectExts, h.bypassExts,
+			h.return400IfFileExtRejected, h.serviceName, h.methodName, ExceptionPagePath)
+		logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata,
+			h.serviceName+" service has stopped processing partially"))
+		if icapStatus != utils.Continue {
+			msgHeadersAfterProcessing = h.generalFunc.LogHTTPMsgHeaders(h.methodName)
+			return icapStatus, httpMsg, serviceHeaders,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
+		return utils.Continue, nil, nil,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+	if h.methodName == utils.ICAPModeResp {
+		if h.httpMsg.Response != nil {
+			if h.httpMsg.Response.StatusCode == 206 {
+				logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has stopped processing byte range received"))
+				return utils.NoModificationStatusCodeStr, h.httpMsg, serviceHeaders,
+					msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+			}
+		}
+	}
+	isGzip := false
+	ExceptionPagePath := utils.BlockPagePath
+
+	if h.ExceptionPage != "" {
+		ExceptionPagePath = h.ExceptionPage
+	}
+	//the fallback service scans the whole body again if the provider doesn't know the hash of the file
+	var rewind func() io.ReadCloser
+	if h.FallbackService != "" {
+		rewind = h.rewindableBody()
+	}
+	//extracting the file from http message, the hash of a spooled HTTP response body is computed from the spool
+	body, streaming := h.generalFunc.SpooledBody(h.methodName)
+	file, reqContentType := &bytes.Buffer{}, ContentTypes.ContentType(nil)
+	var err error
+	if !streaming {
+		file, reqContentType, err = h.generalFunc.CopyingFileToTheBuffer(h.methodName)
+		if err != nil {
+			logging.Logger.Error(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" error: "+err.Error()))
+			logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has stopped processing"))
+			return utils.InternalServerErrStatusCodeStr, nil, serviceHeaders,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
+	}
+	head, size, fileReader := file.Bytes(), int64(file.Len()), io.Reader(bytes.NewReader(file.Bytes()))
+	if streaming {
+		head, size, fileReader = body.Head(spool.SniffLen), body.Size(), body.Open()
+	}
+
+	//if the http method is Connect, return the request as it is because it has no body
+	if h.methodName == utils.ICAPModeReq {
+		if h.httpMsg.Request.Method == http.MethodConnect {
+			return utils.OkStatusCodeStr, h.generalFunc.ReturningHttpMessageWithFile(h.methodName, file.Bytes()),
+				serviceHeaders, msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
+	}
+
+	//getting the extension of the file
+	var contentType []string
+	if h.methodName == utils.ICAPModeReq {
+		contentType = h.httpMsg.Request.Header["Content-Type"]
+	} else {
+		contentType = h.httpMsg.Response.Header["Content-Type"]
+	}
+	fileName := h.generalFunc.GetFileName()
+	if len(contentType) == 0 {
+		contentType = append(contentType, "")
+	}
+
+	logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" file name : "+fileName))
+
+	fileExtension := h.generalFunc.GetMimeExtension(head, contentType[0], fileName)
+
+	fileDigests, err := digests.Compute(fileReader)
+	if err != nil {
+		logging.Logger.Error(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" error: "+err.Error()))
+	}
+	fileSize := fmt.Sprintf("%v", size)
+	fileHash := fileDigests[digests.SHA256]
+	h.FileHash = fileHash
+	logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" file hash : "+fileHash))
+	if streaming && h.generalFunc.ExtensionAction(fileExtension, h.extArrs, h.processExts, h.rejectExts,
+		h.bypassExts, h.serviceName) == utils.BypassExts {
+		logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has stopped processing"))
+		msgHeadersAfterProcessing = h.generalFunc.LogHTTPMsgHeaders(h.methodName)
+		return utils.NoModificationStatusCodeStr, h.generalFunc.ReturningHttpMessageWithSpool(body), serviceHeaders,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+
+	//check if the file extension is a bypass extension
+	//if yes we will not modify the file, and we will return 204 No modifications
+	isProcess, icapStatus, httpMsg := h.generalFunc.CheckTheExtension(fileExtension, h.extArrs,
+		h.processExts, h.rejectExts, h.bypassExts, h.return400IfFileExtRejected, isGzip,
+		h.serviceName, h.methodName, fileHash, h.httpMsg.Request.RequestURI, reqContentType, file, ExceptionPagePath, fileSize)
+	if !isProcess {
+		logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has stopped processing"))
+		msgHeadersAfterProcessing = h.generalFunc.LogHTTPMsgHeaders(h.methodName)
+		return icapStatus, httpMsg, serviceHeaders,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+	//check if the file size is greater than max file size of the service
+	//if yes we will return 200 ok or 204 no modification, it depends on the configuration of the service
+	if h.maxFileSize != 0 && int64(h.maxFileSize) < size {
+		if streaming && h.returnOrigIfMaxSizeExc {
+			logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has stopped processing"))
+			msgHeadersAfterProcessing = h.generalFunc.LogHTTPMsgHeaders(h.methodName)
+			return utils.NoModificationStatusCodeStr, h.generalFunc.ReturningHttpMessageWithSpool(body), nil,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
+		status, file, httpMsg := h.generalFunc.IfMaxFileSizeExc(h.returnOrigIfMaxSizeExc, h.serviceName, h.methodName, file, h.maxFileSize, ExceptionPagePath, fileSize)
+		fileAfterPrep, httpMsg := h.generalFunc.IfStatusIs204WithFile(h.methodName, status, file, isGzip, reqContentType, httpMsg, true)
+		if fileAfterPrep == nil && httpMsg == nil {
+			logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has stopped processing"))
+			return utils.InternalServerErrStatusCodeStr, nil, serviceHeaders,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
+		switch msg := httpMsg.(type) {
+		case *http.Request:
+			msg.Body = icap.NewBody(fileAfterPrep)
+		case *http.Response:
+			msg.Body = icap.NewBody(fileAfterPrep)
+		}
+		logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has stopped processing"))
+		msgHeadersAfterProcessing = h.generalFunc.LogHTTPMsgHeaders(h.methodName)
+		return status, httpMsg, nil,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+
+	var rep reputation
+	if list, _, found := cache.LookupHashList(fileHash); found {
+		logging.Logger.Debug(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" file hash is in the "+list+" list"))
+		vendorMsgs["hash_list"] = list
+		rep = reputation{known: true}
+		if list == cache.DenyListName {
+			rep.detections, rep.threat = h.MaliciousThreshold, "KnownMalicious"
+		}
+	} else if verdict, found := cache.GetVerdict(h.serviceName, "", fileHash); found {
+		logging.Logger.Debug(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" verdict was found in the verdict cache"))
+		vendorMsgs["verdict_cache"] = "hit"
+		rep = reputation{known: true, threat: verdict.Threat}
+		if verdict.Malicious {
+			rep.detections = h.MaliciousThreshold
+		}
+	} else {
+		rep, err = h.lookUp(fileHash)
+		if err == nil && rep.known {
+			cache.SetVerdict(h.serviceName, "", fileHash, rep.detections >= h.MaliciousThreshold, h.threatOf(rep))
+		}
+	}
+	if err != nil {
+		vendorMsgs[utils.VendorMsgError] = err.Error()
+	}
+	if err != nil && !h.BypassOnApiError {
+		logging.Logger.Error(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" error: "+err.Error()))
+		if strings.Contains(err.Error(), "context deadline exceeded") {
+			logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has stopped processing"))
+			return utils.RequestTimeOutStatusCodeStr, nil, nil,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
+		logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has stopped processing"))
+		return utils.BadRequestStatusCodeStr, nil, nil,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+
+	//the files which the provider doesn't know are scanned by the fallback service, it uploads them to its vendor
+	if err == nil && !rep.known {
+		vendorMsgs[vendorMsgReputation] = reputationUnknown
+		if fallback, done := h.fallbackService(rewind); fallback != nil {
+			defer done()
+			logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" doesn't know the file hash, "+
+				h.FallbackService+" service scans the file"))
+			icapStatus, httpMsg, fallbackHeaders, _, msgHeadersAfterProcessing, fallbackMsgs := fallback.Processing(false, IcapHeader)
+			if fallbackMsgs == nil {
+				fallbackMsgs = make(map[string]interface{})
+			}
+			fallbackMsgs[vendorMsgReputation] = reputationUnknown
+			fallbackMsgs[vendorMsgFallbackService] = h.FallbackService
+			logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has stopped processing"))
+			return icapStatus, httpMsg, fallbackHeaders,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, fallbackMsgs
+		}
+	}
+
+	if rep.known && rep.detections >= h.MaliciousThreshold {
+		threat := h.threatOf(rep)
+		logging.Logger.Debug(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+": file is not safe"))
+		vendorMsgs[vendorMsgReputation] = reputationKnownBad
+		vendorMsgs[vendorMsgDetections] = rep.detections
+		vendorMsgs[utils.VendorMsgVerdict] = utils.SampleSeverityMalicious
+		vendorMsgs[utils.VendorMsgThreat] = threat
+		h.generalFunc.SetThreatName(threat)
+		vendorMsgs[utils.VendorMsgFileName] = fileName
+		vendorMsgs[utils.VendorMsgFileHash] = fileHash
+		vendorMsgs[utils.VendorMsgFileDigests] = fileDigests
+		vendorMsgs[utils.VendorMsgFileSize] = fileSize
+		services_utilities.AddThreatHeaders(serviceHeaders, services_utilities.Violation{FileName: fileName,
+			Threat: threat, Type: services_utilities.ThreatTypeInfection,
+			Resolution: services_utilities.ResolutionBlocked})
+		if h.methodName == utils.ICAPModeResp {
+			errPage := h.generalFunc.GenHtmlPage(ExceptionPagePath, utils.ErrPageReasonFileIsNotSafe, h.serviceName, h.FileHash, h.httpMsg.Request.RequestURI, fileSize, h.xICAPMetadata)
+			h.httpMsg.Response = h.generalFunc.ErrPageResp(h.CaseBlockHttpResponseCode, errPage.Len())
+			if h.CaseBlockHttpBody {
+				h.httpMsg.Response.Body = icap.NewBody(errPage.Bytes())
+			} else {
+				var r []byte
+				h.httpMsg.Response.Body = icap.NewBody(r)
+				delete(h.httpMsg.Response.Header, "Content-Type")
+				delete(h.httpMsg.Response.Header, "Content-Length")
+			}
+			logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has stopped processing"))
+			msgHeadersAfterProcessing = h.generalFunc.LogHTTPMsgHeaders(h.methodName)
+			return utils.OkStatusCodeStr, h.httpMsg.Response, serviceHeaders,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
+		htmlPage, req, err := h.generalFunc.ReqModErrPage(utils.ErrPageReasonFileIsNotSafe, h.serviceName, h.FileHash, fileSize)
+		if err != nil {
+			logging.Logger.Error(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" error: "+err.Error()))
+			return utils.InternalServerErrStatusCodeStr, nil, nil,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
+		req.Body = io.NopCloser(htmlPage)
+		msgHeadersAfterProcessing = h.generalFunc.LogHTTPMsgHeaders(h.methodName)
+		return utils.OkStatusCodeStr, req, serviceHeaders,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+	if rep.known {
+		vendorMsgs[vendorMsgReputation] = reputationKnownGood
+		vendorMsgs[vendorMsgDetections] = rep.detections
+	}
+
+	//returning the file as it is, it's known to be clean or the provider doesn't know it
+	logging.Logger.Info(utils.PrepareLogMsg(h.xICAPMetadata, h.serviceName+" service has stopped processing"))
+	msgHeadersAfterProcessing = h.generalFunc.LogHTTPMsgHeaders(h.methodName)
+	if streaming {
+		return utils.NoModificationStatusCodeStr, h.generalFunc.ReturningHttpMessageWithSpool(body),
+			serviceHeaders, msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+	scannedFile := h.generalFunc.PreparingFileAfterScanning(file.Bytes(), reqContentType, h.methodName)
+	return utils.NoModificationStatusCodeStr, h.generalFunc.ReturningHttpMessageWithFile(h.methodName, scannedFile),
+		serviceHeaders, msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+}
+
+// threatOf returns the threat of a known file, the provider may not name the threats of the files which few
+// engines detected
+func (h *HashReputation) threatOf(rep reputation) string {
+	if rep.threat != "" {
+		return rep.threat
+	}
+	if rep.detections >= h.MaliciousThreshold {
+		return "KnownMalicious"
+	}
+	return ""
+}
+
+// lookUp looks the SHA-256 of the file up in the API of the provider, the file itself isn't sent. The API key of
+// [app.vendor_credentials] replaces the api_key of the service
+func (h *HashReputation) lookUp(fileHash string) (reputation, error) {
+	p := providers[h.Provider]
+	apiKey := h.APIKey
+	if value, exists, err := credentials.Get(HashReputationVendor); exists {
+		if err != nil {
+			return reputation{}, err
+		}
+		apiKey = value
+	}
+	client := &http.Client{Transport: capture.Transport(HashReputationVendor, h.xICAPMetadata,
+		proxy.Transport(HashReputationVendor))}
+	var resp *http.Response
+	err := retry.Do(h.serviceName, func() (err error) {
+		req, err := http.NewRequest(http.MethodGet, h.ScanUrl+fileHash, nil)
+		if err != nil {
+			return err
+		}
+		if apiKey != "" {
+			req.Header.Set(p.keyHeader, apiKey)
+		}
+		req.Header.Set("Accept", "application/json")
+		spanCtx, span := tracing.StartVendorCall(h.xICAPMetadata, "GET "+h.Provider,
+			attribute.String("http.url", req.URL.String()))
+		defer func() { tracing.End(span, err) }()
+		tracing.Inject(spanCtx, req.Header)
+		ctx, cancel := context.WithTimeout(spanCtx, h.Timeout)
+		resp, err = client.Do(req.WithContext(ctx))
+		if err != nil {
+			cancel()
+			return err
+		}
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+		resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+		return retry.CheckResponse(resp)
+	})
+	if err != nil {
+		return reputation{}, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return p.parse(resp.Body)
+	case http.StatusNotFound:
+		return reputation{}, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		// the key may have been rotated at the provider, it's loaded again for the next lookup
+		credentials.Invalidate(HashReputationVendor)
+		return reputation{}, errors.New(h.Provider + " rejected the API key: " + resp.Status)
+	}
+	return reputation{}, errors.New(h.Provider + " answered " + resp.Status)
+}
+
+// rewindableBody returns the func which gives the fallback service the body of the HTTP message from its start,
+// a body which isn't spooled is kept in memory. It returns nil if the HTTP message has no body
+func (h *HashReputation) rewindableBody() func() io.ReadCloser {
+	var body *io.ReadCloser
+	if h.methodName == utils.ICAPModeReq && h.httpMsg.Request != nil {
+		body = &h.httpMsg.Request.Body
+	} else if h.methodName == utils.ICAPModeResp && h.httpMsg.Response != nil {
+		body = &h.httpMsg.Response.Body
+	}
+	if body == nil || *body == nil {
+		return nil
+	}
+	if spooled, isSpooled := spool.Of(*body); isSpooled {
+		return func() io.ReadCloser { return spooled.Open() }
+	}
+	data, _ := io.ReadAll(*body)
+	*body = io.NopCloser(bytes.NewReader(data))
+	return func() io.ReadCloser { return io.NopCloser(bytes.NewReader(data)) }
+}
+
+// fallbackService returns the fallback service which scans the HTTP message from the start of its body, with the
+// func which ends the scan of its vendor. It returns nil if the service can't scan it: the service is disabled at
+// runtime, it doesn't support the ICAP method or its vendor isn't registered
+func (h *HashReputation) fallbackService(rewind func() io.ReadCloser) (registry.Service, func()) {
+	if h.FallbackService == "" || rewind == nil {
+		return nil, nil
+	}
+	serviceInstance, exists := config.App().ServicesInstances[h.FallbackService]
+	if !exists || toggles.IsDisabled(h.FallbackService) ||
+		(h.methodName == utils.ICAPModeReq && !serviceInstance.ReqMode) ||
+		(h.methodName == utils.ICAPModeResp && !serviceInstance.RespMode) {
+		logging.Logger.Warn(utils.PrepareLogMsg(h.xICAPMetadata, h.FallbackService+
+			" service can't scan the "+h.methodName+" HTTP message, the file whose hash is unknown is returned as it is"))
+		return nil, nil
+	}
+	vendor := hotswap.Vendor(h.FallbackService, serviceInstance.Vendor)
+	v, exists := registry.Lookup(vendor)
+	if !exists {
+		return nil, nil
+	}
+	if v.Init != nil {
+		v.Init(h.FallbackService)
+	}
+	if h.methodName == utils.ICAPModeReq {
+		h.httpMsg.Request.Body = rewind()
+	} else {
+		h.httpMsg.Response.Body = rewind()
+	}
+	return v.New(h.FallbackService, h.methodName, h.httpMsg, h.xICAPMetadata), hotswap.Begin(h.FallbackService, vendor)
+}
+
+// cancelBody cancels the context of the request when the response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// ISTagValue returns the ISTag which changes with the definition version of the vendor if the ISTag rotation
+// polls it
+func (h *HashReputation) ISTagValue() string {
+	if tag, exists := istag.Value(HashReputationVendor); exists {
+		return tag
+	}
+	epochTime := strconv.FormatInt(time.Now().Unix(), 10)
+	return "epoch-" + epochTime
+}
+
+// probeScanURL is the health probe of the API of a service, the API is reachable if its host answers a HEAD
+// request without a server error, the lookups aren't counted against the quota of the API key
+func probeScanURL(serviceName string, timeout time.Duration) error {
+	keys := config.Service(serviceName)
+	if keys == nil {
+		return errors.New("the service isn't configured")
+	}
+	scanURL := keys.String("scan_url")
+	if scanURL == "" {
+		scanURL = providers[keys.String("provider")].scanURL
+	}
+	u, err := url.Parse(scanURL)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: proxy.Transport(HashReputationVendor), Timeout: timeout}
+	resp, err := client.Head(u.Scheme + "://" + u.Host + "/")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return errors.New("the API answered " + resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package hashreputation
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// the providers of the reputation APIs
+const (
+	ProviderVirusTotal   = "virustotal"
+	ProviderMetaDefender = "metadefender"
+)
+
+// reputation is what the provider knows about the hash of a file
+type reputation struct {
+	known      bool   // false if the provider never saw the file
+	detections int    // the engines which detected the file
+	threat     string // the name of the threat, empty if the provider has none
+}
+
+// provider is a reputation API, the SHA-256 of the file is appended to its scan url and a hash which it
+// doesn't know is answered with 404
+type provider struct {
+	scanURL   string
+	keyHeader string // the header of the API key
+	parse     func(body io.Reader) (reputation, error)
+}
+
+var providers = map[string]provider{
+	ProviderVirusTotal: {
+		scanURL:   "https://www.virustotal.com/api/v3/files/",
+		keyHeader: "x-apikey",
+		parse:     parseVirusTotal,
+	},
+	ProviderMetaDefender: {
+		scanURL:   "https://api.metadefender.com/v4/hash/",
+		keyHeader: "apikey",
+		parse:     parseMetaDefender,
+	},
+}
+
+// parseVirusTotal parses the file report of the VirusTotal API v3, the threat is the label which VirusTotal
+// suggests or the result of the first engine which detected the file
+func parseVirusTotal(body io.Reader) (reputation, error) {
+	var report struct {
+		Data struct {
+			Attributes struct {
+				LastAnalysisStats struct {
+					Malicious int `json:"malicious"`
+				} `json:"last_analysis_stats"`
+				PopularThreatClassification struct {
+					SuggestedThreatLabel string `json:"suggested_threat_label"`
+				} `json:"popular_threat_classification"`
+				LastAnalysisResults map[string]struct {
+					Category string `json:"category"`
+					Result   string `json:"result"`
+				} `json:"last_analysis_results"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(body).Decode(&report); err != nil {
+		return reputation{}, err
+	}
+	attributes := report.Data.Attributes
+	r := reputation{known: true, detections: attributes.LastAnalysisStats.Malicious,
+		threat: attributes.PopularThreatClassification.SuggestedThreatLabel}
+	if r.threat == "" {
+		threats := make(map[string]string)
+		for engine, result := range attributes.LastAnalysisResults {
+			if result.Category == "malicious" {
+				threats[engine] = result.Result
+			}
+		}
+		r.threat = firstThreat(threats)
+	}
+	return r, nil
+}
+
+// parseMetaDefender parses the hash lookup of MetaDefender Cloud (v4) and MetaDefender Core, Core answers the
+// hashes which it doesn't know with 200 and a body without scan results
+func parseMetaDefender(body io.Reader) (reputation, error) {
+	var report struct {
+		ScanResults *struct {
+			TotalDetectedAVs int `json:"total_detected_avs"`
+			ScanDetails      map[string]struct {
+				ThreatFound string `json:"threat_found"`
+			} `json:"scan_details"`
+		} `json:"scan_results"`
+	}
+	if err := json.NewDecoder(body).Decode(&report); err != nil {
+		return reputation{}, err
+	}
+	if report.ScanResults == nil {
+		return reputation{}, nil
+	}
+	threats := make(map[string]string)
+	for engine, details := range report.ScanResults.ScanDetails {
+		threats[engine] = details.ThreatFound
+	}
+	return reputation{known: true, detections: report.ScanResults.TotalDetectedAVs, threat: firstThreat(threats)}, nil
+}
+
+// firstThreat returns the first threat which an engine found in the order of the engine names, so the threat of a
+// file doesn't change between the lookups
+func firstThreat(threats map[string]string) string {
+	engines := make([]string, 0, len(threats))
+	for engine, threat := range threats {
+		if threat != "" {
+			engines = append(engines, engine)
+		}
+	}
+	if len(engines) == 0 {
+		return ""
+	}
+	sort.Strings(engines)
+	return threats[engines[0]]
+}
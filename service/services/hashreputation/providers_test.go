@@ -0,0 +1,65 @@
+package hashreputation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseVirusTotal(t *testing.T) {
+	report := `{"data": {"attributes": {"last_analysis_stats": {"malicious": 3, "undetected": 60},
+		"last_analysis_results": {"Zillya": {"category": "malicious", "result": "EICAR.TestFile"},
+		"Avast": {"category": "malicious", "result": "EICAR Test-NOT virus!!!"},
+		"Bkav": {"category": "undetected", "result": null}}}}}`
+	r, err := parseVirusTotal(strings.NewReader(report))
+	if err != nil || !r.known || r.detections != 3 || r.threat != "EICAR Test-NOT virus!!!" {
+		t.Fatalf("parseVirusTotal() = %+v, %v", r, err)
+	}
+	labeled := `{"data": {"attributes": {"last_analysis_stats": {"malicious": 3},
+		"popular_threat_classification": {"suggested_threat_label": "virus.eicar/test"}}}}`
+	if r, _ = parseVirusTotal(strings.NewReader(labeled)); r.threat != "virus.eicar/test" {
+		t.Fatalf("the suggested threat label should be the threat, got %q", r.threat)
+	}
+}
+
+func TestParseMetaDefender(t *testing.T) {
+	report := `{"scan_results": {"total_detected_avs": 2, "scan_details": {
+		"ClamAV": {"threat_found": "Eicar-Signature"}, "Ahnlab": {"threat_found": ""}}}}`
+	r, err := parseMetaDefender(strings.NewReader(report))
+	if err != nil || !r.known || r.detections != 2 || r.threat != "Eicar-Signature" {
+		t.Fatalf("parseMetaDefender() = %+v, %v", r, err)
+	}
+	// MetaDefender Core answers the unknown hashes with 200
+	if r, err = parseMetaDefender(strings.NewReader(`{"ABC": "Not Found"}`)); err != nil || r.known {
+		t.Fatalf("a hash without scan results should be unknown, got %+v, %v", r, err)
+	}
+}
+
+func TestLookUp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-apikey") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/unknown") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"data": {"attributes": {"last_analysis_stats": {"malicious": 0, "harmless": 70}}}}`))
+	}))
+	defer server.Close()
+	h := &HashReputation{serviceName: "reputation", Provider: ProviderVirusTotal, ScanUrl: server.URL + "/files/",
+		APIKey: "secret", Timeout: time.Second, MaliciousThreshold: 1}
+	if r, err := h.lookUp("clean"); err != nil || !r.known || r.detections != 0 {
+		t.Fatalf("lookUp() of a known good file = %+v, %v", r, err)
+	}
+	if r, err := h.lookUp("unknown"); err != nil || r.known {
+		t.Fatalf("lookUp() of an unknown file = %+v, %v", r, err)
+	}
+	h.APIKey = "rotated"
+	if _, err := h.lookUp("clean"); err == nil {
+		t.Fatal("a rejected API key should be an error")
+	}
+}
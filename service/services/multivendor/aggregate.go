@@ -0,0 +1,95 @@
+package multivendor
+
+import (
+	"fmt"
+	utils "icapeg/consts"
+	"strconv"
+)
+
+// the outcomes of the vendors in the vendor messages of the service
+const (
+	outcomeClean      = "clean"
+	outcomeBlocked    = "blocked"
+	outcomeFailed     = "failed"
+	outcomeContinue   = "continue"   // the vendor needs the rest of the body after the preview
+	outcomeUnfinished = "unfinished" // the verdict was aggregated before the vendor returned or ran
+)
+
+// result holds the values which Processing func of a vendor returned, with their outcome
+type result struct {
+	vendor         string
+	outcome        string
+	reason         string // why the vendor failed
+	status         int
+	httpMsg        interface{}
+	serviceHeaders map[string]string
+	before         map[string]interface{}
+	after          map[string]interface{}
+	vendorMsgs     map[string]interface{}
+}
+
+// outcomeOf classifies the values which a vendor returned: the vendor blocked the file if its verdict is malicious
+// and it failed if it answered with an error or it reported one (ex: it bypassed the file upon bypass_on_api_error)
+func outcomeOf(status int, vendorMsgs map[string]interface{}) (string, string) {
+	if vendorMsgs[utils.VendorMsgVerdict] == utils.SampleSeverityMalicious {
+		return outcomeBlocked, ""
+	}
+	switch status {
+	case utils.Continue:
+		return outcomeContinue, ""
+	case utils.InternalServerErrStatusCodeStr, utils.RequestTimeOutStatusCodeStr:
+		return outcomeFailed, "it answered with status " + strconv.Itoa(status)
+	}
+	if vendorErr, exists := vendorMsgs[utils.VendorMsgError]; exists {
+		return outcomeFailed, fmt.Sprint(vendorErr)
+	}
+	return outcomeClean, ""
+}
+
+// decide returns the result which the service answers with upon the results of the vendors in the order they
+// returned, and whether the aggregation is over while pending vendors haven't returned yet:
+//   - any_block blocks upon the first vendor which blocks the file, the file is clean if no vendor blocked it
+//   - all_block blocks if every vendor which returned a verdict blocked it, the file is clean upon the first clean
+//     verdict
+//   - first_verdict answers with the first vendor which returns a verdict
+//
+// The vendors which failed are left out, the first failure is the answer only if none of them returned a verdict.
+// A vendor which needs the rest of the body asks for it for all of them
+func decide(aggregation string, results []*result, pending int) (*result, bool) {
+	first := func(outcome string) *result {
+		for _, r := range results {
+			if r.outcome == outcome {
+				return r
+			}
+		}
+		return nil
+	}
+	if r := first(outcomeContinue); r != nil {
+		return r, true
+	}
+	switch aggregation {
+	case utils.AggregationAllBlock:
+		if r := first(outcomeClean); r != nil {
+			return r, true
+		}
+	case utils.AggregationFirstVerdict:
+		for _, r := range results {
+			if r.outcome != outcomeFailed {
+				return r, true
+			}
+		}
+	default:
+		if r := first(outcomeBlocked); r != nil {
+			return r, true
+		}
+	}
+	if pending > 0 {
+		return nil, false
+	}
+	for _, outcome := range []string{outcomeBlocked, outcomeClean, outcomeFailed} {
+		if r := first(outcome); r != nil {
+			return r, true
+		}
+	}
+	return nil, true
+}
@@ -0,0 +1,104 @@
+package multivendor
+
+import (
+	"errors"
+	"icapeg/config"
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	"icapeg/service/registry"
+	"icapeg/service/services-utilities/health"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the vendor keys are checked by config with the keys of the listed vendors, the services of the vendor are
+// healthy while one of their vendors is
+func init() {
+	health.Register(utils.MultiVendor, probeVendors)
+}
+
+// MultiVendor represents the information regarding a service which runs several vendors and aggregates their
+// verdicts
+type MultiVendor struct {
+	xICAPMetadata string
+	httpMsg       *http_message.HttpMsg
+	serviceName   string
+	methodName    string
+	vendors       []string
+	fanOut        string
+	aggregation   string
+	timeouts      map[string]time.Duration
+	membersMu     sync.Mutex                  // the vendors run at once
+	members       map[string]registry.Service // the service of every vendor once it was created
+}
+
+// InitMultiVendorConfig loads the configuration of every vendor of the service from its section, the vendors
+// load it once
+func InitMultiVendorConfig(serviceName string) {
+	logging.Logger.Debug("loading " + serviceName + " service configurations")
+	for _, vendor := range multiVendorConfig(serviceName).Vendors {
+		if v, exists := registry.Lookup(vendor); exists && v.Init != nil {
+			v.Init(serviceName)
+		}
+	}
+}
+
+// multiVendorConfig returns the vendors keys of the service, the configuration which is being reloaded has them
+// too because config reads them with the other keys
+func multiVendorConfig(serviceName string) *config.MultiVendorConfig {
+	if serviceInstance, exists := config.App().ServicesInstances[serviceName]; exists && serviceInstance.MultiVendor != nil {
+		return serviceInstance.MultiVendor
+	}
+	return &config.MultiVendorConfig{}
+}
+
+// NewMultiVendorService returns a new populated instance of the multi vendor service
+func NewMultiVendorService(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) *MultiVendor {
+	cfg := multiVendorConfig(serviceName)
+	return &MultiVendor{
+		xICAPMetadata: xICAPMetadata,
+		httpMsg:       httpMsg,
+		serviceName:   serviceName,
+		methodName:    methodName,
+		vendors:       cfg.Vendors,
+		fanOut:        cfg.FanOut,
+		aggregation:   cfg.Aggregation,
+		timeouts:      cfg.Timeouts,
+		members:       make(map[string]registry.Service),
+	}
+}
+
+// probeVendors is the health probe of the services of the vendor, it probes the backends of the vendors of the
+// service at once and fails only if all of them are down because the verdicts of the others are aggregated
+// without them
+func probeVendors(serviceName string, timeout time.Duration) error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var failures []string
+	probed := 0
+	for _, vendor := range multiVendorConfig(serviceName).Vendors {
+		probe, exists := health.Lookup(vendor)
+		if !exists {
+			continue
+		}
+		probed++
+		wg.Add(1)
+		go func(vendor string) {
+			defer wg.Done()
+			if err := probe(serviceName, timeout); err != nil {
+				mu.Lock()
+				failures = append(failures, vendor+": "+err.Error())
+				mu.Unlock()
+			}
+		}(vendor)
+	}
+	wg.Wait()
+	if probed > 0 && len(failures) == probed {
+		sort.Strings(failures)
+		return errors.New(strings.Join(failures, ", "))
+	}
+	return nil
+}
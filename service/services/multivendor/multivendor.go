@@ -0,0 +1,201 @@
+package multivendor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	"icapeg/service/registry"
+	"icapeg/service/services-utilities/spool"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Processing is a func used for to processing the http message, every vendor of the service processes its own
+// copy of the HTTP message, in parallel or one after the other, and the service answers with the result which
+// the aggregation of their verdicts selects. A vendor which doesn't return before its timeout is left out
+func (m *MultiVendor) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int, interface{}, map[string]string, map[string]interface{},
+	map[string]interface{}, map[string]interface{}) {
+	logging.Logger.Info(utils.PrepareLogMsg(m.xICAPMetadata, m.serviceName+" service has started processing"))
+	rewind := m.rewindableBody()
+	var results []*result
+	if m.fanOut == utils.FanOutSequential {
+		for _, vendor := range m.vendors {
+			results = append(results, m.run(vendor, partial, IcapHeader, rewind))
+			if _, final := decide(m.aggregation, results, len(m.vendors)-len(results)); final {
+				break
+			}
+		}
+	} else {
+		returned := make(chan *result, len(m.vendors))
+		for _, vendor := range m.vendors {
+			go func(vendor string) {
+				returned <- m.run(vendor, partial, IcapHeader, rewind)
+			}(vendor)
+		}
+		for range m.vendors {
+			results = append(results, <-returned)
+			if _, final := decide(m.aggregation, results, len(m.vendors)-len(results)); final {
+				break
+			}
+		}
+	}
+	logging.Logger.Info(utils.PrepareLogMsg(m.xICAPMetadata, m.serviceName+" service has stopped processing"))
+
+	r, _ := decide(m.aggregation, results, 0)
+	if r == nil {
+		r = &result{status: utils.InternalServerErrStatusCodeStr, vendorMsgs: map[string]interface{}{}}
+	}
+	if r.outcome == outcomeContinue {
+		return utils.Continue, nil, nil, r.before, r.after, r.vendorMsgs
+	}
+	vendorMsgs := make(map[string]interface{}, len(r.vendorMsgs)+1)
+	for key, value := range r.vendorMsgs {
+		vendorMsgs[key] = value
+	}
+	vendorMsgs[utils.VendorMsgVendors] = m.outcomes(results)
+	if r.outcome == outcomeFailed {
+		if _, exists := vendorMsgs[utils.VendorMsgError]; !exists {
+			vendorMsgs[utils.VendorMsgError] = "none of the vendors returned a verdict"
+		}
+	}
+	serviceHeaders := r.serviceHeaders
+	if serviceHeaders == nil && r.status != utils.InternalServerErrStatusCodeStr {
+		serviceHeaders = map[string]string{"X-ICAP-Metadata": m.xICAPMetadata}
+	}
+	return r.status, r.httpMsg, serviceHeaders, r.before, r.after, vendorMsgs
+}
+
+// outcomes returns the outcome of every vendor for the vendor messages, with the reason of the failures
+func (m *MultiVendor) outcomes(results []*result) map[string]interface{} {
+	outcomes := make(map[string]interface{}, len(m.vendors))
+	for _, vendor := range m.vendors {
+		outcomes[vendor] = outcomeUnfinished
+	}
+	for _, r := range results {
+		outcomes[r.vendor] = r.outcome
+		if r.reason != "" {
+			outcomes[r.vendor] = r.outcome + ": " + r.reason
+		}
+	}
+	return outcomes
+}
+
+// run processes the copy of the HTTP message with the service of the vendor up to the timeout of the vendor,
+// the vendor which timed out keeps processing in the background but its result is dropped
+func (m *MultiVendor) run(vendor string, partial bool, IcapHeader textproto.MIMEHeader, rewind func() io.ReadCloser) *result {
+	member := m.member(vendor, m.copyHttpMsg(rewind))
+	if member == nil {
+		logging.Logger.Error(utils.PrepareLogMsg(m.xICAPMetadata, "the vendor "+vendor+" of "+m.serviceName+
+			" service isn't registered"))
+		return &result{vendor: vendor, outcome: outcomeFailed, reason: "the vendor isn't registered",
+			status: utils.InternalServerErrStatusCodeStr}
+	}
+	returned := make(chan *result, 1)
+	go func() {
+		r := &result{vendor: vendor}
+		r.status, r.httpMsg, r.serviceHeaders, r.before, r.after, r.vendorMsgs = member.Processing(partial, IcapHeader)
+		if r.vendorMsgs == nil {
+			r.vendorMsgs = make(map[string]interface{})
+		}
+		r.outcome, r.reason = outcomeOf(r.status, r.vendorMsgs)
+		returned <- r
+	}()
+	timeout := m.timeouts[vendor]
+	select {
+	case r := <-returned:
+		if r.outcome == outcomeFailed {
+			logging.Logger.Warn(utils.PrepareLogMsg(m.xICAPMetadata, "the vendor "+vendor+" of "+m.serviceName+
+				" service failed, its verdict is left out: "+r.reason))
+		}
+		return r
+	case <-time.After(timeout):
+		logging.Logger.Warn(utils.PrepareLogMsg(m.xICAPMetadata, "the vendor "+vendor+" of "+m.serviceName+
+			" service didn't return its verdict in "+timeout.String()+", its verdict is left out"))
+		return &result{vendor: vendor, outcome: outcomeFailed, reason: "timed out after " + timeout.String(),
+			status: utils.RequestTimeOutStatusCodeStr}
+	}
+}
+
+// member creates the service of the vendor which processes the HTTP message, nil if the vendor isn't registered
+func (m *MultiVendor) member(vendor string, httpMsg *http_message.HttpMsg) registry.Service {
+	v, exists := registry.Lookup(vendor)
+	if !exists {
+		return nil
+	}
+	if v.Init != nil {
+		v.Init(m.serviceName)
+	}
+	service := v.New(m.serviceName, m.methodName, httpMsg, m.xICAPMetadata)
+	m.membersMu.Lock()
+	m.members[vendor] = service
+	m.membersMu.Unlock()
+	return service
+}
+
+// copyHttpMsg returns the copy of the HTTP message which a vendor processes: the vendors change the headers of
+// the messages which they return, and every vendor reads the body from its start
+func (m *MultiVendor) copyHttpMsg(rewind func() io.ReadCloser) *http_message.HttpMsg {
+	httpMsg := &http_message.HttpMsg{}
+	if m.httpMsg.Request != nil {
+		httpMsg.Request = m.httpMsg.Request.Clone(m.httpMsg.Request.Context())
+		if m.methodName == utils.ICAPModeReq && rewind != nil {
+			httpMsg.Request.Body = rewind()
+		}
+	}
+	if m.httpMsg.Response != nil {
+		response := *m.httpMsg.Response
+		response.Header = m.httpMsg.Response.Header.Clone()
+		if m.methodName == utils.ICAPModeResp && rewind != nil {
+			response.Body = rewind()
+		}
+		if httpMsg.Request != nil {
+			response.Request = httpMsg.Request
+		}
+		httpMsg.Response = &response
+	}
+	return httpMsg
+}
+
+// rewindableBody returns the func which gives every vendor the body of the HTTP message from its start, a body
+// which isn't spooled is kept in memory. It returns nil if the HTTP message has no body
+func (m *MultiVendor) rewindableBody() func() io.ReadCloser {
+	var body *io.ReadCloser
+	if m.methodName == utils.ICAPModeReq && m.httpMsg.Request != nil {
+		body = &m.httpMsg.Request.Body
+	} else if m.methodName == utils.ICAPModeResp && m.httpMsg.Response != nil {
+		body = &m.httpMsg.Response.Body
+	}
+	if body == nil || *body == nil || *body == http.NoBody {
+		return nil
+	}
+	if spooled, isSpooled := spool.Of(*body); isSpooled {
+		return func() io.ReadCloser { return spooled.Open() }
+	}
+	data, _ := io.ReadAll(*body)
+	*body = io.NopCloser(bytes.NewReader(data))
+	return func() io.ReadCloser { return io.NopCloser(bytes.NewReader(data)) }
+}
+
+// ISTagValue returns the ISTag which changes with the ISTag of any vendor of the service
+func (m *MultiVendor) ISTagValue() string {
+	tags := make([]string, 0, len(m.vendors))
+	for _, vendor := range m.vendors {
+		m.membersMu.Lock()
+		service, exists := m.members[vendor]
+		m.membersMu.Unlock()
+		if !exists {
+			if service = m.member(vendor, m.httpMsg); service == nil {
+				continue
+			}
+		}
+		tags = append(tags, vendor+"="+service.ISTagValue())
+	}
+	sum := sha256.Sum256([]byte(strings.Join(tags, ",")))
+	return "multi-" + hex.EncodeToString(sum[:])[:16]
+}
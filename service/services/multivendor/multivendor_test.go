@@ -0,0 +1,131 @@
+package multivendor
+
+import (
+	utils "icapeg/consts"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	"icapeg/service/registry"
+	"io"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	// the vendors which timed out keep running after their test, the logger is set once before them
+	logging.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+// fakeVendor blocks the bodies which contain EICAR after its delay, it stops waiting when done is closed
+type fakeVendor struct {
+	httpMsg *http_message.HttpMsg
+	delay   time.Duration
+	status  int
+	done    <-chan struct{}
+}
+
+func (f *fakeVendor) Processing(bool, textproto.MIMEHeader) (int, interface{}, map[string]string,
+	map[string]interface{}, map[string]interface{}, map[string]interface{}) {
+	select {
+	case <-time.After(f.delay):
+	case <-f.done:
+		return utils.InternalServerErrStatusCodeStr, nil, nil, nil, nil, nil
+	}
+	body, _ := io.ReadAll(f.httpMsg.Response.Body)
+	if f.status != 0 {
+		return f.status, nil, nil, nil, nil, nil
+	}
+	if strings.Contains(string(body), "EICAR") {
+		return utils.OkStatusCodeStr, f.httpMsg.Response, nil, nil, nil,
+			map[string]interface{}{utils.VendorMsgVerdict: utils.SampleSeverityMalicious}
+	}
+	return utils.NoModificationStatusCodeStr, f.httpMsg.Response, nil, nil, nil, map[string]interface{}{}
+}
+
+func (f *fakeVendor) ISTagValue() string { return "fake" }
+
+func registerFake(name string, delay time.Duration, status int, done <-chan struct{}) {
+	registry.Register(name, registry.Vendor{
+		New: func(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) registry.Service {
+			return &fakeVendor{httpMsg: httpMsg, delay: delay, status: status, done: done}
+		},
+	})
+}
+
+func newTestService(body, fanOut, aggregation string, vendors ...string) *MultiVendor {
+	timeouts := make(map[string]time.Duration)
+	for _, vendor := range vendors {
+		timeouts[vendor] = 200 * time.Millisecond
+	}
+	response := &http.Response{StatusCode: http.StatusOK, Header: http.Header{},
+		Body: io.NopCloser(strings.NewReader(body))}
+	return &MultiVendor{httpMsg: &http_message.HttpMsg{Response: response}, serviceName: "multi",
+		methodName: utils.ICAPModeResp, vendors: vendors, fanOut: fanOut, aggregation: aggregation,
+		timeouts: timeouts, members: make(map[string]registry.Service)}
+}
+
+func TestProcessingAggregation(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	registerFake("fake_fast", 0, 0, done)
+	registerFake("fake_slow", time.Minute, 0, done)
+	registerFake("fake_broken", 0, utils.InternalServerErrStatusCodeStr, done)
+
+	tests := []struct {
+		name, body, fanOut, aggregation string
+		vendors                         []string
+		status                          int
+		outcomes                        map[string]string
+	}{
+		{"a slow vendor doesn't stall the others", "EICAR", utils.FanOutParallel, utils.AggregationAnyBlock,
+			[]string{"fake_slow", "fake_fast"}, utils.OkStatusCodeStr,
+			map[string]string{"fake_fast": outcomeBlocked, "fake_slow": outcomeUnfinished}},
+		{"the timed out vendor is left out", "clean", utils.FanOutParallel, utils.AggregationAnyBlock,
+			[]string{"fake_slow", "fake_fast"}, utils.NoModificationStatusCodeStr,
+			map[string]string{"fake_fast": outcomeClean, "fake_slow": outcomeFailed + ": timed out after 200ms"}},
+		{"the broken vendor is skipped in order", "EICAR", utils.FanOutSequential, utils.AggregationFirstVerdict,
+			[]string{"fake_broken", "fake_fast", "fake_slow"}, utils.OkStatusCodeStr,
+			map[string]string{"fake_broken": outcomeFailed + ": it answered with status 500",
+				"fake_fast": outcomeBlocked, "fake_slow": outcomeUnfinished}},
+		{"all_block needs every verdict", "EICAR", utils.FanOutSequential, utils.AggregationAllBlock,
+			[]string{"fake_fast", "fake_broken"}, utils.OkStatusCodeStr,
+			map[string]string{"fake_fast": outcomeBlocked, "fake_broken": outcomeFailed + ": it answered with status 500"}},
+		{"no verdict is a failure", "clean", utils.FanOutParallel, utils.AggregationAnyBlock,
+			[]string{"fake_broken", "unregistered"}, utils.InternalServerErrStatusCodeStr,
+			map[string]string{"fake_broken": outcomeFailed + ": it answered with status 500",
+				"unregistered": outcomeFailed + ": the vendor isn't registered"}},
+	}
+	for _, test := range tests {
+		m := newTestService(test.body, test.fanOut, test.aggregation, test.vendors...)
+		status, _, _, _, _, vendorMsgs := m.Processing(false, nil)
+		if status != test.status {
+			t.Errorf("%s: the status = %d, want %d", test.name, status, test.status)
+		}
+		outcomes := vendorMsgs[utils.VendorMsgVendors].(map[string]interface{})
+		for vendor, outcome := range test.outcomes {
+			if outcomes[vendor] != outcome {
+				t.Errorf("%s: the outcome of %s = %v, want %s", test.name, vendor, outcomes[vendor], outcome)
+			}
+		}
+	}
+}
+
+func TestDecideWaitsForThePendingVendors(t *testing.T) {
+	clean := &result{vendor: "a", outcome: outcomeClean}
+	if _, final := decide(utils.AggregationAnyBlock, []*result{clean}, 1); final {
+		t.Fatal("any_block shouldn't pass the file before every vendor returned")
+	}
+	if r, final := decide(utils.AggregationAllBlock, []*result{clean}, 1); !final || r != clean {
+		t.Fatal("all_block should pass the file upon the first clean verdict")
+	}
+	more := &result{vendor: "b", outcome: outcomeContinue}
+	if r, final := decide(utils.AggregationAnyBlock, []*result{clean, more}, 1); !final || r != more {
+		t.Fatal("a vendor which needs the rest of the body should ask for it")
+	}
+}
@@ -0,0 +1,164 @@
+package remoteicap
+
+import (
+	"icapeg/config"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	services_utilities "icapeg/service/services-utilities"
+	general_functions "icapeg/service/services-utilities/general-functions"
+	"icapeg/service/services-utilities/health"
+	"icapeg/service/services-utilities/retry"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+// RemoteICAPVendor is the vendor of the services which forward the HTTP messages to an upstream ICAP server
+const RemoteICAPVendor = "remote_icap"
+
+// the keys of the services of the vendor in addition to the ones of every service
+func init() {
+	config.RegisterVendorKeys(RemoteICAPVendor, map[string]config.KeySpec{
+		"upstream_url":           {Kind: config.StringKey, Mandatory: true},
+		"upstream_preview_bytes": {Kind: config.IntKey},
+		"timeout":                {Kind: config.DurationKey, Mandatory: true},
+		"return_original_if_max_file_size_exceeded": {Kind: config.BoolKey, Mandatory: true},
+		"return_400_if_file_ext_rejected":           {Kind: config.BoolKey, Mandatory: true},
+		"bypass_on_api_error":                       {Kind: config.BoolKey},
+		"verify_server_cert":                        {Kind: config.BoolKey, Mandatory: true},
+		"exception_page":                            {Kind: config.StringKey, Mandatory: true},
+	})
+	health.Register(RemoteICAPVendor, probeUpstream)
+}
+
+var doOnce sync.Once
+var (
+	configMu sync.RWMutex
+	// the service whose section the configuration was read from, the services of the vendor share it
+	configService    string
+	RemoteICAPConfig *RemoteICAP
+)
+
+// RemoteICAP represents the information regarding the remote ICAP service
+type RemoteICAP struct {
+	xICAPMetadata              string
+	httpMsg                    *http_message.HttpMsg
+	serviceName                string
+	methodName                 string
+	maxFileSize                int
+	bypassExts                 []string
+	processExts                []string
+	rejectExts                 []string
+	extArrs                    []services_utilities.Extension
+	UpstreamURL                string
+	Timeout                    time.Duration
+	PreviewBytes               int
+	returnOrigIfMaxSizeExc     bool
+	return400IfFileExtRejected bool
+	generalFunc                *general_functions.GeneralFunc
+	BypassOnApiError           bool
+	verifyServerCert           bool
+	ExceptionPage              string
+	IcapHeaders                textproto.MIMEHeader
+}
+
+func InitRemoteICAPConfig(serviceName string) {
+	logging.Logger.Debug("loading " + serviceName + " service configurations")
+	doOnce.Do(func() {
+		configMu.Lock()
+		RemoteICAPConfig = readRemoteICAPConfig(serviceName)
+		configService = serviceName
+		configMu.Unlock()
+		general_functions.InitBlockPageLanguage(serviceName)
+		retry.InitRetryConfig(serviceName)
+	})
+}
+
+// readRemoteICAPConfig reads the configuration of the service, an upstream_preview_bytes below zero or missing
+// uses the Preview of the OPTIONS response of the upstream service
+func readRemoteICAPConfig(serviceName string) *RemoteICAP {
+	keys := config.Service(serviceName)
+	cfg := &RemoteICAP{
+		maxFileSize:                keys.Int("max_filesize"),
+		bypassExts:                 keys.Slice("bypass_extensions"),
+		processExts:                keys.Slice("process_extensions"),
+		rejectExts:                 keys.Slice("reject_extensions"),
+		UpstreamURL:                keys.String("upstream_url"),
+		Timeout:                    keys.Duration("timeout"),
+		PreviewBytes:               -1,
+		returnOrigIfMaxSizeExc:     keys.Bool("return_original_if_max_file_size_exceeded"),
+		return400IfFileExtRejected: keys.Bool("return_400_if_file_ext_rejected"),
+		BypassOnApiError:           keys.Bool("bypass_on_api_error"),
+		verifyServerCert:           keys.Bool("verify_server_cert"),
+		ExceptionPage:              keys.String("exception_page"),
+	}
+	if keys.Has("upstream_preview_bytes") {
+		cfg.PreviewBytes = keys.Int("upstream_preview_bytes")
+	}
+	cfg.extArrs = services_utilities.InitExtsArr(cfg.processExts, cfg.rejectExts, cfg.bypassExts)
+	return cfg
+}
+
+// ReloadRemoteICAPConfig reads the configuration of the service which loaded it again, it returns the func which
+// makes it the configuration of the next requests, nil if it wasn't loaded yet or its section was removed
+func ReloadRemoteICAPConfig() func() {
+	configMu.RLock()
+	serviceName := configService
+	configMu.RUnlock()
+	if serviceName == "" || config.Service(serviceName) == nil {
+		return nil
+	}
+	cfg := readRemoteICAPConfig(serviceName)
+	return func() {
+		configMu.Lock()
+		defer configMu.Unlock()
+		RemoteICAPConfig = cfg
+	}
+}
+
+func currentConfig() *RemoteICAP {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return RemoteICAPConfig
+}
+
+// NewRemoteICAPService returns a new populated instance of the remote ICAP service
+func NewRemoteICAPService(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) *RemoteICAP {
+	cfg := currentConfig()
+	r := &RemoteICAP{
+		xICAPMetadata:              xICAPMetadata,
+		httpMsg:                    httpMsg,
+		serviceName:                serviceName,
+		methodName:                 methodName,
+		maxFileSize:                cfg.maxFileSize,
+		bypassExts:                 cfg.bypassExts,
+		processExts:                cfg.processExts,
+		rejectExts:                 cfg.rejectExts,
+		extArrs:                    cfg.extArrs,
+		UpstreamURL:                cfg.UpstreamURL,
+		Timeout:                    cfg.Timeout * time.Second,
+		PreviewBytes:               cfg.PreviewBytes,
+		returnOrigIfMaxSizeExc:     cfg.returnOrigIfMaxSizeExc,
+		return400IfFileExtRejected: cfg.return400IfFileExtRejected,
+		generalFunc:                general_functions.NewGeneralFunc(httpMsg, xICAPMetadata),
+		BypassOnApiError:           cfg.BypassOnApiError,
+		verifyServerCert:           cfg.verifyServerCert,
+		ExceptionPage:              cfg.ExceptionPage,
+	}
+	r.applyScanProfile()
+	return r
+}
+
+// applyScanProfile replaces the keys of the service with the ones of the scan profile which the transaction
+// selected, if it selected one
+func (r *RemoteICAP) applyScanProfile() {
+	profile := config.ScanProfile(r.serviceName, r.xICAPMetadata)
+	if profile == nil {
+		return
+	}
+	r.maxFileSize = profile.MaxFileSize
+	r.bypassExts, r.processExts, r.rejectExts = profile.BypassExtensions, profile.ProcessExtensions, profile.RejectExtensions
+	r.extArrs = services_utilities.ProfileExtsArr(profile)
+	r.returnOrigIfMaxSizeExc = profile.ReturnOrigIfMaxSizeExc
+	r.BypassOnApiError = profile.BypassOnApiError
+}
@@ -0,0 +1,440 @@
+package remoteicap
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"icapeg/config"
+	utils "icapeg/consts"
+	"icapeg/icap"
+	"icapeg/logging"
+	"icapeg/pkg/icapclient"
+	services_utilities "icapeg/service/services-utilities"
+	"icapeg/service/services-utilities/retry"
+	"icapeg/service/services-utilities/spool"
+	"icapeg/service/services-utilities/tracing"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// the OPTIONS response of the upstream service is asked for again after its Options-TTL, or after this if it
+// has none
+const defaultOptionsTTL = time.Hour
+
+// the ICAP headers of the ICAP client which are forwarded to the upstream service
+var forwardedHeaders = []string{"X-Client-IP", "X-Client-Username", "X-Authenticated-User", "X-Authenticated-Groups",
+	"X-Server-IP"}
+
+// the headers which the upstream services put the name of the threat in, ex: X-Infection-Found: Type=0;
+// Resolution=2; Threat=Eicar-Signature;
+var threatHeaders = []string{"X-Infection-Found", "X-Virus-ID", "X-Violations-Found"}
+
+// the cached OPTIONS responses of the upstream services by URL
+var (
+	optionsMu    sync.Mutex
+	optionsCache = make(map[string]cachedOptions)
+)
+
+type cachedOptions struct {
+	options   *icapclient.Options
+	expiresAt time.Time
+}
+
+// Processing is a func used for to processing the http message
+func (r *RemoteICAP) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int, interface{}, map[string]string, map[string]interface{},
+	map[string]interface{}, map[string]interface{}) {
+	serviceHeaders := make(map[string]string)
+	serviceHeaders["X-ICAP-Metadata"] = r.xICAPMetadata
+	logging.Logger.Info(utils.PrepareLogMsg(r.xICAPMetadata, r.serviceName+" service has started processing"))
+	msgHeadersBeforeProcessing := r.generalFunc.LogHTTPMsgHeaders(r.methodName)
+	msgHeadersAfterProcessing := make(map[string]interface{})
+	vendorMsgs := make(map[string]interface{})
+	r.IcapHeaders = IcapHeader
+	ExceptionPagePath := utils.BlockPagePath
+	if r.ExceptionPage != "" {
+		ExceptionPagePath = r.ExceptionPage
+	}
+	// the bypassed and rejected files are answered here without forwarding them, the type of the file is
+	// sniffed from the spooled body whether it's the preview or the whole body
+	icapStatus, httpMsg := r.generalFunc.PreviewDecision(r.extArrs, r.processExts, r.rejectExts, r.bypassExts,
+		r.return400IfFileExtRejected, r.serviceName, r.methodName, ExceptionPagePath)
+	if partial {
+		logging.Logger.Info(utils.PrepareLogMsg(r.xICAPMetadata,
+			r.serviceName+" service has stopped processing partially"))
+		if icapStatus != utils.Continue {
+			msgHeadersAfterProcessing = r.generalFunc.LogHTTPMsgHeaders(r.methodName)
+			return icapStatus, httpMsg, serviceHeaders,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
+		return utils.Continue, nil, nil,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+	body := r.spooledBody()
+	if icapStatus == utils.NoModificationStatusCodeStr {
+		logging.Logger.Info(utils.PrepareLogMsg(r.xICAPMetadata, r.serviceName+" service has stopped processing"))
+		return utils.NoModificationStatusCodeStr, r.original(body), serviceHeaders,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+	if icapStatus != utils.Continue {
+		logging.Logger.Info(utils.PrepareLogMsg(r.xICAPMetadata, r.serviceName+" service has stopped processing"))
+		msgHeadersAfterProcessing = r.generalFunc.LogHTTPMsgHeaders(r.methodName)
+		return icapStatus, httpMsg, serviceHeaders,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+
+	//check if the file size is greater than max file size of the service
+	//if yes we will return 200 ok or 204 no modification, it depends on the configuration of the service
+	if body != nil && r.maxFileSize != 0 && int64(r.maxFileSize) < body.Size() {
+		if r.returnOrigIfMaxSizeExc {
+			logging.Logger.Info(utils.PrepareLogMsg(r.xICAPMetadata, r.serviceName+" service has stopped processing"))
+			return utils.NoModificationStatusCodeStr, r.original(body), nil,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
+		status, page, httpMsg := r.generalFunc.IfMaxFileSizeExc(false, r.serviceName, r.methodName, nil,
+			r.maxFileSize, ExceptionPagePath, fmt.Sprintf("%v", body.Size()))
+		if status == utils.OkStatusCodeStr {
+			switch msg := httpMsg.(type) {
+			case *http.Request:
+				msg.Body = io.NopCloser(page)
+			case *http.Response:
+				msg.Body = icap.NewBody(page.Bytes())
+			}
+		}
+		logging.Logger.Info(utils.PrepareLogMsg(r.xICAPMetadata, r.serviceName+" service has stopped processing"))
+		msgHeadersAfterProcessing = r.generalFunc.LogHTTPMsgHeaders(r.methodName)
+		return status, httpMsg, nil,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+
+	resp, err := r.forward(body)
+	if err != nil {
+		vendorMsgs[utils.VendorMsgError] = err.Error()
+		logging.Logger.Error(utils.PrepareLogMsg(r.xICAPMetadata, r.serviceName+" error: "+err.Error()))
+		logging.Logger.Info(utils.PrepareLogMsg(r.xICAPMetadata, r.serviceName+" service has stopped processing"))
+		if r.BypassOnApiError {
+			return utils.NoModificationStatusCodeStr, r.original(body), serviceHeaders,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return utils.RequestTimeOutStatusCodeStr, nil, nil,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
+		return utils.InternalServerErrStatusCodeStr, nil, nil,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+
+	if resp.StatusCode == http.StatusNoContent {
+		logging.Logger.Debug(utils.PrepareLogMsg(r.xICAPMetadata, r.serviceName+": the upstream service didn't modify the message"))
+		logging.Logger.Info(utils.PrepareLogMsg(r.xICAPMetadata, r.serviceName+" service has stopped processing"))
+		return utils.NoModificationStatusCodeStr, r.original(body), serviceHeaders,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+
+	// the upstream service modified the message or answered the request with its own response (ex: a block page),
+	// the modified message is relayed as it is
+	var result interface{}
+	if resp.ContentResponse != nil {
+		resp.ContentResponse.Body, err = readUpstreamBody(resp.ContentResponse.Body)
+		result = resp.ContentResponse
+	} else if resp.ContentRequest != nil {
+		resp.ContentRequest.Body, err = readUpstreamBody(resp.ContentRequest.Body)
+		result = resp.ContentRequest
+	}
+	if err != nil || result == nil {
+		if err == nil {
+			err = errors.New("the upstream service returned 200 without an HTTP message")
+		}
+		vendorMsgs[utils.VendorMsgError] = err.Error()
+		logging.Logger.Error(utils.PrepareLogMsg(r.xICAPMetadata, r.serviceName+" error: "+err.Error()))
+		logging.Logger.Info(utils.PrepareLogMsg(r.xICAPMetadata, r.serviceName+" service has stopped processing"))
+		return utils.InternalServerErrStatusCodeStr, nil, nil,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+	if threat, found := threatOf(resp.Header); found {
+		logging.Logger.Debug(utils.PrepareLogMsg(r.xICAPMetadata, r.serviceName+": file is not safe, "+threat))
+		vendorMsgs[utils.VendorMsgVerdict] = utils.SampleSeverityMalicious
+		vendorMsgs[utils.VendorMsgThreat] = threat
+		vendorMsgs[utils.VendorMsgFileName] = r.generalFunc.GetFileName()
+		// the headers of the upstream service replace the ones of the helper below
+		services_utilities.AddThreatHeaders(serviceHeaders, services_utilities.Violation{
+			FileName: vendorMsgs[utils.VendorMsgFileName].(string), Threat: threat,
+			Type: services_utilities.ThreatTypeInfection, Resolution: services_utilities.ResolutionBlocked})
+	} else if _, blocked := result.(*http.Response); blocked && r.methodName == utils.ICAPModeReq {
+		vendorMsgs[utils.VendorMsgVerdict] = utils.SampleSeverityMalicious
+	}
+	for _, name := range append(threatHeaders, services_utilities.ResponseInfoHeader,
+		services_utilities.ResponseDescHeader) {
+		if value := resp.Header.Get(name); value != "" {
+			serviceHeaders[name] = value
+		}
+	}
+	logging.Logger.Info(utils.PrepareLogMsg(r.xICAPMetadata, r.serviceName+" service has stopped processing"))
+	switch msg := result.(type) {
+	case *http.Request:
+		r.httpMsg.Request = msg
+		msgHeadersAfterProcessing = r.generalFunc.LogHTTPMsgHeaders(utils.ICAPModeReq)
+	case *http.Response:
+		r.httpMsg.Response = msg
+		msgHeadersAfterProcessing = r.generalFunc.LogHTTPMsgHeaders(utils.ICAPModeResp)
+	}
+	return utils.OkStatusCodeStr, result, serviceHeaders,
+		msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+}
+
+// spooledBody returns the spooled body of the HTTP message, nil if the message has no body
+func (r *RemoteICAP) spooledBody() *spool.Body {
+	var body io.Reader
+	if r.methodName == utils.ICAPModeReq {
+		body = r.httpMsg.Request.Body
+	} else if r.httpMsg.Response != nil {
+		body = r.httpMsg.Response.Body
+	}
+	if spooled, isSpooled := spool.Of(body); isSpooled {
+		return spooled
+	}
+	return nil
+}
+
+// original returns the HTTP message with its original body for the 204 responses
+func (r *RemoteICAP) original(body *spool.Body) interface{} {
+	if r.methodName == utils.ICAPModeReq {
+		if body != nil {
+			r.httpMsg.Request.Body = body.Open()
+		}
+		return r.httpMsg.Request
+	}
+	if body != nil {
+		return r.generalFunc.ReturningHttpMessageWithSpool(body)
+	}
+	return r.httpMsg.Response
+}
+
+// forward sends the HTTP message to the upstream service with a preview and Allow: 204, the body is read from
+// the spool again when the call is retried
+func (r *RemoteICAP) forward(body *spool.Body) (*icapclient.Response, error) {
+	client := &icapclient.Client{
+		Timeout:   r.Timeout,
+		TLSConfig: &tls.Config{InsecureSkipVerify: !r.verifyServerCert},
+	}
+	previewBytes := r.PreviewBytes
+	options, err := r.options(client)
+	if err != nil {
+		logging.Logger.Warn(utils.PrepareLogMsg(r.xICAPMetadata,
+			r.serviceName+" couldn't get the OPTIONS of the upstream service: "+err.Error()))
+	} else {
+		if !options.Supports(r.methodName) {
+			return nil, errors.New("the upstream service doesn't support " + r.methodName)
+		}
+		if previewBytes < 0 {
+			previewBytes = options.Preview
+		}
+	}
+	var resp *icapclient.Response
+	err = retry.Do(r.serviceName, func() (err error) {
+		spanCtx, span := tracing.StartVendorCall(r.xICAPMetadata, r.methodName+" upstream",
+			attribute.String("icap.upstream_url", r.UpstreamURL))
+		defer func() {
+			if resp != nil {
+				span.SetAttributes(attribute.Int("icap.status_code", resp.StatusCode))
+			}
+			tracing.End(span, err)
+		}()
+		ctx, cancel := r.context(spanCtx)
+		req, err := r.newRequest(ctx, body)
+		if err != nil {
+			cancel()
+			return err
+		}
+		if previewBytes >= 0 && body != nil {
+			req.SetPreview(previewBytes)
+		}
+		resp, err = client.Do(req)
+		if err != nil {
+			cancel()
+			return err
+		}
+		switch resp.StatusCode {
+		case http.StatusNoContent:
+			cancel()
+			return nil
+		case http.StatusOK, http.StatusPartialContent:
+			if resp.ContentResponse != nil && resp.ContentResponse.Body != nil {
+				resp.ContentResponse.Body = &cancelBody{ReadCloser: resp.ContentResponse.Body, cancel: cancel}
+			} else if resp.ContentRequest != nil && resp.ContentRequest.Body != nil {
+				resp.ContentRequest.Body = &cancelBody{ReadCloser: resp.ContentRequest.Body, cancel: cancel}
+			} else {
+				cancel()
+			}
+			return nil
+		}
+		cancel()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return &retry.StatusError{StatusCode: resp.StatusCode}
+		}
+		return errors.New("the upstream service responded with " + strconv.Itoa(resp.StatusCode) + " " + resp.Status)
+	})
+	return resp, err
+}
+
+// context returns the context of a call to the upstream service, the calls of a service without a timeout
+// aren't limited
+func (r *RemoteICAP) context(parent context.Context) (context.Context, context.CancelFunc) {
+	if r.Timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, r.Timeout)
+}
+
+// newRequest builds the ICAP request of the HTTP message with new readers of its spooled body
+func (r *RemoteICAP) newRequest(ctx context.Context, body *spool.Body) (*icapclient.Request, error) {
+	var httpReq *http.Request
+	var httpResp *http.Response
+	if r.httpMsg.Request != nil {
+		reqCopy := *r.httpMsg.Request
+		reqCopy.Body = http.NoBody
+		if r.methodName == utils.ICAPModeReq && body != nil {
+			reqCopy.Body = body.Open()
+		}
+		httpReq = &reqCopy
+	}
+	if r.methodName == utils.ICAPModeResp {
+		respCopy := *r.httpMsg.Response
+		respCopy.Body = http.NoBody
+		if body != nil {
+			respCopy.Body = body.Open()
+		}
+		httpResp = &respCopy
+	}
+	req, err := icapclient.NewRequestWithContext(ctx, r.methodName, r.UpstreamURL, httpReq, httpResp)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.GetBody = func() (io.ReadCloser, error) { return body.Open(), nil }
+	}
+	for _, name := range forwardedHeaders {
+		if value := r.IcapHeaders.Get(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	// the upstream service continues the trace of the transaction if it traces its requests
+	tracing.Inject(ctx, req.Header)
+	return req, nil
+}
+
+// options returns the OPTIONS response of the upstream service, it's cached for its Options-TTL
+func (r *RemoteICAP) options(client *icapclient.Client) (*icapclient.Options, error) {
+	optionsMu.Lock()
+	cached, exists := optionsCache[r.UpstreamURL]
+	optionsMu.Unlock()
+	if exists && time.Now().Before(cached.expiresAt) {
+		return cached.options, nil
+	}
+	ctx, cancel := r.context(context.Background())
+	defer cancel()
+	options, err := client.Options(ctx, r.UpstreamURL)
+	if err != nil {
+		return nil, err
+	}
+	ttl := options.TTL
+	if ttl <= 0 {
+		ttl = defaultOptionsTTL
+	}
+	optionsMu.Lock()
+	optionsCache[r.UpstreamURL] = cachedOptions{options: options, expiresAt: time.Now().Add(ttl)}
+	optionsMu.Unlock()
+	return options, nil
+}
+
+// readUpstreamBody reads the body of the modified message from the connection to the upstream service and
+// closes it, so the connection doesn't outlive the transaction
+func readUpstreamBody(body io.ReadCloser) (io.ReadCloser, error) {
+	if body == nil || body == http.NoBody {
+		return icap.NewBody(nil), nil
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return icap.NewBody(data), nil
+}
+
+// threatOf returns the name of the threat which the upstream service found, ex: Threat=Eicar-Signature of
+// X-Infection-Found
+func threatOf(header http.Header) (string, bool) {
+	for _, name := range threatHeaders {
+		value := header.Get(name)
+		if value == "" {
+			continue
+		}
+		for _, field := range strings.Split(value, ";") {
+			if key, threat, found := strings.Cut(strings.TrimSpace(field), "="); found &&
+				strings.EqualFold(key, "Threat") {
+				return threat, true
+			}
+		}
+		return value, true
+	}
+	return "", false
+}
+
+// cancelBody cancels the context of the ICAP call when the body of the modified message is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// ISTagValue returns the ISTag of the upstream service, so the caches of the ICAP clients are invalidated when
+// it changes
+func (r *RemoteICAP) ISTagValue() string {
+	optionsMu.Lock()
+	cached, exists := optionsCache[r.UpstreamURL]
+	optionsMu.Unlock()
+	if exists && cached.options.ISTag != "" {
+		return strings.Trim(cached.options.ISTag, "\"")
+	}
+	epochTime := strconv.FormatInt(time.Now().Unix(), 10)
+	return "epoch-" + epochTime
+}
+
+// SignatureVersion returns the ISTag of the upstream service, the ISTag of the service changes with it
+func (r *RemoteICAP) SignatureVersion() string {
+	optionsMu.Lock()
+	defer optionsMu.Unlock()
+	if cached, exists := optionsCache[r.UpstreamURL]; exists {
+		return strings.Trim(cached.options.ISTag, "\"")
+	}
+	return ""
+}
+
+// probeUpstream is the health probe of the upstream service of a service, it sends an OPTIONS request which
+// isn't cached so an upstream which went down is noticed
+func probeUpstream(serviceName string, timeout time.Duration) error {
+	keys := config.Service(serviceName)
+	if keys == nil {
+		return errors.New("the service isn't configured")
+	}
+	client := &icapclient.Client{
+		Timeout:   timeout,
+		TLSConfig: &tls.Config{InsecureSkipVerify: !keys.Bool("verify_server_cert")},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err := client.Options(ctx, keys.String("upstream_url"))
+	return err
+}
@@ -0,0 +1,24 @@
+package remoteicap
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestThreatOf(t *testing.T) {
+	tests := []struct {
+		header http.Header
+		threat string
+		found  bool
+	}{
+		{http.Header{"X-Infection-Found": {"Type=0; Resolution=2; Threat=Eicar-Test-Signature;"}}, "Eicar-Test-Signature", true},
+		{http.Header{"X-Virus-Id": {"Win.Trojan.Agent"}}, "Win.Trojan.Agent", true},
+		{http.Header{"Istag": {"\"UP-1\""}}, "", false},
+	}
+	for _, test := range tests {
+		threat, found := threatOf(test.header)
+		if threat != test.threat || found != test.found {
+			t.Errorf("threatOf(%v) = %q, %v, want %q, %v", test.header, threat, found, test.threat, test.found)
+		}
+	}
+}
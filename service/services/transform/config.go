@@ -0,0 +1,130 @@
+package transform
+
+import (
+	"icapeg/config"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	general_functions "icapeg/service/services-utilities/general-functions"
+	"os"
+	"sync"
+)
+
+// TransformVendor is the vendor of the services which rewrite the headers and the bodies of the HTTP responses
+const TransformVendor = "transform"
+
+// the media type of the bodies which are rewritten if the service has no content_types
+const defaultContentType = "text/html"
+
+// the keys of the services of the vendor in addition to the ones of every service
+func init() {
+	config.RegisterVendorKeys(TransformVendor, map[string]config.KeySpec{
+		"add_headers":    {Kind: config.SliceKey},
+		"remove_headers": {Kind: config.SliceKey},
+		"rewrite_urls":   {Kind: config.SliceKey},
+		"banner":         {Kind: config.StringKey},
+		"banner_file":    {Kind: config.StringKey},
+		"content_types":  {Kind: config.SliceKey},
+	})
+}
+
+var doOnce sync.Once
+var (
+	configMu sync.RWMutex
+	// the service whose section the configuration was read from, the services of the vendor share it
+	configService   string
+	transformConfig *Transform
+)
+
+// Transform represents the information regarding the transform service
+type Transform struct {
+	xICAPMetadata string
+	httpMsg       *http_message.HttpMsg
+	serviceName   string
+	methodName    string
+	maxFileSize   int
+	rules         *Rules
+	generalFunc   *general_functions.GeneralFunc
+}
+
+func InitTransformConfig(serviceName string) {
+	logging.Logger.Debug("loading " + serviceName + " service configurations")
+	doOnce.Do(func() {
+		configMu.Lock()
+		transformConfig = readTransformConfig(serviceName)
+		configService = serviceName
+		configMu.Unlock()
+	})
+}
+
+// readTransformConfig reads the configuration of the service and parses its rules, the rules which can't be
+// parsed are logged and left out. The banner of banner_file replaces the banner key
+func readTransformConfig(serviceName string) *Transform {
+	keys := config.Service(serviceName)
+	rules := &Rules{RemoveHeaders: keys.Slice("remove_headers"), Banner: []byte(keys.String("banner")),
+		ContentTypes: keys.Slice("content_types")}
+	for _, entry := range keys.Slice("add_headers") {
+		name, value, err := ParseHeader(entry)
+		if err != nil {
+			logging.Logger.Error(serviceName + " service: " + err.Error() + ", it's left out")
+			continue
+		}
+		rules.AddHeaders = append(rules.AddHeaders, [2]string{name, value})
+	}
+	for _, entry := range keys.Slice("rewrite_urls") {
+		from, to, err := ParseURLRewrite(entry)
+		if err != nil {
+			logging.Logger.Error(serviceName + " service: " + err.Error() + ", it's left out")
+			continue
+		}
+		rules.URLRewrites = append(rules.URLRewrites, [2]string{from, to})
+	}
+	if bannerFile := keys.String("banner_file"); bannerFile != "" {
+		banner, err := os.ReadFile(bannerFile)
+		if err != nil {
+			logging.Logger.Error(serviceName + " service: couldn't read the banner file: " + err.Error())
+		} else {
+			rules.Banner = banner
+		}
+	}
+	if len(rules.ContentTypes) == 0 {
+		rules.ContentTypes = []string{defaultContentType}
+	}
+	return &Transform{maxFileSize: keys.Int("max_filesize"), rules: rules}
+}
+
+// ReloadTransformConfig reads the configuration of the service which loaded it again, it returns the func which
+// makes it the configuration of the next requests, nil if it wasn't loaded yet or its section was removed
+func ReloadTransformConfig() func() {
+	configMu.RLock()
+	serviceName := configService
+	configMu.RUnlock()
+	if serviceName == "" || config.Service(serviceName) == nil {
+		return nil
+	}
+	cfg := readTransformConfig(serviceName)
+	return func() {
+		configMu.Lock()
+		defer configMu.Unlock()
+		transformConfig = cfg
+	}
+}
+
+func currentConfig() *Transform {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return transformConfig
+}
+
+// NewTransformService returns a new populated instance of the transform service
+func NewTransformService(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) *Transform {
+	cfg := currentConfig()
+	return &Transform{
+		xICAPMetadata: xICAPMetadata,
+		httpMsg:       httpMsg,
+		serviceName:   serviceName,
+		methodName:    methodName,
+		generalFunc:   general_functions.NewGeneralFunc(httpMsg, xICAPMetadata),
+		maxFileSize:   cfg.maxFileSize,
+		rules:         cfg.rules,
+	}
+}
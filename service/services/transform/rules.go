@@ -0,0 +1,139 @@
+package transform
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// the transformations of the vendor messages
+const (
+	transformAddHeaders    = "add_headers"
+	transformRemoveHeaders = "remove_headers"
+	transformRewriteURLs   = "rewrite_urls"
+	transformBanner        = "banner"
+)
+
+// the separator of the original and the new URL of a rewrite_urls entry
+const urlRewriteSeparator = "->"
+
+// Rules are the transformations of the HTTP responses of a service
+type Rules struct {
+	AddHeaders    [][2]string // the canonical header names and their values, they replace the existing values
+	RemoveHeaders []string
+	URLRewrites   [][2]string // the URL prefixes and their replacements, in the order of the configuration
+	Banner        []byte
+	ContentTypes  []string // the media types of the bodies which are rewritten
+}
+
+// ParseHeader parses an add_headers entry, ex: "X-Frame-Options: DENY"
+func ParseHeader(entry string) (string, string, error) {
+	name, value, found := strings.Cut(entry, ":")
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	if !found || name == "" {
+		return "", "", errors.New("the header " + entry + " isn't <name>: <value>")
+	}
+	return textproto.CanonicalMIMEHeaderKey(name), value, nil
+}
+
+// ParseURLRewrite parses a rewrite_urls entry, ex: "http://intranet.local/ -> https://intranet.example.com/"
+func ParseURLRewrite(entry string) (string, string, error) {
+	from, to, found := strings.Cut(entry, urlRewriteSeparator)
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if !found || from == "" {
+		return "", "", errors.New("the URL rewrite " + entry + " isn't <url> " + urlRewriteSeparator + " <url>")
+	}
+	return from, to, nil
+}
+
+// TransformHeader removes and adds the headers of the rules, it returns the transformations which changed it
+func (r *Rules) TransformHeader(header http.Header) []string {
+	var applied []string
+	removed := false
+	for _, name := range r.RemoveHeaders {
+		if _, exists := header[textproto.CanonicalMIMEHeaderKey(name)]; exists {
+			header.Del(name)
+			removed = true
+		}
+	}
+	if removed {
+		applied = append(applied, transformRemoveHeaders)
+	}
+	added := false
+	for _, h := range r.AddHeaders {
+		if values := header.Values(h[0]); len(values) != 1 || values[0] != h[1] {
+			header.Set(h[0], h[1])
+			added = true
+		}
+	}
+	if added {
+		applied = append(applied, transformAddHeaders)
+	}
+	return applied
+}
+
+// RewritesBody reports whether the body of the content type is rewritten
+func (r *Rules) RewritesBody(contentType string) bool {
+	if len(r.URLRewrites) == 0 && len(r.Banner) == 0 {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range r.ContentTypes {
+		if strings.EqualFold(t, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// TransformBody rewrites the URLs of the body and inserts the banner after its <body> tag, or at its start if
+// it has none. It returns the new body and the transformations which changed it
+func (r *Rules) TransformBody(body []byte) ([]byte, []string) {
+	var applied []string
+	rewritten := body
+	for _, rewrite := range r.URLRewrites {
+		if bytes.Contains(rewritten, []byte(rewrite[0])) {
+			rewritten = bytes.ReplaceAll(rewritten, []byte(rewrite[0]), []byte(rewrite[1]))
+			if len(applied) == 0 {
+				applied = append(applied, transformRewriteURLs)
+			}
+		}
+	}
+	if len(r.Banner) != 0 {
+		at := bodyContentStart(rewritten)
+		withBanner := make([]byte, 0, len(rewritten)+len(r.Banner))
+		withBanner = append(withBanner, rewritten[:at]...)
+		withBanner = append(withBanner, r.Banner...)
+		rewritten = append(withBanner, rewritten[at:]...)
+		applied = append(applied, transformBanner)
+	}
+	return rewritten, applied
+}
+
+// bodyContentStart returns the offset right after the opening <body> tag of the HTML document, 0 if it has none
+func bodyContentStart(html []byte) int {
+	lower := bytes.ToLower(html)
+	for offset := 0; ; {
+		i := bytes.Index(lower[offset:], []byte("<body"))
+		if i < 0 {
+			return 0
+		}
+		i += offset
+		next := i + len("<body")
+		// ex: <bodyguard> isn't the body tag
+		if next < len(lower) && (lower[next] == '>' || lower[next] == ' ' || lower[next] == '\t' ||
+			lower[next] == '\n' || lower[next] == '\r' || lower[next] == '/') {
+			if end := bytes.IndexByte(lower[next:], '>'); end >= 0 {
+				return next + end + 1
+			}
+			return 0
+		}
+		offset = next
+	}
+}
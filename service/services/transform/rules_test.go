@@ -0,0 +1,48 @@
+package transform
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestTransformHeader(t *testing.T) {
+	name, value, err := ParseHeader("content-security-policy: default-src 'self'")
+	if err != nil || name != "Content-Security-Policy" || value != "default-src 'self'" {
+		t.Fatalf("unexpected header %q %q %v", name, value, err)
+	}
+	if _, _, err = ParseHeader("X-Frame-Options"); err == nil {
+		t.Fatal("a header without a value should be rejected")
+	}
+	rules := &Rules{AddHeaders: [][2]string{{name, value}}, RemoveHeaders: []string{"server", "X-Powered-By"}}
+	header := http.Header{"Server": {"nginx/1.18"}, "Content-Type": {"text/html"}}
+	if applied := rules.TransformHeader(header); !reflect.DeepEqual(applied, []string{transformRemoveHeaders, transformAddHeaders}) {
+		t.Fatalf("unexpected transformations %v", applied)
+	}
+	if header.Get("Server") != "" || header.Get("Content-Security-Policy") != "default-src 'self'" {
+		t.Fatalf("unexpected headers %v", header)
+	}
+	if applied := rules.TransformHeader(header); len(applied) != 0 {
+		t.Fatalf("the transformed headers shouldn't change again, got %v", applied)
+	}
+}
+
+func TestTransformBody(t *testing.T) {
+	from, to, err := ParseURLRewrite("http://intranet.local/ -> https://intranet.example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := &Rules{URLRewrites: [][2]string{{from, to}}, Banner: []byte(`<div class="banner">Scanned</div>`),
+		ContentTypes: []string{"text/html"}}
+	if !rules.RewritesBody("text/html; charset=utf-8") || rules.RewritesBody("application/json") {
+		t.Fatal("only the HTML bodies should be rewritten")
+	}
+	body, applied := rules.TransformBody([]byte(`<html><BODY class="x"><a href="http://intranet.local/a">a</a></body></html>`))
+	expected := `<html><BODY class="x"><div class="banner">Scanned</div><a href="https://intranet.example.com/a">a</a></body></html>`
+	if string(body) != expected || !reflect.DeepEqual(applied, []string{transformRewriteURLs, transformBanner}) {
+		t.Fatalf("unexpected body %q with %v", body, applied)
+	}
+	if body, _ = rules.TransformBody([]byte("<bodyguard>text")); string(body) != `<div class="banner">Scanned</div><bodyguard>text` {
+		t.Fatalf("the banner should be inserted at the start of a document without a body tag, got %q", body)
+	}
+}
@@ -0,0 +1,114 @@
+package transform
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	utils "icapeg/consts"
+	"icapeg/logging"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+// the vendor message of the transformations which changed the HTTP response
+const vendorMsgTransformations = "transformations"
+
+// Processing is a func used for to processing the http message, the headers of the HTTP response are removed
+// and added and its body is rewritten upon the rules of the service. The HTTP requests are returned as they are
+func (t *Transform) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int, interface{}, map[string]string, map[string]interface{},
+	map[string]interface{}, map[string]interface{}) {
+	serviceHeaders := make(map[string]string)
+	serviceHeaders["X-ICAP-Metadata"] = t.xICAPMetadata
+	logging.Logger.Info(utils.PrepareLogMsg(t.xICAPMetadata, t.serviceName+" service has started processing"))
+	msgHeadersBeforeProcessing := t.generalFunc.LogHTTPMsgHeaders(t.methodName)
+	msgHeadersAfterProcessing := make(map[string]interface{})
+	vendorMsgs := make(map[string]interface{})
+
+	// the whole body is needed to rewrite it
+	if partial {
+		logging.Logger.Info(utils.PrepareLogMsg(t.xICAPMetadata,
+			t.serviceName+" service has stopped processing partially"))
+		return utils.Continue, nil, nil, msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+	resp := t.httpMsg.Response
+	if t.methodName != utils.ICAPModeResp || resp == nil {
+		logging.Logger.Info(utils.PrepareLogMsg(t.xICAPMetadata, t.serviceName+" service has stopped processing, "+
+			"it transforms the HTTP responses only"))
+		return utils.NoModificationStatusCodeStr, t.httpMsg.Request, serviceHeaders,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		logging.Logger.Info(utils.PrepareLogMsg(t.xICAPMetadata, t.serviceName+" service has stopped processing byte range received"))
+		return utils.NoModificationStatusCodeStr, resp, serviceHeaders,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+
+	var raw []byte
+	if resp.Body != nil {
+		var err error
+		if raw, err = io.ReadAll(resp.Body); err != nil {
+			logging.Logger.Error(utils.PrepareLogMsg(t.xICAPMetadata, t.serviceName+" error: "+err.Error()))
+			logging.Logger.Info(utils.PrepareLogMsg(t.xICAPMetadata, t.serviceName+" service has stopped processing"))
+			return utils.InternalServerErrStatusCodeStr, nil, serviceHeaders,
+				msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+		}
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+
+	applied := t.rules.TransformHeader(resp.Header)
+	body := raw
+	if len(raw) != 0 && t.rules.RewritesBody(resp.Header.Get(utils.ContentType)) {
+		decoded, err := decodeBody(raw, resp.Header)
+		switch {
+		case err != nil:
+			logging.Logger.Debug(utils.PrepareLogMsg(t.xICAPMetadata, t.serviceName+
+				" doesn't rewrite the body, couldn't decode it: "+err.Error()))
+		case t.maxFileSize != 0 && t.maxFileSize < len(decoded):
+			logging.Logger.Debug(utils.PrepareLogMsg(t.xICAPMetadata, t.serviceName+
+				" doesn't rewrite the body, it exceeds the max file size"))
+		default:
+			// a rewritten gzip body is sent decompressed, the ICAP response writer drops its Content-Encoding
+			if rewritten, bodyApplied := t.rules.TransformBody(decoded); len(bodyApplied) != 0 {
+				body = rewritten
+				applied = append(applied, bodyApplied...)
+			}
+		}
+	}
+	if len(applied) == 0 {
+		logging.Logger.Info(utils.PrepareLogMsg(t.xICAPMetadata, t.serviceName+" service has stopped processing"))
+		msgHeadersAfterProcessing = t.generalFunc.LogHTTPMsgHeaders(t.methodName)
+		return utils.NoModificationStatusCodeStr, resp, serviceHeaders,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+
+	// the ICAP response writer sets the Content-Length of the new body and drops the Transfer-Encoding
+	vendorMsgs[vendorMsgTransformations] = applied
+	logging.Logger.Info(utils.PrepareLogMsg(t.xICAPMetadata, t.serviceName+" service has stopped processing"))
+	msgHeadersAfterProcessing = t.generalFunc.LogHTTPMsgHeaders(t.methodName)
+	return utils.OkStatusCodeStr, t.generalFunc.ReturningHttpMessageWithFile(t.methodName, body), serviceHeaders,
+		msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+}
+
+// decodeBody returns the body without its gzip Content-Encoding, the other encodings can't be rewritten
+func decodeBody(body []byte, header http.Header) ([]byte, error) {
+	switch header.Get("Content-Encoding") {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	}
+	return nil, errors.New("the " + header.Get("Content-Encoding") + " Content-Encoding isn't supported")
+}
+
+func (t *Transform) ISTagValue() string {
+	epochTime := strconv.FormatInt(time.Now().Unix(), 10)
+	return "epoch-" + epochTime
+}
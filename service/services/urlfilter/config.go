@@ -0,0 +1,210 @@
+package urlfilter
+
+import (
+	"icapeg/config"
+	"icapeg/feeds"
+	http_message "icapeg/http-message"
+	"icapeg/logging"
+	general_functions "icapeg/service/services-utilities/general-functions"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// URLFilterVendor is the vendor of the services which block the HTTP requests to the hosts and the URLs of
+// their local blocklists
+const URLFilterVendor = "url_filter"
+
+// FeedTypeCategories is the type of the feeds of [app.feeds] which download the category file of the services
+// of the vendor, the services read the new file as soon as it's verified
+const FeedTypeCategories = "url_categories"
+
+// the keys of the services of the vendor in addition to the ones of every service
+func init() {
+	config.RegisterVendorKeys(URLFilterVendor, map[string]config.KeySpec{
+		"block_hosts":        {Kind: config.SliceKey},
+		"hosts_files":        {Kind: config.SliceKey},
+		"url_patterns":       {Kind: config.SliceKey},
+		"url_patterns_file":  {Kind: config.StringKey},
+		"categories_file":    {Kind: config.StringKey},
+		"blocked_categories": {Kind: config.SliceKey},
+		"allow_hosts":        {Kind: config.SliceKey},
+	})
+	feeds.RegisterReloader(FeedTypeCategories, reloadCategories)
+}
+
+var doOnce sync.Once
+var (
+	configMu sync.RWMutex
+	// the service whose section the configuration was read from, the services of the vendor share it
+	configService   string
+	urlFilterConfig *URLFilter
+)
+
+// URLFilter represents the information regarding the URL filter service
+type URLFilter struct {
+	xICAPMetadata  string
+	httpMsg        *http_message.HttpMsg
+	serviceName    string
+	methodName     string
+	lists          *Lists
+	categoriesFile string
+	generalFunc    *general_functions.GeneralFunc
+}
+
+func InitURLFilterConfig(serviceName string) {
+	logging.Logger.Debug("loading " + serviceName + " service configurations")
+	doOnce.Do(func() {
+		configMu.Lock()
+		urlFilterConfig = readURLFilterConfig(serviceName)
+		configService = serviceName
+		configMu.Unlock()
+		general_functions.InitBlockPageLanguage(serviceName)
+	})
+}
+
+// readURLFilterConfig reads the configuration of the service and its list files, the files which can't be read
+// and the patterns which can't be compiled are logged and left out
+func readURLFilterConfig(serviceName string) *URLFilter {
+	keys := config.Service(serviceName)
+	lists := NewLists()
+	for _, host := range keys.Slice("allow_hosts") {
+		if host = NormalizeHost(host); host != "" {
+			lists.AllowHosts[host] = ListAllowHosts
+		}
+	}
+	for _, host := range keys.Slice("block_hosts") {
+		if host = NormalizeHost(host); host != "" {
+			lists.BlockHosts[host] = ListBlockHosts
+		}
+	}
+	for _, path := range keys.Slice("hosts_files") {
+		file, err := os.Open(path)
+		if err != nil {
+			logging.Logger.Error(serviceName + " service: couldn't read the hosts file, it's left out: " + err.Error())
+			continue
+		}
+		hosts, err := ParseHosts(file)
+		file.Close()
+		if err != nil {
+			logging.Logger.Error(serviceName + " service: couldn't read the hosts file " + path + ": " + err.Error())
+		}
+		for _, host := range hosts {
+			if _, listed := lists.BlockHosts[host]; !listed {
+				lists.BlockHosts[host] = path
+			}
+		}
+	}
+	patterns := keys.Slice("url_patterns")
+	if patternsFile := keys.String("url_patterns_file"); patternsFile != "" {
+		if content, err := os.ReadFile(patternsFile); err != nil {
+			logging.Logger.Error(serviceName + " service: couldn't read the URL patterns file, it's left out: " +
+				err.Error())
+		} else {
+			patterns = append(patterns, strings.Split(string(content), "\n")...)
+		}
+	}
+	for _, pattern := range patterns {
+		// a pattern a line in the file, the comments start with #
+		if pattern = strings.TrimSpace(pattern); pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			logging.Logger.Error(serviceName + " service: invalid URL pattern " + pattern + ", it's left out: " +
+				err.Error())
+			continue
+		}
+		lists.URLPatterns = append(lists.URLPatterns, compiled)
+	}
+	for _, category := range keys.Slice("blocked_categories") {
+		if category = strings.ToLower(strings.TrimSpace(category)); category != "" {
+			lists.BlockedCategories[category] = true
+		}
+	}
+	cfg := &URLFilter{lists: lists, categoriesFile: keys.String("categories_file")}
+	if cfg.categoriesFile != "" {
+		categories, err := readCategories(cfg.categoriesFile)
+		if err != nil {
+			// the file of a feed may not be downloaded yet, the feed loads it once it is
+			logging.Logger.Error(serviceName + " service: couldn't read the categories file: " + err.Error())
+		} else {
+			lists.Categories = categories
+		}
+	}
+	logging.Logger.Debug(serviceName + " service: " + strconv.Itoa(len(lists.BlockHosts)) + " blocked hosts, " +
+		strconv.Itoa(len(lists.URLPatterns)) + " URL patterns and " + strconv.Itoa(len(lists.Categories)) +
+		" categorized hosts")
+	return cfg
+}
+
+func readCategories(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseCategories(file)
+}
+
+// reloadCategories is the reloader of the url_categories feeds, the categories of the service are replaced if
+// the feed downloads its categories_file. A file which can't be parsed keeps the previous one
+func reloadCategories(_ feeds.Config, path string) error {
+	categories, err := readCategories(path)
+	if err != nil {
+		return err
+	}
+	configMu.Lock()
+	defer configMu.Unlock()
+	if urlFilterConfig == nil || urlFilterConfig.categoriesFile != path {
+		return nil
+	}
+	lists := *urlFilterConfig.lists
+	lists.Categories = categories
+	cfg := *urlFilterConfig
+	cfg.lists = &lists
+	urlFilterConfig = &cfg
+	logging.Logger.Info(configService + " service: " + strconv.Itoa(len(categories)) +
+		" categorized hosts were loaded from " + path)
+	return nil
+}
+
+// ReloadURLFilterConfig reads the configuration of the service which loaded it again with its list files, it
+// returns the func which makes it the configuration of the next requests, nil if it wasn't loaded yet or its
+// section was removed
+func ReloadURLFilterConfig() func() {
+	configMu.RLock()
+	serviceName := configService
+	configMu.RUnlock()
+	if serviceName == "" || config.Service(serviceName) == nil {
+		return nil
+	}
+	cfg := readURLFilterConfig(serviceName)
+	return func() {
+		configMu.Lock()
+		defer configMu.Unlock()
+		urlFilterConfig = cfg
+	}
+}
+
+func currentConfig() *URLFilter {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return urlFilterConfig
+}
+
+// NewURLFilterService returns a new populated instance of the URL filter service
+func NewURLFilterService(serviceName, methodName string, httpMsg *http_message.HttpMsg, xICAPMetadata string) *URLFilter {
+	cfg := currentConfig()
+	return &URLFilter{
+		xICAPMetadata:  xICAPMetadata,
+		httpMsg:        httpMsg,
+		serviceName:    serviceName,
+		methodName:     methodName,
+		generalFunc:    general_functions.NewGeneralFunc(httpMsg, xICAPMetadata),
+		lists:          cfg.lists,
+		categoriesFile: cfg.categoriesFile,
+	}
+}
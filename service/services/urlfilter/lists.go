@@ -0,0 +1,141 @@
+package urlfilter
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// the names of the lists of a match which aren't files
+const (
+	ListAllowHosts  = "allow_hosts"
+	ListBlockHosts  = "block_hosts"
+	ListURLPatterns = "url_patterns"
+	ListCategories  = "categories"
+)
+
+// Lists are the blocklists of a service. A listed domain blocks its subdomains too, and the allowed hosts are
+// never blocked whatever the lists say. The lists aren't changed once they're built, a new configuration or
+// a new category feed builds new ones
+type Lists struct {
+	AllowHosts        map[string]string // the allowed domains with the list which allows them
+	BlockHosts        map[string]string // the blocked domains with the list which blocks them
+	URLPatterns       []*regexp.Regexp
+	Categories        map[string]string // the domains of the category feed with their category
+	BlockedCategories map[string]bool
+}
+
+// Match is the reason why a URL is blocked
+type Match struct {
+	List     string // the file of the hosts, block_hosts, url_patterns or categories
+	Rule     string // the domain or the pattern which matched
+	Category string // the category of the domain, categories only
+}
+
+// NewLists returns empty lists
+func NewLists() *Lists {
+	return &Lists{AllowHosts: make(map[string]string), BlockHosts: make(map[string]string),
+		Categories: make(map[string]string), BlockedCategories: make(map[string]bool)}
+}
+
+// Check returns the first match of the host and of the URL in the lists: the blocked hosts, the URL patterns and
+// then the blocked categories. It returns false if none of them matches or the host is allowed
+func (l *Lists) Check(host, rawURL string) (Match, bool) {
+	host = NormalizeHost(host)
+	if _, allowed := lookupDomain(host, l.AllowHosts); allowed {
+		return Match{}, false
+	}
+	if domain, found := lookupDomain(host, l.BlockHosts); found {
+		return Match{List: l.BlockHosts[domain], Rule: domain}, true
+	}
+	for _, pattern := range l.URLPatterns {
+		if pattern.MatchString(rawURL) {
+			return Match{List: ListURLPatterns, Rule: pattern.String()}, true
+		}
+	}
+	if len(l.BlockedCategories) != 0 {
+		if domain, found := lookupDomain(host, l.Categories); found && l.BlockedCategories[l.Categories[domain]] {
+			return Match{List: ListCategories, Rule: domain, Category: l.Categories[domain]}, true
+		}
+	}
+	return Match{}, false
+}
+
+// lookupDomain returns the host or its closest parent domain which is a key of the map, an IP address is looked
+// up as it is
+func lookupDomain(host string, domains map[string]string) (string, bool) {
+	if len(domains) == 0 || host == "" {
+		return "", false
+	}
+	if net.ParseIP(host) != nil {
+		_, found := domains[host]
+		return host, found
+	}
+	for domain := host; ; {
+		if _, found := domains[domain]; found {
+			return domain, true
+		}
+		dot := strings.IndexByte(domain, '.')
+		if dot == -1 {
+			return "", false
+		}
+		domain = domain[dot+1:]
+	}
+}
+
+// NormalizeHost returns the host in lower case without its port, its brackets and its trailing dot
+func NormalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.Trim(strings.TrimSpace(host), "[]")), ".")
+}
+
+// ParseHosts reads the domains of a hosts file: "0.0.0.0 ads.example.com tracker.example.com" lines like the
+// ones of the public blocklists, or a domain a line. The comments start with # and the names of the local
+// host are left out
+func ParseHosts(r io.Reader) ([]string, error) {
+	var hosts []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if comment := strings.IndexByte(line, '#'); comment != -1 {
+			line = line[:comment]
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 1 && net.ParseIP(fields[0]) != nil {
+			fields = fields[1:]
+		}
+		for _, field := range fields {
+			switch host := NormalizeHost(field); host {
+			case "", "localhost", "localhost.localdomain", "local", "broadcasthost", "ip6-localhost", "ip6-loopback":
+			default:
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts, scanner.Err()
+}
+
+// ParseCategories reads a category feed, a "<domain> <category>" line for every domain, the fields may be
+// separated by a comma too. The comments start with #
+func ParseCategories(r io.Reader) (map[string]string, error) {
+	categories := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(strings.Replace(line, ",", " ", 1))
+		if len(fields) < 2 {
+			return nil, errors.New("line " + strconv.Itoa(n) + " has no category")
+		}
+		categories[NormalizeHost(fields[0])] = strings.ToLower(fields[1])
+	}
+	return categories, scanner.Err()
+}
@@ -0,0 +1,81 @@
+package urlfilter
+
+import (
+	"bufio"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseHosts(t *testing.T) {
+	hosts, err := ParseHosts(strings.NewReader("# ads\n127.0.0.1 localhost\n0.0.0.0 Ads.Example.com tracker.example.net # inline\n" +
+		"\nmalware.example.org.\n::1 ip6-localhost\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"ads.example.com", "tracker.example.net", "malware.example.org"}
+	if strings.Join(hosts, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, hosts)
+	}
+}
+
+func TestParseCategories(t *testing.T) {
+	categories, err := ParseCategories(strings.NewReader("# domain category\ncasino.example.com gambling\n" +
+		"news.example.com,News\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if categories["casino.example.com"] != "gambling" || categories["news.example.com"] != "news" {
+		t.Fatalf("unexpected categories %v", categories)
+	}
+	if _, err = ParseCategories(strings.NewReader("nocategory.example.com\n")); err == nil {
+		t.Fatal("a line without a category should be an error")
+	}
+}
+
+func TestListsCheck(t *testing.T) {
+	lists := NewLists()
+	lists.BlockHosts["example.com"] = "/etc/icapeg/hosts"
+	lists.BlockHosts["10.0.0.1"] = ListBlockHosts
+	lists.AllowHosts["safe.example.com"] = ListAllowHosts
+	lists.URLPatterns = []*regexp.Regexp{regexp.MustCompile(`/wp-login\.php`)}
+	lists.Categories["casino.example.net"] = "gambling"
+	lists.Categories["news.example.net"] = "news"
+	lists.BlockedCategories["gambling"] = true
+
+	tests := []struct {
+		host, url string
+		blocked   bool
+		list      string
+	}{
+		{"example.com", "http://example.com/", true, "/etc/icapeg/hosts"},
+		{"WWW.Example.com:8080", "http://www.example.com:8080/", true, "/etc/icapeg/hosts"},
+		{"safe.example.com", "http://safe.example.com/", false, ""},
+		{"notexample.com", "http://notexample.com/", false, ""},
+		{"10.0.0.1", "http://10.0.0.1/", true, ListBlockHosts},
+		{"blog.example.org", "http://blog.example.org/wp-login.php", true, ListURLPatterns},
+		{"www.casino.example.net", "https://www.casino.example.net/", true, ListCategories},
+		{"news.example.net", "https://news.example.net/", false, ""},
+	}
+	for _, test := range tests {
+		match, blocked := lists.Check(test.host, test.url)
+		if blocked != test.blocked || match.List != test.list {
+			t.Errorf("%s: expected blocked=%v by %q, got blocked=%v by %q", test.url, test.blocked, test.list,
+				blocked, match.List)
+		}
+	}
+}
+
+func TestRequestTarget(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "/path?q=1", nil)
+	req.Host = "www.example.com"
+	if host, url := requestTarget(req); host != "www.example.com" || url != "http://www.example.com/path?q=1" {
+		t.Fatalf("unexpected target %s %s", host, url)
+	}
+	connect, _ := http.ReadRequest(bufio.NewReader(strings.NewReader(
+		"CONNECT casino.example.net:443 HTTP/1.1\r\nHost: casino.example.net:443\r\n\r\n")))
+	if host, url := requestTarget(connect); host != "casino.example.net:443" || url != "casino.example.net:443" {
+		t.Fatalf("unexpected CONNECT target %s %s", host, url)
+	}
+}
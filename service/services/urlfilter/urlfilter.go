@@ -0,0 +1,100 @@
+package urlfilter
+
+import (
+	utils "icapeg/consts"
+	"icapeg/logging"
+	services_utilities "icapeg/service/services-utilities"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+// Processing is a func used for to processing the http message, the host and the URL of the HTTP request are
+// checked against the blocklists of the service and the request is answered with the block page if they match.
+// The body isn't needed, so the request is answered after its preview. The HTTP responses are returned as they are
+func (u *URLFilter) Processing(partial bool, IcapHeader textproto.MIMEHeader) (int, interface{}, map[string]string, map[string]interface{},
+	map[string]interface{}, map[string]interface{}) {
+	serviceHeaders := make(map[string]string)
+	serviceHeaders["X-ICAP-Metadata"] = u.xICAPMetadata
+	logging.Logger.Info(utils.PrepareLogMsg(u.xICAPMetadata, u.serviceName+" service has started processing"))
+	msgHeadersBeforeProcessing := u.generalFunc.LogHTTPMsgHeaders(u.methodName)
+	msgHeadersAfterProcessing := make(map[string]interface{})
+	vendorMsgs := make(map[string]interface{})
+
+	if u.methodName != utils.ICAPModeReq || u.httpMsg.Request == nil {
+		logging.Logger.Info(utils.PrepareLogMsg(u.xICAPMetadata, u.serviceName+" service has stopped processing, "+
+			"it filters the HTTP requests only"))
+		return utils.NoModificationStatusCodeStr, u.httpMsg.Response, serviceHeaders,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+	req := u.httpMsg.Request
+	host, rawURL := requestTarget(req)
+	match, blocked := u.lists.Check(host, rawURL)
+	if !blocked {
+		logging.Logger.Debug(utils.PrepareLogMsg(u.xICAPMetadata, u.serviceName+" allows "+rawURL))
+		logging.Logger.Info(utils.PrepareLogMsg(u.xICAPMetadata, u.serviceName+" service has stopped processing"))
+		return utils.NoModificationStatusCodeStr, req, serviceHeaders,
+			msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+	}
+
+	threat := threatOf(match)
+	vendorMsgs[utils.VendorMsgURLFilter] = map[string]string{"list": match.List, "rule": match.Rule,
+		"category": match.Category}
+	vendorMsgs[utils.VendorMsgVerdict] = utils.SampleSeverityMalicious
+	vendorMsgs[utils.VendorMsgThreat] = threat
+	logging.Logger.Info(utils.PrepareEventLogMsg(u.xICAPMetadata, utils.EventURLBlocked, map[string]interface{}{
+		"service":       u.serviceName,
+		"host":          host,
+		"requested_url": rawURL,
+		"list":          match.List,
+		"rule":          match.Rule,
+		"category":      match.Category,
+	}))
+	u.generalFunc.SetThreatName(threat)
+	services_utilities.AddThreatHeaders(serviceHeaders, services_utilities.Violation{FileName: "-",
+		Threat: threat, Type: services_utilities.ThreatTypePolicy, Resolution: services_utilities.ResolutionBlocked})
+	// the block page is the response of the request, so the CONNECT requests are blocked too
+	htmlPage := u.generalFunc.GenHtmlPage(utils.BlockPagePath, utils.ErrPageReasonURLBlocked, u.serviceName, "-",
+		rawURL, "0", u.xICAPMetadata)
+	response := u.generalFunc.ErrPageResp(http.StatusForbidden, htmlPage.Len())
+	response.Body = io.NopCloser(htmlPage)
+	logging.Logger.Info(utils.PrepareLogMsg(u.xICAPMetadata, u.serviceName+" service has stopped processing"))
+	return utils.OkStatusCodeStr, response, serviceHeaders,
+		msgHeadersBeforeProcessing, msgHeadersAfterProcessing, vendorMsgs
+}
+
+// requestTarget returns the host and the absolute URL of the HTTP request, the authority of a CONNECT request
+// is its URL
+func requestTarget(req *http.Request) (string, string) {
+	host := req.Host
+	if req.URL != nil && req.URL.Host != "" {
+		host = req.URL.Host
+	}
+	if req.Method == http.MethodConnect || req.URL == nil {
+		return host, host
+	}
+	if req.URL.IsAbs() {
+		return host, req.URL.String()
+	}
+	u := *req.URL
+	u.Scheme, u.Host = "http", host
+	return host, u.String()
+}
+
+// threatOf returns the threat name of the match, ex: "URL.category:gambling" or "URL.blocklist:example.com"
+func threatOf(match Match) string {
+	switch match.List {
+	case ListCategories:
+		return "URL.category:" + match.Category
+	case ListURLPatterns:
+		return "URL.pattern"
+	}
+	return "URL.blocklist:" + match.Rule
+}
+
+func (u *URLFilter) ISTagValue() string {
+	epochTime := strconv.FormatInt(time.Now().Unix(), 10)
+	return "epoch-" + epochTime
+}
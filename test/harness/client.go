@@ -0,0 +1,315 @@
+package harness
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the ICAP methods of the requests
+const (
+	MethodOPTIONS = "OPTIONS"
+	MethodREQMOD  = "REQMOD"
+	MethodRESPMOD = "RESPMOD"
+)
+
+// Request is an ICAP request which the client writes as it is, so the tests decide the preview, the chunks and
+// even a wrong encapsulation. The HTTP heads are raw and end with an empty line
+type Request struct {
+	Method       string
+	Service      string
+	Header       http.Header // the ICAP headers, ex: Allow: 204
+	HTTPRequest  string      // the head of the encapsulated HTTP request, "" = none
+	HTTPResponse string      // the head of the encapsulated HTTP response, "" = none
+	Body         []byte      // the encapsulated body, nil = null-body
+	Preview      int         // the bytes of the body which are sent in the preview, -1 = no preview
+	ChunkSize    int         // the size of the chunks which the body is sent in, 0 = one chunk
+	Encapsulated string      // replaces the Encapsulated header which is computed from the parts, "-" = none
+}
+
+// Response is the ICAP response with its encapsulated HTTP message whose body is read entirely
+type Response struct {
+	StatusCode   int
+	Status       string
+	Header       textproto.MIMEHeader
+	Continued    bool // the server asked for the rest of the body after the preview with 100 Continue
+	HTTPRequest  *http.Request
+	HTTPResponse *http.Response
+	Body         []byte
+}
+
+// Client sends the ICAP requests to the server of the harness, every request has its own connection
+type Client struct {
+	Addr    string
+	Timeout time.Duration // the deadline of a whole transaction, 10 seconds by default
+}
+
+// NewREQMOD returns a REQMOD request of the HTTP request whose body is body, nil = without a body
+func NewREQMOD(service, method, url string, body []byte) *Request {
+	head := method + " " + url + " HTTP/1.1\r\nHost: " + hostOf(url) + "\r\n"
+	if body != nil {
+		head += "Content-Length: " + strconv.Itoa(len(body)) + "\r\n"
+	}
+	return &Request{Method: MethodREQMOD, Service: service, Header: http.Header{}, HTTPRequest: head + "\r\n",
+		Body: body, Preview: -1}
+}
+
+// NewRESPMOD returns a RESPMOD request of the 200 response of a GET to url whose body is body
+func NewRESPMOD(service, url, contentType string, body []byte) *Request {
+	return &Request{Method: MethodRESPMOD, Service: service, Header: http.Header{},
+		HTTPRequest: "GET " + url + " HTTP/1.1\r\nHost: " + hostOf(url) + "\r\n\r\n",
+		HTTPResponse: "HTTP/1.1 200 OK\r\nContent-Type: " + contentType + "\r\nContent-Length: " +
+			strconv.Itoa(len(body)) + "\r\n\r\n",
+		Body: body, Preview: -1}
+}
+
+// NewOPTIONS returns an OPTIONS request of the service
+func NewOPTIONS(service string) *Request {
+	return &Request{Method: MethodOPTIONS, Service: service, Header: http.Header{}, Preview: -1}
+}
+
+func hostOf(url string) string {
+	host := url
+	if i := strings.Index(host, "://"); i != -1 {
+		host = host[i+3:]
+	}
+	if i := strings.IndexByte(host, '/'); i != -1 {
+		host = host[:i]
+	}
+	return host
+}
+
+// Do sends the request and returns the final response, the rest of the body is sent if the server answers the
+// preview with 100 Continue
+func (c *Client) Do(req *Request) (*Response, error) {
+	conn, br, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	bw := bufio.NewWriter(conn)
+	if err = writeHead(bw, c.Addr, req); err != nil {
+		return nil, err
+	}
+	rest := req.Body
+	if req.Body != nil {
+		sent := req.Body
+		if req.Preview >= 0 && req.Preview < len(req.Body) {
+			sent, rest = req.Body[:req.Preview], req.Body[req.Preview:]
+		} else {
+			rest = nil
+		}
+		ieof := req.Preview >= 0 && rest == nil
+		if err = writeChunks(bw, sent, req.ChunkSize, ieof); err != nil {
+			return nil, err
+		}
+	}
+	if err = bw.Flush(); err != nil {
+		return nil, err
+	}
+	resp, err := ReadResponse(br)
+	if err != nil || req.Preview < 0 || rest == nil || resp.StatusCode != 100 {
+		return resp, err
+	}
+	if err = writeChunks(bw, rest, req.ChunkSize, false); err != nil {
+		return nil, err
+	}
+	if err = bw.Flush(); err != nil {
+		return nil, err
+	}
+	if resp, err = ReadResponse(br); resp != nil {
+		resp.Continued = true
+	}
+	return resp, err
+}
+
+// SendRaw writes the bytes as they are and returns the response which the server answers them with
+func (c *Client) SendRaw(raw []byte) (*Response, error) {
+	conn, br, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err = conn.Write(raw); err != nil {
+		return nil, err
+	}
+	return ReadResponse(br)
+}
+
+func (c *Client) dial() (net.Conn, *bufio.Reader, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	conn, err := net.DialTimeout("tcp", c.Addr, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	return conn, bufio.NewReader(conn), nil
+}
+
+// DumpRequest returns the bytes which Do writes before the body, ex: to send a request with SendRaw after
+// changing it
+func DumpRequest(addr string, req *Request) []byte {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	writeHead(bw, addr, req)
+	bw.Flush()
+	return buf.Bytes()
+}
+
+func writeHead(w *bufio.Writer, addr string, req *Request) error {
+	fmt.Fprintf(w, "%s icap://%s/%s ICAP/1.0\r\nHost: %s\r\n", req.Method, addr, req.Service, addr)
+	header := req.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if req.Preview >= 0 && req.Body != nil {
+		header.Set("Preview", strconv.Itoa(req.Preview))
+	}
+	encapsulated := req.Encapsulated
+	if encapsulated == "" {
+		encapsulated = encapsulatedOf(req)
+	}
+	if encapsulated != "-" {
+		header.Set("Encapsulated", encapsulated)
+	}
+	if err := header.Write(w); err != nil {
+		return err
+	}
+	w.WriteString("\r\n" + req.HTTPRequest + req.HTTPResponse)
+	return nil
+}
+
+// encapsulatedOf returns the Encapsulated header of the parts of the request
+func encapsulatedOf(req *Request) string {
+	var parts []string
+	offset := 0
+	if req.HTTPRequest != "" {
+		parts = append(parts, "req-hdr=0")
+		offset = len(req.HTTPRequest)
+	}
+	if req.HTTPResponse != "" {
+		parts = append(parts, "res-hdr="+strconv.Itoa(offset))
+		offset += len(req.HTTPResponse)
+	}
+	switch {
+	case req.Body == nil:
+		parts = append(parts, "null-body="+strconv.Itoa(offset))
+	case req.Method == MethodREQMOD:
+		parts = append(parts, "req-body="+strconv.Itoa(offset))
+	default:
+		parts = append(parts, "res-body="+strconv.Itoa(offset))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// writeChunks writes the body in chunks of size bytes followed by the last chunk, which is "0; ieof" if the
+// preview has the whole body
+func writeChunks(w *bufio.Writer, body []byte, size int, ieof bool) error {
+	if size <= 0 {
+		size = len(body)
+	}
+	for len(body) > 0 {
+		n := size
+		if n > len(body) {
+			n = len(body)
+		}
+		fmt.Fprintf(w, "%x\r\n", n)
+		w.Write(body[:n])
+		w.WriteString("\r\n")
+		body = body[n:]
+	}
+	if ieof {
+		_, err := w.WriteString("0; ieof\r\n\r\n")
+		return err
+	}
+	_, err := w.WriteString("0\r\n\r\n")
+	return err
+}
+
+// ReadResponse reads an ICAP response with its encapsulated HTTP message and body
+func ReadResponse(br *bufio.Reader) (*Response, error) {
+	tp := textproto.NewReader(br)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "ICAP/") {
+		return nil, errors.New("malformed ICAP status line: " + line)
+	}
+	resp := &Response{Status: line}
+	if resp.StatusCode, err = strconv.Atoi(fields[1]); err != nil {
+		return nil, errors.New("malformed ICAP status code: " + line)
+	}
+	if resp.Header, err = tp.ReadMIMEHeader(); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == 100 {
+		return resp, nil
+	}
+	return resp, resp.readEncapsulated(br)
+}
+
+type encapsulatedPart struct {
+	name   string
+	offset int
+}
+
+// readEncapsulated reads the parts of the Encapsulated header, the body is dechunked
+func (r *Response) readEncapsulated(br *bufio.Reader) error {
+	value := r.Header.Get("Encapsulated")
+	if value == "" {
+		return nil
+	}
+	var parts []encapsulatedPart
+	for _, part := range strings.Split(value, ",") {
+		name, offset, found := strings.Cut(strings.TrimSpace(part), "=")
+		n, err := strconv.Atoi(offset)
+		if !found || err != nil {
+			return errors.New("malformed Encapsulated header: " + value)
+		}
+		parts = append(parts, encapsulatedPart{name, n})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].offset < parts[j].offset })
+	for i, part := range parts {
+		if strings.HasSuffix(part.name, "-body") {
+			if part.name == "null-body" {
+				return nil
+			}
+			body, err := io.ReadAll(httputil.NewChunkedReader(br))
+			r.Body = body
+			return err
+		}
+		if i+1 == len(parts) {
+			return errors.New("the Encapsulated header has no body part: " + value)
+		}
+		head := make([]byte, parts[i+1].offset-part.offset)
+		if _, err := io.ReadFull(br, head); err != nil {
+			return err
+		}
+		var err error
+		switch part.name {
+		case "req-hdr":
+			r.HTTPRequest, err = http.ReadRequest(bufio.NewReader(bytes.NewReader(head)))
+		case "res-hdr":
+			r.HTTPResponse, err = http.ReadResponse(bufio.NewReader(bytes.NewReader(head)), nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
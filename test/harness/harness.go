@@ -0,0 +1,158 @@
+// Package harness serves the ICAP requests of the integration tests with the whole request path of ICAPeg: the
+// configuration, the services and their vendors, on a loopback listener. The vendors whose APIs are faked are
+// mock vendors (icapeg/mockvendor) served by the harness, and the requests are sent with a Client which writes
+// them as they are, so the tests control the preview, the chunks and the encapsulation.
+//
+// The configuration and the services are global, so a test binary starts one harness in its TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		h, err := harness.Start(harness.Options{Config: configTemplate,
+//			Vendors: map[string]*mockvendor.Script{"hashlookup": nil}})
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		code := m.Run()
+//		h.Close()
+//		os.Exit(code)
+//	}
+package harness
+
+import (
+	"context"
+	"errors"
+	"icapeg/api"
+	"icapeg/config"
+	"icapeg/icap"
+	"icapeg/mockvendor"
+	"icapeg/server"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Options are the configuration of the harness
+type Options struct {
+	// Config is the text/template of config.toml, {{vendor "name"}} is the URL of the mock vendor of the name
+	// and {{.Dir}} the directory which ICAPeg runs in
+	Config string
+	// Vendors are the mock vendors which are served with their scripts, nil = the default script of the
+	// mock vendor (EICAR is malicious)
+	Vendors map[string]*mockvendor.Script
+	// Files are written in the directory before ICAPeg starts, ex: the block page
+	Files map[string][]byte
+}
+
+// Harness is ICAPeg serving the ICAP requests on a loopback address with its mock vendors
+type Harness struct {
+	Addr    string // the address of the ICAP listener
+	Dir     string // the working directory of ICAPeg, its logs and its temporary files are there
+	vendors map[string]*httptest.Server
+	mocks   map[string]*mockvendor.Server
+	srv     *icap.Server
+	wd      string
+}
+
+var started bool
+
+// Start starts the mock vendors, initializes ICAPeg with the configuration in a temporary directory which
+// becomes the working directory, and serves the ICAP requests. It can be called once in a test binary
+func Start(opts Options) (*Harness, error) {
+	if started {
+		return nil, errors.New("the harness was started already, the configuration of ICAPeg is global")
+	}
+	started = true
+	dir, err := os.MkdirTemp("", "icapeg-harness-")
+	if err != nil {
+		return nil, err
+	}
+	h := &Harness{Dir: dir, vendors: make(map[string]*httptest.Server), mocks: make(map[string]*mockvendor.Server)}
+	for name, script := range opts.Vendors {
+		mock := mockvendor.New(script, "")
+		h.mocks[name] = mock
+		h.vendors[name] = httptest.NewServer(mock)
+	}
+	tmpl, err := template.New("config.toml").Funcs(template.FuncMap{
+		"vendor": func(name string) (string, error) {
+			if vendor, exists := h.vendors[name]; exists {
+				return vendor.URL, nil
+			}
+			return "", errors.New("no mock vendor is named " + name)
+		},
+	}).Parse(opts.Config)
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+	var configFile strings.Builder
+	if err = tmpl.Execute(&configFile, struct{ Dir string }{dir}); err != nil {
+		h.Close()
+		return nil, err
+	}
+	files := map[string][]byte{"config.toml": []byte(configFile.String())}
+	for name, content := range opts.Files {
+		files[name] = content
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			err = os.WriteFile(path, content, 0644)
+		}
+		if err != nil {
+			h.Close()
+			return nil, err
+		}
+	}
+	// the logs and the relative paths of the configuration are in the directory
+	if h.wd, err = os.Getwd(); err == nil {
+		err = os.Chdir(dir)
+	}
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+	config.InitTestConfig(filepath.Join(dir, "config.toml"))
+	server.InitComponents()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+	h.Addr = l.Addr().String()
+	mux := icap.NewServeMux()
+	mux.HandleFunc("/", api.ToICAPEGServe)
+	h.srv = &icap.Server{Handler: mux, MaxConcurrency: config.App().MaxConcurrency,
+		WorkerTimeout: config.App().WorkerTimeout}
+	go h.srv.Serve(l)
+	return h, nil
+}
+
+// Client returns a client of the ICAP listener
+func (h *Harness) Client() *Client {
+	return &Client{Addr: h.Addr}
+}
+
+// Vendor returns the mock vendor of the name, its calls are what the services sent
+func (h *Harness) Vendor(name string) *mockvendor.Server {
+	return h.mocks[name]
+}
+
+// Close stops the ICAP server and the mock vendors and removes the directory
+func (h *Harness) Close() {
+	if h.srv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		h.srv.Shutdown(ctx)
+		cancel()
+	}
+	for _, vendor := range h.vendors {
+		vendor.Close()
+	}
+	if h.wd != "" {
+		os.Chdir(h.wd)
+	}
+	os.RemoveAll(h.Dir)
+}
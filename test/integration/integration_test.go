@@ -0,0 +1,831 @@
+package integration
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"icapeg/config"
+	"icapeg/events"
+	"icapeg/mockvendor"
+	"icapeg/service/services-utilities/quotas"
+	"icapeg/test/harness"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eicar is the EICAR test file, which the default script of the mock vendor reports as malicious
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// configTemplate has an echo service which returns the PDF files and bypasses the others, a hash lookup service
+// of the mock vendor, its shadow, a hash lookup service which trickles the files while they're looked up, hash
+// lookup services which bypass and block the files which take longer than their max wait to look up, hash lookup
+// services which fail open and closed when the lookup fails or takes longer than their scan_timeout, services which bypass and block the bodies above 1KB and an echo service which
+// only the ICAP clients with the partner secret may use. The acme tenant scans its files with the service which
+// blocks the large bodies. The uabypass echo service bypasses the
+// downloads of the Updater User-Agent. The services of a vendor share the keys of the vendor,
+// so the services of the same vendor differ by the keys of every service only
+const configTemplate = `
+[app]
+port = 1344
+log_level = "error"
+write_logs_to_console = false
+services = ["echo", "hashlookup", "shadow", "trickled", "waitbypass", "waitblock", "failopen", "failclosed",
+	"bypasslarge", "blocklarge", "partneronly", "uabypass"]
+debugging_headers = false
+client_profile = "generic"
+web_server_host = "localhost:8081"
+web_server_endpoint = "/service/message"
+
+[app.access_control]
+enabled = true
+secret_header = "X-ICAP-Secret"
+
+[app.access_control.loopback]
+ips = ["127.0.0.1", "::1"]
+services = ["echo", "hashlookup", "shadow", "trickled", "waitbypass", "waitblock", "failopen", "failclosed",
+	"bypasslarge", "blocklarge", "uabypass"]
+
+[app.access_control.partner]
+secret = "s3cret"
+services = ["partneronly"]
+
+[app.tenants]
+header = "X-Tenant"
+
+[app.tenants.acme]
+max_concurrent = 0
+queue_timeout = 30
+
+[app.tenants.acme.services]
+scan = "blocklarge"
+
+[app.data_quotas]
+enabled = true
+per = "client"
+action = "block"
+max_bytes = 0
+window = 3600
+
+[app.data_quotas.limits]
+"fd00::1" = 1000000
+"fd00::2" = 1
+
+{{define "service"}}
+service_caption = "integration test service"
+service_tag = "TEST ICAP"
+req_mode = true
+resp_mode = true
+preview_bytes = "1024"
+preview_enabled = true
+reject_extensions = []
+return_original_if_max_file_size_exceeded = false
+scan_partial_if_max_file_size_exceeded = false
+return_400_if_file_ext_rejected = false
+{{end}}
+
+{{define "echo"}}
+vendor = "echo"
+shadow_service = false
+max_filesize = 0
+process_extensions = ["pdf"]
+bypass_extensions = ["*"]
+{{template "service"}}
+{{end}}
+
+[echo]
+{{template "echo"}}
+
+[partneronly]
+{{template "echo"}}
+
+[uabypass]
+{{template "echo"}}
+
+[uabypass.user_agent_policies.updater]
+pattern = "^Updater/"
+action = "bypass"
+
+{{define "hashlookup"}}
+vendor = "clhashlookup"
+scan_url = "{{vendor "hashlookup"}}/lookup/sha256/"
+timeout = 5
+fail_threshold = 2
+max_filesize = 0
+verify_server_cert = false
+bypass_on_api_error = false
+http_exception_response_code = 403
+http_exception_has_body = true
+exception_page = "./exception-page.html"
+process_extensions = ["*"]
+bypass_extensions = []
+{{template "service"}}
+{{end}}
+
+[hashlookup]
+shadow_service = false
+{{template "hashlookup"}}
+
+[shadow]
+shadow_service = true
+{{template "hashlookup"}}
+
+[trickled]
+shadow_service = false
+{{template "hashlookup"}}
+
+[trickled.trickling]
+enabled = true
+min_size = 500
+delay = 0
+interval = 1
+bytes_per_interval = 100
+
+[waitbypass]
+shadow_service = false
+{{template "hashlookup"}}
+
+[waitbypass.max_wait]
+timeout = 1
+action = "bypass"
+
+[waitblock]
+shadow_service = false
+{{template "hashlookup"}}
+
+[waitblock.max_wait]
+timeout = 1
+action = "block"
+
+[failopen]
+shadow_service = false
+scan_timeout = 1
+fail_policy = "open"
+{{template "hashlookup"}}
+
+[failclosed]
+shadow_service = false
+scan_timeout = 1
+fail_policy = "closed"
+{{template "hashlookup"}}
+
+[bypasslarge]
+vendor = "transform"
+shadow_service = false
+max_filesize = 1024
+size_limit_action = "bypass"
+process_extensions = ["*"]
+bypass_extensions = []
+{{template "service"}}
+
+[blocklarge]
+vendor = "transform"
+shadow_service = false
+max_filesize = 1024
+size_limit_action = "block"
+process_extensions = ["*"]
+bypass_extensions = []
+{{template "service"}}
+`
+
+var h *harness.Harness
+
+// the files which the mock vendor takes slowLatency to look up, so the services wait for their verdicts, and the
+// file whose lookup fails with 503
+var (
+	slowClean     = bytes.Repeat([]byte("a clean file which is long to look up "), 30)
+	slowMalicious = bytes.Repeat([]byte("a malicious file which is long to look up "), 30)
+	failing       = []byte("a file whose lookup fails")
+)
+
+const slowLatency = 1500 * time.Millisecond
+
+func TestMain(m *testing.M) {
+	script := mockvendor.DefaultScript()
+	latency := int(slowLatency / time.Millisecond)
+	script.Rules = append(script.Rules,
+		mockvendor.Rule{Name: "slow-clean", SHA256: sha256Of(string(slowClean)), LatencyMs: &latency},
+		mockvendor.Rule{Name: "slow-malicious", SHA256: sha256Of(string(slowMalicious)),
+			Verdict: mockvendor.VerdictMalicious, LatencyMs: &latency},
+		mockvendor.Rule{Name: "failing", SHA256: sha256Of(string(failing)), Status: 503})
+	blockPage, err := os.ReadFile("../../block-page.html")
+	if err == nil {
+		h, err = harness.Start(harness.Options{
+			Config:  configTemplate,
+			Vendors: map[string]*mockvendor.Script{"hashlookup": script},
+			Files: map[string][]byte{
+				"block-page.html":     blockPage,
+				"exception-page.html": []byte("<html>blocked</html>"),
+			},
+		})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "couldn't start the harness:", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	h.Close()
+	os.Exit(code)
+}
+
+func sha256Of(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// lookups returns the hashes which the mock vendor was asked about
+func lookups() []string {
+	var hashes []string
+	for _, call := range h.Vendor("hashlookup").Calls() {
+		hashes = append(hashes, call.SHA256)
+	}
+	return hashes
+}
+
+func TestOptions(t *testing.T) {
+	resp, err := h.Client().Do(harness.NewOPTIONS("echo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || !strings.Contains(resp.Header.Get("Methods"), "RESPMOD") ||
+		resp.Header.Get("Preview") != "1024" || resp.Header.Get("ISTag") == "" {
+		t.Fatalf("unexpected OPTIONS response %s %v", resp.Status, resp.Header)
+	}
+}
+
+func Test204Negotiation(t *testing.T) {
+	small := []byte("a clean text file")
+	large := bytes.Repeat([]byte("clean text "), 500)
+	pdf := append([]byte("%PDF-1.4\n"), large...)
+	tests := []struct {
+		name       string
+		file       string
+		body       []byte
+		allow204   bool
+		preview    int
+		status     int
+		continued  bool
+		sameAsSent bool
+	}{
+		{"bypassed", "file.txt", small, true, -1, 204, false, false},
+		{"bypassed without Allow: 204", "file.txt", small, false, -1, 200, false, true},
+		{"bypassed after the whole preview", "file.txt", small, true, 1024, 204, false, false},
+		{"bypassed after the preview", "file.txt", large, true, 1024, 204, true, false},
+		{"bypassed after the preview without Allow: 204", "file.txt", large, false, 1024, 200, true, true},
+		{"processed", "file.pdf", pdf, true, 1024, 200, true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := harness.NewRESPMOD("echo", "http://example.com/"+test.file, "application/octet-stream",
+				test.body)
+			req.Preview = test.preview
+			req.ChunkSize = 100
+			if test.allow204 {
+				req.Header.Set("Allow", "204")
+			}
+			resp, err := h.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.status || resp.Continued != test.continued {
+				t.Fatalf("expected %d (continued: %t), got %s (continued: %t)", test.status, test.continued,
+					resp.Status, resp.Continued)
+			}
+			if test.sameAsSent && (resp.HTTPResponse == nil || !bytes.Equal(resp.Body, test.body)) {
+				t.Fatalf("the original HTTP response should be returned, got %d bytes", len(resp.Body))
+			}
+		})
+	}
+}
+
+func TestDataQuotaCountsThePreviewOnce(t *testing.T) {
+	pdf := append([]byte("%PDF-1.4\n"), bytes.Repeat([]byte("clean text "), 500)...)
+	req := harness.NewRESPMOD("echo", "http://example.com/file.pdf", "application/pdf", pdf)
+	req.Preview = 1024
+	req.Header.Set("X-Client-IP", "fd00::1")
+	resp, err := h.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || !resp.Continued {
+		t.Fatalf("the PDF file should be processed after 100 Continue, got %s (continued: %t)", resp.Status,
+			resp.Continued)
+	}
+	if _, used, _ := quotas.Current().Exceeded("fd00::1"); used != uint64(len(pdf)) {
+		t.Fatalf("the quota should count the %d bytes of the file once, it counted %d bytes", len(pdf), used)
+	}
+}
+
+func TestUserAgentBypassDoesntSkipTheQuota(t *testing.T) {
+	quotas.Current().Add("fd00::2", 10)
+	tests := []struct {
+		name   string
+		client string
+		status int
+	}{
+		{"bypassed before the body is read", "fd00::3", 204},
+		{"blocked after the quota was exceeded", "fd00::2", 200},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := harness.NewRESPMOD("uabypass", "http://example.com/update.pdf", "application/pdf",
+				[]byte("%PDF-1.4\nan update"))
+			req.HTTPRequest = "GET http://example.com/update.pdf HTTP/1.1\r\nHost: example.com\r\n" +
+				"User-Agent: Updater/1.0\r\n\r\n"
+			req.Header.Set("Allow", "204")
+			req.Header.Set("X-Client-IP", test.client)
+			resp, err := h.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.status {
+				t.Fatalf("expected %d, got %s", test.status, resp.Status)
+			}
+			if test.status == 200 && (resp.HTTPResponse == nil || resp.HTTPResponse.StatusCode != 403) {
+				t.Fatalf("the HTTP response should be blocked by the quota, got %v", resp.HTTPResponse)
+			}
+		})
+	}
+}
+
+func TestHashLookupBlocksTheMaliciousFiles(t *testing.T) {
+	req := harness.NewRESPMOD("hashlookup", "http://example.com/eicar.com", "application/octet-stream",
+		[]byte(eicar))
+	req.Header.Set("Allow", "204")
+	resp, err := h.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || resp.HTTPResponse == nil || resp.HTTPResponse.StatusCode != 403 {
+		t.Fatalf("EICAR should be blocked with a 403 HTTP response, got %s %+v", resp.Status, resp.HTTPResponse)
+	}
+	if !strings.Contains(strings.Join(lookups(), ","), sha256Of(eicar)) {
+		t.Fatalf("the mock vendor wasn't asked about EICAR, it got %v", lookups())
+	}
+}
+
+func TestShadowServiceAnswersBeforeTheVendor(t *testing.T) {
+	const file = "a file which only the shadow service scans"
+	req := harness.NewRESPMOD("shadow", "http://example.com/shadow.txt", "text/plain", []byte(eicar+file))
+	req.Header.Set("Allow", "204")
+	resp, err := h.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 204 {
+		t.Fatalf("a shadow service should answer with 204 whatever the verdict is, got %s", resp.Status)
+	}
+	// the vendor is still called in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(strings.Join(lookups(), ","), sha256Of(eicar+file)) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the shadow service didn't call the mock vendor, it got %v", lookups())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestOversizedBodies(t *testing.T) {
+	large := bytes.Repeat([]byte("x"), 4096)
+	tests := []struct {
+		name       string
+		service    string
+		preview    int
+		chunked    bool // without a Content-Length, so the body is counted while it's read
+		status     int
+		httpStatus int
+	}{
+		{"bypassed", "bypasslarge", -1, false, 204, 0},
+		{"bypassed after the preview", "bypasslarge", 1024, false, 204, 0},
+		{"bypassed without a Content-Length", "bypasslarge", -1, true, 204, 0},
+		{"blocked", "blocklarge", -1, false, 200, 403},
+		{"blocked without a Content-Length", "blocklarge", 1024, true, 200, 403},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := harness.NewRESPMOD(test.service, "http://example.com/large.bin", "application/octet-stream",
+				large)
+			req.Preview = test.preview
+			req.Header.Set("Allow", "204")
+			if test.chunked {
+				req.HTTPResponse = "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\n" +
+					"Transfer-Encoding: chunked\r\n\r\n"
+			}
+			resp, err := h.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.status {
+				t.Fatalf("expected %d, got %s", test.status, resp.Status)
+			}
+			if test.httpStatus != 0 && (resp.HTTPResponse == nil || resp.HTTPResponse.StatusCode != test.httpStatus) {
+				t.Fatalf("expected a %d HTTP response, got %+v", test.httpStatus, resp.HTTPResponse)
+			}
+		})
+	}
+}
+
+func TestMalformedEncapsulation(t *testing.T) {
+	respmod := func(change func(req *harness.Request)) *harness.Request {
+		req := harness.NewRESPMOD("echo", "http://example.com/file.txt", "text/plain", []byte("hello"))
+		change(req)
+		return req
+	}
+	tests := []struct {
+		name   string
+		req    *harness.Request
+		status int
+	}{
+		{"RESPMOD without the HTTP response", respmod(func(req *harness.Request) {
+			req.HTTPResponse = ""
+		}), 400},
+		{"REQMOD without the HTTP request", &harness.Request{Method: harness.MethodREQMOD, Service: "echo",
+			Body: []byte("hello"), Preview: -1}, 400},
+		{"without the Encapsulated header", respmod(func(req *harness.Request) {
+			req.Encapsulated = "-"
+		}), 400},
+		{"invalid offsets", respmod(func(req *harness.Request) {
+			req.Encapsulated = "req-hdr=0, res-hdr=abc, res-body=10"
+		}), 400},
+		{"decreasing offsets", respmod(func(req *harness.Request) {
+			req.Encapsulated = "req-hdr=50, res-hdr=0, res-body=100"
+		}), 400},
+		{"unknown service", respmod(func(req *harness.Request) {
+			req.Service = "nosuchservice"
+		}), 404},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := h.Client().Do(test.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.status {
+				t.Fatalf("expected %d, got %s", test.status, resp.Status)
+			}
+		})
+	}
+
+	// a chunk size which isn't hexadecimal
+	raw := append(harness.DumpRequest(h.Addr, respmod(func(*harness.Request) {})), "zz\r\nhello\r\n0\r\n\r\n"...)
+	resp, err := h.Client().SendRaw(raw)
+	if err == nil && resp.StatusCode < 400 {
+		t.Fatalf("a malformed chunk should be rejected, got %s", resp.Status)
+	}
+}
+
+func TestAccessControl(t *testing.T) {
+	var mu sync.Mutex
+	var denied []string
+	events.Subscribe("access_denied", []string{events.TypeBlocked}, events.SubscriberFunc(func(event *events.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if event.Blocked.Reason == "access_denied" {
+			denied = append(denied, event.Blocked.ServiceName)
+		}
+	}), 0)
+	tests := []struct {
+		name    string
+		service string
+		secret  string
+		status  int
+	}{
+		{"an allowed address", "echo", "", 204},
+		{"the secret of the service", "partneronly", "s3cret", 204},
+		{"without the secret", "partneronly", "", 403},
+		{"a wrong secret", "partneronly", "wrong", 403},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := harness.NewRESPMOD(test.service, "http://example.com/file.txt", "text/plain", []byte("hello"))
+			req.Header.Set("Allow", "204")
+			if test.secret != "" {
+				req.Header.Set("X-ICAP-Secret", test.secret)
+			}
+			resp, err := h.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.status {
+				t.Fatalf("expected %d, got %s", test.status, resp.Status)
+			}
+		})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(denied) != 2 || denied[0] != "partneronly" {
+		t.Fatalf("the denied requests should be blocked events, got %v", denied)
+	}
+}
+
+func TestTenantResolution(t *testing.T) {
+	large := bytes.Repeat([]byte("x"), 4096)
+	tests := []struct {
+		name       string
+		service    string
+		tenant     string // the tenant header
+		status     int
+		httpStatus int
+	}{
+		{"URL prefix", "acme/scan", "", 200, 403},
+		{"tenant header", "scan", "acme", 200, 403},
+		{"tenant header in upper case", "scan", "ACME", 200, 403},
+		{"URL prefix before the header", "acme/scan", "other", 200, 403},
+		{"without a tenant", "echo", "", 204, 0},
+		{"without a tenant, a service of a tenant", "scan", "", 404, 0},
+		{"unknown tenant", "other/scan", "", 404, 0},
+		{"unknown tenant header", "scan", "other", 404, 0},
+		{"service which isn't the tenant's", "acme/echo", "", 404, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := harness.NewRESPMOD(test.service, "http://example.com/large.bin", "application/octet-stream",
+				large)
+			req.Header.Set("Allow", "204")
+			if test.tenant != "" {
+				req.Header.Set("X-Tenant", test.tenant)
+			}
+			resp, err := h.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.status {
+				t.Fatalf("expected %d, got %s", test.status, resp.Status)
+			}
+			if test.httpStatus != 0 && (resp.HTTPResponse == nil || resp.HTTPResponse.StatusCode != test.httpStatus) {
+				t.Fatalf("expected a %d HTTP response, got %+v", test.httpStatus, resp.HTTPResponse)
+			}
+		})
+	}
+}
+
+// trickledRead is the number of bytes of the body which were read when they were read
+type trickledRead struct {
+	at    time.Duration
+	total int
+}
+
+// readTrickled sends the RESPMOD request of the body to the trickled service and reads the body of the response
+// as it arrives, it returns the reads, when the body ended and the error which ended it
+func readTrickled(t *testing.T, body []byte) ([]trickledRead, time.Duration, error) {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", h.Addr, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	req := harness.NewRESPMOD("trickled", "http://example.com/large.bin", "application/octet-stream", body)
+	raw := append(harness.DumpRequest(h.Addr, req), fmt.Sprintf("%x\r\n%s\r\n0\r\n\r\n", len(body), body)...)
+	start := time.Now()
+	if _, err = conn.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	tp := textproto.NewReader(br)
+	if line, err := tp.ReadLine(); err != nil || line != "ICAP/1.0 200 OK" {
+		t.Fatalf("the trickled response should be started with 200, got %q (%v)", line, err)
+	}
+	if _, err = tp.ReadMIMEHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = http.ReadResponse(br, nil); err != nil {
+		t.Fatal(err)
+	}
+	var reads []trickledRead
+	chunked := httputil.NewChunkedReader(br)
+	buf := make([]byte, len(body))
+	total := 0
+	for {
+		n, err := chunked.Read(buf)
+		if n > 0 {
+			total += n
+			reads = append(reads, trickledRead{at: time.Since(start), total: total})
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return reads, time.Since(start), err
+		}
+	}
+}
+
+func TestTrickling(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     []byte
+		complete bool
+	}{
+		{"clean file", slowClean, true},
+		{"malicious file", slowMalicious, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reads, ended, err := readTrickled(t, test.body)
+			if len(reads) == 0 {
+				t.Fatalf("nothing was trickled (%v)", err)
+			}
+			// the first 100 bytes are sent at once and the next 100 bytes an interval later, before the verdict
+			first, second := reads[0], trickledRead{}
+			for _, read := range reads {
+				if read.total > first.total {
+					second = read
+					break
+				}
+			}
+			if first.total != 100 || first.at >= slowLatency {
+				t.Fatalf("the first 100 bytes should be sent before the verdict, got %d bytes after %v",
+					first.total, first.at)
+			}
+			if second.total != 200 || second.at-first.at < 900*time.Millisecond || second.at >= slowLatency {
+				t.Fatalf("the next 100 bytes should be sent an interval later, got %d bytes after %v", second.total,
+					second.at)
+			}
+			last := reads[len(reads)-1]
+			if test.complete && (err != nil || last.total != len(test.body)) {
+				t.Fatalf("the clean file should be completed, got %d of %d bytes (%v)", last.total,
+					len(test.body), err)
+			}
+			if !test.complete && (err == nil || last.total >= len(test.body)) {
+				t.Fatalf("the malicious file should be aborted, got %d of %d bytes", last.total, len(test.body))
+			}
+			if ended < slowLatency {
+				t.Fatalf("the response should wait for the verdict, it ended after %v", ended)
+			}
+		})
+	}
+}
+
+func TestMaxWait(t *testing.T) {
+	tests := []struct {
+		name       string
+		service    string
+		body       []byte
+		allow204   bool
+		status     int
+		httpStatus int // the status of the returned HTTP response, 0 = the original one
+		timedOut   bool
+	}{
+		{"bypassed", "waitbypass", slowMalicious, true, 204, 0, true},
+		{"bypassed without Allow: 204", "waitbypass", slowMalicious, false, 200, 0, true},
+		{"blocked", "waitblock", slowClean, true, 200, 403, true},
+		{"verdict in time", "waitbypass", []byte(eicar), true, 200, 403, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := harness.NewRESPMOD(test.service, "http://example.com/large.bin", "application/octet-stream",
+				test.body)
+			if test.allow204 {
+				req.Header.Set("Allow", "204")
+			}
+			start := time.Now()
+			resp, err := h.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); test.timedOut && (elapsed < time.Second || elapsed >= slowLatency) {
+				t.Fatalf("the fallback should be returned after the max wait of 1s, it took %v", elapsed)
+			}
+			if resp.StatusCode != test.status {
+				t.Fatalf("expected %d, got %s", test.status, resp.Status)
+			}
+			switch {
+			case test.httpStatus != 0:
+				if resp.HTTPResponse == nil || resp.HTTPResponse.StatusCode != test.httpStatus {
+					t.Fatalf("expected a %d HTTP response, got %+v", test.httpStatus, resp.HTTPResponse)
+				}
+			case test.status == 200:
+				if resp.HTTPResponse == nil || !bytes.Equal(resp.Body, test.body) {
+					t.Fatalf("the original HTTP response should be returned, got %d bytes", len(resp.Body))
+				}
+			}
+		})
+	}
+}
+
+func TestFailPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		service    string
+		body       []byte
+		allow204   bool
+		status     int
+		httpStatus int // the status of the returned HTTP response, 0 = the original one
+	}{
+		{"open on a vendor error", "failopen", failing, true, 204, 0},
+		{"open on a vendor error without Allow: 204", "failopen", failing, false, 200, 0},
+		{"open on a scan timeout", "failopen", slowMalicious, true, 204, 0},
+		{"closed on a vendor error", "failclosed", failing, true, 500, 0},
+		{"closed on a scan timeout", "failclosed", slowClean, true, 500, 0},
+		{"verdict of the vendor", "failclosed", []byte(eicar), true, 200, 403},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := harness.NewRESPMOD(test.service, "http://example.com/file.bin", "application/octet-stream",
+				test.body)
+			if test.allow204 {
+				req.Header.Set("Allow", "204")
+			}
+			resp, err := h.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.status {
+				t.Fatalf("expected %d, got %s", test.status, resp.Status)
+			}
+			switch {
+			case test.httpStatus != 0:
+				if resp.HTTPResponse == nil || resp.HTTPResponse.StatusCode != test.httpStatus {
+					t.Fatalf("expected a %d HTTP response, got %+v", test.httpStatus, resp.HTTPResponse)
+				}
+			case test.status == 200:
+				if resp.HTTPResponse == nil || !bytes.Equal(resp.Body, test.body) {
+					t.Fatalf("the original HTTP response should be returned, got %d bytes", len(resp.Body))
+				}
+			}
+		})
+	}
+}
+
+// setClientProfile reloads the configuration with the client profile, the generic profile is reloaded when the
+// test ends
+func setClientProfile(t *testing.T, profile string) {
+	t.Helper()
+	file := filepath.Join(h.Dir, "config.toml")
+	original, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := strings.Replace(string(original), `client_profile = "generic"`, `client_profile = "`+profile+`"`, 1)
+	if err = os.WriteFile(file, []byte(changed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.WriteFile(file, original, 0644)
+		if err := config.Reload(nil); err != nil {
+			t.Errorf("couldn't restore the configuration: %v", err)
+		}
+	})
+	if err = config.Reload(nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientProfiles(t *testing.T) {
+	tests := []struct {
+		profile string
+		headers map[string]string // the headers of the OPTIONS response, "" = without the header
+	}{
+		{"generic", map[string]string{"Allow": "204", "Options-TTL": "", "Service-ID": "", "X-Include": ""}},
+		{"squid", map[string]string{"Allow": "204", "Options-TTL": "3600", "Service-ID": "", "X-Include": ""}},
+		{"proxysg", map[string]string{"Allow": "204", "Options-TTL": "3600", "Service-ID": "echo",
+			"X-Include": "X-Client-IP, X-Authenticated-User, X-Authenticated-Groups"}},
+	}
+	for _, test := range tests {
+		t.Run(test.profile, func(t *testing.T) {
+			setClientProfile(t, test.profile)
+			resp, err := h.Client().Do(harness.NewOPTIONS("echo"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != 200 {
+				t.Fatalf("expected 200, got %s", resp.Status)
+			}
+			for name, want := range test.headers {
+				if got := resp.Header.Get(name); got != want {
+					t.Errorf("the %s header should be %q, got %q", name, want, got)
+				}
+			}
+			// squid rejects the ISTags which aren't quoted strings
+			if istag := resp.Header.Get("ISTag"); test.profile == "squid" && !strings.HasPrefix(istag, `"`) {
+				t.Errorf("the ISTag should be quoted, got %s", istag)
+			}
+		})
+	}
+
+	// squid sends Allow: trailers on its own line, before or after Allow: 204
+	setClientProfile(t, "squid")
+	for _, allow := range [][]string{{"204", "trailers"}, {"trailers", "204"}} {
+		req := harness.NewRESPMOD("echo", "http://example.com/file.txt", "text/plain", []byte("hello"))
+		req.Header["Allow"] = allow
+		resp, err := h.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 204 {
+			t.Fatalf("the request with the Allow lines %v should be answered with 204, got %s", allow, resp.Status)
+		}
+	}
+}
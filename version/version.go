@@ -0,0 +1,63 @@
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// the build info which is embedded at compile time:
+//
+//	go build -ldflags "-X icapeg/version.Version=v1.2.0 -X icapeg/version.Commit=$(git rev-parse --short HEAD)
+//	-X icapeg/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// the commit and the build date of the VCS stamp of the go toolchain are used if they aren't embedded
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// BuildInfo represents the build of the running ICAPeg
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Info returns the build of the running ICAPeg
+func Info() BuildInfo {
+	info := BuildInfo{Version: Version, Commit: Commit, BuildDate: BuildDate, GoVersion: runtime.Version()}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+				if len(info.Commit) > 12 {
+					info.Commit = info.Commit[:12]
+				}
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
+
+// ServerHeader returns the value of the header which tells the ICAP clients the build of ICAPeg
+func ServerHeader() string {
+	info := Info()
+	return "ICAPeg/" + info.Version + " (" + info.Commit + ")"
+}
+
+// String returns the build in one line
+func String() string {
+	info := Info()
+	return "ICAPeg " + info.Version + ", commit " + info.Commit + ", built " + info.BuildDate + " with " + info.GoVersion
+}